@@ -0,0 +1,56 @@
+package jwtauth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hashicorp/errwrap"
+	"golang.org/x/oauth2"
+)
+
+// userInfoClaims validates an OIDC access token by fetching the claims of its
+// user from the provider's userinfo endpoint, for roles with
+// accept_access_tokens. The token itself is only sent to the provider.
+func (b *jwtAuthBackend) userInfoClaims(ctx context.Context, config *jwtConfig, role *jwtRole, token string) (map[string]interface{}, error) {
+	if config.OIDCDiscoveryURL == "" {
+		return nil, errors.New("'accept_access_tokens' requires 'oidc_discovery_url' to be configured")
+	}
+	if len(config.boundAudiences(role)) > 0 {
+		return nil, errors.New("audiences can't be validated for access tokens")
+	}
+
+	if err := b.providerBreaker.allow(config); err != nil {
+		return nil, err
+	}
+
+	provider, err := b.getProvider(config)
+	if err != nil {
+		return nil, errwrap.Wrapf("error getting provider for UserInfo request: {{err}}", err)
+	}
+
+	oidcCtx, err := b.createCAContext(ctx, config, config.OIDCDiscoveryCAPEM)
+	if err != nil {
+		return nil, errwrap.Wrapf("error preparing context for UserInfo request: {{err}}", err)
+	}
+
+	userinfo, err := provider.UserInfo(oidcCtx, oauth2.StaticTokenSource(&oauth2.Token{
+		AccessToken: token,
+		TokenType:   "Bearer",
+	}))
+	if err != nil {
+		return nil, errwrap.Wrapf("error fetching userinfo: {{err}}", err)
+	}
+
+	allClaims := make(map[string]interface{})
+	if err := userinfo.Claims(&allClaims); err != nil {
+		return nil, errwrap.Wrapf("error decoding userinfo: {{err}}", err)
+	}
+
+	if role.BoundSubject != "" {
+		if sub, _ := allClaims["sub"].(string); sub != role.BoundSubject {
+			return nil, errors.New("sub does not match the role's bound_subject")
+		}
+	}
+
+	return allClaims, nil
+}
@@ -0,0 +1,153 @@
+package jwtauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestLogin_AcceptAccessTokens(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	s.userInfoToken = "opaque-access-token"
+	s.userInfoClaims = map[string]interface{}{
+		"sub":   "user1",
+		"email": "bob@example.com",
+		"color": "green",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/access",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_type":            "jwt",
+			"accept_access_tokens": true,
+			"user_claim":           "email",
+			"bound_subject":        "user1",
+			"bound_claims":         map[string]interface{}{"color": "green"},
+			"claim_mappings":       map[string]string{"color": "color"},
+			"token_policies":       "dev",
+		},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+
+	login := func(token string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role": "access",
+				"jwt":  token,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp = login("opaque-access-token")
+	if resp.IsError() {
+		t.Fatalf("unexpected error: %v", resp.Error())
+	}
+	if resp.Auth.Alias.Name != "bob@example.com" || resp.Auth.Alias.Metadata["color"] != "green" {
+		t.Fatalf("unexpected alias: %#v", resp.Auth.Alias)
+	}
+	if len(resp.Auth.Policies) != 1 || resp.Auth.Policies[0] != "dev" {
+		t.Fatalf("unexpected policies: %v", resp.Auth.Policies)
+	}
+	for k, v := range resp.Auth.Metadata {
+		if strings.Contains(v, "opaque-access-token") {
+			t.Fatalf("access token found in metadata %q", k)
+		}
+	}
+
+	// The provider rejects unknown tokens with a 401.
+	if resp := login("invalid-token"); !resp.IsError() || !strings.Contains(resp.Error().Error(), "401") {
+		t.Fatalf("expected error for an invalid access token, got: %#v", resp)
+	}
+
+	// Bound claims are checked against the userinfo response.
+	s.userInfoClaims["color"] = "red"
+	if resp := login("opaque-access-token"); !resp.IsError() || !strings.Contains(resp.Error().Error(), "color") {
+		t.Fatalf("expected bound claims error, got: %#v", resp)
+	}
+
+	s.userInfoClaims["color"] = "green"
+	s.userInfoClaims["sub"] = "user2"
+	if resp := login("opaque-access-token"); !resp.IsError() || !strings.Contains(resp.Error().Error(), "bound_subject") {
+		t.Fatalf("expected bound_subject error, got: %#v", resp)
+	}
+}
+
+func TestPath_AcceptAccessTokens_RoleType(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/access",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_type":             "oidc",
+			"accept_access_tokens":  true,
+			"user_claim":            "email",
+			"allowed_redirect_uris": "https://example.com",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() {
+		t.Fatal("expected error for accept_access_tokens with an oidc role")
+	}
+}
+
+func TestPath_AcceptAccessTokens_Bounds(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	for _, tt := range []struct {
+		data   map[string]interface{}
+		errMsg string
+	}{
+		{map[string]interface{}{}, "requires 'bound_subject' or 'bound_claims'"},
+		{map[string]interface{}{"bound_cidrs": "127.0.0.1/32"}, "requires 'bound_subject' or 'bound_claims'"},
+		{map[string]interface{}{"bound_audiences": "vault"}, "requires 'bound_subject' or 'bound_claims'"},
+		{map[string]interface{}{"bound_subject": "user1", "bound_audiences": "vault"}, "'bound_audiences' can't be validated"},
+		{map[string]interface{}{"bound_subject": "user1"}, ""},
+		{map[string]interface{}{"bound_claims": map[string]interface{}{"color": "green"}}, ""},
+	} {
+		data := map[string]interface{}{
+			"role_type":            "jwt",
+			"accept_access_tokens": true,
+			"user_claim":           "email",
+		}
+		for k, v := range tt.data {
+			data[k] = v
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/access",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.errMsg == "" && resp != nil && resp.IsError() {
+			t.Fatalf("%v: unexpected error: %v", tt.data, resp.Error())
+		}
+		if tt.errMsg != "" && (resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), tt.errMsg)) {
+			t.Fatalf("%v: expected error %q, got: %#v", tt.data, tt.errMsg, resp)
+		}
+	}
+}
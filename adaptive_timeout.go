@@ -0,0 +1,171 @@
+package jwtauth
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/hashicorp/go-hclog"
+)
+
+const (
+	// adaptiveTimeoutWindow is the period over which provider response
+	// times are tracked for oidc_adaptive_timeout.
+	adaptiveTimeoutWindow = 5 * time.Minute
+
+	// adaptiveTimeoutMinSamples is the number of responses needed in the
+	// window before the timeout is adapted, so that a single slow response
+	// doesn't increase it.
+	adaptiveTimeoutMinSamples = 10
+
+	// adaptiveTimeoutMaxSamples bounds the memory used by the window.
+	adaptiveTimeoutMaxSamples = 1000
+)
+
+// adaptiveTimeout tracks the response times of provider requests, and
+// increases the timeout of oidc_http_timeout by 50% while the P99 response
+// time in the window is above 80% of it. The timeout is restored once the P99
+// drops below 50% of oidc_http_timeout.
+type adaptiveTimeout struct {
+	l         sync.Mutex
+	logger    log.Logger
+	samples   []adaptiveTimeoutSample
+	increased bool
+}
+
+type adaptiveTimeoutSample struct {
+	at       time.Time
+	duration time.Duration
+}
+
+func newAdaptiveTimeout() *adaptiveTimeout {
+	return &adaptiveTimeout{
+		logger: log.NewNullLogger(),
+	}
+}
+
+func (a *adaptiveTimeout) setLogger(logger log.Logger) {
+	a.l.Lock()
+	a.logger = logger
+	a.l.Unlock()
+}
+
+// timeout returns the timeout to use for provider requests, given the
+// configured oidc_http_timeout.
+func (a *adaptiveTimeout) timeout(base time.Duration) time.Duration {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if a.increased {
+		return base * 3 / 2
+	}
+	return base
+}
+
+// p99 returns the P99 of the response times in the window, or zero if no
+// response was recorded.
+func (a *adaptiveTimeout) p99(now time.Time) time.Duration {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	a.prune(now)
+	return a.percentile(99)
+}
+
+// record adds a provider response time to the window, and increases or
+// restores the timeout if its P99 crossed a threshold of base.
+func (a *adaptiveTimeout) record(now time.Time, duration, base time.Duration) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	a.prune(now)
+	a.samples = append(a.samples, adaptiveTimeoutSample{at: now, duration: duration})
+	if len(a.samples) > adaptiveTimeoutMaxSamples {
+		a.samples = a.samples[len(a.samples)-adaptiveTimeoutMaxSamples:]
+	}
+
+	if len(a.samples) < adaptiveTimeoutMinSamples {
+		return
+	}
+
+	p99 := a.percentile(99)
+	switch {
+	case !a.increased && p99 > base*8/10:
+		a.increased = true
+		a.logger.Warn("OIDC provider responses are slow, increasing the provider timeout", "p99", p99, "timeout", base*3/2)
+	case a.increased && p99 < base/2:
+		a.increased = false
+		a.logger.Info("OIDC provider responses recovered, restoring the provider timeout", "p99", p99, "timeout", base)
+	}
+}
+
+// reset discards the recorded response times and restores the timeout.
+func (a *adaptiveTimeout) reset() {
+	a.l.Lock()
+	a.samples = nil
+	a.increased = false
+	a.l.Unlock()
+}
+
+func (a *adaptiveTimeout) prune(now time.Time) {
+	i := 0
+	for i < len(a.samples) && now.Sub(a.samples[i].at) > adaptiveTimeoutWindow {
+		i++
+	}
+	a.samples = a.samples[i:]
+}
+
+func (a *adaptiveTimeout) percentile(p int) time.Duration {
+	if len(a.samples) == 0 {
+		return 0
+	}
+
+	durations := make([]time.Duration, len(a.samples))
+	for i, s := range a.samples {
+		durations[i] = s.duration
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	idx := (len(durations)*p+99)/100 - 1
+	return durations[idx]
+}
+
+// adaptiveTransport applies the adaptive timeout to each request, and
+// records how long the provider took to respond. Failed requests are
+// recorded too, since timeouts are the slowest responses.
+type adaptiveTransport struct {
+	transport http.RoundTripper
+	tracker   *adaptiveTimeout
+	base      time.Duration
+}
+
+func (t *adaptiveTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.tracker.timeout(t.base))
+
+	start := time.Now()
+	resp, err := t.transport.RoundTrip(req.WithContext(ctx))
+	t.tracker.record(time.Now(), time.Since(start), t.base)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// The timeout covers reading the body, so the context is only canceled
+	// once it is closed.
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
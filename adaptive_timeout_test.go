@@ -0,0 +1,164 @@
+package jwtauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+)
+
+func TestAdaptiveTimeout(t *testing.T) {
+	a := newAdaptiveTimeout()
+	base := time.Second
+	now := time.Now()
+
+	// too few samples to adapt
+	for i := 0; i < adaptiveTimeoutMinSamples-1; i++ {
+		a.record(now, 900*time.Millisecond, base)
+	}
+	if got := a.timeout(base); got != base {
+		t.Fatalf("expected timeout %v, got %v", base, got)
+	}
+
+	// P99 above 80% of the timeout
+	a.record(now, 900*time.Millisecond, base)
+	if got := a.timeout(base); got != 1500*time.Millisecond {
+		t.Fatalf("expected increased timeout, got %v", got)
+	}
+	if got := a.p99(now); got != 900*time.Millisecond {
+		t.Fatalf("unexpected p99: %v", got)
+	}
+
+	// P99 between 50% and 80% keeps the increased timeout
+	later := now.Add(adaptiveTimeoutWindow + time.Second)
+	for i := 0; i < adaptiveTimeoutMinSamples; i++ {
+		a.record(later, 600*time.Millisecond, base)
+	}
+	if got := a.timeout(base); got != 1500*time.Millisecond {
+		t.Fatalf("expected increased timeout, got %v", got)
+	}
+
+	// P99 below 50% restores it
+	later = later.Add(adaptiveTimeoutWindow + time.Second)
+	for i := 0; i < adaptiveTimeoutMinSamples; i++ {
+		a.record(later, 100*time.Millisecond, base)
+	}
+	if got := a.timeout(base); got != base {
+		t.Fatalf("expected restored timeout, got %v", got)
+	}
+
+	// a single slow response among fast ones is below the P99
+	for i := 0; i < 200; i++ {
+		a.record(later, 100*time.Millisecond, base)
+	}
+	a.record(later, 2*time.Second, base)
+	if got := a.timeout(base); got != base {
+		t.Fatalf("expected timeout %v, got %v", base, got)
+	}
+}
+
+func TestAdaptiveTimeout_Transport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(250 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	b, _ := getBackend(t)
+	get := func(config *jwtConfig) error {
+		t.Helper()
+		ctx, err := b.(*jwtAuthBackend).createCAContext(context.Background(), config, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := ctx.Value(oauth2.HTTPClient).(*http.Client).Get(server.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	config := &jwtConfig{
+		OIDCHTTPTimeout: 200 * time.Millisecond,
+		adaptiveTimeout: newAdaptiveTimeout(),
+	}
+	if err := get(config); err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if p99 := config.adaptiveTimeout.p99(time.Now()); p99 < 190*time.Millisecond {
+		t.Fatalf("expected the timed out request to be recorded, got p99 %v", p99)
+	}
+
+	// once the provider is known to be slow the timeout is 300ms
+	for i := 0; i < adaptiveTimeoutMinSamples; i++ {
+		config.adaptiveTimeout.record(time.Now(), 190*time.Millisecond, config.OIDCHTTPTimeout)
+	}
+	if err := get(config); err != nil {
+		t.Fatalf("expected request within the increased timeout to succeed: %v", err)
+	}
+}
+
+func TestAdaptiveTimeout_ProviderHealth(t *testing.T) {
+	b, storage := getBackend(t)
+
+	s := newOIDCProvider(t)
+	defer s.server.Close()
+
+	cert, err := s.getTLSCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"oidc_discovery_url":                  s.server.URL,
+			"oidc_discovery_ca_pem":               cert,
+			"oidc_provider_health_check_interval": "5m",
+			"oidc_http_timeout":                   "10s",
+			"oidc_adaptive_timeout":               true,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	jb := b.(*jwtAuthBackend)
+	if err := jb.periodicFunc(context.Background(), &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+
+	readHealth := func() *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "oidc/provider-health",
+			Storage:   storage,
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("err:%v resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+
+	resp = readHealth()
+	if resp.Data["adaptive_timeout"] != 10.0 {
+		t.Fatalf("unexpected adaptive timeout: %v", resp.Data)
+	}
+	if p99, _ := resp.Data["provider_response_p99"].(float64); p99 <= 0 {
+		t.Fatalf("expected the health check request to be recorded: %v", resp.Data)
+	}
+
+	for i := 0; i < adaptiveTimeoutMinSamples; i++ {
+		jb.adaptiveTimeout.record(time.Now(), 9*time.Second, 10*time.Second)
+	}
+	if resp := readHealth(); resp.Data["adaptive_timeout"] != 15.0 {
+		t.Fatalf("expected increased adaptive timeout: %v", resp.Data)
+	}
+}
@@ -0,0 +1,130 @@
+package jwtauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	joseed25519 "golang.org/x/crypto/ed25519"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// algNone is the algorithm of unsigned tokens. It can be listed in a role's
+// algorithm_restrictions to make the rejection of unsigned tokens explicit,
+// but they are rejected whether or not it is listed.
+const algNone = "none"
+
+// supportedSigningAlgs are the JWS algorithms accepted in jwt_supported_algs
+// and algorithm_restrictions.
+var supportedSigningAlgs = []string{
+	string(jose.RS256), string(jose.RS384), string(jose.RS512),
+	string(jose.ES256), string(jose.ES384), string(jose.ES512),
+	string(jose.PS256), string(jose.PS384), string(jose.PS512),
+	string(jose.EdDSA),
+}
+
+// parseAlgorithmRestrictions validates a role's algorithm_restrictions.
+func parseAlgorithmRestrictions(algs []string) ([]string, error) {
+	var parsed []string
+	for _, alg := range algs {
+		if strings.EqualFold(alg, algNone) {
+			alg = algNone
+		} else if !strutil.StrListContains(supportedSigningAlgs, alg) {
+			return nil, fmt.Errorf("unsupported algorithm %q", alg)
+		}
+		if strutil.StrListContains(parsed, alg) {
+			return nil, fmt.Errorf("duplicate algorithm %q", alg)
+		}
+		parsed = append(parsed, alg)
+	}
+	return parsed, nil
+}
+
+// tokenAlgorithm returns the algorithm in the header of a compact JWS.
+func tokenAlgorithm(token string) (string, error) {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return "", err
+	}
+	if len(parsed.Headers) == 0 {
+		return "", errors.New("token has no header")
+	}
+	return parsed.Headers[0].Algorithm, nil
+}
+
+// keyMatchesAlgorithm reports whether key has the key type that alg
+// requires: RSA for RS* and PS*, EC for ES* and OKP for EdDSA.
+func keyMatchesAlgorithm(alg string, key interface{}) bool {
+	switch k := key.(type) {
+	case jose.JSONWebKey:
+		key = k.Key
+	case *jose.JSONWebKey:
+		key = k.Key
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case *ecdsa.PublicKey:
+		return strings.HasPrefix(alg, "ES")
+	case ed25519.PublicKey, joseed25519.PublicKey:
+		return alg == string(jose.EdDSA)
+	}
+	return false
+}
+
+// checkAlgorithmRestrictions checks alg, the algorithm a token is signed
+// with, against the role's algorithm_restrictions. The restrictions are
+// tried in order, skipping the algorithms that none of keys can verify, and
+// the token is accepted by the first one that is its algorithm. If keys is
+// nil the keys aren't known, e.g. for OIDC discovery, and no algorithm is
+// skipped. Unsigned tokens are always rejected.
+func checkAlgorithmRestrictions(role *jwtRole, alg string, keys []interface{}) error {
+	if alg == "" || strings.EqualFold(alg, algNone) {
+		return errors.New("unsigned tokens are not accepted")
+	}
+
+	if len(role.AlgorithmRestrictions) == 0 {
+		return nil
+	}
+
+	for _, allowed := range role.AlgorithmRestrictions {
+		if allowed == algNone {
+			continue
+		}
+		if keys != nil && !anyKeyMatchesAlgorithm(allowed, keys) {
+			if allowed == alg {
+				return fmt.Errorf("signing algorithm %q is allowed by algorithm_restrictions but there is no key of its type", alg)
+			}
+			continue
+		}
+		if allowed == alg {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("signing algorithm %q is not allowed by algorithm_restrictions", alg)
+}
+
+func anyKeyMatchesAlgorithm(alg string, keys []interface{}) bool {
+	for _, key := range keys {
+		if keyMatchesAlgorithm(alg, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// jwkKeys returns the keys of a JWK set for checkAlgorithmRestrictions.
+func jwkKeys(keys []jose.JSONWebKey) []interface{} {
+	result := make([]interface{}, len(keys))
+	for i, key := range keys {
+		result[i] = key
+	}
+	return result
+}
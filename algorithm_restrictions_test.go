@@ -0,0 +1,208 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	joseed25519 "golang.org/x/crypto/ed25519"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func TestCheckAlgorithmRestrictions(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mixed := []interface{}{&rsaKey.PublicKey, &ecKey.PublicKey}
+	jwks := jwkKeys([]jose.JSONWebKey{{Key: &ecKey.PublicKey}, {Key: joseed25519.PublicKey(edPub)}})
+
+	tests := []struct {
+		name         string
+		restrictions []string
+		alg          string
+		keys         []interface{}
+		errStr       string
+	}{
+		{"no restrictions", nil, "RS256", mixed, ""},
+		{"first usable", []string{"EdDSA", "ES256", "RS256"}, "ES256", mixed, ""},
+		{"second usable", []string{"EdDSA", "ES256", "RS256"}, "RS256", mixed, ""},
+		{"no key of type", []string{"EdDSA", "ES256", "RS256"}, "EdDSA", mixed, "there is no key of its type"},
+		{"not listed", []string{"ES256"}, "RS256", mixed, "not allowed by algorithm_restrictions"},
+		{"PS uses RSA keys", []string{"PS256"}, "PS256", mixed, ""},
+		{"jwk keys", []string{"RS256", "EdDSA"}, "EdDSA", jwks, ""},
+		{"jwk keys without RSA", []string{"RS256", "EdDSA"}, "RS256", jwks, "there is no key of its type"},
+		{"unknown keys", []string{"EdDSA"}, "EdDSA", nil, ""},
+		{"none sentinel", []string{"none", "ES256"}, "ES256", mixed, ""},
+		{"none", []string{"none", "ES256"}, "none", mixed, "unsigned tokens are not accepted"},
+		{"none unrestricted", nil, "none", nil, "unsigned tokens are not accepted"},
+		{"none mixed case", nil, "nOnE", mixed, "unsigned tokens are not accepted"},
+		{"no algorithm", nil, "", mixed, "unsigned tokens are not accepted"},
+	}
+
+	for _, tt := range tests {
+		err := checkAlgorithmRestrictions(&jwtRole{AlgorithmRestrictions: tt.restrictions}, tt.alg, tt.keys)
+		switch {
+		case tt.errStr == "" && err != nil:
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		case tt.errStr != "" && (err == nil || !strings.Contains(err.Error(), tt.errStr)):
+			t.Fatalf("%s: expected error containing %q, got: %v", tt.name, tt.errStr, err)
+		}
+	}
+}
+
+func TestLogin_AlgorithmRestrictions_MixedKeyTypes(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &rsaKey.PublicKey, KeyID: "rsa", Use: "sig"},
+		{Key: &ecKey.PublicKey, KeyID: "ec", Use: "sig"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(jwks)
+	}))
+	defer server.Close()
+
+	tokens := map[string]string{
+		"RS256": signedLoginJWT(t, jose.RS256, rsaKey, "rsa"),
+		"PS256": signedLoginJWT(t, jose.PS256, rsaKey, "rsa"),
+		"ES256": signedLoginJWT(t, jose.ES256, ecKey, "ec"),
+		"EdDSA": signedLoginJWT(t, jose.EdDSA, joseed25519.PrivateKey(edPriv), "rsa"),
+	}
+
+	tests := []struct {
+		restrictions string
+		supported    []string
+		allowed      map[string]string
+	}{
+		{
+			// EdDSA is skipped since the JWKS has no OKP key, and each of
+			// the other algorithms is verified with the key of its type.
+			restrictions: "EdDSA,ES256,RS256",
+			allowed: map[string]string{
+				"ES256": "",
+				"RS256": "",
+				"PS256": "not allowed by algorithm_restrictions",
+				"EdDSA": "there is no key of its type",
+			},
+		},
+		{
+			restrictions: "ES256",
+			allowed: map[string]string{
+				"ES256": "",
+				"RS256": "not allowed by algorithm_restrictions",
+			},
+		},
+		{
+			// The jwt_supported_algs of the config are checked first.
+			restrictions: "RS256,ES256",
+			supported:    []string{"ES256"},
+			allowed: map[string]string{
+				"ES256": "",
+				"RS256": `unsupported signing algorithm "RS256"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		configData := map[string]interface{}{
+			"jwt_validation_pubkeys": "",
+			"jwks_url":               server.URL,
+		}
+		if tt.supported != nil {
+			configData["jwt_supported_algs"] = tt.supported
+		}
+		b, storage := setupBackend(t, testConfig{
+			audience:   true,
+			configData: configData,
+			roleData: map[string]interface{}{
+				"algorithm_restrictions": tt.restrictions,
+			},
+		})
+
+		for alg, errStr := range tt.allowed {
+			resp := loginWithJWT(t, b, storage, tokens[alg])
+			if errStr == "" && (resp == nil || resp.IsError() || resp.Auth == nil) {
+				t.Fatalf("%s: expected successful %s login, got: %v", tt.restrictions, alg, resp)
+			}
+			if errStr != "" && (resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), errStr)) {
+				t.Fatalf("%s: expected %s login error containing %q, got: %v", tt.restrictions, alg, errStr, resp)
+			}
+		}
+	}
+}
+
+func TestPath_AlgorithmRestrictions(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for value, errStr := range map[string]string{
+		"NONE,ES256,EdDSA": "",
+		"HS256":            `unsupported algorithm "HS256"`,
+		"es256":            `unsupported algorithm "es256"`,
+		"ES256,ES256":      `duplicate algorithm "ES256"`,
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/test",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role_type":              "jwt",
+				"user_claim":             "sub",
+				"bound_audiences":        "vault",
+				"algorithm_restrictions": value,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if errStr == "" {
+			if resp != nil && resp.IsError() {
+				t.Fatalf("%s: unexpected error: %v", value, resp.Error())
+			}
+			continue
+		}
+		if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), errStr) {
+			t.Fatalf("%s: expected error containing %q, got: %v", value, errStr, resp)
+		}
+	}
+
+	role, err := b.(*jwtAuthBackend).role(context.Background(), storage, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(role.AlgorithmRestrictions, ","); got != "none,ES256,EdDSA" {
+		t.Fatalf("unexpected algorithm_restrictions: %s", got)
+	}
+}
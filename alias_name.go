@@ -0,0 +1,90 @@
+package jwtauth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hashicorp/errwrap"
+)
+
+const (
+	aliasNameSourceUserClaim     = "user_claim"
+	aliasNameSourceFullClaimPath = "full_claim_path"
+	aliasNameSourceClaimTemplate = "claim_template"
+
+	// maxAliasNameLength bounds the length of entity alias names.
+	maxAliasNameLength = 512
+)
+
+// parseAliasNameTemplate parses an alias_name_template. Referencing a claim
+// missing from the token is an error.
+func parseAliasNameTemplate(text string) (*template.Template, error) {
+	return template.New("alias_name_template").Option("missingkey=error").Parse(text)
+}
+
+// aliasName returns the entity alias name for allClaims, according to the
+// role's alias_name_source.
+func (r *jwtRole) aliasName(allClaims map[string]interface{}) (string, error) {
+	var name string
+	switch r.AliasNameSource {
+	case aliasNameSourceFullClaimPath:
+		value, err := claimAtPath(allClaims, r.UserClaim)
+		if err != nil {
+			return "", err
+		}
+		var ok bool
+		if name, ok = value.(string); !ok {
+			return "", fmt.Errorf("claim %q could not be converted to string", r.UserClaim)
+		}
+
+	case aliasNameSourceClaimTemplate:
+		tmpl, err := parseAliasNameTemplate(r.AliasNameTemplate)
+		if err != nil {
+			return "", errwrap.Wrapf("error parsing alias_name_template: {{err}}", err)
+		}
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, allClaims); err != nil {
+			return "", errwrap.Wrapf("error rendering alias_name_template: {{err}}", err)
+		}
+		name = sb.String()
+
+	default:
+		userClaimRaw, ok := lookupClaim(allClaims, r.UserClaim)
+		if !ok {
+			return "", fmt.Errorf("claim %q not found in token", r.UserClaim)
+		}
+		if name, ok = userClaimRaw.(string); !ok {
+			return "", fmt.Errorf("claim %q could not be converted to string", r.UserClaim)
+		}
+	}
+
+	if err := validateAliasName(name); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// validateAliasName checks that name can be used as an entity alias name.
+func validateAliasName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("entity alias name is empty")
+	}
+	if len(name) > maxAliasNameLength {
+		return fmt.Errorf("entity alias name is longer than %d bytes", maxAliasNameLength)
+	}
+	if !utf8.ValidString(name) {
+		return errors.New("entity alias name is not valid UTF-8")
+	}
+	for _, c := range name {
+		if unicode.IsControl(c) {
+			return errors.New("entity alias name contains control characters")
+		}
+	}
+
+	return nil
+}
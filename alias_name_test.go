@@ -0,0 +1,201 @@
+package jwtauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestRole_AliasName(t *testing.T) {
+	allClaims := map[string]interface{}{
+		"sub":       "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+		"email":     "jeff@example.com",
+		"tenant_id": "tenant/1",
+		"newline":   "jeff\nroot",
+		"long":      strings.Repeat("a", maxAliasNameLength+1),
+		"empty":     "",
+		"number":    42.0,
+		"realm": map[string]interface{}{
+			"user": map[string]interface{}{
+				"id": "jeff-id",
+			},
+		},
+	}
+
+	for _, tt := range []struct {
+		name     string
+		role     *jwtRole
+		expected string
+		errMsg   string
+	}{
+		{
+			"user claim",
+			&jwtRole{UserClaim: "email"},
+			"jeff@example.com", "",
+		},
+		{
+			"user claim of another type",
+			&jwtRole{UserClaim: "number", AliasNameSource: aliasNameSourceUserClaim},
+			"", `claim "number" could not be converted to string`,
+		},
+		{
+			"dotted user claim",
+			&jwtRole{UserClaim: "realm.user.id"},
+			"jeff-id", "",
+		},
+		{
+			"full claim path",
+			&jwtRole{UserClaim: "realm.user.id", AliasNameSource: aliasNameSourceFullClaimPath},
+			"jeff-id", "",
+		},
+		{
+			"missing full claim path",
+			&jwtRole{UserClaim: "realm.group.id", AliasNameSource: aliasNameSourceFullClaimPath},
+			"", `claim "realm.group.id" not found in token`,
+		},
+		{
+			"claim template",
+			&jwtRole{AliasNameSource: aliasNameSourceClaimTemplate, AliasNameTemplate: "{{.email}}-{{.tenant_id}}"},
+			"jeff@example.com-tenant/1", "",
+		},
+		{
+			"claim template with nested claims",
+			&jwtRole{AliasNameSource: aliasNameSourceClaimTemplate, AliasNameTemplate: "{{.realm.user.id}}"},
+			"jeff-id", "",
+		},
+		{
+			"claim template with missing claim",
+			&jwtRole{AliasNameSource: aliasNameSourceClaimTemplate, AliasNameTemplate: "{{.email}}-{{.org}}"},
+			"", "error rendering alias_name_template",
+		},
+		{
+			"claim template with control characters",
+			&jwtRole{AliasNameSource: aliasNameSourceClaimTemplate, AliasNameTemplate: "{{.newline}}"},
+			"", "contains control characters",
+		},
+		{
+			"empty alias name",
+			&jwtRole{AliasNameSource: aliasNameSourceClaimTemplate, AliasNameTemplate: "{{.empty}} "},
+			"", "entity alias name is empty",
+		},
+		{
+			"alias name too long",
+			&jwtRole{UserClaim: "long"},
+			"", "longer than 512 bytes",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			name, err := tt.role.aliasName(allClaims)
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Fatalf("expected error %q, got: %v", tt.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != tt.expected {
+				t.Fatalf("expected alias name %q, got %q", tt.expected, name)
+			}
+		})
+	}
+}
+
+func TestLogin_AliasNameTemplate(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		roleData: map[string]interface{}{
+			"alias_name_source":   "claim_template",
+			"alias_name_template": "{{.email}}-{{.tenant_id}}",
+		},
+	})
+
+	login := func(op logical.Operation, privateCl map[string]interface{}) *logical.Response {
+		t.Helper()
+		cl := jwt.Claims{
+			Audience:  jwt.Audience{"https://vault.plugin.auth.jwt.test"},
+			Issuer:    "https://team-vault.auth0.com/",
+			Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+			NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
+			Expiry:    jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}
+		token, _ := getTestJWT(t, ecdsaPrivKey, cl, privateCl)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: op,
+			Path:      "login",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role": "plugin-test",
+				"jwt":  token,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	claims := map[string]interface{}{
+		"https://vault/user":   "jeff",
+		"https://vault/groups": []string{"foo"},
+		"email":                "jeff@example.com",
+		"tenant_id":            "acme",
+	}
+
+	// The alias looked up before login is the one the entity is created
+	// with on login.
+	for _, op := range []logical.Operation{logical.AliasLookaheadOperation, logical.UpdateOperation} {
+		resp := login(op, claims)
+		if resp.IsError() {
+			t.Fatalf("unexpected error: %v", resp.Error())
+		}
+		if resp.Auth.Alias.Name != "jeff@example.com-acme" {
+			t.Fatalf("unexpected alias name: %q", resp.Auth.Alias.Name)
+		}
+	}
+
+	delete(claims, "tenant_id")
+	resp := login(logical.UpdateOperation, claims)
+	if !resp.IsError() || !strings.Contains(resp.Error().Error(), "error rendering alias_name_template") {
+		t.Fatalf("expected template error, got: %v", resp)
+	}
+}
+
+func TestPath_AliasNameSource_Invalid(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for _, tt := range []struct {
+		data   map[string]interface{}
+		errMsg string
+	}{
+		{map[string]interface{}{"alias_name_source": "claim"}, "invalid 'alias_name_source'"},
+		{map[string]interface{}{"alias_name_source": "claim_template"}, "'alias_name_template' must be set"},
+		{map[string]interface{}{"alias_name_source": "claim_template", "alias_name_template": "{{.email"}, "invalid 'alias_name_template'"},
+	} {
+		data := map[string]interface{}{
+			"role_type":  "jwt",
+			"user_claim": "user",
+		}
+		for k, v := range tt.data {
+			data[k] = v
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/test",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.IsError() || !strings.Contains(resp.Error().Error(), tt.errMsg) {
+			t.Fatalf("expected error %q, got: %v", tt.errMsg, resp)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package jwtauth
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Authentication event counters exposed by oidc/metrics.
+const (
+	metricJWTAuthSuccess       = "vault_jwt_auth_success_total"
+	metricJWTAuthFailure       = "vault_jwt_auth_failure_total"
+	metricOIDCAuthURLRequest   = "vault_oidc_auth_url_request_total"
+	metricOIDCCallbackSuccess  = "vault_oidc_callback_success_total"
+	metricOIDCCallbackFailure  = "vault_oidc_callback_failure_total"
+	metricOIDCProviderDuration = "vault_oidc_provider_request_duration_seconds"
+)
+
+// authCounterHelp describes the authentication event counters, in the
+// order they are exposed.
+var authCounterHelp = []struct {
+	name string
+	help string
+}{
+	{metricJWTAuthSuccess, "Successful JWT logins, by role."},
+	{metricJWTAuthFailure, "Failed JWT logins, by role and reason."},
+	{metricOIDCAuthURLRequest, "OIDC authorization URL requests, by role."},
+	{metricOIDCCallbackSuccess, "Successful OIDC callbacks, by role."},
+	{metricOIDCCallbackFailure, "Failed OIDC callbacks, by role and reason."},
+}
+
+// Failure reasons of the failure counters.
+const (
+	failureInvalidSignature = "invalid_signature"
+	failureExpired          = "expired"
+	failureBoundClaims      = "bound_claims"
+	failureProviderError    = "provider_error"
+	failureOther            = "other"
+)
+
+// failureReasons maps substrings of login error messages to failure
+// reasons. The first match wins.
+var failureReasons = []struct {
+	substr string
+	reason string
+}{
+	{"expired", failureExpired},
+	{"error fetching jwks", failureProviderError},
+	{"error exchanging", failureProviderError},
+	{"circuit breaker", failureProviderError},
+	{"No response from provider", failureProviderError},
+	{"signature", failureInvalidSignature},
+	{"error verifying token", failureInvalidSignature},
+	{"error parsing token", failureInvalidSignature},
+	{"bound claim", failureBoundClaims},
+	{"does not match", failureBoundClaims},
+}
+
+// loginFailureReason classifies the error message of a failed login.
+func loginFailureReason(msg string) string {
+	for _, r := range failureReasons {
+		if strings.Contains(msg, r.substr) {
+			return r.reason
+		}
+	}
+	return failureOther
+}
+
+type authCounterKey struct {
+	name   string
+	role   string
+	reason string
+}
+
+// authMetrics counts authentication events by role.
+type authMetrics struct {
+	l        sync.Mutex
+	counters map[authCounterKey]uint64
+}
+
+func newAuthMetrics() *authMetrics {
+	return &authMetrics{
+		counters: make(map[authCounterKey]uint64),
+	}
+}
+
+// inc increments the counter name for role. reason is only set for failure
+// counters.
+func (m *authMetrics) inc(name, role, reason string) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	m.counters[authCounterKey{name: name, role: role, reason: reason}]++
+}
+
+// count returns the value of the counter name for role and reason.
+func (m *authMetrics) count(name, role, reason string) uint64 {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	return m.counters[authCounterKey{name: name, role: role, reason: reason}]
+}
+
+// recordResult increments the success or failure counter for the result of
+// a login.
+func (m *authMetrics) recordResult(success, failure, role string, resp *logical.Response, err error) {
+	switch {
+	case err == nil && resp != nil && resp.Auth != nil:
+		m.inc(success, role, "")
+	case err != nil:
+		m.inc(failure, role, loginFailureReason(err.Error()))
+	case resp != nil && resp.IsError():
+		m.inc(failure, role, loginFailureReason(resp.Error().Error()))
+	}
+}
+
+// deleteRole removes the counters of a deleted role.
+func (m *authMetrics) deleteRole(role string) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	for key := range m.counters {
+		if key.role == role {
+			delete(m.counters, key)
+		}
+	}
+}
+
+// sortedKeys returns the keys of counter name, sorted by role and reason.
+// The caller must hold l.
+func (m *authMetrics) sortedKeys(name string) []authCounterKey {
+	var keys []authCounterKey
+	for key := range m.counters {
+		if key.name == name {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].role != keys[j].role {
+			return keys[i].role < keys[j].role
+		}
+		return keys[i].reason < keys[j].reason
+	})
+
+	return keys
+}
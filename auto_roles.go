@@ -0,0 +1,240 @@
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/parseutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// autoRoleTemplateKeys are the keys accepted in oidc_auto_role_template.
+var autoRoleTemplateKeys = []string{
+	"trigger_claim",
+	"trigger_value",
+	"policies",
+	"ttl",
+	"max_ttl",
+	"bound_claims",
+	"bound_audiences",
+	"user_claim",
+	"groups_claim",
+}
+
+// maxEphemeralRoles is the maximum number of ephemeral roles stored at once.
+var maxEphemeralRoles = 1000
+
+// ephemeralRoleNameRegex matches the role names accepted by the role/ path.
+var ephemeralRoleNameRegex = regexp.MustCompile("^" + framework.GenericNameRegex("name") + "$")
+
+var (
+	errEphemeralRoleName  = errors.New("role name is not valid for a role generated from oidc_auto_role_template")
+	errEphemeralRoleLimit = errors.New("the maximum number of roles generated from oidc_auto_role_template has been reached")
+)
+
+// autoRoleTemplate is the parsed oidc_auto_role_template. Logins to a role
+// that doesn't exist use a role generated from the template, which is stored
+// as an ephemeral role once a login succeeds.
+type autoRoleTemplate struct {
+	triggerClaim   string
+	triggerValue   string
+	policies       []string
+	ttl            time.Duration
+	maxTTL         time.Duration
+	boundClaims    map[string]interface{}
+	boundAudiences []string
+	userClaim      string
+	groupsClaim    string
+}
+
+// parseAutoRoleTemplate parses the oidc_auto_role_template config value. nil
+// is returned if no template is configured.
+func parseAutoRoleTemplate(raw map[string]interface{}) (*autoRoleTemplate, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	for k := range raw {
+		if !strutil.StrListContains(autoRoleTemplateKeys, k) {
+			return nil, fmt.Errorf("unknown oidc_auto_role_template key %q", k)
+		}
+	}
+
+	t := &autoRoleTemplate{
+		userClaim: "sub",
+	}
+	t.triggerClaim, _ = raw["trigger_claim"].(string)
+	t.triggerValue, _ = raw["trigger_value"].(string)
+	if t.triggerClaim == "" || t.triggerValue == "" {
+		return nil, errors.New("oidc_auto_role_template requires 'trigger_claim' and 'trigger_value'")
+	}
+
+	var err error
+	if v, ok := raw["policies"]; ok {
+		if t.policies, err = parseutil.ParseCommaStringSlice(v); err != nil {
+			return nil, errwrap.Wrapf("error parsing oidc_auto_role_template 'policies': {{err}}", err)
+		}
+	}
+	if v, ok := raw["bound_audiences"]; ok {
+		if t.boundAudiences, err = parseutil.ParseCommaStringSlice(v); err != nil {
+			return nil, errwrap.Wrapf("error parsing oidc_auto_role_template 'bound_audiences': {{err}}", err)
+		}
+	}
+	if v, ok := raw["ttl"]; ok {
+		if t.ttl, err = parseutil.ParseDurationSecond(v); err != nil {
+			return nil, errwrap.Wrapf("error parsing oidc_auto_role_template 'ttl': {{err}}", err)
+		}
+	}
+	if v, ok := raw["max_ttl"]; ok {
+		if t.maxTTL, err = parseutil.ParseDurationSecond(v); err != nil {
+			return nil, errwrap.Wrapf("error parsing oidc_auto_role_template 'max_ttl': {{err}}", err)
+		}
+	}
+	if t.maxTTL > 0 && t.ttl > t.maxTTL {
+		return nil, errors.New("oidc_auto_role_template 'ttl' should not be greater than 'max_ttl'")
+	}
+	if v, ok := raw["bound_claims"]; ok {
+		if t.boundClaims, ok = v.(map[string]interface{}); !ok {
+			return nil, errors.New("oidc_auto_role_template 'bound_claims' must be a map")
+		}
+	}
+	if v, ok := raw["user_claim"].(string); ok && v != "" {
+		t.userClaim = v
+	}
+	t.groupsClaim, _ = raw["groups_claim"].(string)
+
+	return t, nil
+}
+
+// role returns the role generated from the template. The trigger claim is
+// added to the bound claims, so logins only succeed with tokens carrying it.
+func (t *autoRoleTemplate) role() *jwtRole {
+	boundClaims := make(map[string]interface{}, len(t.boundClaims)+1)
+	for k, v := range t.boundClaims {
+		boundClaims[k] = v
+	}
+	boundClaims[t.triggerClaim] = t.triggerValue
+
+	role := &jwtRole{
+		RoleType:           "jwt",
+		UserClaim:          t.userClaim,
+		GroupsClaim:        t.groupsClaim,
+		BoundAudiences:     t.boundAudiences,
+		BoundAudiencesType: boundClaimsTypeString,
+		BoundClaimsType:    boundClaimsTypeString,
+		BoundClaims:        boundClaims,
+		OIDCFlow:           oidcFlowCode,
+		Ephemeral:          true,
+	}
+	role.TokenPolicies = t.policies
+	role.TokenTTL = t.ttl
+	role.TokenMaxTTL = t.maxTTL
+
+	return role
+}
+
+// storeEphemeralRole stores a role generated from the auto role template
+// after a successful login. The role expires once the tokens issued with it
+// can no longer be renewed; later logins extend the expiry. The name must be
+// one the role/ path accepts, and no more than maxEphemeralRoles are stored.
+func (b *jwtAuthBackend) storeEphemeralRole(ctx context.Context, s logical.Storage, roleName string, role *jwtRole) error {
+	if !ephemeralRoleNameRegex.MatchString(roleName) {
+		return errEphemeralRoleName
+	}
+
+	maxTTL := role.TokenMaxTTL
+	if maxTTL <= 0 || maxTTL > b.System().MaxLeaseTTL() {
+		maxTTL = b.System().MaxLeaseTTL()
+	}
+	expiry := time.Now().Add(maxTTL)
+
+	b.roleLock.Lock()
+	defer b.roleLock.Unlock()
+
+	existing, err := b.role(ctx, s, roleName)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		// A role created in the meantime is left alone.
+		if !existing.Ephemeral {
+			return nil
+		}
+		if existing.EphemeralExpiry.After(expiry) {
+			expiry = existing.EphemeralExpiry
+		}
+	} else {
+		count, err := b.countEphemeralRoles(ctx, s)
+		if err != nil {
+			return err
+		}
+		if count >= maxEphemeralRoles {
+			return errEphemeralRoleLimit
+		}
+	}
+	role.EphemeralExpiry = expiry
+
+	entry, err := logical.StorageEntryJSON(rolePrefix+roleName, role)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// countEphemeralRoles returns the number of stored ephemeral roles. The
+// caller must hold roleLock.
+func (b *jwtAuthBackend) countEphemeralRoles(ctx context.Context, s logical.Storage) (int, error) {
+	roleNames, err := s.List(ctx, rolePrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, roleName := range roleNames {
+		role, err := b.role(ctx, s, roleName)
+		if err != nil {
+			return 0, err
+		}
+		if role != nil && role.Ephemeral {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// tidyEphemeralRoles deletes the ephemeral roles whose tokens have all
+// expired. It is run from the backend's periodic function.
+func (b *jwtAuthBackend) tidyEphemeralRoles(ctx context.Context, s logical.Storage) error {
+	roleNames, err := s.List(ctx, rolePrefix)
+	if err != nil {
+		return err
+	}
+
+	b.roleLock.Lock()
+	defer b.roleLock.Unlock()
+
+	now := time.Now()
+	for _, roleName := range roleNames {
+		role, err := b.role(ctx, s, roleName)
+		if err != nil {
+			return err
+		}
+		if role == nil || !role.Ephemeral || now.Before(role.EphemeralExpiry) {
+			continue
+		}
+
+		if err := b.deleteRole(ctx, s, roleName, role); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,226 @@
+package jwtauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// setupAutoRoleBackend returns a backend with an auto role template
+// triggered by the "team" claim, and a function logging in to it.
+func setupAutoRoleBackend(t *testing.T) (*jwtAuthBackend, logical.Storage, func(roleName, team string) (*logical.Response, error)) {
+	b, storage := setupBackend(t, testConfig{
+		configData: map[string]interface{}{
+			"oidc_auto_role_template": map[string]interface{}{
+				"trigger_claim":   "team",
+				"trigger_value":   "project-x",
+				"policies":        "project-x,default",
+				"ttl":             "1m",
+				"max_ttl":         "10m",
+				"bound_audiences": "https://vault.plugin.auth.jwt.test",
+				"user_claim":      "https://vault/user",
+			},
+		},
+	})
+
+	login := func(roleName, team string) (*logical.Response, error) {
+		cl := jwt.Claims{
+			Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+			Issuer:    "https://team-vault.auth0.com/",
+			NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
+			Expiry:    jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			Audience:  jwt.Audience{"https://vault.plugin.auth.jwt.test"},
+		}
+		privateCl := map[string]interface{}{
+			"https://vault/user": "jeff",
+			"team":               team,
+		}
+		token, _ := getTestJWT(t, ecdsaPrivKey, cl, privateCl)
+
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role": roleName,
+				"jwt":  token,
+			},
+		})
+	}
+
+	return b.Backend.(*jwtAuthBackend), storage, login
+}
+
+func TestLogin_AutoRoleTemplate(t *testing.T) {
+	backend, storage, login := setupAutoRoleBackend(t)
+
+	// Tokens without the trigger claim value don't create a role.
+	resp, err := login("project-x", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() || !strings.Contains(resp.Error().Error(), "claim \"team\" does not match") {
+		t.Fatalf("expected bound claims error, got: %v", resp)
+	}
+	role, err := backend.role(context.Background(), storage, "project-x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != nil {
+		t.Fatal("expected no role to be created")
+	}
+
+	resp, err = login("project-x", "project-x")
+	if err != nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	if resp.Auth.Alias.Name != "jeff" {
+		t.Fatalf("unexpected alias name: %q", resp.Auth.Alias.Name)
+	}
+	if strings.Join(resp.Auth.Policies, ",") != "project-x,default" {
+		t.Fatalf("unexpected policies: %v", resp.Auth.Policies)
+	}
+	if resp.Auth.TTL != time.Minute || resp.Auth.MaxTTL != 10*time.Minute {
+		t.Fatalf("unexpected TTLs: %s, %s", resp.Auth.TTL, resp.Auth.MaxTTL)
+	}
+
+	role, err = backend.role(context.Background(), storage, "project-x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role == nil || !role.Ephemeral {
+		t.Fatalf("expected ephemeral role, got: %#v", role)
+	}
+	if until := time.Until(role.EphemeralExpiry); until < 9*time.Minute || until > 10*time.Minute {
+		t.Fatalf("unexpected ephemeral role expiry: %s", role.EphemeralExpiry)
+	}
+
+	// The role is kept until its tokens have expired.
+	if err := backend.tidyEphemeralRoles(context.Background(), storage); err != nil {
+		t.Fatal(err)
+	}
+	if role, _ = backend.role(context.Background(), storage, "project-x"); role == nil {
+		t.Fatal("expected ephemeral role to be kept")
+	}
+
+	role.EphemeralExpiry = time.Now().Add(-time.Second)
+	entry, err := logical.StorageEntryJSON(rolePrefix+"project-x", role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.tidyEphemeralRoles(context.Background(), storage); err != nil {
+		t.Fatal(err)
+	}
+	if role, _ = backend.role(context.Background(), storage, "project-x"); role != nil {
+		t.Fatal("expected expired ephemeral role to be deleted")
+	}
+
+	// Writing an ephemeral role makes it permanent.
+	if resp, err = login("project-y", "project-x"); err != nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	resp, err = backend.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/project-y",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_type":      "jwt",
+			"token_policies": "project-y",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	if role, _ = backend.role(context.Background(), storage, "project-y"); role == nil || role.Ephemeral {
+		t.Fatalf("expected permanent role, got: %#v", role)
+	}
+}
+
+func TestLogin_AutoRoleTemplate_InvalidName(t *testing.T) {
+	_, storage, login := setupAutoRoleBackend(t)
+
+	for _, roleName := range []string{"team/project-x", "../project-x", "-project-x", "project x"} {
+		resp, err := login(roleName, "project-x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.IsError() || !strings.Contains(resp.Error().Error(), "role name is not valid") {
+			t.Fatalf("expected invalid role name error for %q, got: %v", roleName, resp)
+		}
+	}
+
+	roleNames, err := storage.List(context.Background(), rolePrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roleNames) != 1 || roleNames[0] != "plugin-test" {
+		t.Fatalf("expected no role to be created, got: %v", roleNames)
+	}
+}
+
+func TestLogin_AutoRoleTemplate_Limit(t *testing.T) {
+	defer func(n int) { maxEphemeralRoles = n }(maxEphemeralRoles)
+	maxEphemeralRoles = 2
+
+	backend, storage, login := setupAutoRoleBackend(t)
+
+	for _, roleName := range []string{"project-1", "project-2"} {
+		if resp, err := login(roleName, "project-x"); err != nil || resp.IsError() {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+	}
+
+	resp, err := login("project-3", "project-x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() || !strings.Contains(resp.Error().Error(), "maximum number of roles") {
+		t.Fatalf("expected role limit error, got: %v", resp)
+	}
+	if role, _ := backend.role(context.Background(), storage, "project-3"); role != nil {
+		t.Fatal("expected no role to be created beyond the limit")
+	}
+
+	// Logins to a stored ephemeral role still succeed at the limit.
+	if resp, err := login("project-1", "project-x"); err != nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+}
+
+func TestConfig_AutoRoleTemplate(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		template map[string]interface{}
+		errMsg   string
+	}{
+		{"missing trigger", map[string]interface{}{"policies": "foo"}, "requires 'trigger_claim' and 'trigger_value'"},
+		{"unknown key", map[string]interface{}{"trigger_claim": "team", "trigger_value": "x", "period": "1h"}, `unknown oidc_auto_role_template key "period"`},
+		{"ttl above max_ttl", map[string]interface{}{"trigger_claim": "team", "trigger_value": "x", "ttl": "1h", "max_ttl": "1m"}, "should not be greater"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := getBackend(t)
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPath,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"jwt_validation_pubkeys":  ecdsaPubKey,
+					"oidc_auto_role_template": tt.template,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !resp.IsError() || !strings.Contains(resp.Error().Error(), tt.errMsg) {
+				t.Fatalf("expected error %q, got: %v", tt.errMsg, resp)
+			}
+		})
+	}
+}
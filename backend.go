@@ -11,6 +11,7 @@ import (
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/patrickmn/go-cache"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
@@ -33,8 +34,78 @@ type jwtAuthBackend struct {
 	l            sync.RWMutex
 	provider     *oidc.Provider
 	keySet       oidc.KeySet
+	roleKeySets  map[string]oidc.KeySet
 	cachedConfig *jwtConfig
 	oidcStates   *cache.Cache
+	stateLock    sync.Mutex
+	logoutStates *cache.Cache
+
+	// deviceLock serializes polls of pending device codes
+	deviceLock sync.Mutex
+
+	// callbackFailures counts recent failed OIDC callbacks, which raise the
+	// oidc_pow_difficulty of new logins
+	callbackFailures failureRate
+
+	// roleLock serializes role writes and deletes so that role aliases
+	// stay consistent
+	roleLock sync.Mutex
+
+	// revocationCache holds tokens that recently passed a revocation check
+	revocationCache *cache.Cache
+
+	// perKidKeys holds keys fetched with a role's jwks_per_kid_url_template
+	perKidKeys *cache.Cache
+
+	// jwksCache holds the *jwksCacheEntry of each role's JWKS URLs, and
+	// jwksFetches makes concurrent refreshes of an entry share a fetch
+	jwksCache   sync.Map
+	jwksFetches singleflight.Group
+
+	// providerFetched and providerAttempted are the times of the last
+	// successful and attempted fetches of the discovery document of
+	// provider, and providerRefreshing is set while a background refresh is
+	// running. providerGeneration changes whenever the config is reset, so
+	// that fetches for an older config are discarded. All are guarded by l.
+	// providerFetches makes concurrent refreshes share a fetch.
+	providerFetched    time.Time
+	providerAttempted  time.Time
+	providerRefreshing bool
+	providerGeneration uint64
+	providerFetches    singleflight.Group
+
+	// perKidFetches makes concurrent logins missing the same per-kid key
+	// share a single fetch. The key sets of jwks_url and jwks_urls already
+	// share their fetches in go-oidc.
+	perKidFetches singleflight.Group
+
+	// negativeCache holds the errors of rejected tokens for a role's
+	// oidc_negative_cache_ttl
+	negativeCache *cache.Cache
+
+	// introspectionCache holds the responses of introspection endpoints
+	// for a role's introspection_cache_ttl
+	introspectionCache *cache.Cache
+
+	providerBreaker   *circuitBreaker
+	validationMetrics *validationMetrics
+	tokenStats        *tokenStats
+	authMetrics       *authMetrics
+
+	// providerMetrics records the latency of OIDC provider requests made
+	// during callbacks
+	providerMetrics *validationMetrics
+
+	healthLock     sync.RWMutex
+	providerHealth *providerHealth
+
+	// adaptiveTimeout tracks provider response times for
+	// oidc_adaptive_timeout
+	adaptiveTimeout *adaptiveTimeout
+
+	// geoIP is the opened oidc_geoip_database
+	geoIPLock sync.RWMutex
+	geoIP     *geoIPDatabase
 
 	providerCtx       context.Context
 	providerCtxCancel context.CancelFunc
@@ -43,7 +114,19 @@ type jwtAuthBackend struct {
 func backend() *jwtAuthBackend {
 	b := new(jwtAuthBackend)
 	b.providerCtx, b.providerCtxCancel = context.WithCancel(context.Background())
+	b.roleKeySets = make(map[string]oidc.KeySet)
 	b.oidcStates = cache.New(oidcStateTimeout, 1*time.Minute)
+	b.logoutStates = cache.New(oidcStateTimeout, 1*time.Minute)
+	b.revocationCache = cache.New(cache.NoExpiration, 1*time.Minute)
+	b.perKidKeys = cache.New(perKidKeyTimeout, 1*time.Minute)
+	b.negativeCache = cache.New(cache.NoExpiration, 1*time.Minute)
+	b.introspectionCache = cache.New(cache.NoExpiration, 1*time.Minute)
+	b.providerBreaker = newCircuitBreaker()
+	b.validationMetrics = newValidationMetrics()
+	b.tokenStats = newTokenStats()
+	b.authMetrics = newAuthMetrics()
+	b.providerMetrics = newValidationMetrics()
+	b.adaptiveTimeout = newAdaptiveTimeout()
 
 	b.Backend = &framework.Backend{
 		AuthRenew:   b.pathLoginRenew,
@@ -55,12 +138,31 @@ func backend() *jwtAuthBackend {
 				"login",
 				"oidc/auth_url",
 				"oidc/callback",
+				"oidc/obo",
+				"token-exchange",
+				"oidc/verify_auth_url",
+				"oidc/end-session",
+				"oidc/logged-out",
+				"oidc/logout",
+				"oidc/negotiate-role",
+				"device/auth_url",
+				"device/token",
+				"config/encryption-key/public",
 
 				// Uncomment to mount simple UI handler for local development
 				// "ui",
 			},
+			Root: []string{
+				"oidc/validate-config",
+				"oidc/state-cleanup",
+			},
 			SealWrapStorage: []string{
 				"config",
+				encryptionKeyPath,
+				rolePrefix,
+				authURLSigningKeyPath,
+				claimKeyPrefix,
+				clientKeyPrefix,
 			},
 		},
 		Paths: framework.PathAppend(
@@ -69,13 +171,32 @@ func backend() *jwtAuthBackend {
 				pathRoleList(b),
 				pathRole(b),
 				pathConfig(b),
+				pathConfigJWKSCache(b),
+				pathConfigOIDCDiscovery(b),
+				pathProviderHealth(b),
+				pathOIDCOnBehalfOf(b),
+				pathTokenExchange(b),
+				pathOIDCVerifyAuthURL(b),
+				pathOIDCRegisterClient(b),
+				pathOIDCNegotiateRole(b),
+				pathOIDCDecryptClaim(b),
+				pathMetrics(b),
+				pathOIDCStats(b),
+				pathOIDCValidateConfig(b),
+				pathOIDCStateCleanup(b),
+				pathBatchLogin(b),
 
 				// Uncomment to mount simple UI handler for local development
 				// pathUI(b),
 			},
 			pathOIDC(b),
+			pathOIDCDevice(b),
+			pathRoleValidationKeys(b),
+			pathOIDCLogout(b),
+			pathConfigEncryptionKey(b),
 		),
-		Clean: b.cleanup,
+		Clean:        b.cleanup,
+		PeriodicFunc: b.periodicFunc,
 	}
 
 	return b
@@ -89,6 +210,32 @@ func (b *jwtAuthBackend) cleanup(_ context.Context) {
 	b.l.Unlock()
 }
 
+func (b *jwtAuthBackend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	if err := b.checkProviderHealth(ctx, req.Storage); err != nil {
+		return err
+	}
+
+	b.refreshGeoIPDatabase()
+
+	if err := b.tidyOIDCStates(ctx, req.Storage); err != nil {
+		return err
+	}
+
+	if err := b.tidyDeviceCodes(ctx, req.Storage); err != nil {
+		return err
+	}
+
+	if err := b.tidyEphemeralRoles(ctx, req.Storage); err != nil {
+		return err
+	}
+
+	if err := b.tidyLoggedOutSessions(ctx, req.Storage); err != nil {
+		return err
+	}
+
+	return b.tidyTokenIPs(ctx, req.Storage)
+}
+
 func (b *jwtAuthBackend) invalidate(ctx context.Context, key string) {
 	switch key {
 	case "config":
@@ -99,34 +246,23 @@ func (b *jwtAuthBackend) invalidate(ctx context.Context, key string) {
 func (b *jwtAuthBackend) reset() {
 	b.l.Lock()
 	b.provider = nil
+	b.providerFetched = time.Time{}
+	b.providerAttempted = time.Time{}
+	b.providerGeneration++
 	b.cachedConfig = nil
+	b.roleKeySets = make(map[string]oidc.KeySet)
 	b.l.Unlock()
-}
 
-func (b *jwtAuthBackend) getProvider(config *jwtConfig) (*oidc.Provider, error) {
-	b.l.RLock()
-	unlockFunc := b.l.RUnlock
-	defer func() { unlockFunc() }()
-
-	if b.provider != nil {
-		return b.provider, nil
-	}
-
-	b.l.RUnlock()
-	b.l.Lock()
-	unlockFunc = b.l.Unlock
-
-	if b.provider != nil {
-		return b.provider, nil
-	}
-
-	provider, err := b.createProvider(config)
-	if err != nil {
-		return nil, err
-	}
+	b.healthLock.Lock()
+	b.providerHealth = nil
+	b.healthLock.Unlock()
 
-	b.provider = provider
-	return provider, nil
+	b.providerBreaker.reset()
+	b.perKidKeys.Flush()
+	b.flushJWKSCache("")
+	b.negativeCache.Flush()
+	b.introspectionCache.Flush()
+	b.adaptiveTimeout.reset()
 }
 
 // getKeySet returns a new JWKS KeySet based on the provided config.
@@ -142,7 +278,7 @@ func (b *jwtAuthBackend) getKeySet(config *jwtConfig) (oidc.KeySet, error) {
 		return nil, errors.New("keyset error: jwks_url not configured")
 	}
 
-	ctx, err := b.createCAContext(b.providerCtx, config.JWKSCAPEM)
+	ctx, err := b.createCAContext(b.providerCtx, config, config.JWKSCAPEM)
 	if err != nil {
 		return nil, errwrap.Wrapf("error parsing jwks_ca_pem: {{err}}", err)
 	}
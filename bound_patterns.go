@@ -0,0 +1,27 @@
+package jwtauth
+
+import (
+	"regexp"
+	"sync"
+)
+
+// boundPatterns holds the regular expressions of bound_claims and
+// bound_audiences, keyed by pattern. They are compiled when a role is
+// written, so that logins don't compile them again. Patterns of roles written
+// before a restart, or on another node, are compiled by their first login.
+var boundPatterns sync.Map
+
+// compileBoundPattern returns the compiled regular expression of pattern.
+func compileBoundPattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := boundPatterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	boundPatterns.Store(pattern, re)
+
+	return re, nil
+}
@@ -0,0 +1,79 @@
+package jwtauth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBoundPatterns_CompiledAtRoleWrite(t *testing.T) {
+	b, storage := getBackend(t)
+
+	const claimPattern = `^team-compiled-at-write-[0-9]+$`
+	const audiencePattern = `^vault-compiled-at-write-[a-z]+$`
+	for _, pattern := range []string{claimPattern, audiencePattern} {
+		if _, ok := boundPatterns.Load(pattern); ok {
+			t.Fatalf("pattern %q already compiled", pattern)
+		}
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_type":         "jwt",
+			"user_claim":        "sub",
+			"bound_audiences":   "vault",
+			"bound_claims_type": "regexp",
+			"bound_claims": map[string]interface{}{
+				"team": claimPattern,
+			},
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+
+	compiled, ok := boundPatterns.Load(claimPattern)
+	if !ok {
+		t.Fatal("expected the bound claim pattern to be compiled when the role is written")
+	}
+
+	// Logins reuse the compiled pattern.
+	if re, err := compileBoundPattern(claimPattern); err != nil || re != compiled {
+		t.Fatalf("expected the compiled pattern to be reused, got %p, %v", re, err)
+	}
+	if err := validateBoundClaims(nil, boundClaimsTypeRegexp, map[string]interface{}{"team": claimPattern}, map[string]interface{}{"team": "team-compiled-at-write-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/audiences",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_type":            "jwt",
+			"user_claim":           "sub",
+			"bound_audiences_type": "regexp",
+			"bound_audiences":      audiencePattern,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	if _, ok := boundPatterns.Load(audiencePattern); !ok {
+		t.Fatal("expected the bound audience pattern to be compiled when the role is written")
+	}
+	if !audienceMatches(boundClaimsTypeRegexp, []string{audiencePattern}, "vault-compiled-at-write-prod") {
+		t.Fatal("expected the audience to match")
+	}
+
+	if _, err := compileBoundPattern("(unclosed"); err == nil {
+		t.Fatal("expected error for an invalid pattern")
+	}
+	if _, ok := boundPatterns.Load("(unclosed"); ok {
+		t.Fatal("expected invalid patterns not to be stored")
+	}
+}
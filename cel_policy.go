@@ -0,0 +1,86 @@
+package jwtauth
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// celPrograms holds the compiled cel_policy programs, keyed by expression.
+// Like boundPatterns, policies are compiled when a role is written, and
+// policies of roles written before a restart or on another node are compiled
+// by their first login.
+var celPrograms sync.Map
+
+// compileCELPolicy compiles a role's cel_policy. The expression accesses the
+// token's claims through the "claims" map and must evaluate to a bool.
+func compileCELPolicy(expr string) (cel.Program, error) {
+	if prg, ok := celPrograms.Load(expr); ok {
+		return prg.(cel.Program), nil
+	}
+
+	env, err := cel.NewEnv(cel.Declarations(
+		decls.NewIdent("claims", decls.NewMapType(decls.String, decls.Dyn), nil),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, issues := env.Parse(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("error compiling cel_policy: %s", issues.Err())
+	}
+	ast, issues := env.Check(parsed)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("error compiling cel_policy: %s", issues.Err())
+	}
+
+	// Expressions of claims are dynamically typed, so their result is only
+	// checked when they are evaluated.
+	resultType := ast.ResultType()
+	if resultType.GetPrimitive() != exprpb.Type_BOOL && resultType.GetDyn() == nil {
+		return nil, errors.New("cel_policy must evaluate to a bool")
+	}
+
+	prg, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling cel_policy: %s", err)
+	}
+	celPrograms.Store(expr, prg)
+
+	return prg, nil
+}
+
+// validateCELPolicy evaluates the role's cel_policy, if one is set, against
+// allClaims. Logins are denied unless it evaluates to true.
+func validateCELPolicy(role *jwtRole, allClaims map[string]interface{}) error {
+	if role.CELPolicy == "" {
+		return nil
+	}
+
+	prg, err := compileCELPolicy(role.CELPolicy)
+	if err != nil {
+		return err
+	}
+
+	out, _, err := prg.Eval(map[string]interface{}{
+		"claims": allClaims,
+	})
+	if err != nil {
+		return fmt.Errorf("error evaluating cel_policy: %s", err)
+	}
+
+	allowed, ok := out.Value().(bool)
+	if !ok {
+		return fmt.Errorf("cel_policy evaluated to %v instead of a bool", out.Value())
+	}
+	if !allowed {
+		return errors.New("claims do not satisfy cel_policy")
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package jwtauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestCELPolicy(t *testing.T) {
+	claims := map[string]interface{}{
+		"sub":    "user-1",
+		"email":  "bob@example.com",
+		"groups": []interface{}{"dev", "admins"},
+		"level":  2.0,
+		"org":    map[string]interface{}{"id": "acme"},
+	}
+
+	tests := []struct {
+		policy string
+		errStr string
+	}{
+		{policy: `claims.email.endsWith("@example.com")`},
+		{policy: `"admins" in claims.groups && claims.level >= 2.0`},
+		{policy: `claims.org.id == "acme"`},
+		{policy: `has(claims.team) || claims.sub.startsWith("user-")`},
+		{policy: `claims.email.endsWith("@evil.com")`, errStr: "claims do not satisfy cel_policy"},
+		{policy: `claims.groups.exists(g, g == "ops")`, errStr: "claims do not satisfy cel_policy"},
+		{policy: `claims.team == "payments"`, errStr: "error evaluating cel_policy"},
+		{policy: `claims.sub`, errStr: "instead of a bool"},
+	}
+
+	for _, tt := range tests {
+		err := validateCELPolicy(&jwtRole{CELPolicy: tt.policy}, claims)
+		switch {
+		case tt.errStr == "" && err != nil:
+			t.Fatalf("%s: unexpected error: %v", tt.policy, err)
+		case tt.errStr != "" && (err == nil || !strings.Contains(err.Error(), tt.errStr)):
+			t.Fatalf("%s: expected error containing %q, got: %v", tt.policy, tt.errStr, err)
+		}
+	}
+}
+
+func TestCELPolicy_Compile(t *testing.T) {
+	tests := map[string]string{
+		`claims.email ==`:    "error compiling cel_policy",
+		`unknown.field`:      "error compiling cel_policy",
+		`"not a bool"`:       "must evaluate to a bool",
+		`size(claims) + 1`:   "must evaluate to a bool",
+		`claims.sub == 1 &&`: "error compiling cel_policy",
+	}
+
+	for policy, errStr := range tests {
+		if _, err := compileCELPolicy(policy); err == nil || !strings.Contains(err.Error(), errStr) {
+			t.Fatalf("%s: expected error containing %q, got: %v", policy, errStr, err)
+		}
+	}
+}
+
+func TestLogin_CELPolicy(t *testing.T) {
+	for policy, allowed := range map[string]bool{
+		`claims.color == "green" && "foo" in claims["https://vault/groups"]`: true,
+		`claims.color == "blue"`: false,
+	} {
+		b, storage := setupBackend(t, testConfig{
+			audience: true,
+			roleData: map[string]interface{}{
+				"cel_policy": policy,
+			},
+		})
+		req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed && (resp == nil || resp.IsError()) {
+			t.Fatalf("expected successful login, got: %v", resp)
+		}
+		if !allowed && (resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "cel_policy")) {
+			t.Fatalf("expected cel_policy error, got: %v", resp)
+		}
+	}
+}
+
+func TestPath_CELPolicy_Invalid(t *testing.T) {
+	b, storage := getBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"user_claim":    "user",
+			"bound_subject": "user-1",
+			"cel_policy":    `claims.email ==`,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() || !strings.Contains(resp.Error().Error(), "error compiling cel_policy") {
+		t.Fatalf("expected compile error, got: %v", resp)
+	}
+}
+
+func TestCELPolicy_CompiledAtRoleWrite(t *testing.T) {
+	b, storage := getBackend(t)
+
+	const policy = `claims.sub == "compiled-at-write"`
+	if _, ok := celPrograms.Load(policy); ok {
+		t.Fatalf("policy %q already compiled", policy)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_type":       "jwt",
+			"user_claim":      "sub",
+			"bound_audiences": "vault",
+			"cel_policy":      policy,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+
+	compiled, ok := celPrograms.Load(policy)
+	if !ok {
+		t.Fatal("expected the policy to be compiled when the role is written")
+	}
+
+	// Logins reuse the compiled program.
+	if prg, err := compileCELPolicy(policy); err != nil || prg != compiled {
+		t.Fatalf("expected the compiled program to be reused, got %v, %v", prg, err)
+	}
+	if err := validateCELPolicy(&jwtRole{CELPolicy: policy}, map[string]interface{}{"sub": "compiled-at-write"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := celPrograms.Load(`claims.email ==`); ok {
+		t.Fatal("expected invalid policies not to be stored")
+	}
+}
@@ -0,0 +1,144 @@
+package jwtauth
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Defaults for oidc_circuit_breaker_window and oidc_circuit_breaker_cooldown.
+const (
+	defaultCircuitBreakerWindow   = 60 * time.Second
+	defaultCircuitBreakerCooldown = 30 * time.Second
+)
+
+// errProviderUnreachable is returned while the provider circuit breaker is
+// open.
+var errProviderUnreachable = errors.New("OIDC provider unreachable, auth disabled until recovery")
+
+// circuitBreaker stops requests to the OIDC provider after repeated failures,
+// as configured by the oidc_circuit_breaker_* settings. Once the cooldown has
+// passed, a single probe request is allowed through; its success closes the
+// circuit and its failure opens it again.
+type circuitBreaker struct {
+	l sync.Mutex
+
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	probeAt     time.Time
+
+	// now is overridden in tests
+	now func() time.Time
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{now: time.Now}
+}
+
+// allow returns errProviderUnreachable if requests to the provider should
+// not be attempted.
+func (cb *circuitBreaker) allow(config *jwtConfig) error {
+	if config.OIDCCircuitBreakerThreshold <= 0 {
+		return nil
+	}
+
+	cb.l.Lock()
+	defer cb.l.Unlock()
+
+	if cb.openedAt.IsZero() {
+		return nil
+	}
+
+	now := cb.now()
+	if now.Sub(cb.openedAt) < config.circuitBreakerCooldown() {
+		return errProviderUnreachable
+	}
+
+	// Allow a single probe per cooldown period
+	if !cb.probeAt.IsZero() && now.Sub(cb.probeAt) < config.circuitBreakerCooldown() {
+		return errProviderUnreachable
+	}
+	cb.probeAt = now
+
+	return nil
+}
+
+// record registers the outcome of a request to the provider.
+func (cb *circuitBreaker) record(config *jwtConfig, failed bool) {
+	if config.OIDCCircuitBreakerThreshold <= 0 {
+		return
+	}
+
+	cb.l.Lock()
+	defer cb.l.Unlock()
+
+	now := cb.now()
+
+	if !failed {
+		cb.failures = 0
+		cb.windowStart = time.Time{}
+		cb.openedAt = time.Time{}
+		cb.probeAt = time.Time{}
+		return
+	}
+
+	// A failed probe opens the circuit for another cooldown period
+	if !cb.openedAt.IsZero() {
+		cb.openedAt = now
+		cb.probeAt = time.Time{}
+		return
+	}
+
+	if cb.windowStart.IsZero() || now.Sub(cb.windowStart) > config.circuitBreakerWindow() {
+		cb.windowStart = now
+		cb.failures = 0
+	}
+
+	cb.failures++
+	if cb.failures >= config.OIDCCircuitBreakerThreshold {
+		cb.openedAt = now
+	}
+}
+
+// isOpen reports whether the circuit is open.
+func (cb *circuitBreaker) isOpen() bool {
+	cb.l.Lock()
+	defer cb.l.Unlock()
+
+	return !cb.openedAt.IsZero()
+}
+
+// reset closes the circuit.
+func (cb *circuitBreaker) reset() {
+	cb.l.Lock()
+	defer cb.l.Unlock()
+
+	cb.failures = 0
+	cb.windowStart = time.Time{}
+	cb.openedAt = time.Time{}
+	cb.probeAt = time.Time{}
+}
+
+// isProviderUnreachable reports whether err from a request to the provider
+// indicates that the provider could not be reached, as opposed to the
+// provider rejecting the request.
+func isProviderUnreachable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := err.(*oauth2.RetrieveError); ok {
+		return false
+	}
+
+	return true
+}
+
+// isKeyFetchError reports whether a token verification error was caused by a
+// failure to fetch the provider's signing keys.
+func isKeyFetchError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "fetching keys")
+}
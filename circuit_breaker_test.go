@@ -0,0 +1,74 @@
+package jwtauth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	config := &jwtConfig{
+		OIDCCircuitBreakerThreshold: 2,
+		OIDCCircuitBreakerWindow:    time.Minute,
+		OIDCCircuitBreakerCooldown:  30 * time.Second,
+	}
+
+	now := time.Now()
+	cb := newCircuitBreaker()
+	cb.now = func() time.Time { return now }
+
+	expectAllowed := func(allowed bool) {
+		t.Helper()
+		if err := cb.allow(config); (err == nil) != allowed {
+			t.Fatalf("expected allowed=%t, got %v", allowed, err)
+		}
+	}
+
+	// failures outside of the window don't open the circuit
+	cb.record(config, true)
+	now = now.Add(2 * time.Minute)
+	cb.record(config, true)
+	expectAllowed(true)
+
+	// consecutive failures within the window do
+	now = now.Add(time.Second)
+	cb.record(config, true)
+	expectAllowed(false)
+
+	// a single probe is allowed after the cooldown
+	now = now.Add(31 * time.Second)
+	expectAllowed(true)
+	expectAllowed(false)
+
+	// a failed probe opens the circuit again
+	cb.record(config, true)
+	expectAllowed(false)
+	now = now.Add(31 * time.Second)
+	expectAllowed(true)
+
+	// a successful probe closes it
+	cb.record(config, false)
+	expectAllowed(true)
+	expectAllowed(true)
+
+	// disabled without a threshold
+	config.OIDCCircuitBreakerThreshold = 0
+	for i := 0; i < 5; i++ {
+		cb.record(config, true)
+	}
+	expectAllowed(true)
+}
+
+func TestIsProviderUnreachable(t *testing.T) {
+	if isProviderUnreachable(nil) {
+		t.Fatal("nil error is not a failure")
+	}
+	if isProviderUnreachable(&oauth2.RetrieveError{}) {
+		t.Fatal("provider error response is not a failure")
+	}
+	if !isProviderUnreachable(errors.New("dial tcp: connection refused")) {
+		t.Fatal("network error is a failure")
+	}
+}
@@ -0,0 +1,124 @@
+package jwtauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/hashicorp/go-hclog"
+)
+
+// claimOperator is a parsed bound_claims_operators expression, e.g. ">=3" or
+// "between:1,10".
+type claimOperator struct {
+	op    string
+	bound float64
+
+	// upper is the upper bound of "between".
+	upper float64
+}
+
+// claimOperatorPrefixes are the comparison operators, longest first so that
+// ">=" isn't parsed as ">".
+var claimOperatorPrefixes = []string{">=", "<=", "==", ">", "<"}
+
+// parseClaimOperator parses a bound_claims_operators expression.
+func parseClaimOperator(expr string) (*claimOperator, error) {
+	expr = strings.TrimSpace(expr)
+
+	if strings.HasPrefix(expr, "between:") {
+		bounds := strings.Split(strings.TrimPrefix(expr, "between:"), ",")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid operator %q: between requires two bounds", expr)
+		}
+		lower, err := strconv.ParseFloat(strings.TrimSpace(bounds[0]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid operator %q: %s", expr, err)
+		}
+		upper, err := strconv.ParseFloat(strings.TrimSpace(bounds[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid operator %q: %s", expr, err)
+		}
+		if lower > upper {
+			return nil, fmt.Errorf("invalid operator %q: lower bound is greater than upper bound", expr)
+		}
+		return &claimOperator{op: "between", bound: lower, upper: upper}, nil
+	}
+
+	for _, op := range claimOperatorPrefixes {
+		if strings.HasPrefix(expr, op) {
+			bound, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimPrefix(expr, op)), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid operator %q: %s", expr, err)
+			}
+			return &claimOperator{op: op, bound: bound}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid operator %q: expected one of >=, <=, ==, >, < or between:", expr)
+}
+
+// matches reports whether v satisfies the operator. Bounds are inclusive for
+// ">=", "<=" and "between".
+func (o *claimOperator) matches(v float64) bool {
+	switch o.op {
+	case ">=":
+		return v >= o.bound
+	case "<=":
+		return v <= o.bound
+	case "==":
+		return v == o.bound
+	case ">":
+		return v > o.bound
+	case "<":
+		return v < o.bound
+	case "between":
+		return v >= o.bound && v <= o.upper
+	}
+
+	return false
+}
+
+// numericClaim returns the value of a numeric claim.
+func numericClaim(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+
+	return 0, false
+}
+
+// validateBoundClaimsOperators checks that the claims named in operators
+// satisfy their numeric operator expressions.
+func validateBoundClaimsOperators(logger log.Logger, operators map[string]string, allClaims map[string]interface{}) error {
+	for claim, expr := range operators {
+		// Expressions are validated when the role is written.
+		op, err := parseClaimOperator(expr)
+		if err != nil {
+			return err
+		}
+
+		value := getClaim(logger, allClaims, claim)
+		if value == nil {
+			return fmt.Errorf("claim %q is missing", claim)
+		}
+		v, ok := numericClaim(value)
+		if !ok {
+			return fmt.Errorf("claim %q is not numeric: %v", claim, value)
+		}
+		if !op.matches(v) {
+			return fmt.Errorf("claim %q does not satisfy %q", claim, expr)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestValidateBoundClaimsOperators(t *testing.T) {
+	allClaims := map[string]interface{}{
+		"security_level": 3.0,
+		"auth_time":      json.Number("1699999999"),
+		"score":          0.75,
+		"name":           "jeff",
+	}
+
+	for _, tt := range []struct {
+		name      string
+		operators map[string]string
+		errMsg    string
+	}{
+		{"integer greater or equal", map[string]string{"security_level": ">=3"}, ""},
+		{"integer greater", map[string]string{"security_level": ">3"}, `does not satisfy ">3"`},
+		{"integer less than", map[string]string{"auth_time": "<1700000000"}, ""},
+		{"integer less than boundary", map[string]string{"auth_time": "<1699999999"}, "does not satisfy"},
+		{"integer less or equal boundary", map[string]string{"auth_time": "<=1699999999"}, ""},
+		{"integer equal", map[string]string{"security_level": "==3"}, ""},
+		{"float between", map[string]string{"score": "between:0.5,1"}, ""},
+		{"float between boundary", map[string]string{"score": "between:0.25,0.75"}, ""},
+		{"float outside between", map[string]string{"score": "between:0.8,1"}, "does not satisfy"},
+		{"float less than", map[string]string{"score": "< 0.8"}, ""},
+		{"several claims", map[string]string{"security_level": ">=2", "score": ">0.5"}, ""},
+		{"non-numeric claim", map[string]string{"name": ">=1"}, `claim "name" is not numeric`},
+		{"missing claim", map[string]string{"level": ">=1"}, `claim "level" is missing`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBoundClaimsOperators(hclog.NewNullLogger(), tt.operators, allClaims)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error %q, got: %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestParseClaimOperator_Invalid(t *testing.T) {
+	for _, expr := range []string{"3", "=>3", ">=three", "between:1", "between:10,1", "between:a,b"} {
+		if _, err := parseClaimOperator(expr); err == nil {
+			t.Fatalf("expected error for %q", expr)
+		}
+	}
+}
+
+func TestLogin_BoundClaimsOperators(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{
+		audience:    true,
+		boundClaims: true,
+		roleData: map[string]interface{}{
+			"bound_claims_operators": map[string]interface{}{
+				"security_level": ">=3",
+			},
+		},
+	})
+
+	login := func(color string, securityLevel interface{}) *logical.Response {
+		t.Helper()
+		cl := jwt.Claims{
+			Audience:  jwt.Audience{"https://vault.plugin.auth.jwt.test"},
+			Issuer:    "https://team-vault.auth0.com/",
+			Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+			NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
+			Expiry:    jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}
+		privateCl := map[string]interface{}{
+			"https://vault/user":   "jeff",
+			"https://vault/groups": []string{"foo"},
+			"color":                color,
+			"security_level":       securityLevel,
+		}
+		jwtData, _ := getTestJWT(t, ecdsaPrivKey, cl, privateCl)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role": "plugin-test",
+				"jwt":  jwtData,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := login("green", 3); resp.IsError() {
+		t.Fatalf("unexpected error: %v", resp.Error())
+	}
+	if resp := login("green", 2); !resp.IsError() || !strings.Contains(resp.Error().Error(), "does not satisfy") {
+		t.Fatalf("expected operator error, got: %v", resp)
+	}
+	if resp := login("green", "high"); !resp.IsError() || !strings.Contains(resp.Error().Error(), "is not numeric") {
+		t.Fatalf("expected non-numeric error, got: %v", resp)
+	}
+	if resp := login("blue", 4); !resp.IsError() || !strings.Contains(resp.Error().Error(), "does not match") {
+		t.Fatalf("expected bound claims error, got: %v", resp)
+	}
+}
+
+func TestPath_BoundClaimsOperators_Invalid(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for _, tt := range []struct {
+		data   map[string]interface{}
+		errMsg string
+	}{
+		{
+			map[string]interface{}{
+				"bound_claims":           map[string]interface{}{"security_level": "3"},
+				"bound_claims_operators": map[string]interface{}{"security_level": ">=3"},
+			},
+			`claim "security_level" can't be in both bound_claims and bound_claims_operators`,
+		},
+		{
+			map[string]interface{}{
+				"bound_claims_operators": map[string]interface{}{"security_level": "3"},
+			},
+			"invalid bound_claims_operators",
+		},
+	} {
+		data := map[string]interface{}{
+			"role_type":  "jwt",
+			"user_claim": "user",
+		}
+		for k, v := range tt.data {
+			data[k] = v
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/test",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.IsError() || !strings.Contains(resp.Error().Error(), tt.errMsg) {
+			t.Fatalf("expected error %q, got: %v", tt.errMsg, resp)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package jwtauth
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/helper/parseutil"
+	"github.com/hashicorp/vault/sdk/helper/policyutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	claimPoliciesMergeModeAppend  = "append"
+	claimPoliciesMergeModeReplace = "replace"
+)
+
+// parseClaimPoliciesMap parses the claim_policies_map role field, a map of
+// claims to maps of claim values to policies.
+func parseClaimPoliciesMap(raw map[string]interface{}) (map[string]map[string][]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	claimPolicies := make(map[string]map[string][]string, len(raw))
+	for claim, valuesRaw := range raw {
+		values, ok := valuesRaw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("claim %q must map claim values to policies", claim)
+		}
+
+		claimPolicies[claim] = make(map[string][]string, len(values))
+		for value, policiesRaw := range values {
+			policies, err := parseutil.ParseCommaStringSlice(policiesRaw)
+			if err != nil {
+				return nil, fmt.Errorf("invalid policies for value %q of claim %q: %s", value, claim, err)
+			}
+			claimPolicies[claim][value] = policyutil.SanitizePolicies(policies, false)
+		}
+	}
+
+	return claimPolicies, nil
+}
+
+// claimPolicies returns the policies mapped to the values of the role's
+// claim_policies_map claims. Values of list claims accumulate their
+// policies; values that aren't mapped are ignored.
+func (r *jwtRole) claimPolicies(logger log.Logger, allClaims map[string]interface{}) []string {
+	var policies []string
+
+	// Claims are visited in order so that the policies are stable.
+	claims := make([]string, 0, len(r.ClaimPoliciesMap))
+	for claim := range r.ClaimPoliciesMap {
+		claims = append(claims, claim)
+	}
+	sort.Strings(claims)
+
+	for _, claim := range claims {
+		value := getClaim(logger, allClaims, claim)
+		if value == nil {
+			continue
+		}
+		values, ok := normalizeList(value)
+		if !ok {
+			logger.Warn("claim_policies_map claim is not a string or list, ignoring it", "claim", claim)
+			continue
+		}
+		for _, v := range values {
+			if s, ok := v.(string); ok {
+				policies = append(policies, r.ClaimPoliciesMap[claim][s]...)
+			}
+		}
+	}
+
+	return policyutil.SanitizePolicies(policies, false)
+}
+
+// applyClaimPolicies adds the policies mapped from allClaims to auth, or
+// replaces the role's token_policies with them if the merge mode is
+// "replace". The token_policies are kept if no claim value is mapped.
+func (r *jwtRole) applyClaimPolicies(logger log.Logger, allClaims map[string]interface{}, auth *logical.Auth) {
+	policies := r.claimPolicies(logger, allClaims)
+	if len(policies) == 0 {
+		return
+	}
+
+	if r.ClaimPoliciesMergeMode == claimPoliciesMergeModeReplace {
+		auth.Policies = policies
+		return
+	}
+
+	// auth.Policies is the role's token_policies slice, so it is copied
+	// before being modified.
+	merged := make([]string, 0, len(auth.Policies)+len(policies))
+	merged = append(merged, auth.Policies...)
+	auth.Policies = policyutil.SanitizePolicies(append(merged, policies...), false)
+}
@@ -0,0 +1,134 @@
+package jwtauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestLogin_ClaimPoliciesMap(t *testing.T) {
+	claimPoliciesMap := map[string]interface{}{
+		"https://vault/groups": map[string]interface{}{
+			"engineering": []interface{}{"dev-policy"},
+			"ops":         "ops-policy,pager-policy",
+		},
+		"color": map[string]interface{}{
+			"green": []interface{}{"green-policy", "dev-policy"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		mergeMode string
+		groups    []string
+		expected  []string
+	}{
+		{"multiple matching values", "", []string{"engineering", "ops"}, []string{"dev-policy", "green-policy", "ops-policy", "pager-policy", "test"}},
+		{"replace", "replace", []string{"engineering", "ops"}, []string{"dev-policy", "green-policy", "ops-policy", "pager-policy"}},
+		{"unmapped value", "", []string{"sales"}, []string{"dev-policy", "green-policy", "test"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roleData := map[string]interface{}{
+				"claim_policies_map": claimPoliciesMap,
+			}
+			if tt.mergeMode != "" {
+				roleData["claim_policies_map_merge_mode"] = tt.mergeMode
+			}
+			b, storage := setupBackend(t, testConfig{
+				audience: true,
+				roleData: roleData,
+			})
+
+			cl := jwt.Claims{
+				Audience:  jwt.Audience{"https://vault.plugin.auth.jwt.test"},
+				Issuer:    "https://team-vault.auth0.com/",
+				Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+				NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
+				Expiry:    jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			}
+			privateCl := map[string]interface{}{
+				"https://vault/user":   "jeff",
+				"https://vault/groups": tt.groups,
+				"color":                "green",
+			}
+			jwtData, _ := getTestJWT(t, ecdsaPrivKey, cl, privateCl)
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "login",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"role": "plugin-test",
+					"jwt":  jwtData,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.IsError() {
+				t.Fatalf("unexpected error: %v", resp.Error())
+			}
+			if diff := deep.Equal(resp.Auth.Policies, tt.expected); diff != nil {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestRole_ApplyClaimPolicies_NoMatch(t *testing.T) {
+	role := &jwtRole{
+		ClaimPoliciesMap: map[string]map[string][]string{
+			"groups": {"engineering": {"dev-policy"}},
+		},
+		ClaimPoliciesMergeMode: claimPoliciesMergeModeReplace,
+	}
+	role.TokenPolicies = []string{"test"}
+
+	auth := &logical.Auth{}
+	role.PopulateTokenAuth(auth)
+	role.applyClaimPolicies(hclog.NewNullLogger(), map[string]interface{}{"groups": []interface{}{"sales"}}, auth)
+
+	if diff := deep.Equal(auth.Policies, []string{"test"}); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestPath_ClaimPoliciesMap_Invalid(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for _, tt := range []struct {
+		data   map[string]interface{}
+		errMsg string
+	}{
+		{map[string]interface{}{"claim_policies_map": map[string]interface{}{"groups": "dev-policy"}}, "invalid claim_policies_map"},
+		{map[string]interface{}{"claim_policies_map_merge_mode": "merge"}, "invalid 'claim_policies_map_merge_mode'"},
+	} {
+		data := map[string]interface{}{
+			"role_type":  "jwt",
+			"user_claim": "user",
+		}
+		for k, v := range tt.data {
+			data[k] = v
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/test",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.IsError() || !strings.Contains(resp.Error().Error(), tt.errMsg) {
+			t.Fatalf("expected error %q, got: %v", tt.errMsg, resp)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package jwtauth
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	claimTransformLowercase = "lowercase"
+	claimTransformUppercase = "uppercase"
+	claimTransformTrim      = "trim"
+
+	// claimTransformRegexpPrefix starts a "regexp:s/<pattern>/<replacement>/"
+	// substitution.
+	claimTransformRegexpPrefix = "regexp:s/"
+)
+
+// claimTransform transforms the value of a metadata field.
+type claimTransform func(string) string
+
+// parseClaimTransforms parses a claim_mappings_transform specification: one or
+// more transforms separated by "|", applied in order. A "/" in the pattern or
+// replacement of a regexp substitution is escaped as "\/".
+func parseClaimTransforms(spec string) ([]claimTransform, error) {
+	var transforms []claimTransform
+	rest := spec
+	for {
+		var transform claimTransform
+		if strings.HasPrefix(rest, claimTransformRegexpPrefix) {
+			pattern, replacement, remaining, err := parseRegexpSubstitution(rest[len(claimTransformRegexpPrefix):])
+			if err != nil {
+				return nil, fmt.Errorf("invalid transform %q: %s", spec, err)
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid transform %q: %s", spec, err)
+			}
+			transform = func(s string) string { return re.ReplaceAllString(s, replacement) }
+			rest = remaining
+		} else {
+			name := rest
+			if i := strings.Index(rest, "|"); i >= 0 {
+				name = rest[:i]
+			}
+			switch name {
+			case claimTransformLowercase:
+				transform = strings.ToLower
+			case claimTransformUppercase:
+				transform = strings.ToUpper
+			case claimTransformTrim:
+				transform = strings.TrimSpace
+			default:
+				return nil, fmt.Errorf("invalid transform %q: unknown transform %q", spec, name)
+			}
+			rest = rest[len(name):]
+		}
+		transforms = append(transforms, transform)
+
+		if rest == "" {
+			return transforms, nil
+		}
+		if !strings.HasPrefix(rest, "|") {
+			return nil, fmt.Errorf("invalid transform %q: expected \"|\" after %q", spec, strings.TrimSuffix(spec, rest))
+		}
+		rest = rest[1:]
+	}
+}
+
+// parseRegexpSubstitution parses the "<pattern>/<replacement>/" following
+// "regexp:s/", and returns the rest of s.
+func parseRegexpSubstitution(s string) (pattern, replacement, rest string, err error) {
+	var parts []string
+	var part strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == '/':
+			i++
+			part.WriteByte('/')
+		case s[i] == '/':
+			parts = append(parts, part.String())
+			part.Reset()
+			if len(parts) == 2 {
+				return parts[0], parts[1], s[i+1:], nil
+			}
+		default:
+			part.WriteByte(s[i])
+		}
+	}
+
+	return "", "", "", fmt.Errorf("expected %s<pattern>/<replacement>/", claimTransformRegexpPrefix)
+}
+
+// checkClaimTransforms checks the specifications of claim_mappings_transform.
+func checkClaimTransforms(specs map[string]string) error {
+	for metadataKey, spec := range specs {
+		if _, err := parseClaimTransforms(spec); err != nil {
+			return fmt.Errorf("error in claim_mappings_transform for metadata key %q: %s", metadataKey, err)
+		}
+	}
+	return nil
+}
+
+// applyClaimTransforms transforms the metadata fields that have a
+// specification in specs. Fields missing from metadata are skipped.
+func applyClaimTransforms(specs map[string]string, metadata map[string]string) error {
+	for metadataKey, spec := range specs {
+		value, ok := metadata[metadataKey]
+		if !ok {
+			continue
+		}
+
+		// Specifications are validated when the role is written.
+		transforms, err := parseClaimTransforms(spec)
+		if err != nil {
+			return err
+		}
+		for _, transform := range transforms {
+			value = transform(value)
+		}
+		metadata[metadataKey] = value
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package jwtauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestApplyClaimTransforms(t *testing.T) {
+	metadata := map[string]string{
+		"lower":   "Engineering",
+		"upper":   "Engineering",
+		"trim":    "  Engineering\n",
+		"regexp":  "grp_engineering",
+		"capture": "grp_engineering_eu",
+		"slash":   "org/engineering",
+		"chain":   "  GRP_Engineering ",
+		"other":   "Engineering",
+	}
+
+	err := applyClaimTransforms(map[string]string{
+		"lower":   "lowercase",
+		"upper":   "uppercase",
+		"trim":    "trim",
+		"regexp":  "regexp:s/^grp_//",
+		"capture": "regexp:s/^grp_([a-z]+)_([a-z]+)$/$2-$1/",
+		"slash":   `regexp:s/^org\///`,
+		"chain":   "trim|lowercase|regexp:s/^grp_//|uppercase",
+		"missing": "lowercase",
+	}, metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"lower":   "engineering",
+		"upper":   "ENGINEERING",
+		"trim":    "Engineering",
+		"regexp":  "engineering",
+		"capture": "eu-engineering",
+		"slash":   "engineering",
+		"chain":   "ENGINEERING",
+		"other":   "Engineering",
+	}
+	if diff := deep.Equal(metadata, expected); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestParseClaimTransforms_Invalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"titlecase",
+		"lowercase|",
+		"lowercase||trim",
+		"regexp:s/^grp_",
+		"regexp:s/^grp_/",
+		"regexp:s/[/x/",
+		"regexp:s/a/b/trim",
+		"regexp:/a/b/",
+	} {
+		if _, err := parseClaimTransforms(spec); err == nil {
+			t.Fatalf("expected error for %q", spec)
+		}
+	}
+}
+
+func TestRole_ClaimMappingsTransform(t *testing.T) {
+	b, storage := getBackend(t)
+
+	writeRole := func(transform map[string]string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/test",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role_type":                "jwt",
+				"user_claim":               "user",
+				"bound_subject":            "testsub",
+				"claim_mappings":           map[string]string{"groups": "team"},
+				"claim_mappings_transform": transform,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := writeRole(map[string]string{"team": "regexp:s/[unclosed//"})
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), `metadata key "team"`) {
+		t.Fatalf("expected invalid transform error, got: %v", resp)
+	}
+
+	if resp := writeRole(map[string]string{"team": "trim|lowercase"}); resp != nil && resp.IsError() {
+		t.Fatalf("unexpected error: %v", resp)
+	}
+}
+
+func TestLogin_ClaimMappingsTransform(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		roleData: map[string]interface{}{
+			"claim_mappings": map[string]string{
+				"team":     "team",
+				"division": "division",
+			},
+			"claim_mappings_transform": map[string]string{
+				"team":     "regexp:s/^grp_(.*)$/team-$1/|lowercase",
+				"division": "uppercase",
+			},
+		},
+	})
+
+	cl := jwt.Claims{
+		Audience:  jwt.Audience{"https://vault.plugin.auth.jwt.test"},
+		Issuer:    "https://team-vault.auth0.com/",
+		Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+		NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
+		Expiry:    jwt.NewNumericDate(time.Now().Add(5 * time.Second)),
+	}
+	privateCl := map[string]interface{}{
+		"https://vault/user":   "foobar",
+		"https://vault/groups": []string{"foo"},
+		"team":                 "grp_Engineering",
+	}
+	token, _ := getTestJWT(t, ecdsaPrivKey, cl, privateCl)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role": "plugin-test",
+			"jwt":  token,
+		},
+		Connection: &logical.Connection{
+			RemoteAddr: "127.0.0.1",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+
+	if team := resp.Auth.Metadata["team"]; team != "team-engineering" {
+		t.Fatalf("unexpected team metadata: %q", team)
+	}
+	// The division claim is missing, so nothing is transformed.
+	if division, ok := resp.Auth.Metadata["division"]; ok {
+		t.Fatalf("unexpected division metadata: %q", division)
+	}
+}
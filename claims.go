@@ -3,7 +3,9 @@ package jwtauth
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/ryanuber/go-glob"
@@ -14,13 +16,13 @@ import (
 
 // getClaim returns a claim value from allClaims given a provided claim string.
 // If this string is a valid JSONPointer, it will be interpreted as such to locate
-// the claim. Otherwise, the claim string will be used directly.
+// the claim. Otherwise, the claim string will be looked up with lookupClaim.
 func getClaim(logger log.Logger, allClaims map[string]interface{}, claim string) interface{} {
 	var val interface{}
 	var err error
 
 	if !strings.HasPrefix(claim, "/") {
-		val = allClaims[claim]
+		val, _ = lookupClaim(allClaims, claim)
 	} else {
 		val, err = pointerstructure.Get(allClaims, claim)
 		if err != nil {
@@ -45,6 +47,98 @@ func getClaim(logger log.Logger, allClaims map[string]interface{}, claim string)
 	return val
 }
 
+// lookupClaim returns the top-level claim named claim or, if there is none,
+// the claim at claim as a dotted path into nested claims. Claims that are
+// already used by their full name, such as "https://example.com/groups", are
+// therefore found as before.
+func lookupClaim(allClaims map[string]interface{}, claim string) (interface{}, bool) {
+	if val, ok := allClaims[claim]; ok {
+		return val, true
+	}
+	if !strings.Contains(claim, ".") {
+		return nil, false
+	}
+
+	val, err := claimAtPath(allClaims, claim)
+	if err != nil {
+		return nil, false
+	}
+	return val, true
+}
+
+// claimAtPath returns the claim at a dot-separated path into nested claims,
+// e.g. "realm_access.user.id". A dot that is part of a key is escaped with a
+// backslash, as in "a\.b", and numeric segments index into arrays, as in
+// "identities.0.userId".
+func claimAtPath(allClaims map[string]interface{}, path string) (interface{}, error) {
+	var value interface{} = allClaims
+	for _, key := range splitClaimPath(path) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[key]; !ok {
+				return nil, fmt.Errorf("claim %q not found in token", path)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("claim %q not found in token", path)
+			}
+			value = v[i]
+		default:
+			return nil, fmt.Errorf("claim %q not found in token", path)
+		}
+	}
+
+	return value, nil
+}
+
+// splitClaimPath splits a dotted claim path into its keys. "\." is a literal
+// dot and "\\" a literal backslash.
+func splitClaimPath(path string) []string {
+	var keys []string
+	var key strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; {
+		case c == '\\' && i+1 < len(path) && (path[i+1] == '.' || path[i+1] == '\\'):
+			i++
+			key.WriteByte(path[i])
+		case c == '.':
+			keys = append(keys, key.String())
+			key.Reset()
+		default:
+			key.WriteByte(c)
+		}
+	}
+	return append(keys, key.String())
+}
+
+// stripClaimNamespace returns allClaims with the top-level claims whose names
+// start with prefix also available without it, e.g. "https://example.com/groups"
+// as "groups" with the prefix "https://example.com/". A claim that already has
+// the stripped name is not replaced.
+func stripClaimNamespace(prefix string, allClaims map[string]interface{}) map[string]interface{} {
+	if prefix == "" {
+		return allClaims
+	}
+
+	stripped := make(map[string]interface{}, len(allClaims))
+	for k, v := range allClaims {
+		stripped[k] = v
+	}
+	for k, v := range allClaims {
+		name := strings.TrimPrefix(k, prefix)
+		if name == k || name == "" {
+			continue
+		}
+		if _, ok := allClaims[name]; !ok {
+			stripped[name] = v
+		}
+	}
+
+	return stripped
+}
+
 // extractMetadata builds a metadata map from a set of claims and claims mappings.
 // The referenced claims must be strings and the claims mappings must be of the structure:
 //
@@ -68,17 +162,43 @@ func extractMetadata(logger log.Logger, allClaims map[string]interface{}, claimM
 	return metadata, nil
 }
 
+// applyConditionalClaimMappings adds the source claims of mappings whose
+// condition claim has the expected value to metadata. Mappings are applied in
+// order; mappings whose condition doesn't match, or whose source claim is
+// absent, are skipped.
+func applyConditionalClaimMappings(logger log.Logger, allClaims map[string]interface{}, mappings []conditionalClaimMapping, metadata map[string]string) error {
+	for _, m := range mappings {
+		condition := getClaim(logger, allClaims, m.ConditionClaim)
+		if condition == nil || fmt.Sprintf("%v", condition) != m.ConditionValue {
+			continue
+		}
+
+		value := getClaim(logger, allClaims, m.SourceClaim)
+		if value == nil {
+			continue
+		}
+		strValue, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("error converting claim '%s' to string", m.SourceClaim)
+		}
+
+		metadata[m.TargetMetadata] = strValue
+	}
+
+	return nil
+}
+
 // validateAudience checks whether any of the audiences in audClaim match those
 // in boundAudiences. If strict is true and there are no bound audiences, then the
 // presence of any audience in the received claim is considered an error.
-func validateAudience(boundAudiences, audClaim []string, strict bool) error {
+func validateAudience(boundAudiencesType string, boundAudiences, audClaim []string, strict bool) error {
 	if strict && len(boundAudiences) == 0 && len(audClaim) > 0 {
 		return errors.New("audience claim found in JWT but no audiences bound to the role")
 	}
 
 	if len(boundAudiences) > 0 {
-		for _, v := range boundAudiences {
-			if strutil.StrListContains(audClaim, v) {
+		for _, v := range audClaim {
+			if audienceMatches(boundAudiencesType, boundAudiences, v) {
 				return nil
 			}
 		}
@@ -88,10 +208,156 @@ func validateAudience(boundAudiences, audClaim []string, strict bool) error {
 	return nil
 }
 
+// audienceMatches checks whether aud matches one of boundAudiences, which are
+// regular expressions if boundAudiencesType is "regexp" and exact values
+// otherwise. Patterns were compiled when the role was written.
+func audienceMatches(boundAudiencesType string, boundAudiences []string, aud string) bool {
+	if boundAudiencesType != boundClaimsTypeRegexp {
+		return strutil.StrListContains(boundAudiences, aud)
+	}
+
+	for _, pattern := range boundAudiences {
+		if re, err := compileBoundPattern(pattern); err == nil && re.MatchString(aud) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateAudienceStrict checks that every audience in audClaim is one of
+// boundAudiences.
+func validateAudienceStrict(boundAudiencesType string, boundAudiences, audClaim []string) error {
+	for _, v := range audClaim {
+		if !audienceMatches(boundAudiencesType, boundAudiences, v) {
+			return fmt.Errorf("aud claim contains unexpected audience %q", v)
+		}
+	}
+
+	return nil
+}
+
+// validateFederationAudience checks that audClaim contains the mount's
+// oidc_federation_audience, if one is configured.
+func validateFederationAudience(config *jwtConfig, audClaim []string) error {
+	if config.OIDCFederationAudience == "" {
+		return nil
+	}
+	if !strutil.StrListContains(audClaim, config.OIDCFederationAudience) {
+		return errors.New("aud claim does not match oidc_federation_audience")
+	}
+
+	return nil
+}
+
+// validateIssuedAt checks the iat claim against the role's verify_iat and
+// reject_past_iat_threshold settings. A zero iat is treated as absent.
+func validateIssuedAt(role *jwtRole, iat time.Time, leeway time.Duration) error {
+	if !role.VerifyIssuedAt && role.RejectPastIATThreshold == 0 {
+		return nil
+	}
+
+	if iat.IsZero() || iat.Unix() == 0 {
+		if role.RejectPastIATThreshold > 0 {
+			return errors.New("iat claim is required when reject_past_iat_threshold is set")
+		}
+		return nil
+	}
+
+	now := time.Now()
+	if role.VerifyIssuedAt && iat.After(now.Add(leeway)) {
+		return errors.New("iat claim is in the future")
+	}
+
+	if role.RejectPastIATThreshold > 0 && iat.Before(now.Add(-role.RejectPastIATThreshold)) {
+		return fmt.Errorf("iat claim is older than %s", role.RejectPastIATThreshold)
+	}
+
+	return nil
+}
+
+// errAuthTimeExceeded is returned by validateAuthTime if the user
+// authenticated with the provider more than max_age ago.
+var errAuthTimeExceeded = errors.New("auth_time claim is older than max_age")
+
+// validateAuthTime checks that the auth_time claim is present and no older
+// than maxAge at now, allowing for leeway.
+func validateAuthTime(allClaims map[string]interface{}, now time.Time, maxAge, leeway time.Duration) error {
+	authTime, ok := numericClaim(allClaims["auth_time"])
+	if !ok {
+		return errors.New("auth_time claim is required when max_age is set")
+	}
+
+	if now.Unix()-int64(authTime) > int64((maxAge + leeway).Seconds()) {
+		return errAuthTimeExceeded
+	}
+
+	return nil
+}
+
+const (
+	acrValuesSatisfactionAny = "any"
+	acrValuesSatisfactionAll = "all"
+)
+
+// validateACR checks that the acr claim matches boundACRValues. With
+// acrValuesSatisfactionAll, all of the values must be present as
+// space-separated values of the claim, otherwise one of them is enough.
+func validateACR(allClaims map[string]interface{}, boundACRValues []string, satisfaction string) error {
+	acr, ok := allClaims["acr"].(string)
+	if !ok || acr == "" {
+		return errors.New("acr claim is required when bound_acr_values is set")
+	}
+
+	values := strings.Fields(acr)
+	if satisfaction == acrValuesSatisfactionAll {
+		for _, required := range boundACRValues {
+			if !strutil.StrListContains(values, required) {
+				return fmt.Errorf("acr claim %q does not include %q", acr, required)
+			}
+		}
+		return nil
+	}
+
+	for _, allowed := range boundACRValues {
+		if strutil.StrListContains(values, allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("acr claim %q does not match any of the bound_acr_values", acr)
+}
+
+// validateRequiredClaims checks that each of the required claims is present
+// and neither null nor an empty string. Nested claims are given as JSON
+// pointers, e.g. "/org/id".
+func validateRequiredClaims(logger log.Logger, allClaims map[string]interface{}, required []string) error {
+	for _, claim := range required {
+		if val := getClaim(logger, allClaims, claim); val == nil || val == "" {
+			return fmt.Errorf("required claim '%s' is missing", claim)
+		}
+	}
+	return nil
+}
+
+// validateEmailVerified checks that the email_verified claim is present and
+// is the boolean true.
+func validateEmailVerified(allClaims map[string]interface{}) error {
+	verified, ok := allClaims["email_verified"].(bool)
+	if !ok {
+		return errors.New("email_verified claim is missing or not a boolean")
+	}
+	if !verified {
+		return errors.New("email address has not been verified")
+	}
+
+	return nil
+}
+
 // validateBoundClaims checks that all of the claim:value requirements in boundClaims are
 // met in allClaims.
 func validateBoundClaims(logger log.Logger, boundClaimsType string, boundClaims, allClaims map[string]interface{}) error {
 	useGlobs := boundClaimsType == boundClaimsTypeGlob
+	useRegexps := boundClaimsType == boundClaimsTypeRegexp
 
 	for claim, expValue := range boundClaims {
 		actValue := getClaim(logger, allClaims, claim)
@@ -115,7 +381,19 @@ func validateBoundClaims(logger log.Logger, boundClaimsType string, boundClaims,
 
 	scan:
 		for _, v := range expVals {
-			if useGlobs {
+			if useRegexps {
+				// Patterns are compiled when the role is written.
+				re, err := compileBoundPattern(v.(string))
+				if err != nil {
+					return fmt.Errorf("invalid bound claim pattern %q: %s", v, err)
+				}
+				for _, av := range actVals {
+					if avs, ok := av.(string); ok && re.MatchString(avs) {
+						found = true
+						break scan
+					}
+				}
+			} else if useGlobs {
 				vs := v.(string)
 				for _, av := range actVals {
 					if avs, ok := av.(string); ok {
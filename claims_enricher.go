@@ -0,0 +1,66 @@
+package jwtauth
+
+import (
+	"context"
+	"fmt"
+	"plugin"
+
+	"github.com/hashicorp/errwrap"
+)
+
+// claimsEnricherSymbol is the name of the variable looked up in a claims
+// enrichment plugin.
+const claimsEnricherSymbol = "Enricher"
+
+// ClaimsEnricher transforms the verified claims of a token before they are
+// validated against the role. It is implemented by the exported Enricher
+// variable of a Go plugin configured with oidc_claims_enrichment_plugin. The
+// returned map replaces the original claims.
+type ClaimsEnricher interface {
+	Enrich(ctx context.Context, rawClaims map[string]interface{}) (map[string]interface{}, error)
+}
+
+// loadClaimsEnricher opens the Go plugin at path and returns its Enricher, or
+// nil if path is empty. Go plugins must be built with the same Go version and
+// dependency versions as this plugin.
+func loadClaimsEnricher(path string) (ClaimsEnricher, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, errwrap.Wrapf("error opening claims enrichment plugin: {{err}}", err)
+	}
+
+	sym, err := p.Lookup(claimsEnricherSymbol)
+	if err != nil {
+		return nil, errwrap.Wrapf("error loading claims enrichment plugin: {{err}}", err)
+	}
+
+	switch enricher := sym.(type) {
+	case ClaimsEnricher:
+		return enricher, nil
+	case *ClaimsEnricher:
+		return *enricher, nil
+	default:
+		return nil, fmt.Errorf("claims enrichment plugin symbol %q does not implement ClaimsEnricher", claimsEnricherSymbol)
+	}
+}
+
+// enrichClaims runs the configured claims enricher, if any.
+func enrichClaims(ctx context.Context, config *jwtConfig, allClaims map[string]interface{}) (map[string]interface{}, error) {
+	if config.enricher == nil {
+		return allClaims, nil
+	}
+
+	enriched, err := config.enricher.Enrich(ctx, allClaims)
+	if err != nil {
+		return nil, err
+	}
+	if enriched == nil {
+		return nil, fmt.Errorf("claims enrichment plugin returned no claims")
+	}
+
+	return enriched, nil
+}
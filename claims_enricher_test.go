@@ -0,0 +1,97 @@
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+type testEnricher struct {
+	err error
+}
+
+func (e testEnricher) Enrich(ctx context.Context, rawClaims map[string]interface{}) (map[string]interface{}, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	enriched := make(map[string]interface{}, len(rawClaims)+1)
+	for k, v := range rawClaims {
+		enriched[k] = v
+	}
+	enriched["department"] = "engineering"
+	return enriched, nil
+}
+
+func TestLogin_ClaimsEnricher(t *testing.T) {
+	tests := map[string]struct {
+		enricher ClaimsEnricher
+		errMsg   string
+	}{
+		"enriched": {enricher: testEnricher{}},
+		"error":    {enricher: testEnricher{err: errors.New("lookup failed")}, errMsg: "error enriching claims: lookup failed"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := testConfig{
+				audience: true,
+				roleData: map[string]interface{}{
+					"claim_mappings": map[string]string{
+						"department": "dept",
+					},
+				},
+			}
+			b, storage := setupBackend(t, cfg)
+
+			config, err := b.Backend.(*jwtAuthBackend).config(context.Background(), storage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.enricher = tt.enricher
+
+			req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.errMsg != "" {
+				if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), tt.errMsg) {
+					t.Fatalf("expected error %q, got: %v", tt.errMsg, resp)
+				}
+				return
+			}
+			if resp.IsError() {
+				t.Fatal(resp.Error())
+			}
+			if dept := resp.Auth.Alias.Metadata["dept"]; dept != "engineering" {
+				t.Fatalf("unexpected metadata: %v", resp.Auth.Alias.Metadata)
+			}
+		})
+	}
+}
+
+func TestConfig_ClaimsEnrichmentPlugin(t *testing.T) {
+	b, storage := getBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"jwt_validation_pubkeys":        ecdsaPubKey,
+			"oidc_claims_enrichment_plugin": "/nonexistent/enricher.so",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "claims enrichment plugin") {
+		t.Fatalf("expected plugin load error, got: %v", resp)
+	}
+}
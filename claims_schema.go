@@ -0,0 +1,93 @@
+package jwtauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// claimsSchemas holds the compiled claims_schema documents, keyed by the
+// document. Like boundPatterns, schemas are compiled when a role is written,
+// and schemas of roles written before a restart or on another node are
+// compiled by their first login.
+var claimsSchemas sync.Map
+
+// parseClaimsSchema compiles a JSON Schema document. References must point
+// into the schema itself: the loader would otherwise fetch remote URLs or
+// read local files when a role is written.
+func parseClaimsSchema(raw string) (*gojsonschema.Schema, error) {
+	if schema, ok := claimsSchemas.Load(raw); ok {
+		return schema.(*gojsonschema.Schema), nil
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+		return nil, fmt.Errorf("error parsing claims_schema: %s", err)
+	}
+
+	if err := checkSchemaRefs(doc); err != nil {
+		return nil, err
+	}
+
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(doc))
+	if err != nil {
+		return nil, fmt.Errorf("error compiling claims_schema: %s", err)
+	}
+	claimsSchemas.Store(raw, schema)
+
+	return schema, nil
+}
+
+// checkSchemaRefs rejects any $ref in doc that isn't a fragment of the
+// schema itself.
+func checkSchemaRefs(doc interface{}) error {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			if ref, ok := val.(string); ok && k == "$ref" && !strings.HasPrefix(ref, "#") {
+				return fmt.Errorf("claims_schema can only reference itself, got $ref %q", ref)
+			}
+			if err := checkSchemaRefs(val); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, val := range v {
+			if err := checkSchemaRefs(val); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateClaimsSchema validates allClaims against the role's claims_schema,
+// if one is set.
+func validateClaimsSchema(role *jwtRole, allClaims map[string]interface{}) error {
+	if role.ClaimsSchema == "" {
+		return nil
+	}
+
+	schema, err := parseClaimsSchema(role.ClaimsSchema)
+	if err != nil {
+		return err
+	}
+
+	result, err := schema.Validate(gojsonschema.NewGoLoader(allClaims))
+	if err != nil {
+		return fmt.Errorf("error validating claims against claims_schema: %s", err)
+	}
+	if !result.Valid() {
+		var errs []string
+		for _, e := range result.Errors() {
+			errs = append(errs, e.String())
+		}
+		return fmt.Errorf("claims do not match claims_schema: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
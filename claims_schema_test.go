@@ -0,0 +1,167 @@
+package jwtauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestClaimsSchema(t *testing.T) {
+	role := &jwtRole{
+		ClaimsSchema: `{
+			"type": "object",
+			"required": ["sub", "groups"],
+			"properties": {
+				"sub": {"type": "string", "pattern": "^user-", "maxLength": 12},
+				"groups": {"type": "array", "items": {"type": "string"}, "minItems": 1},
+				"level": {"type": "integer", "minimum": 1, "maximum": 3},
+				"env": {"enum": ["dev", "prod"]},
+				"org": {"oneOf": [{"$ref": "#/definitions/orgID"}, {"type": "null"}]}
+			},
+			"definitions": {
+				"orgID": {"type": "string", "format": "uuid"}
+			}
+		}`,
+	}
+
+	tests := []struct {
+		claims map[string]interface{}
+		errStr string
+	}{
+		{
+			claims: map[string]interface{}{"sub": "user-1", "groups": []interface{}{"a"}, "level": 2.0, "env": "dev"},
+		},
+		{
+			claims: map[string]interface{}{"sub": "user-1", "groups": []interface{}{"a"}, "org": "6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+		},
+		{
+			claims: map[string]interface{}{"sub": "user-1"},
+			errStr: "groups is required",
+		},
+		{
+			claims: map[string]interface{}{"sub": "admin", "groups": []interface{}{"a"}},
+			errStr: "sub: Does not match pattern",
+		},
+		{
+			claims: map[string]interface{}{"sub": "user-1", "groups": []interface{}{"a", 1.0}},
+			errStr: "groups.1: Invalid type",
+		},
+		{
+			claims: map[string]interface{}{"sub": "user-1", "groups": []interface{}{}},
+			errStr: "groups: Array must have at least 1 items",
+		},
+		{
+			claims: map[string]interface{}{"sub": "user-1", "groups": []interface{}{"a"}, "level": 1.5},
+			errStr: "level: Invalid type",
+		},
+		{
+			claims: map[string]interface{}{"sub": "user-1", "groups": []interface{}{"a"}, "level": 4.0},
+			errStr: "level: Must be less than or equal to 3",
+		},
+		{
+			claims: map[string]interface{}{"sub": "user-1", "groups": []interface{}{"a"}, "env": "test"},
+			errStr: "env: env must be one of the following",
+		},
+		{
+			claims: map[string]interface{}{"sub": "user-1", "groups": []interface{}{"a"}, "org": "acme"},
+			errStr: "org: Must validate one and only one schema",
+		},
+	}
+
+	for i, tt := range tests {
+		err := validateClaimsSchema(role, tt.claims)
+		switch {
+		case tt.errStr == "" && err != nil:
+			t.Fatalf("test %d: unexpected error: %v", i, err)
+		case tt.errStr != "" && (err == nil || !strings.Contains(err.Error(), tt.errStr)):
+			t.Fatalf("test %d: expected error containing %q, got: %v", i, tt.errStr, err)
+		}
+	}
+}
+
+func TestClaimsSchema_Parse(t *testing.T) {
+	tests := map[string]string{
+		`not json`: "error parsing claims_schema",
+		`{"properties": {"sub": {"pattern": "("}}}`:                       "error compiling claims_schema",
+		`{"properties": {"sub": {"type": {}}}}`:                           "error compiling claims_schema",
+		`{"properties": {"sub": {"$ref": "#/definitions/missing"}}}`:      "error compiling claims_schema",
+		`{"properties": {"sub": {"$ref": "https://example.com/s.json"}}}`: "can only reference itself",
+		`{"allOf": [{"$ref": "file:///etc/passwd"}]}`:                     "can only reference itself",
+	}
+
+	for raw, errStr := range tests {
+		if _, err := parseClaimsSchema(raw); err == nil || !strings.Contains(err.Error(), errStr) {
+			t.Fatalf("%s: expected error containing %q, got: %v", raw, errStr, err)
+		}
+	}
+}
+
+func TestLogin_ClaimsSchema(t *testing.T) {
+	for schema, allowed := range map[string]bool{
+		`{"properties": {"color": {"enum": ["green"]}}}`: true,
+		`{"properties": {"color": {"enum": ["blue"]}}}`:  false,
+	} {
+		b, storage := setupBackend(t, testConfig{
+			audience: true,
+			roleData: map[string]interface{}{
+				"claims_schema": schema,
+			},
+		})
+		req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed && (resp == nil || resp.IsError()) {
+			t.Fatalf("expected successful login, got: %v", resp)
+		}
+		if !allowed && (resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "claims_schema")) {
+			t.Fatalf("expected claims_schema error, got: %v", resp)
+		}
+	}
+}
+
+func TestClaimsSchema_CompiledAtRoleWrite(t *testing.T) {
+	b, storage := getBackend(t)
+
+	const schema = `{"properties": {"sub": {"const": "compiled-at-write"}}}`
+	if _, ok := claimsSchemas.Load(schema); ok {
+		t.Fatalf("schema %q already compiled", schema)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_type":       "jwt",
+			"user_claim":      "sub",
+			"bound_audiences": "vault",
+			"claims_schema":   schema,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+
+	compiled, ok := claimsSchemas.Load(schema)
+	if !ok {
+		t.Fatal("expected the schema to be compiled when the role is written")
+	}
+
+	// Logins reuse the compiled schema.
+	if s, err := parseClaimsSchema(schema); err != nil || s != compiled {
+		t.Fatalf("expected the compiled schema to be reused, got %p, %v", s, err)
+	}
+	if err := validateClaimsSchema(&jwtRole{ClaimsSchema: schema}, map[string]interface{}{"sub": "compiled-at-write"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := claimsSchemas.Load(`not json`); ok {
+		t.Fatal("expected invalid schemas not to be stored")
+	}
+}
@@ -2,8 +2,10 @@ package jwtauth
 
 import (
 	"encoding/json"
+	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/go-test/deep"
 	"github.com/hashicorp/go-hclog"
@@ -23,6 +25,20 @@ func TestGetClaim(t *testing.T) {
 			"f": {
 				"g": "zebra"
 			}
+		},
+		"resource_access": {
+			"vault": {
+				"roles": ["admin", "reader"]
+			}
+		},
+		"identities": [
+			{"userId": "u-1"},
+			{"userId": "u-2"}
+		],
+		"x.y": "literal",
+		"x": {
+			"y": "nested",
+			"z.w": "escaped"
 		}
 	}`
 	var claims map[string]interface{}
@@ -44,6 +60,18 @@ func TestGetClaim(t *testing.T) {
 		{"/c/f/h", nil},
 		{"", nil},
 		{"\\", nil},
+		{"c.d", float64(95)},
+		{"c.f.g", "zebra"},
+		{"c.e", []interface{}{"dog", "cat", "bird"}},
+		{"resource_access.vault.roles", []interface{}{"admin", "reader"}},
+		{"resource_access.other.roles", nil},
+		{"c.f.g.h", nil},
+		{"identities.1.userId", "u-2"},
+		{"identities.2.userId", nil},
+		{"identities.first.userId", nil},
+		{"x.y", "literal"},
+		{"x.z\\.w", "escaped"},
+		{"x.z.w", nil},
 	}
 
 	for _, test := range tests {
@@ -171,7 +199,7 @@ func TestValidateAudience(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		err := validateAudience(test.boundAudiences, test.audience, test.strict)
+		err := validateAudience(boundClaimsTypeString, test.boundAudiences, test.audience, test.strict)
 		if test.errExpected != (err != nil) {
 			t.Fatalf("unexpected error result: boundAudiences %v, audience %v, strict %t, err: %v",
 				test.boundAudiences, test.audience, test.strict, err)
@@ -179,6 +207,40 @@ func TestValidateAudience(t *testing.T) {
 	}
 }
 
+func TestValidateIssuedAt(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name        string
+		verifyIAT   bool
+		threshold   time.Duration
+		iat         time.Time
+		errExpected bool
+	}{
+		{"disabled, future iat", false, 0, now.Add(time.Hour), false},
+		{"future iat", true, 0, now.Add(time.Hour), true},
+		{"future iat within leeway", true, 0, now.Add(30 * time.Second), false},
+		{"past iat", true, 0, now.Add(-time.Hour), false},
+		{"missing iat", true, 0, time.Time{}, false},
+		{"iat within threshold", false, time.Hour, now.Add(-30 * time.Minute), false},
+		{"iat older than threshold", false, time.Hour, now.Add(-2 * time.Hour), true},
+		{"missing iat with threshold", false, time.Hour, time.Time{}, true},
+		{"missing iat with threshold, unix epoch", false, time.Hour, time.Unix(0, 0), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role := &jwtRole{
+				VerifyIssuedAt:         tt.verifyIAT,
+				RejectPastIATThreshold: tt.threshold,
+			}
+			err := validateIssuedAt(role, tt.iat, time.Minute)
+			if tt.errExpected != (err != nil) {
+				t.Fatalf("unexpected error result: %v", err)
+			}
+		})
+	}
+}
+
 func TestValidateBoundClaims(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -509,6 +571,76 @@ func TestValidateBoundClaims(t *testing.T) {
 			},
 			errExpected: true,
 		},
+		{
+			name:            "matching regexp",
+			boundClaimsType: "regexp",
+			boundClaims: map[string]interface{}{
+				"department": `^eng-team-\d+$`,
+			},
+			allClaims: map[string]interface{}{
+				"department": "eng-team-42",
+			},
+			errExpected: false,
+		},
+		{
+			name:            "not matching regexp",
+			boundClaimsType: "regexp",
+			boundClaims: map[string]interface{}{
+				"department": `^eng-team-\d+$`,
+			},
+			allClaims: map[string]interface{}{
+				"department": "eng-team-x",
+			},
+			errExpected: true,
+		},
+		{
+			name:            "matching regexp in nested claim",
+			boundClaimsType: "regexp",
+			boundClaims: map[string]interface{}{
+				"/org/department": `^eng-`,
+			},
+			allClaims: map[string]interface{}{
+				"org": map[string]interface{}{
+					"department": "eng-team-42",
+				},
+			},
+			errExpected: false,
+		},
+		{
+			name:            "matching regexp against list claim",
+			boundClaimsType: "regexp",
+			boundClaims: map[string]interface{}{
+				"groups": `^admins-`,
+			},
+			allClaims: map[string]interface{}{
+				"groups": []interface{}{"users", "admins-eu"},
+			},
+			errExpected: false,
+		},
+		{
+			name:            "not matching any regexp in list",
+			boundClaimsType: "regexp",
+			boundClaims: map[string]interface{}{
+				"groups": []interface{}{`^admins-`, `^ops$`},
+			},
+			allClaims: map[string]interface{}{
+				"groups": []interface{}{"users", "operators"},
+			},
+			errExpected: true,
+		},
+		{
+			name:            "matching glob in nested claim",
+			boundClaimsType: "glob",
+			boundClaims: map[string]interface{}{
+				"/org/department": "eng-team-*",
+			},
+			allClaims: map[string]interface{}{
+				"org": map[string]interface{}{
+					"department": "eng-team-42",
+				},
+			},
+			errExpected: false,
+		},
 	}
 	for _, tt := range tests {
 		if err := validateBoundClaims(hclog.NewNullLogger(), tt.boundClaimsType, tt.boundClaims, tt.allClaims); (err != nil) != tt.errExpected {
@@ -569,3 +701,301 @@ func Test_normalizeList(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateEmailVerified(t *testing.T) {
+	tests := []struct {
+		name        string
+		claims      map[string]interface{}
+		errExpected bool
+	}{
+		{"verified", map[string]interface{}{"email_verified": true}, false},
+		{"not verified", map[string]interface{}{"email_verified": false}, true},
+		{"missing", map[string]interface{}{}, true},
+		{"string value", map[string]interface{}{"email_verified": "true"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateEmailVerified(tt.claims)
+			if tt.errExpected != (err != nil) {
+				t.Fatalf("unexpected error result: %v", err)
+			}
+		})
+	}
+}
+
+func TestApplyConditionalClaimMappings(t *testing.T) {
+	mappings := []conditionalClaimMapping{
+		{ConditionClaim: "user_type", ConditionValue: "employee", SourceClaim: "employee_id", TargetMetadata: "person_id"},
+		{ConditionClaim: "user_type", ConditionValue: "contractor", SourceClaim: "vendor_id", TargetMetadata: "person_id"},
+		{ConditionClaim: "/org/tier", ConditionValue: "2", SourceClaim: "/org/name", TargetMetadata: "org"},
+		{ConditionClaim: "missing", ConditionValue: "x", SourceClaim: "employee_id", TargetMetadata: "never"},
+	}
+
+	tests := []struct {
+		name     string
+		claims   map[string]interface{}
+		expected map[string]string
+	}{
+		{
+			"employee",
+			map[string]interface{}{
+				"user_type":   "employee",
+				"employee_id": "e123",
+				"vendor_id":   "v456",
+				"org":         map[string]interface{}{"tier": float64(2), "name": "acme"},
+			},
+			map[string]string{"person_id": "e123", "org": "acme"},
+		},
+		{
+			"contractor",
+			map[string]interface{}{
+				"user_type": "contractor",
+				"vendor_id": "v456",
+			},
+			map[string]string{"person_id": "v456"},
+		},
+		{
+			"no matches",
+			map[string]interface{}{
+				"user_type":   "guest",
+				"employee_id": "e123",
+			},
+			map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata := map[string]string{}
+			if err := applyConditionalClaimMappings(hclog.NewNullLogger(), tt.claims, mappings, metadata); err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(metadata, tt.expected); diff != nil {
+				t.Fatal(diff)
+			}
+		})
+	}
+
+	err := applyConditionalClaimMappings(hclog.NewNullLogger(), map[string]interface{}{
+		"user_type":   "employee",
+		"employee_id": 123,
+	}, mappings, map[string]string{})
+	if err == nil {
+		t.Fatal("expected error converting non-string claim")
+	}
+}
+
+func TestValidateAudienceStrict(t *testing.T) {
+	tests := []struct {
+		boundAudiences []string
+		audClaim       []string
+		errExpected    bool
+	}{
+		{[]string{"vault"}, []string{"vault"}, false},
+		{[]string{"vault", "vault-dr"}, []string{"vault"}, false},
+		{[]string{"vault", "vault-dr"}, []string{"vault-dr", "vault"}, false},
+		{[]string{"vault"}, []string{"vault", "other-service"}, true},
+		{[]string{"vault"}, []string{"other-service"}, true},
+	}
+
+	for i, tt := range tests {
+		err := validateAudienceStrict(boundClaimsTypeString, tt.boundAudiences, tt.audClaim)
+		if tt.errExpected != (err != nil) {
+			t.Fatalf("case %d: unexpected error result: %v", i, err)
+		}
+	}
+}
+
+func TestStripClaimNamespace(t *testing.T) {
+	allClaims := map[string]interface{}{
+		"sub":                      "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+		"https://example.com/":     "empty name",
+		"https://example.com/team": "infra",
+		"https://example.com/sub":  "not the subject",
+		"https://other.com/team":   "other",
+	}
+
+	if stripped := stripClaimNamespace("", allClaims); !reflect.DeepEqual(stripped, allClaims) {
+		t.Fatalf("expected claims to be unchanged, got: %v", stripped)
+	}
+
+	stripped := stripClaimNamespace("https://example.com/", allClaims)
+	expected := map[string]interface{}{
+		"sub":                      "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+		"team":                     "infra",
+		"https://example.com/":     "empty name",
+		"https://example.com/team": "infra",
+		"https://example.com/sub":  "not the subject",
+		"https://other.com/team":   "other",
+	}
+	if diff := deep.Equal(stripped, expected); diff != nil {
+		t.Fatal(diff)
+	}
+	if _, ok := allClaims["team"]; ok {
+		t.Fatal("expected the original claims not to be modified")
+	}
+}
+
+func TestValidateAudience_Regexp(t *testing.T) {
+	patterns := []string{`^https://vault\.corp\.example\.com/v1/[a-z0-9-]+$`, `^vault-dr$`}
+
+	tests := []struct {
+		name        string
+		audClaim    []string
+		strict      bool
+		errExpected bool
+	}{
+		{"single match", []string{"https://vault.corp.example.com/v1/some-random-id"}, false, false},
+		{"no match", []string{"https://other.example.com/v1/some-random-id"}, false, true},
+		{"unanchored value", []string{"https://vault.corp.example.com/v1/some-random-id/extra"}, false, true},
+		{"multiple values, one matching", []string{"other-service", "vault-dr"}, false, false},
+		{"multiple values, none matching", []string{"other-service", "vault"}, false, true},
+		{"multiple values, all matching", []string{"vault-dr", "https://vault.corp.example.com/v1/abc"}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAudience(boundClaimsTypeRegexp, patterns, tt.audClaim, tt.strict)
+			if tt.errExpected != (err != nil) {
+				t.Fatalf("unexpected error result: %v", err)
+			}
+		})
+	}
+
+	// A pattern matching some but not all of the audiences passes unless
+	// oidc_audience_strict is set.
+	audClaim := []string{"https://vault.corp.example.com/v1/abc", "other-service"}
+	if err := validateAudience(boundClaimsTypeRegexp, patterns, audClaim, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := validateAudienceStrict(boundClaimsTypeRegexp, patterns, audClaim); err == nil {
+		t.Fatal("expected error for audience not matching any pattern")
+	}
+	if err := validateAudienceStrict(boundClaimsTypeRegexp, patterns, audClaim[:1]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Patterns are only interpreted with the regexp type.
+	if err := validateAudience(boundClaimsTypeString, patterns, []string{"vault-dr"}, false); err == nil {
+		t.Fatal("expected string bound audiences to be matched exactly")
+	}
+}
+
+func TestValidateAuthTime(t *testing.T) {
+	now := time.Now()
+	maxAge := 5 * time.Minute
+
+	tests := map[string]struct {
+		claims map[string]interface{}
+		leeway time.Duration
+		err    error
+	}{
+		"at max_age": {
+			claims: map[string]interface{}{"auth_time": float64(now.Add(-maxAge).Unix())},
+		},
+		"one second over": {
+			claims: map[string]interface{}{"auth_time": float64(now.Add(-maxAge - time.Second).Unix())},
+			err:    errAuthTimeExceeded,
+		},
+		"within leeway": {
+			claims: map[string]interface{}{"auth_time": float64(now.Add(-maxAge - time.Second).Unix())},
+			leeway: time.Second,
+		},
+		"json number": {
+			claims: map[string]interface{}{"auth_time": json.Number(fmt.Sprint(now.Unix()))},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := validateAuthTime(tt.claims, now, maxAge, tt.leeway); err != tt.err {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+
+	for _, claims := range []map[string]interface{}{{}, {"auth_time": "yesterday"}} {
+		err := validateAuthTime(claims, now, maxAge, 0)
+		if err == nil || err == errAuthTimeExceeded {
+			t.Fatalf("expected missing auth_time error for %v, got %v", claims, err)
+		}
+	}
+}
+
+func TestValidateACR(t *testing.T) {
+	bound := []string{"urn:mfa", "urn:pwd"}
+
+	tests := map[string]struct {
+		acr          interface{}
+		satisfaction string
+		success      bool
+	}{
+		"any match":              {acr: "urn:mfa", satisfaction: acrValuesSatisfactionAny, success: true},
+		"any no match":           {acr: "urn:none", satisfaction: acrValuesSatisfactionAny},
+		"any partial value":      {acr: "urn:mf", satisfaction: acrValuesSatisfactionAny},
+		"all match":              {acr: "urn:mfa  urn:hwk urn:pwd", satisfaction: acrValuesSatisfactionAll, success: true},
+		"all missing one":        {acr: "urn:mfa urn:hwk", satisfaction: acrValuesSatisfactionAll},
+		"missing acr":            {satisfaction: acrValuesSatisfactionAny},
+		"empty acr":              {acr: "", satisfaction: acrValuesSatisfactionAll},
+		"acr is not a string":    {acr: []interface{}{"urn:mfa"}, satisfaction: acrValuesSatisfactionAny},
+		"space-separated in any": {acr: "urn:hwk urn:pwd", satisfaction: acrValuesSatisfactionAny, success: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			claims := map[string]interface{}{}
+			if tt.acr != nil {
+				claims["acr"] = tt.acr
+			}
+
+			err := validateACR(claims, bound, tt.satisfaction)
+			if tt.success && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.success && err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestValidateRequiredClaims(t *testing.T) {
+	allClaims := map[string]interface{}{
+		"jti":     "abc123",
+		"org_id":  "",
+		"tenant":  nil,
+		"groups":  []interface{}{"a"},
+		"enabled": false,
+		"org": map[string]interface{}{
+			"id":   "org-1",
+			"name": "",
+		},
+	}
+
+	tests := []struct {
+		required []string
+		missing  string
+	}{
+		{nil, ""},
+		{[]string{"jti", "groups", "enabled", "/org/id"}, ""},
+		{[]string{"jti", "tenant"}, "tenant"},
+		{[]string{"org_id"}, "org_id"},
+		{[]string{"/org/name"}, "/org/name"},
+		{[]string{"/org/unit/id"}, "/org/unit/id"},
+		{[]string{"exp"}, "exp"},
+	}
+
+	for _, tt := range tests {
+		err := validateRequiredClaims(hclog.NewNullLogger(), allClaims, tt.required)
+		if tt.missing == "" {
+			if err != nil {
+				t.Fatalf("unexpected error for %v: %v", tt.required, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != fmt.Sprintf("required claim '%s' is missing", tt.missing) {
+			t.Fatalf("expected missing %q for %v, got: %v", tt.missing, tt.required, err)
+		}
+	}
+}
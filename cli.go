@@ -1,8 +1,10 @@
 package jwtauth
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -12,6 +14,7 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/api"
 )
@@ -21,9 +24,14 @@ const defaultListenAddress = "localhost"
 const defaultPort = "8250"
 const defaultCallbackHost = "localhost"
 const defaultCallbackMethod = "http"
+const defaultTLSCallbackMethod = "https"
 
 var errorRegex = regexp.MustCompile(`(?s)Errors:.*\* *(.*)`)
 
+// ErrPrintOnlyMode is returned by Auth with print_only=true, after the auth
+// URL has been printed, since the login is not completed.
+var ErrPrintOnlyMode = errors.New("print_only is set; the auth URL was printed and the login was not completed")
+
 type CLIHandler struct{}
 
 type loginResp struct {
@@ -44,6 +52,18 @@ func (h *CLIHandler) Auth(c *api.Client, m map[string]string) (*api.Secret, erro
 		mount = defaultMount
 	}
 
+	// With output_format=json, the messages meant for users are suppressed so
+	// that scripts only get the login result, formatted by FormatOutput.
+	outputFormat := m["output_format"]
+	var stderr io.Writer = os.Stderr
+	switch outputFormat {
+	case "":
+	case outputFormatJSON:
+		stderr = ioutil.Discard
+	default:
+		return nil, fmt.Errorf("unsupported output_format %q", outputFormat)
+	}
+
 	listenAddress, ok := m["listenaddress"]
 	if !ok {
 		listenAddress = defaultListenAddress
@@ -59,9 +79,21 @@ func (h *CLIHandler) Auth(c *api.Client, m map[string]string) (*api.Secret, erro
 		callbackHost = defaultCallbackHost
 	}
 
+	callbackTLS := m["callbacktls"] == "true"
+
+	// The certificate is loaded before the login starts, so that errors
+	// are reported before the browser is opened.
+	callbackCert, err := loadCallbackCert(m["callbacktlscert"], m["callbacktlskey"])
+	if err != nil {
+		return nil, err
+	}
+
 	callbackMethod, ok := m["callbackmethod"]
 	if !ok {
 		callbackMethod = defaultCallbackMethod
+		if callbackTLS || m["callbacktlscert"] != "" || m["callbacktlskey"] != "" {
+			callbackMethod = defaultTLSCallbackMethod
+		}
 	}
 
 	callbackPort, ok := m["callbackport"]
@@ -71,75 +103,326 @@ func (h *CLIHandler) Auth(c *api.Client, m map[string]string) (*api.Secret, erro
 
 	role := m["role"]
 
-	authURL, err := fetchAuthURL(c, role, mount, callbackPort, callbackMethod, callbackHost)
+	// The device flow is completed in a browser on another device, so no
+	// callback listener is needed. The verification URL and user code are
+	// needed to complete the login, so they are shown even with
+	// output_format=json.
+	switch m["method"] {
+	case "", "browser":
+	case "device":
+		return deviceAuth(c, os.Stderr, mount, role)
+	default:
+		return nil, fmt.Errorf("unsupported method %q", m["method"])
+	}
+
+	printOnly := m["print_only"] == "true"
+
+	credentialCache, err := expandHome(m["credential_cache"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential_cache: %s", err)
+	}
+
+	// With wrap_ttl, the callback response is wrapped so that the Vault token
+	// only exists in this goroutine, once unwrapped.
+	wrapTTL := m["wrap_ttl"]
+	if wrapTTL != "" {
+		if _, err := time.ParseDuration(wrapTTL); err != nil {
+			return nil, fmt.Errorf("invalid wrap_ttl: %s", err)
+		}
+	}
+
+	// Custom pages that can't be read are reported, and the default pages
+	// are served instead.
+	pages, err := loadCallbackPages(m["success_html_file"], m["error_html_file"])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading custom callback pages, using the default pages: %s\n", err)
+		pages = &callbackPages{}
+	}
+
+	cacheLoginHints := m["cache_login_hints"] == "true"
+
+	loginHintCacheTTL := defaultLoginHintCacheTTL
+	if v, ok := m["login_hint_cache_ttl"]; ok {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid login_hint_cache_ttl: %s", err)
+		}
+		loginHintCacheTTL = ttl
+	}
+
+	var timeout time.Duration
+	if v, ok := m["timeout"]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %s", err)
+		}
+		if d < 0 {
+			return nil, errors.New("invalid timeout: must not be negative")
+		}
+		timeout = d
+	}
+
+	if m["login_hint_cache_clear"] == "true" {
+		if err := clearLoginHint(mount); err != nil {
+			return nil, fmt.Errorf("error clearing cached login hint: %s", err)
+		}
+	}
+
+	loginHint := m["login_hint"]
+	if loginHint == "" && cacheLoginHints {
+		hint, err := readLoginHint(mount)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error reading cached login hint: %s\n", err)
+		}
+		loginHint = hint
+	}
+
+	codeVerifier, err := newCodeVerifier()
 	if err != nil {
 		return nil, err
 	}
+	codeChallenge := pkceChallenge(codeVerifier)
+
+	prompt := m["prompt"]
+	authURL, powSolution, err := fetchAuthURL(c, role, mount, callbackPort, callbackMethod, callbackHost, loginHint, m["inline_data"], prompt, codeChallenge)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkAuthURLSignature(c, mount, authURL); err != nil {
+		return nil, err
+	}
+
+	var credentialKey string
+	if credentialCache != "" {
+		credentialKey, err = credentialCacheKey(authURL, role)
+		if err != nil {
+			return nil, err
+		}
+		if m["force_refresh"] != "true" {
+			secret, err := readCachedToken(c, credentialCache, credentialKey)
+			if err != nil {
+				fmt.Fprintf(stderr, "Error reading credential cache: %s\n", err)
+			}
+			if secret != nil {
+				fmt.Fprintf(stderr, "Using the cached Vault token from %s.\n", credentialCache)
+				return secret, nil
+			}
+		}
+	}
+
+	// With print_only, the browser is opened by the caller, and no
+	// callback is received. The caller completes the login by passing the
+	// code and state of the provider redirect to the OIDC callback, along
+	// with the PKCE code verifier and any proof of work solution.
+	if printOnly {
+		if _, ok := m["timeout"]; ok {
+			fmt.Fprintln(stderr, "Warning: timeout is ignored with print_only=true.")
+		}
+		fmt.Fprintln(os.Stdout, authURL)
+		fmt.Fprintf(os.Stdout, "code_verifier=%s\n", codeVerifier)
+		if powSolution != "" {
+			fmt.Fprintf(os.Stdout, "pow_solution=%s\n", powSolution)
+		}
+		return nil, ErrPrintOnlyMode
+	}
 
 	// Set up callback handler
-	http.HandleFunc("/oidc/callback", func(w http.ResponseWriter, req *http.Request) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oidc/callback", func(w http.ResponseWriter, req *http.Request) {
 		var response string
 
 		query := req.URL.Query()
 		code := query.Get("code")
 		state := query.Get("state")
+		idToken := query.Get("id_token")
+
+		// Tokens returned in the URL fragment need to be resubmitted by the
+		// browser before they can be read.
+		if code == "" && idToken == "" && query.Get("error") == "" {
+			w.Write([]byte(fragmentHTML))
+			return
+		}
+
 		data := map[string][]string{
-			"code":  {code},
-			"state": {state},
+			"code":          {code},
+			"state":         {state},
+			"code_verifier": {codeVerifier},
+		}
+		if powSolution != "" {
+			data["pow_solution"] = []string{powSolution}
+		}
+		if idToken != "" {
+			data["id_token"] = []string{idToken}
+			data["access_token"] = []string{query.Get("access_token")}
+		}
+		if providerErr := query.Get("error"); providerErr != "" {
+			// A silent login that needs the user's involvement is retried
+			// once with the consent screen shown.
+			if prompt == "none" && (providerErr == "interaction_required" || providerErr == "consent_required") {
+				fmt.Fprintf(stderr, "The OIDC provider requires user interaction (%s). Retrying with prompt=consent.\n", providerErr)
+				prompt = "consent"
+				retryURL, retrySolution, err := fetchAuthURL(c, role, mount, callbackPort, callbackMethod, callbackHost, loginHint, m["inline_data"], prompt, codeChallenge)
+				if err == nil {
+					err = checkAuthURLSignature(c, mount, retryURL)
+				}
+				if err != nil {
+					summary, detail := parseError(err)
+					w.Write([]byte(pages.errorHTML(summary, detail)))
+					doneCh <- loginResp{nil, err}
+					return
+				}
+				powSolution = retrySolution
+				http.Redirect(w, req, retryURL, http.StatusFound)
+				return
+			}
+			data["error"] = []string{providerErr}
+			data["error_description"] = []string{query.Get("error_description")}
 		}
 
-		secret, err := c.Logical().ReadWithData(fmt.Sprintf("auth/%s/oidc/callback", mount), data)
-		if err != nil {
+		callbackClient := c
+		if wrapTTL != "" {
+			callbackClient, err = c.Clone()
+			if err != nil {
+				summary, detail := parseError(err)
+				w.Write([]byte(pages.errorHTML(summary, detail)))
+				doneCh <- loginResp{nil, err}
+				return
+			}
+			callbackClient.SetWrappingLookupFunc(func(string, string) string { return wrapTTL })
+		}
+
+		secret, err := callbackClient.Logical().ReadWithData(fmt.Sprintf("auth/%s/oidc/callback", mount), data)
+		switch {
+		case err != nil:
 			summary, detail := parseError(err)
-			response = errorHTML(summary, detail)
-		} else {
-			response = successHTML
+			response = pages.errorHTML(summary, detail)
+		case wrapTTL != "" && secret != nil && secret.WrapInfo != nil:
+			response = pages.successHTML(time.Duration(secret.WrapInfo.TTL) * time.Second)
+		default:
+			response = pages.successHTML(0)
 		}
 
 		w.Write([]byte(response))
 		doneCh <- loginResp{secret, err}
 	})
 
-	listener, err := net.Listen("tcp", listenAddress+":"+port)
+	listener, fingerprint, err := listenCallback(joinHostPort(listenAddress, port), unbracketHost(callbackHost), callbackTLS, callbackCert)
 	if err != nil {
 		return nil, err
 	}
 	defer listener.Close()
 
+	if fingerprint != "" {
+		fmt.Fprintf(stderr, "The callback listener uses a self-signed certificate with SHA-256 fingerprint:\n\n    %s\n\n", fingerprint)
+	}
+
 	// Open the default browser to the callback URL.
-	fmt.Fprintf(os.Stderr, "Complete the login via your OIDC provider. Launching browser to:\n\n    %s\n\n\n", authURL)
-	if err := openURL(authURL); err != nil {
-		fmt.Fprintf(os.Stderr, "Error attempting to automatically open browser: '%s'.\nPlease visit the authorization URL manually.", err)
+	fmt.Fprintf(stderr, "Complete the login via your OIDC provider. Launching browser to:\n\n    %s\n\n\n", authURL)
+	if err := openBrowser(authURL); err != nil {
+		// The URL is needed to complete the login, so it is shown even
+		// with output_format=json.
+		if stderr == ioutil.Discard {
+			fmt.Fprintf(os.Stderr, "Error attempting to automatically open browser: '%s'.\nPlease visit the authorization URL manually:\n\n    %s\n\n", err, authURL)
+		} else {
+			fmt.Fprintf(stderr, "Error attempting to automatically open browser: '%s'.\nPlease visit the authorization URL manually.", err)
+		}
 	}
 
 	// Start local server
 	go func() {
-		err := http.Serve(listener, nil)
+		err := http.Serve(listener, mux)
 		if err != nil && err != http.ErrServerClosed {
 			doneCh <- loginResp{nil, err}
 		}
 	}()
 
-	// Wait for either the callback to finish or SIGINT to be received
+	progress := startProgress(os.Stderr, outputFormat == "" && progressEnabled(os.Stderr))
+	s := waitForCallback(doneCh, sigintCh, timeout, progress)
+	if s.err == nil && wrapTTL != "" {
+		s.secret, s.err = unwrapLogin(c, s.secret)
+	}
+	if s.err == nil && cacheLoginHints {
+		cacheLoginHint(stderr, c, mount, m["login_hint"], s.secret, loginHintCacheTTL)
+	}
+	if s.err == nil && credentialCache != "" {
+		if err := writeCachedToken(c, credentialCache, credentialKey, s.secret); err != nil {
+			fmt.Fprintf(stderr, "Error caching Vault token: %s\n", err)
+		}
+	}
+	return s.secret, s.err
+}
+
+// unwrapLogin unwraps the wrapped response of the OIDC callback.
+func unwrapLogin(c *api.Client, wrapped *api.Secret) (*api.Secret, error) {
+	if wrapped == nil || wrapped.WrapInfo == nil || wrapped.WrapInfo.Token == "" {
+		return nil, errors.New("the OIDC callback response was not wrapped")
+	}
+
+	// The wrapping token authenticates the unwrap request, without changing
+	// the token of c.
+	client, err := c.Clone()
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(wrapped.WrapInfo.Token)
+
+	secret, err := client.Logical().Unwrap("")
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping the login response: %s", err)
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, errors.New("the unwrapped login response has no token")
+	}
+	return secret, nil
+}
+
+// waitForCallback waits for the callback to finish, SIGINT to be received or
+// timeout to elapse, then stops progress. A zero timeout waits indefinitely.
+func waitForCallback(doneCh <-chan loginResp, sigintCh <-chan os.Signal, timeout time.Duration, progress *progressIndicator) loginResp {
+	defer progress.Stop()
+
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
 	select {
 	case s := <-doneCh:
-		return s.secret, s.err
+		return s
 	case <-sigintCh:
-		return nil, errors.New("Interrupted")
+		return loginResp{nil, errors.New("Interrupted")}
+	case <-timeoutCh:
+		return loginResp{nil, fmt.Errorf("OIDC login timed out after %s", timeout)}
 	}
 }
 
-func fetchAuthURL(c *api.Client, role, mount, callbackport string, callbackMethod string, callbackHost string) (string, error) {
-	var authURL string
+// fetchAuthURL returns the auth URL of a new login and, if the mount
+// requires proof of work, the solution to pass to the callback.
+func fetchAuthURL(c *api.Client, role, mount, callbackport string, callbackMethod string, callbackHost string, loginHint string, inlineData string, prompt string, codeChallenge string) (string, string, error) {
+	var authURL, powSolution string
 
 	data := map[string]interface{}{
-		"role":         role,
-		"redirect_uri": fmt.Sprintf("%s://%s:%s/oidc/callback", callbackMethod, callbackHost, callbackport),
+		"role":                  role,
+		"redirect_uri":          fmt.Sprintf("%s://%s/oidc/callback", callbackMethod, joinHostPort(callbackHost, callbackport)),
+		"code_challenge":        codeChallenge,
+		"code_challenge_method": pkceMethodS256,
+	}
+	if loginHint != "" {
+		data["login_hint"] = loginHint
+	}
+	if inlineData != "" {
+		data["inline_data"] = inlineData
+	}
+	if prompt != "" {
+		data["prompt"] = prompt
 	}
 
 	secret, err := c.Logical().Write(fmt.Sprintf("auth/%s/oidc/auth_url", mount), data)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	if secret != nil {
@@ -147,10 +430,85 @@ func fetchAuthURL(c *api.Client, role, mount, callbackport string, callbackMetho
 	}
 
 	if authURL == "" {
-		return "", errors.New(fmt.Sprintf("Unable to authorize role %q. Check Vault logs for more information.", role))
+		return "", "", errors.New(fmt.Sprintf("Unable to authorize role %q. Check Vault logs for more information.", role))
+	}
+
+	// The puzzle is solved before the browser is opened, so that the
+	// callback can be completed as soon as it is received.
+	if puzzle, ok := secret.Data["pow_puzzle"].(string); ok && puzzle != "" {
+		n, _ := secret.Data["pow_difficulty"].(json.Number)
+		difficulty, err := n.Int64()
+		if err != nil {
+			return "", "", fmt.Errorf("invalid pow_difficulty: %s", err)
+		}
+		powSolution = solvePoW(puzzle, int(difficulty))
+	}
+
+	return authURL, powSolution, nil
+}
+
+// joinHostPort joins host and port, wrapping IPv6 literals such as ::1 in
+// brackets. Hosts given already bracketed are accepted as well.
+func joinHostPort(host, port string) string {
+	return net.JoinHostPort(unbracketHost(host), port)
+}
+
+// unbracketHost removes the brackets around an IPv6 literal, e.g. [::1].
+func unbracketHost(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
 	}
+	return host
+}
+
+// checkAuthURLSignature verifies the authorization URL with Vault before it
+// is displayed. Unsigned URLs are only accepted if the mount doesn't sign
+// them, so that a URL whose signature was stripped is rejected as well.
+func checkAuthURLSignature(c *api.Client, mount, authURL string) error {
+	signed := strings.Contains(authURL, authURLSignatureParam+"=")
 
-	return authURL, nil
+	secret, err := c.Logical().Write(fmt.Sprintf("auth/%s/oidc/verify_auth_url", mount), map[string]interface{}{
+		"auth_url": authURL,
+	})
+	if err != nil {
+		// Mounts without the endpoint predate oidc_sign_auth_url.
+		if respErr, ok := err.(*api.ResponseError); ok && respErr.StatusCode == http.StatusNotFound && !signed {
+			return nil
+		}
+		return err
+	}
+
+	switch {
+	case secret != nil && secret.Data["valid"] == true:
+		return nil
+	case signed:
+		return errors.New("The authorization URL signature could not be verified. The URL may have been tampered with.")
+	case secret != nil && secret.Data["signing_required"] == true:
+		return errors.New("The authorization URL is not signed, but this auth method signs its authorization URLs. The URL may have been tampered with.")
+	}
+
+	return nil
+}
+
+// cacheLoginHint stores the login hint for the next login. An explicitly
+// provided hint is cached as is, otherwise it is derived from the new token.
+// Failures only produce a warning since the login itself succeeded.
+func cacheLoginHint(stderr io.Writer, c *api.Client, mount, loginHint string, secret *api.Secret, ttl time.Duration) {
+	if loginHint == "" {
+		hint, err := loginHintFromToken(c, mount, secret)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error determining login hint to cache: %s\n", err)
+			return
+		}
+		loginHint = hint
+	}
+	if loginHint == "" {
+		return
+	}
+
+	if err := writeLoginHint(mount, loginHint, ttl); err != nil {
+		fmt.Fprintf(stderr, "Error caching login hint: %s\n", err)
+	}
 }
 
 // isWSL tests if the binary is being run in Windows Subsystem for Linux
@@ -166,24 +524,34 @@ func isWSL() bool {
 	return strings.Contains(strings.ToLower(string(data)), "microsoft")
 }
 
+// openBrowser opens the authorization URL. It is replaced in tests.
+var openBrowser = openURL
+
 // openURL opens the specified URL in the default browser of the user.
 // Source: https://stackoverflow.com/a/39324149/453290
 func openURL(url string) error {
+	cmd, args := openURLCommand(runtime.GOOS, isWSL(), url)
+	return exec.Command(cmd, args...).Start()
+}
+
+// openURLCommand returns the command opening url in the default browser on
+// goos. The URL is passed as a single argument, so brackets of IPv6 hosts
+// need no escaping; cmd.exe only needs "&" to be escaped.
+func openURLCommand(goos string, wsl bool, url string) (string, []string) {
 	var cmd string
 	var args []string
 
 	switch {
-	case "windows" == runtime.GOOS || isWSL():
+	case "windows" == goos || wsl:
 		cmd = "cmd.exe"
 		args = []string{"/c", "start"}
 		url = strings.Replace(url, "&", "^&", -1)
-	case "darwin" == runtime.GOOS:
+	case "darwin" == goos:
 		cmd = "open"
 	default: // "linux", "freebsd", "openbsd", "netbsd"
 		cmd = "xdg-open"
 	}
-	args = append(args, url)
-	return exec.Command(cmd, args...).Start()
+	return cmd, append(args, url)
 }
 
 // parseError converts error from the API into summary and detailed portions.
@@ -196,7 +564,7 @@ func openURL(url string) error {
 //
 //    "No response from provider.", "Gateway timeout from upstream proxy."
 func parseError(err error) (string, string) {
-	headers := []string{errNoResponse, errLoginFailed, errTokenVerification}
+	headers := []string{errNoResponse, errLoginFailed, errTokenVerification, errAuthTimeTooOld}
 	summary := "Login error"
 	detail := ""
 
@@ -245,20 +613,108 @@ Configuration:
   role=<string>
       Vault role of type "OIDC" to use for authentication.
 
+  method=<string>
+    Optional login method: browser, or device for the OAuth 2.0 device authorization
+    grant (default: browser). With method=device, a verification URL and user code are
+    printed to stderr, to be entered in a browser on any device, e.g. from a CI runner
+    or SSH session. The role must have device_flow_allowed set.
+
   listenaddress=<string>
     Optional address to bind the OIDC callback listener to (default: localhost).
+    IPv6 literals such as ::1 are supported.
 
   port=<string>
     Optional localhost port to use for OIDC callback (default: 8250).
 
   callbackmethod=<string>
-    Optional method to to use in OIDC redirect_uri (default: http).
+    Optional method to to use in OIDC redirect_uri (default: http, or https if
+    callbacktls is set).
+
+  callbacktls=<bool>
+    Optional. If true, the callback listener serves HTTPS with a self-signed
+    certificate generated for the login. Its SHA-256 fingerprint is printed so that
+    it can be compared with the certificate shown by the browser (default: false).
+
+  callbacktlscert=<string>
+    Optional path to a PEM certificate served by the callback listener instead of a
+    self-signed certificate, e.g. one issued by an enterprise CA. Requires
+    callbacktlskey. Setting it defaults callbackmethod to https.
+
+  callbacktlskey=<string>
+    Optional path to the PEM private key of callbacktlscert.
 
   callbackhost=<string>
     Optional callback host address to use in OIDC redirect_uri (default: localhost).
+    IPv6 literals are wrapped in brackets, e.g. http://[::1]:8250/oidc/callback.
 
   callbackport=<string>
       Optional port to to use in OIDC redirect_uri (default: the value set for port).
+
+  login_hint=<string>
+    Optional login_hint to pass to the OIDC provider, e.g. the user's email address.
+
+  cache_login_hints=<bool>
+    Optional. If true, the login hint is cached in ~/.vault-oidc-hints/<mount> after a
+    successful login and used for the next login when login_hint isn't set (default: false).
+
+  login_hint_cache_ttl=<duration>
+    Optional lifetime of a cached login hint (default: 720h).
+
+  login_hint_cache_clear=<bool>
+    Optional. If true, the cached login hint for the mount is removed before logging in.
+
+  inline_data=<string>
+    Optional base64-encoded application data to round-trip through the OIDC login. It is
+    returned in the "inline_data" token metadata. Requires oidc_inline_data_max_bytes.
+
+  prompt=<string>
+    Optional prompt to pass to the OIDC provider: none, login, consent or select_account.
+    With prompt=none, a login that fails with interaction_required or consent_required
+    is retried once with prompt=consent.
+
+  timeout=<duration>
+    Optional. How long to wait for the OIDC callback, e.g. "120s", before the login
+    fails and the callback listener is closed. Defaults to "0", which waits
+    indefinitely. Ignored with print_only=true.
+
+  output_format=<string>
+    Optional. If set to json, messages meant for users are not shown, so that the
+    login result can be printed as JSON for scripts.
+
+  credential_cache=<string>
+    Optional path of a file caching Vault tokens, e.g. ~/.vault/oidc-cache. A cached
+    token for the same provider, client and role is reused while it is valid,
+    without opening the browser. Tokens are only cached if they can look themselves
+    up. The file is written with 0600 permissions.
+
+  force_refresh=<bool>
+    Optional. If set to true, the credential cache is not read and a new login is
+    always made.
+
+  print_only=<bool>
+    Optional. If set to true, the auth URL is printed to stdout, for headless
+    environments that open the browser themselves. No browser is launched and no
+    callback listener is started, so the login is not completed by this command.
+    The auth URL is followed by a "code_verifier=<verifier>" line and, if the login
+    requires proof of work, a "pow_solution=<solution>" line. Both must be passed to
+    the OIDC callback along with the code and state of the provider redirect.
+
+  wrap_ttl=<duration>
+    Optional. If set, e.g. to "5m", the OIDC callback response is wrapped with this
+    TTL and unwrapped once received, so that the Vault token is never handled by
+    the callback listener. The browser is only shown the wrapping token TTL.
+
+  success_html_file=<string>
+    Optional path of an HTML page to show in the browser after a successful login,
+    instead of the default page.
+
+  error_html_file=<string>
+    Optional path of an HTML page to show in the browser after a failed login,
+    instead of the default page. The {summary} and {detail} tokens are replaced by
+    the error.
+
+  While waiting for the login to complete, the time elapsed is shown on stderr if it
+  is a terminal. Set VAULT_OIDC_NO_PROGRESS or VAULT_CLI_NO_COLOR to disable it.
 `
 
 	return strings.TrimSpace(help)
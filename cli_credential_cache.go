@@ -0,0 +1,173 @@
+package jwtauth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// cachedCredential is a Vault token cached by credential_cache.
+type cachedCredential struct {
+	Auth    *api.SecretAuth `json:"auth"`
+	Expires time.Time       `json:"expires"`
+}
+
+// credentialCacheKey identifies the cached token of role for the provider
+// and client of authURL, hashed so that the cache doesn't reveal them.
+func credentialCacheKey(authURL, role string) (string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+
+	issuer := u.Scheme + "://" + u.Host
+	sum := sha256.Sum256([]byte(strings.Join([]string{issuer, u.Query().Get("client_id"), role}, "\n")))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// expandHome expands a leading ~ in path to the user's home directory.
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+func readCredentialCache(path string) (map[string]cachedCredential, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[string]cachedCredential{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cache := map[string]cachedCredential{}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// readCachedToken returns the cached token for key if it is within its TTL
+// and still valid, or nil otherwise.
+func readCachedToken(c *api.Client, path, key string) (*api.Secret, error) {
+	cache, err := readCredentialCache(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cached, ok := cache[key]
+	if !ok || cached.Auth == nil || !time.Now().Before(cached.Expires) {
+		return nil, nil
+	}
+
+	ttl, err := lookupTokenTTL(c, cached.Auth.ClientToken)
+	if err != nil || ttl <= 0 {
+		// The token was revoked or can't be checked, so a new login is
+		// needed.
+		return nil, nil
+	}
+
+	auth := *cached.Auth
+	auth.LeaseDuration = ttl
+	return &api.Secret{Auth: &auth}, nil
+}
+
+// writeCachedToken caches the token of secret for key. Tokens that can't
+// look themselves up are not cached, since they couldn't be checked before
+// being reused.
+func writeCachedToken(c *api.Client, path, key string, secret *api.Secret) error {
+	if secret == nil || secret.Auth == nil {
+		return nil
+	}
+
+	ttl, err := lookupTokenTTL(c, secret.Auth.ClientToken)
+	if err != nil {
+		return errors.New("the token can't look itself up, so it is not cached")
+	}
+	if ttl <= 0 {
+		return nil
+	}
+
+	cache, err := readCredentialCache(path)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for k, cached := range cache {
+		if !now.Before(cached.Expires) {
+			delete(cache, k)
+		}
+	}
+	cache[key] = cachedCredential{
+		Auth:    secret.Auth,
+		Expires: now.Add(time.Duration(ttl) * time.Second),
+	}
+
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	// The cache is written to a new file that replaces the previous one,
+	// so that the tokens are never readable by others, even if an existing
+	// file had broader permissions.
+	f, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// lookupTokenTTL looks up token and returns its remaining TTL in seconds.
+func lookupTokenTTL(c *api.Client, token string) (int, error) {
+	client, err := c.Clone()
+	if err != nil {
+		return 0, err
+	}
+	client.SetToken(token)
+
+	self, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		return 0, err
+	}
+
+	ttl, err := self.TokenTTL()
+	if err != nil {
+		return 0, err
+	}
+	return int(ttl.Seconds()), nil
+}
@@ -0,0 +1,218 @@
+package jwtauth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// newCredentialCacheTestClient returns a client of a Vault server that only
+// lets the token "s.valid" look itself up.
+func newCredentialCacheTestClient(t *testing.T) (*api.Client, func()) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/auth/oidc/oidc/auth_url":
+			w.Write([]byte(`{"data": {"auth_url": "https://provider.example.com/auth?client_id=abc&state=xyz"}}`))
+		case r.URL.Path == "/v1/auth/oidc/oidc/verify_auth_url":
+			w.Write([]byte(`{"data": {"valid": false, "signing_required": false}}`))
+		case r.URL.Path == "/v1/auth/token/lookup-self" && r.Header.Get("X-Vault-Token") == "s.valid":
+			w.Write([]byte(`{"data": {"ttl": 3000}}`))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors": ["permission denied"]}`))
+		}
+	}))
+
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	c, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, server.Close
+}
+
+func TestCLIHandler_Auth_CredentialCache(t *testing.T) {
+	c, closeServer := newCredentialCacheTestClient(t)
+	defer closeServer()
+
+	origOpenBrowser := openBrowser
+	defer func() { openBrowser = origOpenBrowser }()
+	openBrowser = func(string) error {
+		t.Error("unexpected browser launch")
+		return nil
+	}
+
+	// print_only is used to detect that the cache was not used, since a new
+	// login then prints the auth URL.
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	origStdout := os.Stdout
+	os.Stdout = devNull
+	defer func() { os.Stdout = origStdout }()
+
+	key, err := credentialCacheKey("https://provider.example.com/auth?client_id=abc&state=other", "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		token       string
+		expires     time.Time
+		extra       map[string]string
+		expectCache bool
+	}{
+		"cache hit": {
+			token:       "s.valid",
+			expires:     time.Now().Add(time.Hour),
+			expectCache: true,
+		},
+		"expired token": {
+			token:   "s.valid",
+			expires: time.Now().Add(-time.Minute),
+		},
+		"revoked token": {
+			token:   "s.revoked",
+			expires: time.Now().Add(time.Hour),
+		},
+		"force_refresh": {
+			token:   "s.valid",
+			expires: time.Now().Add(time.Hour),
+			extra:   map[string]string{"force_refresh": "true"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "vault-oidc-cache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "oidc-cache")
+			cache := map[string]cachedCredential{
+				key: {
+					Auth:    &api.SecretAuth{ClientToken: tt.token, LeaseDuration: 3600},
+					Expires: tt.expires,
+				},
+			}
+			data, err := json.Marshal(cache)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			m := map[string]string{
+				"role":             "dev",
+				"credential_cache": path,
+				"print_only":       "true",
+			}
+			for k, v := range tt.extra {
+				m[k] = v
+			}
+
+			h := &CLIHandler{}
+			secret, err := h.Auth(c, m)
+			if !tt.expectCache {
+				if err != ErrPrintOnlyMode {
+					t.Fatalf("expected a new login, got secret %#v and error %v", secret, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if secret.Auth.ClientToken != "s.valid" || secret.Auth.LeaseDuration != 3000 {
+				t.Fatalf("unexpected cached token: %#v", secret.Auth)
+			}
+		})
+	}
+}
+
+func TestWriteCachedToken(t *testing.T) {
+	c, closeServer := newCredentialCacheTestClient(t)
+	defer closeServer()
+
+	dir, err := ioutil.TempDir("", "vault-oidc-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cache", "oidc-cache")
+
+	// Tokens that can't look themselves up are not cached.
+	err = writeCachedToken(c, path, "key", &api.Secret{Auth: &api.SecretAuth{ClientToken: "s.nolookup"}})
+	if err == nil {
+		t.Fatal("expected an error for a token that can't look itself up")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected no cache file, got: %v", err)
+	}
+
+	if err := writeCachedToken(c, path, "key", &api.Secret{Auth: &api.SecretAuth{ClientToken: "s.valid"}}); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Fatalf("expected 0600 permissions, got %o", perm)
+	}
+
+	secret, err := readCachedToken(c, path, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret == nil || secret.Auth.ClientToken != "s.valid" {
+		t.Fatalf("expected the cached token, got: %#v", secret)
+	}
+	if secret, err := readCachedToken(c, path, "other"); err != nil || secret != nil {
+		t.Fatalf("expected a cache miss, got: %#v, %v", secret, err)
+	}
+
+	// An existing cache file readable by others is replaced by one that
+	// isn't.
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeCachedToken(c, path, "other", &api.Secret{Auth: &api.SecretAuth{ClientToken: "s.valid"}}); err != nil {
+		t.Fatal(err)
+	}
+	fi, err = os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Fatalf("expected 0600 permissions, got %o", perm)
+	}
+	if secret, err := readCachedToken(c, path, "key"); err != nil || secret == nil {
+		t.Fatalf("expected the previously cached token, got: %#v, %v", secret, err)
+	}
+
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected only the cache file, got %d files", len(files))
+	}
+}
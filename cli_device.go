@@ -0,0 +1,96 @@
+package jwtauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// deviceSleep waits between polls of a device flow login. Tests replace it.
+var deviceSleep = time.Sleep
+
+// deviceAuth logs in with the device authorization grant: the user is shown
+// the verification URI and user code, and device/token is polled until the
+// login is authorized on another device or the device code expires.
+func deviceAuth(c *api.Client, stderr io.Writer, mount, role string) (*api.Secret, error) {
+	secret, err := c.Logical().Write(fmt.Sprintf("auth/%s/device/auth_url", mount), map[string]interface{}{
+		"role": role,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, errors.New("empty response from device/auth_url")
+	}
+
+	deviceCode, _ := secret.Data["device_code"].(string)
+	userCode, _ := secret.Data["user_code"].(string)
+	verificationURI, _ := secret.Data["verification_uri"].(string)
+	if deviceCode == "" || userCode == "" || verificationURI == "" {
+		return nil, errors.New("device/auth_url response is missing device_code, user_code or verification_uri")
+	}
+
+	interval, err := deviceSeconds(secret.Data["interval"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid interval: %s", err)
+	}
+	expiresIn, err := deviceSeconds(secret.Data["expires_in"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid expires_in: %s", err)
+	}
+	deadline := time.Now().Add(expiresIn)
+
+	fmt.Fprintf(stderr, "To complete the login, open the following URL on any device:\n\n    %s\n\nand enter the code: %s\n\n", verificationURI, userCode)
+	if complete, ok := secret.Data["verification_uri_complete"].(string); ok && complete != "" {
+		fmt.Fprintf(stderr, "Or open this URL, which includes the code:\n\n    %s\n\n", complete)
+	}
+	fmt.Fprintln(stderr, "Waiting for the login to be authorized...")
+
+	path := fmt.Sprintf("auth/%s/device/token", mount)
+	for {
+		deviceSleep(interval)
+		if time.Now().After(deadline) {
+			return nil, errors.New("the device code expired before the login was authorized")
+		}
+
+		secret, err := c.Logical().Write(path, map[string]interface{}{
+			"device_code": deviceCode,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if secret == nil {
+			return nil, errors.New("empty response from device/token")
+		}
+		if secret.Auth != nil {
+			return secret, nil
+		}
+
+		switch status, _ := secret.Data["status"].(string); status {
+		case deviceStatusPending, deviceStatusSlowDown:
+			if next, err := deviceSeconds(secret.Data["interval"]); err == nil && next > 0 {
+				interval = next
+			}
+		default:
+			return nil, fmt.Errorf("unexpected device/token status %q", status)
+		}
+	}
+}
+
+// deviceSeconds converts a number of seconds in a device flow response to a
+// duration.
+func deviceSeconds(v interface{}) (time.Duration, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("expected a number of seconds, got %v", v)
+	}
+	secs, err := n.Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(secs) * time.Second, nil
+}
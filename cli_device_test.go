@@ -0,0 +1,156 @@
+package jwtauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/api"
+)
+
+// deviceVault stubs the device flow endpoints of a Vault server, returning
+// polls in order from device/token. Polls with errors are returned with a
+// 400 status.
+func deviceVault(t *testing.T, expiresIn int, polls []string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/oidc/device/auth_url":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["role"] != "ci" {
+				t.Errorf("unexpected role: %v", body["role"])
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{
+					"device_code":      "device-code",
+					"user_code":        "WDJB-MJHT",
+					"verification_uri": "https://provider.example.com/activate",
+					"expires_in":       expiresIn,
+					"interval":         5,
+				},
+			})
+		case "/v1/auth/oidc/device/token":
+			if len(polls) == 0 {
+				t.Error("unexpected poll")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			poll := polls[0]
+			polls = polls[1:]
+			if strings.HasPrefix(poll, `{"errors"`) {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+			w.Write([]byte(poll))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestCLIHandler_Auth_Device(t *testing.T) {
+	server := deviceVault(t, 300, []string{
+		`{"data": {"status": "authorization_pending", "interval": 5}}`,
+		`{"data": {"status": "slow_down", "interval": 10}}`,
+		`{"data": {"status": "authorization_pending", "interval": 10}}`,
+		`{"auth": {"client_token": "s.device"}}`,
+	})
+	defer server.Close()
+
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	c, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var slept []time.Duration
+	defer func(sleep func(time.Duration)) { deviceSleep = sleep }(deviceSleep)
+	deviceSleep = func(d time.Duration) { slept = append(slept, d) }
+
+	origOpenBrowser := openBrowser
+	defer func() { openBrowser = origOpenBrowser }()
+	openBrowser = func(string) error {
+		t.Error("unexpected browser launch")
+		return nil
+	}
+
+	h := &CLIHandler{}
+	secret, err := h.Auth(c, map[string]string{
+		"method":        "device",
+		"role":          "ci",
+		"output_format": "json",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken != "s.device" {
+		t.Fatalf("unexpected secret: %#v", secret)
+	}
+
+	// The interval is increased after slow_down.
+	expected := []time.Duration{5 * time.Second, 5 * time.Second, 10 * time.Second, 10 * time.Second}
+	if diff := deep.Equal(slept, expected); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestCLIHandler_Auth_DeviceExpiry(t *testing.T) {
+	config := api.DefaultConfig()
+
+	t.Run("server error", func(t *testing.T) {
+		server := deviceVault(t, 300, []string{
+			`{"data": {"status": "authorization_pending", "interval": 5}}`,
+			`{"errors": ["Vault login failed. Expired or missing device code."]}`,
+		})
+		defer server.Close()
+
+		config.Address = server.URL
+		c, err := api.NewClient(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer func(sleep func(time.Duration)) { deviceSleep = sleep }(deviceSleep)
+		deviceSleep = func(time.Duration) {}
+
+		_, err = deviceAuth(c, &strings.Builder{}, "oidc", "ci")
+		if err == nil || !strings.Contains(err.Error(), "Expired or missing device code") {
+			t.Fatalf("expected expired device code error, got: %v", err)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		server := deviceVault(t, 1, []string{
+			`{"data": {"status": "authorization_pending", "interval": 5}}`,
+		})
+		defer server.Close()
+
+		config.Address = server.URL
+		c, err := api.NewClient(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Each wait takes 600ms, so the device code expires before the
+		// second poll.
+		defer func(sleep func(time.Duration)) { deviceSleep = sleep }(deviceSleep)
+		deviceSleep = func(time.Duration) { time.Sleep(600 * time.Millisecond) }
+
+		_, err = deviceAuth(c, &strings.Builder{}, "oidc", "ci")
+		if err == nil || !strings.Contains(err.Error(), "device code expired") {
+			t.Fatalf("expected device code expiry, got: %v", err)
+		}
+	})
+}
+
+func TestCLIHandler_Auth_InvalidMethod(t *testing.T) {
+	h := &CLIHandler{}
+	_, err := h.Auth(nil, map[string]string{"method": "carrier-pigeon"})
+	if err == nil || !strings.Contains(err.Error(), `unsupported method "carrier-pigeon"`) {
+		t.Fatalf("expected unsupported method error, got: %v", err)
+	}
+}
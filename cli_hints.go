@@ -0,0 +1,121 @@
+package jwtauth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const defaultLoginHintCacheTTL = 30 * 24 * time.Hour
+
+// loginHintsDir returns the directory login hints are cached in. It is a
+// variable so tests can override it.
+var loginHintsDir = func() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".vault-oidc-hints"), nil
+}
+
+type cachedLoginHint struct {
+	LoginHint string    `json:"login_hint"`
+	Expires   time.Time `json:"expires"`
+}
+
+// loginHintPath returns the cache file for mount.
+func loginHintPath(mount string) (string, error) {
+	dir, err := loginHintsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, strings.Replace(strings.Trim(mount, "/"), "/", "_", -1)), nil
+}
+
+// readLoginHint returns the cached login hint for mount, or "" if there is
+// none or it has expired.
+func readLoginHint(mount string) (string, error) {
+	path, err := loginHintPath(mount)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var hint cachedLoginHint
+	if err := json.Unmarshal(data, &hint); err != nil {
+		return "", err
+	}
+	if time.Now().After(hint.Expires) {
+		return "", nil
+	}
+
+	return hint.LoginHint, nil
+}
+
+// writeLoginHint caches loginHint for mount for the given ttl.
+func writeLoginHint(mount, loginHint string, ttl time.Duration) error {
+	path, err := loginHintPath(mount)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(cachedLoginHint{
+		LoginHint: loginHint,
+		Expires:   time.Now().Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// clearLoginHint removes the cached login hint for mount.
+func clearLoginHint(mount string) error {
+	path, err := loginHintPath(mount)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// loginHintFromToken derives a login hint from the display name of the token
+// in secret, which Vault prefixes with the mount path.
+func loginHintFromToken(c *api.Client, mount string, secret *api.Secret) (string, error) {
+	if secret == nil || secret.Auth == nil {
+		return "", nil
+	}
+
+	client, err := c.Clone()
+	if err != nil {
+		return "", err
+	}
+	client.SetToken(secret.Auth.ClientToken)
+
+	self, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		return "", err
+	}
+	displayName, _ := self.Data["display_name"].(string)
+
+	prefix := strings.Replace(strings.Trim(mount, "/"), "/", "-", -1) + "-"
+	return strings.TrimPrefix(displayName, prefix), nil
+}
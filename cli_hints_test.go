@@ -0,0 +1,64 @@
+package jwtauth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoginHintCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-oidc-hints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	origDir := loginHintsDir
+	defer func() { loginHintsDir = origDir }()
+	loginHintsDir = func() (string, error) { return filepath.Join(dir, "hints"), nil }
+
+	if hint, err := readLoginHint("oidc"); err != nil || hint != "" {
+		t.Fatalf("expected no hint, got %q, %v", hint, err)
+	}
+
+	if err := writeLoginHint("oidc", "bob@example.com", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "hints", "oidc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected file mode: %v", info.Mode())
+	}
+
+	if hint, err := readLoginHint("oidc"); err != nil || hint != "bob@example.com" {
+		t.Fatalf("expected cached hint, got %q, %v", hint, err)
+	}
+
+	// hints are per mount
+	if hint, err := readLoginHint("ns1/oidc"); err != nil || hint != "" {
+		t.Fatalf("expected no hint, got %q, %v", hint, err)
+	}
+
+	// expired hints are ignored
+	if err := writeLoginHint("ns1/oidc", "alice@example.com", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if hint, err := readLoginHint("ns1/oidc"); err != nil || hint != "" {
+		t.Fatalf("expected expired hint to be ignored, got %q, %v", hint, err)
+	}
+
+	if err := clearLoginHint("oidc"); err != nil {
+		t.Fatal(err)
+	}
+	if hint, err := readLoginHint("oidc"); err != nil || hint != "" {
+		t.Fatalf("expected cleared hint, got %q, %v", hint, err)
+	}
+	if err := clearLoginHint("oidc"); err != nil {
+		t.Fatalf("clearing a missing hint should not fail: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package jwtauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// outputFormatJSON is the output_format value for machine-readable output.
+const outputFormatJSON = "json"
+
+// loginOutput is the JSON form of a login result.
+type loginOutput struct {
+	ClientToken      string            `json:"client_token"`
+	Accessor         string            `json:"accessor"`
+	LeaseDuration    int               `json:"lease_duration"`
+	Renewable        bool              `json:"renewable"`
+	Policies         []string          `json:"policies"`
+	TokenPolicies    []string          `json:"token_policies"`
+	IdentityPolicies []string          `json:"identity_policies"`
+	Metadata         map[string]string `json:"metadata"`
+	EntityID         string            `json:"entity_id"`
+	Warnings         []string          `json:"warnings,omitempty"`
+}
+
+// FormatOutput formats the secret returned by Auth. The Vault CLI can detect
+// it by interface assertion to print the result of a login made with
+// output_format set, e.g. output_format=json.
+func (h *CLIHandler) FormatOutput(secret *api.Secret, format string) ([]byte, error) {
+	if format != outputFormatJSON {
+		return nil, fmt.Errorf("unsupported output format %q", format)
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, errors.New("no login result to format")
+	}
+
+	out := loginOutput{
+		ClientToken:      secret.Auth.ClientToken,
+		Accessor:         secret.Auth.Accessor,
+		LeaseDuration:    secret.Auth.LeaseDuration,
+		Renewable:        secret.Auth.Renewable,
+		Policies:         secret.Auth.Policies,
+		TokenPolicies:    secret.Auth.TokenPolicies,
+		IdentityPolicies: secret.Auth.IdentityPolicies,
+		Metadata:         secret.Auth.Metadata,
+		EntityID:         secret.Auth.EntityID,
+		Warnings:         secret.Warnings,
+	}
+
+	return json.MarshalIndent(out, "", "  ")
+}
@@ -0,0 +1,103 @@
+package jwtauth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestCLIHandler_Auth_JSONOutput(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/oidc/oidc/auth_url":
+			w.Write([]byte(`{"data": {"auth_url": "https://provider.example.com/auth?state=abc"}}`))
+		case "/v1/auth/oidc/oidc/callback":
+			w.Write([]byte(`{"auth": {"client_token": "s.token", "accessor": "accessor", "lease_duration": 3600, "renewable": true, "policies": ["default", "dev"], "metadata": {"role": "test"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	c, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	// The browser is replaced by a request to the callback listener.
+	origOpenBrowser := openBrowser
+	defer func() { openBrowser = origOpenBrowser }()
+	openBrowser = func(string) error {
+		go http.Get("http://127.0.0.1:" + port + "/oidc/callback?code=abc&state=abc")
+		return nil
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	origStderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = origStderr }()
+
+	h := &CLIHandler{}
+	secret, err := h.Auth(c, map[string]string{
+		"output_format": "json",
+		"listenaddress": "127.0.0.1",
+		"callbackhost":  "127.0.0.1",
+		"port":          port,
+	})
+	os.Stderr = origStderr
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stderr, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stderr) != 0 {
+		t.Fatalf("expected no output on stderr, got: %q", stderr)
+	}
+
+	out, err := h.FormatOutput(secret, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(out, &result); err != nil {
+		t.Fatalf("invalid JSON %q: %s", out, err)
+	}
+	if result["client_token"] != "s.token" || result["lease_duration"] != 3600.0 {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	if metadata, _ := result["metadata"].(map[string]interface{}); metadata["role"] != "test" {
+		t.Fatalf("unexpected metadata: %s", out)
+	}
+	if policies, _ := result["policies"].([]interface{}); len(policies) != 2 {
+		t.Fatalf("unexpected policies: %s", out)
+	}
+
+	if _, err := h.FormatOutput(secret, "yaml"); err == nil {
+		t.Fatal("expected error for unsupported format")
+	}
+	if _, err := h.Auth(c, map[string]string{"output_format": "yaml"}); err == nil {
+		t.Fatal("expected error for unsupported output_format")
+	}
+}
@@ -0,0 +1,93 @@
+package jwtauth
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressInterval is how often the progress indicator is updated.
+var progressInterval = time.Second
+
+var progressSpinner = []string{"|", "/", "-", "\\"}
+
+// progressIndicator shows the time elapsed while waiting for the OIDC
+// callback, so that a slow provider or MFA prompt doesn't look like a hang.
+type progressIndicator struct {
+	w     io.Writer
+	start time.Time
+	stop  chan struct{}
+	done  chan struct{}
+
+	stopOnce sync.Once
+}
+
+// startProgress starts writing the progress indicator to w once per
+// progressInterval. With enabled false, nothing is written.
+func startProgress(w io.Writer, enabled bool) *progressIndicator {
+	p := &progressIndicator{
+		w:     w,
+		start: time.Now(),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if !enabled {
+		close(p.done)
+		return p
+	}
+
+	go p.run()
+	return p
+}
+
+func (p *progressIndicator) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	var width int
+	for i := 0; ; i++ {
+		select {
+		case <-p.stop:
+			// Clear the line, so that later output starts on a clean line.
+			if width > 0 {
+				fmt.Fprintf(p.w, "\r%s\r", strings.Repeat(" ", width))
+			}
+			return
+		case <-ticker.C:
+			elapsed := time.Since(p.start).Round(time.Second)
+			line := fmt.Sprintf("%s Waiting for the OIDC provider (%s elapsed)", progressSpinner[i%len(progressSpinner)], elapsed)
+			if len(line) < width {
+				line += strings.Repeat(" ", width-len(line))
+			}
+			width = len(line)
+			fmt.Fprintf(p.w, "\r%s", line)
+		}
+	}
+}
+
+// Stop stops the indicator and waits for its line to be cleared. It is safe
+// to call Stop more than once.
+func (p *progressIndicator) Stop() {
+	p.stopOnce.Do(func() { close(p.stop) })
+	<-p.done
+}
+
+// progressEnabled reports whether the progress indicator should be written
+// to f. It is disabled by VAULT_CLI_NO_COLOR and VAULT_OIDC_NO_PROGRESS,
+// and when f is not a terminal.
+func progressEnabled(f *os.File) bool {
+	if os.Getenv("VAULT_CLI_NO_COLOR") != "" || os.Getenv("VAULT_OIDC_NO_PROGRESS") != "" {
+		return false
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,156 @@
+package jwtauth
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// syncBuffer is a bytes.Buffer safe for use by the progress goroutine and
+// the test.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWaitForCallback_Progress(t *testing.T) {
+	origInterval := progressInterval
+	progressInterval = 10 * time.Millisecond
+	defer func() { progressInterval = origInterval }()
+
+	secret := &api.Secret{}
+	tests := []struct {
+		name    string
+		exit    func(doneCh chan loginResp, sigintCh chan os.Signal)
+		timeout time.Duration
+		err     string
+	}{
+		{
+			"login completes",
+			func(doneCh chan loginResp, _ chan os.Signal) { doneCh <- loginResp{secret, nil} },
+			time.Minute,
+			"",
+		},
+		{
+			"callback error",
+			func(doneCh chan loginResp, _ chan os.Signal) { doneCh <- loginResp{nil, errors.New("callback failed")} },
+			time.Minute,
+			"callback failed",
+		},
+		{
+			"interrupted",
+			func(_ chan loginResp, sigintCh chan os.Signal) { sigintCh <- os.Interrupt },
+			time.Minute,
+			"Interrupted",
+		},
+		{
+			"timed out",
+			func(chan loginResp, chan os.Signal) {},
+			100 * time.Millisecond,
+			"timed out",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doneCh := make(chan loginResp)
+			sigintCh := make(chan os.Signal, 1)
+			var out syncBuffer
+
+			progress := startProgress(&out, true)
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				tt.exit(doneCh, sigintCh)
+			}()
+
+			s := waitForCallback(doneCh, sigintCh, tt.timeout, progress)
+			if tt.err == "" {
+				if s.err != nil || s.secret != secret {
+					t.Fatalf("unexpected result: %#v", s)
+				}
+			} else if s.err == nil || !strings.Contains(s.err.Error(), tt.err) {
+				t.Fatalf("expected error %q, got: %v", tt.err, s.err)
+			}
+
+			// The goroutine has exited once waitForCallback returns.
+			select {
+			case <-progress.done:
+			default:
+				t.Fatal("expected the progress goroutine to have exited")
+			}
+
+			// The indicator was written and its line cleared.
+			output := out.String()
+			if !strings.Contains(output, "Waiting for the OIDC provider") {
+				t.Fatalf("expected progress output, got: %q", output)
+			}
+			lines := strings.Split(output, "\r")
+			if last := lines[len(lines)-1]; last != "" {
+				t.Fatalf("expected the progress line to be cleared, got: %q", last)
+			}
+			if strings.TrimSpace(lines[len(lines)-2]) != "" {
+				t.Fatalf("expected the progress line to be blanked, got: %q", lines[len(lines)-2])
+			}
+		})
+	}
+}
+
+func TestProgressEnabled(t *testing.T) {
+	for _, env := range []string{"VAULT_OIDC_NO_PROGRESS", "VAULT_CLI_NO_COLOR"} {
+		orig, ok := os.LookupEnv(env)
+		os.Setenv(env, "1")
+		if progressEnabled(os.Stderr) {
+			t.Fatalf("expected progress to be disabled by %s", env)
+		}
+		if ok {
+			os.Setenv(env, orig)
+		} else {
+			os.Unsetenv(env)
+		}
+	}
+
+	// Files that aren't terminals don't get the indicator.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if progressEnabled(w) {
+		t.Fatal("expected progress to be disabled for a pipe")
+	}
+}
+
+func TestProgress_Disabled(t *testing.T) {
+	origInterval := progressInterval
+	progressInterval = 10 * time.Millisecond
+	defer func() { progressInterval = origInterval }()
+
+	var out syncBuffer
+	progress := startProgress(&out, false)
+	time.Sleep(50 * time.Millisecond)
+	progress.Stop()
+	progress.Stop()
+
+	if out.String() != "" {
+		t.Fatalf("expected no output, got: %q", out.String())
+	}
+}
@@ -1,6 +1,69 @@
 package jwtauth
 
-import "fmt"
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// callbackPages are the pages served by the CLI callback listener. Empty
+// pages are replaced by the default ones.
+type callbackPages struct {
+	success string
+
+	// errorTemplate is an error page whose {summary} and {detail} tokens
+	// are replaced by the error.
+	errorTemplate string
+}
+
+// loadCallbackPages reads the custom pages of success_html_file and
+// error_html_file, if set.
+func loadCallbackPages(successFile, errorFile string) (*callbackPages, error) {
+	pages := &callbackPages{}
+	if successFile != "" {
+		data, err := ioutil.ReadFile(successFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading success_html_file: %s", err)
+		}
+		pages.success = string(data)
+	}
+	if errorFile != "" {
+		data, err := ioutil.ReadFile(errorFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading error_html_file: %s", err)
+		}
+		pages.errorTemplate = string(data)
+	}
+
+	return pages, nil
+}
+
+// successHTML returns the page of a successful login. The wrapping TTL is
+// only shown on the default page.
+func (p *callbackPages) successHTML(wrapTTL time.Duration) string {
+	switch {
+	case p.success != "":
+		return p.success
+	case wrapTTL > 0:
+		return wrappedSuccessHTML(wrapTTL)
+	default:
+		return successHTML
+	}
+}
+
+// errorHTML returns the page of a failed login.
+func (p *callbackPages) errorHTML(summary, detail string) string {
+	if p.errorTemplate == "" {
+		return errorHTML(summary, detail)
+	}
+
+	return strings.NewReplacer(
+		"{summary}", html.EscapeString(summary),
+		"{detail}", html.EscapeString(detail),
+	).Replace(p.errorTemplate)
+}
 
 const successHTML = `
 <!DOCTYPE html>
@@ -144,6 +207,38 @@ const successHTML = `
 </html>
 `
 
+// wrappedSuccessHTML is successHTML for a login whose Vault token was
+// returned wrapped, valid for ttl. The wrapping token is not shown.
+func wrappedSuccessHTML(ttl time.Duration) string {
+	return strings.Replace(successHTML,
+		"You can now close this window and start using Vault.",
+		fmt.Sprintf("The Vault token was returned in a response-wrapping token valid for %s. You can now close this window and start using Vault.", ttl),
+		1)
+}
+
+// fragmentHTML is served to the browser when the provider returns the tokens
+// in the URL fragment, as in the implicit flow. The fragment is never sent to
+// the callback server, so it is resubmitted as the query string.
+const fragmentHTML = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <title>HashiCorp Vault</title>
+</head>
+<body>
+  <p id="message">Completing login...</p>
+  <script>
+    if (window.location.hash.length > 1) {
+      window.location.replace(window.location.pathname + "?" + window.location.hash.substring(1));
+    } else {
+      document.getElementById("message").textContent = "Login failed: no response from the OIDC provider.";
+    }
+  </script>
+</body>
+</html>
+`
+
 func errorHTML(summary, detail string) string {
 	const html = `
 <!DOCTYPE html>
@@ -1,8 +1,19 @@
 package jwtauth
 
 import (
+	"encoding/json"
 	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
 )
 
 func TestParseHelp(t *testing.T) {
@@ -42,6 +53,11 @@ func TestParseHelp(t *testing.T) {
 			summary: "No response from provider.",
 			detail:  "Because of reasons.",
 		},
+		{
+			err:     "Errors: * Authentication is too old. Because of reasons.",
+			summary: "Authentication is too old.",
+			detail:  "Because of reasons.",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -56,3 +72,418 @@ func TestParseHelp(t *testing.T) {
 		})
 	}
 }
+
+func TestCLIHandler_Auth_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/oidc/oidc/auth_url" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"data": {"auth_url": "https://provider.example.com/auth?state=abc"}}`))
+	}))
+	defer server.Close()
+
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	c, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	// The browser is never opened, so the callback never arrives.
+	origOpenBrowser := openBrowser
+	defer func() { openBrowser = origOpenBrowser }()
+	openBrowser = func(string) error { return nil }
+
+	h := &CLIHandler{}
+	start := time.Now()
+	_, err = h.Auth(c, map[string]string{
+		"listenaddress": "127.0.0.1",
+		"port":          port,
+		"timeout":       "200ms",
+	})
+	elapsed := time.Since(start)
+	if err == nil || err.Error() != "OIDC login timed out after 200ms" {
+		t.Fatalf("expected timeout error, got: %v", err)
+	}
+	if elapsed < 200*time.Millisecond || elapsed > 5*time.Second {
+		t.Fatalf("expected the timeout to fire after 200ms, took %s", elapsed)
+	}
+
+	// The callback listener was closed.
+	l, err = net.Listen("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		t.Fatalf("expected port %s to be unbound: %v", port, err)
+	}
+	l.Close()
+
+	if _, err := h.Auth(c, map[string]string{"timeout": "-1s"}); err == nil {
+		t.Fatal("expected error for negative timeout")
+	}
+}
+
+func TestWaitForCallback_Timeout(t *testing.T) {
+	secret := &api.Secret{Auth: &api.SecretAuth{ClientToken: "s.login"}}
+
+	// Without a timeout, a late callback still completes the login.
+	doneCh := make(chan loginResp)
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		doneCh <- loginResp{secret, nil}
+	}()
+	s := waitForCallback(doneCh, make(chan os.Signal), 0, startProgress(ioutil.Discard, false))
+	if s.err != nil || s.secret != secret {
+		t.Fatalf("expected the callback response, got: %#v", s)
+	}
+
+	s = waitForCallback(make(chan loginResp), make(chan os.Signal), 100*time.Millisecond, startProgress(ioutil.Discard, false))
+	if s.err == nil || s.err.Error() != "OIDC login timed out after 100ms" {
+		t.Fatalf("expected timeout error, got: %v", s.err)
+	}
+}
+
+func TestCheckAuthURLSignature(t *testing.T) {
+	const unsignedURL = "https://provider.example.com/auth?state=abc"
+	const signedURL = unsignedURL + "&" + authURLSignatureParam + "=sig"
+
+	tests := map[string]struct {
+		authURL   string
+		status    int
+		response  string
+		expectErr string
+	}{
+		"signed":                  {signedURL, 200, `{"data": {"valid": true, "signing_required": true}}`, ""},
+		"tampered":                {signedURL, 200, `{"data": {"valid": false, "signing_required": true}}`, "signature could not be verified"},
+		"signature stripped":      {unsignedURL, 200, `{"data": {"valid": false, "signing_required": true}}`, "URL is not signed"},
+		"signing not configured":  {unsignedURL, 200, `{"data": {"valid": false, "signing_required": false}}`, ""},
+		"unsupported endpoint":    {unsignedURL, 404, `{"errors": ["unsupported path"]}`, ""},
+		"signed without endpoint": {signedURL, 404, `{"errors": ["unsupported path"]}`, "unsupported path"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/auth/oidc/oidc/verify_auth_url" {
+					t.Errorf("unexpected path: %q", r.URL.Path)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			config := api.DefaultConfig()
+			config.Address = server.URL
+			c, err := api.NewClient(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = checkAuthURLSignature(c, "oidc", tt.authURL)
+			if tt.expectErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.expectErr != "" && (err == nil || !strings.Contains(err.Error(), tt.expectErr)) {
+				t.Fatalf("expected error %q, got: %v", tt.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestCLIHandler_Auth_PrintOnly(t *testing.T) {
+	const authURL = "https://provider.example.com/auth?state=abc"
+	var codeChallenge string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/oidc/oidc/auth_url" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		codeChallenge, _ = body["code_challenge"].(string)
+		w.Write([]byte(`{"data": {"auth_url": "` + authURL + `", "pow_puzzle": "puzzle", "pow_difficulty": 4}}`))
+	}))
+	defer server.Close()
+
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	c, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	origOpenBrowser := openBrowser
+	defer func() { openBrowser = origOpenBrowser }()
+	openBrowser = func(string) error {
+		t.Error("unexpected browser launch")
+		return nil
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	origStdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = origStdout }()
+
+	h := &CLIHandler{}
+	secret, err := h.Auth(c, map[string]string{
+		"print_only":    "true",
+		"timeout":       "1m",
+		"listenaddress": "127.0.0.1",
+		"port":          port,
+	})
+	os.Stdout = origStdout
+	w.Close()
+
+	if err != ErrPrintOnlyMode {
+		t.Fatalf("expected ErrPrintOnlyMode, got: %v", err)
+	}
+	if secret != nil {
+		t.Fatalf("unexpected secret: %#v", secret)
+	}
+
+	stdout, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The output has everything needed to complete the login at the OIDC
+	// callback.
+	lines := strings.Split(strings.TrimSuffix(string(stdout), "\n"), "\n")
+	if len(lines) != 3 || lines[0] != authURL {
+		t.Fatalf("expected the auth URL, code verifier and proof of work solution on stdout, got: %q", stdout)
+	}
+	codeVerifier := strings.TrimPrefix(lines[1], "code_verifier=")
+	if codeVerifier == lines[1] || codeChallenge == "" || pkceChallenge(codeVerifier) != codeChallenge {
+		t.Fatalf("code verifier %q doesn't match the code challenge %q", lines[1], codeChallenge)
+	}
+	if lines[2] != "pow_solution="+solvePoW("puzzle", 4) {
+		t.Fatalf("unexpected proof of work solution: %q", lines[2])
+	}
+
+	// No callback listener was started.
+	l, err = net.Listen("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		t.Fatalf("expected port %s to be unbound: %v", port, err)
+	}
+	l.Close()
+}
+
+func TestCLIHandler_Auth_WrapTTL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/oidc/oidc/auth_url":
+			w.Write([]byte(`{"data": {"auth_url": "https://provider.example.com/auth?state=abc"}}`))
+		case "/v1/auth/oidc/oidc/callback":
+			if ttl := r.Header.Get("X-Vault-Wrap-TTL"); ttl != "5m" {
+				t.Errorf("expected a wrapped callback request, got wrap TTL %q", ttl)
+			}
+			w.Write([]byte(`{"wrap_info": {"token": "s.wrapping", "ttl": 300}}`))
+		case "/v1/sys/wrapping/unwrap":
+			if token := r.Header.Get("X-Vault-Token"); token != "s.wrapping" {
+				t.Errorf("expected the wrapping token to be unwrapped, got %q", token)
+			}
+			w.Write([]byte(`{"auth": {"client_token": "s.token", "lease_duration": 3600}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	c, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	// The browser is replaced by a request to the callback listener, whose
+	// page is checked once the login completes.
+	pageCh := make(chan string, 1)
+	origOpenBrowser := openBrowser
+	defer func() { openBrowser = origOpenBrowser }()
+	openBrowser = func(string) error {
+		go func() {
+			resp, err := http.Get("http://127.0.0.1:" + port + "/oidc/callback?code=abc&state=abc")
+			if err != nil {
+				pageCh <- err.Error()
+				return
+			}
+			defer resp.Body.Close()
+			page, _ := ioutil.ReadAll(resp.Body)
+			pageCh <- string(page)
+		}()
+		return nil
+	}
+
+	h := &CLIHandler{}
+	secret, err := h.Auth(c, map[string]string{
+		"output_format": "json",
+		"listenaddress": "127.0.0.1",
+		"callbackhost":  "127.0.0.1",
+		"port":          port,
+		"wrap_ttl":      "5m",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Auth == nil || secret.Auth.ClientToken != "s.token" || secret.WrapInfo != nil {
+		t.Fatalf("expected the unwrapped secret, got: %#v", secret)
+	}
+	if c.Token() != "" {
+		t.Fatalf("expected the client token to be unchanged, got %q", c.Token())
+	}
+
+	page := <-pageCh
+	if !strings.Contains(page, "valid for 5m0s") {
+		t.Fatalf("expected the wrapping token TTL in the page, got: %s", page)
+	}
+	if strings.Contains(page, "s.wrapping") || strings.Contains(page, "s.token") {
+		t.Fatal("expected no token in the page")
+	}
+
+	if _, err := h.Auth(c, map[string]string{"wrap_ttl": "soon"}); err == nil {
+		t.Fatal("expected error for invalid wrap_ttl")
+	}
+}
+
+func TestCLIHandler_Auth_CustomPages(t *testing.T) {
+	callbackErr := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/oidc/oidc/auth_url":
+			w.Write([]byte(`{"data": {"auth_url": "https://provider.example.com/auth?state=abc"}}`))
+		case "/v1/auth/oidc/oidc/callback":
+			if callbackErr {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errors": ["<b>invalid state</b>"]}`))
+				return
+			}
+			w.Write([]byte(`{"auth": {"client_token": "s.token", "lease_duration": 3600}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	c, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "vault-oidc-pages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	errorFile := filepath.Join(dir, "error.html")
+	if err := ioutil.WriteFile(errorFile, []byte("<p>Acme login failed: {summary} ({detail})</p>"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(m map[string]string) (*api.Secret, string, error) {
+		t.Helper()
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, port, _ := net.SplitHostPort(l.Addr().String())
+		l.Close()
+
+		pageCh := make(chan string, 1)
+		origOpenBrowser := openBrowser
+		defer func() { openBrowser = origOpenBrowser }()
+		openBrowser = func(string) error {
+			go func() {
+				resp, err := http.Get("http://127.0.0.1:" + port + "/oidc/callback?code=abc&state=abc")
+				if err != nil {
+					pageCh <- err.Error()
+					return
+				}
+				defer resp.Body.Close()
+				page, _ := ioutil.ReadAll(resp.Body)
+				pageCh <- string(page)
+			}()
+			return nil
+		}
+
+		m["output_format"] = "json"
+		m["listenaddress"] = "127.0.0.1"
+		m["callbackhost"] = "127.0.0.1"
+		m["port"] = port
+		secret, err := (&CLIHandler{}).Auth(c, m)
+		return secret, <-pageCh, err
+	}
+
+	_, page, err := login(map[string]string{"error_html_file": errorFile})
+	if err == nil {
+		t.Fatal("expected login error")
+	}
+	if !strings.HasPrefix(page, "<p>Acme login failed: ") || !strings.Contains(page, "&lt;b&gt;invalid state&lt;/b&gt;") {
+		t.Fatalf("expected the custom error page, got: %s", page)
+	}
+	if strings.Contains(page, "{summary}") || strings.Contains(page, "{detail}") {
+		t.Fatalf("expected the tokens to be replaced, got: %s", page)
+	}
+
+	// Only the configured page is replaced.
+	callbackErr = false
+	_, page, err = login(map[string]string{"error_html_file": errorFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page != successHTML {
+		t.Fatalf("expected the default success page, got: %s", page)
+	}
+
+	successFile := filepath.Join(dir, "success.html")
+	if err := ioutil.WriteFile(successFile, []byte("<p>Welcome to Acme</p>"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	secret, page, err := login(map[string]string{"success_html_file": successFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Auth == nil || secret.Auth.ClientToken != "s.token" {
+		t.Fatalf("unexpected secret: %#v", secret)
+	}
+	if page != "<p>Welcome to Acme</p>" {
+		t.Fatalf("expected the custom success page, got: %s", page)
+	}
+
+	// Pages that can't be read are replaced by the default ones.
+	_, page, err = login(map[string]string{"success_html_file": filepath.Join(dir, "missing.html")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page != successHTML {
+		t.Fatalf("expected the default success page, got: %s", page)
+	}
+}
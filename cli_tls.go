@@ -0,0 +1,121 @@
+package jwtauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+// callbackCertLifetime is the validity period of the self-signed callback
+// listener certificate. It only needs to outlive a single login.
+const callbackCertLifetime = time.Hour
+
+// listenCallback opens the OIDC callback listener. With cert, the listener
+// serves HTTPS with that certificate. Otherwise, with useTLS, the listener
+// serves HTTPS with a freshly generated self-signed certificate for host, and
+// the certificate's SHA-256 fingerprint is returned so that users can confirm
+// it when the browser warns about it. The certificate is only kept in memory.
+func listenCallback(address, host string, useTLS bool, cert *tls.Certificate) (net.Listener, string, error) {
+	if !useTLS && cert == nil {
+		listener, err := net.Listen("tcp", address)
+		return listener, "", err
+	}
+
+	var fingerprint string
+	if cert == nil {
+		selfSigned, err := selfSignedCert(host)
+		if err != nil {
+			return nil, "", fmt.Errorf("error generating callback certificate: %s", err)
+		}
+		cert = &selfSigned
+		fingerprint = certFingerprint(cert.Certificate[0])
+	}
+
+	listener, err := tls.Listen("tcp", address, &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return listener, fingerprint, nil
+}
+
+// loadCallbackCert loads the callback listener certificate from the PEM
+// files set with callbacktlscert and callbacktlskey. nil is returned if
+// neither is set.
+func loadCallbackCert(certFile, keyFile string) (*tls.Certificate, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("callbacktlscert and callbacktlskey must be set together")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading callback certificate: %s", err)
+	}
+
+	return &cert, nil
+}
+
+// selfSignedCert returns a self-signed RSA certificate valid for host.
+func selfSignedCert(host string) (tls.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: host},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(callbackCertLifetime),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}
+
+// certFingerprint returns the SHA-256 fingerprint of a DER certificate in the
+// colon-separated hex form shown by browsers.
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
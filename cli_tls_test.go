@@ -0,0 +1,316 @@
+package jwtauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestListenCallback_TLS(t *testing.T) {
+	listener, fingerprint, err := listenCallback("127.0.0.1:0", "localhost", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         "localhost",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) != 1 {
+		t.Fatalf("expected a single certificate, got %d", len(certs))
+	}
+	if got := certFingerprint(certs[0].Raw); got != fingerprint {
+		t.Fatalf("expected fingerprint %q, got %q", fingerprint, got)
+	}
+	if err := certs[0].VerifyHostname("localhost"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new certificate is generated for every listener.
+	other, otherFingerprint, err := listenCallback("127.0.0.1:0", "localhost", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Close()
+	if otherFingerprint == fingerprint {
+		t.Fatal("expected a new certificate for each listener")
+	}
+
+	plain, fingerprint, err := listenCallback("127.0.0.1:0", "localhost", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain.Close()
+	if fingerprint != "" {
+		t.Fatalf("expected no fingerprint without TLS, got %q", fingerprint)
+	}
+}
+
+func TestFetchAuthURL_HTTPSRedirect(t *testing.T) {
+	var redirectURI string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		redirectURI, _ = body["redirect_uri"].(string)
+		w.Write([]byte(`{"data": {"auth_url": "https://example.com/auth"}}`))
+	}))
+	defer server.Close()
+
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	c, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := fetchAuthURL(c, "test", "oidc", "8250", defaultTLSCallbackMethod, "localhost", "", "", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if redirectURI != "https://localhost:8250/oidc/callback" {
+		t.Fatalf("unexpected redirect_uri: %q", redirectURI)
+	}
+}
+
+// writeTestCert writes a certificate for localhost, signed by a new test
+// CA, and its key to dir. The CA certificate is returned.
+func writeTestCert(t *testing.T, dir, name string) *x509.Certificate {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return ca
+}
+
+func TestListenCallback_UserCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-oidc-callback-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := writeTestCert(t, dir, "callback")
+	writeTestCert(t, dir, "other")
+
+	cert, err := loadCallbackCert(filepath.Join(dir, "callback.crt"), filepath.Join(dir, "callback.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, fingerprint, err := listenCallback("127.0.0.1:0", "localhost", false, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if fingerprint != "" {
+		t.Fatalf("expected no fingerprint for a user certificate, got %q", fingerprint)
+	}
+
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	// The handshake succeeds with full verification against the CA.
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+		RootCAs:    roots,
+		ServerName: "localhost",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	for _, tt := range []struct {
+		name     string
+		certFile string
+		keyFile  string
+		errMsg   string
+	}{
+		{"cert only", "callback.crt", "", "must be set together"},
+		{"key only", "", "callback.key", "must be set together"},
+		{"missing file", "missing.crt", "callback.key", "no such file"},
+		{"key mismatch", "callback.crt", "other.key", "error loading callback certificate"},
+		{"not a certificate", "callback.key", "callback.key", "error loading callback certificate"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var certFile, keyFile string
+			if tt.certFile != "" {
+				certFile = filepath.Join(dir, tt.certFile)
+			}
+			if tt.keyFile != "" {
+				keyFile = filepath.Join(dir, tt.keyFile)
+			}
+			_, err := loadCallbackCert(certFile, keyFile)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("expected error %q, got: %v", tt.errMsg, err)
+			}
+		})
+	}
+
+	if cert, err := loadCallbackCert("", ""); cert != nil || err != nil {
+		t.Fatalf("expected no certificate, got: %v, %v", cert, err)
+	}
+}
+
+func TestListenCallback_IPv6(t *testing.T) {
+	listener, _, err := listenCallback(joinHostPort("::1", "0"), "::1", false, nil)
+	if err != nil {
+		t.Skipf("IPv6 loopback is not available: %s", err)
+	}
+	defer listener.Close()
+
+	if host, _, _ := net.SplitHostPort(listener.Addr().String()); host != "::1" {
+		t.Fatalf("expected listener on ::1, got %s", listener.Addr())
+	}
+
+	// The self-signed certificate is valid for the IPv6 host.
+	tlsListener, _, err := listenCallback(joinHostPort("[::1]", "0"), unbracketHost("[::1]"), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tlsListener.Close()
+	go http.Serve(tlsListener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	conn, err := tls.Dial("tcp", tlsListener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.ConnectionState().PeerCertificates[0].VerifyHostname("::1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFetchAuthURL_IPv6Redirect(t *testing.T) {
+	var redirectURI string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		redirectURI, _ = body["redirect_uri"].(string)
+		w.Write([]byte(`{"data": {"auth_url": "https://example.com/auth"}}`))
+	}))
+	defer server.Close()
+
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	c, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, host := range []string{"::1", "[::1]"} {
+		if _, _, err := fetchAuthURL(c, "test", "oidc", "8250", defaultCallbackMethod, host, "", "", "", ""); err != nil {
+			t.Fatal(err)
+		}
+		if redirectURI != "http://[::1]:8250/oidc/callback" {
+			t.Fatalf("unexpected redirect_uri for host %q: %q", host, redirectURI)
+		}
+	}
+}
+
+func TestOpenURLCommand(t *testing.T) {
+	const url = "http://[::1]:8250/oidc/callback?a=1&b=2"
+
+	for _, tt := range []struct {
+		goos string
+		wsl  bool
+		cmd  string
+		args []string
+	}{
+		{"windows", false, "cmd.exe", []string{"/c", "start", "http://[::1]:8250/oidc/callback?a=1^&b=2"}},
+		{"linux", true, "cmd.exe", []string{"/c", "start", "http://[::1]:8250/oidc/callback?a=1^&b=2"}},
+		{"darwin", false, "open", []string{url}},
+		{"linux", false, "xdg-open", []string{url}},
+		{"freebsd", false, "xdg-open", []string{url}},
+	} {
+		cmd, args := openURLCommand(tt.goos, tt.wsl, url)
+		if cmd != tt.cmd || !reflect.DeepEqual(args, tt.args) {
+			t.Fatalf("%s (wsl: %t): unexpected command %s %v", tt.goos, tt.wsl, cmd, args)
+		}
+	}
+}
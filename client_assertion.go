@@ -0,0 +1,204 @@
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	clientAuthMethodSecretBasic   = "client_secret_basic"
+	clientAuthMethodSecretPost    = "client_secret_post"
+	clientAuthMethodPrivateKeyJWT = "private_key_jwt"
+
+	// clientAssertionType is the client_assertion_type of private_key_jwt
+	// client authentication (RFC 7523 section 2.2).
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	// clientAssertionLifetime is how long a client assertion is valid.
+	clientAssertionLifetime = 5 * time.Minute
+
+	// clientKeyPrefix is the storage prefix of the per-role private keys
+	// used for private_key_jwt client authentication.
+	clientKeyPrefix = "client_keys/"
+
+	// maxClientKeyVersions is the number of previous client key versions
+	// kept on rotation.
+	maxClientKeyVersions = 2
+)
+
+// clientKeyVersion is a version of a role's client private key.
+type clientKeyVersion struct {
+	Version int       `json:"version"`
+	Key     string    `json:"key"`
+	KeyID   string    `json:"key_id"`
+	Created time.Time `json:"created"`
+
+	// Retired is when the version was replaced by a newer one, or zero for
+	// the current version.
+	Retired time.Time `json:"retired"`
+}
+
+// clientKeys holds the versions of a role's client private key, newest
+// first.
+type clientKeys struct {
+	Versions []clientKeyVersion `json:"versions"`
+}
+
+// current returns the current version, or nil if no key has been set.
+func (k *clientKeys) current() *clientKeyVersion {
+	if k == nil || len(k.Versions) == 0 {
+		return nil
+	}
+	return &k.Versions[0]
+}
+
+// rotate makes keyPEM the current version, keeping up to
+// maxClientKeyVersions previous versions.
+func (k *clientKeys) rotate(keyPEM, keyID string) {
+	now := time.Now()
+	version := 1
+	if current := k.current(); current != nil {
+		version = current.Version + 1
+		current.Retired = now
+	}
+
+	k.Versions = append([]clientKeyVersion{{
+		Version: version,
+		Key:     keyPEM,
+		KeyID:   keyID,
+		Created: now,
+	}}, k.Versions...)
+	if len(k.Versions) > maxClientKeyVersions+1 {
+		k.Versions = k.Versions[:maxClientKeyVersions+1]
+	}
+}
+
+func readClientKeys(ctx context.Context, s logical.Storage, roleName string) (*clientKeys, error) {
+	entry, err := s.Get(ctx, clientKeyPrefix+roleName)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	keys := new(clientKeys)
+	if err := entry.DecodeJSON(keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func putClientKeys(ctx context.Context, s logical.Storage, roleName string, keys *clientKeys) error {
+	entry, err := logical.StorageEntryJSON(clientKeyPrefix+roleName, keys)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// validateClientPrivateKey checks that keyPEM can sign client assertions.
+func validateClientPrivateKey(keyPEM string) error {
+	key, err := parseEncryptionKey(keyPEM)
+	if err != nil {
+		return err
+	}
+	_, err = requestObjectAlgorithm(key)
+	return err
+}
+
+// clientAssertion returns a client assertion for clientID, signed with the
+// key of version, for the token endpoint at tokenURL (RFC 7523 section 3).
+func clientAssertion(version *clientKeyVersion, clientID, tokenURL string) (string, error) {
+	key, err := parseEncryptionKey(version.Key)
+	if err != nil {
+		return "", err
+	}
+
+	alg, err := requestObjectAlgorithm(key)
+	if err != nil {
+		return "", err
+	}
+
+	opts := (&jose.SignerOptions{}).WithType("JWT")
+	if version.KeyID != "" {
+		opts = opts.WithHeader("kid", version.KeyID)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, opts)
+	if err != nil {
+		return "", err
+	}
+
+	jti, err := uuid.GenerateUUID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := jwt.Claims{
+		Issuer:   clientID,
+		Subject:  clientID,
+		Audience: jwt.Audience{tokenURL},
+		ID:       jti,
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(clientAssertionLifetime)),
+	}
+
+	assertion, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		return "", fmt.Errorf("error signing client assertion: %v", err)
+	}
+
+	return assertion, nil
+}
+
+// clientAuthOptions configures oauth2Config to authenticate to the token
+// endpoint with the role's oidc_client_auth_method, and returns the
+// parameters to add to the token request. If the method is unset, the style
+// is detected by trying client_secret_basic and then client_secret_post.
+func clientAuthOptions(ctx context.Context, s logical.Storage, role *jwtRole, roleName string, oauth2Config *oauth2.Config) ([]oauth2.AuthCodeOption, error) {
+	switch role.OIDCClientAuthMethod {
+	case clientAuthMethodSecretBasic:
+		oauth2Config.Endpoint.AuthStyle = oauth2.AuthStyleInHeader
+		return nil, nil
+	case clientAuthMethodSecretPost:
+		oauth2Config.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+		return nil, nil
+	case clientAuthMethodPrivateKeyJWT:
+	default:
+		oauth2Config.Endpoint.AuthStyle = oauth2.AuthStyleAutoDetect
+		return nil, nil
+	}
+
+	keys, err := readClientKeys(ctx, s, roleName)
+	if err != nil {
+		return nil, err
+	}
+	version := keys.current()
+	if version == nil {
+		return nil, errors.New("no client private key is set for private_key_jwt client authentication")
+	}
+
+	assertion, err := clientAssertion(version, oauth2Config.ClientID, oauth2Config.Endpoint.TokenURL)
+	if err != nil {
+		return nil, errwrap.Wrapf("error creating client assertion: {{err}}", err)
+	}
+
+	// The client secret is not sent, only the client ID in the request
+	// body.
+	oauth2Config.ClientSecret = ""
+	oauth2Config.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("client_assertion_type", clientAssertionType),
+		oauth2.SetAuthURLParam("client_assertion", assertion),
+	}, nil
+}
@@ -0,0 +1,311 @@
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/certutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// tokenClientAuthMethod returns how the client authenticated to the token
+// endpoint with r.
+func tokenClientAuthMethod(r *http.Request) string {
+	if _, _, ok := r.BasicAuth(); ok {
+		return clientAuthMethodSecretBasic
+	}
+	switch {
+	case r.FormValue("client_secret") != "":
+		return clientAuthMethodSecretPost
+	case r.FormValue("client_assertion") != "":
+		return clientAuthMethodPrivateKeyJWT
+	}
+	return ""
+}
+
+// verifyClientAssertion checks the private_key_jwt client assertion of a
+// token request.
+func (o *oidcProvider) verifyClientAssertion(r *http.Request) error {
+	if r.FormValue("client_assertion_type") != clientAssertionType {
+		return errors.New("invalid client_assertion_type")
+	}
+	if r.FormValue("client_secret") != "" {
+		return errors.New("unexpected client_secret")
+	}
+
+	token, err := jwt.ParseSigned(r.FormValue("client_assertion"))
+	if err != nil {
+		return err
+	}
+	key, err := certutil.ParsePublicKeyPEM([]byte(o.clientAssertionKey))
+	if err != nil {
+		return err
+	}
+
+	var claims jwt.Claims
+	if err := token.Claims(key, &claims); err != nil {
+		return err
+	}
+	if claims.ID == "" {
+		return errors.New("missing jti")
+	}
+
+	return claims.Validate(jwt.Expected{
+		Issuer:   o.clientID,
+		Subject:  o.clientID,
+		Audience: jwt.Audience{o.server.URL + "/token"},
+		Time:     time.Now(),
+	})
+}
+
+func TestClientAssertion(t *testing.T) {
+	privKey, pubKey := newTestECKey(t)
+
+	version := &clientKeyVersion{Version: 1, Key: privKey, KeyID: "key-1"}
+	assertion, err := clientAssertion(version, "abc", "https://provider.example.com/token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := jwt.ParseSigned(assertion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := token.Headers[0].KeyID; kid != "key-1" {
+		t.Fatalf("unexpected kid: %q", kid)
+	}
+
+	key, err := certutil.ParsePublicKeyPEM([]byte(pubKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims jwt.Claims
+	if err := token.Claims(key, &claims); err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Issuer != "abc" || claims.Subject != "abc" {
+		t.Fatalf("unexpected iss or sub: %q, %q", claims.Issuer, claims.Subject)
+	}
+	if !claims.Audience.Contains("https://provider.example.com/token") {
+		t.Fatalf("unexpected aud: %v", claims.Audience)
+	}
+	if claims.ID == "" {
+		t.Fatal("missing jti")
+	}
+	if claims.Expiry == nil || !claims.Expiry.Time().After(time.Now()) {
+		t.Fatalf("unexpected exp: %v", claims.Expiry)
+	}
+
+	other, err := clientAssertion(version, "abc", "https://provider.example.com/token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, _ := jwt.ParseSigned(other)
+	var otherClaims jwt.Claims
+	otherToken.UnsafeClaimsWithoutVerification(&otherClaims)
+	if otherClaims.ID == claims.ID {
+		t.Fatal("expected a unique jti for each assertion")
+	}
+}
+
+func TestOIDC_Callback_ClientAuthMethod(t *testing.T) {
+	privKey, pubKey := newTestECKey(t)
+
+	tests := map[string]struct {
+		roleData           map[string]interface{}
+		clientAssertionKey string
+		rejectSecretBasic  bool
+		expectedMethod     string
+		expectErr          bool
+	}{
+		"default": {
+			expectedMethod: clientAuthMethodSecretBasic,
+		},
+		"default without client_secret_basic support": {
+			rejectSecretBasic: true,
+			expectedMethod:    clientAuthMethodSecretPost,
+		},
+		"client_secret_basic": {
+			roleData:       map[string]interface{}{"oidc_client_auth_method": "client_secret_basic"},
+			expectedMethod: clientAuthMethodSecretBasic,
+		},
+		"client_secret_basic without provider support": {
+			roleData:          map[string]interface{}{"oidc_client_auth_method": "client_secret_basic"},
+			rejectSecretBasic: true,
+			expectedMethod:    clientAuthMethodSecretBasic,
+			expectErr:         true,
+		},
+		"client_secret_post": {
+			roleData:       map[string]interface{}{"oidc_client_auth_method": "client_secret_post"},
+			expectedMethod: clientAuthMethodSecretPost,
+		},
+		"private_key_jwt": {
+			roleData: map[string]interface{}{
+				"oidc_client_auth_method": "private_key_jwt",
+				"oidc_client_private_key": privKey,
+				"oidc_client_key_id":      "key-1",
+			},
+			clientAssertionKey: pubKey,
+			expectedMethod:     clientAuthMethodPrivateKeyJWT,
+		},
+		"private_key_jwt wrong key": {
+			roleData: map[string]interface{}{
+				"oidc_client_auth_method": "private_key_jwt",
+				"oidc_client_private_key": privKey,
+			},
+			clientAssertionKey: ecdsaPubKey,
+			expectedMethod:     clientAuthMethodPrivateKeyJWT,
+			expectErr:          true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			b, storage, s := getBackendAndServer(t, false)
+			defer s.server.Close()
+
+			s.clientAssertionKey = tt.clientAssertionKey
+			s.rejectSecretBasic = tt.rejectSecretBasic
+
+			if tt.roleData != nil {
+				resp, err := b.HandleRequest(context.Background(), &logical.Request{
+					Operation: logical.UpdateOperation,
+					Path:      "role/test",
+					Storage:   storage,
+					Data:      tt.roleData,
+				})
+				if err != nil || resp.IsError() {
+					t.Fatalf("err:%v resp:%#v", err, resp)
+				}
+			}
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "oidc/auth_url",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"role":         "test",
+					"redirect_uri": "https://example.com",
+				},
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("err:%v resp:%#v", err, resp)
+			}
+
+			authURL := resp.Data["auth_url"].(string)
+			s.customClaims = sampleClaims(getQueryParam(t, authURL, "nonce"))
+			s.code = "abc"
+
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      "oidc/callback",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"state": getQueryParam(t, authURL, "state"),
+					"code":  "abc",
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.IsError() != tt.expectErr {
+				t.Fatalf("expected error: %t, got: %v", tt.expectErr, resp.Error())
+			}
+			if s.clientAuthMethod != tt.expectedMethod {
+				t.Fatalf("expected client authentication %q, got %q", tt.expectedMethod, s.clientAuthMethod)
+			}
+		})
+	}
+}
+
+func TestPath_ClientPrivateKey(t *testing.T) {
+	b, storage := getBackend(t)
+
+	write := func(data map[string]interface{}) *logical.Response {
+		t.Helper()
+		data["role_type"] = "jwt"
+		data["user_claim"] = "user"
+		data["bound_subject"] = "subject"
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/test",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	read := func() map[string]interface{} {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "role/test",
+			Storage:   storage,
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		return resp.Data
+	}
+
+	for _, tt := range []struct {
+		data   map[string]interface{}
+		errMsg string
+	}{
+		{map[string]interface{}{"oidc_client_auth_method": "tls_client_auth"}, "invalid 'oidc_client_auth_method'"},
+		{map[string]interface{}{"oidc_client_auth_method": "private_key_jwt"}, "'oidc_client_private_key' must be set"},
+		{map[string]interface{}{"oidc_client_key_id": "key-1"}, "'oidc_client_key_id' requires 'oidc_client_private_key'"},
+		{map[string]interface{}{"oidc_client_private_key": ecdsaPubKey}, "error parsing 'oidc_client_private_key'"},
+	} {
+		resp := write(tt.data)
+		if !resp.IsError() || !strings.Contains(resp.Error().Error(), tt.errMsg) {
+			t.Fatalf("expected error %q, got: %v", tt.errMsg, resp)
+		}
+	}
+
+	key1, _ := newTestECKey(t)
+	key2, _ := newTestECKey(t)
+	if resp := write(map[string]interface{}{"oidc_client_auth_method": "private_key_jwt", "oidc_client_private_key": key1, "oidc_client_key_id": "key-1"}); resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+	if resp := write(map[string]interface{}{"oidc_client_private_key": key2, "oidc_client_key_id": "key-2"}); resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+
+	data := read()
+	if data["oidc_client_key_id"] != "key-2" || data["oidc_client_key_version"] != 2 {
+		t.Fatalf("unexpected client key: %v, %v", data["oidc_client_key_id"], data["oidc_client_key_version"])
+	}
+	if _, ok := data["oidc_client_private_key"]; ok {
+		t.Fatal("the client private key must not be returned")
+	}
+
+	keys, err := readClientKeys(context.Background(), storage, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys.Versions) != 2 || keys.Versions[1].Key != key1 || keys.Versions[1].Retired.IsZero() {
+		t.Fatalf("expected the previous version to be kept: %#v", keys.Versions)
+	}
+
+	// The key is deleted with the role.
+	if _, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "role/test",
+		Storage:   storage,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := readClientKeys(context.Background(), storage, "test"); err != nil || keys != nil {
+		t.Fatalf("expected the client keys to be deleted, err: %v", err)
+	}
+}
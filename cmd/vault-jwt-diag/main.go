@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	jwtauth "github.com/hashicorp/vault-plugin-auth-jwt"
+)
+
+func main() {
+	configFile := flag.String("config", "", "JSON file with the auth method config, in the format of the config endpoint")
+	roleFile := flag.String("role", "", "JSON file with the role, in the format of the role endpoint (optional)")
+	flag.Parse()
+
+	if *configFile == "" {
+		fmt.Fprintln(os.Stderr, "usage: vault-jwt-diag -config <file> [-role <file>] < token")
+		os.Exit(2)
+	}
+
+	configData, err := readJSONFile(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	var roleData map[string]interface{}
+	if *roleFile != "" {
+		roleData, err = readJSONFile(*roleFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	token, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error reading token from stdin:", err)
+		os.Exit(2)
+	}
+
+	ok := true
+	for _, step := range jwtauth.Diagnose(context.Background(), configData, roleData, strings.TrimSpace(string(token))) {
+		status := "[ OK ]"
+		if !step.OK {
+			status = "[FAIL]"
+			ok = false
+		}
+		fmt.Println(status, step.Name)
+		for _, line := range strings.Split(step.Detail, "\n") {
+			if line != "" {
+				fmt.Println("       " + line)
+			}
+		}
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func readJSONFile(path string) (map[string]interface{}, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", path, err)
+	}
+
+	return data, nil
+}
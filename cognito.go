@@ -0,0 +1,112 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	cognitoTokenUseID     = "id"
+	cognitoTokenUseAccess = "access"
+
+	// cognitoGroupsClaim is the groups_claim of Cognito roles that don't set
+	// one.
+	cognitoGroupsClaim = "cognito:groups"
+)
+
+// cognitoIssuerFormat is the issuer of the tokens of a Cognito user pool,
+// given its region and ID. The pool's keys are at the issuer's
+// /.well-known/jwks.json.
+var cognitoIssuerFormat = "https://cognito-idp.%s.amazonaws.com/%s"
+
+// cognitoTokenUse returns the token_use claim that tokens of the role must
+// have.
+func (r *jwtRole) cognitoTokenUse() string {
+	if r.CognitoTokenUse == "" {
+		return cognitoTokenUseID
+	}
+	return r.CognitoTokenUse
+}
+
+// verifyCognitoToken verifies token as a token of the role's Cognito user
+// pool, and returns its claims.
+func (b *jwtAuthBackend) verifyCognitoToken(ctx context.Context, config *jwtConfig, role *jwtRole, token string) (map[string]interface{}, error) {
+	if err := validateSigningAlg(config.JWTSupportedAlgs, token); err != nil {
+		return nil, rejectSignature(errwrap.Wrapf("error verifying token: {{err}}", err))
+	}
+	alg, err := tokenAlgorithm(token)
+	if err != nil {
+		return nil, rejectSignature(errwrap.Wrapf("error parsing token: {{err}}", err))
+	}
+	if err := checkAlgorithmRestrictions(role, alg, nil); err != nil {
+		return nil, rejectSignature(errwrap.Wrapf("error verifying token: {{err}}", err))
+	}
+
+	issuer := fmt.Sprintf(cognitoIssuerFormat, role.CognitoRegion, role.CognitoUserPoolID)
+	keySet, err := b.roleKeySet(config, issuer+"/.well-known/jwks.json", role.jwksURLTimeout())
+	if err != nil {
+		return nil, errwrap.Wrapf("error fetching jwks keyset: {{err}}", err)
+	}
+
+	payload, err := keySet.VerifySignature(ctx, token)
+	if err != nil {
+		return nil, &rejectionError{rejection: keySetRejection(err), err: errwrap.Wrapf("error verifying token: {{err}}", err)}
+	}
+
+	claims := jwt.Claims{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal claims: %v", err)
+	}
+	allClaims := map[string]interface{}{}
+	if err := json.Unmarshal(payload, &allClaims); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal claims: %v", err)
+	}
+
+	// Cognito always sets exp, so unlike other JWTs it isn't derived from
+	// iat or nbf.
+	if claims.Expiry == nil {
+		return nil, rejectClaims(errors.New("error validating claims: no expiration time encoded in token"))
+	}
+
+	expected := jwt.Expected{
+		Issuer:  issuer,
+		Subject: role.BoundSubject,
+		Time:    time.Now(),
+	}
+	if err := claims.ValidateWithLeeway(expected, config.clockSkewLeeway(role)); err != nil {
+		return nil, rejectClaims(errwrap.Wrapf("error validating claims: {{err}}", err))
+	}
+
+	tokenUse := role.cognitoTokenUse()
+	if use, _ := allClaims["token_use"].(string); use != tokenUse {
+		return nil, rejectClaims(fmt.Errorf("error validating claims: token_use %q does not match %q", use, tokenUse))
+	}
+
+	// Cognito access tokens have no aud claim, the app client they were
+	// issued to is their client_id claim.
+	audience := []string(claims.Audience)
+	if tokenUse == cognitoTokenUseAccess {
+		audience = nil
+		if clientID, ok := allClaims["client_id"].(string); ok {
+			audience = []string{clientID}
+		}
+	}
+
+	boundAudiences := config.boundAudiences(role)
+	if err := validateAudience(role.BoundAudiencesType, boundAudiences, audience, true); err != nil {
+		return nil, rejectClaims(errwrap.Wrapf("error validating claims: {{err}}", err))
+	}
+	if role.AudienceStrict {
+		if err := validateAudienceStrict(role.BoundAudiencesType, boundAudiences, audience); err != nil {
+			return nil, rejectClaims(errwrap.Wrapf("error validating claims: {{err}}", err))
+		}
+	}
+
+	return allClaims, nil
+}
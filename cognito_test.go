@@ -0,0 +1,185 @@
+package jwtauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestLogin_Cognito(t *testing.T) {
+	pool := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/us-east-1/us-east-1_pool/.well-known/jwks.json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(getTestJWKS(t, ecdsaPubKey))
+	}))
+	defer pool.Close()
+
+	defer func(format string) { cognitoIssuerFormat = format }(cognitoIssuerFormat)
+	cognitoIssuerFormat = pool.URL + "/%s/%s"
+	issuer := pool.URL + "/us-east-1/us-east-1_pool"
+
+	b, storage := setupBackend(t, testConfig{
+		roleData: map[string]interface{}{
+			"bound_subject":             "",
+			"bound_audiences":           "app-client",
+			"groups_claim":              "",
+			"user_claim":                "cognito:username",
+			"oidc_cognito_mode":         true,
+			"oidc_cognito_region":       "us-east-1",
+			"oidc_cognito_user_pool_id": "us-east-1_pool",
+		},
+	})
+
+	login := func(cl jwt.Claims, privateCl map[string]interface{}) *logical.Response {
+		t.Helper()
+		token, _ := getTestJWT(t, ecdsaPrivKey, cl, privateCl)
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       map[string]interface{}{"role": "plugin-test", "jwt": token},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	idClaims := jwt.Claims{
+		Issuer:   issuer,
+		Subject:  "0b9f1a2c",
+		Audience: jwt.Audience{"app-client"},
+		IssuedAt: jwt.NewNumericDate(time.Now()),
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+	privateCl := map[string]interface{}{
+		"token_use":        "id",
+		"cognito:username": "alice",
+		"cognito:groups":   []string{"admins", "devs"},
+	}
+
+	resp := login(idClaims, privateCl)
+	if resp == nil || resp.IsError() || resp.Auth == nil {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+	if resp.Auth.Alias.Name != "alice" {
+		t.Fatalf("unexpected alias: %q", resp.Auth.Alias.Name)
+	}
+	var groups []string
+	for _, alias := range resp.Auth.GroupAliases {
+		groups = append(groups, alias.Name)
+	}
+	if diff := deep.Equal(groups, []string{"admins", "devs"}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	// Access tokens are rejected while the role expects id tokens.
+	accessClaims := idClaims
+	accessClaims.Audience = nil
+	accessPrivateCl := map[string]interface{}{
+		"token_use":        "access",
+		"client_id":        "app-client",
+		"cognito:username": "alice",
+		"cognito:groups":   []string{"admins"},
+	}
+	resp = login(accessClaims, accessPrivateCl)
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "token_use") {
+		t.Fatalf("expected token_use error, got: %v", resp)
+	}
+
+	// Tokens of another pool are rejected.
+	otherPool := idClaims
+	otherPool.Issuer = pool.URL + "/us-east-1/us-east-1_other"
+	resp = login(otherPool, privateCl)
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "issuer") {
+		t.Fatalf("expected issuer error, got: %v", resp)
+	}
+
+	// Access tokens are bound by their client_id.
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/plugin-test",
+		Storage:   storage,
+		Data:      map[string]interface{}{"role_type": "jwt", "oidc_cognito_token_use": "access"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%v", err, resp)
+	}
+
+	resp = login(accessClaims, accessPrivateCl)
+	if resp == nil || resp.IsError() || resp.Auth == nil {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+
+	accessPrivateCl["client_id"] = "other-client"
+	resp = login(accessClaims, accessPrivateCl)
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "aud claim does not match") {
+		t.Fatalf("expected audience error, got: %v", resp)
+	}
+}
+
+func TestRole_CognitoValidation(t *testing.T) {
+	b, storage := getBackend(t)
+
+	tests := map[string]struct {
+		data   map[string]interface{}
+		errMsg string
+	}{
+		"missing pool": {
+			data:   map[string]interface{}{"oidc_cognito_region": "us-east-1"},
+			errMsg: "'oidc_cognito_region' and 'oidc_cognito_user_pool_id' must be set",
+		},
+		"oidc role": {
+			data: map[string]interface{}{
+				"role_type":             "oidc",
+				"allowed_redirect_uris": "http://127.0.0.1",
+			},
+			errMsg: "'oidc_cognito_mode' requires 'role_type' to be 'jwt'",
+		},
+		"invalid token use": {
+			data: map[string]interface{}{
+				"oidc_cognito_region":       "us-east-1",
+				"oidc_cognito_user_pool_id": "us-east-1_pool",
+				"oidc_cognito_token_use":    "refresh",
+			},
+			errMsg: "invalid 'oidc_cognito_token_use'",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			data := map[string]interface{}{
+				"role_type":         "jwt",
+				"user_claim":        "sub",
+				"bound_audiences":   "app-client",
+				"oidc_cognito_mode": true,
+			}
+			for k, v := range tt.data {
+				data[k] = v
+			}
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      "role/test",
+				Storage:   storage,
+				Data:      data,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), tt.errMsg) {
+				t.Fatalf("expected error %q, got: %v", tt.errMsg, resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// diagRoleName is the role created by Diagnose.
+const diagRoleName = "diag"
+
+// DiagStep is a single step of a validation trace produced by Diagnose.
+type DiagStep struct {
+	Name   string
+	OK     bool
+	Detail string
+}
+
+// Diagnose validates token against the given config and role, using the same
+// code paths as a login, and returns a trace of each step. configData and
+// roleData follow the schemas of the config and role endpoints. If roleData
+// is empty, a "jwt" role with "sub" as the user claim is used. The trace stops
+// at the first failing step.
+func Diagnose(ctx context.Context, configData, roleData map[string]interface{}, token string) []DiagStep {
+	var steps []DiagStep
+	add := func(name string, err error, detail string) bool {
+		step := DiagStep{Name: name, OK: err == nil, Detail: detail}
+		if err != nil {
+			step.Detail = err.Error()
+		}
+		steps = append(steps, step)
+		return err == nil
+	}
+
+	detail, err := describeToken(token, time.Now())
+	if !add("decode token", err, detail) {
+		return steps
+	}
+
+	if len(roleData) == 0 {
+		roleData = map[string]interface{}{
+			"role_type":  "jwt",
+			"user_claim": "sub",
+		}
+	}
+
+	b := backend()
+	storage := &logical.InmemStorage{}
+	err = b.Setup(ctx, &logical.BackendConfig{
+		Logger: log.NewNullLogger(),
+		System: &logical.StaticSystemView{
+			DefaultLeaseTTLVal: 12 * time.Hour,
+			MaxLeaseTTLVal:     24 * time.Hour,
+		},
+		StorageView: storage,
+	})
+	if !add("initialize backend", err, "") {
+		return steps
+	}
+	defer b.Cleanup(ctx)
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+			Connection: &logical.Connection{
+				RemoteAddr: "127.0.0.1",
+			},
+		})
+		if err == nil && resp != nil && resp.IsError() {
+			err = resp.Error()
+		}
+		return resp, err
+	}
+
+	_, err = handle(logical.UpdateOperation, configPath, configData)
+	if !add("load config", err, "") {
+		return steps
+	}
+
+	config, err := b.config(ctx, storage)
+	if err != nil {
+		add("verify signature", err, "")
+		return steps
+	}
+	detail, err = b.diagVerifySignature(ctx, config, token)
+	if !add("verify signature", err, detail) {
+		return steps
+	}
+
+	_, err = handle(logical.CreateOperation, rolePrefix+diagRoleName, roleData)
+	if !add("load role", err, "") {
+		return steps
+	}
+
+	resp, err := handle(logical.UpdateOperation, "login", map[string]interface{}{
+		"role": diagRoleName,
+		"jwt":  token,
+	})
+	if err != nil {
+		add("validate claims", err, "")
+		return steps
+	}
+	add("validate claims", nil, describeAuth(resp.Auth))
+
+	return steps
+}
+
+// diagVerifySignature checks only the token signature for the configured
+// key source.
+func (b *jwtAuthBackend) diagVerifySignature(ctx context.Context, config *jwtConfig, token string) (string, error) {
+	switch config.authType() {
+	case StaticKeys:
+		parsed, err := jwt.ParseSigned(token)
+		if err != nil {
+			return "", err
+		}
+		var claims map[string]interface{}
+		for i, key := range config.ParsedJWTPubKeys {
+			if err := parsed.Claims(key, &claims); err == nil {
+				return fmt.Sprintf("verified with jwt_validation_pubkeys[%d]", i), nil
+			}
+		}
+		return "", fmt.Errorf("none of the %d configured public keys verified the signature", len(config.ParsedJWTPubKeys))
+
+	case JWKS:
+		keySet, err := b.getKeySet(config)
+		if err != nil {
+			return "", err
+		}
+		if _, err := keySet.VerifySignature(ctx, token); err != nil {
+			return "", err
+		}
+		return "verified with keys from " + config.JWKSURL, nil
+
+	default:
+		if _, err := b.getProvider(config); err != nil {
+			return "", err
+		}
+		return "discovery succeeded for " + config.OIDCDiscoveryURL + "; signature is verified during claim validation", nil
+	}
+}
+
+// describeToken decodes token without verifying it and summarizes its header
+// and time claims relative to now.
+func describeToken(token string, now time.Time) (string, error) {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return "", err
+	}
+
+	var claims map[string]interface{}
+	if err := parsed.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return "", err
+	}
+
+	var parts []string
+	if len(parsed.Headers) > 0 {
+		parts = append(parts, fmt.Sprintf("alg=%s kid=%q", parsed.Headers[0].Algorithm, parsed.Headers[0].KeyID))
+	}
+	for _, c := range []string{"iss", "sub", "aud"} {
+		if v, ok := claims[c]; ok {
+			parts = append(parts, fmt.Sprintf("%s=%v", c, v))
+		}
+	}
+	for _, c := range []string{"iat", "nbf", "exp"} {
+		if v, ok := claims[c].(float64); ok {
+			t := time.Unix(int64(v), 0)
+			parts = append(parts, fmt.Sprintf("%s=%s (%s)", c, t.UTC().Format(time.RFC3339), relativeTime(t, now)))
+		}
+	}
+
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	parts = append(parts, "claims="+string(raw))
+
+	return strings.Join(parts, "\n"), nil
+}
+
+func relativeTime(t, now time.Time) string {
+	d := t.Sub(now).Round(time.Second)
+	if d < 0 {
+		return (-d).String() + " ago"
+	}
+	return "in " + d.String()
+}
+
+// describeAuth summarizes the result of a successful login.
+func describeAuth(auth *logical.Auth) string {
+	if auth == nil {
+		return ""
+	}
+
+	var groups []string
+	for _, g := range auth.GroupAliases {
+		groups = append(groups, g.Name)
+	}
+
+	var metadata []string
+	for k, v := range auth.Alias.Metadata {
+		metadata = append(metadata, k+"="+v)
+	}
+	sort.Strings(metadata)
+
+	return fmt.Sprintf("alias=%s\ngroups=%s\nmetadata=%s\npolicies=%s",
+		auth.Alias.Name, strings.Join(groups, ","), strings.Join(metadata, ","), strings.Join(auth.Policies, ","))
+}
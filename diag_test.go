@@ -0,0 +1,90 @@
+package jwtauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestDiagnose(t *testing.T) {
+	configData := map[string]interface{}{
+		"bound_issuer":           "https://team-vault.auth0.com/",
+		"jwt_validation_pubkeys": ecdsaPubKey,
+	}
+	roleData := map[string]interface{}{
+		"role_type":       "jwt",
+		"bound_audiences": "https://vault.plugin.auth.jwt.test",
+		"user_claim":      "https://vault/user",
+		"groups_claim":    "https://vault/groups",
+	}
+
+	cl := jwt.Claims{
+		Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+		Issuer:    "https://team-vault.auth0.com/",
+		NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
+		Expiry:    jwt.NewNumericDate(time.Now().Add(5 * time.Second)),
+		Audience:  jwt.Audience{"https://vault.plugin.auth.jwt.test"},
+	}
+	privateCl := struct {
+		User   string   `json:"https://vault/user"`
+		Groups []string `json:"https://vault/groups"`
+	}{
+		"jeff",
+		[]string{"foo", "bar"},
+	}
+	token, _ := getTestJWT(t, ecdsaPrivKey, cl, privateCl)
+
+	lastStep := func(steps []DiagStep) DiagStep {
+		t.Helper()
+		if len(steps) == 0 {
+			t.Fatal("expected steps")
+		}
+		return steps[len(steps)-1]
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		steps := Diagnose(context.Background(), configData, roleData, token)
+		if len(steps) != 6 {
+			t.Fatalf("expected 6 steps, got %#v", steps)
+		}
+		for _, step := range steps {
+			if !step.OK {
+				t.Fatalf("unexpected failed step: %#v", step)
+			}
+		}
+		last := lastStep(steps)
+		if !strings.Contains(last.Detail, "alias=jeff") || !strings.Contains(last.Detail, "groups=foo,bar") {
+			t.Fatalf("unexpected detail: %s", last.Detail)
+		}
+	})
+
+	t.Run("malformed token", func(t *testing.T) {
+		last := lastStep(Diagnose(context.Background(), configData, roleData, "not-a-jwt"))
+		if last.OK || last.Name != "decode token" {
+			t.Fatalf("unexpected step: %#v", last)
+		}
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		badToken, _ := getTestJWT(t, badPrivKey, cl, privateCl)
+		last := lastStep(Diagnose(context.Background(), configData, roleData, badToken))
+		if last.OK || last.Name != "verify signature" {
+			t.Fatalf("unexpected step: %#v", last)
+		}
+	})
+
+	t.Run("claim mismatch", func(t *testing.T) {
+		badRole := map[string]interface{}{
+			"role_type":       "jwt",
+			"bound_audiences": "https://other.example.com",
+			"user_claim":      "https://vault/user",
+		}
+		last := lastStep(Diagnose(context.Background(), configData, badRole, token))
+		if last.OK || last.Name != "validate claims" || !strings.Contains(last.Detail, "aud") {
+			t.Fatalf("unexpected step: %#v", last)
+		}
+	})
+}
@@ -0,0 +1,247 @@
+package jwtauth
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// defaultDiscoveryCacheDuration is the default
+	// oidc_discovery_cache_duration.
+	defaultDiscoveryCacheDuration = time.Hour
+
+	// defaultDiscoveryMaxStaleness is the default
+	// oidc_discovery_max_staleness, unless oidc_discovery_cache_duration
+	// is longer.
+	defaultDiscoveryMaxStaleness = 24 * time.Hour
+
+	// discoveryRetryInterval is the least time between two background
+	// refreshes of the discovery document, so that logins don't retry a
+	// failed refresh on every request.
+	discoveryRetryInterval = 30 * time.Second
+)
+
+// discoveryClock returns the current time for the discovery document cache.
+// Tests replace it.
+var discoveryClock = time.Now
+
+// discoveryCacheDuration returns how long the discovery document is used
+// before it is refreshed in the background, applying the default when unset.
+func (c *jwtConfig) discoveryCacheDuration() time.Duration {
+	if c.OIDCDiscoveryCacheDuration == 0 {
+		return defaultDiscoveryCacheDuration
+	}
+	return c.OIDCDiscoveryCacheDuration
+}
+
+// discoveryMaxStaleness returns how long the discovery document is used
+// while it can't be refreshed, applying the default when unset.
+func (c *jwtConfig) discoveryMaxStaleness() time.Duration {
+	if c.OIDCDiscoveryMaxStaleness == 0 {
+		if c.discoveryCacheDuration() > defaultDiscoveryMaxStaleness {
+			return c.discoveryCacheDuration()
+		}
+		return defaultDiscoveryMaxStaleness
+	}
+	return c.OIDCDiscoveryMaxStaleness
+}
+
+// getProvider returns the provider of the cached discovery document. A
+// document older than oidc_discovery_cache_duration keeps being used while it
+// is refreshed in the background, and until it is older than
+// oidc_discovery_max_staleness if the refresh fails.
+func (b *jwtAuthBackend) getProvider(config *jwtConfig) (*oidc.Provider, error) {
+	now := discoveryClock()
+
+	b.l.RLock()
+	provider, fetched := b.provider, b.providerFetched
+	backgroundRefresh := provider != nil && !b.providerRefreshing &&
+		now.Sub(fetched) > config.discoveryCacheDuration() &&
+		now.Sub(b.providerAttempted) >= discoveryRetryInterval
+	b.l.RUnlock()
+
+	switch {
+	case provider == nil:
+		return b.refreshProvider(config, fetched)
+
+	case now.Sub(fetched) > config.discoveryMaxStaleness():
+		provider, err := b.refreshProvider(config, fetched)
+		if err != nil {
+			return nil, errwrap.Wrapf("cached discovery document is older than oidc_discovery_max_staleness and could not be refreshed: {{err}}", err)
+		}
+		return provider, nil
+
+	case backgroundRefresh:
+		b.l.Lock()
+		start := !b.providerRefreshing
+		b.providerRefreshing = true
+		b.l.Unlock()
+
+		if start {
+			go func() {
+				if _, err := b.refreshProvider(config, fetched); err != nil {
+					b.Logger().Warn("error refreshing the oidc discovery document, using the cached document", "url", config.OIDCDiscoveryURL, "error", err)
+				}
+				b.l.Lock()
+				b.providerRefreshing = false
+				b.l.Unlock()
+			}()
+		}
+	}
+
+	return provider, nil
+}
+
+// refreshProvider fetches the discovery document and caches its provider,
+// unless the cached document was fetched after since, in which case it is
+// returned instead. Concurrent refreshes share a single fetch.
+//
+// oidc.NewProvider rejects documents whose issuer isn't the discovery URL.
+// If the jwks_uri of the document changed, the cached key sets of the old
+// URL are dropped.
+func (b *jwtAuthBackend) refreshProvider(config *jwtConfig, since time.Time) (*oidc.Provider, error) {
+	b.l.RLock()
+	generation := b.providerGeneration
+	b.l.RUnlock()
+
+	v, err, _ := b.providerFetches.Do(strconv.FormatUint(generation, 10), func() (interface{}, error) {
+		b.l.RLock()
+		cached, fetched := b.provider, b.providerFetched
+		b.l.RUnlock()
+		if cached != nil && fetched.After(since) {
+			return cached, nil
+		}
+
+		provider, err := b.createProvider(config)
+		b.providerBreaker.record(config, err != nil)
+
+		now := discoveryClock()
+		b.l.Lock()
+		if b.providerGeneration != generation {
+			// The config changed during the fetch.
+			b.l.Unlock()
+			return provider, err
+		}
+		b.providerAttempted = now
+		if err != nil {
+			b.l.Unlock()
+			return nil, err
+		}
+		old := b.provider
+		b.provider = provider
+		b.providerFetched = now
+		b.l.Unlock()
+
+		if old != nil {
+			if oldURI, newURI := providerJWKSURI(old), providerJWKSURI(provider); oldURI != newURI {
+				b.Logger().Info("jwks_uri of the oidc discovery document changed, dropping cached key sets", "old", oldURI, "new", newURI)
+				b.flushJWKSCacheURL(oldURI)
+			}
+		}
+		return provider, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*oidc.Provider), nil
+}
+
+// providerJWKSURI returns the jwks_uri of the provider's discovery document.
+func providerJWKSURI(provider *oidc.Provider) string {
+	var claims struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := provider.Claims(&claims); err != nil {
+		return ""
+	}
+	return claims.JWKSURI
+}
+
+// flushJWKSCacheURL drops the cached key sets of jwksURL of every role.
+func (b *jwtAuthBackend) flushJWKSCacheURL(jwksURL string) {
+	if jwksURL == "" {
+		return
+	}
+
+	b.jwksCache.Range(func(k, _ interface{}) bool {
+		if strings.HasSuffix(k.(string), " "+jwksURL) {
+			b.jwksCache.Delete(k)
+		}
+		return true
+	})
+
+	b.l.Lock()
+	for k := range b.roleKeySets {
+		if strings.HasSuffix(k, " "+jwksURL) {
+			delete(b.roleKeySets, k)
+		}
+	}
+	b.l.Unlock()
+}
+
+func pathConfigOIDCDiscovery(b *jwtAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: `config/oidc-discovery/refresh`,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathOIDCDiscoveryRefresh,
+				Summary:  "Refresh the cached OIDC discovery document.",
+			},
+		},
+
+		HelpSynopsis:    oidcDiscoveryRefreshHelpSyn,
+		HelpDescription: oidcDiscoveryRefreshHelpDesc,
+	}
+}
+
+func (b *jwtAuthBackend) pathOIDCDiscoveryRefresh(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("could not load configuration"), nil
+	}
+	if config.OIDCDiscoveryURL == "" {
+		return logical.ErrorResponse("'oidc_discovery_url' is not configured"), nil
+	}
+
+	provider, err := b.refreshProvider(config, discoveryClock())
+	if err != nil {
+		return logical.ErrorResponse("error refreshing the oidc discovery document: %s", err), nil
+	}
+
+	var claims struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := provider.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"issuer":   claims.Issuer,
+			"jwks_uri": claims.JWKSURI,
+		},
+	}, nil
+}
+
+const (
+	oidcDiscoveryRefreshHelpSyn = `
+Refreshes the cached OIDC discovery document.
+`
+	oidcDiscoveryRefreshHelpDesc = `
+The OIDC discovery document is cached for oidc_discovery_cache_duration, and
+then refreshed in the background. This endpoint fetches it immediately, for
+instance after the provider rotated its jwks_uri. If the jwks_uri changed, the
+cached key sets of the old URL are dropped.
+`
+)
@@ -0,0 +1,242 @@
+package jwtauth
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// discoveryTestClock replaces discoveryClock with a clock that only moves
+// when the returned function is called.
+func discoveryTestClock() func(time.Duration) {
+	now := time.Now()
+	var clockLock sync.Mutex
+	discoveryClock = func() time.Time {
+		clockLock.Lock()
+		defer clockLock.Unlock()
+		return now
+	}
+	return func(d time.Duration) {
+		clockLock.Lock()
+		defer clockLock.Unlock()
+		now = now.Add(d)
+	}
+}
+
+// waitForDiscoveryFetches waits until the discovery document of s has been
+// fetched n times, and no background refresh of b is running.
+func waitForDiscoveryFetches(t *testing.T, b *jwtAuthBackend, s *oidcProvider, n int32) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.l.RLock()
+		refreshing := b.providerRefreshing
+		b.l.RUnlock()
+		if !refreshing && atomic.LoadInt32(&s.discoveryFetches) >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d discovery fetches, got %d", n, atomic.LoadInt32(&s.discoveryFetches))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOIDC_DiscoveryCache(t *testing.T) {
+	defer func(clock func() time.Time) { discoveryClock = clock }(discoveryClock)
+	advance := discoveryTestClock()
+
+	lb, storage, s := getBackendAndServerWithConfig(t, false, map[string]interface{}{
+		"oidc_discovery_cache_duration": "10m",
+		"oidc_discovery_max_staleness":  "30m",
+	})
+	defer s.server.Close()
+	b := lb.(*jwtAuthBackend)
+
+	config, err := b.config(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getProvider := func() error {
+		t.Helper()
+		_, err := b.getProvider(config)
+		return err
+	}
+
+	// The config write fetches the document to validate it, but doesn't
+	// cache it.
+	fetches := atomic.LoadInt32(&s.discoveryFetches)
+	for i := 0; i < 3; i++ {
+		if err := getProvider(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&s.discoveryFetches) - fetches; n != 1 {
+		t.Fatalf("expected the discovery document to be fetched once, got %d", n)
+	}
+
+	// A document older than oidc_discovery_cache_duration is refreshed in
+	// the background while it keeps being used.
+	advance(11 * time.Minute)
+	if err := getProvider(); err != nil {
+		t.Fatal(err)
+	}
+	waitForDiscoveryFetches(t, b, s, fetches+2)
+
+	// A failed refresh keeps the cached document until it is older than
+	// oidc_discovery_max_staleness.
+	atomic.StoreInt32(&s.discoveryDown, 1)
+	advance(11 * time.Minute)
+	if err := getProvider(); err != nil {
+		t.Fatal(err)
+	}
+	waitForDiscoveryFetches(t, b, s, fetches+3)
+	advance(15 * time.Minute)
+	if err := getProvider(); err != nil {
+		t.Fatal(err)
+	}
+	waitForDiscoveryFetches(t, b, s, fetches+4)
+
+	advance(5 * time.Minute)
+	if err := getProvider(); err == nil || !strings.Contains(err.Error(), "oidc_discovery_max_staleness") {
+		t.Fatalf("expected stale discovery document error, got: %v", err)
+	}
+
+	atomic.StoreInt32(&s.discoveryDown, 0)
+	if err := getProvider(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDC_DiscoveryRefresh(t *testing.T) {
+	lb, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+	b := lb.(*jwtAuthBackend)
+
+	refresh := func() *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/oidc-discovery/refresh",
+			Storage:   storage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := refresh()
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected successful refresh, got: %v", resp)
+	}
+	if resp.Data["issuer"] != s.server.URL || resp.Data["jwks_uri"] != s.server.URL+"/certs" {
+		t.Fatalf("unexpected response: %v", resp.Data)
+	}
+
+	// Key sets of the old jwks_uri are dropped once the document advertises
+	// a new one. Key sets of other URLs are kept.
+	oldKey := jwksCacheKey("test", s.server.URL+"/certs")
+	otherKey := jwksCacheKey("test", "https://example.com/certs")
+	b.jwksCache.Store(oldKey, new(jwksCacheEntry))
+	b.jwksCache.Store(otherKey, new(jwksCacheEntry))
+
+	atomic.StoreInt32(&s.jwksRotated, 1)
+	resp = refresh()
+	if resp == nil || resp.IsError() || resp.Data["jwks_uri"] != s.server.URL+"/certs-rotated" {
+		t.Fatalf("expected the rotated jwks_uri, got: %v", resp)
+	}
+	if _, ok := b.jwksCache.Load(oldKey); ok {
+		t.Fatal("expected the key set of the old jwks_uri to be dropped")
+	}
+	if _, ok := b.jwksCache.Load(otherKey); !ok {
+		t.Fatal("expected the key set of another url to be kept")
+	}
+
+	// A failed refresh is reported, and the cached document is kept.
+	atomic.StoreInt32(&s.discoveryDown, 1)
+	if resp := refresh(); resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "503") {
+		t.Fatalf("expected refresh error, got: %v", resp)
+	}
+	config, err := b.config(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.getProvider(config); err != nil {
+		t.Fatalf("expected the cached document to be used, got: %v", err)
+	}
+}
+
+func TestOIDC_DiscoveryCacheConcurrent(t *testing.T) {
+	defer func(clock func() time.Time) { discoveryClock = clock }(discoveryClock)
+	advance := discoveryTestClock()
+
+	lb, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+	b := lb.(*jwtAuthBackend)
+
+	config, err := b.config(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, age := range []time.Duration{0, 2 * time.Hour, 25 * time.Hour} {
+		// With no cached document, or one that is due for a background
+		// refresh or too stale to be used, concurrent logins fetch the
+		// document once.
+		if age == 0 {
+			b.reset()
+		}
+		advance(age)
+		fetches := atomic.LoadInt32(&s.discoveryFetches)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 50)
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := b.getProvider(config)
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		waitForDiscoveryFetches(t, b, s, fetches+1)
+		if n := atomic.LoadInt32(&s.discoveryFetches) - fetches; n != 1 {
+			t.Fatalf("expected a single fetch for a document of age %s, got %d", age, n)
+		}
+	}
+}
+
+func TestConfig_DiscoveryMaxStalenessValidation(t *testing.T) {
+	b, storage := getBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"jwks_url":                      "https://example.com/certs",
+			"oidc_discovery_cache_duration": "2h",
+			"oidc_discovery_max_staleness":  "1h",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for max staleness below the cache duration, got: %v", resp)
+	}
+}
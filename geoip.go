@@ -0,0 +1,182 @@
+package jwtauth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+var countryCodeRegex = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// geoIPDatabase is an opened oidc_geoip_database. The file is read into
+// memory rather than mapped, so that a database that is being replaced
+// doesn't have to be closed while lookups are using it.
+type geoIPDatabase struct {
+	path    string
+	modTime time.Time
+	size    int64
+	reader  *maxminddb.Reader
+}
+
+// geoIPRecord holds the fields of country and city database records used
+// for country restrictions.
+type geoIPRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// parseCountryCodes upper-cases and validates ISO 3166-1 alpha-2 codes.
+func parseCountryCodes(codes []string) ([]string, error) {
+	var parsed []string
+	for _, code := range codes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if !countryCodeRegex.MatchString(code) {
+			return nil, fmt.Errorf("%q is not an ISO 3166-1 alpha-2 country code", code)
+		}
+		parsed = append(parsed, code)
+	}
+	return parsed, nil
+}
+
+func openGeoIPDatabase(path string) (*geoIPDatabase, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := maxminddb.FromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &geoIPDatabase{
+		path:    path,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		reader:  reader,
+	}, nil
+}
+
+// geoIPDatabase returns the opened database at path, opening it if it isn't
+// open yet.
+func (b *jwtAuthBackend) geoIPDatabase(path string) (*geoIPDatabase, error) {
+	b.geoIPLock.RLock()
+	db := b.geoIP
+	b.geoIPLock.RUnlock()
+
+	if db != nil && db.path == path {
+		return db, nil
+	}
+
+	db, err := openGeoIPDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b.geoIPLock.Lock()
+	b.geoIP = db
+	b.geoIPLock.Unlock()
+
+	return db, nil
+}
+
+// refreshGeoIPDatabase reopens the oidc_geoip_database if the file changed
+// since it was opened. If the new file can't be read, the previous database
+// stays in use.
+func (b *jwtAuthBackend) refreshGeoIPDatabase() {
+	b.geoIPLock.RLock()
+	db := b.geoIP
+	b.geoIPLock.RUnlock()
+
+	if db == nil {
+		return
+	}
+
+	info, err := os.Stat(db.path)
+	if err != nil {
+		b.Logger().Warn("error checking geoip database", "path", db.path, "error", err)
+		return
+	}
+	if info.ModTime().Equal(db.modTime) && info.Size() == db.size {
+		return
+	}
+
+	updated, err := openGeoIPDatabase(db.path)
+	if err != nil {
+		b.Logger().Warn("error reloading geoip database", "path", db.path, "error", err)
+		return
+	}
+
+	b.geoIPLock.Lock()
+	if b.geoIP == db {
+		b.geoIP = updated
+	}
+	b.geoIPLock.Unlock()
+}
+
+// geoIPCountry returns the ISO country code of addr, or "" if it isn't in
+// the database.
+func (b *jwtAuthBackend) geoIPCountry(config *jwtConfig, addr string) (string, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return "", fmt.Errorf("invalid client address %q", addr)
+	}
+
+	db, err := b.geoIPDatabase(config.OIDCGeoIPDatabase)
+	if err != nil {
+		return "", err
+	}
+
+	var record geoIPRecord
+	if err := db.reader.Lookup(ip, &record); err != nil {
+		return "", err
+	}
+
+	return record.Country.ISOCode, nil
+}
+
+// checkCountry denies the login if the client address is located in one of
+// the role's oidc_denied_countries, or outside its oidc_allowed_countries.
+// Addresses that aren't in the database have no country and are only denied
+// by oidc_allowed_countries.
+func (b *jwtAuthBackend) checkCountry(config *jwtConfig, role *jwtRole, req *logical.Request) (*logical.Response, error) {
+	if len(role.AllowedCountries) == 0 && len(role.DeniedCountries) == 0 {
+		return nil, nil
+	}
+
+	if config.OIDCGeoIPDatabase == "" {
+		return logical.ErrorResponse("role restricts logins by country but 'oidc_geoip_database' is not configured"), nil
+	}
+
+	if req.Connection == nil || req.Connection.RemoteAddr == "" {
+		b.Logger().Warn("country restrictions found but no connection information available for validation")
+		return nil, logical.ErrPermissionDenied
+	}
+
+	country, err := b.geoIPCountry(config, req.Connection.RemoteAddr)
+	if err != nil {
+		return nil, errwrap.Wrapf("error looking up client address in geoip database: {{err}}", err)
+	}
+
+	if strutil.StrListContains(role.DeniedCountries, country) ||
+		(len(role.AllowedCountries) > 0 && !strutil.StrListContains(role.AllowedCountries, country)) {
+		return nil, logical.ErrPermissionDenied
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,244 @@
+package jwtauth
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// writeTestGeoIPDatabase writes an IPv4 MaxMind DB with a single node that
+// locates 0.0.0.0/1 in country low and 128.0.0.0/1 in country high. An empty
+// country leaves its half of the address space out of the database.
+func writeTestGeoIPDatabase(t *testing.T, path, low, high string) {
+	t.Helper()
+
+	mmdbString := func(s string) []byte {
+		return append([]byte{0x40 | byte(len(s))}, s...)
+	}
+
+	const nodeCount = 1
+	var data []byte
+	record := func(country string) uint32 {
+		if country == "" {
+			return nodeCount
+		}
+		offset := uint32(len(data))
+		data = append(data, 0xe1)
+		data = append(data, mmdbString("country")...)
+		data = append(data, 0xe1)
+		data = append(data, mmdbString("iso_code")...)
+		data = append(data, mmdbString(country)...)
+		return nodeCount + 16 + offset
+	}
+	left, right := record(low), record(high)
+
+	var buf []byte
+	for _, r := range []uint32{left, right} {
+		buf = append(buf, byte(r>>16), byte(r>>8), byte(r))
+	}
+	buf = append(buf, make([]byte, 16)...)
+	buf = append(buf, data...)
+
+	buf = append(buf, "\xab\xcd\xefMaxMind.com"...)
+	buf = append(buf, 0xe6)
+	buf = append(buf, mmdbString("binary_format_major_version")...)
+	buf = append(buf, 0xa1, 2)
+	buf = append(buf, mmdbString("binary_format_minor_version")...)
+	buf = append(buf, 0xa0)
+	buf = append(buf, mmdbString("database_type")...)
+	buf = append(buf, mmdbString("Test-Country")...)
+	buf = append(buf, mmdbString("ip_version")...)
+	buf = append(buf, 0xa1, 4)
+	buf = append(buf, mmdbString("node_count")...)
+	buf = append(buf, 0xc1, nodeCount)
+	buf = append(buf, mmdbString("record_size")...)
+	buf = append(buf, 0xa1, 24)
+
+	if err := ioutil.WriteFile(path, buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGeoIPCountry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "country.mmdb")
+	writeTestGeoIPDatabase(t, path, "US", "")
+
+	b, _ := getBackend(t)
+	config := &jwtConfig{OIDCGeoIPDatabase: path}
+
+	lookup := func(addr string) string {
+		t.Helper()
+		country, err := b.(*jwtAuthBackend).geoIPCountry(config, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return country
+	}
+
+	if country := lookup("10.0.0.1"); country != "US" {
+		t.Fatalf("expected US, got %q", country)
+	}
+	if country := lookup("203.0.113.5"); country != "" {
+		t.Fatalf("expected no country, got %q", country)
+	}
+
+	// An updated database is picked up by the periodic function.
+	writeTestGeoIPDatabase(t, path, "US", "FR")
+	if country := lookup("203.0.113.5"); country != "" {
+		t.Fatalf("expected no country before the refresh, got %q", country)
+	}
+	b.(*jwtAuthBackend).refreshGeoIPDatabase()
+	if country := lookup("203.0.113.5"); country != "FR" {
+		t.Fatalf("expected FR, got %q", country)
+	}
+
+	// A broken update keeps the previous database.
+	if err := ioutil.WriteFile(path, []byte("not a database"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	b.(*jwtAuthBackend).refreshGeoIPDatabase()
+	if country := lookup("203.0.113.5"); country != "FR" {
+		t.Fatalf("expected FR, got %q", country)
+	}
+
+	if _, err := b.(*jwtAuthBackend).geoIPCountry(config, "not an address"); err == nil {
+		t.Fatal("expected error for an invalid address")
+	}
+}
+
+func TestOIDC_Callback_Countries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "country.mmdb")
+	writeTestGeoIPDatabase(t, path, "US", "FR")
+
+	tests := map[string]struct {
+		roleData map[string]interface{}
+		addr     string
+		success  bool
+	}{
+		"allowed":                 {map[string]interface{}{"oidc_allowed_countries": "us,ca"}, "10.0.0.1", true},
+		"not allowed":             {map[string]interface{}{"oidc_allowed_countries": "US,CA"}, "203.0.113.5", false},
+		"denied":                  {map[string]interface{}{"oidc_denied_countries": "FR"}, "203.0.113.5", false},
+		"not denied":              {map[string]interface{}{"oidc_denied_countries": "FR"}, "10.0.0.1", true},
+		"denied takes precedence": {map[string]interface{}{"oidc_allowed_countries": "US", "oidc_denied_countries": "US"}, "10.0.0.1", false},
+		"no connection":           {map[string]interface{}{"oidc_denied_countries": "FR"}, "", false},
+		"no restrictions":         {map[string]interface{}{}, "203.0.113.5", true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			b, storage, s := getBackendAndServerWithConfig(t, false, map[string]interface{}{
+				"oidc_geoip_database": path,
+			})
+			defer s.server.Close()
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "role/test",
+				Storage:   storage,
+				Data:      tt.roleData,
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("err:%v resp:%#v", err, resp)
+			}
+
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "oidc/auth_url",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"role":         "test",
+					"redirect_uri": "https://example.com",
+				},
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("err:%v resp:%#v", err, resp)
+			}
+			authURL := resp.Data["auth_url"].(string)
+
+			s.customClaims = sampleClaims(getQueryParam(t, authURL, "nonce"))
+			s.code = "abc"
+
+			req := &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      "oidc/callback",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"state": getQueryParam(t, authURL, "state"),
+					"code":  "abc",
+				},
+			}
+			if tt.addr != "" {
+				req.Connection = &logical.Connection{RemoteAddr: tt.addr}
+			}
+
+			resp, err = b.HandleRequest(context.Background(), req)
+			if tt.success {
+				if err != nil || resp.IsError() || resp.Auth == nil {
+					t.Fatalf("expected successful login, got err:%v resp:%#v", err, resp)
+				}
+			} else if err != logical.ErrPermissionDenied {
+				t.Fatalf("expected permission denied, got err:%v resp:%#v", err, resp)
+			}
+		})
+	}
+}
+
+func TestPath_Countries_Invalid(t *testing.T) {
+	b, storage := getBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"jwt_validation_pubkeys": []string{testJWTPubKey},
+			"oidc_geoip_database":    "/nonexistent/country.mmdb",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() || !strings.Contains(resp.Error().Error(), "error opening 'oidc_geoip_database'") {
+		t.Fatalf("expected geoip database error, got: %v", resp)
+	}
+
+	for field, value := range map[string]string{
+		"oidc_allowed_countries": "US,USA",
+		"oidc_denied_countries":  "1A",
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/test",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role_type":       "jwt",
+				"user_claim":      "sub",
+				"bound_audiences": "vault",
+				field:             value,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.IsError() || !strings.Contains(resp.Error().Error(), "not an ISO 3166-1 alpha-2 country code") {
+			t.Fatalf("%s: expected country code error, got: %v", field, resp)
+		}
+	}
+}
@@ -5,6 +5,7 @@ go 1.12
 require (
 	github.com/coreos/go-oidc v2.0.0+incompatible
 	github.com/go-test/deep v1.0.2-0.20181118220953-042da051cf31
+	github.com/google/cel-go v0.2.0
 	github.com/hashicorp/errwrap v1.0.0
 	github.com/hashicorp/go-cleanhttp v0.5.1
 	github.com/hashicorp/go-hclog v0.8.0
@@ -13,9 +14,14 @@ require (
 	github.com/hashicorp/vault/api v1.0.5-0.20190814205728-e9c5cd8aca98
 	github.com/hashicorp/vault/sdk v0.1.14-0.20190814205504-1cad00d1133b
 	github.com/mitchellh/pointerstructure v0.0.0-20190430161007-f252a8fd71c8
+	github.com/oschwald/maxminddb-golang v1.5.0
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/pquerna/cachecontrol v0.0.0-20180517163645-1555304b9b35 // indirect
 	github.com/ryanuber/go-glob v1.0.0
+	github.com/xeipuuv/gojsonschema v1.2.0
+	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
 	golang.org/x/oauth2 v0.0.0-20190402181905-9f3314589c9a
+	golang.org/x/sync v0.0.0-20190423024810-112230192c58
+	google.golang.org/genproto v0.0.0-20190404172233-64821d5d2107
 	gopkg.in/square/go-jose.v2 v2.3.1
 )
@@ -0,0 +1,173 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-cleanhttp"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// defaultIntrospectionCacheTTL is used when introspection_cache_ttl is
+	// not set on the role.
+	defaultIntrospectionCacheTTL = 30 * time.Second
+
+	// introspectionTimeout bounds a single introspection request.
+	introspectionTimeout = 5 * time.Second
+
+	// introspectionAudParamResource and introspectionAudParamAudience are
+	// the parameters introspection_audience can be sent in.
+	introspectionAudParamResource = "resource"
+	introspectionAudParamAudience = "audience"
+)
+
+// errTokenInactive is returned when the introspection endpoint reports a
+// token as not active.
+var errTokenInactive = errors.New("token is not active")
+
+// introspectionCacheTTL returns how long introspection responses are cached.
+func (r *jwtRole) introspectionCacheTTL() time.Duration {
+	if r.IntrospectionCacheTTL <= 0 {
+		return defaultIntrospectionCacheTTL
+	}
+	return r.IntrospectionCacheTTL
+}
+
+// introspectToken validates an opaque token with the role's RFC 7662
+// introspection endpoint and returns the claims of the introspection
+// response. Responses are cached for the role's introspection_cache_ttl, so
+// that a burst of logins with the same token makes a single request.
+func (b *jwtAuthBackend) introspectToken(ctx context.Context, config *jwtConfig, role *jwtRole, token string) (map[string]interface{}, error) {
+	// The response depends on the audience the token is introspected for.
+	cacheKey := negativeCacheKey(role.IntrospectionEndpoint+"|"+role.IntrospectionAudience, token)
+
+	var response map[string]interface{}
+	if cached, ok := b.introspectionCache.Get(cacheKey); ok {
+		response = cached.(map[string]interface{})
+	} else {
+		var err error
+		response, err = b.queryIntrospection(ctx, config, role, token)
+		if err != nil {
+			return nil, errwrap.Wrapf("error introspecting token: {{err}}", err)
+		}
+		b.introspectionCache.Set(cacheKey, response, role.introspectionCacheTTL())
+	}
+
+	if active, _ := response["active"].(bool); !active {
+		return nil, errTokenInactive
+	}
+
+	// Claims of the introspection response take precedence over those
+	// nested in extra_claims.
+	allClaims := make(map[string]interface{}, len(response))
+	if extra, ok := response["extra_claims"].(map[string]interface{}); ok {
+		for k, v := range extra {
+			allClaims[k] = v
+		}
+	}
+	for k, v := range response {
+		if k != "extra_claims" {
+			allClaims[k] = v
+		}
+	}
+
+	// A cached response may outlive the token, so the expiry is checked
+	// every time.
+	if exp, ok := allClaims["exp"].(float64); ok {
+		expiry := time.Unix(int64(exp), 0)
+		if time.Now().After(expiry.Add(config.clockSkewLeeway(role))) {
+			return nil, rejectClaims(errors.New("token is expired"))
+		}
+	}
+
+	if role.BoundSubject != "" {
+		if sub, _ := allClaims["sub"].(string); sub != role.BoundSubject {
+			return nil, rejectClaims(errors.New("sub does not match the role's bound_subject"))
+		}
+	}
+
+	if err := validateAudience(role.BoundAudiencesType, config.boundAudiences(role), introspectionAudience(allClaims["aud"]), true); err != nil {
+		return nil, rejectClaims(err)
+	}
+
+	return allClaims, nil
+}
+
+// introspectionAudience returns the "aud" of an introspection response,
+// which can be a string or a list of strings.
+func introspectionAudience(aud interface{}) []string {
+	switch v := aud.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var audiences []string
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+		return audiences
+	}
+
+	return nil
+}
+
+// queryIntrospection posts token to the role's introspection endpoint,
+// authenticating with the role's client credentials.
+func (b *jwtAuthBackend) queryIntrospection(ctx context.Context, config *jwtConfig, role *jwtRole, token string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, introspectionTimeout)
+	defer cancel()
+
+	caCtx, err := b.createCAContext(ctx, config, config.OIDCDiscoveryCAPEM)
+	if err != nil {
+		return nil, err
+	}
+	client := cleanhttp.DefaultClient()
+	if c, ok := caCtx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		client = c
+	}
+
+	form := url.Values{
+		"token":           {token},
+		"token_type_hint": {"access_token"},
+	}
+	if role.IntrospectionAudience != "" {
+		param := role.IntrospectionAudParam
+		if param == "" {
+			param = introspectionAudParamResource
+		}
+		form.Set(param, role.IntrospectionAudience)
+	}
+	req, err := http.NewRequest(http.MethodPost, role.IntrospectionEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(role.IntrospectionClientID), url.QueryEscape(role.IntrospectionClientSecret))
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var response map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
@@ -0,0 +1,243 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestLogin_Introspection(t *testing.T) {
+	var requests int
+	var response map[string]interface{}
+	var form map[string][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if id, secret, ok := r.BasicAuth(); !ok || id != "vault" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		form = r.PostForm
+		if r.FormValue("token") != "opaque-token" {
+			w.Write([]byte(`{"active": false}`))
+			return
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer srv.Close()
+
+	activeResponse := func() map[string]interface{} {
+		return map[string]interface{}{
+			"active":               true,
+			"sub":                  "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+			"exp":                  time.Now().Add(time.Hour).Unix(),
+			"scope":                "read write",
+			"https://vault/user":   "jeff",
+			"https://vault/groups": []string{"foo"},
+			"extra_claims": map[string]interface{}{
+				"first_name": "Jeff",
+				"color":      "green",
+			},
+		}
+	}
+
+	login := func(t *testing.T, roleData map[string]interface{}, token string, times int) (*logical.Response, error) {
+		t.Helper()
+		data := map[string]interface{}{
+			"role_type":                   "introspection",
+			"introspection_endpoint":      srv.URL,
+			"introspection_client_id":     "vault",
+			"introspection_client_secret": "s3cret",
+		}
+		for k, v := range roleData {
+			data[k] = v
+		}
+		b, storage := setupBackend(t, testConfig{roleData: data})
+
+		var resp *logical.Response
+		var err error
+		for i := 0; i < times; i++ {
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "login",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"role": "plugin-test",
+					"jwt":  token,
+				},
+			})
+		}
+		return resp, err
+	}
+
+	t.Run("active", func(t *testing.T) {
+		requests, response = 0, activeResponse()
+
+		resp, err := login(t, nil, "opaque-token", 2)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		if resp.Auth.Alias.Name != "jeff" {
+			t.Fatalf("unexpected alias name: %q", resp.Auth.Alias.Name)
+		}
+		if resp.Auth.Alias.Metadata["name"] != "Jeff" {
+			t.Fatalf("expected claim mapping from extra_claims, got %v", resp.Auth.Alias.Metadata)
+		}
+		if requests != 1 {
+			t.Fatalf("expected the introspection response to be cached, got %d requests", requests)
+		}
+	})
+
+	t.Run("inactive", func(t *testing.T) {
+		requests, response = 0, activeResponse()
+
+		_, err := login(t, nil, "revoked-token", 1)
+		if err != logical.ErrPermissionDenied {
+			t.Fatalf("expected permission denied, got %v", err)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		requests, response = 0, activeResponse()
+		response["exp"] = time.Now().Add(-time.Hour).Unix()
+
+		resp, err := login(t, nil, "opaque-token", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.IsError() || !strings.Contains(resp.Error().Error(), "token is expired") {
+			t.Fatalf("expected expiry error, got %v", resp)
+		}
+	})
+
+	t.Run("bound claims", func(t *testing.T) {
+		for _, tt := range []struct {
+			color   string
+			success bool
+		}{
+			{"green", true},
+			{"blue", false},
+		} {
+			requests, response = 0, activeResponse()
+
+			resp, err := login(t, map[string]interface{}{
+				"bound_claims": map[string]interface{}{"color": tt.color},
+			}, "opaque-token", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.success && resp.IsError() {
+				t.Fatalf("%s: unexpected error: %v", tt.color, resp.Error())
+			}
+			if !tt.success && (!resp.IsError() || !strings.Contains(resp.Error().Error(), "claim \"color\" does not match")) {
+				t.Fatalf("%s: expected bound claims error, got %v", tt.color, resp)
+			}
+		}
+	})
+
+	t.Run("audience", func(t *testing.T) {
+		for _, tt := range []struct {
+			roleData map[string]interface{}
+			param    string
+		}{
+			{map[string]interface{}{}, ""},
+			{map[string]interface{}{"introspection_audience": "https://api.example.com"}, "resource"},
+			{map[string]interface{}{"introspection_audience": "https://api.example.com", "introspection_audience_param": "audience"}, "audience"},
+		} {
+			requests, response = 0, activeResponse()
+
+			resp, err := login(t, tt.roleData, "opaque-token", 1)
+			if err != nil || resp == nil || resp.IsError() {
+				t.Fatalf("err:%v resp:%#v", err, resp)
+			}
+			_, hasResource := form["resource"]
+			_, hasAudience := form["audience"]
+			switch tt.param {
+			case "":
+				if hasResource || hasAudience {
+					t.Fatalf("unexpected audience parameter: %v", form)
+				}
+			case "resource":
+				if hasAudience || form["resource"][0] != "https://api.example.com" {
+					t.Fatalf("expected resource parameter, got: %v", form)
+				}
+			case "audience":
+				if hasResource || form["audience"][0] != "https://api.example.com" {
+					t.Fatalf("expected audience parameter, got: %v", form)
+				}
+			}
+		}
+
+		// responses for different audiences are cached separately
+		requests, response = 0, activeResponse()
+		b, storage := setupBackend(t, testConfig{})
+		jb := b.Backend.(*jwtAuthBackend)
+		config, err := jb.config(context.Background(), storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, aud := range []string{"a", "b", "a"} {
+			role := &jwtRole{
+				IntrospectionEndpoint:     srv.URL,
+				IntrospectionClientID:     "vault",
+				IntrospectionClientSecret: "s3cret",
+				IntrospectionAudience:     aud,
+			}
+			if _, err := jb.introspectToken(context.Background(), config, role, "opaque-token"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if requests != 2 {
+			t.Fatalf("expected a request per audience, got %d", requests)
+		}
+	})
+
+	t.Run("role validation", func(t *testing.T) {
+		b, storage := getBackend(t)
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/test",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role_type":              "introspection",
+				"bound_subject":          "alice",
+				"user_claim":             "sub",
+				"introspection_endpoint": srv.URL,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.IsError() || !strings.Contains(resp.Error().Error(), "introspection_client_secret") {
+			t.Fatalf("expected missing credentials error, got %v", resp)
+		}
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/test",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role_type":                    "introspection",
+				"bound_subject":                "alice",
+				"user_claim":                   "sub",
+				"introspection_endpoint":       srv.URL,
+				"introspection_client_id":      "vault",
+				"introspection_client_secret":  "s3cret",
+				"introspection_audience":       "https://api.example.com",
+				"introspection_audience_param": "aud",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.IsError() || !strings.Contains(resp.Error().Error(), "invalid 'introspection_audience_param'") {
+			t.Fatalf("expected invalid introspection_audience_param error, got %v", resp)
+		}
+	})
+}
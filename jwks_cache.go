@@ -0,0 +1,358 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const (
+	// defaultJWKSCacheDuration is used when jwks_cache_duration is not set
+	// on the role.
+	defaultJWKSCacheDuration = 15 * time.Minute
+
+	// defaultJWKSCacheMaxStaleness is used when jwks_cache_max_staleness is
+	// not set on the role.
+	defaultJWKSCacheMaxStaleness = time.Hour
+
+	// jwksCacheRetryInterval is the least time between two fetches of the
+	// same key set, other than the ones forced by config/jwks-cache/invalidate.
+	// It bounds the retries of failed background refreshes, and the fetches
+	// caused by tokens signed with unknown keys.
+	jwksCacheRetryInterval = 30 * time.Second
+)
+
+// jwksClock returns the current time for the JWKS cache. Tests replace it.
+var jwksClock = time.Now
+
+// jwksCacheEntry is the cached key set of a JWKS URL for a role.
+type jwksCacheEntry struct {
+	l sync.RWMutex
+
+	// keys is the key set of the last successful fetch, fetched at fetched
+	keys    *jose.JSONWebKeySet
+	fetched time.Time
+
+	// attempted is the time of the last fetch, successful or not
+	attempted time.Time
+
+	// refreshing is set while a background refresh is running
+	refreshing bool
+}
+
+// jwksCacheDuration returns how long the role's key sets are used before
+// they are refreshed in the background.
+func (r *jwtRole) jwksCacheDuration() time.Duration {
+	if r.JWKSCacheDuration <= 0 {
+		return defaultJWKSCacheDuration
+	}
+	return r.JWKSCacheDuration
+}
+
+// jwksCacheMaxStaleness returns how long the role's key sets are used while
+// they can't be refreshed.
+func (r *jwtRole) jwksCacheMaxStaleness() time.Duration {
+	if r.JWKSCacheMaxStaleness <= 0 {
+		return defaultJWKSCacheMaxStaleness
+	}
+	return r.JWKSCacheMaxStaleness
+}
+
+// roleJWKSURLs returns the JWKS URLs that tokens of the role are verified
+// with: the role's jwks_urls, or else the jwks_url of the config.
+func roleJWKSURLs(config *jwtConfig, role *jwtRole) []string {
+	if len(role.JWKSURLs) > 0 {
+		return role.JWKSURLs
+	}
+	if config.JWKSURL != "" {
+		return []string{config.JWKSURL}
+	}
+	return nil
+}
+
+// jwksCacheKey returns the key of a role's cached key set of jwksURL. Role
+// names can't contain spaces.
+func jwksCacheKey(roleName, jwksURL string) string {
+	return roleName + " " + jwksURL
+}
+
+// verifyWithCachedJWKS verifies the signature of token with the role's cached
+// key set of jwksURL, and returns the payload. A key set older than
+// jwks_cache_duration keeps being used while it is refreshed in the
+// background, and until it is older than jwks_cache_max_staleness if the
+// refresh fails.
+func (b *jwtAuthBackend) verifyWithCachedJWKS(config *jwtConfig, roleName string, role *jwtRole, jwksURL, token string) ([]byte, error) {
+	jws, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, rejectSignature(errwrap.Wrapf("error parsing token: {{err}}", err))
+	}
+	if len(jws.Signatures) == 0 {
+		return nil, rejectSignature(errors.New("token has no signatures"))
+	}
+	kid := jws.Signatures[0].Header.KeyID
+
+	cacheKey := jwksCacheKey(roleName, jwksURL)
+	v, _ := b.jwksCache.LoadOrStore(cacheKey, new(jwksCacheEntry))
+	entry := v.(*jwksCacheEntry)
+
+	keys, err := b.cachedKeySet(config, role, cacheKey, entry, jwksURL)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, found, err := verifyWithKeySet(role, jws, kid, keys)
+	if found {
+		if err != nil {
+			return nil, rejectSignature(err)
+		}
+		return payload, nil
+	}
+
+	// The signing key may have been added since the key set was fetched.
+	entry.l.RLock()
+	refetch := jwksClock().Sub(entry.attempted) >= jwksCacheRetryInterval
+	entry.l.RUnlock()
+	if !refetch {
+		return nil, err
+	}
+	keys, fetchErr := b.refreshKeySet(config, role, cacheKey, entry, jwksURL)
+	if fetchErr != nil {
+		return nil, err
+	}
+
+	payload, found, err = verifyWithKeySet(role, jws, kid, keys)
+	if found && err != nil {
+		return nil, rejectSignature(err)
+	}
+	return payload, err
+}
+
+// cachedKeySet returns the key set of entry, fetching it first if there is
+// none or it is older than jwks_cache_max_staleness, and starting a
+// background refresh if it is older than jwks_cache_duration.
+func (b *jwtAuthBackend) cachedKeySet(config *jwtConfig, role *jwtRole, cacheKey string, entry *jwksCacheEntry, jwksURL string) (*jose.JSONWebKeySet, error) {
+	now := jwksClock()
+
+	entry.l.Lock()
+	keys, age := entry.keys, now.Sub(entry.fetched)
+	refresh := keys != nil && age > role.jwksCacheDuration() && age <= role.jwksCacheMaxStaleness() &&
+		!entry.refreshing && now.Sub(entry.attempted) >= jwksCacheRetryInterval
+	if refresh {
+		entry.refreshing = true
+	}
+	entry.l.Unlock()
+
+	if refresh {
+		go func() {
+			if _, err := b.refreshKeySet(config, role, cacheKey, entry, jwksURL); err != nil {
+				b.Logger().Warn("error refreshing jwks, using the cached key set", "url", jwksURL, "error", err)
+			}
+
+			entry.l.Lock()
+			entry.refreshing = false
+			entry.l.Unlock()
+		}()
+	}
+
+	switch {
+	case keys == nil:
+		return b.refreshKeySet(config, role, cacheKey, entry, jwksURL)
+
+	case age > role.jwksCacheMaxStaleness():
+		keys, err := b.refreshKeySet(config, role, cacheKey, entry, jwksURL)
+		if err != nil {
+			return nil, errwrap.Wrapf("cached jwks is older than jwks_cache_max_staleness and could not be refreshed: {{err}}", err)
+		}
+		return keys, nil
+	}
+
+	return keys, nil
+}
+
+// refreshKeySet fetches jwksURL into entry. Concurrent refreshes of the same
+// entry share a single fetch.
+func (b *jwtAuthBackend) refreshKeySet(config *jwtConfig, role *jwtRole, cacheKey string, entry *jwksCacheEntry, jwksURL string) (*jose.JSONWebKeySet, error) {
+	keys, err, _ := b.jwksFetches.Do(cacheKey, func() (interface{}, error) {
+		ctx, err := b.jwksContext(config, role.jwksURLTimeout())
+		if err != nil {
+			return nil, err
+		}
+
+		keys, err := fetchJWKS(ctx, jwksURL)
+		now := jwksClock()
+
+		entry.l.Lock()
+		defer entry.l.Unlock()
+		entry.attempted = now
+		if err != nil {
+			return nil, err
+		}
+		entry.keys = keys
+		entry.fetched = now
+
+		return keys, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys.(*jose.JSONWebKeySet), nil
+}
+
+// fetchJWKS fetches the key set at jwksURL, which must hold at least one key.
+func fetchJWKS(ctx context.Context, jwksURL string) (*jose.JSONWebKeySet, error) {
+	client := cleanhttp.DefaultClient()
+	if c, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		client = c
+	}
+
+	httpReq, err := http.NewRequest(http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %q returned %s", jwksURL, resp.Status)
+	}
+
+	var keySet jose.JSONWebKeySet
+	if err := json.Unmarshal(body, &keySet); err != nil {
+		return nil, fmt.Errorf("error parsing key set from %q: %v", jwksURL, err)
+	}
+	if len(keySet.Keys) == 0 {
+		return nil, fmt.Errorf("key set at %q has no keys", jwksURL)
+	}
+
+	return &keySet, nil
+}
+
+// verifyWithKeySet verifies jws with the keys of keys matching kid, or all of
+// them if the token has no kid. found is false if no key matches, or if the
+// role's algorithm_restrictions reject the token with these keys.
+func verifyWithKeySet(role *jwtRole, jws *jose.JSONWebSignature, kid string, keys *jose.JSONWebKeySet) (payload []byte, found bool, err error) {
+	if err := checkAlgorithmRestrictions(role, jws.Signatures[0].Header.Algorithm, jwkKeys(keys.Keys)); err != nil {
+		return nil, false, err
+	}
+
+	candidates := keys.Keys
+	if kid != "" {
+		candidates = keys.Key(kid)
+	}
+	if len(candidates) == 0 {
+		return nil, false, fmt.Errorf("no key with kid %q found in jwks", kid)
+	}
+
+	for _, key := range candidates {
+		if payload, err := jws.Verify(&key); err == nil {
+			return payload, true, nil
+		}
+	}
+	return nil, true, errors.New("failed to verify token signature")
+}
+
+// flushJWKSCache drops the cached key sets of roleName, or of every role if
+// roleName is empty.
+func (b *jwtAuthBackend) flushJWKSCache(roleName string) {
+	b.jwksCache.Range(func(k, _ interface{}) bool {
+		if roleName == "" || strings.HasPrefix(k.(string), roleName+" ") {
+			b.jwksCache.Delete(k)
+		}
+		return true
+	})
+}
+
+func pathConfigJWKSCache(b *jwtAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: `config/jwks-cache/invalidate`,
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "The role whose cached JWKS key sets are refreshed.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathJWKSCacheInvalidate,
+				Summary:  "Refresh the cached JWKS key sets of a role.",
+			},
+		},
+
+		HelpSynopsis:    jwksCacheInvalidateHelpSyn,
+		HelpDescription: jwksCacheInvalidateHelpDesc,
+	}
+}
+
+func (b *jwtAuthBackend) pathJWKSCacheInvalidate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	roleName := d.Get("role").(string)
+	if roleName == "" {
+		return logical.ErrorResponse("missing role"), nil
+	}
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("could not load configuration"), nil
+	}
+
+	role, err := b.role(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("role %q could not be found", roleName), nil
+	}
+
+	jwksURLs := roleJWKSURLs(config, role)
+	if len(jwksURLs) == 0 {
+		return logical.ErrorResponse("role %q does not verify tokens with a jwks", roleName), nil
+	}
+
+	for _, jwksURL := range jwksURLs {
+		cacheKey := jwksCacheKey(roleName, jwksURL)
+		v, _ := b.jwksCache.LoadOrStore(cacheKey, new(jwksCacheEntry))
+		if _, err := b.refreshKeySet(config, role, cacheKey, v.(*jwksCacheEntry), jwksURL); err != nil {
+			return logical.ErrorResponse("error refreshing jwks %q: %s", jwksURL, err), nil
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"refreshed": jwksURLs,
+		},
+	}, nil
+}
+
+const (
+	jwksCacheInvalidateHelpSyn = `
+Refreshes the cached JWKS key sets of a role.
+`
+	jwksCacheInvalidateHelpDesc = `
+The key sets a role verifies tokens with are fetched from its jwks_urls, or
+the jwks_url of the config, and cached for the role's jwks_cache_duration.
+This endpoint fetches them immediately, for example after the provider has
+rotated its keys.
+`
+)
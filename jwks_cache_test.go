@@ -0,0 +1,332 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// jwksCacheServer serves a key set with the test key as "key-1", counting
+// fetches. The server fails while down is set.
+type jwksCacheServer struct {
+	*httptest.Server
+	fetches int32
+	down    int32
+}
+
+func newJWKSCacheServer(t *testing.T) *jwksCacheServer {
+	block, _ := pem.Decode([]byte(ecdsaPubKey))
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySet, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: pub, KeyID: "key-1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := new(jwksCacheServer)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.fetches, 1)
+		if atomic.LoadInt32(&s.down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(keySet)
+	}))
+	return s
+}
+
+// waitForFetches waits until the server has been fetched n times.
+func (s *jwksCacheServer) waitForFetches(t *testing.T, n int32) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&s.fetches) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d fetches, got %d", n, atomic.LoadInt32(&s.fetches))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func jwksCacheLogin(b logical.Backend, storage logical.Storage, token string) (*logical.Response, error) {
+	return b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role": "plugin-test",
+			"jwt":  token,
+		},
+		Connection: &logical.Connection{
+			RemoteAddr: "127.0.0.1",
+		},
+	})
+}
+
+func TestLogin_JWKSCache(t *testing.T) {
+	srv := newJWKSCacheServer(t)
+	defer srv.Close()
+
+	now := time.Now()
+	var clockLock sync.Mutex
+	defer func(clock func() time.Time) { jwksClock = clock }(jwksClock)
+	jwksClock = func() time.Time {
+		clockLock.Lock()
+		defer clockLock.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		clockLock.Lock()
+		defer clockLock.Unlock()
+		now = now.Add(d)
+	}
+
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		jwks:     true,
+		configData: map[string]interface{}{
+			"jwks_url": srv.URL,
+		},
+		roleData: map[string]interface{}{
+			"jwks_cache_duration":      "10m",
+			"jwks_cache_max_staleness": "30m",
+		},
+	})
+	defer b.closeServerFunc()
+
+	// The config write fetched the key set to check jwks_url.
+	atomic.StoreInt32(&srv.fetches, 0)
+
+	token := perKidTestJWT(t, "key-1")
+	login := func() *logical.Response {
+		t.Helper()
+		resp, err := jwksCacheLogin(b, storage, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	expectSuccess := func() {
+		t.Helper()
+		if resp := login(); resp == nil || resp.IsError() {
+			t.Fatalf("expected successful login, got: %v", resp)
+		}
+	}
+
+	expectSuccess()
+	expectSuccess()
+	if n := atomic.LoadInt32(&srv.fetches); n != 1 {
+		t.Fatalf("expected the key set to be fetched once, got %d", n)
+	}
+
+	// A key set older than jwks_cache_duration is refreshed in the
+	// background while it keeps being used.
+	advance(11 * time.Minute)
+	expectSuccess()
+	srv.waitForFetches(t, 2)
+
+	// A failed refresh keeps the cached key set until it is older than
+	// jwks_cache_max_staleness.
+	atomic.StoreInt32(&srv.down, 1)
+	advance(11 * time.Minute)
+	expectSuccess()
+	srv.waitForFetches(t, 3)
+	advance(15 * time.Minute)
+	expectSuccess()
+	srv.waitForFetches(t, 4)
+
+	advance(5 * time.Minute)
+	resp := login()
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "jwks_cache_max_staleness") {
+		t.Fatalf("expected stale key set error, got: %v", resp)
+	}
+
+	// The login succeeds again once the key set is refreshed.
+	atomic.StoreInt32(&srv.down, 0)
+	expectSuccess()
+
+	// Tokens signed with an unknown key cause a new fetch, but no more than
+	// once every jwksCacheRetryInterval.
+	fetches := atomic.LoadInt32(&srv.fetches)
+	advance(jwksCacheRetryInterval)
+	unknown := perKidTestJWT(t, "key-2")
+	for i := 0; i < 3; i++ {
+		resp, err := jwksCacheLogin(b, storage, unknown)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), `no key with kid "key-2"`) {
+			t.Fatalf("expected unknown key error, got: %v", resp)
+		}
+	}
+	if n := atomic.LoadInt32(&srv.fetches); n != fetches+1 {
+		t.Fatalf("expected one fetch for the unknown key, got %d", n-fetches)
+	}
+}
+
+func TestJWKSCache_Invalidate(t *testing.T) {
+	srv := newJWKSCacheServer(t)
+	defer srv.Close()
+
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		jwks:     true,
+		configData: map[string]interface{}{
+			"jwks_url": srv.URL,
+		},
+	})
+	defer b.closeServerFunc()
+	atomic.StoreInt32(&srv.fetches, 0)
+
+	invalidate := func(role string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/jwks-cache/invalidate",
+			Storage:   storage,
+			Data:      map[string]interface{}{"role": role},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp, err := jwksCacheLogin(b, storage, perKidTestJWT(t, "key-1")); err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("expected successful login, got: %v %v", resp, err)
+	}
+
+	resp := invalidate("plugin-test")
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected successful invalidation, got: %v", resp)
+	}
+	if refreshed := resp.Data["refreshed"].([]string); len(refreshed) != 1 || refreshed[0] != srv.URL {
+		t.Fatalf("unexpected refreshed urls: %v", refreshed)
+	}
+	if n := atomic.LoadInt32(&srv.fetches); n != 2 {
+		t.Fatalf("expected the key set to be refetched, got %d fetches", n)
+	}
+
+	atomic.StoreInt32(&srv.down, 1)
+	if resp := invalidate("plugin-test"); resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "503") {
+		t.Fatalf("expected refresh error, got: %v", resp)
+	}
+
+	if resp := invalidate("missing"); resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for unknown role, got: %v", resp)
+	}
+}
+
+func TestRole_JWKSCacheMaxStalenessValidation(t *testing.T) {
+	b, storage := getBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_type":                "jwt",
+			"user_claim":               "user",
+			"bound_subject":            "testsub",
+			"jwks_cache_duration":      "2h",
+			"jwks_cache_max_staleness": "1h",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for max staleness below the cache duration, got: %v", resp)
+	}
+}
+
+func TestLogin_JWKSCacheConcurrent(t *testing.T) {
+	srv := newJWKSCacheServer(t)
+	defer srv.Close()
+
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		jwks:     true,
+		configData: map[string]interface{}{
+			"jwks_url": srv.URL,
+		},
+	})
+	defer b.closeServerFunc()
+	atomic.StoreInt32(&srv.fetches, 0)
+
+	// The clock moves forward quickly, so that logins race with background
+	// refreshes, invalidations and stale key set refetches.
+	var offset int64
+	defer func(clock func() time.Time) { jwksClock = clock }(jwksClock)
+	jwksClock = func() time.Time {
+		return time.Now().Add(time.Duration(atomic.AddInt64(&offset, int64(time.Minute))))
+	}
+
+	token := perKidTestJWT(t, "key-1")
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%10 == 0 {
+				resp, err := b.HandleRequest(context.Background(), &logical.Request{
+					Operation: logical.UpdateOperation,
+					Path:      "config/jwks-cache/invalidate",
+					Storage:   storage,
+					Data:      map[string]interface{}{"role": "plugin-test"},
+				})
+				if err == nil && (resp == nil || resp.IsError()) {
+					err = fmt.Errorf("expected successful invalidation, got: %v", resp)
+				}
+				errs <- err
+				return
+			}
+
+			resp, err := jwksCacheLogin(b, storage, token)
+			if err == nil && (resp == nil || resp.IsError()) {
+				err = fmt.Errorf("expected successful login, got: %v", resp)
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Background refreshes use jwksClock, so they must finish before it is
+	// restored.
+	deadline := time.Now().Add(5 * time.Second)
+	for refreshing := true; refreshing; {
+		if time.Now().After(deadline) {
+			t.Fatal("background refreshes didn't finish")
+		}
+		refreshing = false
+		b.Backend.(*jwtAuthBackend).jwksCache.Range(func(_, v interface{}) bool {
+			entry := v.(*jwksCacheEntry)
+			entry.l.RLock()
+			defer entry.l.RUnlock()
+			refreshing = refreshing || entry.refreshing
+			return true
+		})
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,129 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-cleanhttp"
+	"golang.org/x/oauth2"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const (
+	// jwksKidPlaceholder is replaced with the token's key ID in a role's
+	// jwks_per_kid_url_template.
+	jwksKidPlaceholder = "{kid}"
+
+	// perKidKeyTimeout is how long a key fetched with a per-kid URL is cached.
+	perKidKeyTimeout = 5 * time.Minute
+)
+
+// verifyWithPerKidKey verifies the signature of token with the key fetched
+// from the role's jwks_per_kid_url_template for the token's kid header, and
+// returns the payload.
+func (b *jwtAuthBackend) verifyWithPerKidKey(ctx context.Context, config *jwtConfig, role *jwtRole, token string) ([]byte, error) {
+	jws, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(jws.Signatures) == 0 {
+		return nil, errors.New("token has no signatures")
+	}
+
+	kid := jws.Signatures[0].Header.KeyID
+	if kid == "" {
+		return nil, errors.New("token has no kid header")
+	}
+
+	keyURL := strings.Replace(role.JWKSPerKidURLTemplate, jwksKidPlaceholder, url.PathEscape(kid), -1)
+
+	var key *jose.JSONWebKey
+	if cached, ok := b.perKidKeys.Get(keyURL); ok {
+		key = cached.(*jose.JSONWebKey)
+	} else {
+		// A failed fetch is returned to all the waiting logins, and the
+		// next login after it makes a new attempt.
+		fetched, err, _ := b.perKidFetches.Do(keyURL, func() (interface{}, error) {
+			if cached, ok := b.perKidKeys.Get(keyURL); ok {
+				return cached, nil
+			}
+
+			caCtx, err := b.createCAContext(ctx, config, config.JWKSCAPEM)
+			if err != nil {
+				return nil, errwrap.Wrapf("error parsing jwks_ca_pem: {{err}}", err)
+			}
+			key, err := fetchPerKidKey(caCtx, keyURL, kid)
+			if err != nil {
+				return nil, err
+			}
+			b.perKidKeys.SetDefault(keyURL, key)
+
+			return key, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		key = fetched.(*jose.JSONWebKey)
+	}
+
+	if err := checkAlgorithmRestrictions(role, jws.Signatures[0].Header.Algorithm, []interface{}{key}); err != nil {
+		return nil, err
+	}
+
+	return jws.Verify(key)
+}
+
+// fetchPerKidKey fetches keyURL and returns the key with the given kid. The
+// document may be either a single JWK or a JWK set.
+func fetchPerKidKey(ctx context.Context, keyURL, kid string) (*jose.JSONWebKey, error) {
+	req, err := http.NewRequest(http.MethodGet, keyURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client, ok := ctx.Value(oauth2.HTTPClient).(*http.Client)
+	if !ok {
+		client = cleanhttp.DefaultClient()
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errwrap.Wrapf("error fetching key: {{err}}", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errwrap.Wrapf("error reading key: {{err}}", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d fetching key", resp.StatusCode)
+	}
+
+	var keySet jose.JSONWebKeySet
+	if err := json.Unmarshal(body, &keySet); err == nil && len(keySet.Keys) > 0 {
+		if keys := keySet.Key(kid); len(keys) > 0 {
+			return &keys[0], nil
+		}
+		return nil, fmt.Errorf("no key with kid %q found", kid)
+	}
+
+	var key jose.JSONWebKey
+	if err := json.Unmarshal(body, &key); err != nil {
+		return nil, errwrap.Wrapf("error parsing key: {{err}}", err)
+	}
+	if key.KeyID != "" && key.KeyID != kid {
+		return nil, fmt.Errorf("fetched key has kid %q, expected %q", key.KeyID, kid)
+	}
+
+	return &key, nil
+}
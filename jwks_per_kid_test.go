@@ -0,0 +1,262 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestLogin_JWKSPerKid(t *testing.T) {
+	block, _ := pem.Decode([]byte(ecdsaPubKey))
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := json.Marshal(jose.JSONWebKey{Key: pub, KeyID: "key-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/keys/key-1" {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write(jwk)
+	}))
+	defer srv.Close()
+
+	b, storage := setupBackend(t, testConfig{
+		jwks:     true,
+		audience: true,
+		roleData: map[string]interface{}{
+			"jwks_per_kid_url_template": srv.URL + "/keys/{kid}",
+		},
+	})
+	defer b.closeServerFunc()
+
+	block, _ = pem.Decode([]byte(ecdsaPrivKey))
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "key-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := jwt.Claims{
+		Audience:  jwt.Audience{"https://vault.plugin.auth.jwt.test"},
+		Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		NotBefore: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		Expiry:    jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+	privateCl := map[string]interface{}{
+		"https://vault/user":   "foobar",
+		"https://vault/groups": []string{"foo"},
+	}
+	token, err := jwt.Signed(sig).Claims(cl).Claims(privateCl).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role": "plugin-test",
+			"jwt":  token,
+		},
+		Connection: &logical.Connection{
+			RemoteAddr: "127.0.0.1",
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || resp.IsError() {
+			t.Fatalf("expected successful login, got: %v", resp)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("expected the fetched key to be cached, got %d requests", requests)
+	}
+
+	// A token without a kid header falls back to jwks_url.
+	req = setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+}
+
+func TestRole_JWKSPerKidTemplate(t *testing.T) {
+	b, storage := getBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_type":                 "jwt",
+			"user_claim":                "user",
+			"bound_subject":             "testsub",
+			"jwks_per_kid_url_template": "https://provider.example/jwks",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for template without {kid}, got: %v", resp)
+	}
+}
+
+// perKidTestJWT returns a test JWT signed with ecdsaPrivKey, with kid set
+// in its header.
+func perKidTestJWT(t *testing.T, kid string) string {
+	t.Helper()
+
+	block, _ := pem.Decode([]byte(ecdsaPrivKey))
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", kid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := jwt.Claims{
+		Audience:  jwt.Audience{"https://vault.plugin.auth.jwt.test"},
+		Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		NotBefore: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		Expiry:    jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+	privateCl := map[string]interface{}{
+		"https://vault/user":   "foobar",
+		"https://vault/groups": []string{"foo"},
+	}
+	token, err := jwt.Signed(sig).Claims(cl).Claims(privateCl).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestLogin_JWKSPerKidConcurrent(t *testing.T) {
+	block, _ := pem.Decode([]byte(ecdsaPubKey))
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := json.Marshal(jose.JSONWebKey{Key: pub, KeyID: "key-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var requests int32
+	var failing int32 = 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(200 * time.Millisecond)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(jwk)
+	}))
+	defer srv.Close()
+
+	b, storage := setupBackend(t, testConfig{
+		jwks:     true,
+		audience: true,
+		roleData: map[string]interface{}{
+			"jwks_per_kid_url_template": srv.URL + "/keys/{kid}",
+		},
+	})
+	defer b.closeServerFunc()
+
+	token := perKidTestJWT(t, "key-1")
+	loginConcurrently := func(success bool) {
+		t.Helper()
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 50)
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := b.HandleRequest(context.Background(), &logical.Request{
+					Operation: logical.UpdateOperation,
+					Path:      "login",
+					Storage:   storage,
+					Data: map[string]interface{}{
+						"role": "plugin-test",
+						"jwt":  token,
+					},
+					Connection: &logical.Connection{
+						RemoteAddr: "127.0.0.1",
+					},
+				})
+				if err == nil && success && (resp == nil || resp.IsError()) {
+					err = fmt.Errorf("expected successful login, got: %v", resp)
+				}
+				if err == nil && !success && (resp == nil || !resp.IsError()) {
+					err = fmt.Errorf("expected login error, got: %v", resp)
+				}
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// A failed fetch is returned to all the waiting logins. jwks_url
+	// doesn't have the key either.
+	loginConcurrently(false)
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("expected 1 request, got %d", n)
+	}
+
+	// The next logins make a new attempt.
+	atomic.StoreInt32(&failing, 0)
+	loginConcurrently(true)
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("expected 2 requests, got %d", n)
+	}
+
+	// The fetched key is cached.
+	loginConcurrently(true)
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("expected 2 requests, got %d", n)
+	}
+}
@@ -0,0 +1,92 @@
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-cleanhttp"
+	"golang.org/x/oauth2"
+)
+
+// defaultJWKSURLTimeout is used when jwks_url_timeout is not set on the role.
+const defaultJWKSURLTimeout = 5 * time.Second
+
+// jwksURLTimeout returns the timeout of fetching each of the role's jwks_urls.
+func (r *jwtRole) jwksURLTimeout() time.Duration {
+	if r.JWKSURLTimeout <= 0 {
+		return defaultJWKSURLTimeout
+	}
+	return r.JWKSURLTimeout
+}
+
+// verifyWithRoleJWKS verifies the signature of token with the cached keys of
+// the role's jwks_urls, trying each URL in order until one of them has the
+// signing key, and returns the payload. The token is rejected as having an
+// invalid signature if any of the URLs has its key.
+func (b *jwtAuthBackend) verifyWithRoleJWKS(config *jwtConfig, roleName string, role *jwtRole, token string) ([]byte, error) {
+	var errs []string
+	rejection := rejectionTransient
+	for _, jwksURL := range role.JWKSURLs {
+		payload, err := b.verifyWithCachedJWKS(config, roleName, role, jwksURL, token)
+		if err == nil {
+			return payload, nil
+		}
+		if rejectionOf(err) == rejectionSignature {
+			rejection = rejectionSignature
+		}
+
+		b.Logger().Debug("token verification failed with jwks url", "url", jwksURL, "error", err)
+		errs = append(errs, fmt.Sprintf("%s: %s", jwksURL, err))
+	}
+
+	err := errors.New(strings.Join(errs, "; "))
+	if rejection == rejectionSignature {
+		return nil, rejectSignature(err)
+	}
+	return nil, err
+}
+
+// roleKeySet returns the cached key set of a URL in a role's jwks_urls. Key
+// sets are cached by URL and timeout, and dropped when the config changes.
+func (b *jwtAuthBackend) roleKeySet(config *jwtConfig, jwksURL string, timeout time.Duration) (oidc.KeySet, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	cacheKey := timeout.String() + " " + jwksURL
+	if keySet, ok := b.roleKeySets[cacheKey]; ok {
+		return keySet, nil
+	}
+
+	ctx, err := b.jwksContext(config, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	keySet := oidc.NewRemoteKeySet(ctx, jwksURL)
+	b.roleKeySets[cacheKey] = keySet
+
+	return keySet, nil
+}
+
+// jwksContext returns a context for fetching key sets with the config's
+// jwks_ca_pem and the given timeout.
+func (b *jwtAuthBackend) jwksContext(config *jwtConfig, timeout time.Duration) (context.Context, error) {
+	ctx, err := b.createCAContext(b.providerCtx, config, config.JWKSCAPEM)
+	if err != nil {
+		return nil, errwrap.Wrapf("error parsing jwks_ca_pem: {{err}}", err)
+	}
+
+	client := cleanhttp.DefaultPooledClient()
+	if c, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		client = &http.Client{Transport: c.Transport}
+	}
+	client.Timeout = timeout
+
+	return context.WithValue(ctx, oauth2.HTTPClient, client), nil
+}
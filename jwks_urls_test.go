@@ -0,0 +1,155 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func TestLogin_RoleJWKSURLs(t *testing.T) {
+	// The primary endpoint is down.
+	primary := newOIDCProvider(t)
+	primaryURL := primary.server.URL + "/certs"
+	primary.server.Close()
+
+	// The secondary endpoint hasn't received the signing key yet.
+	stale := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"keys": []}`))
+	}))
+	defer stale.Close()
+
+	dr := newOIDCProvider(t)
+	defer dr.server.Close()
+
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		jwks:     true,
+		roleData: map[string]interface{}{
+			"jwks_urls":        []string{primaryURL, stale.URL + "/certs", dr.server.URL + "/certs"},
+			"jwks_url_timeout": "1s",
+		},
+	})
+	defer b.closeServerFunc()
+
+	req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+
+	// The login fails once no URL has the key.
+	dr.server.Close()
+	b.Backend.(*jwtAuthBackend).reset()
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), primaryURL) {
+		t.Fatalf("expected error listing each jwks url, got: %v", resp)
+	}
+}
+
+func TestRole_InvalidJWKSURLs(t *testing.T) {
+	b, storage := getBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_type":     "jwt",
+			"user_claim":    "user",
+			"bound_subject": "testsub",
+			"jwks_urls":     "https://example.com/keys,not a url",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for invalid jwks url, got: %v", resp)
+	}
+}
+
+func TestLogin_RoleJWKSURLsConcurrent(t *testing.T) {
+	block, _ := pem.Decode([]byte(ecdsaPubKey))
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySet, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: pub, KeyID: "key-1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(200 * time.Millisecond)
+		w.Write(keySet)
+	}))
+	defer srv.Close()
+
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		jwks:     true,
+		roleData: map[string]interface{}{
+			"jwks_urls": []string{srv.URL},
+		},
+	})
+	defer b.closeServerFunc()
+
+	// Concurrent logins missing the key share a single fetch.
+	token := perKidTestJWT(t, "key-1")
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "login",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"role": "plugin-test",
+					"jwt":  token,
+				},
+				Connection: &logical.Connection{
+					RemoteAddr: "127.0.0.1",
+				},
+			})
+			if err == nil && (resp == nil || resp.IsError()) {
+				err = fmt.Errorf("expected successful login, got: %v", resp)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("expected 1 request, got %d", n)
+	}
+}
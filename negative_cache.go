@@ -0,0 +1,152 @@
+package jwtauth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// tokenRejection classifies why a login token was rejected. Only definitive
+// rejections, which validating the same token again would repeat, are
+// negatively cached.
+type tokenRejection int
+
+const (
+	// rejectionTransient is a failure that may not happen again for the same
+	// token, e.g. because the provider or its keys couldn't be reached.
+	rejectionTransient tokenRejection = iota
+
+	// rejectionSignature is a token that is malformed or whose signature is
+	// invalid.
+	rejectionSignature
+
+	// rejectionClaims is a token that is expired or whose claims don't match
+	// the role.
+	rejectionClaims
+)
+
+// tokenVerification is the outcome of verifyLoginToken that decides whether
+// and how a rejected token is negatively cached.
+type tokenVerification struct {
+	// signatureVerified is set once the signature of the token was verified
+	// with a key, also if its claims were then rejected.
+	signatureVerified bool
+
+	rejection tokenRejection
+}
+
+func (v tokenVerification) rejected(rejection tokenRejection) tokenVerification {
+	v.rejection = rejection
+	return v
+}
+
+// rejectionError marks an error as a definitive rejection of the token.
+type rejectionError struct {
+	rejection tokenRejection
+	err       error
+}
+
+func (e *rejectionError) Error() string {
+	return e.err.Error()
+}
+
+func rejectSignature(err error) error {
+	return &rejectionError{rejection: rejectionSignature, err: err}
+}
+
+func rejectClaims(err error) error {
+	return &rejectionError{rejection: rejectionClaims, err: err}
+}
+
+// rejectionOf returns the rejection err was marked with, or
+// rejectionTransient if it wasn't.
+func rejectionOf(err error) tokenRejection {
+	if e, ok := err.(*rejectionError); ok {
+		return e.rejection
+	}
+	return rejectionTransient
+}
+
+// keySetRejection classifies an error of the go-oidc verifier or key set.
+// Failures to fetch the keys, and any error not known to be about the token
+// itself, are transient.
+func keySetRejection(err error) tokenRejection {
+	if isKeyFetchError(err) {
+		return rejectionTransient
+	}
+
+	msg := err.Error()
+	for _, s := range []string{"failed to verify id token signature", "oidc: malformed jwt", "oidc: id token not signed", "oidc: multiple signatures", "oidc: id token signed with unsupported algorithm"} {
+		if strings.Contains(msg, s) {
+			return rejectionSignature
+		}
+	}
+	for _, s := range []string{"oidc: token is expired", "oidc: id token issued by a different provider", "oidc: expected audience", "oidc: failed to unmarshal claims"} {
+		if strings.Contains(msg, s) {
+			return rejectionClaims
+		}
+	}
+	return rejectionTransient
+}
+
+// negativeCacheKey returns the cache key of a token rejected when logging in
+// against roleName. Only a hash of the token is kept.
+func negativeCacheKey(roleName, token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return roleName + ":" + hex.EncodeToString(sum[:])
+}
+
+// claimsCacheKey returns a cache key for roleName derived from the values of
+// fields in claims, as configured in oidc_cache_key_fields. false is
+// returned if no fields are configured or a field is missing.
+func claimsCacheKey(roleName string, fields []string, claims map[string]interface{}) (string, bool) {
+	if len(fields) == 0 || claims == nil {
+		return "", false
+	}
+
+	values := make([]interface{}, len(fields))
+	for i, field := range fields {
+		value, ok := claims[field]
+		if !ok {
+			return "", false
+		}
+		values[i] = value
+	}
+
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return "", false
+	}
+
+	sum := sha256.Sum256(encoded)
+	return roleName + ":claims:" + hex.EncodeToString(sum[:]), true
+}
+
+// unverifiedClaims returns the claims of token without verifying its
+// signature, or nil if it can't be parsed.
+func unverifiedClaims(token string) map[string]interface{} {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil
+	}
+
+	var claims map[string]interface{}
+	if err := parsed.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return nil
+	}
+
+	return claims
+}
+
+// flushNegativeCache removes the rejected tokens cached for roleName, so that
+// changes to the role apply to tokens that were previously rejected.
+func (b *jwtAuthBackend) flushNegativeCache(roleName string) {
+	for key := range b.negativeCache.Items() {
+		if strings.HasPrefix(key, roleName+":") {
+			b.negativeCache.Delete(key)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestLogin_NegativeCache(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		roleData: map[string]interface{}{
+			"bound_subject":           "other",
+			"oidc_negative_cache_ttl": "1m",
+		},
+	})
+	backend := b.Backend.(*jwtAuthBackend)
+	req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for wrong subject, got: %v", resp)
+	}
+	if backend.negativeCache.ItemCount() != 1 {
+		t.Fatalf("expected the rejected token to be cached, got %d items", backend.negativeCache.ItemCount())
+	}
+
+	cached, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached == nil || cached.Error().Error() != resp.Error().Error() {
+		t.Fatalf("expected cached error %q, got: %v", resp.Error(), cached)
+	}
+
+	// Updating the role flushes its cached rejections.
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/plugin-test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_type":     "jwt",
+			"bound_subject": "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+}
+
+func TestLogin_NegativeCacheKeyFields(t *testing.T) {
+	for _, fields := range []string{"", "sub,iss"} {
+		b, storage := setupBackend(t, testConfig{
+			audience: true,
+			roleData: map[string]interface{}{
+				"bound_subject":           "other",
+				"oidc_negative_cache_ttl": "1m",
+				"oidc_cache_key_fields":   fields,
+			},
+		})
+		backend := b.Backend.(*jwtAuthBackend)
+
+		for _, jti := range []string{"a", "b"} {
+			cl := jwt.Claims{
+				ID:       jti,
+				Audience: jwt.Audience{"https://vault.plugin.auth.jwt.test"},
+				Issuer:   "https://team-vault.auth0.com/",
+				Subject:  "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+				IssuedAt: jwt.NewNumericDate(time.Now()),
+				Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			}
+			token, _ := getTestJWT(t, ecdsaPrivKey, cl, map[string]interface{}{"https://vault/user": "foobar"})
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "login",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"role": "plugin-test",
+					"jwt":  token,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp == nil || !resp.IsError() {
+				t.Fatalf("expected error for wrong subject, got: %v", resp)
+			}
+		}
+
+		// Without key fields each token is cached separately; with them, the
+		// second token is rejected from the cache entry of the first.
+		expected := 2
+		if fields != "" {
+			expected = 1
+		}
+		if n := backend.negativeCache.ItemCount(); n != expected {
+			t.Fatalf("fields %q: expected %d cached rejections, got %d", fields, expected, n)
+		}
+	}
+}
+
+func TestLogin_NegativeCacheDefinitiveRejections(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "rsa", Use: "sig"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	available := int32(1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&available) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(jwks)
+	}))
+	defer server.Close()
+
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		configData: map[string]interface{}{
+			"jwt_validation_pubkeys": "",
+			"jwks_url":               server.URL,
+		},
+		roleData: map[string]interface{}{
+			"oidc_negative_cache_ttl": "1m",
+		},
+	})
+	backend := b.Backend.(*jwtAuthBackend)
+	atomic.StoreInt32(&available, 0)
+
+	// A JWKS outage isn't cached, so the token is accepted once the keys
+	// can be fetched again.
+	token := signedLoginJWT(t, jose.RS256, key, "rsa")
+	if resp := loginWithJWT(t, b, storage, token); resp == nil || !resp.IsError() {
+		t.Fatalf("expected error while the jwks is unavailable, got: %v", resp)
+	}
+	if n := backend.negativeCache.ItemCount(); n != 0 {
+		t.Fatalf("expected the jwks outage not to be cached, got %d items", n)
+	}
+	atomic.StoreInt32(&available, 1)
+	if resp := loginWithJWT(t, b, storage, token); resp == nil || resp.IsError() || resp.Auth == nil {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "rsa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := jwt.Signed(signer).Claims(jwt.Claims{
+		Audience: jwt.Audience{"https://vault.plugin.auth.jwt.test"},
+		Issuer:   "https://team-vault.auth0.com/",
+		Subject:  "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+		IssuedAt: jwt.NewNumericDate(time.Now().Add(-2 * time.Hour)),
+		Expiry:   jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+	}).Claims(map[string]interface{}{"https://vault/user": "foobar"}).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Invalid signatures and expired tokens are cached.
+	for i, token := range []string{signedLoginJWT(t, jose.RS256, otherKey, "rsa"), expired} {
+		if resp := loginWithJWT(t, b, storage, token); resp == nil || !resp.IsError() {
+			t.Fatalf("%d: expected error, got: %v", i, resp)
+		}
+		if n := backend.negativeCache.ItemCount(); n != i+1 {
+			t.Fatalf("%d: expected %d cached rejections, got %d", i, i+1, n)
+		}
+	}
+}
+
+func TestLogin_NegativeCacheBoundClaims(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		roleData: map[string]interface{}{
+			"bound_claims":            map[string]interface{}{"color": "blue"},
+			"oidc_negative_cache_ttl": "1m",
+		},
+	})
+	backend := b.Backend.(*jwtAuthBackend)
+	req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for mismatched bound claims, got: %v", resp)
+	}
+	if n := backend.negativeCache.ItemCount(); n != 1 {
+		t.Fatalf("expected the bound claims mismatch to be cached, got %d items", n)
+	}
+}
+
+func TestLogin_NegativeCacheKeyFields_RevocationCheck(t *testing.T) {
+	var requests int32
+	var revoked, fail int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch {
+		case atomic.LoadInt32(&fail) == 1:
+			w.WriteHeader(http.StatusInternalServerError)
+		case atomic.LoadInt32(&revoked) == 1:
+			w.Write([]byte(`{"revoked": true}`))
+		default:
+			w.Write([]byte(`{"revoked": false}`))
+		}
+	}))
+	defer srv.Close()
+
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		roleData: map[string]interface{}{
+			"oidc_negative_cache_ttl":   "1m",
+			"oidc_cache_key_fields":     "sub,iss",
+			"oidc_revocation_check_url": srv.URL,
+		},
+	})
+	backend := b.Backend.(*jwtAuthBackend)
+
+	login := func(jti string) *logical.Response {
+		t.Helper()
+		cl := jwt.Claims{
+			ID:       jti,
+			Audience: jwt.Audience{"https://vault.plugin.auth.jwt.test"},
+			Issuer:   "https://team-vault.auth0.com/",
+			Subject:  "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+			Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}
+		token, _ := getTestJWT(t, ecdsaPrivKey, cl, map[string]interface{}{
+			"https://vault/user":   "foobar",
+			"https://vault/groups": []string{"foo", "bar"},
+		})
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role": "plugin-test",
+				"jwt":  token,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// A failed revocation check isn't cached by the claims, which would
+	// reject every token of the subject until the entry expires.
+	atomic.StoreInt32(&fail, 1)
+	if resp := login("a"); resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for a failed revocation check, got: %v", resp)
+	}
+	if n := backend.negativeCache.ItemCount(); n != 0 {
+		t.Fatalf("expected the failed revocation check not to be cached, got %d items", n)
+	}
+	atomic.StoreInt32(&fail, 0)
+	if resp := login("b"); resp == nil || resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+
+	// A revoked token is cached by the claims, and rejects the other tokens
+	// of the subject without checking them.
+	atomic.StoreInt32(&revoked, 1)
+	if resp := login("c"); resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for a revoked token, got: %v", resp)
+	}
+	checked := atomic.LoadInt32(&requests)
+	if resp := login("d"); resp == nil || !resp.IsError() {
+		t.Fatalf("expected cached rejection, got: %v", resp)
+	}
+	if n := atomic.LoadInt32(&requests); n != checked {
+		t.Fatalf("expected the cached rejection not to be checked, got %d more requests", n-checked)
+	}
+}
@@ -0,0 +1,166 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// stateBackendStorage is the oidc_distributed_state_backend value that
+	// stores pending OIDC states in barrier storage in addition to memory.
+	stateBackendStorage = "vault-storage"
+
+	// oidcStatePrefix is the storage prefix of pending OIDC states.
+	oidcStatePrefix = "oidc_state/"
+
+	// Bounds and default of oidc_state_param_bits. The default is the
+	// 160 bits recommended by RFC 6749 section 10.10.
+	minStateParamBits     = 128
+	maxStateParamBits     = 512
+	defaultStateParamBits = 160
+)
+
+// stateRandReader is the entropy source of state parameters and nonces.
+var stateRandReader io.Reader = rand.Reader
+
+// randomStateValue returns bits random bits from stateRandReader, encoded
+// as URL-safe base64. bits must be a multiple of 8.
+func randomStateValue(bits int) (string, error) {
+	buf := make([]byte, bits/8)
+	if _, err := io.ReadFull(stateRandReader, buf); err != nil {
+		return "", fmt.Errorf("error generating random value: %s", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// stateStorageKey returns the storage key of a pending state. State IDs are
+// hashed so that their length and characters don't affect the key.
+func stateStorageKey(stateID string) string {
+	sum := sha256.Sum256([]byte(stateID))
+	return oidcStatePrefix + hex.EncodeToString(sum[:])
+}
+
+// storedOIDCState is the storage representation of an oidcState.
+type storedOIDCState struct {
+	RoleName      string    `json:"role_name"`
+	Nonce         string    `json:"nonce"`
+	RedirectURI   string    `json:"redirect_uri"`
+	ClientIP      string    `json:"client_ip"`
+	InlineData    string    `json:"inline_data"`
+	CodeChallenge string    `json:"code_challenge"`
+	PoWDifficulty int       `json:"pow_difficulty"`
+	Created       time.Time `json:"created"`
+	Expiry        time.Time `json:"expiry"`
+}
+
+// storeState writes a pending state, expiring after ttl, to storage so that
+// the callback can be handled by another node.
+func storeState(ctx context.Context, s logical.Storage, stateID string, state *oidcState, ttl time.Duration) error {
+	now := time.Now()
+	entry, err := logical.StorageEntryJSON(stateStorageKey(stateID), storedOIDCState{
+		RoleName:      state.rolename,
+		Nonce:         state.nonce,
+		RedirectURI:   state.redirectURI,
+		ClientIP:      state.clientIP,
+		InlineData:    state.inlineData,
+		CodeChallenge: state.codeChallenge,
+		PoWDifficulty: state.powDifficulty,
+		Created:       now,
+		Expiry:        now.Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// takeStoredState reads and deletes a pending state from storage. A nil state
+// is returned if it is not found or expired.
+func (b *jwtAuthBackend) takeStoredState(ctx context.Context, s logical.Storage, stateID string) (*oidcState, error) {
+	// The lock makes the read and delete atomic on this node, so that a
+	// state can only be used once.
+	b.stateLock.Lock()
+	defer b.stateLock.Unlock()
+
+	entry, err := s.Get(ctx, stateStorageKey(stateID))
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	if err := s.Delete(ctx, stateStorageKey(stateID)); err != nil {
+		return nil, err
+	}
+
+	var stored storedOIDCState
+	if err := entry.DecodeJSON(&stored); err != nil {
+		return nil, err
+	}
+	if time.Now().After(stored.Expiry) {
+		return nil, nil
+	}
+
+	return &oidcState{
+		rolename:      stored.RoleName,
+		nonce:         stored.Nonce,
+		redirectURI:   stored.RedirectURI,
+		clientIP:      stored.ClientIP,
+		inlineData:    stored.InlineData,
+		codeChallenge: stored.CodeChallenge,
+		powDifficulty: stored.PoWDifficulty,
+	}, nil
+}
+
+// tidyOIDCStates removes expired pending states from storage. It is run from
+// the backend's periodic function.
+func (b *jwtAuthBackend) tidyOIDCStates(ctx context.Context, s logical.Storage) error {
+	_, _, err := b.cleanupOIDCStates(ctx, s, 0)
+	return err
+}
+
+// cleanupOIDCStates removes the pending states that are expired or, if
+// olderThan is set, were created more than olderThan ago, and returns the
+// number of deleted and remaining states. States stored before their
+// creation time was recorded are considered older than olderThan.
+func (b *jwtAuthBackend) cleanupOIDCStates(ctx context.Context, s logical.Storage, olderThan time.Duration) (int, int, error) {
+	keys, err := s.List(ctx, oidcStatePrefix)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	now := time.Now()
+	deleted, remaining := 0, 0
+	for _, key := range keys {
+		// States taken by a callback in the meantime are skipped, so
+		// that logins in progress are not affected.
+		b.stateLock.Lock()
+		entry, err := s.Get(ctx, oidcStatePrefix+key)
+		if err == nil && entry != nil {
+			var stored storedOIDCState
+			if err = entry.DecodeJSON(&stored); err == nil {
+				if now.After(stored.Expiry) || (olderThan > 0 && now.Sub(stored.Created) > olderThan) {
+					if err = s.Delete(ctx, oidcStatePrefix+key); err == nil {
+						deleted++
+					}
+				} else {
+					remaining++
+				}
+			}
+		}
+		b.stateLock.Unlock()
+
+		if err != nil {
+			return deleted, remaining, err
+		}
+	}
+
+	return deleted, remaining, nil
+}
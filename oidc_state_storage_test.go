@@ -0,0 +1,237 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/bits"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestOIDC_DistributedState(t *testing.T) {
+	b, storage, s := getBackendAndServerWithConfig(t, false, map[string]interface{}{
+		"oidc_distributed_state_backend": stateBackendStorage,
+	})
+	defer s.server.Close()
+
+	s.code = "abc"
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "oidc/auth_url",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role":         "test",
+			"redirect_uri": "https://example.com",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	authURL := resp.Data["auth_url"].(string)
+	s.customClaims = sampleClaims(getQueryParam(t, authURL, "nonce"))
+
+	// Simulate the callback being handled by a node that didn't create the
+	// state.
+	b.(*jwtAuthBackend).oidcStates.Flush()
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "oidc/callback",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"state": getQueryParam(t, authURL, "state"),
+			"code":  "abc",
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	// The state can only be used once.
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for reused state, got: %v", resp)
+	}
+
+	keys, err := storage.List(context.Background(), oidcStatePrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("expected no stored states, got: %v", keys)
+	}
+}
+
+func TestTidyOIDCStates(t *testing.T) {
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	for key, expiry := range map[string]time.Time{
+		"expired": time.Now().Add(-time.Minute),
+		"pending": time.Now().Add(time.Minute),
+	} {
+		entry, err := logical.StorageEntryJSON(oidcStatePrefix+key, storedOIDCState{Expiry: expiry})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Put(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := b.(*jwtAuthBackend).tidyOIDCStates(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := storage.List(ctx, oidcStatePrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "pending" {
+		t.Fatalf("expected only the pending state to remain, got: %v", keys)
+	}
+}
+
+func TestRandomStateValue(t *testing.T) {
+	for _, size := range []int{minStateParamBits, defaultStateParamBits, maxStateParamBits} {
+		value, err := randomStateValue(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(decoded)*8 != size {
+			t.Fatalf("expected %d bits, got %d", size, len(decoded)*8)
+		}
+	}
+
+	// Basic statistical checks: no repeated values, and about as many set
+	// bits as unset ones.
+	const n = 1000
+	seen := make(map[string]bool)
+	ones := 0
+	for i := 0; i < n; i++ {
+		value, err := randomStateValue(minStateParamBits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[value] {
+			t.Fatalf("repeated state %q", value)
+		}
+		seen[value] = true
+
+		decoded, _ := base64.RawURLEncoding.DecodeString(value)
+		for _, c := range decoded {
+			ones += bits.OnesCount8(c)
+		}
+	}
+	if ratio := float64(ones) / (n * minStateParamBits); ratio < 0.48 || ratio > 0.52 {
+		t.Fatalf("unexpected ratio of set bits: %f", ratio)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("entropy exhausted")
+}
+
+func TestRandomStateValue_ReaderFailure(t *testing.T) {
+	defer func(r io.Reader) { stateRandReader = r }(stateRandReader)
+	stateRandReader = failingReader{}
+
+	if _, err := randomStateValue(defaultStateParamBits); err == nil || !strings.Contains(err.Error(), "entropy exhausted") {
+		t.Fatalf("expected entropy error, got: %v", err)
+	}
+
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "oidc/auth_url",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role":         "test",
+			"redirect_uri": "https://example.com",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authURL := resp.Data["auth_url"]; authURL != "" {
+		t.Fatalf("expected no auth_url, got: %v", authURL)
+	}
+}
+
+func TestOIDC_StateParamBits(t *testing.T) {
+	b, storage, s := getBackendAndServerWithConfig(t, false, map[string]interface{}{
+		"oidc_state_param_bits":          256,
+		"oidc_distributed_state_backend": stateBackendStorage,
+	})
+	defer s.server.Close()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "oidc/auth_url",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role":         "test",
+			"redirect_uri": "https://example.com",
+		},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+
+	u, err := url.Parse(resp.Data["auth_url"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := u.Query().Get("state")
+	decoded, err := base64.RawURLEncoding.DecodeString(state)
+	if err != nil || len(decoded) != 32 {
+		t.Fatalf("expected a 256-bit URL-safe base64 state, got %q", state)
+	}
+
+	// The state is stored under its hash.
+	entry, err := storage.Get(context.Background(), stateStorageKey(state))
+	if err != nil || entry == nil {
+		t.Fatalf("expected stored state, got: %v (%v)", entry, err)
+	}
+	if len(stateStorageKey(state)) != len(oidcStatePrefix)+64 {
+		t.Fatalf("unexpected storage key %q", stateStorageKey(state))
+	}
+
+	for _, bits := range []int{64, 120, 130, 520} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      configPath,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"jwt_validation_pubkeys": ecdsaPubKey,
+				"oidc_state_param_bits":  bits,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || !resp.IsError() {
+			t.Fatalf("expected error for oidc_state_param_bits %d", bits)
+		}
+	}
+}
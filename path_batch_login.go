@@ -0,0 +1,182 @@
+package jwtauth
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/sync/errgroup"
+)
+
+// defaultBatchLoginMaxSize is used when batch_login_max_size is not set.
+const defaultBatchLoginMaxSize = 100
+
+// batchLoginResult is the result of validating one of the tokens of a
+// batch-login request.
+type batchLoginResult struct {
+	Role     string            `json:"role"`
+	Valid    bool              `json:"valid"`
+	Alias    string            `json:"alias,omitempty"`
+	Policies []string          `json:"policies,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+func pathBatchLogin(b *jwtAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: `batch-login`,
+		Fields: map[string]*framework.FieldSchema{
+			"tokens": {
+				Type:        framework.TypeSlice,
+				Description: `The tokens to validate, as a list of objects with a "jwt" and an optional "role".`,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathBatchLogin,
+				Summary:  "Validate several JWTs against their roles in a single request.",
+			},
+		},
+
+		HelpSynopsis:    pathBatchLoginHelpSyn,
+		HelpDescription: pathBatchLoginHelpDesc,
+	}
+}
+
+func (b *jwtAuthBackend) pathBatchLogin(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("could not load configuration"), nil
+	}
+
+	tokens := d.Get("tokens").([]interface{})
+	if len(tokens) == 0 {
+		return logical.ErrorResponse("missing tokens"), nil
+	}
+	if max := config.batchLoginMaxSize(); len(tokens) > max {
+		return logical.ErrorResponse("too many tokens: %d, the maximum is %d", len(tokens), max), nil
+	}
+
+	entries := make([]map[string]interface{}, len(tokens))
+	for i, raw := range tokens {
+		entry, ok := raw.(map[string]interface{})
+		if !ok {
+			return logical.ErrorResponse("token %d is not an object", i), nil
+		}
+		entries[i] = entry
+	}
+
+	// Each token is validated like a separate login. Failures are reported
+	// in its result, so they don't stop the other validations.
+	results := make([]batchLoginResult, len(entries))
+	var g errgroup.Group
+	for i, entry := range entries {
+		i, entry := i, entry
+		g.Go(func() error {
+			results[i] = b.batchLoginEntry(ctx, req, config, entry)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"results": results,
+		},
+	}, nil
+}
+
+// batchLoginEntry validates a single token of a batch-login request. The
+// token is validated like a login, but no token is issued, so the login side
+// effects such as webhooks, token IP tracking and token stats don't apply.
+func (b *jwtAuthBackend) batchLoginEntry(ctx context.Context, req *logical.Request, config *jwtConfig, entry map[string]interface{}) batchLoginResult {
+	role, _ := entry["role"].(string)
+	result := batchLoginResult{Role: role}
+
+	token, ok := entry["jwt"].(string)
+	if !ok || token == "" {
+		result.Error = "missing jwt"
+		return result
+	}
+
+	auth, resp, err := b.batchLoginAuth(ctx, req, config, role, token)
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+	case resp != nil:
+		result.Error = "login failed"
+		if resp.IsError() {
+			result.Error = resp.Error().Error()
+		}
+	default:
+		result.Valid = true
+		result.Role, _ = auth.InternalData["role"].(string)
+		result.Alias = auth.Alias.Name
+		result.Policies = auth.Policies
+		result.Metadata = auth.Metadata
+	}
+
+	return result
+}
+
+// batchLoginAuth returns the alias, policies and metadata a login with token
+// would get from the role and its claim_policies_map.
+func (b *jwtAuthBackend) batchLoginAuth(ctx context.Context, req *logical.Request, config *jwtConfig, roleName, token string) (*logical.Auth, *logical.Response, error) {
+	roleName, role, resp, err := b.loginRole(ctx, req.Storage, config, roleName, token)
+	if err != nil || resp != nil {
+		return nil, resp, err
+	}
+
+	allClaims, resp, err := b.validateLoginToken(ctx, req, config, role, roleName, token)
+	if err != nil || resp != nil {
+		return nil, resp, err
+	}
+
+	alias, _, err := b.createIdentity(ctx, config, allClaims, role, nil)
+	if err != nil {
+		return nil, logical.ErrorResponse(err.Error()), nil
+	}
+
+	metadata := map[string]string{"role": roleName}
+	for k, v := range alias.Metadata {
+		metadata[k] = v
+	}
+	auth := &logical.Auth{
+		Alias: alias,
+		InternalData: map[string]interface{}{
+			"role": roleName,
+		},
+		Metadata: metadata,
+	}
+	role.PopulateTokenAuth(auth)
+	role.applyClaimPolicies(b.Logger(), allClaims, auth)
+
+	return auth, nil, nil
+}
+
+const (
+	pathBatchLoginHelpSyn = `
+Validates several JWTs against their roles in a single request.
+`
+	pathBatchLoginHelpDesc = `
+Each token is validated concurrently like a login to its role (or the
+default role), including its bound claims and revocation checks. The result
+of each token says whether it is valid and, if so, the alias, the policies
+of the role and its claim_policies_map, and the metadata of its claim
+mappings, or the validation error otherwise. One invalid token doesn't fail
+the others. Nothing is recorded for the tokens: webhooks and the policy
+engine are not called, and the tokens are not counted in oidc/stats.
+
+No Vault tokens are issued: Vault creates a single token from the response
+of a login request, so a batch request can't return one per JWT. This
+endpoint is not unauthenticated, and rate limit quotas apply to the batch
+request as a whole. At most batch_login_max_size tokens, by default 100, can
+be validated at once.
+`
+)
@@ -0,0 +1,204 @@
+package jwtauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBatchLogin(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{audience: true})
+
+	validJWT := func() string {
+		return setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage).Data["jwt"].(string)
+	}
+	expiredJWT := setupLogin(t, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour), time.Time{}, b, storage).Data["jwt"].(string)
+
+	batchLogin := func(tokens []interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "batch-login",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"tokens": tokens,
+			},
+			Connection: &logical.Connection{
+				RemoteAddr: "127.0.0.1",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	t.Run("mixed", func(t *testing.T) {
+		var tokens []interface{}
+		for i := 0; i < 20; i++ {
+			tokens = append(tokens, map[string]interface{}{"role": "plugin-test", "jwt": validJWT()})
+		}
+		tokens = append(tokens,
+			map[string]interface{}{"role": "plugin-test", "jwt": expiredJWT},
+			map[string]interface{}{"role": "missing", "jwt": validJWT()},
+			map[string]interface{}{"role": "plugin-test"},
+		)
+
+		resp := batchLogin(tokens)
+		if resp == nil || resp.IsError() {
+			t.Fatalf("unexpected response: %#v", resp)
+		}
+		if resp.Auth != nil {
+			t.Fatal("expected no token to be issued")
+		}
+
+		results := resp.Data["results"].([]batchLoginResult)
+		if len(results) != len(tokens) {
+			t.Fatalf("expected %d results, got %d", len(tokens), len(results))
+		}
+		for i, result := range results[:20] {
+			if !result.Valid || result.Error != "" || result.Role != "plugin-test" || result.Alias != "foobar" {
+				t.Fatalf("unexpected result %d: %#v", i, result)
+			}
+			if len(result.Policies) != 1 || result.Policies[0] != "test" {
+				t.Fatalf("unexpected policies of result %d: %v", i, result.Policies)
+			}
+		}
+
+		for i, expected := range []string{"token is expired", `role "missing" could not be found`, "missing jwt"} {
+			result := results[20+i]
+			if result.Valid || !strings.Contains(result.Error, expected) {
+				t.Fatalf("expected error %q, got: %#v", expected, result)
+			}
+		}
+	})
+
+	t.Run("max size", func(t *testing.T) {
+		var tokens []interface{}
+		for i := 0; i < defaultBatchLoginMaxSize+1; i++ {
+			tokens = append(tokens, map[string]interface{}{"role": "plugin-test", "jwt": "token"})
+		}
+		if resp := batchLogin(tokens); resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "too many tokens") {
+			t.Fatalf("expected error for too many tokens, got: %#v", resp)
+		}
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      configPath,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"bound_issuer":           "https://team-vault.auth0.com/",
+				"jwt_validation_pubkeys": ecdsaPubKey,
+				"batch_login_max_size":   2,
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		tokens = tokens[:3]
+		if resp := batchLogin(tokens); resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "the maximum is 2") {
+			t.Fatalf("expected error for too many tokens, got: %#v", resp)
+		}
+		if resp := batchLogin(tokens[:2]); resp == nil || resp.IsError() {
+			t.Fatalf("unexpected response: %#v", resp)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, tokens := range [][]interface{}{nil, {"token"}} {
+			if resp := batchLogin(tokens); resp == nil || !resp.IsError() {
+				t.Fatalf("expected error for tokens %v, got: %#v", tokens, resp)
+			}
+		}
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      configPath,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"jwt_validation_pubkeys": ecdsaPubKey,
+				"batch_login_max_size":   -1,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || !resp.IsError() {
+			t.Fatalf("expected error for negative batch_login_max_size, got: %#v", resp)
+		}
+	})
+}
+
+func TestBatchLogin_NoSideEffects(t *testing.T) {
+	calls := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(webhookSignatureHeader) != "" {
+			calls <- "login"
+			return
+		}
+		calls <- "metadata"
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		roleData: map[string]interface{}{
+			"oidc_webhook_url":     srv.URL,
+			"oidc_webhook_secret":  "s3cr3t",
+			"metadata_webhook_url": srv.URL,
+		},
+	})
+	backend := b.Backend.(*jwtAuthBackend)
+
+	req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "batch-login",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"tokens": []interface{}{
+				map[string]interface{}{"role": "plugin-test", "jwt": req.Data["jwt"]},
+			},
+		},
+		Connection: req.Connection,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	if result := resp.Data["results"].([]batchLoginResult)[0]; !result.Valid {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+	if counts := backend.tokenStats.counts(time.Now()); len(counts) != 0 {
+		t.Fatalf("expected no issued tokens to be counted, got: %v", counts)
+	}
+
+	// A regular login calls both webhooks, and they are the only calls
+	// received.
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	received := map[string]int{}
+	for len(received) < 2 {
+		select {
+		case call := <-calls:
+			received[call]++
+		case <-time.After(5 * time.Second):
+			t.Fatalf("webhooks were not called: %v", received)
+		}
+	}
+	if received["login"] != 1 || received["metadata"] != 1 || len(calls) != 0 {
+		t.Fatalf("unexpected webhook calls: %v, %d pending", received, len(calls))
+	}
+	if counts := backend.tokenStats.counts(time.Now()); len(counts) != 1 {
+		t.Fatalf("expected the login to be counted, got: %v", counts)
+	}
+}
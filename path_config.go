@@ -7,17 +7,27 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/coreos/go-oidc"
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/go-cleanhttp"
 	"github.com/hashicorp/vault/sdk/framework"
-	"github.com/hashicorp/vault/sdk/helper/certutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"golang.org/x/oauth2"
 )
 
+// maxJWTClockSkewLeeway is the largest jwt_clock_skew_leeway accepted, since
+// larger values would defeat the validation of time based claims.
+const maxJWTClockSkewLeeway = 60 * time.Second
+
+// defaultHTTPTimeout is the default oidc_http_timeout.
+const defaultHTTPTimeout = 30 * time.Second
+
 func pathConfig(b *jwtAuthBackend) *framework.Path {
 	return &framework.Path{
 		Pattern: `config`,
@@ -26,6 +36,14 @@ func pathConfig(b *jwtAuthBackend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: `OIDC Discovery URL, without any .well-known component (base path). Cannot be used with "jwks_url" or "jwt_validation_pubkeys".`,
 			},
+			"oidc_discovery_cache_duration": {
+				Type:        framework.TypeDurationSecond,
+				Description: `How long the OIDC discovery document is used before it is refreshed in the background. Defaults to 1 hour.`,
+			},
+			"oidc_discovery_max_staleness": {
+				Type:        framework.TypeDurationSecond,
+				Description: `How long the OIDC discovery document keeps being used while it can't be refreshed. Logins fail once the cached document is older than this. Must not be less than "oidc_discovery_cache_duration". Defaults to 24 hours, or "oidc_discovery_cache_duration" if it is longer.`,
+			},
 			"oidc_discovery_ca_pem": {
 				Type:        framework.TypeString,
 				Description: "The CA certificate or chain of certificates, in PEM format, to use to validate connections to the OIDC Discovery URL. If not set, system certificates are used.",
@@ -53,18 +71,156 @@ func pathConfig(b *jwtAuthBackend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: "The default role to use if none is provided during login. If not set, a role is required during login.",
 			},
+			"audience_role_mapping": {
+				Type:        framework.TypeKVPairs,
+				Description: "Mappings of token audiences to the role to use if none is provided during login. Takes precedence over default_role.",
+			},
 			"jwt_validation_pubkeys": {
 				Type:        framework.TypeCommaStringSlice,
-				Description: `A list of PEM-encoded public keys to use to authenticate signatures locally. Cannot be used with "jwks_url" or "oidc_discovery_url".`,
+				Description: `A list of PEM-encoded RSA, ECDSA or Ed25519 public keys to use to authenticate signatures locally. Cannot be used with "jwks_url" or "oidc_discovery_url".`,
 			},
 			"jwt_supported_algs": {
 				Type:        framework.TypeCommaStringSlice,
-				Description: `A list of supported signing algorithms. Defaults to RS256.`,
+				Description: `A list of supported signing algorithms, e.g. RS256, ES256, PS256 or EdDSA. Defaults to RS256 with "oidc_discovery_url". With "jwks_url" or "jwt_validation_pubkeys", all algorithms of the keys are accepted if unset.`,
+			},
+			"jwt_clock_skew_leeway": {
+				Type:        framework.TypeDurationSecond,
+				Description: `Leeway for clock differences with the identity provider, added to the role's clock_skew_leeway: tokens are accepted up to this long after 'exp' and before 'nbf', and with an 'iat' this far in the future. At most 60 seconds. Defaults to 0.`,
 			},
 			"bound_issuer": {
 				Type:        framework.TypeString,
 				Description: "The value against which to match the 'iss' claim in a JWT. Optional.",
 			},
+			"oidc_use_state_cookie": {
+				Type:        framework.TypeBool,
+				Description: `If set, auth_url sets a "vault-oidc-state" cookie which must accompany the OIDC callback. Requires "Cookie" in passthrough_request_headers and "Set-Cookie" in allowed_response_headers for the mount.`,
+			},
+			"oidc_sign_auth_url": {
+				Type:        framework.TypeBool,
+				Description: `If set, auth_url appends an HMAC of the complete authorization URL as the "_sig" parameter, which can be checked with oidc/verify_auth_url.`,
+			},
+			"oidc_post_logout_redirect_uri": {
+				Type:        framework.TypeString,
+				Description: `The post_logout_redirect_uri passed to the provider by oidc/end-session, typically this mount's oidc/logged-out path.`,
+			},
+			"oidc_registration_access_token": {
+				Type:        framework.TypeString,
+				Description: `The initial access token sent to the provider's registration endpoint by oidc/register-client. Not returned on read.`,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"oidc_claims_enrichment_plugin": {
+				Type:        framework.TypeString,
+				Description: `Path to a Go plugin (.so) on the plugin host whose "Enricher" variable implements ClaimsEnricher. The enricher transforms claims before they are validated against the role.`,
+			},
+			"oidc_bind_ip_to_state": {
+				Type:        framework.TypeBool,
+				Description: `If set, the OIDC callback must come from the same client address that requested the authorization URL.`,
+			},
+			"oidc_geoip_database": {
+				Type:        framework.TypeString,
+				Description: `Path to a MaxMind GeoIP2 or GeoLite2 country or city database (.mmdb) on the Vault server, used for the oidc_allowed_countries and oidc_denied_countries of roles. The file is reloaded by the periodic function when it changes, so it can be updated in place, e.g. by geoipupdate.`,
+			},
+			"oidc_inline_data_max_bytes": {
+				Type:        framework.TypeInt,
+				Description: `The maximum decoded size of the inline_data accepted by auth_url and returned in the token metadata. Defaults to 0, which disables inline_data.`,
+			},
+			"oidc_error_mapping": {
+				Type:        framework.TypeKVPairs,
+				Description: `Mappings of OIDC error codes returned by the provider (key) to the message shown to the user (value).`,
+			},
+			"oidc_circuit_breaker_threshold": {
+				Type:        framework.TypeInt,
+				Description: `The number of consecutive provider failures within oidc_circuit_breaker_window after which OIDC logins fail immediately. Defaults to 0, which disables the circuit breaker.`,
+			},
+			"oidc_circuit_breaker_window": {
+				Type:        framework.TypeDurationSecond,
+				Description: `The period in which failures are counted towards oidc_circuit_breaker_threshold. Defaults to 60 seconds.`,
+			},
+			"oidc_circuit_breaker_cooldown": {
+				Type:        framework.TypeDurationSecond,
+				Description: `The time after which a single request is sent to the provider to test whether it has recovered. Defaults to 30 seconds.`,
+			},
+			"oidc_distributed_state_backend": {
+				Type:        framework.TypeString,
+				Description: `Where pending OIDC login states are kept. If set to "vault-storage", states are also written to storage so that the callback can be handled by another node. Defaults to memory only.`,
+			},
+			"oidc_device_code_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: `The maximum time a device flow login can be pending before the user authorizes it. Device codes expire sooner if the provider's expires_in is shorter. Defaults to 10 minutes.`,
+			},
+			"oidc_pow_difficulty": {
+				Type:        framework.TypeInt,
+				Description: `If set, OIDC logins require a proof-of-work solution: auth_url returns a "pow_puzzle" and "pow_difficulty", and the callback must be given a "pow_solution" such that the SHA-256 hash of the puzzle, ":" and the solution starts with that many zero bits. The difficulty is raised by one bit for every 10 failed callbacks in the last 5 minutes, by up to 8 bits, and never exceeds 32. Defaults to 0, no proof of work.`,
+			},
+			"nonce_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: `How long the state and nonce of an OIDC login are valid for, between auth_url and the callback. Defaults to 10 minutes.`,
+			},
+			"batch_login_max_size": {
+				Type:        framework.TypeInt,
+				Description: `The maximum number of tokens validated by a single batch-login request. Defaults to 100.`,
+			},
+			"oidc_state_param_bits": {
+				Type:        framework.TypeInt,
+				Description: `The number of random bits of the OIDC state parameter, a multiple of 8 from 128 to 512. Defaults to 160.`,
+			},
+			"oidc_http_timeout": {
+				Type:        framework.TypeDurationSecond,
+				Description: `Timeout of outbound requests to the OIDC provider, JWKS URLs and other services. Defaults to 30 seconds.`,
+			},
+			"oidc_adaptive_timeout": {
+				Type:        framework.TypeBool,
+				Description: `If set, the timeout of requests to the OIDC provider is increased by 50% while the P99 of its response times over the last 5 minutes is above 80% of 'oidc_http_timeout', and restored once it drops below 50%. The current timeout is reported by oidc/provider-health.`,
+			},
+			"oidc_http_proxy": {
+				Type:        framework.TypeString,
+				Description: `URL of the proxy used for outbound requests. If not set, the proxy environment variables of the Vault server are used.`,
+			},
+			"oidc_tls_ca_cert": {
+				Type:        framework.TypeString,
+				Description: `PEM-encoded CA certificates trusted for outbound requests, in addition to the system roots.`,
+			},
+			"oidc_cert_expiry_warn_days": {
+				Type:        framework.TypeInt,
+				Description: `A warning is logged by the provider health check when the TLS certificate of the OIDC discovery URL expires in fewer days than this. Defaults to 30.`,
+			},
+			"oidc_request_parameter_object": {
+				Type:        framework.TypeBool,
+				Description: `If set, auth_url passes the authorization parameters as a request object signed with "oidc_request_object_signing_key" (RFC 9101). The provider must advertise "request_parameter_supported".`,
+			},
+			"oidc_request_object_signing_key": {
+				Type:        framework.TypeString,
+				Description: `The PEM-encoded RSA or EC private key that request objects are signed with. Not returned on read.`,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"oidc_jar_encryption_key_pem": {
+				Type:        framework.TypeString,
+				Description: `The PEM-encoded RSA or EC public key of the provider that signed request objects are encrypted with, using RSA-OAEP-256 or ECDH-ES+A256KW respectively and A256GCM. Requires "oidc_request_parameter_object".`,
+			},
+			"oidc_auto_role_template": {
+				Type:        framework.TypeMap,
+				Description: `Template of the ephemeral roles created for logins to roles that don't exist. Logins succeed if the token's "trigger_claim" has the value "trigger_value". Other keys are "policies", "ttl", "max_ttl", "bound_claims", "bound_audiences", "user_claim" and "groups_claim". Ephemeral roles are deleted when their tokens expire.`,
+			},
+			"oidc_federation_issuer": {
+				Type:        framework.TypeString,
+				Description: `The issuer of tokens federated from another Vault cluster's identity token provider. If set, the 'iss' claim of every token must match it. Cannot differ from "bound_issuer".`,
+			},
+			"oidc_federation_audience": {
+				Type:        framework.TypeString,
+				Description: `If set, the 'aud' claim of every token must contain this value. Also used as the bound audience for roles without "bound_audiences".`,
+			},
+			"provider_config": {
+				Type:        framework.TypeMap,
+				Description: `Provider specific handling configuration. The "provider" key selects a built-in provider; supported values are "azure", "github", "google", "keycloak", "okta" and "pingidentity".`,
+			},
+			"oidc_provider_health_check_interval": {
+				Type:        framework.TypeDurationSecond,
+				Description: `If set, the OIDC discovery document is fetched at this interval and the result reported by the "oidc/provider-health" endpoint and the jwtauth_provider_health gauge of "oidc/metrics". Checks run from the periodic function, so intervals shorter than a minute are not honored.`,
+			},
 		},
 
 		Operations: map[logical.Operation]framework.OperationHandler{
@@ -107,13 +263,35 @@ func (b *jwtAuthBackend) config(ctx context.Context, s logical.Storage) (*jwtCon
 	}
 
 	for _, v := range result.JWTValidationPubKeys {
-		key, err := certutil.ParsePublicKeyPEM([]byte(v))
+		key, err := parsePublicKeyPEM([]byte(v))
 		if err != nil {
 			return nil, errwrap.Wrapf("error parsing public key: {{err}}", err)
 		}
 		result.ParsedJWTPubKeys = append(result.ParsedJWTPubKeys, key)
 	}
 
+	provider, err := newCustomProvider(result)
+	if err != nil {
+		return nil, err
+	}
+	result.provider = provider
+
+	enricher, err := loadClaimsEnricher(result.OIDCClaimsEnrichmentPlugin)
+	if err != nil {
+		return nil, err
+	}
+	result.enricher = enricher
+
+	result.autoRoleTemplate, err = parseAutoRoleTemplate(result.OIDCAutoRoleTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.OIDCAdaptiveTimeout {
+		b.adaptiveTimeout.setLogger(b.Logger())
+		result.adaptiveTimeout = b.adaptiveTimeout
+	}
+
 	b.cachedConfig = result
 
 	return result, nil
@@ -130,15 +308,48 @@ func (b *jwtAuthBackend) pathConfigRead(ctx context.Context, req *logical.Reques
 
 	resp := &logical.Response{
 		Data: map[string]interface{}{
-			"oidc_discovery_url":     config.OIDCDiscoveryURL,
-			"oidc_discovery_ca_pem":  config.OIDCDiscoveryCAPEM,
-			"oidc_client_id":         config.OIDCClientID,
-			"default_role":           config.DefaultRole,
-			"jwt_validation_pubkeys": config.JWTValidationPubKeys,
-			"jwt_supported_algs":     config.JWTSupportedAlgs,
-			"jwks_url":               config.JWKSURL,
-			"jwks_ca_pem":            config.JWKSCAPEM,
-			"bound_issuer":           config.BoundIssuer,
+			"oidc_discovery_url":                  config.OIDCDiscoveryURL,
+			"oidc_discovery_ca_pem":               config.OIDCDiscoveryCAPEM,
+			"oidc_client_id":                      config.OIDCClientID,
+			"default_role":                        config.DefaultRole,
+			"audience_role_mapping":               config.AudienceRoleMapping,
+			"jwt_validation_pubkeys":              config.JWTValidationPubKeys,
+			"jwt_supported_algs":                  config.JWTSupportedAlgs,
+			"jwt_clock_skew_leeway":               int64(config.JWTClockSkewLeeway.Seconds()),
+			"jwks_url":                            config.JWKSURL,
+			"jwks_ca_pem":                         config.JWKSCAPEM,
+			"bound_issuer":                        config.BoundIssuer,
+			"oidc_use_state_cookie":               config.OIDCUseStateCookie,
+			"oidc_provider_health_check_interval": int64(config.OIDCProviderHealthCheckInterval.Seconds()),
+			"provider_config":                     config.ProviderConfig,
+			"oidc_federation_issuer":              config.OIDCFederationIssuer,
+			"oidc_federation_audience":            config.OIDCFederationAudience,
+			"oidc_sign_auth_url":                  config.OIDCSignAuthURL,
+			"oidc_post_logout_redirect_uri":       config.OIDCPostLogoutRedirectURI,
+			"oidc_claims_enrichment_plugin":       config.OIDCClaimsEnrichmentPlugin,
+			"oidc_bind_ip_to_state":               config.OIDCBindIPToState,
+			"oidc_geoip_database":                 config.OIDCGeoIPDatabase,
+			"oidc_inline_data_max_bytes":          config.OIDCInlineDataMaxBytes,
+			"oidc_error_mapping":                  config.OIDCErrorMapping,
+			"oidc_circuit_breaker_threshold":      config.OIDCCircuitBreakerThreshold,
+			"oidc_circuit_breaker_window":         int64(config.OIDCCircuitBreakerWindow.Seconds()),
+			"oidc_circuit_breaker_cooldown":       int64(config.OIDCCircuitBreakerCooldown.Seconds()),
+			"oidc_distributed_state_backend":      config.OIDCDistributedStateBackend,
+			"oidc_device_code_ttl":                int64(config.DeviceCodeTTL.Seconds()),
+			"oidc_pow_difficulty":                 config.OIDCPoWDifficulty,
+			"oidc_discovery_cache_duration":       int64(config.OIDCDiscoveryCacheDuration.Seconds()),
+			"oidc_discovery_max_staleness":        int64(config.OIDCDiscoveryMaxStaleness.Seconds()),
+			"nonce_ttl":                           int64(config.NonceTTL.Seconds()),
+			"oidc_state_param_bits":               config.OIDCStateParamBits,
+			"batch_login_max_size":                config.BatchLoginMaxSize,
+			"oidc_http_timeout":                   int64(config.OIDCHTTPTimeout.Seconds()),
+			"oidc_adaptive_timeout":               config.OIDCAdaptiveTimeout,
+			"oidc_http_proxy":                     config.OIDCHTTPProxy,
+			"oidc_tls_ca_cert":                    config.OIDCTLSCACert,
+			"oidc_cert_expiry_warn_days":          config.OIDCCertExpiryWarnDays,
+			"oidc_request_parameter_object":       config.OIDCRequestParameterObject,
+			"oidc_jar_encryption_key_pem":         config.OIDCJAREncryptionKeyPEM,
+			"oidc_auto_role_template":             config.OIDCAutoRoleTemplate,
 		},
 	}
 
@@ -147,16 +358,51 @@ func (b *jwtAuthBackend) pathConfigRead(ctx context.Context, req *logical.Reques
 
 func (b *jwtAuthBackend) pathConfigWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	config := &jwtConfig{
-		OIDCDiscoveryURL:     d.Get("oidc_discovery_url").(string),
-		OIDCDiscoveryCAPEM:   d.Get("oidc_discovery_ca_pem").(string),
-		OIDCClientID:         d.Get("oidc_client_id").(string),
-		OIDCClientSecret:     d.Get("oidc_client_secret").(string),
-		JWKSURL:              d.Get("jwks_url").(string),
-		JWKSCAPEM:            d.Get("jwks_ca_pem").(string),
-		DefaultRole:          d.Get("default_role").(string),
-		JWTValidationPubKeys: d.Get("jwt_validation_pubkeys").([]string),
-		JWTSupportedAlgs:     d.Get("jwt_supported_algs").([]string),
-		BoundIssuer:          d.Get("bound_issuer").(string),
+		OIDCDiscoveryURL:                d.Get("oidc_discovery_url").(string),
+		OIDCDiscoveryCAPEM:              d.Get("oidc_discovery_ca_pem").(string),
+		OIDCClientID:                    d.Get("oidc_client_id").(string),
+		OIDCClientSecret:                d.Get("oidc_client_secret").(string),
+		JWKSURL:                         d.Get("jwks_url").(string),
+		JWKSCAPEM:                       d.Get("jwks_ca_pem").(string),
+		DefaultRole:                     d.Get("default_role").(string),
+		AudienceRoleMapping:             d.Get("audience_role_mapping").(map[string]string),
+		JWTValidationPubKeys:            d.Get("jwt_validation_pubkeys").([]string),
+		JWTSupportedAlgs:                d.Get("jwt_supported_algs").([]string),
+		JWTClockSkewLeeway:              time.Duration(d.Get("jwt_clock_skew_leeway").(int)) * time.Second,
+		BoundIssuer:                     d.Get("bound_issuer").(string),
+		OIDCUseStateCookie:              d.Get("oidc_use_state_cookie").(bool),
+		OIDCProviderHealthCheckInterval: time.Duration(d.Get("oidc_provider_health_check_interval").(int)) * time.Second,
+		ProviderConfig:                  d.Get("provider_config").(map[string]interface{}),
+		OIDCFederationIssuer:            d.Get("oidc_federation_issuer").(string),
+		OIDCFederationAudience:          d.Get("oidc_federation_audience").(string),
+		OIDCSignAuthURL:                 d.Get("oidc_sign_auth_url").(bool),
+		OIDCPostLogoutRedirectURI:       d.Get("oidc_post_logout_redirect_uri").(string),
+		OIDCRegistrationAccessToken:     d.Get("oidc_registration_access_token").(string),
+		OIDCClaimsEnrichmentPlugin:      d.Get("oidc_claims_enrichment_plugin").(string),
+		OIDCBindIPToState:               d.Get("oidc_bind_ip_to_state").(bool),
+		OIDCGeoIPDatabase:               d.Get("oidc_geoip_database").(string),
+		OIDCInlineDataMaxBytes:          d.Get("oidc_inline_data_max_bytes").(int),
+		OIDCErrorMapping:                d.Get("oidc_error_mapping").(map[string]string),
+		OIDCCircuitBreakerThreshold:     d.Get("oidc_circuit_breaker_threshold").(int),
+		OIDCCircuitBreakerWindow:        time.Duration(d.Get("oidc_circuit_breaker_window").(int)) * time.Second,
+		OIDCCircuitBreakerCooldown:      time.Duration(d.Get("oidc_circuit_breaker_cooldown").(int)) * time.Second,
+		OIDCDistributedStateBackend:     d.Get("oidc_distributed_state_backend").(string),
+		DeviceCodeTTL:                   time.Duration(d.Get("oidc_device_code_ttl").(int)) * time.Second,
+		OIDCPoWDifficulty:               d.Get("oidc_pow_difficulty").(int),
+		OIDCDiscoveryCacheDuration:      time.Duration(d.Get("oidc_discovery_cache_duration").(int)) * time.Second,
+		OIDCDiscoveryMaxStaleness:       time.Duration(d.Get("oidc_discovery_max_staleness").(int)) * time.Second,
+		NonceTTL:                        time.Duration(d.Get("nonce_ttl").(int)) * time.Second,
+		OIDCStateParamBits:              d.Get("oidc_state_param_bits").(int),
+		BatchLoginMaxSize:               d.Get("batch_login_max_size").(int),
+		OIDCHTTPTimeout:                 time.Duration(d.Get("oidc_http_timeout").(int)) * time.Second,
+		OIDCAdaptiveTimeout:             d.Get("oidc_adaptive_timeout").(bool),
+		OIDCHTTPProxy:                   d.Get("oidc_http_proxy").(string),
+		OIDCTLSCACert:                   d.Get("oidc_tls_ca_cert").(string),
+		OIDCCertExpiryWarnDays:          d.Get("oidc_cert_expiry_warn_days").(int),
+		OIDCRequestParameterObject:      d.Get("oidc_request_parameter_object").(bool),
+		OIDCRequestObjectSigningKey:     d.Get("oidc_request_object_signing_key").(string),
+		OIDCJAREncryptionKeyPEM:         d.Get("oidc_jar_encryption_key_pem").(string),
+		OIDCAutoRoleTemplate:            d.Get("oidc_auto_role_template").(map[string]interface{}),
 	}
 
 	// Run checks on values
@@ -171,7 +417,68 @@ func (b *jwtAuthBackend) pathConfigWrite(ctx context.Context, req *logical.Reque
 		methodCount++
 	}
 
+	if _, err := buildHTTPClient(config); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
 	switch {
+	case config.OIDCProviderHealthCheckInterval < 0:
+		return logical.ErrorResponse("'oidc_provider_health_check_interval' must not be negative"), nil
+
+	case config.OIDCProviderHealthCheckInterval > 0 && config.OIDCDiscoveryURL == "":
+		return logical.ErrorResponse("'oidc_discovery_url' must be set to use 'oidc_provider_health_check_interval'"), nil
+
+	case config.OIDCCircuitBreakerThreshold < 0, config.OIDCCircuitBreakerWindow < 0, config.OIDCCircuitBreakerCooldown < 0:
+		return logical.ErrorResponse("'oidc_circuit_breaker_threshold', 'oidc_circuit_breaker_window' and 'oidc_circuit_breaker_cooldown' must not be negative"), nil
+
+	case config.OIDCDistributedStateBackend != "" && config.OIDCDistributedStateBackend != stateBackendStorage:
+		return logical.ErrorResponse("invalid 'oidc_distributed_state_backend' %q, must be empty or %q", config.OIDCDistributedStateBackend, stateBackendStorage), nil
+
+	case config.OIDCDiscoveryCacheDuration < 0, config.OIDCDiscoveryMaxStaleness < 0:
+		return logical.ErrorResponse("'oidc_discovery_cache_duration' and 'oidc_discovery_max_staleness' must not be negative"), nil
+
+	case config.discoveryMaxStaleness() < config.discoveryCacheDuration():
+		return logical.ErrorResponse("'oidc_discovery_max_staleness' must not be less than 'oidc_discovery_cache_duration'"), nil
+
+	case config.DeviceCodeTTL < 0:
+		return logical.ErrorResponse("'oidc_device_code_ttl' must not be negative"), nil
+
+	case config.OIDCPoWDifficulty < 0 || config.OIDCPoWDifficulty > maxPoWDifficulty:
+		return logical.ErrorResponse("'oidc_pow_difficulty' must be from 0 to %d", maxPoWDifficulty), nil
+
+	case config.JWTClockSkewLeeway > maxJWTClockSkewLeeway:
+		return logical.ErrorResponse("'jwt_clock_skew_leeway' must not be greater than %s", maxJWTClockSkewLeeway), nil
+
+	case config.OIDCCertExpiryWarnDays < 0:
+		return logical.ErrorResponse("'oidc_cert_expiry_warn_days' must not be negative"), nil
+
+	case config.OIDCRequestParameterObject && config.OIDCRequestObjectSigningKey == "":
+		return logical.ErrorResponse("'oidc_request_object_signing_key' must be set to use 'oidc_request_parameter_object'"), nil
+
+	case config.OIDCRequestParameterObject && config.OIDCDiscoveryURL == "":
+		return logical.ErrorResponse("'oidc_discovery_url' must be set to use 'oidc_request_parameter_object'"), nil
+
+	case config.OIDCJAREncryptionKeyPEM != "" && !config.OIDCRequestParameterObject:
+		return logical.ErrorResponse("'oidc_request_parameter_object' must be set to use 'oidc_jar_encryption_key_pem'"), nil
+
+	case config.OIDCHTTPTimeout < 0:
+		return logical.ErrorResponse("'oidc_http_timeout' must not be negative"), nil
+
+	case config.NonceTTL < 0:
+		return logical.ErrorResponse("'nonce_ttl' must not be negative"), nil
+
+	case config.BatchLoginMaxSize < 0:
+		return logical.ErrorResponse("'batch_login_max_size' must not be negative"), nil
+
+	case config.OIDCStateParamBits != 0 && (config.OIDCStateParamBits < minStateParamBits || config.OIDCStateParamBits > maxStateParamBits || config.OIDCStateParamBits%8 != 0):
+		return logical.ErrorResponse("'oidc_state_param_bits' must be a multiple of 8 from %d to %d", minStateParamBits, maxStateParamBits), nil
+
+	case config.OIDCInlineDataMaxBytes < 0:
+		return logical.ErrorResponse("'oidc_inline_data_max_bytes' must not be negative"), nil
+
+	case config.OIDCFederationIssuer != "" && config.BoundIssuer != "" && config.OIDCFederationIssuer != config.BoundIssuer:
+		return logical.ErrorResponse("'oidc_federation_issuer' and 'bound_issuer' must match if both are set"), nil
+
 	case methodCount != 1:
 		return logical.ErrorResponse("exactly one of 'jwt_validation_pubkeys', 'jwks_url' or 'oidc_discovery_url' must be set"), nil
 
@@ -180,16 +487,19 @@ func (b *jwtAuthBackend) pathConfigWrite(ctx context.Context, req *logical.Reque
 		return logical.ErrorResponse("both 'oidc_client_id' and 'oidc_client_secret' must be set for OIDC"), nil
 
 	case config.OIDCDiscoveryURL != "":
-		_, err := b.createProvider(config)
+		provider, err := b.createProvider(config)
 		if err != nil {
 			return logical.ErrorResponse(errwrap.Wrapf("error checking oidc discovery URL: {{err}}", err).Error()), nil
 		}
+		if config.OIDCRequestParameterObject && !providerSupportsRequestParameter(provider) {
+			return logical.ErrorResponse("'oidc_request_parameter_object' is set but the provider does not advertise 'request_parameter_supported'"), nil
+		}
 
 	case config.OIDCClientID != "" && config.OIDCDiscoveryURL == "":
 		return logical.ErrorResponse("'oidc_discovery_url' must be set for OIDC"), nil
 
 	case config.JWKSURL != "":
-		ctx, err := b.createCAContext(context.Background(), config.JWKSCAPEM)
+		ctx, err := b.createCAContext(context.Background(), config, config.JWKSCAPEM)
 		if err != nil {
 			return logical.ErrorResponse(errwrap.Wrapf("error checking jwks_ca_pem: {{err}}", err).Error()), nil
 		}
@@ -210,7 +520,7 @@ func (b *jwtAuthBackend) pathConfigWrite(ctx context.Context, req *logical.Reque
 
 	case len(config.JWTValidationPubKeys) != 0:
 		for _, v := range config.JWTValidationPubKeys {
-			if _, err := certutil.ParsePublicKeyPEM([]byte(v)); err != nil {
+			if _, err := parsePublicKeyPEM([]byte(v)); err != nil {
 				return logical.ErrorResponse(errwrap.Wrapf("error parsing public key: {{err}}", err).Error()), nil
 			}
 		}
@@ -219,10 +529,42 @@ func (b *jwtAuthBackend) pathConfigWrite(ctx context.Context, req *logical.Reque
 		return nil, errors.New("unknown condition")
 	}
 
+	if config.OIDCRequestObjectSigningKey != "" {
+		key, err := parseEncryptionKey(config.OIDCRequestObjectSigningKey)
+		if err == nil {
+			_, err = requestObjectAlgorithm(key)
+		}
+		if err != nil {
+			return logical.ErrorResponse(errwrap.Wrapf("error parsing 'oidc_request_object_signing_key': {{err}}", err).Error()), nil
+		}
+	}
+
+	if config.OIDCJAREncryptionKeyPEM != "" {
+		if _, _, err := requestObjectEncryptionKey(config.OIDCJAREncryptionKeyPEM); err != nil {
+			return logical.ErrorResponse(errwrap.Wrapf("error parsing 'oidc_jar_encryption_key_pem': {{err}}", err).Error()), nil
+		}
+	}
+
+	if _, err := newCustomProvider(config); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if _, err := loadClaimsEnricher(config.OIDCClaimsEnrichmentPlugin); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if config.OIDCGeoIPDatabase != "" {
+		if _, err := openGeoIPDatabase(config.OIDCGeoIPDatabase); err != nil {
+			return logical.ErrorResponse(errwrap.Wrapf("error opening 'oidc_geoip_database': {{err}}", err).Error()), nil
+		}
+	}
+
+	if _, err := parseAutoRoleTemplate(config.OIDCAutoRoleTemplate); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
 	for _, a := range config.JWTSupportedAlgs {
-		switch a {
-		case oidc.RS256, oidc.RS384, oidc.RS512, oidc.ES256, oidc.ES384, oidc.ES512, oidc.PS256, oidc.PS384, oidc.PS512:
-		default:
+		if !strutil.StrListContains(supportedSigningAlgs, a) {
 			return logical.ErrorResponse(fmt.Sprintf("Invalid supported algorithm: %s", a)), nil
 		}
 	}
@@ -241,7 +583,7 @@ func (b *jwtAuthBackend) pathConfigWrite(ctx context.Context, req *logical.Reque
 }
 
 func (b *jwtAuthBackend) createProvider(config *jwtConfig) (*oidc.Provider, error) {
-	oidcCtx, err := b.createCAContext(b.providerCtx, config.OIDCDiscoveryCAPEM)
+	oidcCtx, err := b.createCAContext(b.providerCtx, config, config.OIDCDiscoveryCAPEM)
 	if err != nil {
 		return nil, errwrap.Wrapf("error creating provider: {{err}}", err)
 	}
@@ -254,46 +596,230 @@ func (b *jwtAuthBackend) createProvider(config *jwtConfig) (*oidc.Provider, erro
 	return provider, nil
 }
 
-// createCAContext returns a context with custom TLS client, configured with the root certificates
-// from caPEM. If no certificates are configured, the original context is returned.
-func (b *jwtAuthBackend) createCAContext(ctx context.Context, caPEM string) (context.Context, error) {
-	if caPEM == "" {
-		return ctx, nil
+// createCAContext returns a context with the HTTP client built from config
+// by buildHTTPClient, for requests to the OIDC provider. If caPEM is set, its
+// certificates are trusted instead of the system roots. With
+// oidc_adaptive_timeout, the timeout is applied by an adaptiveTransport.
+func (b *jwtAuthBackend) createCAContext(ctx context.Context, config *jwtConfig, caPEM string) (context.Context, error) {
+	tc, err := buildHTTPClient(config)
+	if err != nil {
+		return nil, err
 	}
 
-	certPool := x509.NewCertPool()
-	if ok := certPool.AppendCertsFromPEM([]byte(caPEM)); !ok {
-		return nil, errors.New("could not parse CA PEM value successfully")
+	if caPEM != "" {
+		certPool := x509.NewCertPool()
+		if ok := certPool.AppendCertsFromPEM([]byte(caPEM)); !ok {
+			return nil, errors.New("could not parse CA PEM value successfully")
+		}
+		if config.OIDCTLSCACert != "" {
+			certPool.AppendCertsFromPEM([]byte(config.OIDCTLSCACert))
+		}
+		tc.Transport.(*http.Transport).TLSClientConfig = &tls.Config{
+			RootCAs: certPool,
+		}
 	}
 
+	if config.adaptiveTimeout != nil {
+		tc.Transport = &adaptiveTransport{
+			transport: tc.Transport,
+			tracker:   config.adaptiveTimeout,
+			base:      tc.Timeout,
+		}
+		tc.Timeout = 0
+	}
+
+	caCtx := context.WithValue(ctx, oauth2.HTTPClient, tc)
+
+	return caCtx, nil
+}
+
+// buildHTTPClient returns the client for outbound requests, with the
+// config's oidc_http_timeout and oidc_http_proxy, and trusting the
+// oidc_tls_ca_cert certificates in addition to the system roots.
+func buildHTTPClient(config *jwtConfig) (*http.Client, error) {
 	tr := cleanhttp.DefaultPooledTransport()
-	if certPool != nil {
+
+	if config.OIDCHTTPProxy != "" {
+		proxyURL, err := url.Parse(config.OIDCHTTPProxy)
+		if err != nil {
+			return nil, errwrap.Wrapf("error parsing oidc_http_proxy: {{err}}", err)
+		}
+		if proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("oidc_http_proxy %q must be an absolute URL", config.OIDCHTTPProxy)
+		}
+		tr.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if config.OIDCTLSCACert != "" {
+		certPool, err := x509.SystemCertPool()
+		if err != nil {
+			certPool = x509.NewCertPool()
+		}
+		if ok := certPool.AppendCertsFromPEM([]byte(config.OIDCTLSCACert)); !ok {
+			return nil, errors.New("could not parse oidc_tls_ca_cert successfully")
+		}
 		tr.TLSClientConfig = &tls.Config{
 			RootCAs: certPool,
 		}
 	}
-	tc := &http.Client{
+
+	return &http.Client{
 		Transport: tr,
+		Timeout:   config.httpTimeout(),
+	}, nil
+}
+
+type jwtConfig struct {
+	OIDCDiscoveryURL                string                 `json:"oidc_discovery_url"`
+	OIDCDiscoveryCAPEM              string                 `json:"oidc_discovery_ca_pem"`
+	OIDCClientID                    string                 `json:"oidc_client_id"`
+	OIDCClientSecret                string                 `json:"oidc_client_secret"`
+	JWKSURL                         string                 `json:"jwks_url"`
+	JWKSCAPEM                       string                 `json:"jwks_ca_pem"`
+	JWTValidationPubKeys            []string               `json:"jwt_validation_pubkeys"`
+	JWTSupportedAlgs                []string               `json:"jwt_supported_algs"`
+	JWTClockSkewLeeway              time.Duration          `json:"jwt_clock_skew_leeway"`
+	BoundIssuer                     string                 `json:"bound_issuer"`
+	DefaultRole                     string                 `json:"default_role"`
+	AudienceRoleMapping             map[string]string      `json:"audience_role_mapping"`
+	OIDCUseStateCookie              bool                   `json:"oidc_use_state_cookie"`
+	OIDCProviderHealthCheckInterval time.Duration          `json:"oidc_provider_health_check_interval"`
+	ProviderConfig                  map[string]interface{} `json:"provider_config"`
+	OIDCFederationIssuer            string                 `json:"oidc_federation_issuer"`
+	OIDCFederationAudience          string                 `json:"oidc_federation_audience"`
+	OIDCSignAuthURL                 bool                   `json:"oidc_sign_auth_url"`
+	OIDCPostLogoutRedirectURI       string                 `json:"oidc_post_logout_redirect_uri"`
+	OIDCRegistrationAccessToken     string                 `json:"oidc_registration_access_token"`
+	OIDCClaimsEnrichmentPlugin      string                 `json:"oidc_claims_enrichment_plugin"`
+	OIDCBindIPToState               bool                   `json:"oidc_bind_ip_to_state"`
+	OIDCGeoIPDatabase               string                 `json:"oidc_geoip_database"`
+	OIDCInlineDataMaxBytes          int                    `json:"oidc_inline_data_max_bytes"`
+	OIDCErrorMapping                map[string]string      `json:"oidc_error_mapping"`
+	OIDCCircuitBreakerThreshold     int                    `json:"oidc_circuit_breaker_threshold"`
+	OIDCCircuitBreakerWindow        time.Duration          `json:"oidc_circuit_breaker_window"`
+	OIDCCircuitBreakerCooldown      time.Duration          `json:"oidc_circuit_breaker_cooldown"`
+	OIDCDistributedStateBackend     string                 `json:"oidc_distributed_state_backend"`
+	DeviceCodeTTL                   time.Duration          `json:"oidc_device_code_ttl"`
+	OIDCPoWDifficulty               int                    `json:"oidc_pow_difficulty"`
+	OIDCDiscoveryCacheDuration      time.Duration          `json:"oidc_discovery_cache_duration"`
+	OIDCDiscoveryMaxStaleness       time.Duration          `json:"oidc_discovery_max_staleness"`
+	NonceTTL                        time.Duration          `json:"nonce_ttl"`
+	OIDCStateParamBits              int                    `json:"oidc_state_param_bits"`
+	BatchLoginMaxSize               int                    `json:"batch_login_max_size"`
+	OIDCHTTPTimeout                 time.Duration          `json:"oidc_http_timeout"`
+	OIDCAdaptiveTimeout             bool                   `json:"oidc_adaptive_timeout"`
+	OIDCHTTPProxy                   string                 `json:"oidc_http_proxy"`
+	OIDCTLSCACert                   string                 `json:"oidc_tls_ca_cert"`
+	OIDCCertExpiryWarnDays          int                    `json:"oidc_cert_expiry_warn_days"`
+	OIDCRequestParameterObject      bool                   `json:"oidc_request_parameter_object"`
+	OIDCRequestObjectSigningKey     string                 `json:"oidc_request_object_signing_key"`
+	OIDCJAREncryptionKeyPEM         string                 `json:"oidc_jar_encryption_key_pem"`
+	OIDCAutoRoleTemplate            map[string]interface{} `json:"oidc_auto_role_template"`
+
+	ParsedJWTPubKeys []interface{}     `json:"-"`
+	provider         CustomProvider    `json:"-"`
+	enricher         ClaimsEnricher    `json:"-"`
+	autoRoleTemplate *autoRoleTemplate `json:"-"`
+	adaptiveTimeout  *adaptiveTimeout  `json:"-"`
+}
+
+// circuitBreakerWindow returns the period in which provider failures are
+// counted, applying the default when unset.
+func (c *jwtConfig) circuitBreakerWindow() time.Duration {
+	if c.OIDCCircuitBreakerWindow == 0 {
+		return defaultCircuitBreakerWindow
 	}
+	return c.OIDCCircuitBreakerWindow
+}
 
-	caCtx := context.WithValue(ctx, oauth2.HTTPClient, tc)
+// circuitBreakerCooldown returns the time the circuit breaker stays open,
+// applying the default when unset.
+func (c *jwtConfig) circuitBreakerCooldown() time.Duration {
+	if c.OIDCCircuitBreakerCooldown == 0 {
+		return defaultCircuitBreakerCooldown
+	}
+	return c.OIDCCircuitBreakerCooldown
+}
 
-	return caCtx, nil
+// deviceCodeTTL returns the maximum lifetime of pending device codes,
+// applying the default when unset.
+func (c *jwtConfig) deviceCodeTTL() time.Duration {
+	if c.DeviceCodeTTL == 0 {
+		return defaultDeviceCodeTTL
+	}
+	return c.DeviceCodeTTL
 }
 
-type jwtConfig struct {
-	OIDCDiscoveryURL     string   `json:"oidc_discovery_url"`
-	OIDCDiscoveryCAPEM   string   `json:"oidc_discovery_ca_pem"`
-	OIDCClientID         string   `json:"oidc_client_id"`
-	OIDCClientSecret     string   `json:"oidc_client_secret"`
-	JWKSURL              string   `json:"jwks_url"`
-	JWKSCAPEM            string   `json:"jwks_ca_pem"`
-	JWTValidationPubKeys []string `json:"jwt_validation_pubkeys"`
-	JWTSupportedAlgs     []string `json:"jwt_supported_algs"`
-	BoundIssuer          string   `json:"bound_issuer"`
-	DefaultRole          string   `json:"default_role"`
-
-	ParsedJWTPubKeys []interface{} `json:"-"`
+// httpTimeout returns the timeout of outbound requests, applying the
+// default when unset.
+func (c *jwtConfig) httpTimeout() time.Duration {
+	if c.OIDCHTTPTimeout == 0 {
+		return defaultHTTPTimeout
+	}
+	return c.OIDCHTTPTimeout
+}
+
+// nonceTTL returns how long OIDC login states and their nonces are valid,
+// applying the default when unset.
+func (c *jwtConfig) nonceTTL() time.Duration {
+	if c.NonceTTL == 0 {
+		return oidcStateTimeout
+	}
+	return c.NonceTTL
+}
+
+// stateParamBits returns the number of random bits of OIDC state
+// parameters, applying the default when unset.
+func (c *jwtConfig) stateParamBits() int {
+	if c.OIDCStateParamBits == 0 {
+		return defaultStateParamBits
+	}
+	return c.OIDCStateParamBits
+}
+
+// batchLoginMaxSize returns the maximum number of tokens of a batch-login
+// request, applying the default when unset.
+func (c *jwtConfig) batchLoginMaxSize() int {
+	if c.BatchLoginMaxSize == 0 {
+		return defaultBatchLoginMaxSize
+	}
+	return c.BatchLoginMaxSize
+}
+
+// certExpiryWarnDays returns the number of days before the expiry of the
+// provider's TLS certificate from which a warning is logged, applying the
+// default when unset.
+func (c *jwtConfig) certExpiryWarnDays() int {
+	if c.OIDCCertExpiryWarnDays == 0 {
+		return defaultCertExpiryWarnDays
+	}
+	return c.OIDCCertExpiryWarnDays
+}
+
+// clockSkewLeeway returns the leeway for validating the time based claims of
+// tokens for role: the role's clock_skew_leeway plus jwt_clock_skew_leeway.
+func (c *jwtConfig) clockSkewLeeway(role *jwtRole) time.Duration {
+	return role.clockSkewLeeway() + c.JWTClockSkewLeeway
+}
+
+// boundIssuer returns the issuer that JWTs validated locally must match.
+func (c *jwtConfig) boundIssuer() string {
+	if c.OIDCFederationIssuer != "" {
+		return c.OIDCFederationIssuer
+	}
+	return c.BoundIssuer
+}
+
+// boundAudiences returns the role's bound audiences, falling back to the
+// federation audience if the role has none.
+func (c *jwtConfig) boundAudiences(role *jwtRole) []string {
+	if len(role.BoundAudiences) == 0 && c.OIDCFederationAudience != "" {
+		if role.BoundAudiencesType == boundClaimsTypeRegexp {
+			return []string{"^" + regexp.QuoteMeta(c.OIDCFederationAudience) + "$"}
+		}
+		return []string{c.OIDCFederationAudience}
+	}
+	return role.BoundAudiences
 }
 
 const (
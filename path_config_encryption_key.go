@@ -0,0 +1,237 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/certutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// encryptionKeyPath is the storage path of the generated key used to decrypt
+// JWE tokens for roles without a jwt_encryption_key.
+const encryptionKeyPath = "config/encryption-key"
+
+// encryptionKeyBits is the size of generated encryption keys.
+const encryptionKeyBits = 2048
+
+// encryptionKeys holds the generated encryption key and the one it replaced,
+// which is kept so that tokens encrypted before a rotation can still be
+// decrypted.
+type encryptionKeys struct {
+	Current  string `json:"current"`
+	Previous string `json:"previous"`
+}
+
+func pathConfigEncryptionKey(b *jwtAuthBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: `config/encryption-key/rotate`,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathEncryptionKeyRotate,
+					Summary:  "Generate a new key for decrypting JWE tokens.",
+				},
+			},
+
+			HelpSynopsis:    encryptionKeyRotateHelpSyn,
+			HelpDescription: encryptionKeyRotateHelpDesc,
+		},
+		{
+			Pattern: `config/encryption-key/public`,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathEncryptionKeyPublic,
+					Summary:  "Read the public key that JWE tokens are encrypted to.",
+				},
+			},
+
+			HelpSynopsis:    encryptionKeyPublicHelpSyn,
+			HelpDescription: encryptionKeyPublicHelpDesc,
+		},
+	}
+}
+
+func (b *jwtAuthBackend) pathEncryptionKeyRotate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	keys, err := readEncryptionKeys(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		keys = new(encryptionKeys)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, encryptionKeyBits)
+	if err != nil {
+		return nil, err
+	}
+
+	keys.Previous = keys.Current
+	keys.Current = string(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}))
+
+	entry, err := logical.StorageEntryJSON(encryptionKeyPath, keys)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return publicKeyResponse(key.Public())
+}
+
+func (b *jwtAuthBackend) pathEncryptionKeyPublic(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	keys, err := readEncryptionKeys(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		return logical.ErrorResponse("no encryption key has been generated; use config/encryption-key/rotate to generate one"), nil
+	}
+
+	key, err := parseEncryptionKey(keys.Current)
+	if err != nil {
+		return nil, err
+	}
+
+	return publicKeyResponse(key.Public())
+}
+
+// publicKeyResponse returns pub as PEM and as a JWK identified by its
+// thumbprint.
+func publicKeyResponse(pub crypto.PublicKey) (*logical.Response, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	jwk := jose.JSONWebKey{Key: pub, Use: "enc", Algorithm: string(jose.RSA_OAEP_256)}
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	jwk.KeyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"public_key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			"jwk":        jwk,
+		},
+	}, nil
+}
+
+func readEncryptionKeys(ctx context.Context, s logical.Storage) (*encryptionKeys, error) {
+	entry, err := s.Get(ctx, encryptionKeyPath)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	keys := new(encryptionKeys)
+	if err := entry.DecodeJSON(keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// parseEncryptionKey parses a PEM-encoded RSA or EC private key.
+func parseEncryptionKey(keyPEM string) (crypto.Signer, error) {
+	bundle, err := certutil.ParsePEMBundle(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	switch bundle.PrivateKey.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		return bundle.PrivateKey, nil
+	case nil:
+		return nil, errors.New("no private key found")
+	default:
+		return nil, errors.New("private key must be an RSA or EC key")
+	}
+}
+
+// isEncryptedToken reports whether token is in JWE compact serialization,
+// which has five parts where a JWS has three.
+func isEncryptedToken(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// decryptToken decrypts a JWE token and returns the signed JWT it holds. The
+// role's jwt_encryption_key is used if set, otherwise the generated keys.
+func (b *jwtAuthBackend) decryptToken(ctx context.Context, s logical.Storage, role *jwtRole, token string) (string, error) {
+	encrypted, err := jose.ParseEncrypted(token)
+	if err != nil {
+		return "", errwrap.Wrapf("error parsing encrypted token: {{err}}", err)
+	}
+
+	var keyPEMs []string
+	if role.EncryptionKey != "" {
+		keyPEMs = []string{role.EncryptionKey}
+	} else {
+		keys, err := readEncryptionKeys(ctx, s)
+		if err != nil {
+			return "", err
+		}
+		if keys != nil {
+			keyPEMs = []string{keys.Current, keys.Previous}
+		}
+	}
+
+	for _, keyPEM := range keyPEMs {
+		if keyPEM == "" {
+			continue
+		}
+		key, err := parseEncryptionKey(keyPEM)
+		if err != nil {
+			return "", err
+		}
+		if payload, err := encrypted.Decrypt(key); err == nil {
+			return string(payload), nil
+		}
+	}
+
+	if len(keyPEMs) == 0 {
+		return "", errors.New("token is encrypted but no encryption key is configured")
+	}
+
+	return "", errors.New("no configured encryption key could decrypt the token")
+}
+
+const (
+	encryptionKeyRotateHelpSyn = `
+Generates a new key for decrypting JWE tokens.
+`
+	encryptionKeyRotateHelpDesc = `
+Tokens submitted to roles without a jwt_encryption_key may be JWE tokens
+encrypted to the public key of a key generated by this endpoint. Each call
+generates a new RSA key and returns its public key. The key it replaces is
+kept for decryption until the next rotation, to allow identity providers time
+to switch to the new key.
+`
+	encryptionKeyPublicHelpSyn = `
+Returns the public key that JWE tokens are encrypted to.
+`
+	encryptionKeyPublicHelpDesc = `
+Returns the public key of the key most recently generated by
+config/encryption-key/rotate, as PEM and as a JWK, for configuring identity
+providers that issue encrypted tokens.
+`
+)
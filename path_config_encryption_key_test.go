@@ -0,0 +1,195 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/certutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func encryptTestJWT(t *testing.T, pub crypto.PublicKey, signed string) string {
+	t.Helper()
+
+	enc, err := jose.NewEncrypter(jose.A256GCM, jose.Recipient{Algorithm: jose.RSA_OAEP_256, Key: pub},
+		(&jose.EncrypterOptions{}).WithContentType("JWT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := enc.Encrypt([]byte(signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return raw
+}
+
+func newTestRSAKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, string(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}))
+}
+
+func TestLogin_EncryptedToken_RoleKey(t *testing.T) {
+	key, keyPEM := newTestRSAKey(t)
+	other, _ := newTestRSAKey(t)
+
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		roleData: map[string]interface{}{
+			"jwt_encryption_key": keyPEM,
+		},
+	})
+	req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+	signed := req.Data["jwt"].(string)
+
+	login := func(token string) *logical.Response {
+		req.Data["jwt"] = token
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := login(encryptTestJWT(t, key.Public(), signed)); resp == nil || resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+
+	// Signed tokens are still accepted.
+	if resp := login(signed); resp == nil || resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+
+	// Key mismatch
+	resp := login(encryptTestJWT(t, other.Public(), signed))
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "could decrypt the token") {
+		t.Fatalf("expected decryption error, got: %v", resp)
+	}
+
+	// Malformed JWE
+	resp = login("a.b.c.d.e")
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "error decrypting token") {
+		t.Fatalf("expected decryption error, got: %v", resp)
+	}
+}
+
+func TestLogin_EncryptedToken_GeneratedKey(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+	})
+	req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+	signed := req.Data["jwt"].(string)
+
+	endpoint := func(op logical.Operation, path string) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	publicKey := func(resp *logical.Response) crypto.PublicKey {
+		if resp == nil || resp.IsError() {
+			t.Fatalf("unexpected response: %v", resp)
+		}
+		pub, err := certutil.ParsePublicKeyPEM([]byte(resp.Data["public_key"].(string)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if jwk := resp.Data["jwk"].(jose.JSONWebKey); jwk.KeyID == "" || !jwk.Valid() {
+			t.Fatalf("unexpected jwk: %v", jwk)
+		}
+		return pub
+	}
+	login := func(token string) *logical.Response {
+		req.Data["jwt"] = token
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// No key has been generated yet.
+	if resp := endpoint(logical.ReadOperation, "config/encryption-key/public"); !resp.IsError() {
+		t.Fatalf("expected error, got: %v", resp)
+	}
+	_, unknown := newTestRSAKey(t)
+	unknownKey, err := parseEncryptionKey(unknown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := login(encryptTestJWT(t, unknownKey.Public(), signed)); !resp.IsError() {
+		t.Fatalf("expected error, got: %v", resp)
+	}
+
+	first := publicKey(endpoint(logical.UpdateOperation, "config/encryption-key/rotate"))
+	if got := publicKey(endpoint(logical.ReadOperation, "config/encryption-key/public")); !got.(*rsa.PublicKey).Equal(first) {
+		t.Fatal("public key doesn't match the rotated key")
+	}
+	if resp := login(encryptTestJWT(t, first, signed)); resp == nil || resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+
+	// Tokens encrypted to the previous key are accepted until the next
+	// rotation.
+	second := publicKey(endpoint(logical.UpdateOperation, "config/encryption-key/rotate"))
+	for _, pub := range []crypto.PublicKey{first, second} {
+		if resp := login(encryptTestJWT(t, pub, signed)); resp == nil || resp.IsError() {
+			t.Fatalf("expected successful login, got: %v", resp)
+		}
+	}
+
+	publicKey(endpoint(logical.UpdateOperation, "config/encryption-key/rotate"))
+	if resp := login(encryptTestJWT(t, first, signed)); !resp.IsError() {
+		t.Fatalf("expected error for retired key, got: %v", resp)
+	}
+}
+
+func TestRole_InvalidEncryptionKey(t *testing.T) {
+	b, storage := getBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_type":          "jwt",
+			"user_claim":         "user",
+			"bound_subject":      "testsub",
+			"jwt_encryption_key": "not a key",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for invalid key, got: %v", resp)
+	}
+}
@@ -2,9 +2,13 @@ package jwtauth
 
 import (
 	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-test/deep"
 	"github.com/hashicorp/vault/sdk/helper/certutil"
@@ -15,15 +19,48 @@ func TestConfig_JWT_Read(t *testing.T) {
 	b, storage := getBackend(t)
 
 	data := map[string]interface{}{
-		"oidc_discovery_url":     "",
-		"oidc_discovery_ca_pem":  "",
-		"oidc_client_id":         "",
-		"default_role":           "",
-		"jwt_validation_pubkeys": []string{testJWTPubKey},
-		"jwt_supported_algs":     []string{},
-		"jwks_url":               "",
-		"jwks_ca_pem":            "",
-		"bound_issuer":           "http://vault.example.com/",
+		"oidc_discovery_url":                  "",
+		"oidc_discovery_ca_pem":               "",
+		"oidc_client_id":                      "",
+		"default_role":                        "",
+		"audience_role_mapping":               map[string]string{},
+		"jwt_validation_pubkeys":              []string{testJWTPubKey},
+		"jwt_supported_algs":                  []string{},
+		"jwks_url":                            "",
+		"jwks_ca_pem":                         "",
+		"bound_issuer":                        "http://vault.example.com/",
+		"oidc_use_state_cookie":               false,
+		"oidc_provider_health_check_interval": int64(0),
+		"provider_config":                     map[string]interface{}{},
+		"oidc_federation_issuer":              "",
+		"oidc_federation_audience":            "",
+		"oidc_sign_auth_url":                  false,
+		"oidc_post_logout_redirect_uri":       "",
+		"oidc_claims_enrichment_plugin":       "",
+		"oidc_bind_ip_to_state":               false,
+		"oidc_geoip_database":                 "",
+		"oidc_inline_data_max_bytes":          0,
+		"oidc_error_mapping":                  map[string]string{},
+		"oidc_circuit_breaker_threshold":      0,
+		"oidc_circuit_breaker_window":         int64(0),
+		"oidc_device_code_ttl":                int64(0),
+		"oidc_pow_difficulty":                 0,
+		"oidc_discovery_cache_duration":       int64(0),
+		"oidc_discovery_max_staleness":        int64(0),
+		"nonce_ttl":                           int64(0),
+		"oidc_state_param_bits":               0,
+		"batch_login_max_size":                0,
+		"oidc_http_timeout":                   int64(0),
+		"oidc_adaptive_timeout":               false,
+		"oidc_http_proxy":                     "",
+		"oidc_tls_ca_cert":                    "",
+		"oidc_circuit_breaker_cooldown":       int64(0),
+		"oidc_distributed_state_backend":      "",
+		"oidc_cert_expiry_warn_days":          0,
+		"jwt_clock_skew_leeway":               int64(0),
+		"oidc_request_parameter_object":       false,
+		"oidc_jar_encryption_key_pem":         "",
+		"oidc_auto_role_template":             map[string]interface{}(nil),
 	}
 
 	req := &logical.Request{
@@ -130,6 +167,10 @@ func TestConfig_JWT_Write(t *testing.T) {
 		JWTValidationPubKeys: []string{testJWTPubKey},
 		JWTSupportedAlgs:     []string{},
 		BoundIssuer:          "http://vault.example.com/",
+		ProviderConfig:       map[string]interface{}{},
+		OIDCAutoRoleTemplate: map[string]interface{}{},
+		OIDCErrorMapping:     map[string]string{},
+		AudienceRoleMapping:  map[string]string{},
 	}
 
 	conf, err := b.(*jwtAuthBackend).config(context.Background(), storage)
@@ -154,15 +195,48 @@ func TestConfig_JWKS_Update(t *testing.T) {
 	}
 
 	data := map[string]interface{}{
-		"jwks_url":               s.server.URL + "/certs",
-		"jwks_ca_pem":            cert,
-		"oidc_discovery_url":     "",
-		"oidc_discovery_ca_pem":  "",
-		"oidc_client_id":         "",
-		"default_role":           "",
-		"jwt_validation_pubkeys": []string{},
-		"jwt_supported_algs":     []string{},
-		"bound_issuer":           "",
+		"jwks_url":                            s.server.URL + "/certs",
+		"jwks_ca_pem":                         cert,
+		"oidc_discovery_url":                  "",
+		"oidc_discovery_ca_pem":               "",
+		"oidc_client_id":                      "",
+		"default_role":                        "",
+		"audience_role_mapping":               map[string]string{},
+		"jwt_validation_pubkeys":              []string{},
+		"jwt_supported_algs":                  []string{},
+		"bound_issuer":                        "",
+		"oidc_use_state_cookie":               false,
+		"oidc_provider_health_check_interval": int64(0),
+		"provider_config":                     map[string]interface{}{},
+		"oidc_federation_issuer":              "",
+		"oidc_federation_audience":            "",
+		"oidc_sign_auth_url":                  false,
+		"oidc_post_logout_redirect_uri":       "",
+		"oidc_claims_enrichment_plugin":       "",
+		"oidc_bind_ip_to_state":               false,
+		"oidc_geoip_database":                 "",
+		"oidc_inline_data_max_bytes":          0,
+		"oidc_error_mapping":                  map[string]string{},
+		"oidc_circuit_breaker_threshold":      0,
+		"oidc_circuit_breaker_window":         int64(0),
+		"oidc_device_code_ttl":                int64(0),
+		"oidc_pow_difficulty":                 0,
+		"oidc_discovery_cache_duration":       int64(0),
+		"oidc_discovery_max_staleness":        int64(0),
+		"nonce_ttl":                           int64(0),
+		"oidc_state_param_bits":               0,
+		"batch_login_max_size":                0,
+		"oidc_http_timeout":                   int64(0),
+		"oidc_adaptive_timeout":               false,
+		"oidc_http_proxy":                     "",
+		"oidc_tls_ca_cert":                    "",
+		"oidc_circuit_breaker_cooldown":       int64(0),
+		"oidc_distributed_state_backend":      "",
+		"oidc_cert_expiry_warn_days":          0,
+		"jwt_clock_skew_leeway":               int64(0),
+		"oidc_request_parameter_object":       false,
+		"oidc_jar_encryption_key_pem":         "",
+		"oidc_auto_role_template":             map[string]interface{}(nil),
 	}
 
 	req := &logical.Request{
@@ -291,6 +365,10 @@ func TestConfig_OIDC_Write(t *testing.T) {
 		JWTValidationPubKeys: []string{},
 		JWTSupportedAlgs:     []string{},
 		OIDCDiscoveryURL:     "https://team-vault.auth0.com/",
+		ProviderConfig:       map[string]interface{}{},
+		OIDCAutoRoleTemplate: map[string]interface{}{},
+		OIDCErrorMapping:     map[string]string{},
+		AudienceRoleMapping:  map[string]string{},
 	}
 
 	conf, err := b.(*jwtAuthBackend).config(context.Background(), storage)
@@ -378,3 +456,165 @@ ff4hAtoAmN0/x6yFclFFWX8s7bRGqmnoj39/r98kzeGFb/lPKgQjSVcBJuE7UO4k
 sj9DpQ==
 -----END CERTIFICATE-----`
 )
+
+func TestConfig_ProviderConfig(t *testing.T) {
+	b, storage := getBackend(t)
+
+	data := map[string]interface{}{
+		"jwt_validation_pubkeys": []string{testJWTPubKey},
+		"provider_config": map[string]interface{}{
+			"provider": "unknown",
+		},
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data:      data,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), `provider "unknown" not found`) {
+		t.Fatalf("expected unknown provider error, got: %v", resp)
+	}
+
+	data["provider_config"] = map[string]interface{}{
+		"provider":        "azure",
+		"azure_tenant_id": "tenant1",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	conf, err := b.(*jwtAuthBackend).config(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := conf.provider.(*AzureProvider)
+	if !ok || p.tenantID != "tenant1" {
+		t.Fatalf("unexpected provider: %#v", conf.provider)
+	}
+}
+
+func TestConfig_JWTClockSkewLeeway(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for leeway, valid := range map[string]bool{
+		"0s":  true,
+		"60s": true,
+		"61s": false,
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      configPath,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"jwt_validation_pubkeys": testJWTPubKey,
+				"jwt_clock_skew_leeway":  leeway,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid && resp != nil && resp.IsError() {
+			t.Fatalf("%s: unexpected error: %v", leeway, resp.Error())
+		}
+		if !valid && (resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "jwt_clock_skew_leeway")) {
+			t.Fatalf("%s: expected error, got %v", leeway, resp)
+		}
+	}
+}
+
+func TestBuildHTTPClient_Proxy(t *testing.T) {
+	proxied := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r.URL.String()
+		w.Write([]byte("ok"))
+	}))
+	defer proxy.Close()
+
+	client, err := buildHTTPClient(&jwtConfig{OIDCHTTPProxy: proxy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Get("http://provider.example.com/.well-known/openid-configuration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if u := <-proxied; u != "http://provider.example.com/.well-known/openid-configuration" {
+		t.Fatalf("unexpected proxied request %q", u)
+	}
+
+	if _, err := buildHTTPClient(&jwtConfig{OIDCHTTPProxy: "proxy:3128"}); err == nil {
+		t.Fatal("expected error for a relative proxy URL")
+	}
+}
+
+func TestBuildHTTPClient_Timeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	client, err := buildHTTPClient(&jwtConfig{OIDCHTTPTimeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := client.Get(server.URL); err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("request took %s, expected it to time out after 200ms", elapsed)
+	}
+
+	client, err = buildHTTPClient(&jwtConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Timeout != defaultHTTPTimeout {
+		t.Fatalf("expected default timeout %s, got %s", defaultHTTPTimeout, client.Timeout)
+	}
+}
+
+func TestBuildHTTPClient_TLSCACert(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	client, err := buildHTTPClient(&jwtConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Fatal("expected error for an untrusted certificate")
+	}
+
+	client, err = buildHTTPClient(&jwtConfig{OIDCTLSCACert: caPEM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if _, err := buildHTTPClient(&jwtConfig{OIDCTLSCACert: "not a certificate"}); err == nil {
+		t.Fatal("expected error for an invalid oidc_tls_ca_cert")
+	}
+}
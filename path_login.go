@@ -5,13 +5,18 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"time"
 
 	"github.com/coreos/go-oidc"
 	"github.com/hashicorp/errwrap"
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-sockaddr"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/cidrutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
 	"gopkg.in/square/go-jose.v2/jwt"
 )
 
@@ -44,7 +49,7 @@ func pathLogin(b *jwtAuthBackend) *framework.Path {
 	}
 }
 
-func (b *jwtAuthBackend) pathLogin(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+func (b *jwtAuthBackend) pathLogin(ctx context.Context, req *logical.Request, d *framework.FieldData) (resp *logical.Response, retErr error) {
 	config, err := b.config(ctx, req.Storage)
 	if err != nil {
 		return nil, err
@@ -53,38 +58,197 @@ func (b *jwtAuthBackend) pathLogin(ctx context.Context, req *logical.Request, d
 		return logical.ErrorResponse("could not load configuration"), nil
 	}
 
-	roleName := d.Get("role").(string)
+	token := d.Get("jwt").(string)
+
+	roleName, role, resp, err := b.loginRole(ctx, req.Storage, config, d.Get("role").(string), token)
+	if err != nil || resp != nil {
+		return resp, err
+	}
+
+	defer b.validationMetrics.observe(roleName, time.Now())
+	if req.Operation == logical.UpdateOperation {
+		defer func() {
+			b.authMetrics.recordResult(metricJWTAuthSuccess, metricJWTAuthFailure, roleName, resp, retErr)
+		}()
+	}
+
+	if len(token) == 0 {
+		return logical.ErrorResponse("missing token"), nil
+	}
+
+	if isEncryptedToken(token) {
+		token, err = b.decryptToken(ctx, req.Storage, role, token)
+		if err != nil {
+			return logical.ErrorResponse(errwrap.Wrapf("error decrypting token: {{err}}", err).Error()), nil
+		}
+	}
+
+	// Tokens rejected recently are rejected again without being validated,
+	// so that repeatedly submitting an invalid token doesn't reach the
+	// provider. With oidc_cache_key_fields, rejections of tokens with a
+	// valid signature are also cached by the listed claims, so that they
+	// apply to tokens that only differ in other claims such as jti. Only
+	// definitive rejections are cached, not failures to reach the provider
+	// or its keys.
+	var verification tokenVerification
+	if role.NegativeCacheTTL > 0 {
+		cacheKey := negativeCacheKey(roleName, token)
+		claimsKey, hasClaimsKey := claimsCacheKey(roleName, role.CacheKeyFields, unverifiedClaims(token))
+		for _, key := range []string{cacheKey, claimsKey} {
+			if cached, ok := b.negativeCache.Get(key); ok && key != "" {
+				return logical.ErrorResponse(cached.(string)), nil
+			}
+		}
+		defer func() {
+			if resp != nil && resp.IsError() && verification.rejection != rejectionTransient {
+				key := cacheKey
+				if verification.signatureVerified && hasClaimsKey {
+					key = claimsKey
+				}
+				b.negativeCache.Set(key, resp.Error().Error(), role.NegativeCacheTTL)
+			}
+		}()
+	}
+
+	allClaims, verification, resp, err := b.verifyLoginToken(ctx, req, config, role, roleName, token)
+	if err != nil || resp != nil {
+		return resp, err
+	}
+
+	// Vault sends an alias lookahead before each login. It only needs the
+	// entity alias, so the side effects of a login are left to the login
+	// itself.
+	if req.Operation == logical.UpdateOperation {
+		if err := b.checkTokenIP(ctx, req, role, token, allClaims); err != nil {
+			if err == errTokenIPMismatch {
+				return logical.ErrorResponse("error validating token: %s", err.Error()), nil
+			}
+			return nil, err
+		}
+	}
+
+	allClaims, resp, verification.rejection = b.validateLoginClaims(ctx, config, role, allClaims)
+	if resp != nil {
+		return resp, nil
+	}
+
+	if req.Operation == logical.AliasLookaheadOperation {
+		alias, err := b.createAlias(config, allClaims, role)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		return &logical.Response{
+			Auth: &logical.Auth{
+				Alias: alias,
+			},
+		}, nil
+	}
+
+	resp, err = b.validatedLoginResponse(ctx, req, config, role, roleName, allClaims, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.recordLogin(ctx, req.Storage, roleName, role, resp)
+}
+
+// recordLogin stores the role of a successful login if it is ephemeral, and
+// counts the issued token.
+func (b *jwtAuthBackend) recordLogin(ctx context.Context, s logical.Storage, roleName string, role *jwtRole, resp *logical.Response) (*logical.Response, error) {
+	if resp == nil || resp.Auth == nil {
+		return resp, nil
+	}
+
+	if role.Ephemeral {
+		if err := b.storeEphemeralRole(ctx, s, roleName, role); err != nil {
+			if err == errEphemeralRoleName || err == errEphemeralRoleLimit {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+			return nil, err
+		}
+	}
+	b.tokenStats.record(roleName, time.Now())
+
+	return resp, nil
+}
+
+// loginRole returns the role to log in to with token: roleName, the role
+// matching the token's audience, or the default role. A role generated from
+// oidc_auto_role_template is returned if the role doesn't exist.
+func (b *jwtAuthBackend) loginRole(ctx context.Context, s logical.Storage, config *jwtConfig, roleName, token string) (string, *jwtRole, *logical.Response, error) {
+	if roleName == "" {
+		roleName = audienceRole(config, token)
+	}
 	if roleName == "" {
 		roleName = config.DefaultRole
 	}
 	if roleName == "" {
-		return logical.ErrorResponse("missing role"), nil
+		return "", nil, logical.ErrorResponse("missing role"), nil
 	}
 
-	role, err := b.role(ctx, req.Storage, roleName)
+	roleName, err := b.canonicalRoleName(ctx, s, roleName)
 	if err != nil {
-		return nil, err
+		return "", nil, nil, err
+	}
+
+	role, err := b.role(ctx, s, roleName)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if role == nil && config.autoRoleTemplate != nil {
+		role = config.autoRoleTemplate.role()
 	}
 	if role == nil {
-		return logical.ErrorResponse("role %q could not be found", roleName), nil
+		return "", nil, logical.ErrorResponse("role %q could not be found", roleName), nil
 	}
 
 	if role.RoleType == "oidc" {
-		return logical.ErrorResponse("role with oidc role_type is not allowed"), nil
+		return "", nil, logical.ErrorResponse("role with oidc role_type is not allowed"), nil
 	}
 
-	token := d.Get("jwt").(string)
-	if len(token) == 0 {
-		return logical.ErrorResponse("missing token"), nil
+	return roleName, role, nil, nil
+}
+
+// validateLoginToken validates token against the role like a login, without
+// issuing a token or any of the side effects of a login, and returns its
+// validated claims.
+func (b *jwtAuthBackend) validateLoginToken(ctx context.Context, req *logical.Request, config *jwtConfig, role *jwtRole, roleName, token string) (map[string]interface{}, *logical.Response, error) {
+	if isEncryptedToken(token) {
+		var err error
+		token, err = b.decryptToken(ctx, req.Storage, role, token)
+		if err != nil {
+			return nil, logical.ErrorResponse(errwrap.Wrapf("error decrypting token: {{err}}", err).Error()), nil
+		}
 	}
 
+	allClaims, _, resp, err := b.verifyLoginToken(ctx, req, config, role, roleName, token)
+	if err != nil || resp != nil {
+		return nil, resp, err
+	}
+
+	allClaims, resp, _ = b.validateLoginClaims(ctx, config, role, allClaims)
+	if resp != nil {
+		return nil, resp, nil
+	}
+
+	return allClaims, nil, nil
+}
+
+// verifyLoginToken verifies the signature and the registered claims of
+// token for a login to the role, and returns all of its claims. The returned
+// tokenVerification reports whether the signature was verified, also on
+// failure, and whether the token was rejected for good.
+func (b *jwtAuthBackend) verifyLoginToken(ctx context.Context, req *logical.Request, config *jwtConfig, role *jwtRole, roleName, token string) (map[string]interface{}, tokenVerification, *logical.Response, error) {
+	var v tokenVerification
+	var err error
+
 	if len(role.TokenBoundCIDRs) > 0 {
 		if req.Connection == nil {
 			b.Logger().Warn("token bound CIDRs found but no connection information available for validation")
-			return nil, logical.ErrPermissionDenied
+			return nil, tokenVerification{}, nil, logical.ErrPermissionDenied
 		}
 		if !cidrutil.RemoteAddrIsOk(req.Connection.RemoteAddr, role.TokenBoundCIDRs) {
-			return nil, logical.ErrPermissionDenied
+			return nil, tokenVerification{}, nil, logical.ErrPermissionDenied
 		}
 	}
 
@@ -95,32 +259,87 @@ func (b *jwtAuthBackend) pathLogin(ctx context.Context, req *logical.Request, d
 	configType := config.authType()
 
 	switch {
-	case configType == StaticKeys || configType == JWKS:
+	case role.CognitoMode:
+		allClaims, err = b.verifyCognitoToken(ctx, config, role, token)
+		if err != nil {
+			return nil, v.rejected(rejectionOf(err)), logical.ErrorResponse(err.Error()), nil
+		}
+		v.signatureVerified = true
+
+	case role.AcceptAccessTokens:
+		allClaims, err = b.userInfoClaims(ctx, config, role, token)
+		if err != nil {
+			return nil, v, logical.ErrorResponse(errwrap.Wrapf("error validating token: {{err}}", err).Error()), nil
+		}
+
+	case role.RoleType == "introspection":
+		allClaims, err = b.introspectToken(ctx, config, role, token)
+		if err == errTokenInactive {
+			return nil, tokenVerification{}, nil, logical.ErrPermissionDenied
+		}
+		if err != nil {
+			return nil, v.rejected(rejectionOf(err)), logical.ErrorResponse(errwrap.Wrapf("error validating token: {{err}}", err).Error()), nil
+		}
+
+	case configType == StaticKeys || configType == JWKS || len(role.ValidationKeys) > 0:
+		if err := validateSigningAlg(config.JWTSupportedAlgs, token); err != nil {
+			return nil, v.rejected(rejectionSignature), logical.ErrorResponse(errwrap.Wrapf("error verifying token: {{err}}", err).Error()), nil
+		}
+
 		claims := jwt.Claims{}
-		if configType == JWKS {
-			keySet, err := b.getKeySet(config)
-			if err != nil {
-				return logical.ErrorResponse(errwrap.Wrapf("error fetching jwks keyset: {{err}}", err).Error()), nil
+		if len(role.ValidationKeys) > 0 {
+			// A role with validation keys only trusts its own keys.
+			if err := verifyWithRoleValidationKeys(role, token, &claims, &allClaims); err != nil {
+				return nil, v.rejected(rejectionSignature), logical.ErrorResponse(err.Error()), nil
 			}
-
+			v.signatureVerified = true
+		} else if configType == JWKS || len(role.JWKSURLs) > 0 {
 			// Verify signature (and only signature... other elements are checked later)
-			payload, err := keySet.VerifySignature(ctx, token)
-			if err != nil {
-				return logical.ErrorResponse(errwrap.Wrapf("error verifying token: {{err}}", err).Error()), nil
+			var payload []byte
+			if role.JWKSPerKidURLTemplate != "" {
+				payload, err = b.verifyWithPerKidKey(ctx, config, role, token)
+				if err != nil {
+					b.Logger().Warn("per-kid key verification failed, falling back to jwks_url", "error", err)
+					payload = nil
+				}
+			}
+			if payload == nil && len(role.JWKSURLs) > 0 {
+				payload, err = b.verifyWithRoleJWKS(config, roleName, role, token)
+				if err != nil {
+					return nil, v.rejected(rejectionOf(err)), logical.ErrorResponse(errwrap.Wrapf("error verifying token: {{err}}", err).Error()), nil
+				}
+			}
+			if payload == nil {
+				if config.JWKSURL == "" {
+					return nil, v, logical.ErrorResponse("error fetching jwks keyset: keyset error: jwks_url not configured"), nil
+				}
+
+				payload, err = b.verifyWithCachedJWKS(config, roleName, role, config.JWKSURL, token)
+				if err != nil {
+					return nil, v.rejected(rejectionOf(err)), logical.ErrorResponse(errwrap.Wrapf("error verifying token: {{err}}", err).Error()), nil
+				}
 			}
 
 			// Unmarshal payload into two copies: public claims for library verification, and a set
 			// of all received claims.
 			if err := json.Unmarshal(payload, &claims); err != nil {
-				return nil, fmt.Errorf("failed to unmarshal claims: %v", err)
+				return nil, tokenVerification{}, nil, fmt.Errorf("failed to unmarshal claims: %v", err)
 			}
 			if err := json.Unmarshal(payload, &allClaims); err != nil {
-				return nil, fmt.Errorf("failed to unmarshal claims: %v", err)
+				return nil, tokenVerification{}, nil, fmt.Errorf("failed to unmarshal claims: %v", err)
 			}
+			v.signatureVerified = true
 		} else {
 			parsedJWT, err := jwt.ParseSigned(token)
 			if err != nil {
-				return logical.ErrorResponse(errwrap.Wrapf("error parsing token: {{err}}", err).Error()), nil
+				return nil, v.rejected(rejectionSignature), logical.ErrorResponse(errwrap.Wrapf("error parsing token: {{err}}", err).Error()), nil
+			}
+
+			if len(parsedJWT.Headers) == 0 {
+				return nil, v.rejected(rejectionSignature), logical.ErrorResponse("error parsing token: token has no header"), nil
+			}
+			if err := checkAlgorithmRestrictions(role, parsedJWT.Headers[0].Algorithm, config.ParsedJWTPubKeys); err != nil {
+				return nil, v.rejected(rejectionSignature), logical.ErrorResponse(errwrap.Wrapf("error verifying token: {{err}}", err).Error()), nil
 			}
 
 			var valid bool
@@ -131,8 +350,9 @@ func (b *jwtAuthBackend) pathLogin(ctx context.Context, req *logical.Request, d
 				}
 			}
 			if !valid {
-				return logical.ErrorResponse("no known key successfully validated the token signature"), nil
+				return nil, v.rejected(rejectionSignature), logical.ErrorResponse("no known key successfully validated the token signature"), nil
 			}
+			v.signatureVerified = true
 		}
 
 		// We require notbefore or expiry; if only one is provided, we allow 5 minutes of leeway by default.
@@ -147,7 +367,7 @@ func (b *jwtAuthBackend) pathLogin(ctx context.Context, req *logical.Request, d
 			claims.NotBefore = new(jwt.NumericDate)
 		}
 		if *claims.IssuedAt == 0 && *claims.Expiry == 0 && *claims.NotBefore == 0 {
-			return logical.ErrorResponse("no issue time, notbefore, or expiration time encoded in token"), nil
+			return nil, v.rejected(rejectionClaims), logical.ErrorResponse("no issue time, notbefore, or expiration time encoded in token"), nil
 		}
 
 		if *claims.Expiry == 0 {
@@ -178,46 +398,248 @@ func (b *jwtAuthBackend) pathLogin(ctx context.Context, req *logical.Request, d
 			}
 		}
 
-		if len(claims.Audience) > 0 && len(role.BoundAudiences) == 0 {
-			return logical.ErrorResponse("audience claim found in JWT but no audiences bound to the role"), nil
+		boundAudiences := config.boundAudiences(role)
+
+		if len(claims.Audience) > 0 && len(boundAudiences) == 0 {
+			return nil, v.rejected(rejectionClaims), logical.ErrorResponse("audience claim found in JWT but no audiences bound to the role"), nil
 		}
 
 		expected := jwt.Expected{
-			Issuer:  config.BoundIssuer,
+			Issuer:  config.boundIssuer(),
 			Subject: role.BoundSubject,
 			Time:    time.Now(),
 		}
 
-		cksLeeway := role.ClockSkewLeeway
-		if role.ClockSkewLeeway.Seconds() < 0 {
-			cksLeeway = 0
-		} else if role.ClockSkewLeeway.Seconds() == 0 {
-			cksLeeway = jwt.DefaultLeeway
-		}
+		cksLeeway := config.clockSkewLeeway(role)
 
 		if err := claims.ValidateWithLeeway(expected, cksLeeway); err != nil {
-			return logical.ErrorResponse(errwrap.Wrapf("error validating claims: {{err}}", err).Error()), nil
+			return nil, v.rejected(rejectionClaims), logical.ErrorResponse(errwrap.Wrapf("error validating claims: {{err}}", err).Error()), nil
+		}
+
+		if err := validateIssuedAt(role, claims.IssuedAt.Time(), cksLeeway); err != nil {
+			return nil, v.rejected(rejectionClaims), logical.ErrorResponse(errwrap.Wrapf("error validating claims: {{err}}", err).Error()), nil
+		}
+
+		if err := validateAudience(role.BoundAudiencesType, boundAudiences, claims.Audience, true); err != nil {
+			return nil, v.rejected(rejectionClaims), logical.ErrorResponse(errwrap.Wrapf("error validating claims: {{err}}", err).Error()), nil
+		}
+
+		if role.AudienceStrict {
+			if err := validateAudienceStrict(role.BoundAudiencesType, boundAudiences, claims.Audience); err != nil {
+				return nil, v.rejected(rejectionClaims), logical.ErrorResponse(errwrap.Wrapf("error validating claims: {{err}}", err).Error()), nil
+			}
 		}
 
-		if err := validateAudience(role.BoundAudiences, claims.Audience, true); err != nil {
-			return logical.ErrorResponse(errwrap.Wrapf("error validating claims: {{err}}", err).Error()), nil
+		if err := validateFederationAudience(config, claims.Audience); err != nil {
+			return nil, v.rejected(rejectionClaims), logical.ErrorResponse(errwrap.Wrapf("error validating claims: {{err}}", err).Error()), nil
 		}
 
 	case configType == OIDCDiscovery:
 		allClaims, err = b.verifyOIDCToken(ctx, config, role, token)
 		if err != nil {
-			return logical.ErrorResponse(err.Error()), nil
+			return nil, v.rejected(rejectionOf(err)), logical.ErrorResponse(err.Error()), nil
 		}
 
 	default:
-		return nil, errors.New("unhandled case during login")
+		return nil, tokenVerification{}, nil, errors.New("unhandled case during login")
+	}
+
+	return allClaims, v, nil, nil
+}
+
+// validateSigningAlg checks that token is signed with one of algs, if set.
+// The OIDC discovery verifier does the same check itself, while keys from a
+// JWKS or jwt_validation_pubkeys otherwise accept any algorithm of their key
+// type, e.g. both RS256 and PS256 for RSA keys.
+func validateSigningAlg(algs []string, token string) error {
+	if len(algs) == 0 {
+		return nil
+	}
+
+	parsedJWT, err := jwt.ParseSigned(token)
+	if err != nil {
+		return err
+	}
+	for _, header := range parsedJWT.Headers {
+		if !strutil.StrListContains(algs, header.Algorithm) {
+			return fmt.Errorf("unsupported signing algorithm %q", header.Algorithm)
+		}
+	}
+
+	return nil
+}
+
+// audienceRole returns the role mapped in audience_role_mapping to the first
+// of the token's audiences that has a mapping, or "" if none does. The token
+// isn't verified here; it is validated against the selected role afterwards.
+func audienceRole(config *jwtConfig, token string) string {
+	if len(config.AudienceRoleMapping) == 0 || token == "" {
+		return ""
+	}
+
+	parsedJWT, err := jwt.ParseSigned(token)
+	if err != nil {
+		return ""
+	}
+
+	var claims jwt.Claims
+	if err := parsedJWT.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return ""
+	}
+
+	return mappedAudienceRole(config, claims.Audience)
+}
+
+// mappedAudienceRole returns the role mapped in audience_role_mapping to the
+// first of audiences that has a mapping, or "" if none does.
+func mappedAudienceRole(config *jwtConfig, audiences []string) string {
+	for _, aud := range audiences {
+		if roleName, ok := config.AudienceRoleMapping[aud]; ok {
+			return roleName
+		}
+	}
+
+	return ""
+}
+
+// requestFingerprintMetadata is the token metadata key holding the claim
+// named by a role's oidc_request_fingerprint_claim.
+const requestFingerprintMetadata = "request_fingerprint"
+
+// addRequestFingerprint copies the role's request fingerprint claim, if
+// configured, to metadata. The claim is required once configured, since the
+// binding would otherwise be silently absent.
+func addRequestFingerprint(logger log.Logger, role *jwtRole, allClaims map[string]interface{}, metadata map[string]string) error {
+	if role.RequestFingerprintClaim == "" {
+		return nil
+	}
+
+	fingerprint, ok := getClaim(logger, allClaims, role.RequestFingerprintClaim).(string)
+	if !ok || fingerprint == "" {
+		return fmt.Errorf("request fingerprint claim %q not found in token", role.RequestFingerprintClaim)
+	}
+	metadata[requestFingerprintMetadata] = fingerprint
+
+	return nil
+}
+
+// addClaimBoundCIDRs adds the CIDRs of the role's token_cidrs_claim to the
+// token_bound_cidrs of auth.
+func addClaimBoundCIDRs(logger log.Logger, role *jwtRole, allClaims map[string]interface{}, auth *logical.Auth) error {
+	if role.TokenCIDRsClaim == "" {
+		return nil
+	}
+
+	value := getClaim(logger, allClaims, role.TokenCIDRsClaim)
+	if value == nil {
+		return fmt.Errorf("token CIDRs claim %q not found in token", role.TokenCIDRsClaim)
+	}
+	values, ok := normalizeList(value)
+	if !ok {
+		return fmt.Errorf("token CIDRs claim %q must be a string or a list of strings", role.TokenCIDRsClaim)
+	}
+
+	// auth.BoundCIDRs is the role's token_bound_cidrs slice, so it is copied
+	// before being modified.
+	cidrs := make([]*sockaddr.SockAddrMarshaler, 0, len(auth.BoundCIDRs)+len(values))
+	cidrs = append(cidrs, auth.BoundCIDRs...)
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("token CIDRs claim %q must be a string or a list of strings", role.TokenCIDRsClaim)
+		}
+		if _, _, err := net.ParseCIDR(s); err != nil {
+			return fmt.Errorf("invalid CIDR %q in token CIDRs claim %q", s, role.TokenCIDRsClaim)
+		}
+		sa, err := sockaddr.NewSockAddr(s)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q in token CIDRs claim %q: %s", s, role.TokenCIDRsClaim, err)
+		}
+		cidrs = append(cidrs, &sockaddr.SockAddrMarshaler{SockAddr: sa})
+	}
+	auth.BoundCIDRs = cidrs
+
+	return nil
+}
+
+// validateLoginClaims validates the verified claims of a token against the
+// role, and returns them after they have been enriched and normalized. On
+// failure, the returned tokenRejection reports whether the claims were
+// rejected for good.
+func (b *jwtAuthBackend) validateLoginClaims(ctx context.Context, config *jwtConfig, role *jwtRole, allClaims map[string]interface{}) (map[string]interface{}, *logical.Response, tokenRejection) {
+	if err := handleProviderClaims(config, allClaims); err != nil {
+		return nil, logical.ErrorResponse("error validating claims: %s", err.Error()), rejectionClaims
+	}
+
+	allClaims, err := enrichClaims(ctx, config, allClaims)
+	if err != nil {
+		return nil, logical.ErrorResponse("error enriching claims: %s", err.Error()), rejectionTransient
+	}
+	allClaims = stripClaimNamespace(role.ClaimNamespaceStrip, allClaims)
+
+	if role.RequireEmailVerified {
+		if err := validateEmailVerified(allClaims); err != nil {
+			return nil, logical.ErrorResponse("error validating claims: %s", err.Error()), rejectionClaims
+		}
+	}
+
+	if len(role.BoundACRValues) > 0 {
+		if err := validateACR(allClaims, role.BoundACRValues, role.ACRValuesSatisfaction); err != nil {
+			return nil, logical.ErrorResponse("error validating claims: %s", err.Error()), rejectionClaims
+		}
+	}
+
+	if err := validateClaimsSchema(role, allClaims); err != nil {
+		return nil, logical.ErrorResponse("error validating claims: %s", err.Error()), rejectionClaims
+	}
+
+	if err := validateRequiredClaims(b.Logger(), allClaims, role.RequiredClaims); err != nil {
+		return nil, logical.ErrorResponse("error validating claims: %s", err.Error()), rejectionClaims
 	}
 
 	if err := validateBoundClaims(b.Logger(), role.BoundClaimsType, role.BoundClaims, allClaims); err != nil {
-		return logical.ErrorResponse("error validating claims: %s", err.Error()), nil
+		return nil, logical.ErrorResponse("error validating claims: %s", err.Error()), rejectionClaims
+	}
+
+	if err := validateBoundClaims(b.Logger(), boundClaimsTypeString, role.githubBoundClaims(), allClaims); err != nil {
+		return nil, logical.ErrorResponse("error validating claims: %s", err.Error()), rejectionClaims
+	}
+
+	if err := validateBoundClaimsOperators(b.Logger(), role.BoundClaimsOperators, allClaims); err != nil {
+		return nil, logical.ErrorResponse("error validating claims: %s", err.Error()), rejectionClaims
+	}
+
+	if err := validateCELPolicy(role, allClaims); err != nil {
+		return nil, logical.ErrorResponse("error validating claims: %s", err.Error()), rejectionClaims
+	}
+
+	if err := b.checkRevocation(ctx, config, role, allClaims); err != nil {
+		rejection := rejectionTransient
+		if err == errTokenRevoked {
+			rejection = rejectionClaims
+		}
+		return nil, logical.ErrorResponse("error validating token: %s", err.Error()), rejection
+	}
+
+	return allClaims, nil, rejectionTransient
+}
+
+// loginResponse validates the verified claims of a token against the role and
+// builds the login response. tokenSource is passed on to the provider's
+// GroupsFetcher, if any.
+func (b *jwtAuthBackend) loginResponse(ctx context.Context, req *logical.Request, config *jwtConfig, role *jwtRole, roleName string, allClaims map[string]interface{}, tokenSource oauth2.TokenSource) (*logical.Response, error) {
+	allClaims, resp, _ := b.validateLoginClaims(ctx, config, role, allClaims)
+	if resp != nil {
+		return resp, nil
 	}
 
-	alias, groupAliases, err := b.createIdentity(allClaims, role)
+	return b.validatedLoginResponse(ctx, req, config, role, roleName, allClaims, tokenSource)
+}
+
+// validatedLoginResponse builds the login response for claims that were
+// validated with validateLoginClaims.
+func (b *jwtAuthBackend) validatedLoginResponse(ctx context.Context, req *logical.Request, config *jwtConfig, role *jwtRole, roleName string, allClaims map[string]interface{}, tokenSource oauth2.TokenSource) (*logical.Response, error) {
+	alias, groupAliases, err := b.createIdentity(ctx, config, allClaims, role, tokenSource)
 	if err != nil {
 		return logical.ErrorResponse(err.Error()), nil
 	}
@@ -226,9 +648,22 @@ func (b *jwtAuthBackend) pathLogin(ctx context.Context, req *logical.Request, d
 	for k, v := range alias.Metadata {
 		tokenMetadata[k] = v
 	}
+	if err := b.addEncryptedClaims(ctx, req.Storage, role, roleName, allClaims, tokenMetadata); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := addRequestFingerprint(b.Logger(), role, allClaims, tokenMetadata); err != nil {
+		return logical.ErrorResponse("error validating claims: %s", err.Error()), nil
+	}
+	tokenMetadata[tokenClassificationMetadata], err = classifyToken(role, roleName, allClaims, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := b.addWebhookMetadata(ctx, config, role, roleName, req.ID, tokenMetadata); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
 
 	auth := &logical.Auth{
-		DisplayName:  alias.Name,
+		DisplayName:  providerDisplayName(config, allClaims, alias),
 		Alias:        alias,
 		GroupAliases: groupAliases,
 		InternalData: map[string]interface{}{
@@ -236,8 +671,20 @@ func (b *jwtAuthBackend) pathLogin(ctx context.Context, req *logical.Request, d
 		},
 		Metadata: tokenMetadata,
 	}
+	if err := b.addLoginSession(ctx, req.Storage, allClaims, auth); err != nil {
+		return logical.ErrorResponse("error validating token: %s", err.Error()), nil
+	}
 
 	role.PopulateTokenAuth(auth)
+	role.applyClaimPolicies(b.Logger(), allClaims, auth)
+	if err := b.applyPolicyEngine(ctx, config, role, roleName, req, allClaims, auth); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := addClaimBoundCIDRs(b.Logger(), role, allClaims, auth); err != nil {
+		return logical.ErrorResponse("error validating claims: %s", err.Error()), nil
+	}
+
+	b.sendLoginWebhook(config, role, roleName, allClaims, auth)
 
 	return &logical.Response{
 		Auth: auth,
@@ -259,6 +706,16 @@ func (b *jwtAuthBackend) pathLoginRenew(ctx context.Context, req *logical.Reques
 		return nil, fmt.Errorf("role %s does not exist during renewal", roleName)
 	}
 
+	// Tokens can't be revoked by the plugin, so the tokens of sessions ended
+	// by a back-channel logout are denied renewal.
+	loggedOut, err := b.sessionLoggedOut(ctx, req.Storage, req.Auth)
+	if err != nil {
+		return nil, err
+	}
+	if loggedOut {
+		return nil, errors.New("the session of the token has been logged out")
+	}
+
 	resp := &logical.Response{Auth: req.Auth}
 	resp.Auth.TTL = role.TokenTTL
 	resp.Auth.MaxTTL = role.TokenMaxTTL
@@ -269,15 +726,35 @@ func (b *jwtAuthBackend) pathLoginRenew(ctx context.Context, req *logical.Reques
 func (b *jwtAuthBackend) verifyOIDCToken(ctx context.Context, config *jwtConfig, role *jwtRole, rawToken string) (map[string]interface{}, error) {
 	allClaims := make(map[string]interface{})
 
+	if err := b.providerBreaker.allow(config); err != nil {
+		return nil, err
+	}
+
 	provider, err := b.getProvider(config)
 	if err != nil {
 		return nil, errwrap.Wrapf("error getting provider for login operation: {{err}}", err)
 	}
 
+	alg, err := tokenAlgorithm(rawToken)
+	if err != nil {
+		return nil, rejectSignature(errwrap.Wrapf("error parsing token: {{err}}", err))
+	}
+	if err := checkAlgorithmRestrictions(role, alg, nil); err != nil {
+		return nil, rejectSignature(err)
+	}
+
 	oidcConfig := &oidc.Config{
 		SupportedSigningAlgs: config.JWTSupportedAlgs,
 	}
 
+	// The verifier checks exp without leeway, so jwt_clock_skew_leeway is
+	// applied by moving its clock back.
+	if config.JWTClockSkewLeeway > 0 {
+		oidcConfig.Now = func() time.Time {
+			return time.Now().Add(-config.JWTClockSkewLeeway)
+		}
+	}
+
 	if role.RoleType == "oidc" {
 		oidcConfig.ClientID = config.OIDCClientID
 	} else {
@@ -287,45 +764,88 @@ func (b *jwtAuthBackend) verifyOIDCToken(ctx context.Context, config *jwtConfig,
 	verifier := provider.Verifier(oidcConfig)
 
 	idToken, err := verifier.Verify(ctx, rawToken)
+	if err == nil || isKeyFetchError(err) {
+		b.providerBreaker.record(config, err != nil)
+	}
 	if err != nil {
-		return nil, errwrap.Wrapf("error validating signature: {{err}}", err)
+		return nil, &rejectionError{rejection: keySetRejection(err), err: errwrap.Wrapf("error validating signature: {{err}}", err)}
 	}
 
 	if err := idToken.Claims(&allClaims); err != nil {
-		return nil, errwrap.Wrapf("unable to successfully parse all claims from token: {{err}}", err)
+		return nil, rejectClaims(errwrap.Wrapf("unable to successfully parse all claims from token: {{err}}", err))
+	}
+
+	if err := validateIssuedAt(role, idToken.IssuedAt, config.clockSkewLeeway(role)); err != nil {
+		return nil, rejectClaims(errwrap.Wrapf("error validating claims: {{err}}", err))
 	}
 
 	if role.BoundSubject != "" && role.BoundSubject != idToken.Subject {
-		return nil, errors.New("sub claim does not match bound subject")
+		return nil, rejectClaims(errors.New("sub claim does not match bound subject"))
+	}
+
+	if config.OIDCFederationIssuer != "" && idToken.Issuer != config.OIDCFederationIssuer {
+		return nil, rejectClaims(errors.New("iss claim does not match oidc_federation_issuer"))
+	}
+
+	if err := validateAudience(role.BoundAudiencesType, config.boundAudiences(role), idToken.Audience, false); err != nil {
+		return nil, rejectClaims(errwrap.Wrapf("error validating claims: {{err}}", err))
+	}
+
+	if role.AudienceStrict {
+		if err := validateAudienceStrict(role.BoundAudiencesType, config.boundAudiences(role), idToken.Audience); err != nil {
+			return nil, rejectClaims(errwrap.Wrapf("error validating claims: {{err}}", err))
+		}
 	}
 
-	if err := validateAudience(role.BoundAudiences, idToken.Audience, false); err != nil {
-		return nil, errwrap.Wrapf("error validating claims: {{err}}", err)
+	if err := validateFederationAudience(config, idToken.Audience); err != nil {
+		return nil, rejectClaims(errwrap.Wrapf("error validating claims: {{err}}", err))
 	}
 
 	return allClaims, nil
 }
 
-// createIdentity creates an alias and set of groups aliases based on the role
-// definition and received claims.
-func (b *jwtAuthBackend) createIdentity(allClaims map[string]interface{}, role *jwtRole) (*logical.Alias, []*logical.Alias, error) {
-	userClaimRaw, ok := allClaims[role.UserClaim]
-	if !ok {
-		return nil, nil, fmt.Errorf("claim %q not found in token", role.UserClaim)
-	}
-	userName, ok := userClaimRaw.(string)
-	if !ok {
-		return nil, nil, fmt.Errorf("claim %q could not be converted to string", role.UserClaim)
+// createAlias creates the entity alias of the received claims based on the
+// role definition.
+func (b *jwtAuthBackend) createAlias(config *jwtConfig, allClaims map[string]interface{}, role *jwtRole) (*logical.Alias, error) {
+	userName, err := role.aliasName(allClaims)
+	if err != nil {
+		return nil, err
 	}
+	userName = normalizeAliasName(config, userName)
 
-	metadata, err := extractMetadata(b.Logger(), allClaims, role.ClaimMappings)
+	metadata, err := extractMetadata(b.Logger(), allClaims, role.claimMappingsFor(b.Logger(), allClaims))
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+	if err := applyClaimTransforms(role.ClaimMappingsTransform, metadata); err != nil {
+		return nil, err
+	}
+
+	if err := applyConditionalClaimMappings(b.Logger(), allClaims, role.ConditionalClaimMappings, metadata); err != nil {
+		return nil, err
+	}
+
+	if handler, ok := config.provider.(IdentityHandler); ok {
+		for k, v := range handler.AliasMetadata(allClaims) {
+			if _, ok := metadata[k]; !ok {
+				metadata[k] = v
+			}
+		}
 	}
 
-	alias := &logical.Alias{
+	return &logical.Alias{
 		Name:     userName,
 		Metadata: metadata,
+	}, nil
+}
+
+// createIdentity creates an alias and set of groups aliases based on the role
+// definition and received claims. tokenSource is only available for OIDC
+// logins and is passed to the provider's GroupsFetcher, if any.
+func (b *jwtAuthBackend) createIdentity(ctx context.Context, config *jwtConfig, allClaims map[string]interface{}, role *jwtRole, tokenSource oauth2.TokenSource) (*logical.Alias, []*logical.Alias, error) {
+	alias, err := b.createAlias(config, allClaims, role)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	var groupAliases []*logical.Alias
@@ -334,18 +854,44 @@ func (b *jwtAuthBackend) createIdentity(allClaims map[string]interface{}, role *
 		return alias, groupAliases, nil
 	}
 
-	groupsClaimRaw := getClaim(b.Logger(), allClaims, role.GroupsClaim)
+	var groupsClaimRaw interface{}
+	if fetcher, ok := config.provider.(GroupsFetcher); ok {
+		groupsClaimRaw, err = fetcher.FetchGroups(ctx, b, allClaims, role, tokenSource)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		groupsClaimRaw = getClaim(b.Logger(), allClaims, role.GroupsClaim)
+	}
 
 	if groupsClaimRaw == nil {
+		if role.IgnoreMissingGroups {
+			b.Logger().Debug("groups claim not found in token, continuing without group aliases", "groups_claim", role.GroupsClaim)
+			return alias, groupAliases, nil
+		}
 		return nil, nil, fmt.Errorf("%q claim not found in token", role.GroupsClaim)
 	}
 
+	// With groups_claim_sub_key, a single group object is handled like a
+	// list of one group.
+	if obj, ok := groupsClaimRaw.(map[string]interface{}); ok && role.GroupsClaimSubKey != "" {
+		groupsClaimRaw = []interface{}{obj}
+	}
+
 	groups, ok := normalizeList(groupsClaimRaw)
 
 	if !ok {
 		return nil, nil, fmt.Errorf("%q claim could not be converted to string list", role.GroupsClaim)
 	}
 	for _, groupRaw := range groups {
+		if obj, ok := groupRaw.(map[string]interface{}); ok && role.GroupsClaimSubKey != "" {
+			subKeyRaw, ok := obj[role.GroupsClaimSubKey]
+			if !ok {
+				b.Logger().Warn("groups claim object is missing the sub-key, skipping it", "groups_claim", role.GroupsClaim, "groups_claim_sub_key", role.GroupsClaimSubKey)
+				continue
+			}
+			groupRaw = subKeyRaw
+		}
 		group, ok := groupRaw.(string)
 		if !ok {
 			return nil, nil, fmt.Errorf("value %v in groups claim could not be parsed as string", groupRaw)
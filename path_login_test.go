@@ -3,7 +3,10 @@ package jwtauth
 import (
 	"context"
 	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"io/ioutil"
@@ -30,6 +33,8 @@ type testConfig struct {
 	expLeeway      int
 	nbfLeeway      int
 	groupsClaim    string
+	roleData       map[string]interface{}
+	configData     map[string]interface{}
 }
 
 type closeableBackend struct {
@@ -77,6 +82,10 @@ func setupBackend(t *testing.T, cfg testConfig) (closeableBackend, logical.Stora
 		}
 	}
 
+	for k, v := range cfg.configData {
+		data[k] = v
+	}
+
 	req := &logical.Request{
 		Operation: logical.UpdateOperation,
 		Path:      configPath,
@@ -120,6 +129,10 @@ func setupBackend(t *testing.T, cfg testConfig) (closeableBackend, logical.Stora
 		data["bound_cidrs"] = "127.0.0.42"
 	}
 
+	for k, v := range cfg.roleData {
+		data[k] = v
+	}
+
 	data["clock_skew_leeway"] = cfg.defaultLeeway
 	data["expiration_leeway"] = cfg.expLeeway
 	data["not_before_leeway"] = cfg.nbfLeeway
@@ -408,6 +421,7 @@ func testLogin_JWT(t *testing.T, jwks bool) {
 
 			// check token metadata
 			metadata["role"] = "plugin-test"
+			metadata[tokenClassificationMetadata] = auth.Metadata[tokenClassificationMetadata]
 			if diff := deep.Equal(auth.Metadata, metadata); diff != nil {
 				t.Fatal(diff)
 			}
@@ -948,6 +962,84 @@ func testLogin_NotBeforeClaims(t *testing.T, jwks bool) {
 	}
 }
 
+func TestLogin_IssuedAt(t *testing.T) {
+	tests := []struct {
+		name     string
+		roleData map[string]interface{}
+		iat      time.Time
+		valid    bool
+	}{
+		{"past iat threshold not configured", nil, time.Now().Add(-2 * time.Hour), true},
+		{"past iat within threshold", map[string]interface{}{"reject_past_iat_threshold": "1h"}, time.Now().Add(-30 * time.Minute), true},
+		{"past iat beyond threshold", map[string]interface{}{"reject_past_iat_threshold": "1h"}, time.Now().Add(-2 * time.Hour), false},
+		{"verify_iat with current iat", map[string]interface{}{"verify_iat": true}, time.Now(), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, jwks := range []bool{false, true} {
+				cfg := testConfig{
+					audience: true,
+					jwks:     jwks,
+					roleData: tt.roleData,
+				}
+				b, storage := setupBackend(t, cfg)
+				req := setupLogin(t, tt.iat, time.Now().Add(time.Hour), time.Time{}, b, storage)
+
+				resp, err := b.HandleRequest(context.Background(), req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if resp == nil {
+					t.Fatal("got nil response")
+				}
+				if tt.valid && resp.IsError() {
+					t.Fatalf("unexpected error: %s", resp.Error())
+				} else if !tt.valid && !resp.IsError() {
+					t.Fatalf("expected error, got: %v", *resp)
+				}
+				b.closeServerFunc()
+			}
+		})
+	}
+}
+
+func TestLogin_IgnoreMissingGroups(t *testing.T) {
+	for _, ignore := range []bool{false, true} {
+		cfg := testConfig{
+			audience:    true,
+			groupsClaim: "https://vault/missing_groups",
+			roleData: map[string]interface{}{
+				"oidc_ignore_missing_groups": ignore,
+			},
+		}
+		b, storage := setupBackend(t, cfg)
+		req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil {
+			t.Fatal("got nil response")
+		}
+
+		if !ignore {
+			if !resp.IsError() || !strings.Contains(resp.Error().Error(), "claim not found in token") {
+				t.Fatalf("expected missing groups claim error, got: %v", *resp)
+			}
+			continue
+		}
+
+		if resp.IsError() {
+			t.Fatalf("unexpected error: %s", resp.Error())
+		}
+		if len(resp.Auth.GroupAliases) != 0 {
+			t.Fatalf("expected no group aliases, got: %v", resp.Auth.GroupAliases)
+		}
+	}
+}
+
 func setupLogin(t *testing.T, iat, exp, nbf time.Time, b logical.Backend, storage logical.Storage) *logical.Request {
 	cl := jwt.Claims{
 		Audience:  jwt.Audience{"https://vault.plugin.auth.jwt.test"},
@@ -1285,3 +1377,703 @@ AwEHoUQDQgAE+C3CyjVWdeYtIqgluFJlwZmoonphsQbj9Nfo5wrEutv+3RTFnDQh
 vttUajcFAcl4beR+jHFYC00vSO4i5jZ64g==
 -----END EC PRIVATE KEY-----`
 )
+
+func TestLogin_RequireEmailVerified(t *testing.T) {
+	cfg := testConfig{
+		audience: true,
+		roleData: map[string]interface{}{
+			"require_email_verified": true,
+		},
+	}
+	b, storage := setupBackend(t, cfg)
+	req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "email_verified claim is missing") {
+		t.Fatalf("expected email_verified error, got: %v", resp)
+	}
+}
+
+func TestLogin_Federation(t *testing.T) {
+	tests := []struct {
+		name        string
+		issuer      string
+		audience    string
+		errExpected string
+	}{
+		{"matching", "https://team-vault.auth0.com/", "https://vault.plugin.auth.jwt.test", ""},
+		{"issuer mismatch", "https://vault-a.example.com/", "https://vault.plugin.auth.jwt.test", "validation failed, invalid issuer claim"},
+		{"audience mismatch", "https://team-vault.auth0.com/", "https://vault-b.example.com", "aud claim does not match"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig{
+				configData: map[string]interface{}{
+					"bound_issuer":             "",
+					"oidc_federation_issuer":   tt.issuer,
+					"oidc_federation_audience": tt.audience,
+				},
+			}
+			b, storage := setupBackend(t, cfg)
+			req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp == nil {
+				t.Fatal("got nil response")
+			}
+
+			if tt.errExpected == "" {
+				if resp.IsError() {
+					t.Fatalf("unexpected error: %s", resp.Error())
+				}
+				return
+			}
+			if !resp.IsError() || !strings.Contains(resp.Error().Error(), tt.errExpected) {
+				t.Fatalf("expected error containing %q, got: %v", tt.errExpected, resp)
+			}
+		})
+	}
+}
+
+func TestLogin_AudienceStrict(t *testing.T) {
+	cfg := testConfig{
+		audience: true,
+		roleData: map[string]interface{}{
+			"oidc_audience_strict": true,
+		},
+	}
+	b, storage := setupBackend(t, cfg)
+	req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+}
+
+func TestLogin_AudienceRoleMapping(t *testing.T) {
+	cfg := testConfig{
+		audience: true,
+		configData: map[string]interface{}{
+			"default_role": "unused",
+			"audience_role_mapping": map[string]interface{}{
+				"https://vault.plugin.auth.jwt.test": "plugin-test",
+			},
+		},
+	}
+	b, storage := setupBackend(t, cfg)
+	req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+	delete(req.Data, "role")
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+	if role := resp.Auth.Metadata["role"]; role != "plugin-test" {
+		t.Fatalf("expected mapped role, got: %q", role)
+	}
+
+	// An explicit role takes precedence over the mapping.
+	req.Data["role"] = "other"
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || resp.Error().Error() != `role "other" could not be found` {
+		t.Fatalf("expected explicit role to be used, got: %v", resp)
+	}
+}
+
+func TestLogin_RequestFingerprintClaim(t *testing.T) {
+	for claim, expected := range map[string]string{
+		"color":   "green",
+		"missing": "",
+	} {
+		b, storage := setupBackend(t, testConfig{
+			audience: true,
+			roleData: map[string]interface{}{
+				"oidc_request_fingerprint_claim": claim,
+			},
+		})
+		req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected == "" {
+			if resp == nil || !resp.IsError() {
+				t.Fatalf("expected error for missing fingerprint claim, got: %v", resp)
+			}
+			continue
+		}
+		if resp == nil || resp.IsError() {
+			t.Fatalf("expected successful login, got: %v", resp)
+		}
+		if fp := resp.Auth.Metadata[requestFingerprintMetadata]; fp != expected {
+			t.Fatalf("expected fingerprint %q, got %q", expected, fp)
+		}
+	}
+}
+
+func TestLogin_ClaimNamespaceStrip(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		roleData: map[string]interface{}{
+			"claim_namespace_strip": "https://vault/",
+			"user_claim":            "user",
+			"groups_claim":          "groups",
+			"bound_claims":          map[string]interface{}{"groups": "bar"},
+			"claim_mappings":        map[string]string{"user": "username"},
+		},
+	})
+
+	req := setupLogin(t, time.Now(), time.Now().Add(5*time.Second), time.Now(), b, storage)
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+
+	if resp.Auth.Alias.Name != "foobar" || resp.Auth.Metadata["username"] != "foobar" {
+		t.Fatalf("unexpected alias or metadata: %v %v", resp.Auth.Alias.Name, resp.Auth.Metadata)
+	}
+	var groups []string
+	for _, alias := range resp.Auth.GroupAliases {
+		groups = append(groups, alias.Name)
+	}
+	if diff := deep.Equal(groups, []string{"foo", "bar"}); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestLogin_NestedClaims(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		roleData: map[string]interface{}{
+			"user_claim":     "profile.user.id",
+			"groups_claim":   "resource_access.vault.roles",
+			"bound_claims":   map[string]interface{}{"org.team.name": "infra"},
+			"claim_mappings": map[string]string{"org.team.name": "team"},
+		},
+	})
+
+	login := func(team string) *logical.Response {
+		t.Helper()
+		cl := jwt.Claims{
+			Audience:  jwt.Audience{"https://vault.plugin.auth.jwt.test"},
+			Issuer:    "https://team-vault.auth0.com/",
+			Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+			NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
+			Expiry:    jwt.NewNumericDate(time.Now().Add(5 * time.Second)),
+		}
+		privateCl := map[string]interface{}{
+			"profile":         map[string]interface{}{"user": map[string]interface{}{"id": "jeff"}},
+			"resource_access": map[string]interface{}{"vault": map[string]interface{}{"roles": []string{"admin", "reader"}}},
+			"org":             map[string]interface{}{"team": map[string]interface{}{"name": team}},
+		}
+		token, _ := getTestJWT(t, ecdsaPrivKey, cl, privateCl)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role": "plugin-test",
+				"jwt":  token,
+			},
+			Connection: &logical.Connection{
+				RemoteAddr: "127.0.0.1",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := login("infra")
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+	if resp.Auth.Alias.Name != "jeff" || resp.Auth.Metadata["team"] != "infra" {
+		t.Fatalf("unexpected alias or metadata: %v %v", resp.Auth.Alias.Name, resp.Auth.Metadata)
+	}
+	if len(resp.Auth.GroupAliases) != 2 || resp.Auth.GroupAliases[0].Name != "admin" || resp.Auth.GroupAliases[1].Name != "reader" {
+		t.Fatalf("unexpected group aliases: %v", resp.Auth.GroupAliases)
+	}
+
+	if resp := login("payments"); resp == nil || !resp.IsError() {
+		t.Fatalf("expected bound claim mismatch, got: %v", resp)
+	}
+}
+
+func TestLogin_TokenCIDRsClaim(t *testing.T) {
+	tests := []struct {
+		name     string
+		cidrs    interface{}
+		roleData map[string]interface{}
+		expected []string
+	}{
+		{
+			name:     "single CIDR",
+			cidrs:    "10.0.0.0/16",
+			expected: []string{"10.0.0.0/16"},
+		},
+		{
+			name:     "CIDR list",
+			cidrs:    []string{"10.0.0.0/16", "192.168.1.0/24"},
+			expected: []string{"10.0.0.0/16", "192.168.1.0/24"},
+		},
+		{
+			name:  "invalid CIDR",
+			cidrs: "10.0.0.0/33",
+		},
+		{
+			name:  "address without prefix",
+			cidrs: []string{"10.0.0.0/16", "10.0.0.1"},
+		},
+		{
+			name:  "missing claim",
+			cidrs: nil,
+		},
+		{
+			name:  "union with token_bound_cidrs",
+			cidrs: "10.0.0.0/16",
+			roleData: map[string]interface{}{
+				"token_bound_cidrs": "127.0.0.1/8",
+			},
+			expected: []string{"127.0.0.1/8", "10.0.0.0/16"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roleData := map[string]interface{}{
+				"token_cidrs_claim": "/net/cidrs",
+			}
+			for k, v := range tt.roleData {
+				roleData[k] = v
+			}
+			b, storage := setupBackend(t, testConfig{
+				audience: true,
+				roleData: roleData,
+			})
+
+			cl := jwt.Claims{
+				Audience:  jwt.Audience{"https://vault.plugin.auth.jwt.test"},
+				Issuer:    "https://team-vault.auth0.com/",
+				Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+				Expiry:    jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				NotBefore: jwt.NewNumericDate(time.Now()),
+			}
+			privateCl := map[string]interface{}{
+				"https://vault/user":   "foobar",
+				"https://vault/groups": []string{"foo", "bar"},
+			}
+			if tt.cidrs != nil {
+				privateCl["net"] = map[string]interface{}{"cidrs": tt.cidrs}
+			}
+			jwtData, _ := getTestJWT(t, ecdsaPrivKey, cl, privateCl)
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "login",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"role": "plugin-test",
+					"jwt":  jwtData,
+				},
+				Connection: &logical.Connection{
+					RemoteAddr: "127.0.0.1",
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.expected == nil {
+				if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "CIDR") {
+					t.Fatalf("expected CIDR error, got: %v", resp)
+				}
+				return
+			}
+			if resp == nil || resp.IsError() {
+				t.Fatalf("expected successful login, got: %v", resp)
+			}
+
+			var cidrs []string
+			for _, cidr := range resp.Auth.BoundCIDRs {
+				cidrs = append(cidrs, cidr.String())
+			}
+			if diff := deep.Equal(cidrs, tt.expected); diff != nil {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestLogin_RequiredClaims(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		roleData: map[string]interface{}{
+			"jwt_required_claims": "jti,/org/id",
+			"bound_claims":        map[string]interface{}{"color": "green"},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		claims  map[string]interface{}
+		missing string
+	}{
+		{"present", map[string]interface{}{"jti": "abc123", "org": map[string]interface{}{"id": "org-1"}}, ""},
+		{"null", map[string]interface{}{"jti": nil, "org": map[string]interface{}{"id": "org-1"}}, "jti"},
+		{"missing nested", map[string]interface{}{"jti": "abc123", "org": map[string]interface{}{}}, "/org/id"},
+		{"empty string", map[string]interface{}{"jti": "", "org": map[string]interface{}{"id": "org-1"}}, "jti"},
+		{"checked before bound claims", map[string]interface{}{"color": "red"}, "jti"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := jwt.Claims{
+				Audience:  jwt.Audience{"https://vault.plugin.auth.jwt.test"},
+				Issuer:    "https://team-vault.auth0.com/",
+				Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+				NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
+				Expiry:    jwt.NewNumericDate(time.Now().Add(5 * time.Second)),
+			}
+			privateCl := map[string]interface{}{
+				"https://vault/user":   "foobar",
+				"https://vault/groups": []string{"foo"},
+				"color":                "green",
+			}
+			for k, v := range tt.claims {
+				privateCl[k] = v
+			}
+			token, _ := getTestJWT(t, ecdsaPrivKey, cl, privateCl)
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "login",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"role": "plugin-test",
+					"jwt":  token,
+				},
+				Connection: &logical.Connection{
+					RemoteAddr: "127.0.0.1",
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.missing == "" {
+				if resp == nil || resp.IsError() {
+					t.Fatalf("expected successful login, got: %v", resp)
+				}
+				return
+			}
+			if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "required claim '"+tt.missing+"' is missing") {
+				t.Fatalf("expected missing claim %q, got: %v", tt.missing, resp)
+			}
+		})
+	}
+}
+
+func TestLogin_AlgNoneRejected(t *testing.T) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"aud":                "https://vault.plugin.auth.jwt.test",
+		"iss":                "https://team-vault.auth0.com/",
+		"sub":                "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+		"iat":                time.Now().Unix(),
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"https://vault/user": "foobar",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unsigned tokens are rejected whether or not the role's
+	// algorithm_restrictions list "none".
+	restrictions := []map[string]interface{}{
+		nil,
+		{"algorithm_restrictions": "none,ES256"},
+		{"algorithm_restrictions": "ES256"},
+	}
+
+	for _, jwks := range []bool{false, true} {
+		for i, alg := range []string{"none", "None", "NONE"} {
+			b, storage := setupBackend(t, testConfig{
+				audience: true,
+				jwks:     jwks,
+				roleData: restrictions[i],
+			})
+
+			header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"` + alg + `","typ":"JWT"}`))
+			body := base64.RawURLEncoding.EncodeToString(payload)
+			for _, token := range []string{header + "." + body + ".", header + "." + body + ".c2lnbmF0dXJl"} {
+				resp, err := b.HandleRequest(context.Background(), &logical.Request{
+					Operation: logical.UpdateOperation,
+					Path:      "login",
+					Storage:   storage,
+					Data: map[string]interface{}{
+						"role": "plugin-test",
+						"jwt":  token,
+					},
+					Connection: &logical.Connection{
+						RemoteAddr: "127.0.0.1",
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "unsigned tokens are not accepted") {
+					t.Fatalf("expected unsigned token with alg %q to be rejected (jwks: %t), got: %v", alg, jwks, resp)
+				}
+			}
+		}
+	}
+}
+
+func TestLogin_RSAPSS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	sign := func(alg jose.SignatureAlgorithm) string {
+		t.Helper()
+		sig, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, (&jose.SignerOptions{}).WithType("JWT"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cl := jwt.Claims{
+			Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+			Issuer:    "https://team-vault.auth0.com/",
+			NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
+			Expiry:    jwt.NewNumericDate(time.Now().Add(5 * time.Second)),
+		}
+		privateCl := map[string]interface{}{
+			"https://vault/user":   "jeff",
+			"https://vault/groups": []string{"foo", "bar"},
+		}
+		token, err := jwt.Signed(sig).Claims(cl).Claims(privateCl).CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name    string
+		algs    []string
+		alg     jose.SignatureAlgorithm
+		success bool
+	}{
+		{"PS256 without restriction", nil, jose.PS256, true},
+		{"PS384 without restriction", nil, jose.PS384, true},
+		{"PS512 without restriction", nil, jose.PS512, true},
+		{"PS256 allowed", []string{"PS256", "PS384", "PS512"}, jose.PS256, true},
+		{"PS512 allowed", []string{"PS256", "PS384", "PS512"}, jose.PS512, true},
+		{"PS256 with RS256 only", []string{"RS256"}, jose.PS256, false},
+		{"RS256 with RS256 only", []string{"RS256"}, jose.RS256, true},
+		{"RS256 with PS256 only", []string{"PS256"}, jose.RS256, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configData := map[string]interface{}{
+				"jwt_validation_pubkeys": []string{pubKey},
+			}
+			if tt.algs != nil {
+				configData["jwt_supported_algs"] = tt.algs
+			}
+			b, storage := setupBackend(t, testConfig{configData: configData})
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "login",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"role": "plugin-test",
+					"jwt":  sign(tt.alg),
+				},
+				Connection: &logical.Connection{
+					RemoteAddr: "127.0.0.1",
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.success && (resp == nil || resp.IsError()) {
+				t.Fatalf("expected successful login, got: %v", resp)
+			}
+			if !tt.success && (resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "unsupported signing algorithm")) {
+				t.Fatalf("expected unsupported algorithm error, got: %v", resp)
+			}
+		})
+	}
+}
+
+func TestLogin_JWTClockSkewLeeway(t *testing.T) {
+	// The times are truncated to seconds in the token, so they are kept 2s
+	// away from the leeway.
+	const leeway = 10 * time.Second
+
+	tests := []struct {
+		name    string
+		iat     time.Duration
+		exp     time.Duration
+		nbf     time.Duration
+		leeway  string
+		success bool
+	}{
+		{"expired within leeway", -time.Hour, -(leeway - 2*time.Second), -time.Hour, "10s", true},
+		{"expired beyond leeway", -time.Hour, -(leeway + 2*time.Second), -time.Hour, "10s", false},
+		{"not yet valid within leeway", 0, time.Hour, leeway - 2*time.Second, "10s", true},
+		{"not yet valid beyond leeway", 0, time.Hour, leeway + 2*time.Second, "10s", false},
+		{"issued in the future within leeway", leeway - 2*time.Second, time.Hour, 0, "10s", true},
+		{"issued in the future beyond leeway", leeway + 2*time.Second, time.Hour, 0, "10s", false},
+		{"expired without leeway", -time.Hour, -time.Second, -time.Hour, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configData := map[string]interface{}{}
+			if tt.leeway != "" {
+				configData["jwt_clock_skew_leeway"] = tt.leeway
+			}
+			b, storage := setupBackend(t, testConfig{
+				audience:      true,
+				defaultLeeway: -1,
+				configData:    configData,
+				roleData: map[string]interface{}{
+					"verify_iat": true,
+				},
+			})
+
+			now := time.Now()
+			req := setupLogin(t, now.Add(tt.iat), now.Add(tt.exp), now.Add(tt.nbf), b, storage)
+
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.success && resp.IsError() {
+				t.Fatalf("unexpected error: %v", resp.Error())
+			}
+			if !tt.success && !resp.IsError() {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestLogin_GroupsClaimSubKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		groups   interface{}
+		expected []string
+	}{
+		{
+			"objects",
+			[]interface{}{
+				map[string]interface{}{"id": "abc", "name": "engineering"},
+				map[string]interface{}{"id": "def", "name": "support"},
+			},
+			[]string{"engineering", "support"},
+		},
+		{
+			"mixed",
+			[]interface{}{
+				"admins",
+				map[string]interface{}{"id": "abc", "name": "engineering"},
+			},
+			[]string{"admins", "engineering"},
+		},
+		{
+			"missing sub-key",
+			[]interface{}{
+				map[string]interface{}{"id": "abc"},
+				map[string]interface{}{"id": "def", "name": "support"},
+			},
+			[]string{"support"},
+		},
+		{
+			"single object",
+			map[string]interface{}{"id": "abc", "name": "engineering"},
+			[]string{"engineering"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := setupBackend(t, testConfig{
+				audience: true,
+				roleData: map[string]interface{}{
+					"groups_claim_sub_key": "name",
+				},
+			})
+
+			cl := jwt.Claims{
+				Audience:  jwt.Audience{"https://vault.plugin.auth.jwt.test"},
+				Issuer:    "https://team-vault.auth0.com/",
+				Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+				NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
+				Expiry:    jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			}
+			privateCl := map[string]interface{}{
+				"https://vault/user":   "jeff",
+				"https://vault/groups": tt.groups,
+			}
+			jwtData, _ := getTestJWT(t, ecdsaPrivKey, cl, privateCl)
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "login",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"role": "plugin-test",
+					"jwt":  jwtData,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.IsError() {
+				t.Fatalf("unexpected error: %v", resp.Error())
+			}
+
+			var groups []string
+			for _, alias := range resp.Auth.GroupAliases {
+				groups = append(groups, alias.Name)
+			}
+			if diff := deep.Equal(groups, tt.expected); diff != nil {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
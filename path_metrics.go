@@ -0,0 +1,186 @@
+package jwtauth
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// validationBuckets are the upper bounds, in seconds, of the token validation
+// latency histogram.
+var validationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// validationHistogram is a cumulative histogram of token validation latency.
+type validationHistogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// validationMetrics records token validation latency by role.
+type validationMetrics struct {
+	l      sync.Mutex
+	byRole map[string]*validationHistogram
+}
+
+func newValidationMetrics() *validationMetrics {
+	return &validationMetrics{
+		byRole: make(map[string]*validationHistogram),
+	}
+}
+
+// observe records the time since start for role.
+func (m *validationMetrics) observe(role string, start time.Time) {
+	seconds := time.Since(start).Seconds()
+
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	h, ok := m.byRole[role]
+	if !ok {
+		h = &validationHistogram{counts: make([]uint64, len(validationBuckets))}
+		m.byRole[role] = h
+	}
+
+	for i, bound := range validationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// deleteRole removes the metrics of a deleted role.
+func (m *validationMetrics) deleteRole(role string) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	delete(m.byRole, role)
+}
+
+func pathMetrics(b *jwtAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: `oidc/metrics`,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathMetricsRead,
+				Summary:  "Read provider and token validation metrics in Prometheus text format.",
+			},
+		},
+
+		HelpSynopsis:    metricsHelpSyn,
+		HelpDescription: metricsHelpDesc,
+	}
+}
+
+func (b *jwtAuthBackend) pathMetricsRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	var buf bytes.Buffer
+
+	b.healthLock.RLock()
+	health := b.providerHealth
+	b.healthLock.RUnlock()
+
+	if health != nil {
+		up := 0
+		if health.status == "ok" {
+			up = 1
+		}
+		fmt.Fprintln(&buf, "# HELP jwtauth_provider_health Result of the last OIDC provider health check, by mount and status.")
+		fmt.Fprintln(&buf, "# TYPE jwtauth_provider_health gauge")
+		for _, status := range []string{"ok", "error"} {
+			value := 0
+			if health.status == status {
+				value = 1
+			}
+			fmt.Fprintf(&buf, "jwtauth_provider_health{mount=%q,status=%q} %d\n", req.MountPoint, status, value)
+		}
+		fmt.Fprintln(&buf, "# HELP vault_jwt_oidc_provider_up Whether the last OIDC provider health check succeeded.")
+		fmt.Fprintln(&buf, "# TYPE vault_jwt_oidc_provider_up gauge")
+		fmt.Fprintf(&buf, "vault_jwt_oidc_provider_up %d\n", up)
+		fmt.Fprintln(&buf, "# HELP vault_jwt_oidc_provider_last_check_timestamp_seconds Time of the last OIDC provider health check.")
+		fmt.Fprintln(&buf, "# TYPE vault_jwt_oidc_provider_last_check_timestamp_seconds gauge")
+		fmt.Fprintf(&buf, "vault_jwt_oidc_provider_last_check_timestamp_seconds %d\n", health.lastChecked.Unix())
+	}
+
+	open := 0
+	if b.providerBreaker.isOpen() {
+		open = 1
+	}
+	fmt.Fprintln(&buf, "# HELP vault_jwt_oidc_circuit_breaker_open Whether the OIDC provider circuit breaker is open.")
+	fmt.Fprintln(&buf, "# TYPE vault_jwt_oidc_circuit_breaker_open gauge")
+	fmt.Fprintf(&buf, "vault_jwt_oidc_circuit_breaker_open %d\n", open)
+
+	writeHistogram(&buf, "vault_jwt_token_validation_duration_seconds", "Latency of token validation during login, by role.", b.validationMetrics)
+	writeHistogram(&buf, metricOIDCProviderDuration, "Latency of OIDC provider requests during callbacks, by role.", b.providerMetrics)
+
+	b.authMetrics.l.Lock()
+	for _, counter := range authCounterHelp {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", counter.name, counter.help)
+		fmt.Fprintf(&buf, "# TYPE %s counter\n", counter.name)
+		for _, key := range b.authMetrics.sortedKeys(counter.name) {
+			if key.reason != "" {
+				fmt.Fprintf(&buf, "%s{role=%q,reason=%q} %d\n", counter.name, key.role, key.reason, b.authMetrics.counters[key])
+			} else {
+				fmt.Fprintf(&buf, "%s{role=%q} %d\n", counter.name, key.role, b.authMetrics.counters[key])
+			}
+		}
+	}
+	b.authMetrics.l.Unlock()
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPStatusCode:  http.StatusOK,
+			logical.HTTPRawBody:     buf.Bytes(),
+			logical.HTTPContentType: "text/plain; version=0.0.4",
+		},
+	}, nil
+}
+
+// writeHistogram writes the histograms of m in Prometheus text format.
+func writeHistogram(buf *bytes.Buffer, name, help string, m *validationMetrics) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	roles := make([]string, 0, len(m.byRole))
+	for role := range m.byRole {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s histogram\n", name)
+	for _, role := range roles {
+		h := m.byRole[role]
+		for i, bound := range validationBuckets {
+			fmt.Fprintf(buf, "%s_bucket{role=%q,le=\"%g\"} %d\n", name, role, bound, h.counts[i])
+		}
+		fmt.Fprintf(buf, "%s_bucket{role=%q,le=\"+Inf\"} %d\n", name, role, h.count)
+		fmt.Fprintf(buf, "%s_sum{role=%q} %g\n", name, role, h.sum)
+		fmt.Fprintf(buf, "%s_count{role=%q} %d\n", name, role, h.count)
+	}
+}
+
+const (
+	metricsHelpSyn = `
+Exposes provider and token validation metrics in Prometheus text format.
+`
+	metricsHelpDesc = `
+Returns the result of the last provider health check, as the
+jwtauth_provider_health{mount,status} and vault_jwt_oidc_provider_up gauges,
+the state of the provider circuit breaker, token validation and provider
+request latency histograms by role, and counters of login successes and failures by role.
+Failures are labelled with a reason: invalid_signature, expired,
+bound_claims, provider_error or other.
+Access is controlled by the read capability on this path, so a dedicated
+policy can grant it to a metrics scraper without granting access to the
+rest of the mount.
+`
+)
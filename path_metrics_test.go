@@ -0,0 +1,220 @@
+package jwtauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestMetrics(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{audience: true})
+
+	req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "oidc/metrics",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ct := resp.Data[logical.HTTPContentType]; ct != "text/plain; version=0.0.4" {
+		t.Fatalf("unexpected content type: %v", ct)
+	}
+
+	body := string(resp.Data[logical.HTTPRawBody].([]byte))
+	for _, expected := range []string{
+		"vault_jwt_oidc_circuit_breaker_open 0\n",
+		`vault_jwt_token_validation_duration_seconds_bucket{role="plugin-test",le="+Inf"} 1` + "\n",
+		`vault_jwt_token_validation_duration_seconds_count{role="plugin-test"} 1` + "\n",
+		`vault_jwt_auth_success_total{role="plugin-test"} 1` + "\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("expected %q in metrics:\n%s", expected, body)
+		}
+	}
+
+	if strings.Contains(body, "vault_jwt_oidc_provider_up") || strings.Contains(body, "jwtauth_provider_health") {
+		t.Fatalf("unexpected provider health metrics without a health check:\n%s", body)
+	}
+}
+
+func TestMetrics_ProviderHealth(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{audience: true})
+	backend := b.Backend.(*jwtAuthBackend)
+
+	readMetrics := func() string {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation:  logical.ReadOperation,
+			Path:       "oidc/metrics",
+			MountPoint: "auth/jwt/",
+			Storage:    storage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(resp.Data[logical.HTTPRawBody].([]byte))
+	}
+
+	for status, expected := range map[string][]string{
+		"ok": {
+			`jwtauth_provider_health{mount="auth/jwt/",status="ok"} 1` + "\n",
+			`jwtauth_provider_health{mount="auth/jwt/",status="error"} 0` + "\n",
+			"vault_jwt_oidc_provider_up 1\n",
+		},
+		"error": {
+			`jwtauth_provider_health{mount="auth/jwt/",status="ok"} 0` + "\n",
+			`jwtauth_provider_health{mount="auth/jwt/",status="error"} 1` + "\n",
+			"vault_jwt_oidc_provider_up 0\n",
+		},
+	} {
+		backend.healthLock.Lock()
+		backend.providerHealth = &providerHealth{status: status, lastChecked: time.Now()}
+		backend.healthLock.Unlock()
+
+		body := readMetrics()
+		for _, e := range expected {
+			if !strings.Contains(body, e) {
+				t.Fatalf("expected %q in metrics:\n%s", e, body)
+			}
+		}
+	}
+}
+
+func TestMetrics_AuthEvents(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{audience: true})
+	metrics := b.Backend.(*jwtAuthBackend).authMetrics
+
+	req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+	if count := metrics.count(metricJWTAuthSuccess, "plugin-test", ""); count != 1 {
+		t.Fatalf("expected 1 successful login, got %d", count)
+	}
+
+	req = setupLogin(t, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour), time.Time{}, b, storage)
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || !resp.IsError() {
+		t.Fatalf("expected expired token error, err:%v resp:%#v\n", err, resp)
+	}
+	if count := metrics.count(metricJWTAuthFailure, "plugin-test", failureExpired); count != 1 {
+		t.Fatalf("expected 1 expired login failure, got %d", count)
+	}
+	if count := metrics.count(metricJWTAuthSuccess, "plugin-test", ""); count != 1 {
+		t.Fatalf("expected 1 successful login, got %d", count)
+	}
+
+	// Alias lookaheads are not logins.
+	req = setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+	req.Operation = logical.AliasLookaheadOperation
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if count := metrics.count(metricJWTAuthSuccess, "plugin-test", ""); count != 1 {
+		t.Fatalf("expected 1 successful login, got %d", count)
+	}
+}
+
+func TestMetrics_OIDCEvents(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+	backend := b.(*jwtAuthBackend)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "oidc/auth_url",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role":         "test",
+			"redirect_uri": "https://example.com",
+		},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+
+	authURL := resp.Data["auth_url"].(string)
+	s.customClaims = sampleClaims(getQueryParam(t, authURL, "nonce"))
+	s.code = "abc"
+
+	callback := func(code string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "oidc/callback",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"state": getQueryParam(t, authURL, "state"),
+				"code":  code,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := callback("abc"); resp.IsError() {
+		t.Fatalf("unexpected error: %v", resp.Error())
+	}
+
+	if count := backend.authMetrics.count(metricOIDCAuthURLRequest, "test", ""); count != 1 {
+		t.Fatalf("expected 1 auth URL request, got %d", count)
+	}
+	if count := backend.authMetrics.count(metricOIDCCallbackSuccess, "test", ""); count != 1 {
+		t.Fatalf("expected 1 successful callback, got %d", count)
+	}
+
+	// Two provider requests are made: the code exchange and userinfo.
+	backend.providerMetrics.l.Lock()
+	h := backend.providerMetrics.byRole["test"]
+	backend.providerMetrics.l.Unlock()
+	if h == nil || h.count != 2 {
+		t.Fatalf("expected 2 provider requests, got %#v", h)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "oidc/metrics",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(resp.Data[logical.HTTPRawBody].([]byte))
+	for _, expected := range []string{
+		`vault_oidc_auth_url_request_total{role="test"} 1` + "\n",
+		`vault_oidc_callback_success_total{role="test"} 1` + "\n",
+		`vault_oidc_provider_request_duration_seconds_count{role="test"} 2` + "\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("expected %q in metrics:\n%s", expected, body)
+		}
+	}
+}
+
+func TestLoginFailureReason(t *testing.T) {
+	for msg, reason := range map[string]string{
+		"error validating claims: square/go-jose/jwt: validation failed, token is expired (exp)": failureExpired,
+		"no known key successfully validated the token signature":                                failureInvalidSignature,
+		"error fetching jwks keyset: connection refused":                                         failureProviderError,
+		`claim "color" does not match any associated bound claim values`:                         failureBoundClaims,
+		"missing token": failureOther,
+	} {
+		if got := loginFailureReason(msg); got != reason {
+			t.Fatalf("expected reason %q for %q, got %q", reason, msg, got)
+		}
+	}
+}
@@ -2,21 +2,32 @@ package jwtauth
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
+	"net/http"
 	"net/url"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/coreos/go-oidc"
 	"github.com/hashicorp/errwrap"
-	"github.com/hashicorp/go-uuid"
+	log "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/cidrutil"
 	"github.com/hashicorp/vault/sdk/helper/consts"
 	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"golang.org/x/oauth2"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
 )
 
 var oidcStateTimeout = 10 * time.Minute
@@ -26,6 +37,15 @@ var oidcStateTimeout = 10 * time.Minute
 const errLoginFailed = "Vault login failed."
 const errNoResponse = "No response from provider."
 const errTokenVerification = "Token verification failed."
+const errAuthTimeTooOld = "Authentication is too old."
+
+// validPrompts are the prompt values defined by OpenID Connect Core, section
+// 3.1.2.1.
+var validPrompts = []string{"none", "login", "consent", "select_account"}
+
+// oidcStateCookieName is the name of the cookie set when oidc_use_state_cookie
+// is enabled.
+const oidcStateCookieName = "vault-oidc-state"
 
 // oidcState is created when an authURL is requested. The state identifier is
 // passed throughout the OAuth process.
@@ -33,6 +53,16 @@ type oidcState struct {
 	rolename    string
 	nonce       string
 	redirectURI string
+	clientIP    string
+	inlineData  string
+
+	// codeChallenge is the PKCE code challenge, if any, that the code
+	// verifier provided to the callback must match
+	codeChallenge string
+
+	// powDifficulty is the number of leading zero bits the proof-of-work
+	// solution provided to the callback must have, if any
+	powDifficulty int
 }
 
 func pathOIDC(b *jwtAuthBackend) []*framework.Path {
@@ -46,6 +76,24 @@ func pathOIDC(b *jwtAuthBackend) []*framework.Path {
 				"code": {
 					Type: framework.TypeString,
 				},
+				"id_token": {
+					Type: framework.TypeString,
+				},
+				"access_token": {
+					Type: framework.TypeString,
+				},
+				"code_verifier": {
+					Type: framework.TypeString,
+				},
+				"pow_solution": {
+					Type: framework.TypeString,
+				},
+				"error": {
+					Type: framework.TypeString,
+				},
+				"error_description": {
+					Type: framework.TypeString,
+				},
 			},
 
 			Operations: map[logical.Operation]framework.OperationHandler{
@@ -66,6 +114,27 @@ func pathOIDC(b *jwtAuthBackend) []*framework.Path {
 					Type:        framework.TypeString,
 					Description: "The OAuth redirect_uri to use in the authorization URL.",
 				},
+				"login_hint": {
+					Type:        framework.TypeString,
+					Description: "Optional login_hint to pass to the provider in the authorization URL.",
+				},
+				"prompt": {
+					Type:        framework.TypeString,
+					Description: "Optional prompt value to pass to the provider in the authorization URL: one of 'none', 'login', 'consent' or 'select_account'.",
+				},
+				"inline_data": {
+					Type:        framework.TypeString,
+					Description: "Optional base64-encoded application data returned in the token metadata after login. Requires oidc_inline_data_max_bytes.",
+				},
+				"code_challenge": {
+					Type:        framework.TypeString,
+					Description: "Optional PKCE code challenge to pass to the provider. The matching code_verifier must be provided to the callback.",
+				},
+				"code_challenge_method": {
+					Type:        framework.TypeString,
+					Description: "The PKCE code challenge method. Only 'S256' is supported.",
+					Default:     pkceMethodS256,
+				},
 			},
 			Operations: map[logical.Operation]framework.OperationHandler{
 				logical.UpdateOperation: &framework.PathOperation{
@@ -77,14 +146,40 @@ func pathOIDC(b *jwtAuthBackend) []*framework.Path {
 	}
 }
 
-func (b *jwtAuthBackend) pathCallback(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+func (b *jwtAuthBackend) pathCallback(ctx context.Context, req *logical.Request, d *framework.FieldData) (resp *logical.Response, retErr error) {
 
 	// Because the state is cached, don't process OIDC logins on perf standbys
 	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
 		return nil, logical.ErrReadOnly
 	}
 
-	state := b.verifyState(d.Get("state").(string))
+	defer func() {
+		if retErr != nil || (resp != nil && resp.IsError()) {
+			b.callbackFailures.record(time.Now())
+		}
+	}()
+
+	stateID := d.Get("state").(string)
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse(errLoginFailed + " Could not load configuration"), nil
+	}
+
+	// The state is deleted once verified, so the cookie is checked first:
+	// a request without the browser's cookie must not consume the state of
+	// a login in progress.
+	if config.OIDCUseStateCookie && !validStateCookie(req.Headers, stateID) {
+		return logical.ErrorResponse(errLoginFailed + " OAuth state cookie is missing or does not match."), nil
+	}
+
+	state, err := b.verifyState(ctx, req.Storage, stateID)
+	if err != nil {
+		return nil, err
+	}
 	if state == nil {
 		return logical.ErrorResponse(errLoginFailed + " Expired or missing OAuth state."), nil
 	}
@@ -98,12 +193,28 @@ func (b *jwtAuthBackend) pathCallback(ctx context.Context, req *logical.Request,
 		return logical.ErrorResponse(errLoginFailed + " Role could not be found"), nil
 	}
 
-	config, err := b.config(ctx, req.Storage)
-	if err != nil {
-		return nil, err
+	defer b.validationMetrics.observe(roleName, time.Now())
+	defer func() {
+		b.authMetrics.recordResult(metricOIDCCallbackSuccess, metricOIDCCallbackFailure, roleName, resp, retErr)
+	}()
+
+	if providerErr := d.Get("error").(string); providerErr != "" {
+		if msg, ok := config.OIDCErrorMapping[providerErr]; ok {
+			return logical.ErrorResponse("%s %s", errLoginFailed, msg), nil
+		}
+		if desc := d.Get("error_description").(string); desc != "" {
+			return logical.ErrorResponse("%s Provider returned error %q: %s", errLoginFailed, providerErr, desc), nil
+		}
+		return logical.ErrorResponse("%s Provider returned error %q.", errLoginFailed, providerErr), nil
 	}
-	if config == nil {
-		return logical.ErrorResponse(errLoginFailed + " Could not load configuration"), nil
+
+	// The solution is checked before any request is made to the provider.
+	if state.powDifficulty > 0 && !validPoWSolution(stateID, d.Get("pow_solution").(string), state.powDifficulty) {
+		return logical.ErrorResponse(errLoginFailed + " Missing or invalid proof-of-work solution."), nil
+	}
+
+	if config.OIDCBindIPToState && (req.Connection == nil || req.Connection.RemoteAddr != state.clientIP) {
+		return logical.ErrorResponse(errLoginFailed + " Client address does not match the address that requested the authorization URL."), nil
 	}
 
 	if len(role.TokenBoundCIDRs) > 0 {
@@ -116,12 +227,20 @@ func (b *jwtAuthBackend) pathCallback(ctx context.Context, req *logical.Request,
 		}
 	}
 
+	if resp, err := b.checkCountry(config, role, req); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if err := b.providerBreaker.allow(config); err != nil {
+		return logical.ErrorResponse("%s %s", errLoginFailed, err.Error()), nil
+	}
+
 	provider, err := b.getProvider(config)
 	if err != nil {
 		return nil, errwrap.Wrapf("error getting provider for login operation: {{err}}", err)
 	}
 
-	oidcCtx, err := b.createCAContext(ctx, config.OIDCDiscoveryCAPEM)
+	oidcCtx, err := b.createCAContext(ctx, config, config.OIDCDiscoveryCAPEM)
 	if err != nil {
 		return nil, errwrap.Wrapf("error preparing context for login operation: {{err}}", err)
 	}
@@ -134,20 +253,65 @@ func (b *jwtAuthBackend) pathCallback(ctx context.Context, req *logical.Request,
 		Scopes:       []string{oidc.ScopeOpenID},
 	}
 
-	code := d.Get("code").(string)
-	if code == "" {
-		return logical.ErrorResponse(errLoginFailed + " OAuth code parameter not provided"), nil
-	}
+	var oauth2Token *oauth2.Token
+	var rawToken string
+	if role.OIDCFlow == oidcFlowImplicit {
+		b.Logger().Warn("using deprecated OIDC implicit flow", "role", roleName)
 
-	oauth2Token, err := oauth2Config.Exchange(oidcCtx, code)
-	if err != nil {
-		return logical.ErrorResponse(errLoginFailed+" Error exchanging oidc code: %q.", err.Error()), nil
-	}
+		// In the implicit flow the tokens are returned directly by the
+		// authorization endpoint.
+		rawToken = d.Get("id_token").(string)
+		if rawToken == "" {
+			return logical.ErrorResponse(errLoginFailed + " OAuth id_token parameter not provided"), nil
+		}
+		if accessToken := d.Get("access_token").(string); accessToken != "" {
+			oauth2Token = &oauth2.Token{
+				AccessToken: accessToken,
+				TokenType:   "Bearer",
+			}
+		}
+	} else {
+		code := d.Get("code").(string)
+		if code == "" {
+			return logical.ErrorResponse(errLoginFailed + " OAuth code parameter not provided"), nil
+		}
 
-	// Extract the ID Token from OAuth2 token.
-	rawToken, ok := oauth2Token.Extra("id_token").(string)
-	if !ok {
-		return logical.ErrorResponse(errTokenVerification + " No id_token found in response."), nil
+		var exchangeOpts []oauth2.AuthCodeOption
+		if state.codeChallenge != "" {
+			codeVerifier := d.Get("code_verifier").(string)
+			if codeVerifier == "" {
+				return logical.ErrorResponse(errLoginFailed + " PKCE code_verifier parameter not provided"), nil
+			}
+			if pkceChallenge(codeVerifier) != state.codeChallenge {
+				return logical.ErrorResponse(errLoginFailed + " PKCE code_verifier does not match code_challenge"), nil
+			}
+			exchangeOpts = append(exchangeOpts, oauth2.SetAuthURLParam("code_verifier", codeVerifier))
+		}
+
+		clientAuthOpts, err := clientAuthOptions(ctx, req.Storage, role, roleName, &oauth2Config)
+		if err != nil {
+			return nil, err
+		}
+		exchangeOpts = append(exchangeOpts, clientAuthOpts...)
+
+		exchangeStart := time.Now()
+		oauth2Token, err = oauth2Config.Exchange(oidcCtx, code, exchangeOpts...)
+		b.providerMetrics.observe(roleName, exchangeStart)
+		b.providerBreaker.record(config, isProviderUnreachable(err))
+		if err != nil {
+			return logical.ErrorResponse(errLoginFailed+" Error exchanging oidc code: %q.", err.Error()), nil
+		}
+
+		if missing := missingScopes(role.RequiredScopes, oauth2Token.Extra("scope")); len(missing) > 0 {
+			return logical.ErrorResponse(errLoginFailed+" Required scopes were not granted: %s.", strings.Join(missing, ", ")), nil
+		}
+
+		// Extract the ID Token from OAuth2 token.
+		var ok bool
+		rawToken, ok = oauth2Token.Extra("id_token").(string)
+		if !ok {
+			return logical.ErrorResponse(errTokenVerification + " No id_token found in response."), nil
+		}
 	}
 	if role.VerboseOIDCLogging {
 		b.Logger().Debug("OIDC provider response", "ID token", rawToken)
@@ -164,17 +328,52 @@ func (b *jwtAuthBackend) pathCallback(ctx context.Context, req *logical.Request,
 	}
 	delete(allClaims, "nonce")
 
+	if role.MaxAge > 0 {
+		if err := validateAuthTime(allClaims, time.Now(), role.MaxAge, config.JWTClockSkewLeeway); err != nil {
+			if err == errAuthTimeExceeded {
+				return logical.ErrorResponse("%s The login must be within %s of authenticating with the provider.", errAuthTimeTooOld, role.MaxAge), nil
+			}
+			return logical.ErrorResponse("%s %s", errTokenVerification, err.Error()), nil
+		}
+	}
+
+	// An access token received through the browser must be bound to the ID
+	// token before it is used.
+	if role.OIDCFlow == oidcFlowImplicit && oauth2Token != nil {
+		if err := validateAccessTokenHash(rawToken, oauth2Token.AccessToken, allClaims); err != nil {
+			return logical.ErrorResponse("%s %s", errTokenVerification, err.Error()), nil
+		}
+	}
+
+	if role.UseAccessTokenClaims && oauth2Token != nil {
+		mergeAccessTokenClaims(b.Logger(), oauth2Token.AccessToken, allClaims)
+	}
+
 	// Attempt to fetch information from the /userinfo endpoint and merge it with
 	// the existing claims data. A failure to fetch additional information from this
-	// endpoint will not invalidate the authorization flow.
-	if userinfo, err := provider.UserInfo(oidcCtx, oauth2.StaticTokenSource(oauth2Token)); err == nil {
-		_ = userinfo.Claims(&allClaims)
-	} else {
-		logFunc := b.Logger().Warn
-		if strings.Contains(err.Error(), "user info endpoint is not supported") {
-			logFunc = b.Logger().Info
+	// endpoint will not invalidate the authorization flow, unless the role sets
+	// fetch_groups_from_userinfo.
+	var tokenSource oauth2.TokenSource
+	if oauth2Token != nil {
+		tokenSource = oauth2.StaticTokenSource(oauth2Token)
+	}
+	if role.FetchGroupsFromUserinfo {
+		if err := b.mergeRoleUserinfo(oidcCtx, provider, role, roleName, tokenSource, allClaims); err != nil {
+			return logical.ErrorResponse("%s %s", errLoginFailed, err.Error()), nil
+		}
+	} else if tokenSource != nil {
+		userinfoStart := time.Now()
+		userinfo, err := provider.UserInfo(oidcCtx, tokenSource)
+		b.providerMetrics.observe(roleName, userinfoStart)
+		if err == nil {
+			_ = userinfo.Claims(&allClaims)
+		} else {
+			logFunc := b.Logger().Warn
+			if strings.Contains(err.Error(), "user info endpoint is not supported") {
+				logFunc = b.Logger().Info
+			}
+			logFunc("error reading /userinfo endpoint", "error", err)
 		}
-		logFunc("error reading /userinfo endpoint", "error", err)
 	}
 
 	if role.VerboseOIDCLogging {
@@ -185,43 +384,17 @@ func (b *jwtAuthBackend) pathCallback(ctx context.Context, req *logical.Request,
 		}
 	}
 
-	if err := validateBoundClaims(b.Logger(), role.BoundClaimsType, role.BoundClaims, allClaims); err != nil {
-		return logical.ErrorResponse("error validating claims: %s", err.Error()), nil
+	resp, err = b.loginResponse(ctx, req, config, role, roleName, allClaims, tokenSource)
+	if err != nil || resp.IsError() {
+		return resp, err
 	}
-
-	alias, groupAliases, err := b.createIdentity(allClaims, role)
-	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+	if state.inlineData != "" {
+		resp.Auth.Metadata["inline_data"] = state.inlineData
 	}
+	b.tokenStats.record(roleName, time.Now())
 
-	tokenMetadata := map[string]string{"role": roleName}
-	for k, v := range alias.Metadata {
-		tokenMetadata[k] = v
-	}
-
-	auth := &logical.Auth{
-		Policies:     role.Policies,
-		DisplayName:  alias.Name,
-		Period:       role.Period,
-		NumUses:      role.NumUses,
-		Alias:        alias,
-		GroupAliases: groupAliases,
-		InternalData: map[string]interface{}{
-			"role": roleName,
-		},
-		Metadata: tokenMetadata,
-		LeaseOptions: logical.LeaseOptions{
-			Renewable: true,
-			TTL:       role.TTL,
-			MaxTTL:    role.MaxTTL,
-		},
-		BoundCIDRs: role.BoundCIDRs,
-	}
-
-	role.PopulateTokenAuth(auth)
-
-	resp := &logical.Response{
-		Auth: auth,
+	if role.OIDCFlow == oidcFlowImplicit {
+		resp.AddWarning("This login used the deprecated and insecure OIDC implicit flow.")
 	}
 
 	return resp, nil
@@ -271,6 +444,11 @@ func (b *jwtAuthBackend) authURL(ctx context.Context, req *logical.Request, d *f
 		return logical.ErrorResponse("missing redirect_uri"), nil
 	}
 
+	roleName, err = b.canonicalRoleName(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+
 	role, err := b.role(ctx, req.Storage, roleName)
 	if err != nil {
 		return nil, err
@@ -279,11 +457,17 @@ func (b *jwtAuthBackend) authURL(ctx context.Context, req *logical.Request, d *f
 		return logical.ErrorResponse("role %q could not be found", roleName), nil
 	}
 
-	if !validRedirect(redirectURI, role.AllowedRedirectURIs) {
+	b.authMetrics.inc(metricOIDCAuthURLRequest, roleName, "")
+
+	if !role.redirectAllowed(redirectURI) {
 		logger.Warn("unauthorized redirect_uri", "redirect_uri", redirectURI)
 		return resp, nil
 	}
 
+	if err := b.providerBreaker.allow(config); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
 	provider, err := b.getProvider(config)
 	if err != nil {
 		logger.Warn("error getting provider for login operation", "error", err)
@@ -292,6 +476,18 @@ func (b *jwtAuthBackend) authURL(ctx context.Context, req *logical.Request, d *f
 
 	// "openid" is a required scope for OpenID Connect flows
 	scopes := append([]string{oidc.ScopeOpenID}, role.OIDCScopes...)
+	for _, scope := range role.RequiredScopes {
+		if !strutil.StrListContains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	// Only request offline_access if the provider advertises it, since some
+	// providers reject authorization requests with unknown scopes.
+	if role.AllowOfflineAccess && !strutil.StrListContains(scopes, oidc.ScopeOfflineAccess) &&
+		providerSupportsScope(provider, oidc.ScopeOfflineAccess) {
+		scopes = append(scopes, oidc.ScopeOfflineAccess)
+	}
 
 	// Configure an OpenID Connect aware OAuth2 client
 	oauth2Config := oauth2.Config{
@@ -302,35 +498,286 @@ func (b *jwtAuthBackend) authURL(ctx context.Context, req *logical.Request, d *f
 		Scopes:       scopes,
 	}
 
-	stateID, nonce, err := b.createState(roleName, redirectURI)
+	// The callback must come from the same address if oidc_bind_ip_to_state
+	// is set.
+	var clientIP string
+	if config.OIDCBindIPToState {
+		if req.Connection == nil || req.Connection.RemoteAddr == "" {
+			logger.Warn("oidc_bind_ip_to_state is set but no connection information is available")
+			return resp, nil
+		}
+		clientIP = req.Connection.RemoteAddr
+	}
+
+	inlineData := d.Get("inline_data").(string)
+	if inlineData != "" {
+		decoded, err := base64.StdEncoding.DecodeString(inlineData)
+		if err != nil {
+			return logical.ErrorResponse("inline_data must be base64 encoded"), nil
+		}
+		if len(decoded) > config.OIDCInlineDataMaxBytes {
+			return logical.ErrorResponse("inline_data exceeds oidc_inline_data_max_bytes (%d)", config.OIDCInlineDataMaxBytes), nil
+		}
+	}
+
+	codeChallenge := d.Get("code_challenge").(string)
+	if role.OIDCFlow == oidcFlowCode {
+		switch {
+		case codeChallenge != "":
+			if method := d.Get("code_challenge_method").(string); method != pkceMethodS256 {
+				return logical.ErrorResponse("unsupported code_challenge_method %q, must be %q", method, pkceMethodS256), nil
+			}
+		case role.PKCERequired:
+			return logical.ErrorResponse("role %q requires PKCE, but no code_challenge was provided", roleName), nil
+		default:
+			logger.Warn("OIDC login without PKCE; logins without PKCE are deprecated, set pkce_required on the role to enforce it", "role", roleName)
+		}
+	}
+
+	powDifficulty := b.powDifficulty(config)
+	stateID, nonce, err := b.createState(ctx, req.Storage, config, roleName, redirectURI, clientIP, inlineData, codeChallenge, powDifficulty)
 	if err != nil {
 		logger.Warn("error generating OAuth state", "error", err)
 		return resp, nil
 	}
 
-	resp.Data["auth_url"] = oauth2Config.AuthCodeURL(stateID, oidc.Nonce(nonce))
+	authCodeOpts := []oauth2.AuthCodeOption{oidc.Nonce(nonce)}
+	if loginHint := d.Get("login_hint").(string); loginHint != "" {
+		authCodeOpts = append(authCodeOpts, oauth2.SetAuthURLParam("login_hint", loginHint))
+	}
+	if prompt := d.Get("prompt").(string); prompt != "" {
+		if !strutil.StrListContains(validPrompts, prompt) {
+			return logical.ErrorResponse("invalid prompt %q, must be one of %s", prompt, strings.Join(validPrompts, ", ")), nil
+		}
+		authCodeOpts = append(authCodeOpts, oauth2.SetAuthURLParam("prompt", prompt))
+	}
+	if role.MaxAge > 0 {
+		authCodeOpts = append(authCodeOpts, oauth2.SetAuthURLParam("max_age", strconv.FormatInt(int64(role.MaxAge.Seconds()), 10)))
+	}
+	if len(role.BoundACRValues) > 0 {
+		authCodeOpts = append(authCodeOpts, oauth2.SetAuthURLParam("acr_values", strings.Join(role.BoundACRValues, " ")))
+	}
+	if codeChallenge != "" {
+		authCodeOpts = append(authCodeOpts,
+			oauth2.SetAuthURLParam("code_challenge", codeChallenge),
+			oauth2.SetAuthURLParam("code_challenge_method", pkceMethodS256))
+	}
+	if role.OIDCFlow == oidcFlowImplicit {
+		logger.Warn("using deprecated OIDC implicit flow", "role", roleName)
+		authCodeOpts = append(authCodeOpts, oauth2.SetAuthURLParam("response_type", "id_token token"))
+	}
+
+	authURL := oauth2Config.AuthCodeURL(stateID, authCodeOpts...)
+	if config.OIDCRequestParameterObject {
+		key, err := parseEncryptionKey(config.OIDCRequestObjectSigningKey)
+		if err != nil {
+			logger.Warn("error parsing request object signing key", "error", err)
+			return resp, nil
+		}
+
+		var metadata struct {
+			Issuer string `json:"issuer"`
+		}
+		if err := provider.Claims(&metadata); err != nil {
+			logger.Warn("error reading provider issuer", "error", err)
+			return resp, nil
+		}
+
+		var encryptionKey interface{}
+		var encryptionAlg jose.KeyAlgorithm
+		if config.OIDCJAREncryptionKeyPEM != "" {
+			if encryptionKey, encryptionAlg, err = requestObjectEncryptionKey(config.OIDCJAREncryptionKeyPEM); err != nil {
+				logger.Warn("error parsing request object encryption key", "error", err)
+				return resp, nil
+			}
+		}
+
+		if authURL, err = requestObjectAuthURL(key, encryptionKey, encryptionAlg, metadata.Issuer, authURL); err != nil {
+			logger.Warn("error creating request object", "error", err)
+			return resp, nil
+		}
+	}
+	if config.OIDCSignAuthURL {
+		key, err := b.authURLSigningKey(ctx, req.Storage)
+		if err != nil {
+			logger.Warn("error loading auth URL signing key", "error", err)
+			return resp, nil
+		}
+		authURL = signAuthURL(key, authURL)
+	}
+	resp.Data["auth_url"] = authURL
+
+	// The state is the puzzle, so that a solution is only valid for its
+	// login.
+	if powDifficulty > 0 {
+		resp.Data["pow_puzzle"] = stateID
+		resp.Data["pow_difficulty"] = powDifficulty
+	}
+
+	if config.OIDCUseStateCookie {
+		cookie := &http.Cookie{
+			Name:     oidcStateCookieName,
+			Value:    stateID,
+			Path:     "/",
+			MaxAge:   int(config.nonceTTL().Seconds()),
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		}
+		resp.Headers = map[string][]string{
+			"Set-Cookie": {cookie.String()},
+		}
+	}
 
 	return resp, nil
 }
 
+// providerSupportsScope checks whether scope is listed in the provider
+// discovery document's scopes_supported.
+func providerSupportsScope(provider *oidc.Provider, scope string) bool {
+	var metadata struct {
+		ScopesSupported []string `json:"scopes_supported"`
+	}
+	if err := provider.Claims(&metadata); err != nil {
+		return false
+	}
+
+	return strutil.StrListContains(metadata.ScopesSupported, scope)
+}
+
+// missingScopes returns the required scopes not included in the scope
+// parameter of a token response. A response without a scope parameter grants
+// the requested scopes (RFC 6749 section 5.1), so nothing is missing.
+func missingScopes(required []string, granted interface{}) []string {
+	grantedScope, ok := granted.(string)
+	if !ok {
+		return nil
+	}
+
+	grantedScopes := strings.Fields(grantedScope)
+	var missing []string
+	for _, scope := range required {
+		if !strutil.StrListContains(grantedScopes, scope) {
+			missing = append(missing, scope)
+		}
+	}
+
+	return missing
+}
+
+// validStateCookie checks whether the request headers carry a state cookie
+// matching stateID.
+func validStateCookie(headers map[string][]string, stateID string) bool {
+	r := &http.Request{Header: http.Header(headers)}
+	cookie, err := r.Cookie(oidcStateCookieName)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(stateID)) == 1
+}
+
+// validateAccessTokenHash checks the at_hash claim of the ID token against
+// accessToken as described in OpenID Connect Core, section 3.2.2.9.
+func validateAccessTokenHash(rawIDToken, accessToken string, allClaims map[string]interface{}) error {
+	atHash, ok := allClaims["at_hash"].(string)
+	if !ok {
+		return errors.New("ID token has no at_hash claim for the access token")
+	}
+
+	parsed, err := jwt.ParseSigned(rawIDToken)
+	if err != nil {
+		return err
+	}
+	if len(parsed.Headers) == 0 {
+		return errors.New("ID token has no header")
+	}
+
+	var h hash.Hash
+	switch alg := parsed.Headers[0].Algorithm; alg {
+	case "RS256", "ES256", "PS256":
+		h = sha256.New()
+	case "RS384", "ES384", "PS384":
+		h = sha512.New384()
+	case "RS512", "ES512", "PS512":
+		h = sha512.New()
+	case "EdDSA":
+		// Ed25519 is the only EdDSA curve supported, and it hashes with
+		// SHA-512.
+		h = sha512.New()
+	default:
+		return fmt.Errorf("unsupported ID token algorithm %q for at_hash", alg)
+	}
+
+	h.Write([]byte(accessToken))
+	sum := h.Sum(nil)
+	expected := base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+	if subtle.ConstantTimeCompare([]byte(atHash), []byte(expected)) != 1 {
+		return errors.New("access token does not match at_hash claim")
+	}
+
+	return nil
+}
+
+// mergeAccessTokenClaims adds the claims of accessToken to allClaims if it is
+// a JWT. Existing claims are not overwritten. The access token is not
+// verified: it was received from the token endpoint or, in the implicit flow,
+// bound to the verified ID token by at_hash.
+func mergeAccessTokenClaims(logger log.Logger, accessToken string, allClaims map[string]interface{}) {
+	parsed, err := jwt.ParseSigned(accessToken)
+	if err != nil {
+		logger.Debug("access token is not a JWT, skipping its claims")
+		return
+	}
+
+	var accessClaims map[string]interface{}
+	if err := parsed.UnsafeClaimsWithoutVerification(&accessClaims); err != nil {
+		logger.Warn("unable to parse access token claims", "error", err)
+		return
+	}
+
+	for k, v := range accessClaims {
+		if _, ok := allClaims[k]; !ok {
+			allClaims[k] = v
+		}
+	}
+}
+
 // createState make an expiring state object, associated with a random state ID
 // that is passed throughout the OAuth process. A nonce is also included in the
 // auth process, and for simplicity will be identical in length/format as the state ID.
-func (b *jwtAuthBackend) createState(rolename, redirectURI string) (string, string, error) {
-	// Get enough bytes for 2 160-bit IDs (per rfc6749#section-10.10)
-	bytes, err := uuid.GenerateRandomBytes(2 * 20)
+// Both expire after the config's nonce_ttl. If the config uses the vault-storage state backend, the state is also
+// written to storage.
+func (b *jwtAuthBackend) createState(ctx context.Context, s logical.Storage, config *jwtConfig, rolename, redirectURI, clientIP, inlineData, codeChallenge string, powDifficulty int) (string, string, error) {
+	// A failure of the entropy source fails the request rather than
+	// producing a guessable state.
+	stateID, err := randomStateValue(config.stateParamBits())
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomStateValue(defaultStateParamBits)
 	if err != nil {
 		return "", "", err
 	}
 
-	stateID := fmt.Sprintf("%x", bytes[:20])
-	nonce := fmt.Sprintf("%x", bytes[20:])
+	state := &oidcState{
+		rolename:      rolename,
+		nonce:         nonce,
+		redirectURI:   redirectURI,
+		clientIP:      clientIP,
+		inlineData:    inlineData,
+		codeChallenge: codeChallenge,
+		powDifficulty: powDifficulty,
+	}
+	ttl := config.nonceTTL()
+	b.oidcStates.Set(stateID, state, ttl)
 
-	b.oidcStates.SetDefault(stateID, &oidcState{
-		rolename:    rolename,
-		nonce:       nonce,
-		redirectURI: redirectURI,
-	})
+	if config.OIDCDistributedStateBackend == stateBackendStorage {
+		if err := storeState(ctx, s, stateID, state, ttl); err != nil {
+			b.oidcStates.Delete(stateID)
+			return "", "", err
+		}
+	}
 
 	return stateID, nonce, nil
 }
@@ -338,12 +785,121 @@ func (b *jwtAuthBackend) createState(rolename, redirectURI string) (string, stri
 // verifyState tests whether the provided state ID is valid and returns the
 // associated state object if so. A nil state is returned if the ID is not found
 // or expired. The state should only ever be retrieved once and is deleted as
-// part of this request.
-func (b *jwtAuthBackend) verifyState(stateID string) *oidcState {
+// part of this request. States not found in memory are looked up in storage if
+// the config uses the vault-storage state backend.
+func (b *jwtAuthBackend) verifyState(ctx context.Context, s logical.Storage, stateID string) (*oidcState, error) {
 	defer b.oidcStates.Delete(stateID)
 
+	config, err := b.config(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	storageBacked := config != nil && config.OIDCDistributedStateBackend == stateBackendStorage
+
 	if stateRaw, ok := b.oidcStates.Get(stateID); ok {
-		return stateRaw.(*oidcState)
+		if storageBacked {
+			if _, err := b.takeStoredState(ctx, s, stateID); err != nil {
+				return nil, err
+			}
+		}
+		return stateRaw.(*oidcState), nil
+	}
+
+	if storageBacked && stateID != "" {
+		return b.takeStoredState(ctx, s, stateID)
+	}
+
+	return nil, nil
+}
+
+// Values of a role's redirect_uri_match_type.
+const (
+	redirectURIMatchExact = "exact"
+	redirectURIMatchGlob  = "glob"
+)
+
+// redirectAllowed checks whether uri is allowed by the role's
+// allowed_redirect_uris. With the glob match type, entries containing '*' are
+// patterns whose host may contain wildcards. See redirectPatternMatch.
+func (r *jwtRole) redirectAllowed(uri string) bool {
+	if validRedirect(uri, r.AllowedRedirectURIs) {
+		return true
+	}
+	if r.RedirectURIMatchType != redirectURIMatchGlob {
+		return false
+	}
+
+	for _, pattern := range r.AllowedRedirectURIs {
+		if !strings.Contains(pattern, "*") {
+			continue
+		}
+		if redirectPatternMatch(pattern, uri) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redirectPatternMatch checks whether uri matches a glob entry of
+// allowed_redirect_uris. The scheme, port, path, query and fragment must be
+// the same as the pattern's, so a query or fragment the pattern lacks is
+// rejected. Only the host is matched with '*', which doesn't cross a '.'.
+func redirectPatternMatch(pattern, uri string) bool {
+	p, err := url.Parse(pattern)
+	if err != nil {
+		return false
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+
+	if u.Scheme != p.Scheme || u.User != nil || u.Port() != p.Port() ||
+		u.EscapedPath() != p.EscapedPath() ||
+		u.RawQuery != p.RawQuery || u.ForceQuery != p.ForceQuery ||
+		u.EscapedFragment() != p.EscapedFragment() {
+		return false
+	}
+
+	patternLabels := strings.Split(strings.ToLower(p.Hostname()), ".")
+	hostLabels := strings.Split(strings.ToLower(u.Hostname()), ".")
+	if len(patternLabels) != len(hostLabels) {
+		return false
+	}
+	for i, label := range patternLabels {
+		if ok, _ := path.Match(label, hostLabels[i]); !ok || hostLabels[i] == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkRedirectURIPattern checks that a glob entry of allowed_redirect_uris
+// is an absolute URL with wildcards only in its host, and that at least the
+// last two labels of the host are fixed, so that the pattern can't match
+// hosts of any domain.
+func checkRedirectURIPattern(pattern string) error {
+	p, err := url.Parse(pattern)
+	if err != nil {
+		return err
+	}
+	if p.Scheme == "" || p.Host == "" {
+		return errors.New("must be an absolute URL")
+	}
+
+	if p.User != nil || strings.Contains(p.Scheme+p.Port()+p.EscapedPath()+p.RawQuery+p.EscapedFragment(), "*") {
+		return errors.New("'*' is only allowed in the host")
+	}
+	labels := strings.Split(p.Hostname(), ".")
+	if len(labels) < 3 || strings.Contains(labels[len(labels)-1], "*") || strings.Contains(labels[len(labels)-2], "*") {
+		return errors.New("the last two labels of the host can't contain '*'")
+	}
+	for _, label := range labels {
+		if _, err := path.Match(label, ""); err != nil {
+			return err
+		}
 	}
 
 	return nil
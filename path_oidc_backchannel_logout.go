@@ -0,0 +1,270 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// sessionIDMetadata is the token metadata key holding the "sid" claim
+	// of the token used to log in.
+	sessionIDMetadata = "oidc_session_id"
+
+	backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+	loggedOutSessionPrefix = "logout/sid/"
+	loggedOutSubjectPrefix = "logout/sub/"
+)
+
+// loggedOutSession records a back-channel logout. It is kept until the
+// Vault tokens issued before the logout have expired.
+type loggedOutSession struct {
+	LoggedOut time.Time `json:"logged_out"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+func pathOIDCBackChannelLogout(b *jwtAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: `oidc/logout`,
+		Fields: map[string]*framework.FieldSchema{
+			"logout_token": {
+				Type:        framework.TypeString,
+				Description: "The logout token sent by the OIDC provider.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathBackChannelLogout,
+				Summary:  "End the Vault sessions of a user logged out of the OIDC provider.",
+			},
+		},
+
+		HelpSynopsis:    backChannelLogoutHelpSyn,
+		HelpDescription: backChannelLogoutHelpDesc,
+	}
+}
+
+func (b *jwtAuthBackend) pathBackChannelLogout(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("could not load configuration"), nil
+	}
+
+	if config.authType() != OIDCFlow {
+		return logical.ErrorResponse("OIDC login is not configured for this mount"), nil
+	}
+
+	logoutToken := d.Get("logout_token").(string)
+	if logoutToken == "" {
+		return logical.ErrorResponse("missing logout_token"), nil
+	}
+
+	claims, err := b.verifyLogoutToken(ctx, config, logoutToken)
+	if err != nil {
+		return logical.ErrorResponse(errwrap.Wrapf("error validating logout token: {{err}}", err).Error()), nil
+	}
+
+	sid, _ := claims["sid"].(string)
+	sub, _ := claims["sub"].(string)
+
+	var key string
+	switch {
+	case sid != "":
+		key = loggedOutSessionPrefix + logoutKey(sid)
+	case sub != "":
+		key = loggedOutSubjectPrefix + logoutKey(sub)
+	default:
+		return logical.ErrorResponse("error validating logout token: a sid or sub claim is required"), nil
+	}
+
+	now := time.Now()
+	entry, err := logical.StorageEntryJSON(key, loggedOutSession{
+		LoggedOut: now,
+		Expiry:    now.Add(b.System().MaxLeaseTTL()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// verifyLogoutToken validates the signature, issuer and audience of a
+// back-channel logout token and returns its claims.
+func (b *jwtAuthBackend) verifyLogoutToken(ctx context.Context, config *jwtConfig, logoutToken string) (map[string]interface{}, error) {
+	provider, err := b.getProvider(config)
+	if err != nil {
+		return nil, errwrap.Wrapf("error getting provider: {{err}}", err)
+	}
+
+	verifier := provider.Verifier(&oidc.Config{
+		ClientID:             config.OIDCClientID,
+		SupportedSigningAlgs: config.JWTSupportedAlgs,
+	})
+	token, err := verifier.Verify(ctx, logoutToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := token.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	// A logout token must not be usable as an ID token, and vice versa.
+	if _, ok := claims["nonce"]; ok {
+		return nil, errors.New("logout token must not contain a nonce")
+	}
+	if logoutOnly, _ := claims["logout_only"].(bool); !logoutOnly {
+		events, _ := claims["events"].(map[string]interface{})
+		if _, ok := events[backChannelLogoutEvent]; !ok {
+			return nil, errors.New("logout token must have logout_only set to true")
+		}
+	}
+
+	return claims, nil
+}
+
+// addLoginSession records the session of allClaims in auth, so that the
+// token can be ended by a back-channel logout. Logins with the ID token of
+// a logged out session are rejected.
+func (b *jwtAuthBackend) addLoginSession(ctx context.Context, s logical.Storage, allClaims map[string]interface{}, auth *logical.Auth) error {
+	if sid, ok := allClaims["sid"].(string); ok && sid != "" {
+		loggedOut, err := b.loggedOut(ctx, s, loggedOutSessionPrefix+logoutKey(sid))
+		if err != nil {
+			return err
+		}
+		if loggedOut != nil {
+			return errors.New("the session of the token has been logged out")
+		}
+		auth.Metadata[sessionIDMetadata] = sid
+	}
+
+	if sub, ok := allClaims["sub"].(string); ok && sub != "" {
+		auth.InternalData["oidc_subject"] = sub
+	}
+	auth.InternalData["login_time"] = time.Now().Unix()
+
+	return nil
+}
+
+// sessionLoggedOut reports whether the token of auth belongs to a session
+// ended by a back-channel logout. Logouts by subject only apply to tokens
+// issued before the logout.
+func (b *jwtAuthBackend) sessionLoggedOut(ctx context.Context, s logical.Storage, auth *logical.Auth) (bool, error) {
+	if sid := auth.Metadata[sessionIDMetadata]; sid != "" {
+		loggedOut, err := b.loggedOut(ctx, s, loggedOutSessionPrefix+logoutKey(sid))
+		if err != nil || loggedOut != nil {
+			return loggedOut != nil, err
+		}
+	}
+
+	sub, _ := auth.InternalData["oidc_subject"].(string)
+	if sub == "" {
+		return false, nil
+	}
+	loggedOut, err := b.loggedOut(ctx, s, loggedOutSubjectPrefix+logoutKey(sub))
+	if err != nil || loggedOut == nil {
+		return false, err
+	}
+
+	loginTime, ok := unixTime(auth.InternalData["login_time"])
+	return !ok || !loginTime.After(loggedOut.LoggedOut), nil
+}
+
+// loggedOut returns the logout stored at key, or nil if there is none.
+func (b *jwtAuthBackend) loggedOut(ctx context.Context, s logical.Storage, key string) (*loggedOutSession, error) {
+	entry, err := s.Get(ctx, key)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	var loggedOut loggedOutSession
+	if err := entry.DecodeJSON(&loggedOut); err != nil {
+		return nil, err
+	}
+
+	return &loggedOut, nil
+}
+
+// tidyLoggedOutSessions deletes logouts older than the tokens they
+// apply to. It is run from the backend's periodic function.
+func (b *jwtAuthBackend) tidyLoggedOutSessions(ctx context.Context, s logical.Storage) error {
+	now := time.Now()
+	for _, prefix := range []string{loggedOutSessionPrefix, loggedOutSubjectPrefix} {
+		keys, err := s.List(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			loggedOut, err := b.loggedOut(ctx, s, prefix+key)
+			if err != nil {
+				return err
+			}
+			if loggedOut != nil && now.After(loggedOut.Expiry) {
+				if err := s.Delete(ctx, prefix+key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// logoutKey returns the storage key of a session ID or subject, which are
+// hashed since they are provider controlled.
+func logoutKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// unixTime converts a Unix time stored in a token's internal data, which is
+// a json.Number once the token has been persisted.
+func unixTime(raw interface{}) (time.Time, bool) {
+	switch v := raw.(type) {
+	case int64:
+		return time.Unix(v, 0), true
+	case float64:
+		return time.Unix(int64(v), 0), true
+	case json.Number:
+		i, err := v.Int64()
+		return time.Unix(i, 0), err == nil
+	}
+
+	return time.Time{}, false
+}
+
+const (
+	backChannelLogoutHelpSyn = `
+Receives OIDC back-channel logout tokens.
+`
+	backChannelLogoutHelpDesc = `
+Implements the OIDC Back-Channel Logout endpoint. The provider posts a logout
+token, signed with its keys, for the session or subject logged out. Logout
+tokens must have logout_only set to true or carry the back-channel logout
+event.
+
+The session ID of the token used to log in is stored in the token's
+oidc_session_id metadata. The plugin can't revoke Vault tokens itself, so
+tokens of a logged out session are denied renewal, and logins with the ID
+token of that session are rejected. A logout token with only a sub claim
+applies to all tokens of the subject issued before the logout. Pair this
+endpoint with short token TTLs for the logout to take effect promptly.
+`
+)
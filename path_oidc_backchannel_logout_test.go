@@ -0,0 +1,219 @@
+package jwtauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestOIDC_BackChannelLogout(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	s.code = "abc"
+
+	stdClaims := func() jwt.Claims {
+		return jwt.Claims{
+			Issuer:    s.server.URL,
+			Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+			Audience:  jwt.Audience{"abc"},
+			NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Expiry:    jwt.NewNumericDate(time.Now().Add(5 * time.Minute)),
+		}
+	}
+
+	login := func(sid string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "oidc/auth_url",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role":         "test",
+				"redirect_uri": "https://example.com",
+			},
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+
+		url := resp.Data["auth_url"].(string)
+		s.customClaims = sampleClaims(getQueryParam(t, url, "nonce"))
+		s.customClaims["sid"] = sid
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "oidc/callback",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"state": getQueryParam(t, url, "state"),
+				"code":  "abc",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	logout := func(privKey string, claims map[string]interface{}) *logical.Response {
+		t.Helper()
+		logoutToken, _ := getTestJWT(t, privKey, stdClaims(), claims)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "oidc/logout",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"logout_token": logoutToken,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	renew := func(auth *logical.Auth) error {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RenewOperation,
+			Path:      "login",
+			Storage:   storage,
+			Auth:      auth,
+		})
+		if err == nil && resp.IsError() {
+			err = resp.Error()
+		}
+		return err
+	}
+
+	resp1 := login("session-1")
+	resp2 := login("session-2")
+	for _, resp := range []*logical.Response{resp1, resp2} {
+		if resp.IsError() {
+			t.Fatalf("unexpected error: %v", resp.Error())
+		}
+	}
+	if sid := resp1.Auth.Metadata[sessionIDMetadata]; sid != "session-1" {
+		t.Fatalf("unexpected session ID metadata: %q", sid)
+	}
+
+	// A logout token with an invalid signature is rejected.
+	otherKey, _ := newTestECKey(t)
+	resp := logout(otherKey, map[string]interface{}{"sid": "session-1", "logout_only": true})
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "error validating logout token") {
+		t.Fatalf("expected signature error, got: %v", resp)
+	}
+	if err := renew(resp1.Auth); err != nil {
+		t.Fatalf("unexpected renewal error: %v", err)
+	}
+
+	// A logout token without logout_only, or with a nonce, is rejected.
+	for _, claims := range []map[string]interface{}{
+		{"sid": "session-1"},
+		{"sid": "session-1", "logout_only": true, "nonce": "abc"},
+	} {
+		if resp := logout(ecdsaPrivKey, claims); resp == nil || !resp.IsError() {
+			t.Fatalf("expected error for logout token with claims %v, got: %v", claims, resp)
+		}
+	}
+
+	if resp := logout(ecdsaPrivKey, map[string]interface{}{"sid": "session-1", "logout_only": true}); resp != nil {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+
+	// Only the tokens of the logged out session are ended.
+	if err := renew(resp1.Auth); err == nil || !strings.Contains(err.Error(), "has been logged out") {
+		t.Fatalf("expected renewal to be denied, got: %v", err)
+	}
+	if err := renew(resp2.Auth); err != nil {
+		t.Fatalf("unexpected renewal error: %v", err)
+	}
+	if resp := login("session-1"); !resp.IsError() || !strings.Contains(resp.Error().Error(), "has been logged out") {
+		t.Fatalf("expected login with a logged out session to be rejected, got: %v", resp)
+	}
+
+	// The events claim of the specification is accepted as well.
+	events := map[string]interface{}{
+		backChannelLogoutEvent: map[string]interface{}{},
+	}
+	if resp := logout(ecdsaPrivKey, map[string]interface{}{"sid": "session-2", "events": events}); resp != nil {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+	if err := renew(resp2.Auth); err == nil {
+		t.Fatal("expected renewal to be denied")
+	}
+}
+
+func TestOIDC_BackChannelLogout_MissingSID(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	cl := jwt.Claims{
+		Issuer:    s.server.URL,
+		Audience:  jwt.Audience{"abc"},
+		NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		Expiry:    jwt.NewNumericDate(time.Now().Add(5 * time.Minute)),
+	}
+	logoutToken, _ := getTestJWT(t, ecdsaPrivKey, cl, map[string]interface{}{"logout_only": true})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "oidc/logout",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"logout_token": logoutToken,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "a sid or sub claim is required") {
+		t.Fatalf("expected missing sid error, got: %v", resp)
+	}
+
+	// Without a sid, the subject's tokens issued before the logout are
+	// ended.
+	auth := &logical.Auth{
+		InternalData: map[string]interface{}{
+			"role":         "test",
+			"oidc_subject": "bob",
+			"login_time":   time.Now().Add(-time.Minute).Unix(),
+		},
+		Metadata: map[string]string{},
+	}
+	cl.Subject = "bob"
+	logoutToken, _ = getTestJWT(t, ecdsaPrivKey, cl, map[string]interface{}{"logout_only": true})
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "oidc/logout",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"logout_token": logoutToken,
+		},
+	})
+	if err != nil || resp != nil {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+
+	backend := b.(*jwtAuthBackend)
+	loggedOut, err := backend.sessionLoggedOut(context.Background(), storage, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loggedOut {
+		t.Fatal("expected the subject's token to be logged out")
+	}
+
+	auth.InternalData["login_time"] = time.Now().Add(time.Minute).Unix()
+	if loggedOut, err = backend.sessionLoggedOut(context.Background(), storage, auth); err != nil || loggedOut {
+		t.Fatalf("expected token issued after the logout to be kept, err: %v", err)
+	}
+}
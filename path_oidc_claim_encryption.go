@@ -0,0 +1,219 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// claimKeyPrefix is the storage prefix of the per-role keys used to
+	// encrypt claims in encrypted_claim_mappings.
+	claimKeyPrefix = "claim_keys/"
+
+	// encryptedClaimPrefix marks an encrypted token metadata value.
+	encryptedClaimPrefix = "enc:v1:"
+)
+
+type claimKey struct {
+	Key []byte `json:"key"`
+}
+
+func pathOIDCDecryptClaim(b *jwtAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: `oidc/decrypt-claim`,
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "The role the token was issued by.",
+			},
+			"ciphertext": {
+				Type:        framework.TypeString,
+				Description: "The encrypted token metadata value.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathDecryptClaim,
+				Summary:  "Decrypt a claim stored encrypted in token metadata.",
+			},
+		},
+
+		HelpSynopsis:    decryptClaimHelpSyn,
+		HelpDescription: decryptClaimHelpDesc,
+	}
+}
+
+func (b *jwtAuthBackend) pathDecryptClaim(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	roleName := d.Get("role").(string)
+	if roleName == "" {
+		return logical.ErrorResponse("missing role"), nil
+	}
+
+	ciphertext := d.Get("ciphertext").(string)
+	if ciphertext == "" {
+		return logical.ErrorResponse("missing ciphertext"), nil
+	}
+
+	entry, err := req.Storage.Get(ctx, claimKeyPrefix+roleName)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("no claim encryption key found for role %q", roleName), nil
+	}
+
+	var key claimKey
+	if err := entry.DecodeJSON(&key); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := decryptClaim(key.Key, roleName, ciphertext)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"plaintext": plaintext,
+		},
+	}, nil
+}
+
+// addEncryptedClaims encrypts the claims in the role's
+// encrypted_claim_mappings into metadata. Absent claims are skipped.
+func (b *jwtAuthBackend) addEncryptedClaims(ctx context.Context, s logical.Storage, role *jwtRole, roleName string, allClaims map[string]interface{}, metadata map[string]string) error {
+	if len(role.EncryptedClaimMappings) == 0 {
+		return nil
+	}
+
+	claims, err := extractMetadata(b.Logger(), allClaims, role.EncryptedClaimMappings)
+	if err != nil {
+		return err
+	}
+	if len(claims) == 0 {
+		return nil
+	}
+
+	key, err := b.claimKey(ctx, s, roleName)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range claims {
+		ciphertext, err := encryptClaim(key, roleName, v)
+		if err != nil {
+			return err
+		}
+		metadata[k] = ciphertext
+	}
+
+	return nil
+}
+
+// claimKey returns the role's claim encryption key, creating it on first use.
+func (b *jwtAuthBackend) claimKey(ctx context.Context, s logical.Storage, roleName string) ([]byte, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	entry, err := s.Get(ctx, claimKeyPrefix+roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	var key claimKey
+	if entry != nil {
+		if err := entry.DecodeJSON(&key); err != nil {
+			return nil, err
+		}
+		return key.Key, nil
+	}
+
+	key.Key, err = uuid.GenerateRandomBytes(32)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err = logical.StorageEntryJSON(claimKeyPrefix+roleName, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return key.Key, nil
+}
+
+// encryptClaim encrypts value with AES-GCM. The role name is used as
+// additional data so that a value can't be decrypted as another role's.
+func encryptClaim(key []byte, roleName, value string) (string, error) {
+	gcm, err := claimCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce, err := uuid.GenerateRandomBytes(gcm.NonceSize())
+	if err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(value), []byte(roleName))
+	return encryptedClaimPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// decryptClaim reverses encryptClaim.
+func decryptClaim(key []byte, roleName, ciphertext string) (string, error) {
+	if !strings.HasPrefix(ciphertext, encryptedClaimPrefix) {
+		return "", errors.New("ciphertext is not an encrypted claim")
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(ciphertext, encryptedClaimPrefix))
+	if err != nil {
+		return "", fmt.Errorf("error decoding ciphertext: %s", err)
+	}
+
+	gcm, err := claimCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("ciphertext is too short")
+	}
+
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(roleName))
+	if err != nil {
+		return "", errors.New("error decrypting ciphertext")
+	}
+
+	return string(plaintext), nil
+}
+
+func claimCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+const (
+	decryptClaimHelpSyn = `
+Decrypts a claim stored encrypted in token metadata.
+`
+	decryptClaimHelpDesc = `
+Claims listed in a role's encrypted_claim_mappings are stored in token
+metadata encrypted with a key specific to the role, so that their values
+don't appear in audit logs. Access to this endpoint should be restricted to
+callers allowed to read those claims. Deleting a role deletes its key, after
+which values encrypted for the role can no longer be decrypted.
+`
+)
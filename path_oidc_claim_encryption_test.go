@@ -0,0 +1,90 @@
+package jwtauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestLogin_EncryptedClaimMappings(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		roleData: map[string]interface{}{
+			"encrypted_claim_mappings": map[string]string{
+				"color": "color",
+			},
+		},
+	})
+	req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+
+	ciphertext := resp.Auth.Metadata["color"]
+	if !strings.HasPrefix(ciphertext, encryptedClaimPrefix) || strings.Contains(ciphertext, "green") {
+		t.Fatalf("expected encrypted metadata, got: %q", ciphertext)
+	}
+	if _, ok := resp.Auth.Alias.Metadata["color"]; ok {
+		t.Fatal("encrypted claim should not be added to alias metadata")
+	}
+
+	decrypt := func(role string) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "oidc/decrypt-claim",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role":       role,
+				"ciphertext": ciphertext,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp = decrypt("plugin-test")
+	if resp.IsError() || resp.Data["plaintext"] != "green" {
+		t.Fatalf("unexpected decrypt response: %v", resp)
+	}
+
+	// A value can't be decrypted with another role's key.
+	if _, err := b.Backend.(*jwtAuthBackend).claimKey(context.Background(), storage, "other"); err != nil {
+		t.Fatal(err)
+	}
+	if resp := decrypt("other"); !resp.IsError() {
+		t.Fatalf("expected error decrypting with another role, got: %v", resp)
+	}
+}
+
+func TestRole_EncryptedClaimMappingsConflict(t *testing.T) {
+	b, storage := getBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_type":                "jwt",
+			"user_claim":               "user",
+			"bound_subject":            "testsub",
+			"claim_mappings":           map[string]string{"email": "id"},
+			"encrypted_claim_mappings": map[string]string{"employee_id": "id"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for conflicting metadata key, got: %v", resp)
+	}
+}
@@ -0,0 +1,534 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// grantTypeDeviceCode is the grant type of device access token requests
+	// (RFC 8628 section 3.4).
+	grantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// deviceCodePrefix is the storage prefix of pending device codes.
+	deviceCodePrefix = "device_code/"
+
+	// defaultDeviceCodeTTL is used when oidc_device_code_ttl is not set.
+	defaultDeviceCodeTTL = 10 * time.Minute
+
+	// defaultDevicePollInterval is the polling interval when the provider
+	// doesn't return one, and deviceSlowDownIncrement is added to it on
+	// each slow_down (RFC 8628 section 3.5).
+	defaultDevicePollInterval = 5 * time.Second
+	deviceSlowDownIncrement   = 5 * time.Second
+)
+
+// Statuses of device/token responses while the login is not complete.
+const (
+	deviceStatusPending  = "authorization_pending"
+	deviceStatusSlowDown = "slow_down"
+)
+
+// deviceClock returns the current time for device code expiry and polling
+// intervals. Tests replace it.
+var deviceClock = time.Now
+
+// pendingDeviceCode is the storage representation of a device code that
+// is waiting for the user to authorize it.
+type pendingDeviceCode struct {
+	RoleName string        `json:"role_name"`
+	Interval time.Duration `json:"interval"`
+	LastPoll time.Time     `json:"last_poll"`
+	Expiry   time.Time     `json:"expiry"`
+}
+
+// deviceCodeStorageKey returns the storage key of a pending device code,
+// hashed so that the code isn't stored.
+func deviceCodeStorageKey(deviceCode string) string {
+	sum := sha256.Sum256([]byte(deviceCode))
+	return deviceCodePrefix + hex.EncodeToString(sum[:])
+}
+
+func putPendingDeviceCode(ctx context.Context, s logical.Storage, deviceCode string, pending *pendingDeviceCode) error {
+	entry, err := logical.StorageEntryJSON(deviceCodeStorageKey(deviceCode), pending)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+func readPendingDeviceCode(ctx context.Context, s logical.Storage, deviceCode string) (*pendingDeviceCode, error) {
+	entry, err := s.Get(ctx, deviceCodeStorageKey(deviceCode))
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	pending := new(pendingDeviceCode)
+	if err := entry.DecodeJSON(pending); err != nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+func pathOIDCDevice(b *jwtAuthBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: `device/auth_url`,
+			Fields: map[string]*framework.FieldSchema{
+				"role": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "The role to log in against.",
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathDeviceAuthURL,
+					Summary:  "Start a device flow login, returning the code the user enters at the verification URI.",
+				},
+			},
+
+			HelpSynopsis:    pathDeviceAuthURLHelpSyn,
+			HelpDescription: pathDeviceAuthURLHelpDesc,
+		},
+		{
+			Pattern: `device/token`,
+			Fields: map[string]*framework.FieldSchema{
+				"device_code": {
+					Type:        framework.TypeString,
+					Description: "The device code returned by device/auth_url.",
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathDeviceToken,
+					Summary:  "Poll a device flow login, completing it once the user has authorized it.",
+				},
+			},
+
+			HelpSynopsis:    pathDeviceTokenHelpSyn,
+			HelpDescription: pathDeviceTokenHelpDesc,
+		},
+	}
+}
+
+func (b *jwtAuthBackend) pathDeviceAuthURL(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("could not load configuration"), nil
+	}
+	if config.authType() != OIDCFlow {
+		return logical.ErrorResponse("OIDC login is not configured for this mount"), nil
+	}
+
+	roleName := d.Get("role").(string)
+	if roleName == "" {
+		roleName = config.DefaultRole
+	}
+	if roleName == "" {
+		return logical.ErrorResponse("missing role"), nil
+	}
+
+	roleName, err = b.canonicalRoleName(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := b.role(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("role %q could not be found", roleName), nil
+	}
+	if !role.DeviceFlowAllowed {
+		return logical.ErrorResponse("role %q does not allow the device flow", roleName), nil
+	}
+
+	if err := b.providerBreaker.allow(config); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	provider, err := b.getProvider(config)
+	if err != nil {
+		return nil, errwrap.Wrapf("error getting provider for device flow: {{err}}", err)
+	}
+
+	var discovery struct {
+		DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	}
+	if err := provider.Claims(&discovery); err != nil {
+		return nil, errwrap.Wrapf("error reading provider discovery document: {{err}}", err)
+	}
+	if discovery.DeviceAuthorizationEndpoint == "" {
+		return logical.ErrorResponse("the OIDC provider does not support the device flow"), nil
+	}
+
+	oidcCtx, err := b.createCAContext(ctx, config, config.OIDCDiscoveryCAPEM)
+	if err != nil {
+		return nil, errwrap.Wrapf("error preparing context for device flow: {{err}}", err)
+	}
+
+	scopes := append([]string{oidc.ScopeOpenID}, role.OIDCScopes...)
+	for _, scope := range role.RequiredScopes {
+		if !strutil.StrListContains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	status, body, err := postDeviceForm(oidcCtx, config, discovery.DeviceAuthorizationEndpoint, url.Values{
+		"scope": {strings.Join(scopes, " ")},
+	})
+	b.providerBreaker.record(config, isProviderUnreachable(err))
+	if err != nil {
+		return logical.ErrorResponse("error requesting device code: %s", err), nil
+	}
+	if status != http.StatusOK {
+		return logical.ErrorResponse("device authorization request failed with status %d: %s", status, body), nil
+	}
+
+	var authResp struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int64  `json:"expires_in"`
+		Interval                int64  `json:"interval"`
+	}
+	if err := json.Unmarshal(body, &authResp); err != nil {
+		return logical.ErrorResponse("error parsing device authorization response: %s", err), nil
+	}
+	if authResp.DeviceCode == "" || authResp.UserCode == "" || authResp.VerificationURI == "" {
+		return logical.ErrorResponse("device authorization response is missing device_code, user_code or verification_uri"), nil
+	}
+
+	interval := defaultDevicePollInterval
+	if authResp.Interval > 0 {
+		interval = time.Duration(authResp.Interval) * time.Second
+	}
+
+	// Device codes are kept for the lifetime given by the provider, but no
+	// longer than oidc_device_code_ttl.
+	ttl := config.deviceCodeTTL()
+	if expiresIn := time.Duration(authResp.ExpiresIn) * time.Second; expiresIn > 0 && expiresIn < ttl {
+		ttl = expiresIn
+	}
+
+	now := deviceClock()
+	if err := putPendingDeviceCode(ctx, req.Storage, authResp.DeviceCode, &pendingDeviceCode{
+		RoleName: roleName,
+		Interval: interval,
+		Expiry:   now.Add(ttl),
+	}); err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"device_code":      authResp.DeviceCode,
+		"user_code":        authResp.UserCode,
+		"verification_uri": authResp.VerificationURI,
+		"expires_in":       int64(ttl.Seconds()),
+		"interval":         int64(interval.Seconds()),
+	}
+	if authResp.VerificationURIComplete != "" {
+		data["verification_uri_complete"] = authResp.VerificationURIComplete
+	}
+
+	return &logical.Response{Data: data}, nil
+}
+
+func (b *jwtAuthBackend) pathDeviceToken(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	deviceCode := d.Get("device_code").(string)
+	if deviceCode == "" {
+		return logical.ErrorResponse("missing device_code"), nil
+	}
+
+	// The lock makes each poll see the interval and last poll time of the
+	// previous one.
+	b.deviceLock.Lock()
+	defer b.deviceLock.Unlock()
+
+	pending, err := readPendingDeviceCode(ctx, req.Storage, deviceCode)
+	if err != nil {
+		return nil, err
+	}
+	now := deviceClock()
+	if pending == nil || now.After(pending.Expiry) {
+		if pending != nil {
+			if err := req.Storage.Delete(ctx, deviceCodeStorageKey(deviceCode)); err != nil {
+				return nil, err
+			}
+		}
+		return logical.ErrorResponse(errLoginFailed + " Expired or missing device code."), nil
+	}
+
+	// Polls faster than the interval are not sent to the provider, and
+	// slow down the client like the provider would.
+	if !pending.LastPoll.IsZero() && now.Sub(pending.LastPoll) < pending.Interval {
+		pending.Interval += deviceSlowDownIncrement
+		if err := putPendingDeviceCode(ctx, req.Storage, deviceCode, pending); err != nil {
+			return nil, err
+		}
+		return devicePollResponse(deviceStatusSlowDown, pending), nil
+	}
+	pending.LastPoll = now
+
+	roleName := pending.RoleName
+	role, err := b.role(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil || !role.DeviceFlowAllowed {
+		return logical.ErrorResponse(errLoginFailed + " Role could not be found or does not allow the device flow"), nil
+	}
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse(errLoginFailed + " Could not load configuration"), nil
+	}
+
+	if err := b.providerBreaker.allow(config); err != nil {
+		return logical.ErrorResponse("%s %s", errLoginFailed, err.Error()), nil
+	}
+
+	provider, err := b.getProvider(config)
+	if err != nil {
+		return nil, errwrap.Wrapf("error getting provider for device flow: {{err}}", err)
+	}
+
+	oidcCtx, err := b.createCAContext(ctx, config, config.OIDCDiscoveryCAPEM)
+	if err != nil {
+		return nil, errwrap.Wrapf("error preparing context for device flow: {{err}}", err)
+	}
+
+	exchangeStart := time.Now()
+	status, body, err := postDeviceForm(oidcCtx, config, provider.Endpoint().TokenURL, url.Values{
+		"grant_type":  {grantTypeDeviceCode},
+		"device_code": {deviceCode},
+	})
+	b.providerMetrics.observe(roleName, exchangeStart)
+	b.providerBreaker.record(config, isProviderUnreachable(err))
+	if err != nil {
+		return logical.ErrorResponse(errLoginFailed+" Error polling device code: %q.", err.Error()), nil
+	}
+
+	if status != http.StatusOK {
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(body, &errResp)
+
+		switch errResp.Error {
+		case deviceStatusPending:
+		case deviceStatusSlowDown:
+			pending.Interval += deviceSlowDownIncrement
+		default:
+			if err := req.Storage.Delete(ctx, deviceCodeStorageKey(deviceCode)); err != nil {
+				return nil, err
+			}
+			if errResp.Error != "" {
+				return logical.ErrorResponse("%s Provider returned error %q.", errLoginFailed, errResp.Error), nil
+			}
+			return logical.ErrorResponse("%s Device token request failed with status %d: %s", errLoginFailed, status, body), nil
+		}
+
+		if err := putPendingDeviceCode(ctx, req.Storage, deviceCode, pending); err != nil {
+			return nil, err
+		}
+		return devicePollResponse(errResp.Error, pending), nil
+	}
+
+	// The device code can only be used once.
+	if err := req.Storage.Delete(ctx, deviceCodeStorageKey(deviceCode)); err != nil {
+		return nil, err
+	}
+
+	var oauth2Token oauth2.Token
+	var tokenResp struct {
+		IDToken string `json:"id_token"`
+		Scope   string `json:"scope"`
+	}
+	if err := json.Unmarshal(body, &oauth2Token); err != nil {
+		return logical.ErrorResponse(errLoginFailed+" Error parsing device token response: %q.", err.Error()), nil
+	}
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return logical.ErrorResponse(errLoginFailed+" Error parsing device token response: %q.", err.Error()), nil
+	}
+	if tokenResp.IDToken == "" {
+		return logical.ErrorResponse(errTokenVerification + " No id_token found in response."), nil
+	}
+	if tokenResp.Scope != "" {
+		if missing := missingScopes(role.RequiredScopes, tokenResp.Scope); len(missing) > 0 {
+			return logical.ErrorResponse(errLoginFailed+" Required scopes were not granted: %s.", strings.Join(missing, ", ")), nil
+		}
+	}
+
+	allClaims, err := b.verifyOIDCToken(ctx, config, role, tokenResp.IDToken)
+	if err != nil {
+		return logical.ErrorResponse("%s %s", errTokenVerification, err.Error()), nil
+	}
+
+	if role.UseAccessTokenClaims {
+		mergeAccessTokenClaims(b.Logger(), oauth2Token.AccessToken, allClaims)
+	}
+
+	// Like the callback, claims from the /userinfo endpoint are merged if
+	// they can be fetched, or must be with fetch_groups_from_userinfo.
+	var tokenSource oauth2.TokenSource
+	if oauth2Token.AccessToken != "" {
+		tokenSource = oauth2.StaticTokenSource(&oauth2Token)
+	}
+	if role.FetchGroupsFromUserinfo {
+		if err := b.mergeRoleUserinfo(oidcCtx, provider, role, roleName, tokenSource, allClaims); err != nil {
+			return logical.ErrorResponse("%s %s", errLoginFailed, err.Error()), nil
+		}
+	} else if tokenSource != nil {
+		if userinfo, err := provider.UserInfo(oidcCtx, tokenSource); err == nil {
+			_ = userinfo.Claims(&allClaims)
+		} else {
+			b.Logger().Info("error reading /userinfo endpoint", "error", err)
+		}
+	}
+
+	resp, err := b.loginResponse(ctx, req, config, role, roleName, allClaims, tokenSource)
+	if err == nil && resp != nil && resp.Auth != nil {
+		b.tokenStats.record(roleName, time.Now())
+	}
+
+	return resp, err
+}
+
+// devicePollResponse is the response to a poll of a login that is not
+// complete yet, with the interval the client must wait before polling
+// again.
+func devicePollResponse(status string, pending *pendingDeviceCode) *logical.Response {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"status":   status,
+			"interval": int64(pending.Interval.Seconds()),
+		},
+	}
+}
+
+// postDeviceForm posts form to a device flow endpoint of the provider,
+// authenticating with the client credentials, and returns the status and
+// body of the response. Public clients without a secret send their client
+// ID in the form instead.
+func postDeviceForm(ctx context.Context, config *jwtConfig, endpoint string, form url.Values) (int, []byte, error) {
+	if config.OIDCClientSecret == "" {
+		form.Set("client_id", config.OIDCClientID)
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if config.OIDCClientSecret != "" {
+		httpReq.SetBasicAuth(url.QueryEscape(config.OIDCClientID), url.QueryEscape(config.OIDCClientSecret))
+	}
+
+	client, ok := ctx.Value(oauth2.HTTPClient).(*http.Client)
+	if !ok {
+		client = cleanhttp.DefaultClient()
+	}
+
+	resp, err := client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error reading response: %s", err)
+	}
+
+	return resp.StatusCode, body, nil
+}
+
+// tidyDeviceCodes removes expired pending device codes from storage. It is
+// run from the backend's periodic function.
+func (b *jwtAuthBackend) tidyDeviceCodes(ctx context.Context, s logical.Storage) error {
+	keys, err := s.List(ctx, deviceCodePrefix)
+	if err != nil {
+		return err
+	}
+
+	b.deviceLock.Lock()
+	defer b.deviceLock.Unlock()
+
+	now := deviceClock()
+	for _, key := range keys {
+		entry, err := s.Get(ctx, deviceCodePrefix+key)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+
+		var pending pendingDeviceCode
+		if err := entry.DecodeJSON(&pending); err != nil {
+			return err
+		}
+		if now.After(pending.Expiry) {
+			if err := s.Delete(ctx, deviceCodePrefix+key); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+const (
+	pathDeviceAuthURLHelpSyn = `
+Starts an OAuth 2.0 device authorization grant login.
+`
+	pathDeviceAuthURLHelpDesc = `
+For clients without a browser, e.g. CI runners or SSH sessions (RFC 8628).
+A device code is requested from the configured OIDC provider for a role with
+device_flow_allowed set. The response has the verification_uri and user_code
+that the user enters on another device, and the device_code that the client
+polls device/token with, waiting interval seconds between polls.
+
+Pending device codes expire after the provider's expires_in, or
+oidc_device_code_ttl if it is shorter.
+`
+	pathDeviceTokenHelpSyn = `
+Polls a device authorization grant login.
+`
+	pathDeviceTokenHelpDesc = `
+Until the user has authorized the login, the response has a status of
+"authorization_pending", or "slow_down" if the client must poll less often,
+and the interval to wait before the next poll. Once authorized, the ID token
+is validated against the role and a Vault token is issued. Denied and expired
+logins return an error.
+`
+)
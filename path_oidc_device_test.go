@@ -0,0 +1,245 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// handleDeviceAuthorization issues deviceCode if the client credentials
+// match.
+func (o *oidcProvider) handleDeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, _ := r.BasicAuth()
+	if clientID != o.clientID || clientSecret != o.clientSecret {
+		w.WriteHeader(401)
+		w.Write([]byte(`{"error":"invalid_client"}`))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"device_code":               o.deviceCode,
+		"user_code":                 "WDJB-MJHT",
+		"verification_uri":          o.server.URL + "/activate",
+		"verification_uri_complete": o.server.URL + "/activate?user_code=WDJB-MJHT",
+		"expires_in":                300,
+		"interval":                  1,
+	})
+}
+
+// handleDeviceToken returns the next of devicePollErrors for deviceCode, and
+// tokens once there are none left.
+func (o *oidcProvider) handleDeviceToken(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, _ := r.BasicAuth()
+	if clientID != o.clientID || clientSecret != o.clientSecret {
+		w.WriteHeader(401)
+		w.Write([]byte(`{"error":"invalid_client"}`))
+		return
+	}
+	if r.FormValue("device_code") != o.deviceCode {
+		w.WriteHeader(400)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	o.devicePolls++
+	if o.devicePolls <= len(o.devicePollErrors) {
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]string{"error": o.devicePollErrors[o.devicePolls-1]})
+		return
+	}
+
+	o.writeTokens(w)
+}
+
+func allowDeviceFlow(t *testing.T, b logical.Backend, storage logical.Storage) {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/test",
+		Storage:   storage,
+		Data:      map[string]interface{}{"device_flow_allowed": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%v", err, resp)
+	}
+}
+
+func deviceRequest(t *testing.T, b logical.Backend, storage logical.Storage, path string, data map[string]interface{}) *logical.Response {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      path,
+		Storage:   storage,
+		Data:      data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestOIDC_DeviceFlow(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+	allowDeviceFlow(t, b, storage)
+
+	now := time.Now()
+	defer func(clock func() time.Time) { deviceClock = clock }(deviceClock)
+	deviceClock = func() time.Time { return now }
+
+	s.customClaims = sampleClaims("")
+	s.deviceCode = "device-code"
+	s.devicePollErrors = []string{deviceStatusPending, deviceStatusSlowDown}
+
+	resp := deviceRequest(t, b, storage, "device/auth_url", map[string]interface{}{"role": "test"})
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+	if resp.Data["user_code"] != "WDJB-MJHT" || resp.Data["verification_uri"] != s.server.URL+"/activate" ||
+		resp.Data["expires_in"] != int64(300) || resp.Data["interval"] != int64(1) {
+		t.Fatalf("unexpected device authorization: %v", resp.Data)
+	}
+	deviceCode := resp.Data["device_code"].(string)
+
+	poll := func() *logical.Response {
+		t.Helper()
+		return deviceRequest(t, b, storage, "device/token", map[string]interface{}{"device_code": deviceCode})
+	}
+	expectStatus := func(resp *logical.Response, status string, interval int64) {
+		t.Helper()
+		if resp == nil || resp.IsError() || resp.Auth != nil ||
+			resp.Data["status"] != status || resp.Data["interval"] != interval {
+			t.Fatalf("expected status %q with interval %d, got: %v", status, interval, resp)
+		}
+	}
+
+	expectStatus(poll(), deviceStatusPending, 1)
+
+	// Polling before the interval has passed slows the client down without
+	// polling the provider.
+	expectStatus(poll(), deviceStatusSlowDown, 6)
+	if s.devicePolls != 1 {
+		t.Fatalf("expected 1 provider poll, got %d", s.devicePolls)
+	}
+
+	// The provider's slow_down is honored too.
+	now = now.Add(6 * time.Second)
+	expectStatus(poll(), deviceStatusSlowDown, 11)
+
+	now = now.Add(11 * time.Second)
+	resp = poll()
+	if resp == nil || resp.IsError() || resp.Auth == nil {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+	if resp.Auth.Alias.Name != "bob@example.com" {
+		t.Fatalf("unexpected alias: %q", resp.Auth.Alias.Name)
+	}
+
+	// Device codes can only be used once.
+	now = now.Add(11 * time.Second)
+	resp = poll()
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "Expired or missing device code") {
+		t.Fatalf("expected missing device code error, got: %v", resp)
+	}
+}
+
+func TestOIDC_DeviceFlowExpiry(t *testing.T) {
+	b, storage, s := getBackendAndServerWithConfig(t, false, map[string]interface{}{
+		"oidc_device_code_ttl": "30s",
+	})
+	defer s.server.Close()
+	allowDeviceFlow(t, b, storage)
+
+	now := time.Now()
+	defer func(clock func() time.Time) { deviceClock = clock }(deviceClock)
+	deviceClock = func() time.Time { return now }
+
+	s.deviceCode = "device-code"
+	s.devicePollErrors = []string{deviceStatusPending, "access_denied"}
+
+	resp := deviceRequest(t, b, storage, "device/auth_url", map[string]interface{}{"role": "test"})
+	if resp == nil || resp.IsError() || resp.Data["expires_in"] != int64(30) {
+		t.Fatalf("expected device code expiring in 30s, got: %v", resp)
+	}
+
+	now = now.Add(31 * time.Second)
+	resp = deviceRequest(t, b, storage, "device/token", map[string]interface{}{"device_code": "device-code"})
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "Expired or missing device code") {
+		t.Fatalf("expected expired device code error, got: %v", resp)
+	}
+	if s.devicePolls != 0 {
+		t.Fatalf("expected no provider poll, got %d", s.devicePolls)
+	}
+
+	// Expired device codes are tidied.
+	resp = deviceRequest(t, b, storage, "device/auth_url", map[string]interface{}{"role": "test"})
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+	now = now.Add(31 * time.Second)
+	if err := b.(*jwtAuthBackend).tidyDeviceCodes(context.Background(), storage); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := storage.List(context.Background(), deviceCodePrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("expected expired device codes to be removed, got: %v", keys)
+	}
+
+	// A denied login ends the device flow.
+	resp = deviceRequest(t, b, storage, "device/auth_url", map[string]interface{}{"role": "test"})
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+	resp = deviceRequest(t, b, storage, "device/token", map[string]interface{}{"device_code": "device-code"})
+	if resp == nil || resp.IsError() || resp.Data["status"] != deviceStatusPending {
+		t.Fatalf("expected pending status, got: %v", resp)
+	}
+	now = now.Add(time.Second)
+	resp = deviceRequest(t, b, storage, "device/token", map[string]interface{}{"device_code": "device-code"})
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "access_denied") {
+		t.Fatalf("expected access_denied error, got: %v", resp)
+	}
+	keys, err = storage.List(context.Background(), deviceCodePrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("expected denied device code to be removed, got: %v", keys)
+	}
+}
+
+func TestOIDC_DeviceFlowNotAllowed(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	resp := deviceRequest(t, b, storage, "device/auth_url", map[string]interface{}{"role": "test"})
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "does not allow the device flow") {
+		t.Fatalf("expected device flow to be refused, got: %v", resp)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/jwt",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_type":           "jwt",
+			"user_claim":          "sub",
+			"bound_audiences":     "vault",
+			"device_flow_allowed": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "'device_flow_allowed' requires 'role_type' to be 'oidc'") {
+		t.Fatalf("expected role_type error, got: %v", resp)
+	}
+}
@@ -0,0 +1,168 @@
+package jwtauth
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathOIDCLogout(b *jwtAuthBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: `oidc/end-session`,
+			Fields: map[string]*framework.FieldSchema{
+				"id_token_hint": {
+					Type:        framework.TypeString,
+					Description: "The ID token of the session to end, passed to the provider as id_token_hint.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathEndSession,
+					Summary:  "Redirect to the OIDC provider to end the user's session.",
+				},
+			},
+
+			HelpSynopsis:    endSessionHelpSyn,
+			HelpDescription: endSessionHelpDesc,
+		},
+		{
+			Pattern: `oidc/logged-out`,
+			Fields: map[string]*framework.FieldSchema{
+				"state": {
+					Type: framework.TypeString,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathLoggedOut,
+					Summary:  "Confirmation page shown after the provider has ended the session.",
+				},
+			},
+		},
+		pathOIDCBackChannelLogout(b),
+	}
+}
+
+func (b *jwtAuthBackend) pathEndSession(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	// Because the state is cached, don't process logouts on perf standbys
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil, logical.ErrReadOnly
+	}
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("could not load configuration"), nil
+	}
+
+	if config.authType() != OIDCFlow {
+		return logical.ErrorResponse("OIDC login is not configured for this mount"), nil
+	}
+
+	provider, err := b.getProvider(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	if err := provider.Claims(&metadata); err != nil {
+		return nil, err
+	}
+	if metadata.EndSessionEndpoint == "" {
+		return logical.ErrorResponse("OIDC provider does not advertise an end_session_endpoint"), nil
+	}
+
+	endSessionURL, err := url.Parse(metadata.EndSessionEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	stateID, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	b.logoutStates.SetDefault(stateID, struct{}{})
+
+	q := endSessionURL.Query()
+	q.Set("state", stateID)
+	if idToken := d.Get("id_token_hint").(string); idToken != "" {
+		q.Set("id_token_hint", idToken)
+	}
+	if config.OIDCPostLogoutRedirectURI != "" {
+		q.Set("post_logout_redirect_uri", config.OIDCPostLogoutRedirectURI)
+	}
+	endSessionURL.RawQuery = q.Encode()
+
+	target := html.EscapeString(endSessionURL.String())
+	return htmlResponse(http.StatusOK, fmt.Sprintf(endSessionHTML, target, target)), nil
+}
+
+func (b *jwtAuthBackend) pathLoggedOut(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	stateID := d.Get("state").(string)
+
+	_, ok := b.logoutStates.Get(stateID)
+	b.logoutStates.Delete(stateID)
+	if !ok {
+		return htmlResponse(http.StatusBadRequest, errorHTML("Logout could not be confirmed.", "Expired or missing logout state.")), nil
+	}
+
+	return htmlResponse(http.StatusOK, loggedOutHTML), nil
+}
+
+func htmlResponse(status int, body string) *logical.Response {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPStatusCode:  status,
+			logical.HTTPRawBody:     []byte(body),
+			logical.HTTPContentType: "text/html",
+		},
+	}
+}
+
+const (
+	endSessionHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="refresh" content="0; url=%s">
+<title>Vault Logout</title>
+</head>
+<body>
+<p>Redirecting to your identity provider to sign out. If you are not redirected, <a href="%s">continue here</a>.</p>
+</body>
+</html>
+`
+
+	loggedOutHTML = `<!DOCTYPE html>
+<html>
+<head>
+<title>Vault Logout</title>
+</head>
+<body>
+<p>You have been signed out. You may now close this window.</p>
+</body>
+</html>
+`
+
+	endSessionHelpSyn = `
+Ends the user's session with the OIDC provider.
+`
+	endSessionHelpDesc = `
+Redirects the browser to the end_session_endpoint advertised in the provider's
+discovery document, passing the optional id_token_hint, a state value and,
+if configured, oidc_post_logout_redirect_uri. Setting the redirect URI to this
+mount's oidc/logged-out path shows a confirmation page once the provider has
+ended the session. The provider must allow the redirect URI for the client.
+`
+)
@@ -0,0 +1,88 @@
+package jwtauth
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestOIDC_EndSession(t *testing.T) {
+	b, storage, s := getBackendAndServerWithConfig(t, false, map[string]interface{}{
+		"oidc_post_logout_redirect_uri": "https://vault.example.com/v1/auth/oidc/oidc/logged-out",
+	})
+	defer s.server.Close()
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "oidc/end-session",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"id_token_hint": "the.id.token",
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	if resp.Data[logical.HTTPStatusCode] != http.StatusOK || resp.Data[logical.HTTPContentType] != "text/html" {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+
+	m := regexp.MustCompile(`href="([^"]+)"`).FindStringSubmatch(string(resp.Data[logical.HTTPRawBody].([]byte)))
+	if m == nil {
+		t.Fatalf("missing redirect link in %s", resp.Data[logical.HTTPRawBody])
+	}
+	endSessionURL, err := url.Parse(html.UnescapeString(m[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(endSessionURL.String(), s.server.URL+"/logout?") {
+		t.Fatalf("unexpected end session URL: %s", endSessionURL)
+	}
+	q := endSessionURL.Query()
+	if q.Get("id_token_hint") != "the.id.token" {
+		t.Fatalf("unexpected id_token_hint: %q", q.Get("id_token_hint"))
+	}
+	if q.Get("post_logout_redirect_uri") != "https://vault.example.com/v1/auth/oidc/oidc/logged-out" {
+		t.Fatalf("unexpected post_logout_redirect_uri: %q", q.Get("post_logout_redirect_uri"))
+	}
+	state := q.Get("state")
+	if state == "" {
+		t.Fatal("missing state")
+	}
+
+	loggedOut := func(state string) int {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "oidc/logged-out",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"state": state,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Data[logical.HTTPStatusCode].(int)
+	}
+
+	if code := loggedOut("nope"); code != http.StatusBadRequest {
+		t.Fatalf("expected bad request for unknown state, got %d", code)
+	}
+	if code := loggedOut(state); code != http.StatusOK {
+		t.Fatalf("expected confirmation page, got %d", code)
+	}
+	if code := loggedOut(state); code != http.StatusBadRequest {
+		t.Fatalf("expected state to be single use, got %d", code)
+	}
+}
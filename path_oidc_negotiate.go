@@ -0,0 +1,173 @@
+package jwtauth
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/cidrutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func pathOIDCNegotiateRole(b *jwtAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: `oidc/negotiate-role`,
+		Fields: map[string]*framework.FieldSchema{
+			"jwt": {
+				Type:        framework.TypeString,
+				Description: "A previously held JWT whose claims are used as hints. It is not verified.",
+			},
+			"claims": {
+				Type:        framework.TypeMap,
+				Description: "Claims to use as hints. Takes precedence over the claims of jwt.",
+			},
+			"ip": {
+				Type:        framework.TypeString,
+				Description: "The address the client will log in from. The recommended role must allow it in its token_bound_cidrs.",
+			},
+			"email": {
+				Type:        framework.TypeString,
+				Description: `The email address the client will log in with. The recommended role must allow it, and its domain, in its bound_claims for "email" and "hd".`,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathNegotiateRole,
+				Summary:  "Recommend a role to log in with, based on hints.",
+			},
+		},
+
+		HelpSynopsis:    negotiateRoleHelpSyn,
+		HelpDescription: negotiateRoleHelpDesc,
+	}
+}
+
+func (b *jwtAuthBackend) pathNegotiateRole(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("could not load configuration"), nil
+	}
+
+	hints := map[string]interface{}{}
+	if token := d.Get("jwt").(string); token != "" {
+		parsedJWT, err := jwt.ParseSigned(token)
+		if err != nil {
+			return logical.ErrorResponse("error parsing jwt: %s", err.Error()), nil
+		}
+		if err := parsedJWT.UnsafeClaimsWithoutVerification(&hints); err != nil {
+			return logical.ErrorResponse("error parsing jwt claims: %s", err.Error()), nil
+		}
+	}
+	for k, v := range d.Get("claims").(map[string]interface{}) {
+		hints[k] = v
+	}
+
+	roleName, source := "", ""
+	if roleName = mappedAudienceRole(config, hintAudiences(hints["aud"])); roleName != "" {
+		source = "audience_role_mapping"
+	} else if roleName = config.DefaultRole; roleName != "" {
+		source = "default_role"
+	}
+
+	if roleName == "" {
+		return logical.ErrorResponse("no role could be determined from the given hints"), nil
+	}
+
+	ip, email := d.Get("ip").(string), d.Get("email").(string)
+	if ip != "" || email != "" {
+		role, err := b.role(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			return logical.ErrorResponse("recommended role %q does not exist", roleName), nil
+		}
+		if resp := b.checkNegotiationHints(role, roleName, ip, email); resp != nil {
+			return resp, nil
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"role":   roleName,
+			"source": source,
+		},
+	}, nil
+}
+
+// checkNegotiationHints returns an error response if the role doesn't allow
+// logins from ip, or with email.
+func (b *jwtAuthBackend) checkNegotiationHints(role *jwtRole, roleName, ip, email string) *logical.Response {
+	if ip != "" {
+		if net.ParseIP(ip) == nil {
+			return logical.ErrorResponse("invalid ip hint %q", ip)
+		}
+		if len(role.TokenBoundCIDRs) > 0 && !cidrutil.RemoteAddrIsOk(ip, role.TokenBoundCIDRs) {
+			return logical.ErrorResponse("role %q does not allow logins from %s", roleName, ip)
+		}
+	}
+
+	if email != "" {
+		i := strings.LastIndex(email, "@")
+		if i <= 0 || i == len(email)-1 {
+			return logical.ErrorResponse("invalid email hint %q", email)
+		}
+
+		// Only the email claims are checked, since the other claims of the
+		// token aren't known.
+		hinted := map[string]interface{}{
+			"email": email,
+			"hd":    email[i+1:],
+		}
+		boundClaims := make(map[string]interface{})
+		for claim := range hinted {
+			if v, ok := role.BoundClaims[claim]; ok {
+				boundClaims[claim] = v
+			}
+		}
+		if err := validateBoundClaims(b.Logger(), role.BoundClaimsType, boundClaims, hinted); err != nil {
+			return logical.ErrorResponse("role %q does not allow logins with email %q: %s", roleName, email, err)
+		}
+	}
+
+	return nil
+}
+
+// hintAudiences returns the audiences of an aud claim, which may be a string
+// or a list of strings.
+func hintAudiences(aud interface{}) []string {
+	switch v := aud.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var audiences []string
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+		return audiences
+	}
+
+	return nil
+}
+
+const (
+	negotiateRoleHelpSyn = `
+Recommends the role to log in with.
+`
+	negotiateRoleHelpDesc = `
+Clients can use this endpoint to select a role before starting a login. The
+audiences in the submitted claims, or in the claims of a previously held JWT,
+are looked up in audience_role_mapping, falling back to default_role. If an
+"ip" or "email" hint is given, the recommended role must allow logins from
+that address in its token_bound_cidrs, and with that email address and its
+domain in its bound_claims for "email" and "hd"; otherwise an error is
+returned. No login is performed, and the JWT is not verified.
+`
+)
@@ -0,0 +1,199 @@
+package jwtauth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestOIDC_NegotiateRole(t *testing.T) {
+	b, storage := getBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"jwt_validation_pubkeys": ecdsaPubKey,
+			"default_role":           "fallback",
+			"audience_role_mapping": map[string]interface{}{
+				"app-a": "role-a",
+				"app-b": "role-b",
+			},
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	token, _ := getTestJWT(t, ecdsaPrivKey, jwt.Claims{
+		Audience: jwt.Audience{"other", "app-b"},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}, map[string]interface{}{})
+
+	tests := map[string]struct {
+		data           map[string]interface{}
+		expectedRole   string
+		expectedSource string
+	}{
+		"jwt": {
+			data:           map[string]interface{}{"jwt": token},
+			expectedRole:   "role-b",
+			expectedSource: "audience_role_mapping",
+		},
+		"claims override jwt": {
+			data: map[string]interface{}{
+				"jwt":    token,
+				"claims": map[string]interface{}{"aud": "app-a"},
+			},
+			expectedRole:   "role-a",
+			expectedSource: "audience_role_mapping",
+		},
+		"no match": {
+			data: map[string]interface{}{
+				"claims": map[string]interface{}{"aud": []interface{}{"other"}},
+			},
+			expectedRole:   "fallback",
+			expectedSource: "default_role",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "oidc/negotiate-role",
+				Storage:   storage,
+				Data:      tt.data,
+			})
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("err:%v resp:%#v\n", err, resp)
+			}
+			if resp.Data["role"] != tt.expectedRole || resp.Data["source"] != tt.expectedSource {
+				t.Fatalf("unexpected result: %v", resp.Data)
+			}
+		})
+	}
+}
+
+func TestOIDC_NegotiateRole_Hints(t *testing.T) {
+	b, storage := getBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"jwt_validation_pubkeys": ecdsaPubKey,
+			"default_role":           "corp",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/corp",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_type":         "jwt",
+			"user_claim":        "sub",
+			"bound_audiences":   "vault",
+			"token_bound_cidrs": "10.0.0.0/8",
+			"bound_claims_type": "glob",
+			"bound_claims": map[string]interface{}{
+				"hd":    "example.com",
+				"email": "*@example.com",
+			},
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	tests := map[string]struct {
+		data   map[string]interface{}
+		errStr string
+	}{
+		"matching hints": {
+			data: map[string]interface{}{"ip": "10.1.2.3", "email": "alice@example.com"},
+		},
+		"no hints": {
+			data: map[string]interface{}{},
+		},
+		"ip outside token_bound_cidrs": {
+			data:   map[string]interface{}{"ip": "192.168.0.1", "email": "alice@example.com"},
+			errStr: "does not allow logins from 192.168.0.1",
+		},
+		"invalid ip": {
+			data:   map[string]interface{}{"ip": "not-an-ip"},
+			errStr: "invalid ip hint",
+		},
+		"email domain not bound": {
+			data:   map[string]interface{}{"ip": "10.1.2.3", "email": "alice@other.com"},
+			errStr: `does not allow logins with email "alice@other.com"`,
+		},
+		"invalid email": {
+			data:   map[string]interface{}{"email": "alice"},
+			errStr: "invalid email hint",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "oidc/negotiate-role",
+				Storage:   storage,
+				Data:      tt.data,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.errStr == "" {
+				if resp == nil || resp.IsError() || resp.Data["role"] != "corp" {
+					t.Fatalf("unexpected response: %#v", resp)
+				}
+				return
+			}
+			if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), tt.errStr) {
+				t.Fatalf("expected error containing %q, got: %#v", tt.errStr, resp)
+			}
+			if code, _ := logical.RespondErrorCommon(&logical.Request{Operation: logical.UpdateOperation}, resp, nil); code != http.StatusBadRequest {
+				t.Fatalf("expected status 400, got %d", code)
+			}
+		})
+	}
+
+	// Hints can't be checked against a role that doesn't exist.
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"jwt_validation_pubkeys": ecdsaPubKey,
+			"default_role":           "missing",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "oidc/negotiate-role",
+		Storage:   storage,
+		Data:      map[string]interface{}{"ip": "10.1.2.3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), `role "missing" does not exist`) {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+}
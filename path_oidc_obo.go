@@ -0,0 +1,206 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/cidrutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+)
+
+// Token exchange identifiers defined in RFC 8693.
+const (
+	grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+func pathOIDCOnBehalfOf(b *jwtAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: `oidc/obo`,
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "The role to log in against.",
+			},
+			"jwt": {
+				Type:        framework.TypeString,
+				Description: "The JWT of the calling service, used as the subject token of the exchange.",
+			},
+			"scope": {
+				Type:        framework.TypeString,
+				Description: "The scope to request for the exchanged token, identifying the target service.",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathOnBehalfOf,
+				Summary:  "Exchange a JWT with the OIDC provider and log in with the resulting token.",
+			},
+		},
+
+		HelpSynopsis:    pathOBOHelpSyn,
+		HelpDescription: pathOBOHelpDesc,
+	}
+}
+
+func (b *jwtAuthBackend) pathOnBehalfOf(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("could not load configuration"), nil
+	}
+
+	if config.authType() != OIDCFlow {
+		return logical.ErrorResponse("token exchange requires 'oidc_client_id' and 'oidc_client_secret' to be configured"), nil
+	}
+
+	roleName := d.Get("role").(string)
+	if roleName == "" {
+		roleName = config.DefaultRole
+	}
+	if roleName == "" {
+		return logical.ErrorResponse("missing role"), nil
+	}
+
+	roleName, err = b.canonicalRoleName(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := b.role(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("role %q could not be found", roleName), nil
+	}
+
+	if role.RoleType == "oidc" {
+		return logical.ErrorResponse("role with oidc role_type is not allowed"), nil
+	}
+
+	defer b.validationMetrics.observe(roleName, time.Now())
+
+	subjectToken := d.Get("jwt").(string)
+	if subjectToken == "" {
+		return logical.ErrorResponse("missing token"), nil
+	}
+
+	if len(role.TokenBoundCIDRs) > 0 {
+		if req.Connection == nil {
+			b.Logger().Warn("token bound CIDRs found but no connection information available for validation")
+			return nil, logical.ErrPermissionDenied
+		}
+		if !cidrutil.RemoteAddrIsOk(req.Connection.RemoteAddr, role.TokenBoundCIDRs) {
+			return nil, logical.ErrPermissionDenied
+		}
+	}
+
+	if err := b.providerBreaker.allow(config); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	provider, err := b.getProvider(config)
+	if err != nil {
+		return nil, errwrap.Wrapf("error getting provider for token exchange: {{err}}", err)
+	}
+
+	oidcCtx, err := b.createCAContext(ctx, config, config.OIDCDiscoveryCAPEM)
+	if err != nil {
+		return nil, errwrap.Wrapf("error preparing context for token exchange: {{err}}", err)
+	}
+
+	token, err := exchangeToken(oidcCtx, config, provider.Endpoint().TokenURL, subjectToken, d.Get("scope").(string), "")
+	if err != nil {
+		return logical.ErrorResponse("%s %s", errTokenVerification, err.Error()), nil
+	}
+
+	allClaims, err := b.verifyOIDCToken(ctx, config, role, token)
+	if err != nil {
+		return logical.ErrorResponse("%s %s", errTokenVerification, err.Error()), nil
+	}
+
+	return b.loginResponse(ctx, req, config, role, roleName, allClaims, nil)
+}
+
+// exchangeToken performs an RFC 8693 token exchange of subjectToken for a
+// token with the given scope or audience, authenticating with the configured
+// client credentials.
+func exchangeToken(ctx context.Context, config *jwtConfig, tokenURL, subjectToken, scope, audience string) (string, error) {
+	form := url.Values{
+		"grant_type":         {grantTypeTokenExchange},
+		"subject_token":      {subjectToken},
+		"subject_token_type": {tokenTypeJWT},
+	}
+	if scope != "" {
+		form.Set("scope", scope)
+	}
+	if audience != "" {
+		form.Set("audience", audience)
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.SetBasicAuth(url.QueryEscape(config.OIDCClientID), url.QueryEscape(config.OIDCClientSecret))
+
+	client, ok := ctx.Value(oauth2.HTTPClient).(*http.Client)
+	if !ok {
+		client = cleanhttp.DefaultClient()
+	}
+
+	resp, err := client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return "", errwrap.Wrapf("error exchanging token: {{err}}", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", errwrap.Wrapf("error reading token exchange response: {{err}}", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token exchange failed with status %d: %s", resp.StatusCode, body)
+	}
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", errwrap.Wrapf("error parsing token exchange response: {{err}}", err)
+	}
+	if tokenResp.AccessToken == "" {
+		return "", errors.New("no access_token found in token exchange response")
+	}
+
+	return tokenResp.AccessToken, nil
+}
+
+const (
+	pathOBOHelpSyn = `
+Authenticates on behalf of another service using OAuth 2.0 token exchange.
+`
+	pathOBOHelpDesc = `
+The submitted JWT is exchanged with the configured OIDC provider for a token
+scoped to the target service (RFC 8693). The exchanged token is validated
+against the role like a JWT login, and a Vault token is issued on success.
+The provider must support the token exchange grant for the configured client.
+`
+)
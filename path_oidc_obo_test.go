@@ -0,0 +1,76 @@
+package jwtauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestOIDC_OnBehalfOf(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	s.subjectToken = "service-a-jwt"
+	s.customClaims = map[string]interface{}{
+		"email": "service-a@example.com",
+	}
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/obo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_type":       "jwt",
+			"user_claim":      "email",
+			"bound_audiences": "service-b",
+			"token_policies":  "service-b",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	tests := []struct {
+		name        string
+		data        map[string]interface{}
+		errExpected string
+	}{
+		{"success", map[string]interface{}{"role": "obo", "jwt": "service-a-jwt", "scope": "service-b"}, ""},
+		{"audience mismatch", map[string]interface{}{"role": "obo", "jwt": "service-a-jwt", "scope": "service-c"}, "aud claim does not match any bound audience"},
+		{"rejected subject token", map[string]interface{}{"role": "obo", "jwt": "other-jwt", "scope": "service-b"}, "token exchange failed with status 400"},
+		{"missing token", map[string]interface{}{"role": "obo", "scope": "service-b"}, "missing token"},
+		{"oidc role", map[string]interface{}{"role": "test", "jwt": "service-a-jwt", "scope": "service-b"}, "role with oidc role_type is not allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "oidc/obo",
+				Storage:   storage,
+				Data:      tt.data,
+			}
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.errExpected != "" {
+				if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), tt.errExpected) {
+					t.Fatalf("expected error containing %q, got: %v", tt.errExpected, resp)
+				}
+				return
+			}
+
+			if resp == nil || resp.IsError() || resp.Auth == nil {
+				t.Fatalf("expected successful login, got: %v", resp)
+			}
+			if resp.Auth.Alias.Name != "service-a@example.com" || resp.Auth.Policies[0] != "service-b" {
+				t.Fatalf("unexpected auth: %#v", resp.Auth)
+			}
+		})
+	}
+}
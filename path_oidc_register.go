@@ -0,0 +1,191 @@
+package jwtauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+)
+
+func pathOIDCRegisterClient(b *jwtAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: `oidc/register-client`,
+		Fields: map[string]*framework.FieldSchema{
+			"registration_endpoint": {
+				Type:        framework.TypeString,
+				Description: "The provider's client registration endpoint. Defaults to the registration_endpoint in the discovery document.",
+			},
+			"redirect_uris": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma-separated list of redirect URIs to register for the client.",
+			},
+			"grant_types": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma-separated list of grant types to register for the client.",
+				Default:     []string{"authorization_code"},
+			},
+			"client_name": {
+				Type:        framework.TypeString,
+				Description: "Optional human-readable name of the client.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRegisterClient,
+				Summary:  "Register an OIDC client with the provider and store its credentials.",
+			},
+		},
+
+		HelpSynopsis:    registerClientHelpSyn,
+		HelpDescription: registerClientHelpDesc,
+	}
+}
+
+// clientRegistrationResponse holds the fields of an RFC 7591 client
+// information response used by the plugin.
+type clientRegistrationResponse struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+func (b *jwtAuthBackend) pathRegisterClient(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil || config.OIDCDiscoveryURL == "" {
+		return logical.ErrorResponse("'oidc_discovery_url' must be configured to register a client"), nil
+	}
+
+	redirectURIs := d.Get("redirect_uris").([]string)
+	if len(redirectURIs) == 0 {
+		return logical.ErrorResponse("missing redirect_uris"), nil
+	}
+
+	registrationEndpoint := d.Get("registration_endpoint").(string)
+	if registrationEndpoint == "" {
+		provider, err := b.getProvider(config)
+		if err != nil {
+			return nil, errwrap.Wrapf("error getting provider for client registration: {{err}}", err)
+		}
+
+		var metadata struct {
+			RegistrationEndpoint string `json:"registration_endpoint"`
+		}
+		if err := provider.Claims(&metadata); err != nil {
+			return nil, err
+		}
+		if metadata.RegistrationEndpoint == "" {
+			return logical.ErrorResponse("OIDC provider does not advertise a registration_endpoint"), nil
+		}
+		registrationEndpoint = metadata.RegistrationEndpoint
+	}
+
+	metadata := map[string]interface{}{
+		"redirect_uris":  redirectURIs,
+		"grant_types":    d.Get("grant_types").([]string),
+		"response_types": []string{"code"},
+	}
+	if clientName := d.Get("client_name").(string); clientName != "" {
+		metadata["client_name"] = clientName
+	}
+
+	oidcCtx, err := b.createCAContext(ctx, config, config.OIDCDiscoveryCAPEM)
+	if err != nil {
+		return nil, errwrap.Wrapf("error preparing context for client registration: {{err}}", err)
+	}
+
+	client, err := registerClient(oidcCtx, registrationEndpoint, config.OIDCRegistrationAccessToken, metadata)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	config.OIDCClientID = client.ClientID
+	config.OIDCClientSecret = client.ClientSecret
+
+	entry, err := logical.StorageEntryJSON(configPath, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	b.reset()
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"client_id": client.ClientID,
+		},
+	}, nil
+}
+
+// registerClient sends an RFC 7591 registration request with the given client
+// metadata.
+func registerClient(ctx context.Context, endpoint, accessToken string, metadata map[string]interface{}) (*clientRegistrationResponse, error) {
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if accessToken != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	client, ok := ctx.Value(oauth2.HTTPClient).(*http.Client)
+	if !ok {
+		client = cleanhttp.DefaultClient()
+	}
+
+	resp, err := client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return nil, errwrap.Wrapf("error registering client: {{err}}", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errwrap.Wrapf("error reading client registration response: {{err}}", err)
+	}
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("client registration failed with status %d: %s", resp.StatusCode, respBody)
+	}
+
+	var registration clientRegistrationResponse
+	if err := json.Unmarshal(respBody, &registration); err != nil {
+		return nil, errwrap.Wrapf("error parsing client registration response: {{err}}", err)
+	}
+	if registration.ClientID == "" || registration.ClientSecret == "" {
+		return nil, errors.New("client registration response must contain client_id and client_secret")
+	}
+
+	return &registration, nil
+}
+
+const (
+	registerClientHelpSyn = `
+Registers an OIDC client with the provider using dynamic client registration.
+`
+	registerClientHelpDesc = `
+Sends an RFC 7591 registration request with the given redirect URIs and grant
+types to the provider's registration endpoint, and stores the returned
+client_id and client_secret in the mount's config. If the provider requires
+an initial access token for registration, set oidc_registration_access_token
+in the config first. oidc_discovery_url must already be configured.
+`
+)
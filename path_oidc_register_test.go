@@ -0,0 +1,57 @@
+package jwtauth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestOIDC_RegisterClient(t *testing.T) {
+	b, storage, s := getBackendAndServerWithConfig(t, false, map[string]interface{}{
+		"oidc_registration_access_token": "reg-token",
+	})
+	defer s.server.Close()
+
+	register := func() *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "oidc/register-client",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"redirect_uris": "https://example.com/callback",
+				"client_name":   "vault",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	s.regToken = "other-token"
+	if resp := register(); resp == nil || !resp.IsError() {
+		t.Fatalf("expected error with wrong registration token, got %#v", resp)
+	}
+
+	s.regToken = "reg-token"
+	resp := register()
+	if resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+	if resp.Data["client_id"] != "registered-client" {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+
+	config, err := b.(*jwtAuthBackend).config(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.OIDCClientID != "registered-client" || config.OIDCClientSecret != "registered-secret" {
+		t.Fatalf("unexpected client credentials: %q/%q", config.OIDCClientID, config.OIDCClientSecret)
+	}
+	if config.OIDCDiscoveryURL != s.server.URL {
+		t.Fatalf("unexpected discovery URL: %q", config.OIDCDiscoveryURL)
+	}
+}
@@ -0,0 +1,65 @@
+package jwtauth
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathOIDCStateCleanup(b *jwtAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: `oidc/state-cleanup`,
+		Fields: map[string]*framework.FieldSchema{
+			"older_than": {
+				Type:        framework.TypeDurationSecond,
+				Description: "If set, pending states created more than this long ago are also deleted, even if they have not expired.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathStateCleanup,
+				Summary:  "Delete expired or abandoned pending OIDC states from storage.",
+			},
+		},
+
+		HelpSynopsis:    stateCleanupHelpSyn,
+		HelpDescription: stateCleanupHelpDesc,
+	}
+}
+
+func (b *jwtAuthBackend) pathStateCleanup(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	olderThan := time.Duration(d.Get("older_than").(int)) * time.Second
+	if olderThan < 0 {
+		return logical.ErrorResponse("older_than must be non-negative"), nil
+	}
+
+	deleted, remaining, err := b.cleanupOIDCStates(ctx, req.Storage, olderThan)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"deleted":   deleted,
+			"remaining": remaining,
+		},
+	}, nil
+}
+
+const (
+	stateCleanupHelpSyn = `
+Deletes expired or abandoned pending OIDC states.
+`
+	stateCleanupHelpDesc = `
+Pending OIDC states and nonces stored with oidc_distributed_state_backend set
+to "vault-storage" are deleted by the periodic function once expired. This
+endpoint deletes them on demand, and with older_than also deletes the states
+created more than older_than ago, e.g. those of logins abandoned in the
+browser. Logins in progress with newer states are not affected.
+
+The response contains the number of deleted and remaining states. This
+endpoint requires sudo capability.
+`
+)
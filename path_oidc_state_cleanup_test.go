@@ -0,0 +1,89 @@
+package jwtauth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestOIDC_StateCleanup(t *testing.T) {
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	if !strutil.StrListContains(b.SpecialPaths().Root, "oidc/state-cleanup") {
+		t.Fatal("expected oidc/state-cleanup to require sudo")
+	}
+
+	now := time.Now()
+	for key, state := range map[string]storedOIDCState{
+		"expired":   {Created: now.Add(-20 * time.Minute), Expiry: now.Add(-10 * time.Minute)},
+		"expired2":  {Created: now.Add(-11 * time.Minute), Expiry: now.Add(-time.Minute)},
+		"pending":   {Created: now.Add(-time.Minute), Expiry: now.Add(9 * time.Minute)},
+		"abandoned": {Created: now.Add(-2 * time.Hour), Expiry: now.Add(time.Hour)},
+	} {
+		entry, err := logical.StorageEntryJSON(oidcStatePrefix+key, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Put(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cleanup := func(data map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "oidc/state-cleanup",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		return resp.Data
+	}
+	assertKeys := func(expected ...string) {
+		t.Helper()
+		keys, err := storage.List(ctx, oidcStatePrefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != len(expected) {
+			t.Fatalf("expected states %v, got: %v", expected, keys)
+		}
+		for i := range keys {
+			if keys[i] != expected[i] {
+				t.Fatalf("expected states %v, got: %v", expected, keys)
+			}
+		}
+	}
+
+	if data := cleanup(nil); data["deleted"] != 2 || data["remaining"] != 2 {
+		t.Fatalf("unexpected response: %v", data)
+	}
+	assertKeys("abandoned", "pending")
+
+	// The cleanup is idempotent.
+	if data := cleanup(nil); data["deleted"] != 0 || data["remaining"] != 2 {
+		t.Fatalf("unexpected response: %v", data)
+	}
+
+	if data := cleanup(map[string]interface{}{"older_than": "1h"}); data["deleted"] != 1 || data["remaining"] != 1 {
+		t.Fatalf("unexpected response: %v", data)
+	}
+	assertKeys("pending")
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "oidc/state-cleanup",
+		Storage:   storage,
+		Data:      map[string]interface{}{"older_than": "-1h"},
+	})
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatal("expected error for negative older_than")
+	}
+}
@@ -3,7 +3,9 @@ package jwtauth
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
@@ -13,6 +15,7 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -54,6 +57,7 @@ func TestOIDC_AuthURL(t *testing.T) {
 		"user_claim":            "email",
 		"bound_audiences":       "vault",
 		"allowed_redirect_uris": []string{"https://example.com"},
+		"pkce_required":         false,
 	}
 
 	req = &logical.Request{
@@ -208,6 +212,183 @@ func TestOIDC_AuthURL(t *testing.T) {
 	})
 }
 
+func TestOIDC_AuthURL_OfflineAccess(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	getScopes := func() string {
+		t.Helper()
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "oidc/auth_url",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role":         "test",
+				"redirect_uri": "https://example.com",
+			},
+		}
+
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v\n", err, resp)
+		}
+
+		return getQueryParam(t, resp.Data["auth_url"].(string), "scope")
+	}
+
+	if scopes := getScopes(); strings.Contains(scopes, "offline_access") {
+		t.Fatalf("unexpected offline_access scope: %q", scopes)
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"oidc_allow_offline_access": true,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	if scopes := getScopes(); scopes != "openid offline_access" {
+		t.Fatalf("expected offline_access scope, got: %q", scopes)
+	}
+
+	config, err := b.(*jwtAuthBackend).config(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := b.(*jwtAuthBackend).getProvider(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if providerSupportsScope(provider, "not_advertised") {
+		t.Fatal("expected unadvertised scope to be unsupported")
+	}
+}
+
+func TestOIDC_AuthURL_LoginHint(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "oidc/auth_url",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role":         "test",
+			"redirect_uri": "https://example.com",
+			"login_hint":   "bob@example.com",
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	if hint := getQueryParam(t, resp.Data["auth_url"].(string), "login_hint"); hint != "bob@example.com" {
+		t.Fatalf("unexpected login_hint: %q", hint)
+	}
+}
+
+func TestOIDC_AuthURL_Prompt(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "oidc/auth_url",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role":         "test",
+			"redirect_uri": "https://example.com",
+			"prompt":       "none",
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	if prompt := getQueryParam(t, resp.Data["auth_url"].(string), "prompt"); prompt != "none" {
+		t.Fatalf("unexpected prompt: %q", prompt)
+	}
+
+	req.Data["prompt"] = "always"
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for invalid prompt, got: %#v", resp)
+	}
+}
+
+func TestOIDC_AuthURL_Signed(t *testing.T) {
+	b, storage, s := getBackendAndServerWithConfig(t, false, map[string]interface{}{
+		"oidc_sign_auth_url": true,
+	})
+	defer s.server.Close()
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "oidc/auth_url",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role":         "test",
+			"redirect_uri": "https://example.com",
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	authURL := resp.Data["auth_url"].(string)
+	if getQueryParam(t, authURL, authURLSignatureParam) == "" {
+		t.Fatalf("missing signature in %q", authURL)
+	}
+
+	tests := map[string]struct {
+		url   string
+		valid bool
+	}{
+		"signed":           {authURL, true},
+		"tampered":         {strings.Replace(authURL, "redirect_uri=", "redirect_uri=evil", 1), false},
+		"signature copied": {"https://evil.example.com/?" + authURLSignatureParam + "=" + getQueryParam(t, authURL, authURLSignatureParam), false},
+		"unsigned":         {"https://example.com/auth", false},
+	}
+
+	for name, tt := range tests {
+		req = &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "oidc/verify_auth_url",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"auth_url": tt.url,
+			},
+		}
+
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("%s: err:%v resp:%#v\n", name, err, resp)
+		}
+
+		if resp.Data["valid"] != tt.valid {
+			t.Fatalf("%s: expected valid=%t, got %v", name, tt.valid, resp.Data["valid"])
+		}
+		if resp.Data["signing_required"] != true {
+			t.Fatalf("%s: expected signing_required, got %v", name, resp.Data["signing_required"])
+		}
+	}
+}
+
 func TestOIDC_Callback(t *testing.T) {
 	t.Run("successful login", func(t *testing.T) {
 
@@ -271,7 +452,8 @@ func TestOIDC_Callback(t *testing.T) {
 					MaxTTL:    5 * time.Minute,
 				},
 				InternalData: map[string]interface{}{
-					"role": "test",
+					"role":         "test",
+					"oidc_subject": "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
 				},
 				DisplayName: "bob@example.com",
 				Alias: &logical.Alias{
@@ -302,6 +484,20 @@ func TestOIDC_Callback(t *testing.T) {
 
 			auth := resp.Auth
 
+			var classification tokenClassification
+			if err := json.Unmarshal([]byte(auth.Metadata[tokenClassificationMetadata]), &classification); err != nil {
+				t.Fatal(err)
+			}
+			if classification.AuthMethod != "oidc" || classification.Role != "test" || classification.Provider == "" {
+				t.Fatalf("unexpected token classification: %v", classification)
+			}
+			delete(auth.Metadata, tokenClassificationMetadata)
+
+			if _, ok := auth.InternalData["login_time"].(int64); !ok {
+				t.Fatalf("expected login time, got: %v", auth.InternalData)
+			}
+			delete(auth.InternalData, "login_time")
+
 			if !reflect.DeepEqual(auth, expected) {
 				t.Fatalf("expected: %v, auth: %v", expected, resp)
 			}
@@ -685,6 +881,165 @@ func TestOIDC_Callback(t *testing.T) {
 			t.Fatalf("expected invalid client_id error, got : %v", *resp)
 		}
 	})
+
+	t.Run("state cookie", func(t *testing.T) {
+		b, storage, s := getBackendAndServerWithConfig(t, false, map[string]interface{}{
+			"oidc_use_state_cookie": true,
+		})
+		defer s.server.Close()
+
+		s.code = "abc"
+
+		tests := map[string]struct {
+			cookie  func(state string) string
+			success bool
+		}{
+			"matching cookie":  {func(state string) string { return oidcStateCookieName + "=" + state }, true},
+			"mismatched":       {func(state string) string { return oidcStateCookieName + "=nope" }, false},
+			"missing cookie":   {func(state string) string { return "" }, false},
+			"unrelated cookie": {func(state string) string { return "other=" + state }, false},
+		}
+
+		for name, tt := range tests {
+			req := &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "oidc/auth_url",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"role":         "test",
+					"redirect_uri": "https://example.com",
+				},
+			}
+
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("err:%v resp:%#v\n", err, resp)
+			}
+
+			authURL := resp.Data["auth_url"].(string)
+			state := getQueryParam(t, authURL, "state")
+			nonce := getQueryParam(t, authURL, "nonce")
+
+			setCookie := resp.Headers["Set-Cookie"]
+			if len(setCookie) != 1 || !strings.HasPrefix(setCookie[0], oidcStateCookieName+"="+state+";") {
+				t.Fatalf("%s: unexpected Set-Cookie header: %v", name, setCookie)
+			}
+
+			s.customClaims = sampleClaims(nonce)
+
+			req = &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      "oidc/callback",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"state": state,
+					"code":  "abc",
+				},
+				Headers: map[string][]string{},
+			}
+			if c := tt.cookie(state); c != "" {
+				req.Headers["Cookie"] = []string{c}
+			}
+
+			resp, err = b.HandleRequest(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.success {
+				if resp.IsError() || resp.Auth == nil {
+					t.Fatalf("%s: expected successful login, got: %v", name, resp)
+				}
+				continue
+			}
+			if !resp.IsError() || !strings.Contains(resp.Error().Error(), "state cookie") {
+				t.Fatalf("%s: expected state cookie error, got: %v", name, resp)
+			}
+
+			// The rejected request doesn't consume the state, so the
+			// browser holding the cookie can still complete the login.
+			req.Headers["Cookie"] = []string{oidcStateCookieName + "=" + state}
+			resp, err = b.HandleRequest(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.IsError() || resp.Auth == nil {
+				t.Fatalf("%s: expected successful login with the cookie, got: %v", name, resp)
+			}
+		}
+	})
+
+	t.Run("state bound to client IP", func(t *testing.T) {
+		b, storage, s := getBackendAndServerWithConfig(t, false, map[string]interface{}{
+			"oidc_bind_ip_to_state": true,
+		})
+		defer s.server.Close()
+
+		s.code = "abc"
+
+		tests := map[string]struct {
+			callbackAddr string
+			success      bool
+		}{
+			"same address":      {"127.0.0.1", true},
+			"different address": {"10.0.0.1", false},
+			"no connection":     {"", false},
+		}
+
+		for name, tt := range tests {
+			req := &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "oidc/auth_url",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"role":         "test",
+					"redirect_uri": "https://example.com",
+				},
+				Connection: &logical.Connection{
+					RemoteAddr: "127.0.0.1",
+				},
+			}
+
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("err:%v resp:%#v\n", err, resp)
+			}
+
+			authURL := resp.Data["auth_url"].(string)
+			state := getQueryParam(t, authURL, "state")
+			nonce := getQueryParam(t, authURL, "nonce")
+
+			s.customClaims = sampleClaims(nonce)
+
+			req = &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      "oidc/callback",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"state": state,
+					"code":  "abc",
+				},
+			}
+			if tt.callbackAddr != "" {
+				req.Connection = &logical.Connection{
+					RemoteAddr: tt.callbackAddr,
+				}
+			}
+
+			resp, err = b.HandleRequest(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.success {
+				if resp.IsError() || resp.Auth == nil {
+					t.Fatalf("%s: expected successful login, got: %v", name, resp)
+				}
+			} else if !resp.IsError() || !strings.Contains(resp.Error().Error(), "Client address does not match") {
+				t.Fatalf("%s: expected client address error, got: %v", name, resp)
+			}
+		}
+	})
 }
 
 // oidcProvider is local server the mocks the basis endpoints used by the
@@ -695,7 +1050,53 @@ type oidcProvider struct {
 	clientID     string
 	clientSecret string
 	code         string
-	customClaims map[string]interface{}
+	subjectToken string
+	regToken     string
+
+	// accessTokenClaims are the private claims of a separate JWT access token
+	accessTokenClaims map[string]interface{}
+	customClaims      map[string]interface{}
+
+	// codeChallenge, if set, is the PKCE challenge the code_verifier sent to
+	// the token endpoint must match
+	codeChallenge string
+
+	// deviceCode is issued by the device authorization endpoint, and
+	// devicePollErrors are returned by successive device code token
+	// requests before tokens are issued
+	deviceCode       string
+	devicePollErrors []string
+	devicePolls      int
+
+	// grantedScope, if set, is returned as the scope of the token response
+	grantedScope string
+
+	// discoveryFetches counts the requests for the discovery document,
+	// which fail while discoveryDown is set and advertise "/certs-rotated"
+	// as jwks_uri while jwksRotated is set. All are accessed atomically.
+	discoveryFetches int32
+	discoveryDown    int32
+	jwksRotated      int32
+
+	// requestParameterSupported is advertised in the discovery document
+	requestParameterSupported bool
+
+	// userInfoToken, if set, is the only access token accepted by the
+	// userinfo endpoint, which then returns userInfoClaims
+	userInfoToken  string
+	userInfoClaims map[string]interface{}
+
+	// clientAssertionKey, if set, is the PEM public key that the client
+	// assertion sent to the token endpoint must be signed with
+	clientAssertionKey string
+
+	// clientAuthMethod is the client authentication method of the last
+	// authorization code exchange
+	clientAuthMethod string
+
+	// rejectSecretBasic makes the token endpoint reject client_secret_basic
+	// client authentication
+	rejectSecretBasic bool
 }
 
 func newOIDCProvider(t *testing.T) *oidcProvider {
@@ -711,14 +1112,28 @@ func (o *oidcProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	switch r.URL.Path {
 	case "/.well-known/openid-configuration":
-		w.Write([]byte(strings.Replace(`
+		atomic.AddInt32(&o.discoveryFetches, 1)
+		if atomic.LoadInt32(&o.discoveryDown) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		jwksPath := "/certs"
+		if atomic.LoadInt32(&o.jwksRotated) == 1 {
+			jwksPath = "/certs-rotated"
+		}
+		w.Write([]byte(fmt.Sprintf(strings.Replace(`
 			{
 				"issuer": "%s",
 				"authorization_endpoint": "%s/auth",
 				"token_endpoint": "%s/token",
-				"jwks_uri": "%s/certs",
-				"userinfo_endpoint": "%s/userinfo"
-			}`, "%s", o.server.URL, -1)))
+				"jwks_uri": "%s`+jwksPath+`",
+				"userinfo_endpoint": "%s/userinfo",
+				"end_session_endpoint": "%s/logout",
+				"registration_endpoint": "%s/register",
+				"device_authorization_endpoint": "%s/device",
+				"scopes_supported": ["openid", "email", "offline_access"],
+				"request_parameter_supported": %t
+			}`, "%s", o.server.URL, -1), o.requestParameterSupported)))
 	case "/certs":
 		a := getTestJWKS(o.t, ecdsaPubKey)
 		w.Write(a)
@@ -727,6 +1142,29 @@ func (o *oidcProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case "/certs_invalid":
 		w.Write([]byte("It's not a keyset!"))
 	case "/token":
+		if r.FormValue("grant_type") == grantTypeTokenExchange {
+			o.handleTokenExchange(w, r)
+			break
+		}
+		if r.FormValue("grant_type") == grantTypeDeviceCode {
+			o.handleDeviceToken(w, r)
+			break
+		}
+
+		o.clientAuthMethod = tokenClientAuthMethod(r)
+		if o.rejectSecretBasic && o.clientAuthMethod == clientAuthMethodSecretBasic {
+			w.WriteHeader(401)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			break
+		}
+		if o.clientAssertionKey != "" {
+			if err := o.verifyClientAssertion(r); err != nil {
+				w.WriteHeader(401)
+				w.Write([]byte(`{"error":"invalid_client"}`))
+				break
+			}
+		}
+
 		code := r.FormValue("code")
 
 		if code != o.code {
@@ -734,27 +1172,32 @@ func (o *oidcProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 
-		stdClaims := jwt.Claims{
-			Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
-			Issuer:    o.server.URL,
-			NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
-			Expiry:    jwt.NewNumericDate(time.Now().Add(5 * time.Second)),
-			Audience:  jwt.Audience{o.clientID},
+		if o.codeChallenge != "" && pkceChallenge(r.FormValue("code_verifier")) != o.codeChallenge {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			break
 		}
-		jwtData, _ := getTestJWT(o.t, ecdsaPrivKey, stdClaims, o.customClaims)
-		w.Write([]byte(fmt.Sprintf(`
-			{
-				"access_token":"%s",
-				"id_token":"%s"
-			}`,
-			jwtData,
-			jwtData,
-		)))
+
+		o.writeTokens(w)
+	case "/device":
+		o.handleDeviceAuthorization(w, r)
+	case "/register":
+		o.handleRegister(w, r)
 	case "/userinfo":
+		if o.userInfoToken != "" {
+			if r.Header.Get("Authorization") != "Bearer "+o.userInfoToken {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"invalid_token"}`))
+				return
+			}
+			json.NewEncoder(w).Encode(o.userInfoClaims)
+			return
+		}
 		w.Write([]byte(`
 			{
 				"color":"red",
-				"temperature":"76"
+				"temperature":"76",
+				"groups":["Everyone", "okta-admins"]
 			}`))
 
 	default:
@@ -762,10 +1205,89 @@ func (o *oidcProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// getTLSCert returns the certificate for this provider in PEM format
-func (o *oidcProvider) getTLSCert() (string, error) {
-	cert := o.server.Certificate()
-	block := &pem.Block{
+// writeTokens writes a token response with an ID token carrying
+// customClaims.
+func (o *oidcProvider) writeTokens(w http.ResponseWriter) {
+	stdClaims := jwt.Claims{
+		Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+		Issuer:    o.server.URL,
+		NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
+		Expiry:    jwt.NewNumericDate(time.Now().Add(5 * time.Second)),
+		Audience:  jwt.Audience{o.clientID},
+	}
+	jwtData, _ := getTestJWT(o.t, ecdsaPrivKey, stdClaims, o.customClaims)
+	accessToken := jwtData
+	if o.accessTokenClaims != nil {
+		accessToken, _ = getTestJWT(o.t, ecdsaPrivKey, stdClaims, o.accessTokenClaims)
+	}
+	resp := map[string]interface{}{
+		"access_token": accessToken,
+		"id_token":     jwtData,
+	}
+	if o.grantedScope != "" {
+		resp["scope"] = o.grantedScope
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleTokenExchange issues an access token for the requested scope if the
+// subject token and client credentials match.
+func (o *oidcProvider) handleTokenExchange(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, _ := r.BasicAuth()
+	if clientID != o.clientID || clientSecret != o.clientSecret ||
+		r.FormValue("subject_token") != o.subjectToken || r.FormValue("subject_token_type") != tokenTypeJWT {
+		w.WriteHeader(400)
+		w.Write([]byte(`{"error":"invalid_request"}`))
+		return
+	}
+
+	stdClaims := jwt.Claims{
+		Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+		Issuer:    o.server.URL,
+		NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
+		Expiry:    jwt.NewNumericDate(time.Now().Add(5 * time.Second)),
+		Audience:  jwt.Audience{r.FormValue("scope")},
+	}
+	if audience := r.FormValue("audience"); audience != "" {
+		stdClaims.Audience = jwt.Audience{audience}
+	}
+	jwtData, _ := getTestJWT(o.t, ecdsaPrivKey, stdClaims, o.customClaims)
+	w.Write([]byte(fmt.Sprintf(`
+		{
+			"access_token":"%s",
+			"issued_token_type":"%s",
+			"token_type":"Bearer"
+		}`,
+		jwtData,
+		tokenTypeJWT,
+	)))
+}
+
+// handleRegister registers a client if the initial access token matches.
+func (o *oidcProvider) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+o.regToken {
+		w.WriteHeader(401)
+		return
+	}
+
+	var metadata map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil || metadata["redirect_uris"] == nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	w.WriteHeader(201)
+	w.Write([]byte(`
+		{
+			"client_id":"registered-client",
+			"client_secret":"registered-secret"
+		}`))
+}
+
+// getTLSCert returns the certificate for this provider in PEM format
+func (o *oidcProvider) getTLSCert() (string, error) {
+	cert := o.server.Certificate()
+	block := &pem.Block{
 		Type:  "CERTIFICATE",
 		Bytes: cert.Raw,
 	}
@@ -822,6 +1344,373 @@ func getTestJWKS(t *testing.T, pubKey string) []byte {
 	return data
 }
 
+func TestOIDC_InlineData(t *testing.T) {
+	b, storage, s := getBackendAndServerWithConfig(t, false, map[string]interface{}{
+		"oidc_inline_data_max_bytes": 16,
+	})
+	defer s.server.Close()
+
+	s.code = "abc"
+
+	authURL := func(inlineData string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "oidc/auth_url",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role":         "test",
+				"redirect_uri": "https://example.com",
+				"inline_data":  inlineData,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	for _, inlineData := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("/this/path/is/too/long"))} {
+		if resp := authURL(inlineData); resp == nil || !resp.IsError() {
+			t.Fatalf("expected error for inline_data %q, got: %v", inlineData, resp)
+		}
+	}
+
+	inlineData := base64.StdEncoding.EncodeToString([]byte("/ui/vault/kv"))
+	resp := authURL(inlineData)
+	if resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+
+	url := resp.Data["auth_url"].(string)
+	s.customClaims = sampleClaims(getQueryParam(t, url, "nonce"))
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "oidc/callback",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"state": getQueryParam(t, url, "state"),
+			"code":  "abc",
+		},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	if resp.Auth.Metadata["inline_data"] != inlineData {
+		t.Fatalf("unexpected token metadata: %v", resp.Auth.Metadata)
+	}
+}
+
+func TestOIDC_PKCE(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	s.code = "abc"
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"pkce_required": true,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	verifier, err := newCodeVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := pkceChallenge(verifier)
+
+	authURL := func(codeChallenge string) *logical.Response {
+		t.Helper()
+		data := map[string]interface{}{
+			"role":         "test",
+			"redirect_uri": "https://example.com",
+		}
+		if codeChallenge != "" {
+			data["code_challenge"] = codeChallenge
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "oidc/auth_url",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := authURL(""); !resp.IsError() {
+		t.Fatalf("expected error without code_challenge, got: %v", resp)
+	}
+
+	callback := func(codeVerifier string) *logical.Response {
+		t.Helper()
+		resp := authURL(challenge)
+		if resp.IsError() {
+			t.Fatal(resp.Error())
+		}
+		url := resp.Data["auth_url"].(string)
+		if getQueryParam(t, url, "code_challenge") != challenge || getQueryParam(t, url, "code_challenge_method") != "S256" {
+			t.Fatalf("expected PKCE parameters in auth_url: %s", url)
+		}
+		s.customClaims = sampleClaims(getQueryParam(t, url, "nonce"))
+		s.codeChallenge = challenge
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "oidc/callback",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"state":         getQueryParam(t, url, "state"),
+				"code":          "abc",
+				"code_verifier": codeVerifier,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	for _, codeVerifier := range []string{"", "wrong"} {
+		if resp := callback(codeVerifier); !resp.IsError() {
+			t.Fatalf("expected error for code_verifier %q, got: %v", codeVerifier, resp)
+		}
+	}
+
+	if resp := callback(verifier); resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp.Error())
+	}
+}
+
+func TestOIDC_Callback_AccessTokenClaims(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	s.code = "abc"
+	s.accessTokenClaims = map[string]interface{}{
+		"email": "other@example.com",
+		"team":  "platform",
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"oidc_use_access_token_claims": true,
+			"claim_mappings": map[string]string{
+				"team": "team",
+			},
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "oidc/auth_url",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role":         "test",
+			"redirect_uri": "https://example.com",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	authURL := resp.Data["auth_url"].(string)
+	s.customClaims = sampleClaims(getQueryParam(t, authURL, "nonce"))
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "oidc/callback",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"state": getQueryParam(t, authURL, "state"),
+			"code":  "abc",
+		},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	if resp.Auth.Alias.Name != "bob@example.com" {
+		t.Fatalf("expected ID token claims to take precedence, got alias %q", resp.Auth.Alias.Name)
+	}
+	if resp.Auth.Alias.Metadata["team"] != "platform" {
+		t.Fatalf("unexpected metadata: %v", resp.Auth.Alias.Metadata)
+	}
+}
+
+func TestOIDC_Callback_ProviderError(t *testing.T) {
+	b, storage, s := getBackendAndServerWithConfig(t, false, map[string]interface{}{
+		"oidc_error_mapping": map[string]string{
+			"access_denied": "Your account does not have access to this Vault environment.",
+		},
+	})
+	defer s.server.Close()
+
+	tests := map[string]struct {
+		data     map[string]interface{}
+		expected string
+	}{
+		"mapped": {
+			map[string]interface{}{"error": "access_denied", "error_description": "denied by policy"},
+			errLoginFailed + " Your account does not have access to this Vault environment.",
+		},
+		"unmapped": {
+			map[string]interface{}{"error": "temporarily_unavailable", "error_description": "try later"},
+			errLoginFailed + ` Provider returned error "temporarily_unavailable": try later`,
+		},
+	}
+
+	for name, tt := range tests {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "oidc/auth_url",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role":         "test",
+				"redirect_uri": "https://example.com",
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v\n", err, resp)
+		}
+
+		tt.data["state"] = getQueryParam(t, resp.Data["auth_url"].(string), "state")
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "oidc/callback",
+			Storage:   storage,
+			Data:      tt.data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !resp.IsError() || resp.Error().Error() != tt.expected {
+			t.Fatalf("%s: expected error %q, got: %v", name, tt.expected, resp)
+		}
+	}
+}
+
+func TestOIDC_Callback_ImplicitFlow(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"oidc_flow": "implicit",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+	if len(resp.Warnings) == 0 {
+		t.Fatal("expected deprecation warning")
+	}
+
+	const accessToken = "implicit-access-token"
+	sum := sha256.Sum256([]byte(accessToken))
+	atHash := base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+
+	tests := map[string]struct {
+		atHash      string
+		accessToken string
+		noIDToken   bool
+		success     bool
+	}{
+		"id token only":            {success: true},
+		"with access token":        {atHash: atHash, accessToken: accessToken, success: true},
+		"missing at_hash":          {accessToken: accessToken},
+		"mismatched access token":  {atHash: atHash, accessToken: "other"},
+		"code instead of id token": {noIDToken: true},
+	}
+
+	for name, tt := range tests {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "oidc/auth_url",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role":         "test",
+				"redirect_uri": "https://example.com",
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v\n", err, resp)
+		}
+
+		authURL := resp.Data["auth_url"].(string)
+		if responseType := getQueryParam(t, authURL, "response_type"); responseType != "id_token token" {
+			t.Fatalf("unexpected response_type: %q", responseType)
+		}
+		state := getQueryParam(t, authURL, "state")
+		nonce := getQueryParam(t, authURL, "nonce")
+
+		// normally returned by the userinfo endpoint, which is only called
+		// with an access token
+		claims := sampleClaims(nonce)
+		claims["temperature"] = "76"
+		if tt.atHash != "" {
+			claims["at_hash"] = tt.atHash
+		}
+		idToken, _ := getTestJWT(t, ecdsaPrivKey, jwt.Claims{
+			Issuer:    s.server.URL,
+			NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
+			Expiry:    jwt.NewNumericDate(time.Now().Add(5 * time.Second)),
+			Audience:  jwt.Audience{s.clientID},
+		}, claims)
+
+		data := map[string]interface{}{
+			"state": state,
+		}
+		if tt.noIDToken {
+			data["code"] = "abc"
+		} else {
+			data["id_token"] = idToken
+			data["access_token"] = tt.accessToken
+		}
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "oidc/callback",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if tt.success {
+			if resp.IsError() {
+				t.Fatalf("%s: unexpected error: %v", name, resp.Error())
+			}
+			if resp.Auth.Alias.Name != "bob@example.com" || len(resp.Warnings) == 0 {
+				t.Fatalf("%s: unexpected response: %#v", name, resp)
+			}
+		} else if !resp.IsError() {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
 func TestOIDC_ValidRedirect(t *testing.T) {
 	tests := []struct {
 		uri      string
@@ -854,7 +1743,117 @@ func TestOIDC_ValidRedirect(t *testing.T) {
 	}
 }
 
+func TestOIDC_RedirectAllowed_Glob(t *testing.T) {
+	glob := &jwtRole{
+		RedirectURIMatchType: redirectURIMatchGlob,
+		AllowedRedirectURIs: []string{
+			"https://example.com/callback",
+			"https://*.example.com/auth/callback",
+			"https://tenant-*.example.com:8443/callback",
+			"https://*.example.com/native?mode=app",
+		},
+	}
+
+	tests := []struct {
+		uri      string
+		expected bool
+	}{
+		{"https://example.com/callback", true},
+		{"https://app.example.com/auth/callback", true},
+		{"https://APP.Example.com/auth/callback", true},
+		{"https://tenant-a.example.com:8443/callback", true},
+		{"https://app.example.com/native?mode=app", true},
+
+		// '*' matches a single label of the host
+		{"https://a.b.example.com/auth/callback", false},
+		{"https://example.com/auth/callback", false},
+		{"https://.example.com/auth/callback", false},
+		{"https://app.example.com.evil.com/auth/callback", false},
+		{"https://evil.com/x.example.com/auth/callback", false},
+
+		// scheme, port and path must match exactly
+		{"http://app.example.com/auth/callback", false},
+		{"https://app.example.com:8443/auth/callback", false},
+		{"https://tenant-a.example.com/callback", false},
+		{"https://app.example.com/auth/callback/extra", false},
+		{"https://example.com/callback2", false},
+
+		// a query or fragment the pattern lacks is rejected
+		{"https://app.example.com/auth/callback?session=xyz", false},
+		{"https://app.example.com/auth/callback?", false},
+		{"https://app.example.com/auth/callback#frag", false},
+		{"https://app.example.com/native?mode=app&next=evil", false},
+		{"https://app.example.com/native?mode=app#frag", false},
+		{"https://evil.com#.example.com/auth/callback", false},
+		{"https://evil.com?.example.com/auth/callback", false},
+
+		// userinfo is rejected
+		{"https://evil.com@app.example.com/auth/callback", false},
+	}
+	for _, test := range tests {
+		if glob.redirectAllowed(test.uri) != test.expected {
+			t.Fatalf("Fail on %s. Expected: %t", test.uri, test.expected)
+		}
+	}
+
+	// Patterns are only interpreted with the glob match type.
+	exact := &jwtRole{
+		RedirectURIMatchType: redirectURIMatchExact,
+		AllowedRedirectURIs:  glob.AllowedRedirectURIs,
+	}
+	if exact.redirectAllowed("https://app.example.com/auth/callback") {
+		t.Fatal("expected an exact role to reject a URI matching a pattern")
+	}
+	if !exact.redirectAllowed("https://example.com/callback") {
+		t.Fatal("expected an exact role to allow an exact match")
+	}
+}
+
+func TestPath_RedirectURIMatchType_Invalid(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for _, tt := range []struct {
+		data   map[string]interface{}
+		errMsg string
+	}{
+		{map[string]interface{}{"redirect_uri_match_type": "regex"}, "invalid 'redirect_uri_match_type'"},
+		{map[string]interface{}{"redirect_uri_match_type": "glob", "allowed_redirect_uris": "https://[example.com/*"}, "invalid pattern"},
+		{map[string]interface{}{"redirect_uri_match_type": "glob", "allowed_redirect_uris": "https://app.example.com/*"}, "only allowed in the host"},
+		{map[string]interface{}{"redirect_uri_match_type": "glob", "allowed_redirect_uris": "https://*.example.com/cb?next=*"}, "only allowed in the host"},
+		{map[string]interface{}{"redirect_uri_match_type": "glob", "allowed_redirect_uris": "http*://app.example.com/cb"}, "invalid pattern"},
+		{map[string]interface{}{"redirect_uri_match_type": "glob", "allowed_redirect_uris": "https://*.com/cb"}, "last two labels"},
+		{map[string]interface{}{"redirect_uri_match_type": "glob", "allowed_redirect_uris": "https://*/cb"}, "last two labels"},
+		{map[string]interface{}{"redirect_uri_match_type": "glob", "allowed_redirect_uris": "*.example.com/cb"}, "absolute URL"},
+	} {
+		data := map[string]interface{}{
+			"user_claim":            "user",
+			"allowed_redirect_uris": "https://example.com",
+		}
+		for k, v := range tt.data {
+			data[k] = v
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/test",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.IsError() || !strings.Contains(resp.Error().Error(), tt.errMsg) {
+			t.Fatalf("expected error %q, got: %v", tt.errMsg, resp)
+		}
+	}
+}
+
 func getBackendAndServer(t *testing.T, boundCIDRs bool) (logical.Backend, logical.Storage, *oidcProvider) {
+	return getBackendAndServerWithConfig(t, boundCIDRs, nil)
+}
+
+// getBackendAndServerWithConfig is like getBackendAndServer but merges
+// extraConfig into the backend configuration.
+func getBackendAndServerWithConfig(t *testing.T, boundCIDRs bool, extraConfig map[string]interface{}) (logical.Backend, logical.Storage, *oidcProvider) {
 	b, storage := getBackend(t)
 	s := newOIDCProvider(t)
 	s.clientID = "abc"
@@ -875,6 +1874,9 @@ func getBackendAndServer(t *testing.T, boundCIDRs bool) (logical.Backend, logica
 		"bound_issuer":          "http://vault.example.com/",
 		"jwt_supported_algs":    []string{"ES256"},
 	}
+	for k, v := range extraConfig {
+		data[k] = v
+	}
 
 	// basic configuration
 	req := &logical.Request{
@@ -889,10 +1891,11 @@ func getBackendAndServer(t *testing.T, boundCIDRs bool) (logical.Backend, logica
 		t.Fatalf("err:%v resp:%#v\n", err, resp)
 	}
 
-	// set up test role
+	// set up test role. PKCE is covered by TestOIDC_PKCE.
 	data = map[string]interface{}{
 		"user_claim":            "email",
 		"allowed_redirect_uris": []string{"https://example.com"},
+		"pkce_required":         false,
 		"claim_mappings": map[string]string{
 			"COLOR":        "color",
 			"/nested/Size": "size",
@@ -942,3 +1945,394 @@ func sampleClaims(nonce string) map[string]interface{} {
 		"password": "foo",
 	}
 }
+
+func TestOIDC_RequiredScopes(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	s.code = "abc"
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"required_scopes": "groups,admin",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	login := func(grantedScope string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "oidc/auth_url",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role":         "test",
+				"redirect_uri": "https://example.com",
+			},
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("err:%v resp:%#v\n", err, resp)
+		}
+
+		url := resp.Data["auth_url"].(string)
+		scope := getQueryParam(t, url, "scope")
+		if !strings.Contains(scope, "groups") || !strings.Contains(scope, "admin") {
+			t.Fatalf("expected required scopes to be requested, got: %q", scope)
+		}
+
+		s.customClaims = sampleClaims(getQueryParam(t, url, "nonce"))
+		s.grantedScope = grantedScope
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "oidc/callback",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"state": getQueryParam(t, url, "state"),
+				"code":  "abc",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp = login("openid groups")
+	if !resp.IsError() || !strings.Contains(resp.Error().Error(), "Required scopes were not granted: admin.") {
+		t.Fatalf("expected missing scope error, got: %v", resp)
+	}
+
+	if resp := login("openid admin groups"); resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+
+	// A response without a scope grants the requested scopes.
+	if resp := login(""); resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+}
+
+func TestOIDC_Callback_MaxAge(t *testing.T) {
+	tests := map[string]struct {
+		authTime  interface{}
+		errPrefix string
+	}{
+		"recent auth_time":  {authTime: time.Now().Unix()},
+		"old auth_time":     {authTime: time.Now().Add(-10 * time.Minute).Unix(), errPrefix: errAuthTimeTooOld},
+		"missing auth_time": {errPrefix: errTokenVerification},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			b, storage, s := getBackendAndServer(t, false)
+			defer s.server.Close()
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "role/test",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"max_age": 300,
+				},
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("err:%v resp:%#v", err, resp)
+			}
+
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "oidc/auth_url",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"role":         "test",
+					"redirect_uri": "https://example.com",
+				},
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("err:%v resp:%#v", err, resp)
+			}
+
+			authURL := resp.Data["auth_url"].(string)
+			if maxAge := getQueryParam(t, authURL, "max_age"); maxAge != "300" {
+				t.Fatalf("expected max_age=300 in auth URL, got: %q", maxAge)
+			}
+
+			s.customClaims = sampleClaims(getQueryParam(t, authURL, "nonce"))
+			if tt.authTime != nil {
+				s.customClaims["auth_time"] = tt.authTime
+			}
+			s.code = "abc"
+
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      "oidc/callback",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"state": getQueryParam(t, authURL, "state"),
+					"code":  "abc",
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.errPrefix == "" {
+				if resp.IsError() {
+					t.Fatalf("unexpected error: %v", resp.Error())
+				}
+				return
+			}
+			if !resp.IsError() || !strings.HasPrefix(resp.Error().Error(), tt.errPrefix) {
+				t.Fatalf("expected error starting with %q, got: %v", tt.errPrefix, resp)
+			}
+		})
+	}
+}
+
+func TestOIDC_Callback_TokenCIDRsClaim(t *testing.T) {
+	for name, cidrs := range map[string]interface{}{
+		"valid CIDRs": []interface{}{"10.0.0.0/16", "192.168.1.0/24"},
+		"invalid":     "10.0.0.0/33",
+	} {
+		t.Run(name, func(t *testing.T) {
+			b, storage, s := getBackendAndServer(t, false)
+			defer s.server.Close()
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "role/test",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"token_cidrs_claim": "cidrs",
+				},
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("err:%v resp:%#v", err, resp)
+			}
+
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "oidc/auth_url",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"role":         "test",
+					"redirect_uri": "https://example.com",
+				},
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("err:%v resp:%#v", err, resp)
+			}
+			authURL := resp.Data["auth_url"].(string)
+
+			s.customClaims = sampleClaims(getQueryParam(t, authURL, "nonce"))
+			s.customClaims["cidrs"] = cidrs
+			s.code = "abc"
+
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      "oidc/callback",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"state": getQueryParam(t, authURL, "state"),
+					"code":  "abc",
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if name == "invalid" {
+				if !resp.IsError() || !strings.Contains(resp.Error().Error(), "invalid CIDR") {
+					t.Fatalf("expected CIDR error, got: %v", resp)
+				}
+				return
+			}
+			if resp.IsError() {
+				t.Fatalf("unexpected error: %v", resp.Error())
+			}
+			var got []string
+			for _, cidr := range resp.Auth.BoundCIDRs {
+				got = append(got, cidr.String())
+			}
+			if expected := []string{"10.0.0.0/16", "192.168.1.0/24"}; !reflect.DeepEqual(got, expected) {
+				t.Fatalf("expected bound CIDRs %v, got %v", expected, got)
+			}
+		})
+	}
+}
+
+func TestOIDC_Callback_BoundACRValues(t *testing.T) {
+	tests := map[string]struct {
+		satisfaction string
+		acr          interface{}
+		success      bool
+	}{
+		"any, single value":        {satisfaction: "any", acr: "urn:mfa", success: true},
+		"any, no match":            {satisfaction: "any", acr: "urn:other"},
+		"all, all values":          {satisfaction: "all", acr: "urn:pwd urn:mfa", success: true},
+		"all, some values":         {satisfaction: "all", acr: "urn:mfa"},
+		"missing acr":              {satisfaction: "any"},
+		"default satisfaction any": {acr: "urn:pwd", success: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			b, storage, s := getBackendAndServer(t, false)
+			defer s.server.Close()
+
+			data := map[string]interface{}{
+				"bound_acr_values": "urn:mfa,urn:pwd",
+			}
+			if tt.satisfaction != "" {
+				data["acr_values_satisfication"] = tt.satisfaction
+			}
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "role/test",
+				Storage:   storage,
+				Data:      data,
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("err:%v resp:%#v", err, resp)
+			}
+
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "oidc/auth_url",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"role":         "test",
+					"redirect_uri": "https://example.com",
+				},
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("err:%v resp:%#v", err, resp)
+			}
+
+			authURL := resp.Data["auth_url"].(string)
+			// acr_values is the first parameter, which getQueryParam can't
+			// read.
+			u, err := url.Parse(authURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if acrValues := u.Query().Get("acr_values"); acrValues != "urn:mfa urn:pwd" {
+				t.Fatalf("expected acr_values=%q in auth URL, got: %q", "urn:mfa urn:pwd", acrValues)
+			}
+
+			s.customClaims = sampleClaims(getQueryParam(t, authURL, "nonce"))
+			if tt.acr != nil {
+				s.customClaims["acr"] = tt.acr
+			}
+			s.code = "abc"
+
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      "oidc/callback",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"state": getQueryParam(t, authURL, "state"),
+					"code":  "abc",
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.success && resp.IsError() {
+				t.Fatalf("unexpected error: %v", resp.Error())
+			}
+			if !tt.success && (!resp.IsError() || !strings.Contains(resp.Error().Error(), "acr")) {
+				t.Fatalf("expected acr error, got: %v", resp)
+			}
+		})
+	}
+}
+
+func TestOIDC_BoundACRValues_InvalidSatisfaction(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"bound_acr_values":         "urn:mfa",
+			"acr_values_satisfication": "most",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() {
+		t.Fatal("expected error for invalid acr_values_satisfication")
+	}
+}
+
+func TestOIDC_Callback_NonceTTL(t *testing.T) {
+	for _, stateBackend := range []string{"", stateBackendStorage} {
+		t.Run("state backend "+stateBackend, func(t *testing.T) {
+			b, storage, s := getBackendAndServerWithConfig(t, false, map[string]interface{}{
+				"nonce_ttl":                      1,
+				"oidc_distributed_state_backend": stateBackend,
+			})
+			defer s.server.Close()
+
+			authURL := func() string {
+				resp, err := b.HandleRequest(context.Background(), &logical.Request{
+					Operation: logical.UpdateOperation,
+					Path:      "oidc/auth_url",
+					Storage:   storage,
+					Data: map[string]interface{}{
+						"role":         "test",
+						"redirect_uri": "https://example.com",
+					},
+				})
+				if err != nil || resp.IsError() {
+					t.Fatalf("err:%v resp:%#v", err, resp)
+				}
+				return resp.Data["auth_url"].(string)
+			}
+			callback := func(authURL string) *logical.Response {
+				s.customClaims = sampleClaims(getQueryParam(t, authURL, "nonce"))
+				s.code = "abc"
+				resp, err := b.HandleRequest(context.Background(), &logical.Request{
+					Operation: logical.ReadOperation,
+					Path:      "oidc/callback",
+					Storage:   storage,
+					Data: map[string]interface{}{
+						"state": getQueryParam(t, authURL, "state"),
+						"code":  "abc",
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return resp
+			}
+
+			url := authURL()
+			if resp := callback(url); resp.IsError() {
+				t.Fatalf("expected successful login, got: %v", resp.Error())
+			}
+
+			// The nonce is consumed with the state by the first callback.
+			if resp := callback(url); !resp.IsError() || !strings.Contains(resp.Error().Error(), "Expired or missing OAuth state") {
+				t.Fatalf("expected the replayed callback to fail, got: %#v", resp)
+			}
+
+			url = authURL()
+			time.Sleep(1100 * time.Millisecond)
+			if resp := callback(url); !resp.IsError() || !strings.Contains(resp.Error().Error(), "Expired or missing OAuth state") {
+				t.Fatalf("expected the callback with an expired nonce to fail, got: %#v", resp)
+			}
+		})
+	}
+}
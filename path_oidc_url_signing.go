@@ -0,0 +1,158 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// authURLSigningKeyPath is the storage path of the key used to sign
+	// authorization URLs when oidc_sign_auth_url is set.
+	authURLSigningKeyPath = "oidc_auth_url_key"
+
+	// authURLSignatureParam is the query parameter holding the signature.
+	authURLSignatureParam = "_sig"
+)
+
+type authURLSigningKey struct {
+	Key []byte `json:"key"`
+}
+
+func pathOIDCVerifyAuthURL(b *jwtAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: `oidc/verify_auth_url`,
+		Fields: map[string]*framework.FieldSchema{
+			"auth_url": {
+				Type:        framework.TypeString,
+				Description: "The signed authorization URL returned by auth_url.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathVerifyAuthURL,
+				Summary:  "Verify the signature of an authorization URL.",
+			},
+		},
+
+		HelpSynopsis:    verifyAuthURLHelpSyn,
+		HelpDescription: verifyAuthURLHelpDesc,
+	}
+}
+
+func (b *jwtAuthBackend) pathVerifyAuthURL(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	authURL := d.Get("auth_url").(string)
+	if authURL == "" {
+		return logical.ErrorResponse("missing auth_url"), nil
+	}
+
+	entry, err := req.Storage.Get(ctx, authURLSigningKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	valid := false
+	if entry != nil {
+		var key authURLSigningKey
+		if err := entry.DecodeJSON(&key); err != nil {
+			return nil, err
+		}
+		valid = verifyAuthURL(key.Key, authURL)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"valid":            valid,
+			"signing_required": config != nil && config.OIDCSignAuthURL,
+		},
+	}, nil
+}
+
+// authURLSigningKey returns the key used to sign authorization URLs, creating
+// it on first use.
+func (b *jwtAuthBackend) authURLSigningKey(ctx context.Context, s logical.Storage) ([]byte, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	entry, err := s.Get(ctx, authURLSigningKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var key authURLSigningKey
+	if entry != nil {
+		if err := entry.DecodeJSON(&key); err != nil {
+			return nil, err
+		}
+		return key.Key, nil
+	}
+
+	key.Key, err = uuid.GenerateRandomBytes(32)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err = logical.StorageEntryJSON(authURLSigningKeyPath, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return key.Key, nil
+}
+
+// signAuthURL appends an HMAC of the complete authURL as the last query
+// parameter.
+func signAuthURL(key []byte, authURL string) string {
+	sep := "?"
+	if strings.Contains(authURL, "?") {
+		sep = "&"
+	}
+
+	return authURL + sep + authURLSignatureParam + "=" + authURLSignature(key, authURL)
+}
+
+// verifyAuthURL checks that signedURL was produced by signAuthURL with key and
+// has not been modified since.
+func verifyAuthURL(key []byte, signedURL string) bool {
+	i := strings.LastIndex(signedURL, authURLSignatureParam+"=")
+	if i < 1 || (signedURL[i-1] != '?' && signedURL[i-1] != '&') {
+		return false
+	}
+
+	expected := authURLSignature(key, signedURL[:i-1])
+	return hmac.Equal([]byte(signedURL[i+len(authURLSignatureParam)+1:]), []byte(expected))
+}
+
+func authURLSignature(key []byte, authURL string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(authURL))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+const (
+	verifyAuthURLHelpSyn = `
+Verifies that an authorization URL was issued by this auth method.
+`
+	verifyAuthURLHelpDesc = `
+If oidc_sign_auth_url is set, authorization URLs returned by auth_url carry an
+HMAC of the complete URL in the "_sig" parameter. This endpoint reports whether
+the signature is valid, allowing clients to detect URLs that were modified or
+did not originate from this Vault instance. It also reports in
+"signing_required" whether oidc_sign_auth_url is set, so that clients reject
+URLs whose signature was removed.
+`
+)
@@ -0,0 +1,181 @@
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/coreos/go-oidc"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// validateConfigCheck is the result of one of the checks made by
+// oidc/validate-config.
+type validateConfigCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+func pathOIDCValidateConfig(b *jwtAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: `oidc/validate-config`,
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "The role to check. Defaults to the configured default_role.",
+			},
+			"redirect_uri": {
+				Type:        framework.TypeString,
+				Description: "An optional redirect URI to check against the role's allowed_redirect_uris.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathValidateConfig,
+				Summary:  "Check the OIDC configuration of a role without starting a login.",
+			},
+		},
+
+		HelpSynopsis:    validateConfigHelpSyn,
+		HelpDescription: validateConfigHelpDesc,
+	}
+}
+
+func (b *jwtAuthBackend) pathValidateConfig(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("could not load configuration"), nil
+	}
+
+	var checks []validateConfigCheck
+	resolved := make(map[string]interface{})
+	check := func(name string, err error, detail string) bool {
+		c := validateConfigCheck{Name: name, OK: err == nil, Detail: detail}
+		if err != nil {
+			c.Detail = err.Error()
+		}
+		checks = append(checks, c)
+		return err == nil
+	}
+	response := func() *logical.Response {
+		valid := true
+		for _, c := range checks {
+			valid = valid && c.OK
+		}
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"valid":    valid,
+				"checks":   checks,
+				"resolved": resolved,
+			},
+		}
+	}
+
+	if config.authType() != OIDCFlow {
+		check("config", fmt.Errorf("OIDC login is not configured for this mount"), "")
+		return response(), nil
+	}
+	check("config", nil, "OIDC login is configured")
+
+	roleName := d.Get("role").(string)
+	if roleName == "" {
+		roleName = config.DefaultRole
+	}
+	if roleName == "" {
+		return logical.ErrorResponse("missing role"), nil
+	}
+
+	roleName, err = b.canonicalRoleName(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	resolved["role"] = roleName
+
+	role, err := b.role(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case role == nil:
+		check("role", fmt.Errorf("role %q could not be found", roleName), "")
+		return response(), nil
+	case role.RoleType != "oidc":
+		check("role", fmt.Errorf("role %q has role_type %q, not \"oidc\"", roleName, role.RoleType), "")
+		return response(), nil
+	}
+	check("role", nil, fmt.Sprintf("role %q found", roleName))
+	resolved["allowed_redirect_uris"] = role.AllowedRedirectURIs
+
+	if redirectURI := d.Get("redirect_uri").(string); redirectURI != "" {
+		resolved["redirect_uri"] = redirectURI
+		if role.redirectAllowed(redirectURI) {
+			check("redirect_uri", nil, "redirect_uri matches allowed_redirect_uris")
+		} else {
+			check("redirect_uri", fmt.Errorf("redirect_uri %q is not in allowed_redirect_uris", redirectURI), "")
+		}
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, providerHealthCheckTimeout)
+	defer cancel()
+
+	oidcCtx, err := b.createCAContext(checkCtx, config, config.OIDCDiscoveryCAPEM)
+	if err != nil {
+		check("discovery", err, "")
+		return response(), nil
+	}
+
+	// A new provider is created, rather than using the cached one, so that
+	// the discovery document is actually fetched.
+	provider, err := oidc.NewProvider(oidcCtx, config.OIDCDiscoveryURL)
+	if err != nil {
+		check("discovery", err, "")
+		return response(), nil
+	}
+
+	var metadata struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := provider.Claims(&metadata); err != nil {
+		check("discovery", err, "")
+		return response(), nil
+	}
+	check("discovery", nil, fmt.Sprintf("discovery document fetched from %q", config.OIDCDiscoveryURL))
+	resolved["issuer"] = metadata.Issuer
+	resolved["authorization_endpoint"] = metadata.AuthorizationEndpoint
+	resolved["token_endpoint"] = metadata.TokenEndpoint
+	resolved["jwks_uri"] = metadata.JWKSURI
+
+	if metadata.JWKSURI == "" {
+		check("jwks", errors.New("discovery document has no jwks_uri"), "")
+		return response(), nil
+	}
+	keySet, err := fetchJWKS(oidcCtx, metadata.JWKSURI)
+	var keyCount int
+	if err == nil {
+		keyCount = len(keySet.Keys)
+	}
+	check("jwks", err, fmt.Sprintf("%d keys fetched from %q", keyCount, metadata.JWKSURI))
+
+	return response(), nil
+}
+
+const (
+	validateConfigHelpSyn = `
+Checks the OIDC configuration of a role.
+`
+	validateConfigHelpDesc = `
+Performs the checks made when requesting an authorization URL for a role:
+the role is looked up, the discovery document and the key set are fetched,
+and redirect_uri, if given, is matched against the role's
+allowed_redirect_uris. No login is started. The result of each check is
+returned along with the discovered endpoints. Requires sudo.
+`
+)
@@ -0,0 +1,114 @@
+package jwtauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestOIDC_ValidateConfig(t *testing.T) {
+	validate := func(t *testing.T, b logical.Backend, storage logical.Storage, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "oidc/validate-config",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	checkResults := func(resp *logical.Response) map[string]validateConfigCheck {
+		results := make(map[string]validateConfigCheck)
+		for _, c := range resp.Data["checks"].([]validateConfigCheck) {
+			results[c.Name] = c
+		}
+		return results
+	}
+
+	t.Run("passing config", func(t *testing.T) {
+		b, storage, s := getBackendAndServer(t, false)
+		defer s.server.Close()
+
+		resp := validate(t, b, storage, map[string]interface{}{
+			"role":         "test",
+			"redirect_uri": "https://example.com",
+		})
+
+		if !resp.Data["valid"].(bool) {
+			t.Fatalf("expected valid config, got %#v", resp.Data["checks"])
+		}
+		results := checkResults(resp)
+		for _, name := range []string{"config", "role", "redirect_uri", "discovery", "jwks"} {
+			if c, ok := results[name]; !ok || !c.OK {
+				t.Fatalf("expected check %q to pass, got %#v", name, c)
+			}
+		}
+
+		resolved := resp.Data["resolved"].(map[string]interface{})
+		if resolved["jwks_uri"] != s.server.URL+"/certs" {
+			t.Fatalf("unexpected jwks_uri: %v", resolved["jwks_uri"])
+		}
+		if resolved["authorization_endpoint"] != s.server.URL+"/auth" {
+			t.Fatalf("unexpected authorization_endpoint: %v", resolved["authorization_endpoint"])
+		}
+	})
+
+	t.Run("failing discovery", func(t *testing.T) {
+		b, storage, s := getBackendAndServer(t, false)
+		s.server.Close()
+
+		resp := validate(t, b, storage, map[string]interface{}{
+			"role": "test",
+		})
+
+		if resp.Data["valid"].(bool) {
+			t.Fatal("expected invalid config")
+		}
+		results := checkResults(resp)
+		if !results["role"].OK {
+			t.Fatalf("expected role check to pass, got %#v", results["role"])
+		}
+		if c := results["discovery"]; c.OK || c.Detail == "" {
+			t.Fatalf("expected discovery check to fail, got %#v", c)
+		}
+		if _, ok := results["jwks"]; ok {
+			t.Fatal("expected no jwks check after failed discovery")
+		}
+	})
+
+	t.Run("redirect uri not allowed", func(t *testing.T) {
+		b, storage, s := getBackendAndServer(t, false)
+		defer s.server.Close()
+
+		resp := validate(t, b, storage, map[string]interface{}{
+			"role":         "test",
+			"redirect_uri": "https://evil.example.com",
+		})
+
+		if resp.Data["valid"].(bool) {
+			t.Fatal("expected invalid config")
+		}
+		results := checkResults(resp)
+		if c := results["redirect_uri"]; c.OK || !strings.Contains(c.Detail, "not in allowed_redirect_uris") {
+			t.Fatalf("expected redirect_uri check to fail, got %#v", c)
+		}
+		if !results["discovery"].OK || !results["jwks"].OK {
+			t.Fatalf("expected provider checks to pass, got %#v", resp.Data["checks"])
+		}
+	})
+
+	t.Run("sudo required", func(t *testing.T) {
+		b, _ := getBackend(t)
+		root := b.SpecialPaths().Root
+		if !strutil.StrListContains(root, "oidc/validate-config") {
+			t.Fatalf("expected oidc/validate-config to require sudo, got %v", root)
+		}
+	})
+}
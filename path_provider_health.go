@@ -0,0 +1,195 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// providerHealthCheckTimeout bounds a single discovery request made by the
+// provider health monitor.
+const providerHealthCheckTimeout = 30 * time.Second
+
+// defaultCertExpiryWarnDays is used when oidc_cert_expiry_warn_days is not
+// set.
+const defaultCertExpiryWarnDays = 30
+
+// providerHealth is the result of the most recent provider health check.
+type providerHealth struct {
+	status      string
+	err         string
+	lastChecked time.Time
+
+	// certExpiry is when the TLS certificate of the discovery URL expires,
+	// or zero if it couldn't be read.
+	certExpiry time.Time
+}
+
+func pathProviderHealth(b *jwtAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: `oidc/provider-health`,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathProviderHealthRead,
+				Summary:  "Read the result of the last OIDC provider health check.",
+			},
+		},
+
+		HelpSynopsis:    providerHealthHelpSyn,
+		HelpDescription: providerHealthHelpDesc,
+	}
+}
+
+func (b *jwtAuthBackend) pathProviderHealthRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.healthLock.RLock()
+	health := b.providerHealth
+	b.healthLock.RUnlock()
+
+	if health == nil {
+		return logical.ErrorResponse("no provider health check has been performed"), nil
+	}
+
+	var certExpiry string
+	if !health.certExpiry.IsZero() {
+		certExpiry = health.certExpiry.Format(time.RFC3339)
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"status":             health.status,
+			"error":              health.err,
+			"last_checked":       health.lastChecked.Format(time.RFC3339),
+			"certificate_expiry": certExpiry,
+		},
+	}
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil && config.adaptiveTimeout != nil {
+		resp.Data["adaptive_timeout"] = config.adaptiveTimeout.timeout(config.httpTimeout()).Seconds()
+		resp.Data["provider_response_p99"] = config.adaptiveTimeout.p99(time.Now()).Seconds()
+	}
+
+	return resp, nil
+}
+
+// checkProviderHealth fetches the discovery document if a health check
+// interval is configured and the previous check is older than the interval.
+// It is run from the backend's periodic function.
+func (b *jwtAuthBackend) checkProviderHealth(ctx context.Context, s logical.Storage) error {
+	config, err := b.config(ctx, s)
+	if err != nil {
+		return err
+	}
+	if config == nil || config.OIDCDiscoveryURL == "" || config.OIDCProviderHealthCheckInterval <= 0 {
+		return nil
+	}
+
+	b.healthLock.RLock()
+	last := b.providerHealth
+	b.healthLock.RUnlock()
+
+	now := time.Now()
+	if last != nil && now.Sub(last.lastChecked) < config.OIDCProviderHealthCheckInterval {
+		return nil
+	}
+
+	health := &providerHealth{
+		status:      "ok",
+		lastChecked: now,
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, providerHealthCheckTimeout)
+	defer cancel()
+
+	oidcCtx, err := b.createCAContext(checkCtx, config, config.OIDCDiscoveryCAPEM)
+	if err == nil {
+		_, err = oidc.NewProvider(oidcCtx, config.OIDCDiscoveryURL)
+	}
+	if err != nil {
+		health.status = "error"
+		health.err = err.Error()
+		b.Logger().Warn("OIDC provider health check failed", "url", config.OIDCDiscoveryURL, "error", err)
+	}
+
+	if expiry, err := providerCertExpiry(checkCtx, config.OIDCDiscoveryURL, config.OIDCDiscoveryCAPEM); err != nil {
+		b.Logger().Debug("error reading OIDC provider certificate", "url", config.OIDCDiscoveryURL, "error", err)
+	} else if !expiry.IsZero() {
+		health.certExpiry = expiry
+		warnDays := config.certExpiryWarnDays()
+		if expiry.Before(now.AddDate(0, 0, warnDays)) {
+			b.Logger().Warn("OIDC provider TLS certificate expires soon", "url", config.OIDCDiscoveryURL, "expiry", expiry.Format(time.RFC3339), "warn_days", warnDays)
+		}
+	}
+
+	b.healthLock.Lock()
+	b.providerHealth = health
+	b.healthLock.Unlock()
+
+	return nil
+}
+
+// providerCertExpiry connects to the host of discoveryURL and returns when its
+// TLS certificate expires. A zero time is returned for non-HTTPS URLs.
+func providerCertExpiry(ctx context.Context, discoveryURL, caPEM string) (time.Time, error) {
+	u, err := url.Parse(discoveryURL)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if u.Scheme != "https" {
+		return time.Time{}, nil
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "443")
+	}
+
+	tlsConfig := &tls.Config{ServerName: u.Hostname()}
+	if caPEM != "" {
+		certPool := x509.NewCertPool()
+		if ok := certPool.AppendCertsFromPEM([]byte(caPEM)); !ok {
+			return time.Time{}, errors.New("could not parse CA PEM value successfully")
+		}
+		tlsConfig.RootCAs = certPool
+	}
+
+	conn, err := (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer conn.Close()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return time.Time{}, errors.New("no certificate presented")
+	}
+
+	return certs[0].NotAfter, nil
+}
+
+const (
+	providerHealthHelpSyn = `
+Reports the health of the configured OIDC provider.
+`
+	providerHealthHelpDesc = `
+If oidc_provider_health_check_interval is set, the discovery document of the
+configured OIDC provider is fetched periodically. This endpoint returns the
+status of the most recent check, any error encountered and when it ran, as
+well as the expiry of the provider's TLS certificate. The result is also
+exported as the jwtauth_provider_health gauge by oidc/metrics. A warning is logged when
+the certificate expires within oidc_cert_expiry_warn_days. With
+oidc_adaptive_timeout, the current provider timeout and the P99 of the
+provider response times are reported in seconds.
+`
+)
@@ -0,0 +1,109 @@
+package jwtauth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestProviderHealth(t *testing.T) {
+	b, storage := getBackend(t)
+
+	s := newOIDCProvider(t)
+	defer s.server.Close()
+
+	cert, err := s.getTLSCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readHealth := func() *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "oidc/provider-health",
+			Storage:   storage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// interval without a discovery URL is rejected
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"jwt_validation_pubkeys":              testJWTPubKey,
+			"oidc_provider_health_check_interval": "5m",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error, got: %v", resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"oidc_discovery_url":                  s.server.URL,
+			"oidc_discovery_ca_pem":               cert,
+			"oidc_provider_health_check_interval": "5m",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	if resp := readHealth(); !resp.IsError() {
+		t.Fatalf("expected error before first check, got: %v", resp)
+	}
+
+	jb := b.(*jwtAuthBackend)
+	if err := jb.periodicFunc(context.Background(), &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp = readHealth()
+	if resp.IsError() || resp.Data["status"] != "ok" || resp.Data["error"] != "" {
+		t.Fatalf("unexpected health response: %v", resp.Data)
+	}
+	certExpiry, err := time.Parse(time.RFC3339, resp.Data["certificate_expiry"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !certExpiry.Equal(s.server.Certificate().NotAfter.Truncate(time.Second)) {
+		t.Fatalf("expected certificate expiry %v, got %v", s.server.Certificate().NotAfter, certExpiry)
+	}
+	lastChecked := resp.Data["last_checked"]
+
+	// a second run within the interval doesn't repeat the check
+	s.server.Close()
+	if err := jb.periodicFunc(context.Background(), &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := readHealth(); resp.Data["status"] != "ok" || resp.Data["last_checked"] != lastChecked {
+		t.Fatalf("unexpected health response: %v", resp.Data)
+	}
+
+	// once the interval has elapsed the failure is reported
+	jb.healthLock.Lock()
+	jb.providerHealth.lastChecked = jb.providerHealth.lastChecked.Add(-10 * time.Minute)
+	jb.healthLock.Unlock()
+
+	if err := jb.periodicFunc(context.Background(), &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	resp = readHealth()
+	if resp.Data["status"] != "error" || resp.Data["error"] == "" {
+		t.Fatalf("unexpected health response: %v", resp.Data)
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"gopkg.in/square/go-jose.v2/jwt"
 
+	log "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/go-sockaddr"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/strutil"
@@ -16,12 +19,16 @@ import (
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
-var reservedMetadata = []string{"role"}
+var reservedMetadata = []string{"role", "inline_data", requestFingerprintMetadata}
 
 const claimDefaultLeeway = 150
 
 const boundClaimsTypeString = "string"
 const boundClaimsTypeGlob = "glob"
+const boundClaimsTypeRegexp = "regexp"
+
+const oidcFlowCode = "code"
+const oidcFlowImplicit = "implicit"
 
 func pathRoleList(b *jwtAuthBackend) *framework.Path {
 	return &framework.Path{
@@ -49,7 +56,7 @@ func pathRole(b *jwtAuthBackend) *framework.Path {
 			},
 			"role_type": {
 				Type:        framework.TypeString,
-				Description: "Type of the role, either 'jwt' or 'oidc'.",
+				Description: "Type of the role, either 'jwt', 'oidc' or 'introspection'.",
 			},
 
 			"policies": &framework.FieldSchema{
@@ -100,6 +107,14 @@ Defaults to 150 (2.5 minutes) if set to 0 and can be disabled if set to -1.`,
 Defaults to 60 (1 minute) if set to 0 and can be disabled if set to -1.`,
 				Default: jwt.DefaultLeeway,
 			},
+			"verify_iat": {
+				Type:        framework.TypeBool,
+				Description: `If set, reject tokens whose 'iat' claim is in the future (accounting for clock_skew_leeway).`,
+			},
+			"reject_past_iat_threshold": {
+				Type:        framework.TypeDurationSecond,
+				Description: `If set, reject tokens whose 'iat' claim is older than this duration, even if 'exp' is still valid.`,
+			},
 			"bound_subject": {
 				Type:        framework.TypeString,
 				Description: `The 'sub' claim that is valid for login. Optional.`,
@@ -108,35 +123,351 @@ Defaults to 60 (1 minute) if set to 0 and can be disabled if set to -1.`,
 				Type:        framework.TypeCommaStringSlice,
 				Description: `Comma-separated list of 'aud' claims that are valid for login; any match is sufficient`,
 			},
+			"bound_audiences_type": {
+				Type:        framework.TypeString,
+				Description: `How to interpret values in bound_audiences: allowed values are 'string' or 'regexp'. Regular expressions are not anchored.`,
+				Default:     boundClaimsTypeString,
+			},
+			"oidc_audience_strict": {
+				Type:        framework.TypeBool,
+				Description: `If set, every value of the 'aud' claim must be one of the bound audiences. Tokens with additional audiences are rejected.`,
+			},
 			"bound_claims_type": {
 				Type:        framework.TypeString,
-				Description: `How to interpret values in the map of claims/values (which must match for login): allowed values are 'string' or 'glob'`,
+				Description: `How to interpret values in the map of claims/values (which must match for login): allowed values are 'string', 'glob' or 'regexp'. Regular expressions are not anchored.`,
 				Default:     boundClaimsTypeString,
 			},
 			"bound_claims": {
 				Type:        framework.TypeMap,
 				Description: `Map of claims/values which must match for login`,
 			},
+			"bound_repository": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Comma-separated list of GitHub repositories, e.g. "octo-org/octo-repo", that the 'repository' claim of GitHub Actions tokens must exactly match.`,
+			},
+			"bound_environment": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Comma-separated list of GitHub deployment environments that the 'environment' claim of GitHub Actions tokens must exactly match.`,
+			},
+			"claim_policies_map": {
+				Type:        framework.TypeMap,
+				Description: `Map of claims to maps of claim values to policies, e.g. {"groups": {"engineering": ["dev-policy"]}}. The policies of every matching value are added to the token.`,
+			},
+			"claim_policies_map_merge_mode": {
+				Type:        framework.TypeString,
+				Description: `How the policies from claim_policies_map are combined with token_policies: 'append' (default) adds them and 'replace' uses them instead. token_policies are used if no claim value is mapped.`,
+			},
+			"bound_claims_operators": {
+				Type:        framework.TypeKVPairs,
+				Description: `Map of numeric claims to operator expressions which must be satisfied for login: ">=3", "<1700000000" or "between:1,10". Bounds are inclusive for >=, <= and between. A claim can't be in both bound_claims and bound_claims_operators.`,
+			},
+			"claims_schema": {
+				Type:        framework.TypeString,
+				Description: `JSON Schema that the token's claims must conform to before bound_claims are checked. Drafts 4, 6 and 7 are supported. A $ref can only point into the schema itself, e.g. "#/definitions/group".`,
+			},
+			"cel_policy": {
+				Type:        framework.TypeString,
+				Description: `CEL (Common Expression Language) expression that must evaluate to true for login, e.g. 'claims.email.endsWith("@example.com") && "admins" in claims.groups'. The token's claims are available in the "claims" map. Logins are denied if it evaluates to false or fails, e.g. because a claim is missing.`,
+			},
 			"claim_mappings": {
 				Type:        framework.TypeKVPairs,
 				Description: `Mappings of claims (key) that will be copied to a metadata field (value)`,
 			},
+			"fetch_groups_from_userinfo": {
+				Type:        framework.TypeBool,
+				Description: `If set, the claims of the userinfo endpoint are fetched with the access token of OIDC logins and added to the ID token claims before "bound_claims", "groups_claim" and the other claim settings are applied. Unlike the best-effort userinfo merge of other roles, the login fails if the userinfo can't be fetched, and ID token claims are kept unless "userinfo_claims_override" is set. Only valid for the "oidc" role_type.`,
+			},
+			"userinfo_url": {
+				Type:        framework.TypeString,
+				Description: `The userinfo endpoint used with "fetch_groups_from_userinfo", instead of the one of the provider's discovery document.`,
+			},
+			"userinfo_claims_override": {
+				Type:        framework.TypeBool,
+				Description: `If set with "fetch_groups_from_userinfo", userinfo claims replace ID token claims of the same name.`,
+			},
+			"claim_mappings_transform": {
+				Type:        framework.TypeKVPairs,
+				Description: `Transforms (value) applied to the metadata fields (key) set by claim_mappings: "lowercase", "uppercase", "trim" or "regexp:s/<pattern>/<replacement>/", where the replacement may reference groups as $1. Transforms can be chained with "|", e.g. "trim|lowercase".`,
+			},
+			"claim_namespace_strip": {
+				Type:        framework.TypeString,
+				Description: `A prefix, such as "https://example.com/", stripped from the names of namespaced custom claims before the role's claim settings like "claim_mappings" and "bound_claims" are applied. "https://example.com/groups" is then also available as "groups". Claims that already have the stripped name are not replaced.`,
+			},
+			"conditional_claim_mappings": {
+				Type:        framework.TypeSlice,
+				Description: `List of claim mappings applied only if "condition_claim" has the value "condition_value". Each entry copies "source_claim" to the "target_metadata" field.`,
+			},
+			"encrypted_claim_mappings": {
+				Type:        framework.TypeKVPairs,
+				Description: `Mappings of claims (key) that will be encrypted and copied to a token metadata field (value). Values can be decrypted with the oidc/decrypt-claim endpoint.`,
+			},
+			"oidc_token_version_claim": {
+				Type:        framework.TypeString,
+				Description: `The claim holding the token schema version, used to select the claim mappings in "versioned_claim_mappings".`,
+			},
+			"versioned_claim_mappings": {
+				Type:        framework.TypeMap,
+				Description: `Map of token schema versions to claim mappings, used instead of "claim_mappings" for tokens of that version.`,
+			},
 			"user_claim": {
 				Type:        framework.TypeString,
-				Description: `The claim to use for the Identity entity alias name`,
+				Description: `The claim to use for the Identity entity alias name. Nested claims are given as a dot-separated path, e.g. "realm_access.user.id". Must be "sub" with the google provider.`,
+			},
+			"alias_name_source": {
+				Type:        framework.TypeString,
+				Description: `How the Identity entity alias name is built: 'user_claim' (default) uses the top-level claim named by user_claim or, if there is none, the claim at user_claim as a dot-separated path, 'full_claim_path' always interprets user_claim as a dot-separated path into nested claims and 'claim_template' renders alias_name_template.`,
+			},
+			"alias_name_template": {
+				Type:        framework.TypeString,
+				Description: `Go text/template over the token claims used as the entity alias name if alias_name_source is 'claim_template', e.g. "{{.email}}-{{.tenant_id}}".`,
 			},
 			"groups_claim": {
 				Type:        framework.TypeString,
-				Description: `The claim to use for the Identity group alias names`,
+				Description: `The claim to use for the Identity group alias names. Nested claims are given as a dot-separated path, e.g. "resource_access.vault.roles", or as a JSON pointer.`,
+			},
+			"groups_claim_sub_key": {
+				Type:        framework.TypeString,
+				Description: `If set, groups claim values that are objects are replaced by the value of this key, e.g. "name" for [{"id":"abc","name":"engineering"}]. Objects without the key are skipped.`,
+			},
+			"oidc_ignore_missing_groups": {
+				Type:        framework.TypeBool,
+				Description: `If set, login will proceed without group aliases when the groups claim is absent from the token.`,
 			},
 			"oidc_scopes": {
 				Type:        framework.TypeCommaStringSlice,
 				Description: `Comma-separated list of OIDC scopes`,
 			},
+			"required_scopes": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Comma-separated list of scopes that are requested and must be granted in the token response for the OIDC login to succeed.`,
+			},
+			"oidc_allowed_countries": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Comma-separated list of ISO 3166-1 alpha-2 country codes, e.g. "US,CA". If set, OIDC logins are only allowed from client addresses that the config's oidc_geoip_database locates in one of these countries.`,
+			},
+			"oidc_denied_countries": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Comma-separated list of ISO 3166-1 alpha-2 country codes from which OIDC logins are denied. Takes precedence over oidc_allowed_countries. Requires the config's oidc_geoip_database.`,
+			},
+			"require_email_verified": {
+				Type:        framework.TypeBool,
+				Description: `If set, login requires the "email_verified" claim to be present and true.`,
+			},
+			"oidc_allow_offline_access": {
+				Type:        framework.TypeBool,
+				Description: `If set, the "offline_access" scope is requested when the provider lists it in "scopes_supported".`,
+			},
+			"oidc_flow": {
+				Type:        framework.TypeString,
+				Description: `The OIDC flow used by the role: 'code' or 'implicit'. The implicit flow is deprecated and insecure, and should only be used with providers that do not support the authorization code flow.`,
+				Default:     oidcFlowCode,
+			},
+			"pkce_required": {
+				Type:        framework.TypeBool,
+				Description: `If set, OIDC logins with the authorization code flow must use PKCE. Defaults to true for new roles.`,
+			},
+			"device_flow_allowed": {
+				Type:        framework.TypeBool,
+				Description: `If set, users can log in with the OAuth 2.0 device authorization grant through device/auth_url and device/token, e.g. from environments without a browser. Requires 'role_type' "oidc" and a provider that supports the device flow.`,
+			},
+			"max_age": {
+				Type:        framework.TypeDurationSecond,
+				Description: `If set, OIDC logins request the max_age authorization parameter and require an auth_time claim no older than this duration.`,
+			},
+			"jwt_required_claims": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Comma-separated list of claims that must be present in the token with a value other than null or an empty string. Nested claims are given as JSON pointers, e.g. "/org/id".`,
+			},
+			"bound_acr_values": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Comma-separated list of authentication context class references, e.g. "urn:mfa", that the 'acr' claim must match. OIDC logins request them with the acr_values authorization parameter.`,
+			},
+			"acr_values_satisfication": {
+				Type:        framework.TypeString,
+				Description: `How the 'acr' claim is matched against bound_acr_values: "any" (default) requires one of the values and "all" requires all of them, as space-separated values of the claim.`,
+			},
+			"oidc_use_access_token_claims": {
+				Type:        framework.TypeBool,
+				Description: `If set, claims of a JWT access token are merged into the ID token claims during OIDC login. ID token claims take precedence.`,
+			},
+			"jwks_cache_duration": {
+				Type:        framework.TypeDurationSecond,
+				Description: `How long the key sets of the jwks_urls, or of the config's jwks_url, are cached before they are refreshed in the background. The cached key set keeps being used during the refresh. Defaults to 15 minutes.`,
+			},
+			"jwks_cache_max_staleness": {
+				Type:        framework.TypeDurationSecond,
+				Description: `How long a cached key set is used while it can't be refreshed. Logins fail once it is older than this, until a refresh succeeds. Defaults to 1 hour.`,
+			},
+			"algorithm_restrictions": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Ordered, comma-separated list of the signing algorithms accepted for the role, e.g. "ES256,RS256", checked after the jwt_supported_algs of the config. Algorithms are tried in order, and skipped if no key the token is verified with has their key type: RSA for RS* and PS*, EC for ES* and OKP for EdDSA. "none" can be listed to record that unsigned tokens are rejected; they are rejected whether or not it is listed.`,
+			},
+			"jwks_urls": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Comma-separated list of JWKS URLs to verify token signatures with, in order of preference. Takes precedence over the jwks_url of the config, and is not used with OIDC discovery.`,
+			},
+			"jwks_url_timeout": {
+				Type:        framework.TypeDurationSecond,
+				Description: `Timeout of fetching each of the jwks_urls. Defaults to 5 seconds.`,
+			},
+			"jwks_per_kid_url_template": {
+				Type:        framework.TypeString,
+				Description: `URL template with a "{kid}" placeholder for fetching the single key that signed a token. Only used with "jwks_url", which is used instead if the fetch fails.`,
+			},
+			"oidc_track_token_ips": {
+				Type:        framework.TypeBool,
+				Description: `If set, the source address of the first login with each JWT is recorded, and logins with the same JWT from other addresses are logged.`,
+			},
+			"oidc_strict_ip_binding": {
+				Type:        framework.TypeBool,
+				Description: `If set together with "oidc_track_token_ips", logins with a JWT from an address other than the one it was first used from are rejected.`,
+			},
+			"oidc_webhook_url": {
+				Type:        framework.TypeString,
+				Description: `If set, a JSON description of each successful login is POSTed to this URL. Failures don't affect the login.`,
+			},
+			"oidc_webhook_secret": {
+				Type:        framework.TypeString,
+				Description: `Secret used to sign the webhook payload with HMAC-SHA256 in the "X-Hub-Signature-256" header. This value is not returned on read.`,
+			},
+			"oidc_policy_engine_url": {
+				Type:        framework.TypeString,
+				Description: `If set, the claims, role name and client address of each login are POSTed to this URL, which must return a JSON object with "policies" and optionally "metadata". The returned policies replace the policies of the role and of "claim_policies_map", and the string metadata values are added to the token metadata. Existing metadata keys are kept.`,
+			},
+			"policy_engine_fail_open": {
+				Type:        framework.TypeBool,
+				Description: `If set, logins get the policies of the role when the policy engine fails. Otherwise the logins fail.`,
+			},
+			"metadata_webhook_url": {
+				Type:        framework.TypeString,
+				Description: `If set, the token metadata of each login is POSTed to this URL, and the string values of the JSON object it returns are added to the token metadata. Existing metadata keys are kept.`,
+			},
+			"metadata_webhook_timeout": {
+				Type:        framework.TypeDurationSecond,
+				Description: `Timeout of the metadata webhook request. Defaults to 3 seconds.`,
+			},
+			"metadata_webhook_required": {
+				Type:        framework.TypeBool,
+				Description: `If set, logins fail when the metadata webhook fails. Otherwise a warning is logged and the login proceeds without the webhook's metadata.`,
+			},
+			"role_aliases": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Comma-separated list of alternative names of the role, e.g. its names before being renamed. Logins using an alias are handled as logins to this role.`,
+			},
+			"jwt_encryption_key": {
+				Type:        framework.TypeString,
+				Description: `PEM-encoded RSA or EC private key used to decrypt JWE tokens. If not set, the key generated with config/encryption-key/rotate is used. This value is not returned on read.`,
+			},
+			"oidc_request_fingerprint_claim": {
+				Type:        framework.TypeString,
+				Description: `The claim holding a request fingerprint, e.g. from an API gateway, that is copied to the "request_fingerprint" token metadata. Logins with tokens missing the claim are rejected.`,
+			},
+			"token_cidrs_claim": {
+				Type:        framework.TypeString,
+				Description: `The claim holding a CIDR, or a list of CIDRs, that are added to 'token_bound_cidrs' of the issued token. Logins with tokens missing the claim or holding invalid CIDRs are rejected.`,
+			},
+			"oidc_revocation_check_url": {
+				Type:        framework.TypeString,
+				Description: `If set, login fails if a GET of this URL with the "jti" and "sub" claims as query parameters returns {"revoked": true}.`,
+			},
+			"oidc_revocation_check_timeout": {
+				Type:        framework.TypeDurationSecond,
+				Description: `Timeout of the revocation check request. Defaults to 2 seconds.`,
+			},
+			"oidc_revocation_check_fail_open": {
+				Type:        framework.TypeBool,
+				Description: `If set, login proceeds when the revocation check cannot be completed.`,
+			},
+			"oidc_revocation_cache_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: `Duration for which a token that is not revoked is not checked again. Defaults to 0, which disables caching.`,
+			},
+			"oidc_cognito_mode": {
+				Type:        framework.TypeBool,
+				Description: `If set, logins are validated as tokens of the Amazon Cognito user pool set in 'oidc_cognito_region' and 'oidc_cognito_user_pool_id', with the keys and issuer of the pool. Requires 'role_type' "jwt". 'groups_claim' defaults to "cognito:groups".`,
+			},
+			"oidc_cognito_region": {
+				Type:        framework.TypeString,
+				Description: `The AWS region of the Cognito user pool, e.g. "us-east-1".`,
+			},
+			"oidc_cognito_user_pool_id": {
+				Type:        framework.TypeString,
+				Description: `The ID of the Cognito user pool, e.g. "us-east-1_AbCdEfGhI".`,
+			},
+			"oidc_cognito_token_use": {
+				Type:        framework.TypeString,
+				Description: `The token_use claim that Cognito tokens must have, "id" or "access". The audience of id tokens is their 'aud' claim, and of access tokens their 'client_id' claim. Defaults to "id".`,
+			},
+			"oidc_cache_key_fields": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Comma-separated list of claims whose values identify a token in the negative and revocation caches, instead of the complete token. Allows caching tokens that differ in other claims, such as a random "jti".`,
+			},
+			"oidc_negative_cache_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: `Duration for which a rejected token is rejected again with the same error without being validated. Defaults to 0, which disables caching.`,
+			},
+			"accept_access_tokens": {
+				Type:        framework.TypeBool,
+				Description: `If set, the 'jwt' of logins is an OIDC access token, validated by fetching the claims of its user from the provider's userinfo endpoint instead of verifying a JWT. Requires 'role_type' "jwt" and 'oidc_discovery_url' to be configured. Signed JWTs are then not accepted for the role. The provider accepts access tokens issued to any of its clients, and the userinfo response doesn't include an audience, so a token obtained by another application can be used to log in. 'bound_subject' or 'bound_claims' must therefore be set, and 'bound_audiences' can't be used.`,
+			},
+			"introspection_endpoint": {
+				Type:        framework.TypeString,
+				Description: `The RFC 7662 token introspection endpoint that opaque tokens are validated with if 'role_type' is 'introspection'.`,
+			},
+			"introspection_client_id": {
+				Type:        framework.TypeString,
+				Description: `The client ID used to authenticate to the introspection endpoint.`,
+			},
+			"introspection_client_secret": {
+				Type:        framework.TypeString,
+				Description: `The client secret used to authenticate to the introspection endpoint. This value is not returned on read.`,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"introspection_cache_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: `Duration for which introspection responses are cached. Defaults to 30 seconds.`,
+			},
+			"introspection_audience": {
+				Type:        framework.TypeString,
+				Description: `The audience that tokens are introspected on behalf of, for introspection endpoints partitioned by resource server. It is sent in the parameter named by 'introspection_audience_param'.`,
+			},
+			"introspection_audience_param": {
+				Type:        framework.TypeString,
+				Description: `The introspection request parameter 'introspection_audience' is sent in: "resource" (RFC 8707) or "audience". Defaults to "resource".`,
+			},
+			"oidc_client_auth_method": {
+				Type:        framework.TypeString,
+				Description: `How the client authenticates to the token endpoint when exchanging the authorization code: "client_secret_basic", "client_secret_post" or "private_key_jwt". If unset, "client_secret_basic" is tried first and "client_secret_post" if the provider rejects it.`,
+			},
+			"oidc_client_private_key": {
+				Type:        framework.TypeString,
+				Description: `The PEM-encoded RSA or EC private key that signs the client assertion if 'oidc_client_auth_method' is "private_key_jwt". Setting a new key keeps the previous versions. This value is not returned on read.`,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"oidc_client_key_id": {
+				Type:        framework.TypeString,
+				Description: `The key ID set in the "kid" header of the client assertion.`,
+			},
+			"token_exchange_target_audience": {
+				Type:        framework.TypeString,
+				Description: `If set, subject tokens submitted to token-exchange are exchanged with the OIDC provider for a token scoped to this audience, which the Vault token is issued for.`,
+			},
+			"max_validation_key_versions": {
+				Type:        framework.TypeInt,
+				Description: `The number of previous versions of the validation key set with rotate-validation-key that are kept. Defaults to 2.`,
+			},
 			"allowed_redirect_uris": {
 				Type:        framework.TypeCommaStringSlice,
 				Description: `Comma-separated list of allowed values for redirect_uri`,
 			},
+			"redirect_uri_match_type": {
+				Type:        framework.TypeString,
+				Description: `How redirect_uri is compared with 'allowed_redirect_uris': "exact" or "glob". With "glob", entries containing '*' are patterns, e.g. "https://*.example.com/callback", where '*' matches within one label of the host. The scheme, port, path, query and fragment of a pattern are compared exactly, so redirect URIs with a query the pattern lacks are rejected. Defaults to "exact".`,
+			},
 			"verbose_oidc_logging": {
 				Type: framework.TypeBool,
 				Description: `Log received OIDC tokens and claims when debug-level logging is active. 
@@ -190,17 +521,105 @@ type jwtRole struct {
 	// Duration of leeway for all claims to account for clock skew
 	ClockSkewLeeway time.Duration `json:"clock_skew_leeway"`
 
+	// Issued at claim validation
+	VerifyIssuedAt         bool          `json:"verify_iat"`
+	RejectPastIATThreshold time.Duration `json:"reject_past_iat_threshold"`
+
 	// Role binding properties
-	BoundAudiences      []string               `json:"bound_audiences"`
-	BoundSubject        string                 `json:"bound_subject"`
-	BoundClaimsType     string                 `json:"bound_claims_type"`
-	BoundClaims         map[string]interface{} `json:"bound_claims"`
-	ClaimMappings       map[string]string      `json:"claim_mappings"`
-	UserClaim           string                 `json:"user_claim"`
-	GroupsClaim         string                 `json:"groups_claim"`
-	OIDCScopes          []string               `json:"oidc_scopes"`
-	AllowedRedirectURIs []string               `json:"allowed_redirect_uris"`
-	VerboseOIDCLogging  bool                   `json:"verbose_oidc_logging"`
+	BoundAudiences            []string                       `json:"bound_audiences"`
+	AudienceStrict            bool                           `json:"oidc_audience_strict"`
+	BoundSubject              string                         `json:"bound_subject"`
+	BoundClaimsType           string                         `json:"bound_claims_type"`
+	BoundAudiencesType        string                         `json:"bound_audiences_type"`
+	BoundClaims               map[string]interface{}         `json:"bound_claims"`
+	BoundRepositories         []string                       `json:"bound_repository"`
+	BoundEnvironments         []string                       `json:"bound_environment"`
+	BoundClaimsOperators      map[string]string              `json:"bound_claims_operators"`
+	ClaimPoliciesMap          map[string]map[string][]string `json:"claim_policies_map"`
+	ClaimPoliciesMergeMode    string                         `json:"claim_policies_map_merge_mode"`
+	ClaimsSchema              string                         `json:"claims_schema"`
+	CELPolicy                 string                         `json:"cel_policy"`
+	ClaimMappings             map[string]string              `json:"claim_mappings"`
+	FetchGroupsFromUserinfo   bool                           `json:"fetch_groups_from_userinfo"`
+	UserinfoURL               string                         `json:"userinfo_url"`
+	UserinfoClaimsOverride    bool                           `json:"userinfo_claims_override"`
+	ClaimMappingsTransform    map[string]string              `json:"claim_mappings_transform"`
+	ClaimNamespaceStrip       string                         `json:"claim_namespace_strip"`
+	ConditionalClaimMappings  []conditionalClaimMapping      `json:"conditional_claim_mappings"`
+	EncryptedClaimMappings    map[string]string              `json:"encrypted_claim_mappings"`
+	TokenVersionClaim         string                         `json:"oidc_token_version_claim"`
+	VersionedClaimMappings    map[string]map[string]string   `json:"versioned_claim_mappings"`
+	UserClaim                 string                         `json:"user_claim"`
+	AliasNameSource           string                         `json:"alias_name_source"`
+	AliasNameTemplate         string                         `json:"alias_name_template"`
+	GroupsClaim               string                         `json:"groups_claim"`
+	GroupsClaimSubKey         string                         `json:"groups_claim_sub_key"`
+	IgnoreMissingGroups       bool                           `json:"oidc_ignore_missing_groups"`
+	OIDCScopes                []string                       `json:"oidc_scopes"`
+	RequiredScopes            []string                       `json:"required_scopes"`
+	AllowedCountries          []string                       `json:"oidc_allowed_countries"`
+	DeniedCountries           []string                       `json:"oidc_denied_countries"`
+	AllowOfflineAccess        bool                           `json:"oidc_allow_offline_access"`
+	RequireEmailVerified      bool                           `json:"require_email_verified"`
+	OIDCFlow                  string                         `json:"oidc_flow"`
+	PKCERequired              bool                           `json:"pkce_required"`
+	DeviceFlowAllowed         bool                           `json:"device_flow_allowed"`
+	MaxAge                    time.Duration                  `json:"max_age"`
+	BoundACRValues            []string                       `json:"bound_acr_values"`
+	RequiredClaims            []string                       `json:"jwt_required_claims"`
+	ACRValuesSatisfaction     string                         `json:"acr_values_satisfication"`
+	UseAccessTokenClaims      bool                           `json:"oidc_use_access_token_claims"`
+	JWKSCacheDuration         time.Duration                  `json:"jwks_cache_duration"`
+	JWKSCacheMaxStaleness     time.Duration                  `json:"jwks_cache_max_staleness"`
+	JWKSURLs                  []string                       `json:"jwks_urls"`
+	AlgorithmRestrictions     []string                       `json:"algorithm_restrictions"`
+	JWKSURLTimeout            time.Duration                  `json:"jwks_url_timeout"`
+	JWKSPerKidURLTemplate     string                         `json:"jwks_per_kid_url_template"`
+	TrackTokenIPs             bool                           `json:"oidc_track_token_ips"`
+	StrictIPBinding           bool                           `json:"oidc_strict_ip_binding"`
+	WebhookURL                string                         `json:"oidc_webhook_url"`
+	WebhookSecret             string                         `json:"oidc_webhook_secret"`
+	PolicyEngineURL           string                         `json:"oidc_policy_engine_url"`
+	PolicyEngineFailOpen      bool                           `json:"policy_engine_fail_open"`
+	MetadataWebhookURL        string                         `json:"metadata_webhook_url"`
+	MetadataWebhookTimeout    time.Duration                  `json:"metadata_webhook_timeout"`
+	MetadataWebhookRequired   bool                           `json:"metadata_webhook_required"`
+	RequestFingerprintClaim   string                         `json:"oidc_request_fingerprint_claim"`
+	TokenCIDRsClaim           string                         `json:"token_cidrs_claim"`
+	EncryptionKey             string                         `json:"jwt_encryption_key"`
+	RoleAliases               []string                       `json:"role_aliases"`
+	RevocationCheckURL        string                         `json:"oidc_revocation_check_url"`
+	RevocationCheckTimeout    time.Duration                  `json:"oidc_revocation_check_timeout"`
+	RevocationCheckFailOpen   bool                           `json:"oidc_revocation_check_fail_open"`
+	RevocationCacheTTL        time.Duration                  `json:"oidc_revocation_cache_ttl"`
+	CognitoMode               bool                           `json:"oidc_cognito_mode"`
+	CognitoRegion             string                         `json:"oidc_cognito_region"`
+	CognitoUserPoolID         string                         `json:"oidc_cognito_user_pool_id"`
+	CognitoTokenUse           string                         `json:"oidc_cognito_token_use"`
+	NegativeCacheTTL          time.Duration                  `json:"oidc_negative_cache_ttl"`
+	CacheKeyFields            []string                       `json:"oidc_cache_key_fields"`
+	AcceptAccessTokens        bool                           `json:"accept_access_tokens"`
+	IntrospectionEndpoint     string                         `json:"introspection_endpoint"`
+	IntrospectionClientID     string                         `json:"introspection_client_id"`
+	IntrospectionClientSecret string                         `json:"introspection_client_secret"`
+	IntrospectionCacheTTL     time.Duration                  `json:"introspection_cache_ttl"`
+	IntrospectionAudience     string                         `json:"introspection_audience"`
+	IntrospectionAudParam     string                         `json:"introspection_audience_param"`
+
+	OIDCClientAuthMethod string `json:"oidc_client_auth_method"`
+
+	TokenExchangeTargetAudience string `json:"token_exchange_target_audience"`
+
+	ValidationKeys           []validationKeyVersion `json:"validation_keys"`
+	MaxValidationKeyVersions int                    `json:"max_validation_key_versions"`
+
+	// Ephemeral roles are generated from oidc_auto_role_template and are
+	// deleted after EphemeralExpiry.
+	Ephemeral            bool      `json:"ephemeral,omitempty"`
+	EphemeralExpiry      time.Time `json:"ephemeral_expiry"`
+	AllowedRedirectURIs  []string  `json:"allowed_redirect_uris"`
+	RedirectURIMatchType string    `json:"redirect_uri_match_type"`
+	VerboseOIDCLogging   bool      `json:"verbose_oidc_logging"`
 
 	// Deprecated by TokenParams
 	Policies   []string                      `json:"policies"`
@@ -211,6 +630,135 @@ type jwtRole struct {
 	BoundCIDRs []*sockaddr.SockAddrMarshaler `json:"bound_cidrs"`
 }
 
+// conditionalClaimMapping copies SourceClaim to the TargetMetadata field only
+// if ConditionClaim has the value ConditionValue.
+type conditionalClaimMapping struct {
+	ConditionClaim string `json:"condition_claim"`
+	ConditionValue string `json:"condition_value"`
+	SourceClaim    string `json:"source_claim"`
+	TargetMetadata string `json:"target_metadata"`
+}
+
+// parseConditionalClaimMappings parses and validates the
+// conditional_claim_mappings role field.
+func parseConditionalClaimMappings(raw []interface{}) ([]conditionalClaimMapping, error) {
+	mappings := make([]conditionalClaimMapping, 0, len(raw))
+	for i, entry := range raw {
+		m, ok := entry.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("conditional_claim_mappings entry %d is not an object", i)
+		}
+
+		var mapping conditionalClaimMapping
+		for _, f := range []struct {
+			name string
+			dst  *string
+		}{
+			{"condition_claim", &mapping.ConditionClaim},
+			{"condition_value", &mapping.ConditionValue},
+			{"source_claim", &mapping.SourceClaim},
+			{"target_metadata", &mapping.TargetMetadata},
+		} {
+			v, ok := m[f.name].(string)
+			if !ok || v == "" {
+				return nil, fmt.Errorf("conditional_claim_mappings entry %d: %q must be a non-empty string", i, f.name)
+			}
+			*f.dst = v
+		}
+
+		if strutil.StrListContains(reservedMetadata, mapping.TargetMetadata) {
+			return nil, fmt.Errorf("metadata key %q is reserved and may not be a mapping destination", mapping.TargetMetadata)
+		}
+
+		mappings = append(mappings, mapping)
+	}
+
+	return mappings, nil
+}
+
+// checkClaimMappings checks mappings for duplicates and collision with
+// reserved names.
+func checkClaimMappings(claimMappings map[string]string) error {
+	targets := make(map[string]bool)
+	for _, metadataKey := range claimMappings {
+		if strutil.StrListContains(reservedMetadata, metadataKey) {
+			return fmt.Errorf("metadata key %q is reserved and may not be a mapping destination", metadataKey)
+		}
+
+		if targets[metadataKey] {
+			return fmt.Errorf("multiple keys are mapped to metadata key %q", metadataKey)
+		}
+		targets[metadataKey] = true
+	}
+
+	return nil
+}
+
+// parseVersionedClaimMappings parses and validates the
+// versioned_claim_mappings role field, a map of token versions to claim
+// mappings.
+func parseVersionedClaimMappings(raw map[string]interface{}) (map[string]map[string]string, error) {
+	versions := make(map[string]map[string]string, len(raw))
+	for version, rawMappings := range raw {
+		m, ok := rawMappings.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("versioned_claim_mappings for version %q is not an object", version)
+		}
+
+		claimMappings := make(map[string]string, len(m))
+		for claim, rawKey := range m {
+			metadataKey, ok := rawKey.(string)
+			if !ok {
+				return nil, fmt.Errorf("versioned_claim_mappings for version %q: metadata key for claim %q must be a string", version, claim)
+			}
+			claimMappings[claim] = metadataKey
+		}
+
+		if err := checkClaimMappings(claimMappings); err != nil {
+			return nil, fmt.Errorf("versioned_claim_mappings for version %q: %s", version, err)
+		}
+		versions[version] = claimMappings
+	}
+
+	return versions, nil
+}
+
+// claimMappingsFor returns the claim mappings for the token version found in
+// the role's oidc_token_version_claim, falling back to claim_mappings if the
+// claim is missing or its version has no mappings.
+func (r *jwtRole) claimMappingsFor(logger log.Logger, allClaims map[string]interface{}) map[string]string {
+	if r.TokenVersionClaim == "" || len(r.VersionedClaimMappings) == 0 {
+		return r.ClaimMappings
+	}
+
+	var version string
+	switch v := getClaim(logger, allClaims, r.TokenVersionClaim).(type) {
+	case string:
+		version = v
+	case float64:
+		version = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	if mappings, ok := r.VersionedClaimMappings[version]; ok {
+		return mappings
+	}
+
+	return r.ClaimMappings
+}
+
+func (r *jwtRole) conditionalClaimMappingsData() []map[string]string {
+	data := make([]map[string]string, 0, len(r.ConditionalClaimMappings))
+	for _, m := range r.ConditionalClaimMappings {
+		data = append(data, map[string]string{
+			"condition_claim": m.ConditionClaim,
+			"condition_value": m.ConditionValue,
+			"source_claim":    m.SourceClaim,
+			"target_metadata": m.TargetMetadata,
+		})
+	}
+	return data
+}
+
 // role takes a storage backend and the name and returns the role's storage
 // entry
 func (b *jwtAuthBackend) role(ctx context.Context, s logical.Storage, name string) (*jwtRole, error) {
@@ -236,6 +784,30 @@ func (b *jwtAuthBackend) role(ctx context.Context, s logical.Storage, name strin
 		role.BoundClaimsType = boundClaimsTypeString
 	}
 
+	if role.BoundAudiencesType == "" {
+		role.BoundAudiencesType = boundClaimsTypeString
+	}
+
+	if role.AliasNameSource == "" {
+		role.AliasNameSource = aliasNameSourceUserClaim
+	}
+
+	if role.ClaimPoliciesMergeMode == "" {
+		role.ClaimPoliciesMergeMode = claimPoliciesMergeModeAppend
+	}
+
+	if role.OIDCFlow == "" {
+		role.OIDCFlow = oidcFlowCode
+	}
+
+	if role.RedirectURIMatchType == "" {
+		role.RedirectURIMatchType = redirectURIMatchExact
+	}
+
+	if role.ACRValuesSatisfaction == "" {
+		role.ACRValuesSatisfaction = acrValuesSatisfactionAny
+	}
+
 	if role.TokenTTL == 0 && role.TTL > 0 {
 		role.TokenTTL = role.TTL
 	}
@@ -258,6 +830,27 @@ func (b *jwtAuthBackend) role(ctx context.Context, s logical.Storage, name strin
 	return role, nil
 }
 
+// clockSkewLeeway returns the effective leeway to use when validating time
+// based claims, applying the default when unset.
+func (r *jwtRole) clockSkewLeeway() time.Duration {
+	switch {
+	case r.ClockSkewLeeway.Seconds() < 0:
+		return 0
+	case r.ClockSkewLeeway.Seconds() == 0:
+		return jwt.DefaultLeeway
+	}
+	return r.ClockSkewLeeway
+}
+
+// revocationCheckTimeout returns the timeout for revocation check requests,
+// applying the default when unset.
+func (r *jwtRole) revocationCheckTimeout() time.Duration {
+	if r.RevocationCheckTimeout <= 0 {
+		return defaultRevocationCheckTimeout
+	}
+	return r.RevocationCheckTimeout
+}
+
 // pathRoleExistenceCheck returns whether the role with the given name exists or not.
 func (b *jwtAuthBackend) pathRoleExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
 	role, err := b.role(ctx, req.Storage, data.Get("name").(string))
@@ -273,7 +866,54 @@ func (b *jwtAuthBackend) pathRoleList(ctx context.Context, req *logical.Request,
 	if err != nil {
 		return nil, err
 	}
-	return logical.ListResponse(roles), nil
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := make(map[string]interface{})
+	for _, roleName := range roles {
+		role, err := b.role(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			continue
+		}
+
+		// OIDC roles authenticate with the client of the mount config.
+		clientID := ""
+		if role.RoleType == "oidc" && config != nil {
+			clientID = redactClientID(config.OIDCClientID)
+		}
+
+		info := map[string]interface{}{
+			"role_type":       role.RoleType,
+			"oidc_client_id":  clientID,
+			"bound_audiences": role.BoundAudiences,
+			"token_policies":  role.TokenPolicies,
+			"user_claim":      role.UserClaim,
+		}
+		if len(role.RoleAliases) > 0 {
+			info["role_aliases"] = role.RoleAliases
+		}
+		keyInfo[roleName] = info
+	}
+
+	return logical.ListResponseWithInfo(roles, keyInfo), nil
+}
+
+// redactClientID keeps only the first characters of a client ID, for role
+// summaries.
+func redactClientID(clientID string) string {
+	if clientID == "" {
+		return ""
+	}
+	if len(clientID) <= 4 {
+		return "***"
+	}
+	return clientID[:4] + "***"
 }
 
 // pathRoleRead grabs a read lock and reads the options set on the role from the storage
@@ -293,20 +933,105 @@ func (b *jwtAuthBackend) pathRoleRead(ctx context.Context, req *logical.Request,
 
 	// Create a map of data to be returned
 	d := map[string]interface{}{
-		"role_type":             role.RoleType,
-		"expiration_leeway":     int64(role.ExpirationLeeway.Seconds()),
-		"not_before_leeway":     int64(role.NotBeforeLeeway.Seconds()),
-		"clock_skew_leeway":     int64(role.ClockSkewLeeway.Seconds()),
-		"bound_audiences":       role.BoundAudiences,
-		"bound_subject":         role.BoundSubject,
-		"bound_claims_type":     role.BoundClaimsType,
-		"bound_claims":          role.BoundClaims,
-		"claim_mappings":        role.ClaimMappings,
-		"user_claim":            role.UserClaim,
-		"groups_claim":          role.GroupsClaim,
-		"allowed_redirect_uris": role.AllowedRedirectURIs,
-		"oidc_scopes":           role.OIDCScopes,
-		"verbose_oidc_logging":  role.VerboseOIDCLogging,
+		"role_type":                       role.RoleType,
+		"expiration_leeway":               int64(role.ExpirationLeeway.Seconds()),
+		"not_before_leeway":               int64(role.NotBeforeLeeway.Seconds()),
+		"clock_skew_leeway":               int64(role.ClockSkewLeeway.Seconds()),
+		"verify_iat":                      role.VerifyIssuedAt,
+		"reject_past_iat_threshold":       int64(role.RejectPastIATThreshold.Seconds()),
+		"bound_audiences":                 role.BoundAudiences,
+		"oidc_audience_strict":            role.AudienceStrict,
+		"bound_subject":                   role.BoundSubject,
+		"bound_claims_type":               role.BoundClaimsType,
+		"bound_audiences_type":            role.BoundAudiencesType,
+		"bound_claims":                    role.BoundClaims,
+		"bound_repository":                role.BoundRepositories,
+		"bound_environment":               role.BoundEnvironments,
+		"bound_claims_operators":          role.BoundClaimsOperators,
+		"claim_policies_map":              role.ClaimPoliciesMap,
+		"claim_policies_map_merge_mode":   role.ClaimPoliciesMergeMode,
+		"claims_schema":                   role.ClaimsSchema,
+		"cel_policy":                      role.CELPolicy,
+		"claim_mappings":                  role.ClaimMappings,
+		"fetch_groups_from_userinfo":      role.FetchGroupsFromUserinfo,
+		"userinfo_url":                    role.UserinfoURL,
+		"userinfo_claims_override":        role.UserinfoClaimsOverride,
+		"claim_mappings_transform":        role.ClaimMappingsTransform,
+		"claim_namespace_strip":           role.ClaimNamespaceStrip,
+		"conditional_claim_mappings":      role.conditionalClaimMappingsData(),
+		"encrypted_claim_mappings":        role.EncryptedClaimMappings,
+		"oidc_token_version_claim":        role.TokenVersionClaim,
+		"versioned_claim_mappings":        role.VersionedClaimMappings,
+		"user_claim":                      role.UserClaim,
+		"alias_name_source":               role.AliasNameSource,
+		"alias_name_template":             role.AliasNameTemplate,
+		"groups_claim":                    role.GroupsClaim,
+		"groups_claim_sub_key":            role.GroupsClaimSubKey,
+		"oidc_ignore_missing_groups":      role.IgnoreMissingGroups,
+		"allowed_redirect_uris":           role.AllowedRedirectURIs,
+		"redirect_uri_match_type":         role.RedirectURIMatchType,
+		"oidc_scopes":                     role.OIDCScopes,
+		"required_scopes":                 role.RequiredScopes,
+		"oidc_allowed_countries":          role.AllowedCountries,
+		"oidc_denied_countries":           role.DeniedCountries,
+		"oidc_allow_offline_access":       role.AllowOfflineAccess,
+		"require_email_verified":          role.RequireEmailVerified,
+		"oidc_flow":                       role.OIDCFlow,
+		"pkce_required":                   role.PKCERequired,
+		"device_flow_allowed":             role.DeviceFlowAllowed,
+		"max_age":                         int64(role.MaxAge.Seconds()),
+		"bound_acr_values":                role.BoundACRValues,
+		"jwt_required_claims":             role.RequiredClaims,
+		"acr_values_satisfication":        role.ACRValuesSatisfaction,
+		"oidc_use_access_token_claims":    role.UseAccessTokenClaims,
+		"jwks_cache_duration":             int64(role.JWKSCacheDuration.Seconds()),
+		"jwks_cache_max_staleness":        int64(role.JWKSCacheMaxStaleness.Seconds()),
+		"jwks_urls":                       role.JWKSURLs,
+		"algorithm_restrictions":          role.AlgorithmRestrictions,
+		"jwks_url_timeout":                int64(role.JWKSURLTimeout.Seconds()),
+		"jwks_per_kid_url_template":       role.JWKSPerKidURLTemplate,
+		"oidc_track_token_ips":            role.TrackTokenIPs,
+		"oidc_strict_ip_binding":          role.StrictIPBinding,
+		"oidc_webhook_url":                role.WebhookURL,
+		"oidc_policy_engine_url":          role.PolicyEngineURL,
+		"policy_engine_fail_open":         role.PolicyEngineFailOpen,
+		"metadata_webhook_url":            role.MetadataWebhookURL,
+		"metadata_webhook_timeout":        int64(role.MetadataWebhookTimeout.Seconds()),
+		"metadata_webhook_required":       role.MetadataWebhookRequired,
+		"oidc_request_fingerprint_claim":  role.RequestFingerprintClaim,
+		"token_cidrs_claim":               role.TokenCIDRsClaim,
+		"role_aliases":                    role.RoleAliases,
+		"oidc_revocation_check_url":       role.RevocationCheckURL,
+		"oidc_revocation_check_timeout":   int64(role.RevocationCheckTimeout.Seconds()),
+		"oidc_revocation_check_fail_open": role.RevocationCheckFailOpen,
+		"oidc_revocation_cache_ttl":       int64(role.RevocationCacheTTL.Seconds()),
+		"oidc_cognito_mode":               role.CognitoMode,
+		"oidc_cognito_region":             role.CognitoRegion,
+		"oidc_cognito_user_pool_id":       role.CognitoUserPoolID,
+		"oidc_cognito_token_use":          role.CognitoTokenUse,
+		"oidc_negative_cache_ttl":         int64(role.NegativeCacheTTL.Seconds()),
+		"oidc_cache_key_fields":           role.CacheKeyFields,
+		"accept_access_tokens":            role.AcceptAccessTokens,
+		"introspection_endpoint":          role.IntrospectionEndpoint,
+		"introspection_client_id":         role.IntrospectionClientID,
+		"introspection_cache_ttl":         int64(role.IntrospectionCacheTTL.Seconds()),
+		"introspection_audience":          role.IntrospectionAudience,
+		"introspection_audience_param":    role.IntrospectionAudParam,
+		"max_validation_key_versions":     role.MaxValidationKeyVersions,
+		"oidc_client_auth_method":         role.OIDCClientAuthMethod,
+		"token_exchange_target_audience":  role.TokenExchangeTargetAudience,
+		"oidc_client_key_id":              "",
+		"oidc_client_key_version":         0,
+		"verbose_oidc_logging":            role.VerboseOIDCLogging,
+	}
+
+	keys, err := readClientKeys(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if current := keys.current(); current != nil {
+		d["oidc_client_key_id"] = current.KeyID
+		d["oidc_client_key_version"] = current.Version
 	}
 
 	role.PopulateTokenData(d)
@@ -342,14 +1067,53 @@ func (b *jwtAuthBackend) pathRoleDelete(ctx context.Context, req *logical.Reques
 		return logical.ErrorResponse("role name required"), nil
 	}
 
-	// Delete the role itself
-	if err := req.Storage.Delete(ctx, rolePrefix+roleName); err != nil {
+	b.roleLock.Lock()
+	defer b.roleLock.Unlock()
+
+	role, err := b.role(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.deleteRole(ctx, req.Storage, roleName, role); err != nil {
 		return nil, err
 	}
 
 	return nil, nil
 }
 
+// deleteRole deletes a role and the state kept for it. The caller must hold
+// roleLock.
+func (b *jwtAuthBackend) deleteRole(ctx context.Context, s logical.Storage, roleName string, role *jwtRole) error {
+	if role != nil {
+		if err := b.updateRoleAliases(ctx, s, roleName, role.RoleAliases, nil); err != nil {
+			return err
+		}
+	}
+
+	// Delete the role itself
+	if err := s.Delete(ctx, rolePrefix+roleName); err != nil {
+		return err
+	}
+
+	if err := s.Delete(ctx, claimKeyPrefix+roleName); err != nil {
+		return err
+	}
+
+	if err := s.Delete(ctx, clientKeyPrefix+roleName); err != nil {
+		return err
+	}
+
+	b.validationMetrics.deleteRole(roleName)
+	b.tokenStats.deleteRole(roleName)
+	b.authMetrics.deleteRole(roleName)
+	b.providerMetrics.deleteRole(roleName)
+	b.flushNegativeCache(roleName)
+	b.flushJWKSCache(roleName)
+
+	return nil
+}
+
 // pathRoleCreateUpdate registers a new role with the backend or updates the options
 // of an existing role
 func (b *jwtAuthBackend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -358,6 +1122,9 @@ func (b *jwtAuthBackend) pathRoleCreateUpdate(ctx context.Context, req *logical.
 		return logical.ErrorResponse("missing role name"), nil
 	}
 
+	b.roleLock.Lock()
+	defer b.roleLock.Unlock()
+
 	// Check if the role already exists
 	role, err := b.role(ctx, req.Storage, roleName)
 	if err != nil {
@@ -372,11 +1139,15 @@ func (b *jwtAuthBackend) pathRoleCreateUpdate(ctx context.Context, req *logical.
 		role = new(jwtRole)
 	}
 
+	// Writing an ephemeral role makes it permanent.
+	role.Ephemeral = false
+	role.EphemeralExpiry = time.Time{}
+
 	roleType := data.Get("role_type").(string)
 	if roleType == "" {
 		roleType = "oidc"
 	}
-	if roleType != "jwt" && roleType != "oidc" {
+	if roleType != "jwt" && roleType != "oidc" && roleType != "introspection" {
 		return logical.ErrorResponse("invalid 'role_type': %s", roleType), nil
 	}
 	role.RoleType = roleType
@@ -428,21 +1199,49 @@ func (b *jwtAuthBackend) pathRoleCreateUpdate(ctx context.Context, req *logical.
 		role.ClockSkewLeeway = time.Duration(tokenClockSkewLeeway.(int)) * time.Second
 	}
 
-	if boundAudiences, ok := data.GetOk("bound_audiences"); ok {
-		role.BoundAudiences = boundAudiences.([]string)
+	if verifyIATRaw, ok := data.GetOk("verify_iat"); ok {
+		role.VerifyIssuedAt = verifyIATRaw.(bool)
 	}
 
-	if boundSubject, ok := data.GetOk("bound_subject"); ok {
-		role.BoundSubject = boundSubject.(string)
+	if rejectPastIATRaw, ok := data.GetOk("reject_past_iat_threshold"); ok {
+		role.RejectPastIATThreshold = time.Duration(rejectPastIATRaw.(int)) * time.Second
 	}
 
-	if verboseOIDCLoggingRaw, ok := data.GetOk("verbose_oidc_logging"); ok {
-		role.VerboseOIDCLogging = verboseOIDCLoggingRaw.(bool)
+	if boundAudiences, ok := data.GetOk("bound_audiences"); ok {
+		role.BoundAudiences = boundAudiences.([]string)
 	}
 
-	boundClaimsType := data.Get("bound_claims_type").(string)
+	boundAudiencesType := data.Get("bound_audiences_type").(string)
+	switch boundAudiencesType {
+	case boundClaimsTypeString, boundClaimsTypeRegexp:
+		role.BoundAudiencesType = boundAudiencesType
+	default:
+		return logical.ErrorResponse("invalid 'bound_audiences_type': %s", boundAudiencesType), nil
+	}
+
+	if boundAudiencesType == boundClaimsTypeRegexp {
+		for _, pattern := range role.BoundAudiences {
+			if _, err := compileBoundPattern(pattern); err != nil {
+				return logical.ErrorResponse("invalid regular expression in bound_audiences: %s", err), nil
+			}
+		}
+	}
+
+	if audienceStrict, ok := data.GetOk("oidc_audience_strict"); ok {
+		role.AudienceStrict = audienceStrict.(bool)
+	}
+
+	if boundSubject, ok := data.GetOk("bound_subject"); ok {
+		role.BoundSubject = boundSubject.(string)
+	}
+
+	if verboseOIDCLoggingRaw, ok := data.GetOk("verbose_oidc_logging"); ok {
+		role.VerboseOIDCLogging = verboseOIDCLoggingRaw.(bool)
+	}
+
+	boundClaimsType := data.Get("bound_claims_type").(string)
 	switch boundClaimsType {
-	case boundClaimsTypeString, boundClaimsTypeGlob:
+	case boundClaimsTypeString, boundClaimsTypeGlob, boundClaimsTypeRegexp:
 		role.BoundClaimsType = boundClaimsType
 	default:
 		return logical.ErrorResponse("invalid 'bound_claims_type': %s", boundClaimsType), nil
@@ -469,25 +1268,163 @@ func (b *jwtAuthBackend) pathRoleCreateUpdate(ctx context.Context, req *logical.
 		}
 	}
 
-	if claimMappingsRaw, ok := data.GetOk("claim_mappings"); ok {
-		claimMappings := claimMappingsRaw.(map[string]string)
+	if boundRepositories, ok := data.GetOk("bound_repository"); ok {
+		role.BoundRepositories = boundRepositories.([]string)
+	}
+
+	if boundEnvironments, ok := data.GetOk("bound_environment"); ok {
+		role.BoundEnvironments = boundEnvironments.([]string)
+	}
+
+	if _, ok := role.BoundClaims["repository"]; ok && len(role.BoundRepositories) > 0 {
+		return logical.ErrorResponse("the 'repository' claim can't be in both 'bound_claims' and 'bound_repository'"), nil
+	}
+	if _, ok := role.BoundClaims["environment"]; ok && len(role.BoundEnvironments) > 0 {
+		return logical.ErrorResponse("the 'environment' claim can't be in both 'bound_claims' and 'bound_environment'"), nil
+	}
+
+	// Regular expressions are checked even if only bound_claims_type
+	// changed, since existing values may not be valid patterns.
+	if boundClaimsType == boundClaimsTypeRegexp {
+		for claim, claimValues := range role.BoundClaims {
+			claimsValuesList, ok := normalizeList(claimValues)
+			if !ok {
+				return logical.ErrorResponse("claim is not a string or list: %v", claimValues), nil
+			}
+
+			for _, claimValue := range claimsValuesList {
+				pattern, ok := claimValue.(string)
+				if !ok {
+					return logical.ErrorResponse("claim is not a string: %v", claimValue), nil
+				}
+				if _, err := compileBoundPattern(pattern); err != nil {
+					return logical.ErrorResponse("invalid regular expression for claim %q: %s", claim, err), nil
+				}
+			}
+		}
+	}
+
+	if boundClaimsOperators, ok := data.GetOk("bound_claims_operators"); ok {
+		role.BoundClaimsOperators = boundClaimsOperators.(map[string]string)
+	}
+	for claim, expr := range role.BoundClaimsOperators {
+		if _, ok := role.BoundClaims[claim]; ok {
+			return logical.ErrorResponse("claim %q can't be in both bound_claims and bound_claims_operators", claim), nil
+		}
+		if _, err := parseClaimOperator(expr); err != nil {
+			return logical.ErrorResponse("invalid bound_claims_operators for claim %q: %s", claim, err), nil
+		}
+	}
+
+	if claimPoliciesMap, ok := data.GetOk("claim_policies_map"); ok {
+		role.ClaimPoliciesMap, err = parseClaimPoliciesMap(claimPoliciesMap.(map[string]interface{}))
+		if err != nil {
+			return logical.ErrorResponse("invalid claim_policies_map: %s", err), nil
+		}
+	}
 
-		// sanity check mappings for duplicates and collision with reserved names
-		targets := make(map[string]bool)
-		for _, metadataKey := range claimMappings {
-			if strutil.StrListContains(reservedMetadata, metadataKey) {
-				return logical.ErrorResponse("metadata key %q is reserved and may not be a mapping destination", metadataKey), nil
+	if mergeMode, ok := data.GetOk("claim_policies_map_merge_mode"); ok {
+		switch mergeMode.(string) {
+		case claimPoliciesMergeModeAppend, claimPoliciesMergeModeReplace:
+			role.ClaimPoliciesMergeMode = mergeMode.(string)
+		default:
+			return logical.ErrorResponse("invalid 'claim_policies_map_merge_mode': %s", mergeMode), nil
+		}
+	}
+
+	if claimsSchema, ok := data.GetOk("claims_schema"); ok {
+		role.ClaimsSchema = claimsSchema.(string)
+		if role.ClaimsSchema != "" {
+			if _, err := parseClaimsSchema(role.ClaimsSchema); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
 			}
+		}
+	}
 
-			if targets[metadataKey] {
-				return logical.ErrorResponse("multiple keys are mapped to metadata key %q", metadataKey), nil
+	if celPolicy, ok := data.GetOk("cel_policy"); ok {
+		role.CELPolicy = celPolicy.(string)
+		if role.CELPolicy != "" {
+			if _, err := compileCELPolicy(role.CELPolicy); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
 			}
-			targets[metadataKey] = true
+		}
+	}
+
+	if claimMappingsRaw, ok := data.GetOk("claim_mappings"); ok {
+		claimMappings := claimMappingsRaw.(map[string]string)
+		if err := checkClaimMappings(claimMappings); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
 		}
 
 		role.ClaimMappings = claimMappings
 	}
 
+	if fetchUserinfo, ok := data.GetOk("fetch_groups_from_userinfo"); ok {
+		role.FetchGroupsFromUserinfo = fetchUserinfo.(bool)
+	}
+	if userinfoURL, ok := data.GetOk("userinfo_url"); ok {
+		role.UserinfoURL = userinfoURL.(string)
+		if u, err := url.Parse(role.UserinfoURL); role.UserinfoURL != "" && (err != nil || u.Scheme == "" || u.Host == "") {
+			return logical.ErrorResponse("invalid 'userinfo_url': %q", role.UserinfoURL), nil
+		}
+	}
+	if override, ok := data.GetOk("userinfo_claims_override"); ok {
+		role.UserinfoClaimsOverride = override.(bool)
+	}
+	if role.FetchGroupsFromUserinfo && role.RoleType != "oidc" {
+		return logical.ErrorResponse("'fetch_groups_from_userinfo' is only valid for the \"oidc\" role_type"), nil
+	}
+	if !role.FetchGroupsFromUserinfo && (role.UserinfoURL != "" || role.UserinfoClaimsOverride) {
+		return logical.ErrorResponse("'userinfo_url' and 'userinfo_claims_override' require 'fetch_groups_from_userinfo'"), nil
+	}
+
+	if transformRaw, ok := data.GetOk("claim_mappings_transform"); ok {
+		transforms := transformRaw.(map[string]string)
+		if err := checkClaimTransforms(transforms); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		role.ClaimMappingsTransform = transforms
+	}
+
+	if claimNamespaceStrip, ok := data.GetOk("claim_namespace_strip"); ok {
+		role.ClaimNamespaceStrip = claimNamespaceStrip.(string)
+	}
+
+	if raw, ok := data.GetOk("encrypted_claim_mappings"); ok {
+		encryptedClaimMappings := raw.(map[string]string)
+		if err := checkClaimMappings(encryptedClaimMappings); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		role.EncryptedClaimMappings = encryptedClaimMappings
+	}
+	for _, metadataKey := range role.EncryptedClaimMappings {
+		for _, target := range role.ClaimMappings {
+			if target == metadataKey {
+				return logical.ErrorResponse("metadata key %q is a destination of both claim_mappings and encrypted_claim_mappings", metadataKey), nil
+			}
+		}
+	}
+
+	if tokenVersionClaim, ok := data.GetOk("oidc_token_version_claim"); ok {
+		role.TokenVersionClaim = tokenVersionClaim.(string)
+	}
+
+	if raw, ok := data.GetOk("versioned_claim_mappings"); ok {
+		mappings, err := parseVersionedClaimMappings(raw.(map[string]interface{}))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		role.VersionedClaimMappings = mappings
+	}
+
+	if raw, ok := data.GetOk("conditional_claim_mappings"); ok {
+		mappings, err := parseConditionalClaimMappings(raw.([]interface{}))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		role.ConditionalClaimMappings = mappings
+	}
+
 	if userClaim, ok := data.GetOk("user_claim"); ok {
 		role.UserClaim = userClaim.(string)
 	}
@@ -495,18 +1432,431 @@ func (b *jwtAuthBackend) pathRoleCreateUpdate(ctx context.Context, req *logical.
 		return logical.ErrorResponse("a user claim must be defined on the role"), nil
 	}
 
+	if aliasNameSource, ok := data.GetOk("alias_name_source"); ok {
+		role.AliasNameSource = aliasNameSource.(string)
+	}
+	switch role.AliasNameSource {
+	case "", aliasNameSourceUserClaim, aliasNameSourceFullClaimPath, aliasNameSourceClaimTemplate:
+	default:
+		return logical.ErrorResponse("invalid 'alias_name_source': %s", role.AliasNameSource), nil
+	}
+
+	if aliasNameTemplate, ok := data.GetOk("alias_name_template"); ok {
+		role.AliasNameTemplate = aliasNameTemplate.(string)
+	}
+	if role.AliasNameSource == aliasNameSourceClaimTemplate {
+		if role.AliasNameTemplate == "" {
+			return logical.ErrorResponse("'alias_name_template' must be set if 'alias_name_source' is 'claim_template'"), nil
+		}
+		if _, err := parseAliasNameTemplate(role.AliasNameTemplate); err != nil {
+			return logical.ErrorResponse("invalid 'alias_name_template': %s", err), nil
+		}
+	}
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		if google, ok := config.provider.(*GoogleProvider); ok {
+			if err := google.checkUserClaim(role); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+	}
+
 	if groupsClaim, ok := data.GetOk("groups_claim"); ok {
 		role.GroupsClaim = groupsClaim.(string)
 	}
 
+	if groupsClaimSubKey, ok := data.GetOk("groups_claim_sub_key"); ok {
+		role.GroupsClaimSubKey = groupsClaimSubKey.(string)
+	}
+
+	if ignoreMissingGroups, ok := data.GetOk("oidc_ignore_missing_groups"); ok {
+		role.IgnoreMissingGroups = ignoreMissingGroups.(bool)
+	}
+
 	if oidcScopes, ok := data.GetOk("oidc_scopes"); ok {
 		role.OIDCScopes = oidcScopes.([]string)
 	}
 
+	if requiredScopes, ok := data.GetOk("required_scopes"); ok {
+		role.RequiredScopes = requiredScopes.([]string)
+	}
+
+	if allowedCountries, ok := data.GetOk("oidc_allowed_countries"); ok {
+		if role.AllowedCountries, err = parseCountryCodes(allowedCountries.([]string)); err != nil {
+			return logical.ErrorResponse("invalid 'oidc_allowed_countries': %s", err), nil
+		}
+	}
+
+	if deniedCountries, ok := data.GetOk("oidc_denied_countries"); ok {
+		if role.DeniedCountries, err = parseCountryCodes(deniedCountries.([]string)); err != nil {
+			return logical.ErrorResponse("invalid 'oidc_denied_countries': %s", err), nil
+		}
+	}
+
+	if requireEmailVerified, ok := data.GetOk("require_email_verified"); ok {
+		role.RequireEmailVerified = requireEmailVerified.(bool)
+	}
+
+	if allowOfflineAccess, ok := data.GetOk("oidc_allow_offline_access"); ok {
+		role.AllowOfflineAccess = allowOfflineAccess.(bool)
+	}
+
+	if useAccessTokenClaims, ok := data.GetOk("oidc_use_access_token_claims"); ok {
+		role.UseAccessTokenClaims = useAccessTokenClaims.(bool)
+	}
+
+	if cacheDuration, ok := data.GetOk("jwks_cache_duration"); ok {
+		role.JWKSCacheDuration = time.Duration(cacheDuration.(int)) * time.Second
+	}
+	if maxStaleness, ok := data.GetOk("jwks_cache_max_staleness"); ok {
+		role.JWKSCacheMaxStaleness = time.Duration(maxStaleness.(int)) * time.Second
+	}
+	if role.jwksCacheMaxStaleness() < role.jwksCacheDuration() {
+		return logical.ErrorResponse("'jwks_cache_max_staleness' must not be less than 'jwks_cache_duration'"), nil
+	}
+
+	if algorithmRestrictions, ok := data.GetOk("algorithm_restrictions"); ok {
+		if role.AlgorithmRestrictions, err = parseAlgorithmRestrictions(algorithmRestrictions.([]string)); err != nil {
+			return logical.ErrorResponse("invalid 'algorithm_restrictions': %s", err), nil
+		}
+	}
+
+	if jwksURLs, ok := data.GetOk("jwks_urls"); ok {
+		role.JWKSURLs = jwksURLs.([]string)
+		for _, jwksURL := range role.JWKSURLs {
+			if u, err := url.Parse(jwksURL); err != nil || u.Scheme == "" || u.Host == "" {
+				return logical.ErrorResponse("invalid URL in 'jwks_urls': %q", jwksURL), nil
+			}
+		}
+	}
+
+	if jwksURLTimeout, ok := data.GetOk("jwks_url_timeout"); ok {
+		role.JWKSURLTimeout = time.Duration(jwksURLTimeout.(int)) * time.Second
+	}
+
+	if perKidURLTemplate, ok := data.GetOk("jwks_per_kid_url_template"); ok {
+		role.JWKSPerKidURLTemplate = perKidURLTemplate.(string)
+	}
+	if role.JWKSPerKidURLTemplate != "" && !strings.Contains(role.JWKSPerKidURLTemplate, jwksKidPlaceholder) {
+		return logical.ErrorResponse("'jwks_per_kid_url_template' must contain %q", jwksKidPlaceholder), nil
+	}
+
+	if trackTokenIPs, ok := data.GetOk("oidc_track_token_ips"); ok {
+		role.TrackTokenIPs = trackTokenIPs.(bool)
+	}
+
+	if strictIPBinding, ok := data.GetOk("oidc_strict_ip_binding"); ok {
+		role.StrictIPBinding = strictIPBinding.(bool)
+	}
+
+	if webhookURL, ok := data.GetOk("oidc_webhook_url"); ok {
+		role.WebhookURL = webhookURL.(string)
+	}
+
+	if webhookSecret, ok := data.GetOk("oidc_webhook_secret"); ok {
+		role.WebhookSecret = webhookSecret.(string)
+	}
+
+	if policyEngineURL, ok := data.GetOk("oidc_policy_engine_url"); ok {
+		role.PolicyEngineURL = policyEngineURL.(string)
+		if role.PolicyEngineURL != "" {
+			u, err := url.Parse(role.PolicyEngineURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return logical.ErrorResponse("invalid oidc_policy_engine_url %q", role.PolicyEngineURL), nil
+			}
+		}
+	}
+
+	if policyEngineFailOpen, ok := data.GetOk("policy_engine_fail_open"); ok {
+		role.PolicyEngineFailOpen = policyEngineFailOpen.(bool)
+	}
+
+	if metadataWebhookURL, ok := data.GetOk("metadata_webhook_url"); ok {
+		role.MetadataWebhookURL = metadataWebhookURL.(string)
+		if role.MetadataWebhookURL != "" {
+			u, err := url.Parse(role.MetadataWebhookURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return logical.ErrorResponse("invalid metadata_webhook_url %q", role.MetadataWebhookURL), nil
+			}
+		}
+	}
+
+	if metadataWebhookTimeout, ok := data.GetOk("metadata_webhook_timeout"); ok {
+		role.MetadataWebhookTimeout = time.Duration(metadataWebhookTimeout.(int)) * time.Second
+		if role.MetadataWebhookTimeout < 0 {
+			return logical.ErrorResponse("metadata_webhook_timeout must be non-negative"), nil
+		}
+	}
+
+	if metadataWebhookRequired, ok := data.GetOk("metadata_webhook_required"); ok {
+		role.MetadataWebhookRequired = metadataWebhookRequired.(bool)
+	}
+
+	previousAliases := role.RoleAliases
+	if roleAliases, ok := data.GetOk("role_aliases"); ok {
+		role.RoleAliases = strutil.RemoveDuplicates(roleAliases.([]string), true)
+	}
+	if err := b.validateRoleAliases(ctx, req.Storage, roleName, role.RoleAliases); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if encryptionKey, ok := data.GetOk("jwt_encryption_key"); ok {
+		role.EncryptionKey = encryptionKey.(string)
+		if role.EncryptionKey != "" {
+			if _, err := parseEncryptionKey(role.EncryptionKey); err != nil {
+				return logical.ErrorResponse("error parsing jwt_encryption_key: %s", err), nil
+			}
+		}
+	}
+
+	if fingerprintClaim, ok := data.GetOk("oidc_request_fingerprint_claim"); ok {
+		role.RequestFingerprintClaim = fingerprintClaim.(string)
+	}
+
+	if cidrsClaim, ok := data.GetOk("token_cidrs_claim"); ok {
+		role.TokenCIDRsClaim = cidrsClaim.(string)
+	}
+
+	if revocationCheckURL, ok := data.GetOk("oidc_revocation_check_url"); ok {
+		role.RevocationCheckURL = revocationCheckURL.(string)
+	}
+
+	if revocationCheckTimeout, ok := data.GetOk("oidc_revocation_check_timeout"); ok {
+		role.RevocationCheckTimeout = time.Duration(revocationCheckTimeout.(int)) * time.Second
+	}
+
+	if revocationCheckFailOpen, ok := data.GetOk("oidc_revocation_check_fail_open"); ok {
+		role.RevocationCheckFailOpen = revocationCheckFailOpen.(bool)
+	}
+
+	if revocationCacheTTL, ok := data.GetOk("oidc_revocation_cache_ttl"); ok {
+		role.RevocationCacheTTL = time.Duration(revocationCacheTTL.(int)) * time.Second
+	}
+
+	if cognitoMode, ok := data.GetOk("oidc_cognito_mode"); ok {
+		role.CognitoMode = cognitoMode.(bool)
+	}
+	if cognitoRegion, ok := data.GetOk("oidc_cognito_region"); ok {
+		role.CognitoRegion = cognitoRegion.(string)
+	}
+	if cognitoUserPoolID, ok := data.GetOk("oidc_cognito_user_pool_id"); ok {
+		role.CognitoUserPoolID = cognitoUserPoolID.(string)
+	}
+	if cognitoTokenUse, ok := data.GetOk("oidc_cognito_token_use"); ok {
+		role.CognitoTokenUse = cognitoTokenUse.(string)
+	}
+
+	if negativeCacheTTL, ok := data.GetOk("oidc_negative_cache_ttl"); ok {
+		role.NegativeCacheTTL = time.Duration(negativeCacheTTL.(int)) * time.Second
+	}
+
+	if cacheKeyFields, ok := data.GetOk("oidc_cache_key_fields"); ok {
+		role.CacheKeyFields = cacheKeyFields.([]string)
+	}
+
+	if acceptAccessTokens, ok := data.GetOk("accept_access_tokens"); ok {
+		role.AcceptAccessTokens = acceptAccessTokens.(bool)
+	}
+
+	if introspectionEndpoint, ok := data.GetOk("introspection_endpoint"); ok {
+		role.IntrospectionEndpoint = introspectionEndpoint.(string)
+		if role.IntrospectionEndpoint != "" {
+			if _, err := url.ParseRequestURI(role.IntrospectionEndpoint); err != nil {
+				return logical.ErrorResponse("invalid 'introspection_endpoint': %s", err), nil
+			}
+		}
+	}
+
+	if introspectionClientID, ok := data.GetOk("introspection_client_id"); ok {
+		role.IntrospectionClientID = introspectionClientID.(string)
+	}
+
+	if introspectionClientSecret, ok := data.GetOk("introspection_client_secret"); ok {
+		role.IntrospectionClientSecret = introspectionClientSecret.(string)
+	}
+
+	if introspectionCacheTTL, ok := data.GetOk("introspection_cache_ttl"); ok {
+		role.IntrospectionCacheTTL = time.Duration(introspectionCacheTTL.(int)) * time.Second
+	}
+
+	if introspectionAudience, ok := data.GetOk("introspection_audience"); ok {
+		role.IntrospectionAudience = introspectionAudience.(string)
+	}
+
+	if introspectionAudParam, ok := data.GetOk("introspection_audience_param"); ok {
+		role.IntrospectionAudParam = introspectionAudParam.(string)
+	}
+	switch role.IntrospectionAudParam {
+	case "", introspectionAudParamResource, introspectionAudParamAudience:
+	default:
+		return logical.ErrorResponse("invalid 'introspection_audience_param' %q, must be %q or %q", role.IntrospectionAudParam, introspectionAudParamResource, introspectionAudParamAudience), nil
+	}
+
+	if targetAudience, ok := data.GetOk("token_exchange_target_audience"); ok {
+		role.TokenExchangeTargetAudience = targetAudience.(string)
+	}
+
+	if maxValidationKeyVersions, ok := data.GetOk("max_validation_key_versions"); ok {
+		role.MaxValidationKeyVersions = maxValidationKeyVersions.(int)
+		if role.MaxValidationKeyVersions < 0 {
+			return logical.ErrorResponse("'max_validation_key_versions' must not be negative"), nil
+		}
+	}
+
+	if clientAuthMethod, ok := data.GetOk("oidc_client_auth_method"); ok {
+		role.OIDCClientAuthMethod = clientAuthMethod.(string)
+	}
+	switch role.OIDCClientAuthMethod {
+	case "", clientAuthMethodSecretBasic, clientAuthMethodSecretPost, clientAuthMethodPrivateKeyJWT:
+	default:
+		return logical.ErrorResponse("invalid 'oidc_client_auth_method': %s", role.OIDCClientAuthMethod), nil
+	}
+
+	keys, err := readClientKeys(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		keys = new(clientKeys)
+	}
+	clientKeysChanged := false
+	clientKeyID, clientKeyIDSet := data.GetOk("oidc_client_key_id")
+	if clientPrivateKey, ok := data.GetOk("oidc_client_private_key"); ok && clientPrivateKey.(string) != "" {
+		if err := validateClientPrivateKey(clientPrivateKey.(string)); err != nil {
+			return logical.ErrorResponse("error parsing 'oidc_client_private_key': %s", err), nil
+		}
+		keyID, _ := clientKeyID.(string)
+		keys.rotate(clientPrivateKey.(string), keyID)
+		clientKeysChanged = true
+	} else if clientKeyIDSet {
+		current := keys.current()
+		if current == nil {
+			return logical.ErrorResponse("'oidc_client_key_id' requires 'oidc_client_private_key' to be set"), nil
+		}
+		current.KeyID = clientKeyID.(string)
+		clientKeysChanged = true
+	}
+	if role.OIDCClientAuthMethod == clientAuthMethodPrivateKeyJWT && keys.current() == nil {
+		return logical.ErrorResponse("'oidc_client_private_key' must be set if 'oidc_client_auth_method' is \"private_key_jwt\""), nil
+	}
+
+	if role.AcceptAccessTokens {
+		if role.RoleType != "jwt" {
+			return logical.ErrorResponse("'accept_access_tokens' requires 'role_type' to be 'jwt'"), nil
+		}
+		// The userinfo response doesn't say which client the access token
+		// was issued to, so the user must be bound by its claims instead.
+		if role.BoundSubject == "" && len(role.BoundClaims) == 0 {
+			return logical.ErrorResponse("'accept_access_tokens' requires 'bound_subject' or 'bound_claims' to be set"), nil
+		}
+		if len(role.BoundAudiences) > 0 {
+			return logical.ErrorResponse("'bound_audiences' can't be validated with 'accept_access_tokens'"), nil
+		}
+	}
+
+	if role.RoleType == "introspection" && (role.IntrospectionEndpoint == "" || role.IntrospectionClientID == "" || role.IntrospectionClientSecret == "") {
+		return logical.ErrorResponse("'introspection_endpoint', 'introspection_client_id' and 'introspection_client_secret' must be set if 'role_type' is 'introspection'"), nil
+	}
+
+	// Roles created before PKCE support don't require it, for backwards
+	// compatibility.
+	if pkceRequired, ok := data.GetOk("pkce_required"); ok {
+		role.PKCERequired = pkceRequired.(bool)
+	} else if req.Operation == logical.CreateOperation {
+		role.PKCERequired = true
+	}
+
+	if deviceFlowAllowed, ok := data.GetOk("device_flow_allowed"); ok {
+		role.DeviceFlowAllowed = deviceFlowAllowed.(bool)
+	}
+	if role.DeviceFlowAllowed && role.RoleType != "oidc" {
+		return logical.ErrorResponse("'device_flow_allowed' requires 'role_type' to be 'oidc'"), nil
+	}
+
+	if maxAge, ok := data.GetOk("max_age"); ok {
+		role.MaxAge = time.Duration(maxAge.(int)) * time.Second
+		if role.MaxAge < 0 {
+			return logical.ErrorResponse("'max_age' must not be negative"), nil
+		}
+	}
+
+	if requiredClaims, ok := data.GetOk("jwt_required_claims"); ok {
+		role.RequiredClaims = requiredClaims.([]string)
+	}
+
+	if boundACRValues, ok := data.GetOk("bound_acr_values"); ok {
+		role.BoundACRValues = boundACRValues.([]string)
+	}
+
+	if satisfaction, ok := data.GetOk("acr_values_satisfication"); ok {
+		switch satisfaction.(string) {
+		case acrValuesSatisfactionAny, acrValuesSatisfactionAll:
+			role.ACRValuesSatisfaction = satisfaction.(string)
+		default:
+			return logical.ErrorResponse("invalid 'acr_values_satisfication': %s", satisfaction), nil
+		}
+	} else if role.ACRValuesSatisfaction == "" {
+		role.ACRValuesSatisfaction = acrValuesSatisfactionAny
+	}
+
+	if oidcFlow, ok := data.GetOk("oidc_flow"); ok {
+		role.OIDCFlow = oidcFlow.(string)
+	} else if role.OIDCFlow == "" {
+		role.OIDCFlow = oidcFlowCode
+	}
+	switch role.OIDCFlow {
+	case oidcFlowCode, oidcFlowImplicit:
+	default:
+		return logical.ErrorResponse("invalid 'oidc_flow': %s", role.OIDCFlow), nil
+	}
+
 	if allowedRedirectURIs, ok := data.GetOk("allowed_redirect_uris"); ok {
 		role.AllowedRedirectURIs = allowedRedirectURIs.([]string)
 	}
 
+	if role.CognitoMode {
+		if role.RoleType != "jwt" {
+			return logical.ErrorResponse("'oidc_cognito_mode' requires 'role_type' to be 'jwt'"), nil
+		}
+		if role.CognitoRegion == "" || role.CognitoUserPoolID == "" {
+			return logical.ErrorResponse("'oidc_cognito_region' and 'oidc_cognito_user_pool_id' must be set if 'oidc_cognito_mode' is enabled"), nil
+		}
+		if role.AcceptAccessTokens {
+			return logical.ErrorResponse("'oidc_cognito_mode' can't be used with 'accept_access_tokens'"), nil
+		}
+		if role.GroupsClaim == "" {
+			role.GroupsClaim = cognitoGroupsClaim
+		}
+	}
+	switch role.CognitoTokenUse {
+	case "", cognitoTokenUseID, cognitoTokenUseAccess:
+	default:
+		return logical.ErrorResponse("invalid 'oidc_cognito_token_use': %q", role.CognitoTokenUse), nil
+	}
+
+	if matchType, ok := data.GetOk("redirect_uri_match_type"); ok {
+		role.RedirectURIMatchType = matchType.(string)
+	}
+	switch role.RedirectURIMatchType {
+	case redirectURIMatchExact, "":
+		role.RedirectURIMatchType = redirectURIMatchExact
+	case redirectURIMatchGlob:
+		for _, pattern := range role.AllowedRedirectURIs {
+			if !strings.Contains(pattern, "*") {
+				continue
+			}
+			if err := checkRedirectURIPattern(pattern); err != nil {
+				return logical.ErrorResponse("invalid pattern %q in 'allowed_redirect_uris': %s", pattern, err), nil
+			}
+		}
+	default:
+		return logical.ErrorResponse("invalid 'redirect_uri_match_type': %s", role.RedirectURIMatchType), nil
+	}
+
 	if role.RoleType == "oidc" && len(role.AllowedRedirectURIs) == 0 {
 		return logical.ErrorResponse(
 			"'allowed_redirect_uris' must be set if 'role_type' is 'oidc' or unspecified."), nil
@@ -541,6 +1891,13 @@ func (b *jwtAuthBackend) pathRoleCreateUpdate(ctx context.Context, req *logical.
 			`may be present in OIDC responses.`)
 	}
 
+	if role.OIDCFlow == oidcFlowImplicit {
+		resp.AddWarning(`oidc_flow is set to "implicit" for this role. ` +
+			`The implicit flow is deprecated and insecure since tokens are ` +
+			`exposed in the browser; use the authorization code flow if the ` +
+			`provider supports it.`)
+	}
+
 	// Store the entry.
 	entry, err := logical.StorageEntryJSON(rolePrefix+roleName, role)
 	if err != nil {
@@ -550,6 +1907,19 @@ func (b *jwtAuthBackend) pathRoleCreateUpdate(ctx context.Context, req *logical.
 		return nil, err
 	}
 
+	if clientKeysChanged {
+		if err := putClientKeys(ctx, req.Storage, roleName, keys); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := b.updateRoleAliases(ctx, req.Storage, roleName, previousAliases, role.RoleAliases); err != nil {
+		return nil, err
+	}
+
+	b.flushNegativeCache(roleName)
+	b.flushJWKSCache(roleName)
+
 	return resp, nil
 }
 
@@ -557,7 +1927,9 @@ func (b *jwtAuthBackend) pathRoleCreateUpdate(ctx context.Context, req *logical.
 var roleHelp = map[string][2]string{
 	"role-list": {
 		"Lists all the roles registered with the backend.",
-		"The list will contain the names of the roles.",
+		`The list will contain the names of the roles. The key_info of each role
+		summarizes its type, OIDC client ID (redacted), bound audiences, token
+		policies and user claim.`,
 	},
 	"role": {
 		"Register an role with the backend.",
@@ -68,22 +68,30 @@ func TestPath_Create(t *testing.T) {
 			TokenNumUses:    12,
 			TokenBoundCIDRs: []*sockaddr.SockAddrMarshaler{{SockAddr: expectedSockAddr}},
 		},
-		RoleType:            "jwt",
-		Policies:            []string{"test"},
-		Period:              3 * time.Second,
-		BoundSubject:        "testsub",
-		BoundAudiences:      []string{"vault"},
-		BoundClaimsType:     "string",
-		UserClaim:           "user",
-		GroupsClaim:         "groups",
-		TTL:                 1 * time.Second,
-		MaxTTL:              5 * time.Second,
-		ExpirationLeeway:    0,
-		NotBeforeLeeway:     0,
-		ClockSkewLeeway:     0,
-		NumUses:             12,
-		BoundCIDRs:          []*sockaddr.SockAddrMarshaler{{SockAddr: expectedSockAddr}},
-		AllowedRedirectURIs: []string(nil),
+		RoleType:               "jwt",
+		Policies:               []string{"test"},
+		Period:                 3 * time.Second,
+		BoundSubject:           "testsub",
+		BoundAudiences:         []string{"vault"},
+		BoundClaimsType:        "string",
+		BoundAudiencesType:     "string",
+		AliasNameSource:        "user_claim",
+		ClaimPoliciesMergeMode: "append",
+		OIDCClientAuthMethod:   "",
+		RedirectURIMatchType:   "exact",
+		ACRValuesSatisfaction:  "any",
+		UserClaim:              "user",
+		GroupsClaim:            "groups",
+		TTL:                    1 * time.Second,
+		MaxTTL:                 5 * time.Second,
+		ExpirationLeeway:       0,
+		NotBeforeLeeway:        0,
+		ClockSkewLeeway:        0,
+		NumUses:                12,
+		BoundCIDRs:             []*sockaddr.SockAddrMarshaler{{SockAddr: expectedSockAddr}},
+		AllowedRedirectURIs:    []string(nil),
+		OIDCFlow:               "code",
+		PKCERequired:           true,
 	}
 
 	req := &logical.Request{
@@ -481,6 +489,97 @@ func TestPath_Create(t *testing.T) {
 	if resp.Error().Error() != "claim is not a string: 10" {
 		t.Fatalf("unexpected err: %v", resp)
 	}
+
+	// Test a role with an invalid regular expression in a claim
+	data = map[string]interface{}{
+		"role_type":         "jwt",
+		"user_claim":        "user",
+		"policies":          "test",
+		"clock_skew_leeway": "-1",
+		"expiration_leeway": "-1",
+		"not_before_leeway": "-1",
+		"bound_claims_type": "regexp",
+		"bound_claims": map[string]interface{}{
+			"foo": []interface{}{"^baz$", "(unclosed"},
+		},
+	}
+
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/test13",
+		Storage:   storage,
+		Data:      data,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil && !resp.IsError() {
+		t.Fatalf("expected error")
+	}
+	if !strings.HasPrefix(resp.Error().Error(), `invalid regular expression for claim "foo"`) {
+		t.Fatalf("unexpected err: %v", resp)
+	}
+
+	// Test a role with an invalid regular expression in bound_audiences
+	data = map[string]interface{}{
+		"role_type":            "jwt",
+		"user_claim":           "user",
+		"policies":             "test",
+		"bound_audiences_type": "regexp",
+		"bound_audiences":      []string{`^vault-[a-z]+$`, "(unclosed"},
+	}
+
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/test14",
+		Storage:   storage,
+		Data:      data,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil && !resp.IsError() {
+		t.Fatalf("expected error")
+	}
+	if !strings.HasPrefix(resp.Error().Error(), "invalid regular expression in bound_audiences") {
+		t.Fatalf("unexpected err: %v", resp)
+	}
+
+	// Test a role with an incomplete conditional claim mapping
+	data = map[string]interface{}{
+		"role_type":  "jwt",
+		"user_claim": "user",
+		"policies":   "test",
+		"conditional_claim_mappings": []interface{}{
+			map[string]interface{}{
+				"condition_claim": "user_type",
+				"condition_value": "employee",
+				"source_claim":    "employee_id",
+			},
+		},
+	}
+
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/test13",
+		Storage:   storage,
+		Data:      data,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil && !resp.IsError() {
+		t.Fatalf("expected error")
+	}
+	if resp.Error().Error() != `conditional_claim_mappings entry 0: "target_metadata" must be a non-empty string` {
+		t.Fatalf("unexpected err: %v", resp)
+	}
 }
 
 func TestPath_OIDCCreate(t *testing.T) {
@@ -518,11 +617,17 @@ func TestPath_OIDCCreate(t *testing.T) {
 			TokenMaxTTL:   5 * time.Second,
 			TokenNumUses:  12,
 		},
-		RoleType:        "oidc",
-		Policies:        []string{"test"},
-		Period:          3 * time.Second,
-		BoundAudiences:  []string{"vault"},
-		BoundClaimsType: "string",
+		RoleType:               "oidc",
+		Policies:               []string{"test"},
+		Period:                 3 * time.Second,
+		BoundAudiences:         []string{"vault"},
+		BoundClaimsType:        "string",
+		BoundAudiencesType:     "string",
+		AliasNameSource:        "user_claim",
+		ClaimPoliciesMergeMode: "append",
+		OIDCClientAuthMethod:   "",
+		RedirectURIMatchType:   "exact",
+		ACRValuesSatisfaction:  "any",
 		BoundClaims: map[string]interface{}{
 			"foo": json.Number("10"),
 			"bar": "baz",
@@ -541,6 +646,8 @@ func TestPath_OIDCCreate(t *testing.T) {
 		NotBeforeLeeway:  300 * time.Second,
 		ClockSkewLeeway:  1 * time.Second,
 		NumUses:          12,
+		OIDCFlow:         "code",
+		PKCERequired:     true,
 	}
 
 	// test both explicit and default role_type
@@ -681,33 +788,109 @@ func TestPath_Read(t *testing.T) {
 	}
 
 	expected := map[string]interface{}{
-		"role_type":               "jwt",
-		"bound_claims_type":       "string",
-		"bound_claims":            map[string]interface{}(nil),
-		"claim_mappings":          map[string]string(nil),
-		"bound_subject":           "testsub",
-		"bound_audiences":         []string{"vault"},
-		"allowed_redirect_uris":   []string{"http://127.0.0.1"},
-		"oidc_scopes":             []string{"email", "profile"},
-		"user_claim":              "user",
-		"groups_claim":            "groups",
-		"token_policies":          []string{"test"},
-		"policies":                []string{"test"},
-		"token_period":            int64(3),
-		"period":                  int64(3),
-		"token_ttl":               int64(1),
-		"ttl":                     int64(1),
-		"token_num_uses":          12,
-		"num_uses":                12,
-		"token_max_ttl":           int64(5),
-		"max_ttl":                 int64(5),
-		"expiration_leeway":       int64(500),
-		"not_before_leeway":       int64(500),
-		"clock_skew_leeway":       int64(100),
-		"verbose_oidc_logging":    false,
-		"token_type":              logical.TokenTypeDefault.String(),
-		"token_no_default_policy": false,
-		"token_explicit_max_ttl":  int64(0),
+		"role_type":                       "jwt",
+		"bound_claims_type":               "string",
+		"bound_audiences_type":            "string",
+		"bound_claims":                    map[string]interface{}(nil),
+		"bound_repository":                []string(nil),
+		"bound_environment":               []string(nil),
+		"bound_claims_operators":          map[string]string(nil),
+		"claim_policies_map":              map[string]map[string][]string(nil),
+		"claim_policies_map_merge_mode":   "append",
+		"claims_schema":                   "",
+		"cel_policy":                      "",
+		"oidc_token_version_claim":        "",
+		"encrypted_claim_mappings":        map[string]string(nil),
+		"versioned_claim_mappings":        map[string]map[string]string(nil),
+		"claim_mappings":                  map[string]string(nil),
+		"fetch_groups_from_userinfo":      false,
+		"userinfo_url":                    "",
+		"userinfo_claims_override":        false,
+		"claim_mappings_transform":        map[string]string(nil),
+		"claim_namespace_strip":           "",
+		"bound_subject":                   "testsub",
+		"bound_audiences":                 []string{"vault"},
+		"allowed_redirect_uris":           []string{"http://127.0.0.1"},
+		"oidc_scopes":                     []string{"email", "profile"},
+		"user_claim":                      "user",
+		"alias_name_source":               "user_claim",
+		"alias_name_template":             "",
+		"groups_claim":                    "groups",
+		"groups_claim_sub_key":            "",
+		"oidc_ignore_missing_groups":      false,
+		"oidc_allow_offline_access":       false,
+		"require_email_verified":          false,
+		"oidc_flow":                       "code",
+		"pkce_required":                   true,
+		"device_flow_allowed":             false,
+		"max_age":                         int64(0),
+		"bound_acr_values":                []string(nil),
+		"jwt_required_claims":             []string(nil),
+		"acr_values_satisfication":        "any",
+		"oidc_use_access_token_claims":    false,
+		"oidc_revocation_check_url":       "",
+		"oidc_revocation_check_timeout":   int64(0),
+		"oidc_revocation_check_fail_open": false,
+		"oidc_revocation_cache_ttl":       int64(0),
+		"oidc_cognito_mode":               false,
+		"oidc_cognito_region":             "",
+		"oidc_cognito_user_pool_id":       "",
+		"oidc_cognito_token_use":          "",
+		"oidc_negative_cache_ttl":         int64(0),
+		"jwks_cache_duration":             int64(0),
+		"jwks_cache_max_staleness":        int64(0),
+		"accept_access_tokens":            false,
+		"introspection_endpoint":          "",
+		"introspection_client_id":         "",
+		"introspection_cache_ttl":         int64(0),
+		"introspection_audience":          "",
+		"introspection_audience_param":    "",
+		"max_validation_key_versions":     0,
+		"oidc_client_auth_method":         "",
+		"token_exchange_target_audience":  "",
+		"redirect_uri_match_type":         "exact",
+		"oidc_client_key_id":              "",
+		"oidc_client_key_version":         0,
+		"oidc_cache_key_fields":           []string(nil),
+		"jwks_per_kid_url_template":       "",
+		"oidc_track_token_ips":            false,
+		"oidc_strict_ip_binding":          false,
+		"oidc_webhook_url":                "",
+		"token_cidrs_claim":               "",
+		"oidc_request_fingerprint_claim":  "",
+		"role_aliases":                    []string(nil),
+		"required_scopes":                 []string(nil),
+		"oidc_allowed_countries":          []string(nil),
+		"oidc_denied_countries":           []string(nil),
+		"jwks_urls":                       []string(nil),
+		"algorithm_restrictions":          []string(nil),
+		"jwks_url_timeout":                int64(0),
+		"oidc_policy_engine_url":          "",
+		"policy_engine_fail_open":         false,
+		"metadata_webhook_url":            "",
+		"metadata_webhook_timeout":        int64(0),
+		"metadata_webhook_required":       false,
+		"conditional_claim_mappings":      []map[string]string{},
+		"oidc_audience_strict":            false,
+		"token_policies":                  []string{"test"},
+		"policies":                        []string{"test"},
+		"token_period":                    int64(3),
+		"period":                          int64(3),
+		"token_ttl":                       int64(1),
+		"ttl":                             int64(1),
+		"token_num_uses":                  12,
+		"num_uses":                        12,
+		"token_max_ttl":                   int64(5),
+		"max_ttl":                         int64(5),
+		"expiration_leeway":               int64(500),
+		"not_before_leeway":               int64(500),
+		"clock_skew_leeway":               int64(100),
+		"verify_iat":                      false,
+		"reject_past_iat_threshold":       int64(0),
+		"verbose_oidc_logging":            false,
+		"token_type":                      logical.TokenTypeDefault.String(),
+		"token_no_default_policy":         false,
+		"token_explicit_max_ttl":          int64(0),
 	}
 
 	req := &logical.Request{
@@ -793,6 +976,97 @@ func TestPath_Read(t *testing.T) {
 	readTest()
 }
 
+func TestPath_List(t *testing.T) {
+	b, storage := getBackend(t)
+
+	entry, err := logical.StorageEntryJSON(configPath, &jwtConfig{
+		OIDCClientID:     "vault-client",
+		OIDCClientSecret: "very-secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+
+	roles := map[string]map[string]interface{}{
+		"web": {
+			"role_type":             "oidc",
+			"user_claim":            "email",
+			"bound_audiences":       "vault",
+			"allowed_redirect_uris": "https://example.com",
+			"token_policies":        "web",
+		},
+		"ci": {
+			"role_type":       "jwt",
+			"user_claim":      "sub",
+			"bound_audiences": "ci,deploy",
+			"token_policies":  "ci,deploy",
+		},
+		"batch": {
+			"role_type":     "jwt",
+			"user_claim":    "user",
+			"bound_subject": "batch",
+		},
+	}
+	for name, data := range roles {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/" + name,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "role/",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+
+	if keys := resp.Data["keys"].([]string); len(keys) != 3 {
+		t.Fatalf("expected 3 roles, got: %v", keys)
+	}
+
+	expected := map[string]interface{}{
+		"web": map[string]interface{}{
+			"role_type":       "oidc",
+			"oidc_client_id":  "vaul***",
+			"bound_audiences": []string{"vault"},
+			"token_policies":  []string{"web"},
+			"user_claim":      "email",
+		},
+		"ci": map[string]interface{}{
+			"role_type":       "jwt",
+			"oidc_client_id":  "",
+			"bound_audiences": []string{"ci", "deploy"},
+			"token_policies":  []string{"ci", "deploy"},
+			"user_claim":      "sub",
+		},
+		"batch": map[string]interface{}{
+			"role_type":       "jwt",
+			"oidc_client_id":  "",
+			"bound_audiences": []string(nil),
+			"token_policies":  []string(nil),
+			"user_claim":      "user",
+		},
+	}
+	if diff := deep.Equal(expected, resp.Data["key_info"]); diff != nil {
+		t.Fatal(diff)
+	}
+
+	if data, _ := json.Marshal(resp.Data); strings.Contains(string(data), "very-secret") {
+		t.Fatal("client secret found in the role list")
+	}
+}
+
 func TestPath_Delete(t *testing.T) {
 	b, storage := getBackend(t)
 
@@ -854,3 +1128,58 @@ func TestPath_Delete(t *testing.T) {
 		t.Fatalf("Unexpected resp data: expected nil got %#v\n", resp.Data)
 	}
 }
+
+func TestPath_VersionedClaimMappings(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/plugin-test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_type":                "jwt",
+			"bound_subject":            "testsub",
+			"user_claim":               "user",
+			"claim_mappings":           map[string]string{"name": "name"},
+			"oidc_token_version_claim": "ver",
+			"versioned_claim_mappings": map[string]interface{}{
+				"2": map[string]interface{}{"given_name": "name"},
+			},
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	role, err := b.(*jwtAuthBackend).role(context.Background(), storage, "plugin-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := log.NewNullLogger()
+	if m := role.claimMappingsFor(logger, map[string]interface{}{"ver": 2.0}); m["given_name"] != "name" {
+		t.Fatalf("expected version 2 mappings, got: %v", m)
+	}
+	if m := role.claimMappingsFor(logger, map[string]interface{}{"ver": "1"}); m["name"] != "name" {
+		t.Fatalf("expected default mappings, got: %v", m)
+	}
+	if m := role.claimMappingsFor(logger, map[string]interface{}{}); m["name"] != "name" {
+		t.Fatalf("expected default mappings, got: %v", m)
+	}
+
+	req.Operation = logical.UpdateOperation
+	req.Data = map[string]interface{}{
+		"versioned_claim_mappings": map[string]interface{}{
+			"3": map[string]interface{}{"r": "role"},
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for reserved metadata key, got: %v", resp)
+	}
+}
@@ -0,0 +1,149 @@
+package jwtauth
+
+import (
+	"context"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathTokenExchange(b *jwtAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: `token-exchange`,
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "The role to log in against.",
+			},
+			"subject_token": {
+				Type:        framework.TypeString,
+				Description: "The JWT of the calling service.",
+			},
+			"subject_token_type": {
+				Type:        framework.TypeString,
+				Description: "The type of the subject token. Only " + tokenTypeJWT + " is supported.",
+				Default:     tokenTypeJWT,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathTokenExchange,
+				Summary:  "Log in with a service JWT, exchanging it for the role's target audience if set.",
+			},
+		},
+
+		HelpSynopsis:    pathTokenExchangeHelpSyn,
+		HelpDescription: pathTokenExchangeHelpDesc,
+	}
+}
+
+func (b *jwtAuthBackend) pathTokenExchange(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if tokenType := d.Get("subject_token_type").(string); tokenType != tokenTypeJWT {
+		return logical.ErrorResponse("unsupported subject_token_type %q", tokenType), nil
+	}
+
+	subjectToken := d.Get("subject_token").(string)
+	if subjectToken == "" {
+		return logical.ErrorResponse("missing subject_token"), nil
+	}
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("could not load configuration"), nil
+	}
+
+	roleName, role, resp, err := b.loginRole(ctx, req.Storage, config, d.Get("role").(string), subjectToken)
+	if err != nil || resp != nil {
+		return resp, err
+	}
+
+	// The subject token is validated against the role like a JWT login.
+	allClaims, resp, err := b.validateLoginToken(ctx, req, config, role, roleName, subjectToken)
+	if err != nil || resp != nil {
+		return resp, err
+	}
+	if err := b.checkTokenIP(ctx, req, role, subjectToken, allClaims); err != nil {
+		if err == errTokenIPMismatch {
+			return logical.ErrorResponse("error validating token: %s", err.Error()), nil
+		}
+		return nil, err
+	}
+
+	if role.TokenExchangeTargetAudience != "" {
+		// The claims of the exchanged token haven't been validated yet.
+		var exchangedClaims map[string]interface{}
+		if exchangedClaims, resp, err = b.exchangeForTargetAudience(ctx, config, role, subjectToken); err != nil || resp != nil {
+			return resp, err
+		}
+		resp, err = b.loginResponse(ctx, req, config, role, roleName, exchangedClaims, nil)
+	} else {
+		resp, err = b.validatedLoginResponse(ctx, req, config, role, roleName, allClaims, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return b.recordLogin(ctx, req.Storage, roleName, role, resp)
+}
+
+// exchangeForTargetAudience exchanges the subject token with the provider
+// for a token scoped to the role's token_exchange_target_audience, and
+// returns the verified claims of the exchanged token.
+func (b *jwtAuthBackend) exchangeForTargetAudience(ctx context.Context, config *jwtConfig, role *jwtRole, subjectToken string) (map[string]interface{}, *logical.Response, error) {
+	if config.authType() != OIDCFlow {
+		return nil, logical.ErrorResponse("'token_exchange_target_audience' requires 'oidc_client_id' and 'oidc_client_secret' to be configured"), nil
+	}
+
+	if err := b.providerBreaker.allow(config); err != nil {
+		return nil, logical.ErrorResponse(err.Error()), nil
+	}
+
+	provider, err := b.getProvider(config)
+	if err != nil {
+		return nil, nil, errwrap.Wrapf("error getting provider for token exchange: {{err}}", err)
+	}
+
+	oidcCtx, err := b.createCAContext(ctx, config, config.OIDCDiscoveryCAPEM)
+	if err != nil {
+		return nil, nil, errwrap.Wrapf("error preparing context for token exchange: {{err}}", err)
+	}
+
+	token, err := exchangeToken(oidcCtx, config, provider.Endpoint().TokenURL, subjectToken, "", role.TokenExchangeTargetAudience)
+	if err != nil {
+		return nil, logical.ErrorResponse("%s %s", errTokenVerification, err.Error()), nil
+	}
+
+	// The exchanged token must be scoped to the target audience rather
+	// than the role's bound audiences, which apply to the subject token.
+	exchangeRole := *role
+	exchangeRole.BoundAudiences = []string{role.TokenExchangeTargetAudience}
+	exchangeRole.BoundAudiencesType = boundClaimsTypeString
+	exchangeRole.AudienceStrict = false
+	allClaims, err := b.verifyOIDCToken(ctx, config, &exchangeRole, token)
+	if err != nil {
+		return nil, logical.ErrorResponse("%s %s", errTokenVerification, err.Error()), nil
+	}
+
+	return allClaims, nil, nil
+}
+
+const (
+	pathTokenExchangeHelpSyn = `
+Authenticates a service with its own JWT, without a browser flow.
+`
+	pathTokenExchangeHelpDesc = `
+The subject token is validated against the role like a JWT login, including
+its JWKS or validation keys and bound claims. If the role doesn't set
+token_exchange_target_audience, a Vault token is issued for it directly.
+
+Otherwise the subject token is then exchanged with the configured OIDC
+provider for a token scoped to token_exchange_target_audience (RFC 8693).
+The exchanged token must be issued for that audience and satisfy the role's
+bound claims, and the Vault token is issued for its claims.
+`
+)
@@ -0,0 +1,243 @@
+package jwtauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestTokenExchange_Direct(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{
+		audience:    true,
+		boundClaims: true,
+	})
+
+	cl := jwt.Claims{
+		Audience:  jwt.Audience{"https://vault.plugin.auth.jwt.test"},
+		Issuer:    "https://team-vault.auth0.com/",
+		Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+		NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
+		Expiry:    jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+
+	tests := []struct {
+		name        string
+		color       string
+		tokenType   string
+		errExpected string
+	}{
+		{"success", "green", "", ""},
+		{"explicit token type", "green", tokenTypeJWT, ""},
+		{"bound claims mismatch", "red", "", "claim \"color\" does not match any associated bound claim values"},
+		{"unsupported token type", "green", "urn:ietf:params:oauth:token-type:saml2", "unsupported subject_token_type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subjectToken, _ := getTestJWT(t, ecdsaPrivKey, cl, map[string]interface{}{
+				"https://vault/user":   "jeff",
+				"https://vault/groups": []string{"foo"},
+				"color":                tt.color,
+			})
+
+			data := map[string]interface{}{
+				"role":          "plugin-test",
+				"subject_token": subjectToken,
+			}
+			if tt.tokenType != "" {
+				data["subject_token_type"] = tt.tokenType
+			}
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "token-exchange",
+				Storage:   storage,
+				Data:      data,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.errExpected != "" {
+				if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), tt.errExpected) {
+					t.Fatalf("expected error containing %q, got: %v", tt.errExpected, resp)
+				}
+				return
+			}
+			if resp == nil || resp.IsError() || resp.Auth == nil {
+				t.Fatalf("expected successful login, got: %v", resp)
+			}
+			if resp.Auth.Alias.Name != "jeff" {
+				t.Fatalf("unexpected alias name: %q", resp.Auth.Alias.Name)
+			}
+		})
+	}
+}
+
+func TestTokenExchange_TargetAudience(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	privKey, pubKey := newTestECKey(t)
+
+	var webhookCalls int32
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&webhookCalls, 1)
+		w.Write([]byte(`{}`))
+	}))
+	defer webhook.Close()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/exchange",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_type":                      "jwt",
+			"user_claim":                     "email",
+			"bound_audiences":                "service-a",
+			"bound_claims":                   map[string]interface{}{"team": "payments"},
+			"token_exchange_target_audience": "service-b",
+			"token_policies":                 "service-b",
+			"metadata_webhook_url":           webhook.URL,
+		},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/exchange/rotate-validation-key",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"public_key": pubKey,
+		},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+
+	cl := jwt.Claims{
+		Audience:  jwt.Audience{"service-a"},
+		Issuer:    "http://vault.example.com/",
+		Subject:   "service-a",
+		NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
+		Expiry:    jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+	subjectClaims := map[string]interface{}{
+		"email": "service-a@example.com",
+		"team":  "payments",
+	}
+
+	exchange := func(subjectToken string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token-exchange",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role":          "exchange",
+				"subject_token": subjectToken,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	subjectToken, _ := getTestJWT(t, privKey, cl, subjectClaims)
+	s.subjectToken = subjectToken
+	s.customClaims = map[string]interface{}{
+		"email": "service-a@example.com",
+		"team":  "payments",
+	}
+
+	resp = exchange(subjectToken)
+	if resp == nil || resp.IsError() || resp.Auth == nil {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+	if resp.Auth.Alias.Name != "service-a@example.com" || resp.Auth.Policies[0] != "service-b" {
+		t.Fatalf("unexpected auth: %#v", resp.Auth)
+	}
+
+	// The subject token is only validated, so the login side effects happen
+	// once, for the exchanged token.
+	if n := atomic.LoadInt32(&webhookCalls); n != 1 {
+		t.Fatalf("expected 1 metadata webhook call, got %d", n)
+	}
+	counts := b.(*jwtAuthBackend).tokenStats.counts(time.Now())
+	if c := counts["exchange"].(map[string]uint64)["last_hour"]; c != 1 {
+		t.Fatalf("expected 1 issued token, got %d", c)
+	}
+
+	// The exchanged token must satisfy the role's bound claims as well.
+	s.customClaims["team"] = "billing"
+	if resp := exchange(subjectToken); resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "claim \"team\" does not match") {
+		t.Fatalf("expected bound claims error, got: %v", resp)
+	}
+
+	// A subject token failing the bound claims is rejected before the
+	// exchange.
+	s.customClaims["team"] = "payments"
+	subjectClaims["team"] = "billing"
+	subjectToken, _ = getTestJWT(t, privKey, cl, subjectClaims)
+	if resp := exchange(subjectToken); resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "claim \"team\" does not match") {
+		t.Fatalf("expected bound claims error, got: %v", resp)
+	}
+}
+
+// countingEnricher counts how often the claims of a login are enriched.
+type countingEnricher struct {
+	calls int32
+}
+
+func (e *countingEnricher) Enrich(ctx context.Context, rawClaims map[string]interface{}) (map[string]interface{}, error) {
+	atomic.AddInt32(&e.calls, 1)
+	return rawClaims, nil
+}
+
+func TestTokenExchange_Direct_ValidatesClaimsOnce(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{audience: true})
+
+	config, err := b.Backend.(*jwtAuthBackend).config(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enricher := &countingEnricher{}
+	config.enricher = enricher
+
+	cl := jwt.Claims{
+		Audience:  jwt.Audience{"https://vault.plugin.auth.jwt.test"},
+		Issuer:    "https://team-vault.auth0.com/",
+		Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+		NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
+		Expiry:    jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+	subjectToken, _ := getTestJWT(t, ecdsaPrivKey, cl, map[string]interface{}{
+		"https://vault/user":   "jeff",
+		"https://vault/groups": []string{"foo"},
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "token-exchange",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role":          "plugin-test",
+			"subject_token": subjectToken,
+		},
+	})
+	if err != nil || resp == nil || resp.IsError() || resp.Auth == nil {
+		t.Fatalf("expected successful login, err:%v resp:%#v", err, resp)
+	}
+
+	if calls := atomic.LoadInt32(&enricher.calls); calls != 1 {
+		t.Fatalf("expected the claims to be validated once, got %d", calls)
+	}
+}
@@ -0,0 +1,27 @@
+package jwtauth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/hashicorp/go-uuid"
+)
+
+// pkceMethodS256 is the only supported PKCE code challenge method (RFC 7636).
+const pkceMethodS256 = "S256"
+
+// newCodeVerifier returns a random PKCE code verifier of 43 characters.
+func newCodeVerifier() (string, error) {
+	bytes, err := uuid.GenerateRandomBytes(32)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// pkceChallenge returns the S256 code challenge for verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,111 @@
+package jwtauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/policyutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// policyEngineTimeout bounds a single policy engine request.
+const policyEngineTimeout = 5 * time.Second
+
+// policyEngineRequest is the body POSTed to a role's oidc_policy_engine_url.
+type policyEngineRequest struct {
+	Role       string                 `json:"role"`
+	Claims     map[string]interface{} `json:"claims"`
+	RemoteAddr string                 `json:"remote_addr"`
+}
+
+// policyEngineResponse is the response expected from the policy engine.
+type policyEngineResponse struct {
+	Policies *[]string         `json:"policies"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// applyPolicyEngine replaces the policies of auth with the ones returned by
+// the role's policy engine, and adds the returned metadata keys that aren't
+// already set. If the policy engine fails, the login fails unless the role has
+// policy_engine_fail_open, in which case auth is left unchanged.
+func (b *jwtAuthBackend) applyPolicyEngine(ctx context.Context, config *jwtConfig, role *jwtRole, roleName string, req *logical.Request, allClaims map[string]interface{}, auth *logical.Auth) error {
+	if role.PolicyEngineURL == "" {
+		return nil
+	}
+
+	var remoteAddr string
+	if req.Connection != nil {
+		remoteAddr = req.Connection.RemoteAddr
+	}
+
+	result, err := fetchPolicyEngineDecision(ctx, config, role.PolicyEngineURL, policyEngineRequest{
+		Role:       roleName,
+		Claims:     allClaims,
+		RemoteAddr: remoteAddr,
+	})
+	if err != nil {
+		if !role.PolicyEngineFailOpen {
+			return fmt.Errorf("policy engine failed: %s", err)
+		}
+		b.Logger().Warn("policy engine failed, using the role policies", "url", role.PolicyEngineURL, "role", roleName, "error", err)
+		return nil
+	}
+
+	auth.Policies = policyutil.SanitizePolicies(*result.Policies, false)
+	for k, v := range result.Metadata {
+		if _, ok := auth.Metadata[k]; ok {
+			continue
+		}
+		auth.Metadata[k] = v
+	}
+
+	return nil
+}
+
+func fetchPolicyEngineDecision(ctx context.Context, config *jwtConfig, engineURL string, payload policyEngineRequest) (*policyEngineResponse, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := buildHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, policyEngineTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, engineURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var result policyEngineResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error decoding response: %s", err)
+	}
+	// A missing list is more likely a broken engine than a decision to
+	// attach no policies.
+	if result.Policies == nil {
+		return nil, errors.New(`response has no "policies"`)
+	}
+
+	return &result, nil
+}
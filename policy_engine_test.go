@@ -0,0 +1,127 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestLogin_PolicyEngine(t *testing.T) {
+	var payload policyEngineRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"policies": ["engine-a", "Engine-B"], "metadata": {"decision": "allow", "role": "ignored"}}`))
+	}))
+	defer srv.Close()
+
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		roleData: map[string]interface{}{
+			"oidc_policy_engine_url": srv.URL,
+		},
+	})
+	req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+
+	if payload.Role != "plugin-test" || payload.RemoteAddr != "127.0.0.1" || payload.Claims["sub"] != "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients" {
+		t.Fatalf("unexpected payload: %#v", payload)
+	}
+
+	if diff := deep.Equal(resp.Auth.Policies, []string{"engine-a", "engine-b"}); diff != nil {
+		t.Fatal(diff)
+	}
+	if resp.Auth.Metadata["decision"] != "allow" || resp.Auth.Metadata["role"] != "plugin-test" {
+		t.Fatalf("unexpected metadata: %v", resp.Auth.Metadata)
+	}
+}
+
+func TestLogin_PolicyEngineFailure(t *testing.T) {
+	var status int
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	for _, failOpen := range []bool{false, true} {
+		b, storage := setupBackend(t, testConfig{
+			audience: true,
+			roleData: map[string]interface{}{
+				"oidc_policy_engine_url":  srv.URL,
+				"policy_engine_fail_open": failOpen,
+			},
+		})
+
+		for _, response := range []struct {
+			status int
+			body   string
+		}{
+			{http.StatusServiceUnavailable, `{"error": "unavailable"}`},
+			{http.StatusOK, `{"metadata": {"decision": "allow"}}`},
+			{http.StatusOK, `not json`},
+		} {
+			status, body = response.status, response.body
+
+			req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !failOpen {
+				if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "policy engine failed") {
+					t.Fatalf("expected policy engine error for %q, got: %v", body, resp)
+				}
+				continue
+			}
+
+			// The role policies are used.
+			if resp == nil || resp.IsError() {
+				t.Fatalf("expected successful login for %q, got: %v", body, resp)
+			}
+			if diff := deep.Equal(resp.Auth.Policies, []string{"test"}); diff != nil {
+				t.Fatal(diff)
+			}
+		}
+	}
+}
+
+func TestRole_PolicyEngineURL_Invalid(t *testing.T) {
+	b, storage := getBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role_type":              "jwt",
+			"user_claim":             "user",
+			"bound_subject":          "testsub",
+			"oidc_policy_engine_url": "not a url",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for invalid oidc_policy_engine_url, got: %v", resp)
+	}
+}
@@ -0,0 +1,106 @@
+package jwtauth
+
+import (
+	"crypto/sha256"
+	"math/bits"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPoWDifficulty is the maximum oidc_pow_difficulty, and the most
+	// leading zero bits a proof-of-work solution is ever required to have.
+	maxPoWDifficulty = 32
+
+	// The difficulty is raised by one bit for every powFailuresPerBit failed
+	// callbacks within the last powFailureBuckets minutes, by up to
+	// maxPoWExtraBits.
+	powFailureBuckets = 5
+	powFailuresPerBit = 10
+	maxPoWExtraBits   = 8
+)
+
+// failureRate counts failed OIDC callbacks in per-minute buckets, so that
+// its memory use doesn't grow with the failure rate.
+type failureRate struct {
+	l       sync.Mutex
+	buckets [powFailureBuckets]struct {
+		minute int64
+		count  int
+	}
+}
+
+// record counts a failure at now.
+func (f *failureRate) record(now time.Time) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	minute := now.Unix() / 60
+	bucket := &f.buckets[minute%powFailureBuckets]
+	if bucket.minute != minute {
+		bucket.minute = minute
+		bucket.count = 0
+	}
+	bucket.count++
+}
+
+// count returns the number of failures in the last powFailureBuckets
+// minutes.
+func (f *failureRate) count(now time.Time) int {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	minute := now.Unix() / 60
+	total := 0
+	for _, bucket := range f.buckets {
+		if minute-bucket.minute < powFailureBuckets {
+			total += bucket.count
+		}
+	}
+	return total
+}
+
+// powDifficulty returns the number of leading zero bits that proof-of-work
+// solutions of new logins must have, or 0 if oidc_pow_difficulty is unset.
+func (b *jwtAuthBackend) powDifficulty(config *jwtConfig) int {
+	if config.OIDCPoWDifficulty == 0 {
+		return 0
+	}
+
+	extra := b.callbackFailures.count(time.Now()) / powFailuresPerBit
+	if extra > maxPoWExtraBits {
+		extra = maxPoWExtraBits
+	}
+	difficulty := config.OIDCPoWDifficulty + extra
+	if difficulty > maxPoWDifficulty {
+		difficulty = maxPoWDifficulty
+	}
+	return difficulty
+}
+
+// validPoWSolution checks that the SHA-256 hash of puzzle, ":" and solution
+// starts with at least difficulty zero bits.
+func validPoWSolution(puzzle, solution string, difficulty int) bool {
+	sum := sha256.Sum256([]byte(puzzle + ":" + solution))
+
+	zeros := 0
+	for _, c := range sum {
+		zeros += bits.LeadingZeros8(c)
+		if c != 0 {
+			break
+		}
+	}
+	return zeros >= difficulty
+}
+
+// solvePoW returns the first decimal solution of puzzle with difficulty
+// leading zero bits.
+func solvePoW(puzzle string, difficulty int) string {
+	for i := uint64(0); ; i++ {
+		solution := strconv.FormatUint(i, 10)
+		if validPoWSolution(puzzle, solution, difficulty) {
+			return solution
+		}
+	}
+}
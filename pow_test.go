@@ -0,0 +1,139 @@
+package jwtauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPoW_Solve(t *testing.T) {
+	for _, difficulty := range []int{0, 1, 8, 12} {
+		solution := solvePoW("puzzle", difficulty)
+		if !validPoWSolution("puzzle", solution, difficulty) {
+			t.Fatalf("invalid solution %q for difficulty %d", solution, difficulty)
+		}
+		// Solutions are bound to their puzzle.
+		if difficulty >= 8 && validPoWSolution("other", solution, difficulty) {
+			t.Fatalf("solution %q is valid for another puzzle", solution)
+		}
+	}
+
+	if validPoWSolution("puzzle", solvePoW("puzzle", 4), maxPoWDifficulty) {
+		t.Fatal("expected solution to be too easy")
+	}
+}
+
+func TestPoW_Difficulty(t *testing.T) {
+	b, _ := getBackend(t)
+	backend := b.(*jwtAuthBackend)
+
+	if d := backend.powDifficulty(&jwtConfig{}); d != 0 {
+		t.Fatalf("expected no difficulty when unset, got %d", d)
+	}
+
+	config := &jwtConfig{OIDCPoWDifficulty: 10}
+	if d := backend.powDifficulty(config); d != 10 {
+		t.Fatalf("expected difficulty 10, got %d", d)
+	}
+
+	now := time.Now()
+	for i := 0; i < 2*powFailuresPerBit; i++ {
+		backend.callbackFailures.record(now)
+	}
+	if d := backend.powDifficulty(config); d != 12 {
+		t.Fatalf("expected difficulty 12 after failures, got %d", d)
+	}
+
+	// The extra bits are capped, as is the total difficulty.
+	for i := 0; i < 100*powFailuresPerBit; i++ {
+		backend.callbackFailures.record(now)
+	}
+	if d := backend.powDifficulty(config); d != 10+maxPoWExtraBits {
+		t.Fatalf("expected difficulty %d, got %d", 10+maxPoWExtraBits, d)
+	}
+	if d := backend.powDifficulty(&jwtConfig{OIDCPoWDifficulty: maxPoWDifficulty}); d != maxPoWDifficulty {
+		t.Fatalf("expected difficulty %d, got %d", maxPoWDifficulty, d)
+	}
+
+	// Old failures no longer count.
+	if n := backend.callbackFailures.count(now.Add(powFailureBuckets * time.Minute)); n != 0 {
+		t.Fatalf("expected failures to expire, got %d", n)
+	}
+}
+
+func TestOIDC_ProofOfWork(t *testing.T) {
+	b, storage, s := getBackendAndServerWithConfig(t, false, map[string]interface{}{
+		"oidc_pow_difficulty": 8,
+	})
+	defer s.server.Close()
+
+	s.code = "abc"
+
+	// login starts a login, and completes it with the solution returned by
+	// solve for the puzzle.
+	login := func(solve func(puzzle string) string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "oidc/auth_url",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role":         "test",
+				"redirect_uri": "https://example.com",
+			},
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("err:%v resp:%#v\n", err, resp)
+		}
+
+		url := resp.Data["auth_url"].(string)
+		state := getQueryParam(t, url, "state")
+		if resp.Data["pow_puzzle"] != state || resp.Data["pow_difficulty"] != 8 {
+			t.Fatalf("unexpected proof-of-work challenge: %v", resp.Data)
+		}
+		s.customClaims = sampleClaims(getQueryParam(t, url, "nonce"))
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "oidc/callback",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"state":        state,
+				"code":         "abc",
+				"pow_solution": solve(state),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// A missing solution is rejected, unless "" happens to be valid.
+	resp := login(func(string) string { return "" })
+	if resp == nil || !resp.IsError() {
+		resp = login(func(string) string { return "" })
+	}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "proof-of-work") {
+		t.Fatalf("expected proof-of-work error, got: %v", resp)
+	}
+
+	resp = login(func(puzzle string) string {
+		solution := "x"
+		for validPoWSolution(puzzle, solution, 8) {
+			solution += "x"
+		}
+		return solution
+	})
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "proof-of-work") {
+		t.Fatalf("expected proof-of-work error, got: %v", resp)
+	}
+
+	resp = login(func(puzzle string) string { return solvePoW(puzzle, 8) })
+	if resp == nil || resp.IsError() || resp.Auth == nil {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+}
@@ -0,0 +1,150 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/helper/parseutil"
+	"golang.org/x/oauth2"
+)
+
+const (
+	azureDefaultGraphURL     = "https://graph.microsoft.com"
+	azureDefaultGraphTimeout = 10 * time.Second
+)
+
+// AzureProvider handles Azure AD tokens: groups are resolved to display names
+// through the Microsoft Graph API, including group overage, and the tenant
+// segment is stripped from the issuer.
+type AzureProvider struct {
+	tenantID     string
+	graphTimeout time.Duration
+
+	// graphURL is overridden in tests
+	graphURL string
+}
+
+// Initialize parses the azure_tenant_id and azure_graph_api_timeout settings.
+func (a *AzureProvider) Initialize(jc *jwtConfig) error {
+	if err := checkProviderConfigKeys(jc.ProviderConfig, "azure_tenant_id", "azure_graph_api_timeout"); err != nil {
+		return err
+	}
+
+	a.graphURL = azureDefaultGraphURL
+	a.graphTimeout = azureDefaultGraphTimeout
+
+	if raw, ok := jc.ProviderConfig["azure_tenant_id"]; ok {
+		tenantID, ok := raw.(string)
+		if !ok {
+			return errors.New("'azure_tenant_id' must be a string")
+		}
+		a.tenantID = tenantID
+	}
+
+	if raw, ok := jc.ProviderConfig["azure_graph_api_timeout"]; ok {
+		timeout, err := parseutil.ParseDurationSecond(raw)
+		if err != nil {
+			return errwrap.Wrapf("error parsing 'azure_graph_api_timeout': {{err}}", err)
+		}
+		if timeout <= 0 {
+			return errors.New("'azure_graph_api_timeout' must be positive")
+		}
+		a.graphTimeout = timeout
+	}
+
+	return nil
+}
+
+// HandleClaims checks the tid claim against azure_tenant_id and removes the
+// tenant segment from iss so that bound claims on the issuer are tenant
+// independent.
+func (a *AzureProvider) HandleClaims(allClaims map[string]interface{}) error {
+	tid, _ := allClaims["tid"].(string)
+	if a.tenantID != "" && tid != a.tenantID {
+		return errors.New("tid claim does not match azure_tenant_id")
+	}
+
+	if iss, ok := allClaims["iss"].(string); ok && tid != "" {
+		allClaims["iss"] = strings.Replace(iss, "/"+tid, "", 1)
+	}
+
+	return nil
+}
+
+// FetchGroups returns the display names of the groups the user is a member of,
+// as reported by the Graph API. Without an access token, only the group object
+// IDs from the token can be returned, and group overage cannot be resolved.
+func (a *AzureProvider) FetchGroups(ctx context.Context, b *jwtAuthBackend, allClaims map[string]interface{}, role *jwtRole, tokenSource oauth2.TokenSource) (interface{}, error) {
+	if tokenSource == nil {
+		if a.hasGroupOverage(allClaims) {
+			return nil, errors.New("group overage in token can only be resolved during an OIDC login")
+		}
+		return getClaim(b.Logger(), allClaims, role.GroupsClaim), nil
+	}
+
+	client := oauth2.NewClient(ctx, tokenSource)
+	client.Timeout = a.graphTimeout
+
+	var groups []interface{}
+	next := a.graphURL + "/v1.0/me/memberOf?$select=id,displayName"
+	for next != "" {
+		page, err := a.getMemberOfPage(client, next)
+		if err != nil {
+			return nil, errwrap.Wrapf("error fetching groups from Microsoft Graph: {{err}}", err)
+		}
+		for _, v := range page.Value {
+			if v.ODataType != "#microsoft.graph.group" || v.DisplayName == "" {
+				continue
+			}
+			groups = append(groups, v.DisplayName)
+		}
+		next = page.NextLink
+	}
+
+	return groups, nil
+}
+
+// hasGroupOverage reports whether the token signals that the groups claim was
+// omitted because the user is a member of too many groups.
+func (a *AzureProvider) hasGroupOverage(allClaims map[string]interface{}) bool {
+	claimNames, ok := allClaims["_claim_names"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, ok = claimNames["groups"]
+	return ok
+}
+
+type azureMemberOfPage struct {
+	NextLink string `json:"@odata.nextLink"`
+	Value    []struct {
+		ODataType   string `json:"@odata.type"`
+		ID          string `json:"id"`
+		DisplayName string `json:"displayName"`
+	} `json:"value"`
+}
+
+func (a *AzureProvider) getMemberOfPage(client *http.Client, url string) (*azureMemberOfPage, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var page azureMemberOfPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, err
+	}
+
+	return &page, nil
+}
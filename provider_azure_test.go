@@ -0,0 +1,123 @@
+package jwtauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestAzureProvider_Initialize(t *testing.T) {
+	tests := []struct {
+		name            string
+		providerConfig  map[string]interface{}
+		expectedTimeout time.Duration
+		errExpected     bool
+	}{
+		{"defaults", map[string]interface{}{"provider": "azure"}, azureDefaultGraphTimeout, false},
+		{"timeout", map[string]interface{}{"provider": "azure", "azure_graph_api_timeout": "30s"}, 30 * time.Second, false},
+		{"invalid timeout", map[string]interface{}{"provider": "azure", "azure_graph_api_timeout": "soon"}, 0, true},
+		{"negative timeout", map[string]interface{}{"provider": "azure", "azure_graph_api_timeout": -5}, 0, true},
+		{"unknown key", map[string]interface{}{"provider": "azure", "azure_tenant": "abc"}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newCustomProvider(&jwtConfig{ProviderConfig: tt.providerConfig})
+			if tt.errExpected {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if timeout := p.(*AzureProvider).graphTimeout; timeout != tt.expectedTimeout {
+				t.Fatalf("expected timeout %s, got %s", tt.expectedTimeout, timeout)
+			}
+		})
+	}
+}
+
+func TestAzureProvider_HandleClaims(t *testing.T) {
+	a := &AzureProvider{tenantID: "tenant1"}
+
+	claims := map[string]interface{}{
+		"iss": "https://login.microsoftonline.com/tenant1/v2.0",
+		"tid": "tenant1",
+	}
+	if err := a.HandleClaims(claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims["iss"] != "https://login.microsoftonline.com/v2.0" {
+		t.Fatalf("unexpected iss: %v", claims["iss"])
+	}
+
+	claims = map[string]interface{}{
+		"iss": "https://login.microsoftonline.com/tenant2/v2.0",
+		"tid": "tenant2",
+	}
+	if err := a.HandleClaims(claims); err == nil {
+		t.Fatal("expected tenant mismatch error")
+	}
+}
+
+func TestAzureProvider_FetchGroups(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("page") == "2" {
+			w.Write([]byte(`{"value": [{"@odata.type": "#microsoft.graph.group", "id": "2", "displayName": "admins"}]}`))
+			return
+		}
+		fmt.Fprintf(w, `{
+			"@odata.nextLink": "%s/v1.0/me/memberOf?page=2",
+			"value": [
+				{"@odata.type": "#microsoft.graph.group", "id": "1", "displayName": "engineering"},
+				{"@odata.type": "#microsoft.graph.directoryRole", "id": "3", "displayName": "Global Reader"}
+			]
+		}`, server.URL)
+	}))
+	defer server.Close()
+
+	b, _ := getBackend(t)
+	a := &AzureProvider{graphURL: server.URL, graphTimeout: time.Second}
+	role := &jwtRole{GroupsClaim: "groups"}
+
+	overageClaims := map[string]interface{}{
+		"_claim_names": map[string]interface{}{"groups": "src1"},
+	}
+
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "access"})
+	groups, err := a.FetchGroups(context.Background(), b.(*jwtAuthBackend), overageClaims, role, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{"engineering", "admins"}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("expected %v, got %v", expected, groups)
+	}
+
+	// JWT logins have no access token
+	if _, err := a.FetchGroups(context.Background(), b.(*jwtAuthBackend), overageClaims, role, nil); err == nil {
+		t.Fatal("expected overage error without access token")
+	}
+
+	groups, err = a.FetchGroups(context.Background(), b.(*jwtAuthBackend), map[string]interface{}{"groups": []interface{}{"id1"}}, role, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(groups, []interface{}{"id1"}) {
+		t.Fatalf("unexpected groups: %v", groups)
+	}
+}
@@ -0,0 +1,157 @@
+package jwtauth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+)
+
+// providerFactories returns constructors for the built-in providers, keyed by
+// the value of the "provider" key in provider_config.
+func providerFactories() map[string]func() CustomProvider {
+	return map[string]func() CustomProvider{
+		"azure":        func() CustomProvider { return &AzureProvider{} },
+		"github":       func() CustomProvider { return &GitHubProvider{} },
+		"google":       func() CustomProvider { return &GoogleProvider{} },
+		"keycloak":     func() CustomProvider { return &KeycloakProvider{} },
+		"okta":         func() CustomProvider { return &OktaProvider{} },
+		"pingidentity": func() CustomProvider { return &PingProvider{} },
+	}
+}
+
+// CustomProvider is implemented by built-in providers that need handling
+// beyond standard OIDC. Providers may additionally implement ClaimsHandler
+// and GroupsFetcher.
+type CustomProvider interface {
+	// Initialize parses and validates the provider specific settings in
+	// provider_config.
+	Initialize(jc *jwtConfig) error
+}
+
+// ClaimsHandler is implemented by providers that normalize or validate
+// claims before the role's bound claims are checked. It may modify allClaims.
+type ClaimsHandler interface {
+	HandleClaims(allClaims map[string]interface{}) error
+}
+
+// GroupsFetcher is implemented by providers that resolve group membership
+// instead of reading the role's groups_claim directly. tokenSource is nil for
+// JWT logins, where no access token is available.
+type GroupsFetcher interface {
+	FetchGroups(ctx context.Context, b *jwtAuthBackend, allClaims map[string]interface{}, role *jwtRole, tokenSource oauth2.TokenSource) (interface{}, error)
+}
+
+// IdentityHandler is implemented by providers that add entity alias metadata
+// or choose the token display name. Metadata from the role's claim_mappings
+// takes precedence, and an empty display name falls back to the alias name.
+type IdentityHandler interface {
+	AliasMetadata(allClaims map[string]interface{}) map[string]string
+	DisplayName(allClaims map[string]interface{}) string
+}
+
+// AliasNormalizer is implemented by providers whose user identifiers can be
+// sent in different forms for the same user. NormalizeAliasName is applied to
+// the entity alias name derived from the role's user_claim.
+type AliasNormalizer interface {
+	NormalizeAliasName(name string) string
+}
+
+// newCustomProvider returns the initialized provider named in provider_config,
+// or nil if provider_config is empty.
+func newCustomProvider(jc *jwtConfig) (CustomProvider, error) {
+	if len(jc.ProviderConfig) == 0 {
+		return nil, nil
+	}
+
+	name, ok := jc.ProviderConfig["provider"].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("'provider' field not found in provider_config")
+	}
+
+	factory, ok := providerFactories()[name]
+	if !ok {
+		return nil, fmt.Errorf("provider %q not found in custom providers", name)
+	}
+
+	provider := factory()
+	if err := provider.Initialize(jc); err != nil {
+		return nil, fmt.Errorf("error initializing %q provider_config: %s", name, err)
+	}
+
+	return provider, nil
+}
+
+// handleProviderClaims runs the configured provider's claim handling, if any.
+func handleProviderClaims(config *jwtConfig, allClaims map[string]interface{}) error {
+	if handler, ok := config.provider.(ClaimsHandler); ok {
+		return handler.HandleClaims(allClaims)
+	}
+
+	return nil
+}
+
+// providerDisplayName returns the token display name for alias, as chosen by
+// the configured provider if it implements IdentityHandler.
+func providerDisplayName(config *jwtConfig, allClaims map[string]interface{}, alias *logical.Alias) string {
+	if handler, ok := config.provider.(IdentityHandler); ok {
+		if name := handler.DisplayName(allClaims); name != "" {
+			return name
+		}
+	}
+
+	return alias.Name
+}
+
+// normalizeAliasName is applied to entity alias names when the configured
+// provider implements AliasNormalizer.
+func normalizeAliasName(config *jwtConfig, name string) string {
+	if normalizer, ok := config.provider.(AliasNormalizer); ok {
+		return normalizer.NormalizeAliasName(name)
+	}
+
+	return name
+}
+
+// stripAliasPrefix removes prefix from name, ignoring case. The name is
+// returned unchanged if nothing would be left of it.
+func stripAliasPrefix(name, prefix string) string {
+	if prefix == "" || len(name) <= len(prefix) || !strings.EqualFold(name[:len(prefix)], prefix) {
+		return name
+	}
+
+	return name[len(prefix):]
+}
+
+// providerConfigString returns the string setting key of provider_config, or
+// "" if it isn't set.
+func providerConfigString(providerConfig map[string]interface{}, key string) (string, error) {
+	raw, ok := providerConfig[key]
+	if !ok {
+		return "", nil
+	}
+	s, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("'%s' must be a string", key)
+	}
+
+	return s, nil
+}
+
+// checkProviderConfigKeys returns an error if provider_config contains keys
+// other than "provider" and those in allowed.
+func checkProviderConfigKeys(providerConfig map[string]interface{}, allowed ...string) error {
+	for k := range providerConfig {
+		if k == "provider" {
+			continue
+		}
+		if !strutil.StrListContains(allowed, k) {
+			return fmt.Errorf("unknown provider_config key %q", k)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package jwtauth
+
+import (
+	"fmt"
+	"strings"
+)
+
+const githubActionsIssuer = "https://token.actions.githubusercontent.com"
+
+// GitHubProvider handles GitHub Actions OIDC tokens. The issuer is checked
+// and job_workflow_ref is split into its workflow and ref parts. Which
+// repositories and environments may log in is set per role with
+// bound_repository and bound_environment.
+type GitHubProvider struct{}
+
+// Initialize checks that no settings other than the provider are given.
+func (g *GitHubProvider) Initialize(jc *jwtConfig) error {
+	return checkProviderConfigKeys(jc.ProviderConfig)
+}
+
+// HandleClaims validates the issuer, and adds the job_workflow and
+// job_workflow_git_ref claims derived from job_workflow_ref.
+func (g *GitHubProvider) HandleClaims(allClaims map[string]interface{}) error {
+	if iss, _ := allClaims["iss"].(string); iss != githubActionsIssuer {
+		return fmt.Errorf("iss claim does not match %q", githubActionsIssuer)
+	}
+
+	// e.g. octo-org/octo-repo/.github/workflows/deploy.yml@refs/heads/main
+	if ref, ok := allClaims["job_workflow_ref"].(string); ok {
+		if i := strings.LastIndex(ref, "@"); i != -1 {
+			allClaims["job_workflow"] = ref[:i]
+			allClaims["job_workflow_git_ref"] = ref[i+1:]
+		}
+	}
+
+	return nil
+}
+
+// githubBoundClaims returns the role's bound_repository and
+// bound_environment as bound claims. They are always matched exactly,
+// whatever the bound_claims_type of the role.
+func (r *jwtRole) githubBoundClaims() map[string]interface{} {
+	boundClaims := make(map[string]interface{})
+	for claim, values := range map[string][]string{
+		"repository":  r.BoundRepositories,
+		"environment": r.BoundEnvironments,
+	} {
+		if len(values) == 0 {
+			continue
+		}
+		list := make([]interface{}, len(values))
+		for i, v := range values {
+			list[i] = v
+		}
+		boundClaims[claim] = list
+	}
+	return boundClaims
+}
@@ -0,0 +1,177 @@
+package jwtauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestGitHubProvider_HandleClaims(t *testing.T) {
+	baseClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":              githubActionsIssuer,
+			"repository":       "octo-org/octo-repo",
+			"environment":      "prod",
+			"job_workflow_ref": "octo-org/octo-repo/.github/workflows/deploy.yml@refs/heads/main",
+		}
+	}
+
+	tests := []struct {
+		name           string
+		providerConfig map[string]interface{}
+		modify         func(map[string]interface{})
+		errExpected    bool
+	}{
+		{"valid", nil, nil, false},
+		{"wrong issuer", nil, func(c map[string]interface{}) { c["iss"] = "https://example.com" }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pc := map[string]interface{}{"provider": "github"}
+			for k, v := range tt.providerConfig {
+				pc[k] = v
+			}
+			p, err := newCustomProvider(&jwtConfig{ProviderConfig: pc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claims := baseClaims()
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+
+			err = p.(ClaimsHandler).HandleClaims(claims)
+			if tt.errExpected != (err != nil) {
+				t.Fatalf("unexpected error result: %v", err)
+			}
+			if err != nil {
+				return
+			}
+
+			if claims["job_workflow"] != "octo-org/octo-repo/.github/workflows/deploy.yml" || claims["job_workflow_git_ref"] != "refs/heads/main" {
+				t.Fatalf("unexpected normalized claims: %v", claims)
+			}
+		})
+	}
+}
+
+func TestGitHubProvider_BoundRepositoryInProviderConfig(t *testing.T) {
+	_, err := newCustomProvider(&jwtConfig{ProviderConfig: map[string]interface{}{
+		"provider":         "github",
+		"bound_repository": "octo-org/octo-repo",
+	}})
+	if err == nil || !strings.Contains(err.Error(), `unknown provider_config key "bound_repository"`) {
+		t.Fatalf("expected unknown key error, got: %v", err)
+	}
+}
+
+func TestLogin_BoundRepository(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{
+		configData: map[string]interface{}{
+			"bound_issuer": githubActionsIssuer,
+			"provider_config": map[string]interface{}{
+				"provider": "github",
+			},
+		},
+	})
+
+	for name, repository := range map[string]string{
+		"app":   "octo-org/app",
+		"infra": "octo-org/infra",
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/" + name,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role_type":         "jwt",
+				"user_claim":        "sub",
+				"bound_audiences":   "https://github.com/octo-org",
+				"bound_repository":  repository,
+				"bound_environment": "prod,staging",
+				// Values are matched exactly, not as globs.
+				"bound_claims_type": "glob",
+				"bound_claims":      map[string]interface{}{"ref": "refs/heads/*"},
+			},
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+	}
+
+	login := func(role, repository, environment string) *logical.Response {
+		t.Helper()
+		cl := jwt.Claims{
+			Audience:  jwt.Audience{"https://github.com/octo-org"},
+			Issuer:    githubActionsIssuer,
+			Subject:   "repo:" + repository + ":environment:" + environment,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+			Expiry:    jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}
+		privateCl := map[string]interface{}{
+			"repository":       repository,
+			"environment":      environment,
+			"ref":              "refs/heads/main",
+			"job_workflow_ref": repository + "/.github/workflows/deploy.yml@refs/heads/main",
+		}
+		token, _ := getTestJWT(t, ecdsaPrivKey, cl, privateCl)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role": role,
+				"jwt":  token,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	tests := []struct {
+		role, repository, environment string
+		success                       bool
+	}{
+		{"app", "octo-org/app", "prod", true},
+		{"infra", "octo-org/infra", "staging", true},
+		{"app", "octo-org/infra", "prod", false},
+		{"infra", "octo-org/app", "prod", false},
+		{"app", "octo-org/*", "prod", false},
+		{"app", "octo-org/app", "dev", false},
+	}
+
+	for _, tt := range tests {
+		resp := login(tt.role, tt.repository, tt.environment)
+		if tt.success && (resp == nil || resp.IsError()) {
+			t.Fatalf("%s with %s/%s: expected successful login, got: %v", tt.role, tt.repository, tt.environment, resp)
+		}
+		if !tt.success && (resp == nil || !resp.IsError()) {
+			t.Fatalf("%s with %s/%s: expected login error, got: %v", tt.role, tt.repository, tt.environment, resp)
+		}
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/app",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"bound_claims": map[string]interface{}{"repository": "octo-org/app"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() || !strings.Contains(resp.Error().Error(), "can't be in both 'bound_claims' and 'bound_repository'") {
+		t.Fatalf("expected conflict error, got: %v", resp)
+	}
+}
@@ -0,0 +1,77 @@
+package jwtauth
+
+import (
+	"errors"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/helper/parseutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+)
+
+// GoogleProvider handles Google tokens. The hosted domain (hd) claim is
+// added to alias metadata as "domain" and can be restricted with
+// bound_google_workspace_domain, and the token display name is the email
+// address. Roles must use "sub" as user_claim, since it is stable where the
+// email address is not; see checkUserClaim.
+//
+// Google rotates its signing keys frequently; no special handling is needed
+// because the key set is refetched whenever an unknown key ID is seen.
+type GoogleProvider struct {
+	boundDomains []string
+}
+
+// Initialize parses the bound_google_workspace_domain setting.
+func (g *GoogleProvider) Initialize(jc *jwtConfig) error {
+	if err := checkProviderConfigKeys(jc.ProviderConfig, "bound_google_workspace_domain"); err != nil {
+		return err
+	}
+
+	if raw, ok := jc.ProviderConfig["bound_google_workspace_domain"]; ok {
+		domains, err := parseutil.ParseCommaStringSlice(raw)
+		if err != nil {
+			return errwrap.Wrapf("error parsing 'bound_google_workspace_domain': {{err}}", err)
+		}
+		g.boundDomains = domains
+	}
+
+	return nil
+}
+
+// checkUserClaim returns an error if role names its entity aliases after
+// anything but the sub claim.
+func (g *GoogleProvider) checkUserClaim(role *jwtRole) error {
+	if role.UserClaim != "sub" || role.AliasNameSource == aliasNameSourceClaimTemplate {
+		return errors.New(`the google provider requires 'user_claim' to be "sub", since Google email addresses can change`)
+	}
+
+	return nil
+}
+
+// HandleClaims checks the hd claim against bound_google_workspace_domain.
+func (g *GoogleProvider) HandleClaims(allClaims map[string]interface{}) error {
+	if len(g.boundDomains) == 0 {
+		return nil
+	}
+
+	hd, _ := allClaims["hd"].(string)
+	if !strutil.StrListContains(g.boundDomains, hd) {
+		return errors.New("hd claim does not match bound_google_workspace_domain")
+	}
+
+	return nil
+}
+
+// AliasMetadata maps the hd claim to the "domain" metadata field.
+func (g *GoogleProvider) AliasMetadata(allClaims map[string]interface{}) map[string]string {
+	if hd, ok := allClaims["hd"].(string); ok && hd != "" {
+		return map[string]string{"domain": hd}
+	}
+
+	return nil
+}
+
+// DisplayName returns the email claim.
+func (g *GoogleProvider) DisplayName(allClaims map[string]interface{}) string {
+	email, _ := allClaims["email"].(string)
+	return email
+}
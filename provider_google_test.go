@@ -0,0 +1,111 @@
+package jwtauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestGoogleProvider(t *testing.T) {
+	p, err := newCustomProvider(&jwtConfig{ProviderConfig: map[string]interface{}{
+		"provider":                      "google",
+		"bound_google_workspace_domain": "example.com",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &jwtConfig{provider: p}
+
+	claims := map[string]interface{}{
+		"sub":   "1234567890",
+		"email": "bob@example.com",
+		"hd":    "example.com",
+		"name":  "Bob",
+	}
+	if err := handleProviderClaims(config, claims); err != nil {
+		t.Fatal(err)
+	}
+	if err := handleProviderClaims(config, map[string]interface{}{"hd": "other.com"}); err == nil {
+		t.Fatal("expected hosted domain mismatch error")
+	}
+	if err := handleProviderClaims(config, map[string]interface{}{}); err == nil {
+		t.Fatal("expected missing hosted domain error")
+	}
+
+	b, _ := getBackend(t)
+	role := &jwtRole{
+		UserClaim:     "sub",
+		ClaimMappings: map[string]string{"name": "name"},
+	}
+	alias, _, err := b.(*jwtAuthBackend).createIdentity(context.Background(), config, claims, role, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias.Name != "1234567890" || alias.Metadata["domain"] != "example.com" || alias.Metadata["name"] != "Bob" {
+		t.Fatalf("unexpected alias: %#v", alias)
+	}
+	if name := providerDisplayName(config, claims, alias); name != "bob@example.com" {
+		t.Fatalf("unexpected display name: %q", name)
+	}
+
+	// falls back to the alias name without an email claim
+	delete(claims, "email")
+	if name := providerDisplayName(config, claims, alias); name != "1234567890" {
+		t.Fatalf("unexpected display name: %q", name)
+	}
+}
+
+func TestGoogleProvider_UserClaim(t *testing.T) {
+	b, storage := getBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"jwt_validation_pubkeys": []string{testJWTPubKey},
+			"provider_config": map[string]interface{}{
+				"provider": "google",
+			},
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+
+	tests := map[string]struct {
+		data    map[string]interface{}
+		success bool
+	}{
+		"sub":            {map[string]interface{}{"user_claim": "sub"}, true},
+		"email":          {map[string]interface{}{"user_claim": "email"}, false},
+		"claim template": {map[string]interface{}{"user_claim": "sub", "alias_name_source": "claim_template", "alias_name_template": "{{.email}}"}, false},
+	}
+
+	for name, tt := range tests {
+		data := map[string]interface{}{
+			"role_type":       "jwt",
+			"bound_audiences": "vault",
+		}
+		for k, v := range tt.data {
+			data[k] = v
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/test",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.success && resp != nil && resp.IsError() {
+			t.Fatalf("%s: unexpected error: %v", name, resp.Error())
+		}
+		if !tt.success && (resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), `requires 'user_claim' to be "sub"`)) {
+			t.Fatalf("%s: expected user_claim error, got: %#v", name, resp)
+		}
+	}
+}
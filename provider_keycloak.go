@@ -0,0 +1,131 @@
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// keycloakFederatedIDRegex matches the prefix of the IDs Keycloak gives to
+// users of a federation provider that aren't imported: f:<provider-id>:.
+var keycloakFederatedIDRegex = regexp.MustCompile(`^f:[^:]+:`)
+
+// KeycloakProvider handles Keycloak tokens. Realm roles from
+// realm_access.roles and client roles from resource_access.<client>.roles
+// are added to the role's groups claim as a single list, prefixed with
+// realm_role_prefix and client_role_prefix respectively. Client roles are
+// taken from the client matching oidc_client_id, or from all clients if no
+// client ID is configured. The token display name is preferred_username.
+//
+// Keycloak usernames are case-insensitive, so entity alias names are lower
+// cased. The f:<provider-id>: prefix of federated user IDs, and the
+// alias_prefix setting if any, are stripped from them, so that the alias
+// doesn't change if the federation provider is replaced.
+type KeycloakProvider struct {
+	clientID         string
+	realmRolePrefix  string
+	clientRolePrefix string
+	aliasPrefix      string
+}
+
+// Initialize parses the realm_role_prefix, client_role_prefix and
+// alias_prefix settings.
+func (k *KeycloakProvider) Initialize(jc *jwtConfig) error {
+	if err := checkProviderConfigKeys(jc.ProviderConfig, "realm_role_prefix", "client_role_prefix", "alias_prefix"); err != nil {
+		return err
+	}
+
+	var ok bool
+	if raw, exists := jc.ProviderConfig["realm_role_prefix"]; exists {
+		if k.realmRolePrefix, ok = raw.(string); !ok {
+			return errors.New("'realm_role_prefix' must be a string")
+		}
+	}
+	if raw, exists := jc.ProviderConfig["client_role_prefix"]; exists {
+		if k.clientRolePrefix, ok = raw.(string); !ok {
+			return errors.New("'client_role_prefix' must be a string")
+		}
+	}
+	aliasPrefix, err := providerConfigString(jc.ProviderConfig, "alias_prefix")
+	if err != nil {
+		return err
+	}
+	k.aliasPrefix = aliasPrefix
+	k.clientID = jc.OIDCClientID
+
+	return nil
+}
+
+// FetchGroups returns the values of the role's groups claim followed by the
+// prefixed realm and client roles. It returns nil if none are present.
+func (k *KeycloakProvider) FetchGroups(_ context.Context, b *jwtAuthBackend, allClaims map[string]interface{}, role *jwtRole, _ oauth2.TokenSource) (interface{}, error) {
+	var groups []interface{}
+
+	if raw := getClaim(b.Logger(), allClaims, role.GroupsClaim); raw != nil {
+		list, ok := normalizeList(raw)
+		if !ok {
+			return nil, errors.New("groups claim could not be converted to string list")
+		}
+		groups = append(groups, list...)
+	}
+
+	if realmAccess, ok := allClaims["realm_access"].(map[string]interface{}); ok {
+		groups = appendPrefixedRoles(groups, realmAccess["roles"], k.realmRolePrefix)
+	}
+
+	if resourceAccess, ok := allClaims["resource_access"].(map[string]interface{}); ok {
+		for client, access := range resourceAccess {
+			if k.clientID != "" && client != k.clientID {
+				continue
+			}
+			if access, ok := access.(map[string]interface{}); ok {
+				groups = appendPrefixedRoles(groups, access["roles"], k.clientRolePrefix)
+			}
+		}
+	}
+
+	if groups == nil {
+		return nil, nil
+	}
+
+	return groups, nil
+}
+
+// AliasMetadata adds no metadata.
+func (k *KeycloakProvider) AliasMetadata(map[string]interface{}) map[string]string {
+	return nil
+}
+
+// DisplayName returns the preferred_username claim.
+func (k *KeycloakProvider) DisplayName(allClaims map[string]interface{}) string {
+	name, _ := allClaims["preferred_username"].(string)
+	return name
+}
+
+// NormalizeAliasName strips the federated ID and alias_prefix prefixes from
+// name and lower cases it.
+func (k *KeycloakProvider) NormalizeAliasName(name string) string {
+	if loc := keycloakFederatedIDRegex.FindStringIndex(name); loc != nil && loc[1] < len(name) {
+		name = name[loc[1]:]
+	}
+	name = stripAliasPrefix(name, k.aliasPrefix)
+
+	return strings.ToLower(name)
+}
+
+func appendPrefixedRoles(groups []interface{}, rawRoles interface{}, prefix string) []interface{} {
+	roles, ok := rawRoles.([]interface{})
+	if !ok {
+		return groups
+	}
+	for _, r := range roles {
+		if r, ok := r.(string); ok && r != "" {
+			groups = append(groups, prefix+r)
+		}
+	}
+
+	return groups
+}
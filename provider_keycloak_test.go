@@ -0,0 +1,129 @@
+package jwtauth
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestKeycloakProvider(t *testing.T) {
+	claims := map[string]interface{}{
+		"sub":                "f0c1",
+		"preferred_username": "bob",
+		"groups":             []interface{}{"/devs"},
+		"realm_access": map[string]interface{}{
+			"roles": []interface{}{"offline_access", "admin"},
+		},
+		"resource_access": map[string]interface{}{
+			"vault": map[string]interface{}{
+				"roles": []interface{}{"operator"},
+			},
+			"other": map[string]interface{}{
+				"roles": []interface{}{"viewer"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		clientID string
+		expected []string
+	}{
+		{"all clients", "", []string{"/devs", "client:operator", "client:viewer", "realm:admin", "realm:offline_access"}},
+		{"configured client", "vault", []string{"/devs", "client:operator", "realm:admin", "realm:offline_access"}},
+	}
+
+	b, _ := getBackend(t)
+	role := &jwtRole{UserClaim: "sub", GroupsClaim: "groups"}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newCustomProvider(&jwtConfig{
+				OIDCClientID: tt.clientID,
+				ProviderConfig: map[string]interface{}{
+					"provider":           "keycloak",
+					"realm_role_prefix":  "realm:",
+					"client_role_prefix": "client:",
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			config := &jwtConfig{provider: p}
+
+			alias, groupAliases, err := b.(*jwtAuthBackend).createIdentity(context.Background(), config, claims, role, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var groups []string
+			for _, g := range groupAliases {
+				groups = append(groups, g.Name)
+			}
+			sort.Strings(groups)
+			if !reflect.DeepEqual(groups, tt.expected) {
+				t.Fatalf("expected groups %v, got %v", tt.expected, groups)
+			}
+
+			if name := providerDisplayName(config, claims, alias); name != "bob" {
+				t.Fatalf("unexpected display name: %q", name)
+			}
+		})
+	}
+
+	// no groups or roles at all is treated as a missing groups claim
+	p, err := newCustomProvider(&jwtConfig{ProviderConfig: map[string]interface{}{"provider": "keycloak"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = b.(*jwtAuthBackend).createIdentity(context.Background(), &jwtConfig{provider: p}, map[string]interface{}{"sub": "f0c1"}, role, nil)
+	if err == nil {
+		t.Fatal("expected missing groups error")
+	}
+}
+
+func TestKeycloakProvider_AliasName(t *testing.T) {
+	b, _ := getBackend(t)
+	role := &jwtRole{UserClaim: "preferred_username"}
+
+	p, err := newCustomProvider(&jwtConfig{ProviderConfig: map[string]interface{}{
+		"provider":     "keycloak",
+		"alias_prefix": "Corp\\",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &jwtConfig{provider: p}
+
+	tests := map[string]string{
+		"Bob":                      "bob",
+		"f:3a5c0e2b:Bob.Smith":     "bob.smith",
+		"F:3A5C0E2B:Bob":           "f:3a5c0e2b:bob",
+		"CORP\\Alice":              "alice",
+		"corp\\ALICE":              "alice",
+		"f:3a5c0e2b:Corp\\Alice":   "alice",
+		"Corp\\":                   "corp\\",
+		"f:3a5c0e2b:":              "f:3a5c0e2b:",
+		"service-account-Vault-CI": "service-account-vault-ci",
+	}
+
+	for name, expected := range tests {
+		alias, _, err := b.(*jwtAuthBackend).createIdentity(context.Background(), config, map[string]interface{}{
+			"preferred_username": name,
+		}, role, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if alias.Name != expected {
+			t.Fatalf("%s: expected alias name %q, got %q", name, expected, alias.Name)
+		}
+	}
+
+	if _, err := newCustomProvider(&jwtConfig{ProviderConfig: map[string]interface{}{
+		"provider":     "keycloak",
+		"alias_prefix": 1,
+	}}); err == nil {
+		t.Fatal("expected error for a non-string alias_prefix")
+	}
+}
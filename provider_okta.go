@@ -0,0 +1,109 @@
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"golang.org/x/oauth2"
+)
+
+// oktaEveryoneGroup is the group every Okta user belongs to. It carries no
+// information, so it is never mapped to a group alias, whatever its case.
+const oktaEveryoneGroup = "Everyone"
+
+// OktaProvider handles Okta tokens. Okta ID tokens don't include group
+// membership unless a groups claim is configured on the authorization
+// server, so groups missing from the token are fetched from the UserInfo
+// endpoint during OIDC logins. The "Everyone" group is dropped, and the token
+// display name is the Okta login.
+//
+// Okta logins are case-insensitive, and the login sent as sub in access
+// tokens can differ in case from the one in ID tokens, so entity alias names
+// are lower cased after the alias_prefix setting, if any, is stripped, e.g.
+// an AD domain prefix.
+type OktaProvider struct {
+	config      *jwtConfig
+	aliasPrefix string
+}
+
+// Initialize parses the alias_prefix setting.
+func (o *OktaProvider) Initialize(jc *jwtConfig) error {
+	if err := checkProviderConfigKeys(jc.ProviderConfig, "alias_prefix"); err != nil {
+		return err
+	}
+	aliasPrefix, err := providerConfigString(jc.ProviderConfig, "alias_prefix")
+	if err != nil {
+		return err
+	}
+	o.aliasPrefix = aliasPrefix
+	o.config = jc
+
+	return nil
+}
+
+// FetchGroups returns the role's groups claim from the token, or from the
+// UserInfo endpoint if the token has none and an access token is available.
+func (o *OktaProvider) FetchGroups(ctx context.Context, b *jwtAuthBackend, allClaims map[string]interface{}, role *jwtRole, tokenSource oauth2.TokenSource) (interface{}, error) {
+	raw := getClaim(b.Logger(), allClaims, role.GroupsClaim)
+
+	if raw == nil && tokenSource != nil {
+		provider, err := b.getProvider(o.config)
+		if err != nil {
+			return nil, errwrap.Wrapf("error getting provider for UserInfo request: {{err}}", err)
+		}
+		oidcCtx, err := b.createCAContext(ctx, o.config, o.config.OIDCDiscoveryCAPEM)
+		if err != nil {
+			return nil, errwrap.Wrapf("error preparing context for UserInfo request: {{err}}", err)
+		}
+
+		userinfo, err := provider.UserInfo(oidcCtx, tokenSource)
+		if err != nil {
+			return nil, errwrap.Wrapf("error fetching groups from UserInfo: {{err}}", err)
+		}
+		userinfoClaims := make(map[string]interface{})
+		if err := userinfo.Claims(&userinfoClaims); err != nil {
+			return nil, errwrap.Wrapf("error parsing UserInfo response: {{err}}", err)
+		}
+		raw = getClaim(b.Logger(), userinfoClaims, role.GroupsClaim)
+	}
+
+	if raw == nil {
+		return nil, nil
+	}
+
+	list, ok := normalizeList(raw)
+	if !ok {
+		return nil, errors.New("groups claim could not be converted to string list")
+	}
+
+	groups := make([]interface{}, 0, len(list))
+	for _, g := range list {
+		if s, ok := g.(string); !ok || !strings.EqualFold(s, oktaEveryoneGroup) {
+			groups = append(groups, g)
+		}
+	}
+
+	return groups, nil
+}
+
+// AliasMetadata adds no metadata.
+func (o *OktaProvider) AliasMetadata(map[string]interface{}) map[string]string {
+	return nil
+}
+
+// NormalizeAliasName strips alias_prefix from name and lower cases it.
+func (o *OktaProvider) NormalizeAliasName(name string) string {
+	return strings.ToLower(stripAliasPrefix(name, o.aliasPrefix))
+}
+
+// DisplayName returns the Okta login, which is sent as preferred_username in
+// ID tokens and as login in access tokens.
+func (o *OktaProvider) DisplayName(allClaims map[string]interface{}) string {
+	if name, ok := allClaims["preferred_username"].(string); ok && name != "" {
+		return name
+	}
+	name, _ := allClaims["login"].(string)
+	return name
+}
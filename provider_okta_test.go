@@ -0,0 +1,89 @@
+package jwtauth
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestOktaProvider(t *testing.T) {
+	b, storage, s := getBackendAndServerWithConfig(t, false, map[string]interface{}{
+		"provider_config": map[string]interface{}{
+			"provider": "okta",
+		},
+	})
+	defer s.server.Close()
+
+	jb := b.(*jwtAuthBackend)
+	config, err := jb.config(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetcher := config.provider.(GroupsFetcher)
+	role := &jwtRole{GroupsClaim: "groups"}
+
+	// groups in the token are used as is, minus Everyone
+	groups, err := fetcher.FetchGroups(context.Background(), jb, map[string]interface{}{
+		"groups": []interface{}{"Everyone", "devs"},
+	}, role, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(groups, []interface{}{"devs"}) {
+		t.Fatalf("unexpected groups: %v", groups)
+	}
+
+	// without groups in the token and no access token there is nothing to fetch
+	groups, err = fetcher.FetchGroups(context.Background(), jb, map[string]interface{}{}, role, nil)
+	if err != nil || groups != nil {
+		t.Fatalf("expected no groups, got: %v, %v", groups, err)
+	}
+
+	// otherwise groups are fetched from UserInfo
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "access"})
+	groups, err = fetcher.FetchGroups(context.Background(), jb, map[string]interface{}{}, role, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(groups, []interface{}{"okta-admins"}) {
+		t.Fatalf("unexpected groups: %v", groups)
+	}
+
+	if name := config.provider.(IdentityHandler).DisplayName(map[string]interface{}{"login": "bob@example.com"}); name != "bob@example.com" {
+		t.Fatalf("unexpected display name: %q", name)
+	}
+}
+
+func TestOktaProvider_AliasName(t *testing.T) {
+	b, _ := getBackend(t)
+	role := &jwtRole{UserClaim: "sub", GroupsClaim: "groups"}
+
+	p, err := newCustomProvider(&jwtConfig{ProviderConfig: map[string]interface{}{
+		"provider":     "okta",
+		"alias_prefix": "CORP\\",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &jwtConfig{provider: p}
+
+	// the same login in different cases, and with or without the prefix,
+	// maps to a single alias
+	for _, sub := range []string{"Bob@Example.com", "bob@example.com", "corp\\BOB@example.COM"} {
+		alias, groupAliases, err := b.(*jwtAuthBackend).createIdentity(context.Background(), config, map[string]interface{}{
+			"sub":    sub,
+			"groups": []interface{}{"EVERYONE", "everyone", "Devs"},
+		}, role, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if alias.Name != "bob@example.com" {
+			t.Fatalf("%s: unexpected alias name %q", sub, alias.Name)
+		}
+		if len(groupAliases) != 1 || groupAliases[0].Name != "Devs" {
+			t.Fatalf("%s: unexpected group aliases: %v", sub, groupAliases)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// pingDefaultClaimPrefix is the WS-Federation claim namespace PingFederate
+// uses for claims mapped from SAML attribute contracts.
+const pingDefaultClaimPrefix = "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/"
+
+// PingProvider handles PingFederate and PingOne tokens. claim_name_prefix is
+// stripped from claim names, so roles can refer to e.g. "emailaddress", and a
+// groups claim sent as a single semicolon delimited string is split into a
+// list.
+type PingProvider struct {
+	claimPrefix string
+}
+
+// Initialize parses the claim_name_prefix setting.
+func (p *PingProvider) Initialize(jc *jwtConfig) error {
+	if err := checkProviderConfigKeys(jc.ProviderConfig, "claim_name_prefix"); err != nil {
+		return err
+	}
+
+	p.claimPrefix = pingDefaultClaimPrefix
+	if raw, ok := jc.ProviderConfig["claim_name_prefix"]; ok {
+		prefix, ok := raw.(string)
+		if !ok {
+			return errors.New("'claim_name_prefix' must be a string")
+		}
+		p.claimPrefix = prefix
+	}
+
+	return nil
+}
+
+// HandleClaims strips the claim name prefix. A claim whose stripped name is
+// already present in the token is left as is.
+func (p *PingProvider) HandleClaims(allClaims map[string]interface{}) error {
+	if p.claimPrefix == "" {
+		return nil
+	}
+
+	for k, v := range allClaims {
+		if !strings.HasPrefix(k, p.claimPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(k, p.claimPrefix)
+		if _, ok := allClaims[name]; ok || name == "" {
+			continue
+		}
+		allClaims[name] = v
+		delete(allClaims, k)
+	}
+
+	return nil
+}
+
+// FetchGroups returns the role's groups claim, splitting semicolon delimited
+// strings.
+func (p *PingProvider) FetchGroups(_ context.Context, b *jwtAuthBackend, allClaims map[string]interface{}, role *jwtRole, _ oauth2.TokenSource) (interface{}, error) {
+	raw := getClaim(b.Logger(), allClaims, role.GroupsClaim)
+
+	s, ok := raw.(string)
+	if !ok {
+		return raw, nil
+	}
+
+	var groups []interface{}
+	for _, g := range strings.Split(s, ";") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+
+	return groups, nil
+}
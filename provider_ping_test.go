@@ -0,0 +1,64 @@
+package jwtauth
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPingProvider(t *testing.T) {
+	p, err := newCustomProvider(&jwtConfig{ProviderConfig: map[string]interface{}{"provider": "pingidentity"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &jwtConfig{provider: p}
+
+	claims := map[string]interface{}{
+		"sub":                                   "bob",
+		pingDefaultClaimPrefix + "emailaddress": "bob@example.com",
+		pingDefaultClaimPrefix + "sub":          "shadowed",
+		"memberOf":                              "vault-admins; devs;;",
+	}
+	if err := handleProviderClaims(config, claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims["emailaddress"] != "bob@example.com" || claims["sub"] != "bob" {
+		t.Fatalf("unexpected normalized claims: %v", claims)
+	}
+
+	b, _ := getBackend(t)
+	role := &jwtRole{UserClaim: "emailaddress", GroupsClaim: "memberOf"}
+	alias, groupAliases, err := b.(*jwtAuthBackend).createIdentity(context.Background(), config, claims, role, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias.Name != "bob@example.com" {
+		t.Fatalf("unexpected alias name: %q", alias.Name)
+	}
+
+	var groups []string
+	for _, g := range groupAliases {
+		groups = append(groups, g.Name)
+	}
+	sort.Strings(groups)
+	if !reflect.DeepEqual(groups, []string{"devs", "vault-admins"}) {
+		t.Fatalf("unexpected groups: %v", groups)
+	}
+
+	// a custom prefix
+	p, err = newCustomProvider(&jwtConfig{ProviderConfig: map[string]interface{}{
+		"provider":          "pingidentity",
+		"claim_name_prefix": "urn:ping:",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims = map[string]interface{}{"urn:ping:group": "a"}
+	if err := handleProviderClaims(&jwtConfig{provider: p}, claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims["group"] != "a" {
+		t.Fatalf("unexpected normalized claims: %v", claims)
+	}
+}
@@ -0,0 +1,42 @@
+package jwtauth
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+
+	"github.com/hashicorp/vault/sdk/helper/certutil"
+	joseed25519 "golang.org/x/crypto/ed25519"
+)
+
+// parsePublicKeyPEM parses a PEM public key or certificate like
+// certutil.ParsePublicKeyPEM, also accepting Ed25519 keys. Ed25519 keys are
+// returned as the golang.org/x/crypto type that go-jose verifies EdDSA
+// signatures with.
+func parsePublicKeyPEM(data []byte) (interface{}, error) {
+	key, err := certutil.ParsePublicKeyPEM(data)
+	if err == nil {
+		return key, nil
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, err
+	}
+
+	rawKey, pkixErr := x509.ParsePKIXPublicKey(block.Bytes)
+	if pkixErr != nil {
+		cert, certErr := x509.ParseCertificate(block.Bytes)
+		if certErr != nil {
+			return nil, err
+		}
+		rawKey = cert.PublicKey
+	}
+
+	if edKey, ok := rawKey.(ed25519.PublicKey); ok {
+		return joseed25519.PublicKey(edKey), nil
+	}
+
+	return nil, errors.New("data does not contain any valid RSA, ECDSA or Ed25519 public keys")
+}
@@ -0,0 +1,168 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	joseed25519 "golang.org/x/crypto/ed25519"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// signedLoginJWT returns a token with the claims of setupLogin, signed with
+// key by alg.
+func signedLoginJWT(t *testing.T, alg jose.SignatureAlgorithm, key interface{}, kid string) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", kid))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cl := jwt.Claims{
+		Audience: jwt.Audience{"https://vault.plugin.auth.jwt.test"},
+		Issuer:   "https://team-vault.auth0.com/",
+		Subject:  "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+		IssuedAt: jwt.NewNumericDate(time.Now()),
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+	privateCl := map[string]interface{}{
+		"https://vault/user":   "foobar",
+		"https://vault/groups": []string{"foo", "bar"},
+	}
+
+	token, err := jwt.Signed(signer).Claims(cl).Claims(privateCl).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func loginWithJWT(t *testing.T, b logical.Backend, storage logical.Storage, token string) *logical.Response {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "login",
+		Storage:    storage,
+		Data:       map[string]interface{}{"role": "plugin-test", "jwt": token},
+		Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestLogin_EdDSA(t *testing.T) {
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edToken := signedLoginJWT(t, jose.EdDSA, joseed25519.PrivateKey(edPriv), "ed")
+	rsaToken := signedLoginJWT(t, jose.RS256, rsaKey, "rsa")
+
+	t.Run("jwks", func(t *testing.T) {
+		jwks, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: joseed25519.PublicKey(edPub), KeyID: "ed", Algorithm: string(jose.EdDSA), Use: "sig"},
+			{Key: &rsaKey.PublicKey, KeyID: "rsa", Algorithm: string(jose.RS256), Use: "sig"},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(jwks), `"kty":"OKP"`) {
+			t.Fatalf("expected an OKP key, got: %s", jwks)
+		}
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(jwks)
+		}))
+		defer server.Close()
+
+		b, storage := setupBackend(t, testConfig{
+			audience: true,
+			configData: map[string]interface{}{
+				"jwt_validation_pubkeys": "",
+				"jwks_url":               server.URL,
+			},
+			roleData: map[string]interface{}{
+				"algorithm_restrictions": "EdDSA",
+			},
+		})
+
+		resp := loginWithJWT(t, b, storage, edToken)
+		if resp == nil || resp.IsError() || resp.Auth == nil {
+			t.Fatalf("expected successful login, got: %v", resp)
+		}
+		if resp.Auth.Alias.Name != "foobar" {
+			t.Fatalf("unexpected alias: %q", resp.Auth.Alias.Name)
+		}
+
+		// The RSA key is in the JWKS, but the role only accepts EdDSA.
+		resp = loginWithJWT(t, b, storage, rsaToken)
+		if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), `signing algorithm "RS256" is not allowed by algorithm_restrictions`) {
+			t.Fatalf("expected RS256 to be rejected, got: %v", resp)
+		}
+	})
+
+	t.Run("validation pubkeys", func(t *testing.T) {
+		der, err := x509.MarshalPKIXPublicKey(edPub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		edPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+		b, storage := setupBackend(t, testConfig{
+			audience: true,
+			configData: map[string]interface{}{
+				"jwt_validation_pubkeys": []string{edPEM},
+				"jwt_supported_algs":     []string{"EdDSA"},
+			},
+		})
+
+		resp := loginWithJWT(t, b, storage, edToken)
+		if resp == nil || resp.IsError() || resp.Auth == nil {
+			t.Fatalf("expected successful login, got: %v", resp)
+		}
+
+		resp = loginWithJWT(t, b, storage, rsaToken)
+		if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), `unsupported signing algorithm "RS256"`) {
+			t.Fatalf("expected RS256 to be rejected, got: %v", resp)
+		}
+	})
+}
+
+func TestValidateAccessTokenHash_EdDSA(t *testing.T) {
+	_, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idToken := signedLoginJWT(t, jose.EdDSA, joseed25519.PrivateKey(edPriv), "ed")
+
+	// Ed25519 ID tokens hash the access token with SHA-512.
+	sum := sha512.Sum512([]byte("access-token"))
+	atHash := base64.RawURLEncoding.EncodeToString(sum[:32])
+
+	if err := validateAccessTokenHash(idToken, "access-token", map[string]interface{}{"at_hash": atHash}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := validateAccessTokenHash(idToken, "other-token", map[string]interface{}{"at_hash": atHash}); err == nil {
+		t.Fatal("expected at_hash mismatch")
+	}
+}
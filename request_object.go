@@ -0,0 +1,135 @@
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/coreos/go-oidc"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// requestObjectType is the "typ" header of request objects (RFC 9101
+// section 10.8).
+const requestObjectType = "oauth-authz-req+jwt"
+
+// requestObjectPlainParams are the authorization parameters that are kept in
+// the query string of the authorization URL as well as in the request
+// object. RFC 9101 requires client_id, and OpenID Connect requires
+// response_type and a scope containing "openid".
+var requestObjectPlainParams = []string{"client_id", "response_type", "scope"}
+
+// providerSupportsRequestParameter checks whether the provider discovery
+// document advertises request_parameter_supported.
+func providerSupportsRequestParameter(provider *oidc.Provider) bool {
+	var metadata struct {
+		RequestParameterSupported bool `json:"request_parameter_supported"`
+	}
+	if err := provider.Claims(&metadata); err != nil {
+		return false
+	}
+
+	return metadata.RequestParameterSupported
+}
+
+// requestObjectAlgorithm returns the signing algorithm used for request
+// objects signed with key.
+func requestObjectAlgorithm(key crypto.Signer) (jose.SignatureAlgorithm, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return jose.ES256, nil
+		case 384:
+			return jose.ES384, nil
+		case 521:
+			return jose.ES512, nil
+		}
+	}
+
+	return "", errors.New("unsupported request object signing key")
+}
+
+// requestObjectEncryptionKey parses the oidc_jar_encryption_key_pem public
+// key and returns the key management algorithm used with it.
+func requestObjectEncryptionKey(keyPEM string) (interface{}, jose.KeyAlgorithm, error) {
+	key, err := parsePublicKeyPEM([]byte(keyPEM))
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return key, jose.RSA_OAEP_256, nil
+	case *ecdsa.PublicKey:
+		return key, jose.ECDH_ES_A256KW, nil
+	}
+
+	return nil, "", errors.New("unsupported request object encryption key, must be RSA or EC")
+}
+
+// requestObjectAuthURL moves the authorization parameters of authURL into a
+// request object signed with key and passed by value as the "request"
+// parameter (RFC 9101 section 5.1). If encryptionKey is set, the signed
+// request object is nested in a JWE encrypted to it with encryptionAlg
+// (RFC 9101 section 6.1).
+func requestObjectAuthURL(key crypto.Signer, encryptionKey interface{}, encryptionAlg jose.KeyAlgorithm, issuer, authURL string) (string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+
+	alg, err := requestObjectAlgorithm(key)
+	if err != nil {
+		return "", err
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, (&jose.SignerOptions{}).WithType(requestObjectType))
+	if err != nil {
+		return "", err
+	}
+
+	params := u.Query()
+	claims := make(map[string]interface{}, len(params)+2)
+	for name := range params {
+		claims[name] = params.Get(name)
+	}
+	claims["iss"] = params.Get("client_id")
+	claims["aud"] = issuer
+
+	requestObject, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		return "", fmt.Errorf("error signing request object: %v", err)
+	}
+
+	if encryptionKey != nil {
+		encrypter, err := jose.NewEncrypter(jose.A256GCM, jose.Recipient{Algorithm: encryptionAlg, Key: encryptionKey}, (&jose.EncrypterOptions{}).WithContentType("JWT"))
+		if err != nil {
+			return "", err
+		}
+		jwe, err := encrypter.Encrypt([]byte(requestObject))
+		if err != nil {
+			return "", fmt.Errorf("error encrypting request object: %v", err)
+		}
+		if requestObject, err = jwe.CompactSerialize(); err != nil {
+			return "", fmt.Errorf("error encrypting request object: %v", err)
+		}
+	}
+
+	query := url.Values{}
+	for _, name := range requestObjectPlainParams {
+		if value := params.Get(name); value != "" {
+			query.Set(name, value)
+		}
+	}
+	query.Set("request", requestObject)
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
@@ -0,0 +1,299 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestOIDC_RequestParameterObject(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	key, keyPEM := newTestRSAKey(t)
+
+	cert, err := s.getTLSCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeConfig := func(extra map[string]interface{}) *logical.Response {
+		data := map[string]interface{}{
+			"oidc_discovery_url":    s.server.URL,
+			"oidc_client_id":        "abc",
+			"oidc_client_secret":    "def",
+			"oidc_discovery_ca_pem": cert,
+			"default_role":          "test",
+			"bound_issuer":          "http://vault.example.com/",
+			"jwt_supported_algs":    []string{"ES256"},
+		}
+		for k, v := range extra {
+			data[k] = v
+		}
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      configPath,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := writeConfig(map[string]interface{}{
+		"oidc_request_parameter_object": true,
+	})
+	if !resp.IsError() || !strings.Contains(resp.Error().Error(), "oidc_request_object_signing_key") {
+		t.Fatalf("expected missing signing key error, got: %v", resp)
+	}
+
+	resp = writeConfig(map[string]interface{}{
+		"oidc_request_parameter_object":   true,
+		"oidc_request_object_signing_key": keyPEM,
+	})
+	if !resp.IsError() || !strings.Contains(resp.Error().Error(), "request_parameter_supported") {
+		t.Fatalf("expected unsupported provider error, got: %v", resp)
+	}
+
+	s.requestParameterSupported = true
+	resp = writeConfig(map[string]interface{}{
+		"oidc_request_parameter_object":   true,
+		"oidc_request_object_signing_key": keyPEM,
+	})
+	if resp != nil && resp.IsError() {
+		t.Fatalf("unexpected error: %v", resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "oidc/auth_url",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role":         "test",
+			"redirect_uri": "https://example.com",
+		},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+
+	authURL, err := url.Parse(resp.Data["auth_url"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authURL.Query()
+
+	for name, expected := range map[string]string{
+		"client_id":     "abc",
+		"response_type": "code",
+		"scope":         "openid",
+	} {
+		if actual := query.Get(name); actual != expected {
+			t.Fatalf("expected %s %q, got %q", name, expected, actual)
+		}
+	}
+	for _, name := range []string{"state", "nonce", "redirect_uri"} {
+		if _, ok := query[name]; ok {
+			t.Fatalf("expected %s to be passed in the request object only", name)
+		}
+	}
+
+	requestObject, err := jwt.ParseSigned(query.Get("request"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := requestObject.Headers[0].ExtraHeaders["typ"]; typ != requestObjectType {
+		t.Fatalf("expected typ %q, got %v", requestObjectType, typ)
+	}
+
+	var claims map[string]interface{}
+	if err := requestObject.Claims(key.Public(), &claims); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]string{
+		"iss":           "abc",
+		"aud":           s.server.URL,
+		"client_id":     "abc",
+		"response_type": "code",
+		"redirect_uri":  "https://example.com",
+	} {
+		if claims[name] != expected {
+			t.Fatalf("expected request object %s %q, got %v", name, expected, claims[name])
+		}
+	}
+	for _, name := range []string{"state", "nonce"} {
+		if v, _ := claims[name].(string); v == "" {
+			t.Fatalf("expected request object to contain %s", name)
+		}
+	}
+
+	// The signing key is not returned on read.
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      configPath,
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp.Data["oidc_request_object_signing_key"]; ok {
+		t.Fatal("expected signing key to be omitted from the config")
+	}
+	if resp.Data["oidc_request_parameter_object"] != true {
+		t.Fatalf("expected oidc_request_parameter_object to be set, got %v", resp.Data["oidc_request_parameter_object"])
+	}
+}
+
+func TestOIDC_RequestParameterObject_Encrypted(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+	s.requestParameterSupported = true
+
+	signingKey, signingKeyPEM := newTestRSAKey(t)
+
+	cert, err := s.getTLSCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKeyPEM := func(key crypto.PublicKey) string {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	}
+
+	writeConfig := func(extra map[string]interface{}) *logical.Response {
+		data := map[string]interface{}{
+			"oidc_discovery_url":    s.server.URL,
+			"oidc_client_id":        "abc",
+			"oidc_client_secret":    "def",
+			"oidc_discovery_ca_pem": cert,
+			"default_role":          "test",
+			"bound_issuer":          "http://vault.example.com/",
+			"jwt_supported_algs":    []string{"ES256"},
+		}
+		for k, v := range extra {
+			data[k] = v
+		}
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      configPath,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := writeConfig(map[string]interface{}{
+		"oidc_jar_encryption_key_pem": publicKeyPEM(&rsaKey.PublicKey),
+	})
+	if !resp.IsError() || !strings.Contains(resp.Error().Error(), "'oidc_request_parameter_object' must be set") {
+		t.Fatalf("expected missing oidc_request_parameter_object error, got: %v", resp)
+	}
+
+	resp = writeConfig(map[string]interface{}{
+		"oidc_request_parameter_object":   true,
+		"oidc_request_object_signing_key": signingKeyPEM,
+		"oidc_jar_encryption_key_pem":     "not a key",
+	})
+	if !resp.IsError() || !strings.Contains(resp.Error().Error(), "error parsing 'oidc_jar_encryption_key_pem'") {
+		t.Fatalf("expected invalid key error, got: %v", resp)
+	}
+
+	tests := map[string]struct {
+		publicKey  crypto.PublicKey
+		privateKey interface{}
+		alg        jose.KeyAlgorithm
+	}{
+		"RSA": {&rsaKey.PublicKey, rsaKey, jose.RSA_OAEP_256},
+		"EC":  {&ecKey.PublicKey, ecKey, jose.ECDH_ES_A256KW},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			resp := writeConfig(map[string]interface{}{
+				"oidc_request_parameter_object":   true,
+				"oidc_request_object_signing_key": signingKeyPEM,
+				"oidc_jar_encryption_key_pem":     publicKeyPEM(tt.publicKey),
+			})
+			if resp != nil && resp.IsError() {
+				t.Fatalf("unexpected error: %v", resp)
+			}
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "oidc/auth_url",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"role":         "test",
+					"redirect_uri": "https://example.com",
+				},
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("err:%v resp:%#v", err, resp)
+			}
+
+			authURL, err := url.Parse(resp.Data["auth_url"].(string))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			jwe, err := jose.ParseEncrypted(authURL.Query().Get("request"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if jwe.Header.Algorithm != string(tt.alg) {
+				t.Fatalf("expected key algorithm %s, got %s", tt.alg, jwe.Header.Algorithm)
+			}
+			if cty := jwe.Header.ExtraHeaders["cty"]; cty != "JWT" {
+				t.Fatalf("expected cty JWT, got %v", cty)
+			}
+			nested, err := jwe.Decrypt(tt.privateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			requestObject, err := jwt.ParseSigned(string(nested))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var claims map[string]interface{}
+			if err := requestObject.Claims(signingKey.Public(), &claims); err != nil {
+				t.Fatal(err)
+			}
+			if claims["redirect_uri"] != "https://example.com" || claims["client_id"] != "abc" {
+				t.Fatalf("unexpected request object claims: %v", claims)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+)
+
+// defaultRevocationCheckTimeout is used when oidc_revocation_check_timeout is
+// not set on the role.
+const defaultRevocationCheckTimeout = 2 * time.Second
+
+// errTokenRevoked is returned when the revocation service reports a token as
+// revoked.
+var errTokenRevoked = errors.New("token has been revoked")
+
+// checkRevocation queries the role's revocation check URL with the token's jti
+// and sub claims. Successful non-revoked results are cached for the role's
+// oidc_revocation_cache_ttl. Errors other than a revoked token are ignored if
+// the role is configured to fail open.
+func (b *jwtAuthBackend) checkRevocation(ctx context.Context, config *jwtConfig, role *jwtRole, allClaims map[string]interface{}) error {
+	if role.RevocationCheckURL == "" {
+		return nil
+	}
+
+	q := url.Values{}
+	if jti, ok := allClaims["jti"].(string); ok {
+		q.Set("jti", jti)
+	}
+	if sub, ok := allClaims["sub"].(string); ok {
+		q.Set("sub", sub)
+	}
+
+	checkURL, err := url.Parse(role.RevocationCheckURL)
+	if err != nil {
+		return err
+	}
+	params := checkURL.Query()
+	for k, v := range q {
+		params[k] = v
+	}
+	checkURL.RawQuery = params.Encode()
+
+	cacheKey := checkURL.String()
+	if claimsKey, ok := claimsCacheKey(role.RevocationCheckURL, role.CacheKeyFields, allClaims); ok {
+		cacheKey = claimsKey
+	}
+	if _, ok := b.revocationCache.Get(cacheKey); ok {
+		return nil
+	}
+
+	client, err := buildHTTPClient(config)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := queryRevocation(ctx, client, checkURL.String(), role.revocationCheckTimeout())
+	switch {
+	case err != nil && role.RevocationCheckFailOpen:
+		b.Logger().Warn("revocation check failed, allowing login", "url", role.RevocationCheckURL, "error", err)
+		return nil
+	case err != nil:
+		return errwrap.Wrapf("revocation check failed: {{err}}", err)
+	case revoked:
+		return errTokenRevoked
+	}
+
+	if role.RevocationCacheTTL > 0 {
+		b.revocationCache.Set(cacheKey, struct{}{}, role.RevocationCacheTTL)
+	}
+
+	return nil
+}
+
+// queryRevocation performs a single revocation check request.
+func queryRevocation(ctx context.Context, client *http.Client, checkURL string, timeout time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodGet, checkURL, nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Revoked bool `json:"revoked"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+
+	return result.Revoked, nil
+}
@@ -0,0 +1,92 @@
+package jwtauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogin_RevocationCheck(t *testing.T) {
+	var requests int
+	var revoked, fail bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if fail {
+			w.WriteHeader(500)
+			return
+		}
+		if r.URL.Query().Get("sub") != "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients" {
+			t.Errorf("unexpected query: %s", r.URL.RawQuery)
+		}
+		if revoked {
+			w.Write([]byte(`{"revoked": true}`))
+		} else {
+			w.Write([]byte(`{"revoked": false}`))
+		}
+	}))
+	defer srv.Close()
+
+	login := func(roleData map[string]interface{}) string {
+		t.Helper()
+		roleData["oidc_revocation_check_url"] = srv.URL
+		b, storage := setupBackend(t, testConfig{
+			audience: true,
+			roleData: roleData,
+		})
+		req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.IsError() {
+			return resp.Error().Error()
+		}
+
+		// a second login uses the cache if configured
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.IsError() {
+			return resp.Error().Error()
+		}
+		return ""
+	}
+
+	tests := []struct {
+		name     string
+		revoked  bool
+		fail     bool
+		roleData map[string]interface{}
+		errMsg   string
+		requests int
+	}{
+		{"not revoked", false, false, map[string]interface{}{}, "", 2},
+		{"cached", false, false, map[string]interface{}{"oidc_revocation_cache_ttl": "1m"}, "", 1},
+		{"cached by claims", false, false, map[string]interface{}{"oidc_revocation_cache_ttl": "1m", "oidc_cache_key_fields": "sub,iss"}, "", 1},
+		{"revoked", true, false, map[string]interface{}{}, "token has been revoked", 1},
+		{"fail closed", false, true, map[string]interface{}{}, "revocation check failed", 1},
+		{"fail open", false, true, map[string]interface{}{"oidc_revocation_check_fail_open": true}, "", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests, revoked, fail = 0, tt.revoked, tt.fail
+
+			errMsg := login(tt.roleData)
+			if tt.errMsg == "" && errMsg != "" {
+				t.Fatalf("unexpected error: %s", errMsg)
+			}
+			if !strings.Contains(errMsg, tt.errMsg) {
+				t.Fatalf("expected error %q, got %q", tt.errMsg, errMsg)
+			}
+			if requests != tt.requests {
+				t.Fatalf("expected %d revocation requests, got %d", tt.requests, requests)
+			}
+		})
+	}
+}
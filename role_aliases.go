@@ -0,0 +1,91 @@
+package jwtauth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// roleAliasPrefix is the storage prefix mapping a role alias to the name of
+// the role it refers to.
+const roleAliasPrefix = "role_alias/"
+
+type roleAlias struct {
+	Role string `json:"role"`
+}
+
+// canonicalRoleName returns the name of the role that name is an alias of, or
+// name itself if it isn't an alias.
+func (b *jwtAuthBackend) canonicalRoleName(ctx context.Context, s logical.Storage, name string) (string, error) {
+	entry, err := s.Get(ctx, roleAliasPrefix+name)
+	if err != nil || entry == nil {
+		return name, err
+	}
+
+	var alias roleAlias
+	if err := entry.DecodeJSON(&alias); err != nil {
+		return "", err
+	}
+
+	return alias.Role, nil
+}
+
+// validateRoleAliases checks that aliases don't collide with other roles or
+// their aliases.
+func (b *jwtAuthBackend) validateRoleAliases(ctx context.Context, s logical.Storage, roleName string, aliases []string) error {
+	if canonical, err := b.canonicalRoleName(ctx, s, roleName); err != nil {
+		return err
+	} else if canonical != roleName {
+		return fmt.Errorf("%q is an alias of role %q", roleName, canonical)
+	}
+
+	for _, alias := range aliases {
+		if alias == roleName {
+			return fmt.Errorf("role alias %q is the name of the role", alias)
+		}
+
+		role, err := b.role(ctx, s, alias)
+		if err != nil {
+			return err
+		}
+		if role != nil {
+			return fmt.Errorf("role alias %q is the name of another role", alias)
+		}
+
+		canonical, err := b.canonicalRoleName(ctx, s, alias)
+		if err != nil {
+			return err
+		}
+		if canonical != alias && canonical != roleName {
+			return fmt.Errorf("role alias %q is already an alias of role %q", alias, canonical)
+		}
+	}
+
+	return nil
+}
+
+// updateRoleAliases stores the aliases of roleName, removing the previous
+// aliases that are no longer used.
+func (b *jwtAuthBackend) updateRoleAliases(ctx context.Context, s logical.Storage, roleName string, previous, aliases []string) error {
+	for _, alias := range previous {
+		if !strutil.StrListContains(aliases, alias) {
+			if err := s.Delete(ctx, roleAliasPrefix+alias); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, alias := range aliases {
+		entry, err := logical.StorageEntryJSON(roleAliasPrefix+alias, roleAlias{Role: roleName})
+		if err != nil {
+			return err
+		}
+		if err := s.Put(ctx, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package jwtauth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestRoleAliases(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		roleData: map[string]interface{}{
+			"role_aliases": "old-name,Older-Name",
+		},
+	})
+	ctx := context.Background()
+
+	writeRole := func(name string, data map[string]interface{}) *logical.Response {
+		data["role_type"] = "jwt"
+		data["bound_subject"] = "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients"
+		data["user_claim"] = "https://vault/user"
+		op := logical.CreateOperation
+		if name == "plugin-test" {
+			op = logical.UpdateOperation
+		}
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      "role/" + name,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Logins to an alias are logins to the canonical role.
+	req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+	req.Data["role"] = "older-name"
+	resp, err := b.HandleRequest(ctx, req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%v", err, resp)
+	}
+	if resp.Auth.Metadata["role"] != "plugin-test" || resp.Auth.InternalData["role"] != "plugin-test" {
+		t.Fatalf("expected canonical role name, got metadata: %v", resp.Auth.Metadata)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "role/",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%v", err, resp)
+	}
+	info := resp.Data["key_info"].(map[string]interface{})["plugin-test"].(map[string]interface{})
+	if aliases := info["role_aliases"].([]string); len(aliases) != 2 || aliases[0] != "old-name" || aliases[1] != "older-name" {
+		t.Fatalf("unexpected role aliases: %v", aliases)
+	}
+
+	// Aliases can't collide with other roles or their aliases.
+	for name, data := range map[string]map[string]interface{}{
+		"old-name": {},
+		"other":    {"role_aliases": "old-name"},
+		"another":  {"role_aliases": "plugin-test"},
+	} {
+		if resp := writeRole(name, data); resp == nil || !resp.IsError() {
+			t.Fatalf("expected error writing role %q, got: %v", name, resp)
+		}
+	}
+
+	// Aliases removed from the role are released.
+	if resp := writeRole("plugin-test", map[string]interface{}{"role_aliases": "old-name"}); resp != nil && resp.IsError() {
+		t.Fatalf("unexpected error: %v", resp)
+	}
+	if resp := writeRole("older-name", map[string]interface{}{}); resp != nil && resp.IsError() {
+		t.Fatalf("unexpected error: %v", resp)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "role/plugin-test",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%v", err, resp)
+	}
+
+	keys, err := storage.List(ctx, roleAliasPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("expected aliases to be deleted with the role, got: %v", keys)
+	}
+
+	req.Data["role"] = "old-name"
+	resp, err = b.HandleRequest(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error logging in with a deleted alias, got: %v", resp)
+	}
+}
@@ -0,0 +1,254 @@
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// defaultMaxValidationKeyVersions is used when max_validation_key_versions
+// is not set on the role.
+const defaultMaxValidationKeyVersions = 2
+
+// validationKeyVersion is a version of a role's validation key.
+type validationKeyVersion struct {
+	Version int       `json:"version"`
+	Key     string    `json:"key"`
+	Created time.Time `json:"created"`
+
+	// Retired is when the version was replaced by a newer one, or zero for
+	// the current version.
+	Retired time.Time `json:"retired"`
+}
+
+// maxValidationKeyVersions returns the number of previous validation key
+// versions kept on rotation.
+func (r *jwtRole) maxValidationKeyVersions() int {
+	if r.MaxValidationKeyVersions <= 0 {
+		return defaultMaxValidationKeyVersions
+	}
+	return r.MaxValidationKeyVersions
+}
+
+func pathRoleValidationKeys(b *jwtAuthBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "role/" + framework.GenericNameRegex("name") + "/rotate-validation-key",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the role.",
+				},
+				"public_key": {
+					Type:        framework.TypeString,
+					Description: "The PEM-encoded public key that becomes the role's current validation key.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathRoleRotateValidationKey,
+					Summary:  "Replace the role's validation key, keeping the previous versions.",
+				},
+			},
+
+			HelpSynopsis:    rotateValidationKeyHelpSyn,
+			HelpDescription: rotateValidationKeyHelpDesc,
+		},
+		{
+			Pattern: "role/" + framework.GenericNameRegex("name") + "/trim-validation-keys",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the role.",
+				},
+				"max_age": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Previous key versions replaced longer ago than this are removed.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathRoleTrimValidationKeys,
+					Summary:  "Remove old versions of the role's validation key.",
+				},
+			},
+
+			HelpSynopsis:    trimValidationKeysHelpSyn,
+			HelpDescription: trimValidationKeysHelpDesc,
+		},
+	}
+}
+
+func (b *jwtAuthBackend) pathRoleRotateValidationKey(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	roleName := d.Get("name").(string)
+
+	publicKey := d.Get("public_key").(string)
+	if publicKey == "" {
+		return logical.ErrorResponse("missing public_key"), nil
+	}
+	if _, err := parsePublicKeyPEM([]byte(publicKey)); err != nil {
+		return logical.ErrorResponse(errwrap.Wrapf("error parsing public key: {{err}}", err).Error()), nil
+	}
+
+	b.roleLock.Lock()
+	defer b.roleLock.Unlock()
+
+	role, err := b.role(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("role %q could not be found", roleName), nil
+	}
+
+	now := time.Now()
+	version := 1
+	if len(role.ValidationKeys) > 0 {
+		version = role.ValidationKeys[0].Version + 1
+		role.ValidationKeys[0].Retired = now
+	}
+
+	role.ValidationKeys = append([]validationKeyVersion{{
+		Version: version,
+		Key:     publicKey,
+		Created: now,
+	}}, role.ValidationKeys...)
+	if max := role.maxValidationKeyVersions() + 1; len(role.ValidationKeys) > max {
+		role.ValidationKeys = role.ValidationKeys[:max]
+	}
+
+	if err := b.putRole(ctx, req.Storage, roleName, role); err != nil {
+		return nil, err
+	}
+
+	return validationKeysResponse(role), nil
+}
+
+func (b *jwtAuthBackend) pathRoleTrimValidationKeys(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	roleName := d.Get("name").(string)
+
+	maxAgeRaw, ok := d.GetOk("max_age")
+	if !ok {
+		return logical.ErrorResponse("missing max_age"), nil
+	}
+	maxAge := time.Duration(maxAgeRaw.(int)) * time.Second
+
+	b.roleLock.Lock()
+	defer b.roleLock.Unlock()
+
+	role, err := b.role(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("role %q could not be found", roleName), nil
+	}
+
+	// The current version is always kept.
+	oldest := time.Now().Add(-maxAge)
+	for i, version := range role.ValidationKeys {
+		if i > 0 && !version.Retired.After(oldest) {
+			role.ValidationKeys = role.ValidationKeys[:i]
+			break
+		}
+	}
+
+	if err := b.putRole(ctx, req.Storage, roleName, role); err != nil {
+		return nil, err
+	}
+
+	return validationKeysResponse(role), nil
+}
+
+// putRole stores role as roleName.
+func (b *jwtAuthBackend) putRole(ctx context.Context, s logical.Storage, roleName string, role *jwtRole) error {
+	entry, err := logical.StorageEntryJSON(rolePrefix+roleName, role)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// validationKeysResponse lists the versions of the role's validation key,
+// newest first.
+func validationKeysResponse(role *jwtRole) *logical.Response {
+	versions := make([]map[string]interface{}, 0, len(role.ValidationKeys))
+	for _, version := range role.ValidationKeys {
+		v := map[string]interface{}{
+			"version":    version.Version,
+			"public_key": version.Key,
+			"created":    version.Created.Format(time.RFC3339),
+		}
+		if !version.Retired.IsZero() {
+			v["retired"] = version.Retired.Format(time.RFC3339)
+		}
+		versions = append(versions, v)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"current_version": role.ValidationKeys[0].Version,
+			"versions":        versions,
+		},
+	}
+}
+
+// verifyWithRoleValidationKeys verifies the signature of token with the
+// role's validation keys, from the current version to the oldest, and
+// returns its claims.
+func verifyWithRoleValidationKeys(role *jwtRole, token string, claims *jwt.Claims, allClaims *map[string]interface{}) error {
+	parsedJWT, err := jwt.ParseSigned(token)
+	if err != nil {
+		return errwrap.Wrapf("error parsing token: {{err}}", err)
+	}
+
+	if len(parsedJWT.Headers) == 0 {
+		return errors.New("error parsing token: token has no header")
+	}
+
+	keys := make([]interface{}, len(role.ValidationKeys))
+	for i, version := range role.ValidationKeys {
+		if keys[i], err = parsePublicKeyPEM([]byte(version.Key)); err != nil {
+			return errwrap.Wrapf("error parsing validation key: {{err}}", err)
+		}
+	}
+
+	if err := checkAlgorithmRestrictions(role, parsedJWT.Headers[0].Algorithm, keys); err != nil {
+		return errwrap.Wrapf("error verifying token: {{err}}", err)
+	}
+
+	for _, key := range keys {
+		if err := parsedJWT.Claims(key, claims, allClaims); err == nil {
+			return nil
+		}
+	}
+
+	return errors.New("no validation key of the role successfully validated the token signature")
+}
+
+const (
+	rotateValidationKeyHelpSyn = `
+Replaces the validation key of a role.
+`
+	rotateValidationKeyHelpDesc = `
+Tokens for a role with validation keys are verified with the role's keys
+instead of the mount's keys. The given public key becomes the current
+version, and the replaced versions are kept, up to the role's
+max_validation_key_versions, so that tokens signed before the rotation
+remain valid. Verification tries the current version first, then the
+previous versions from newest to oldest.
+`
+	trimValidationKeysHelpSyn = `
+Removes old versions of the validation key of a role.
+`
+	trimValidationKeysHelpDesc = `
+Removes the previous versions of the role's validation key that were
+replaced longer than max_age ago. The current version is never removed.
+`
+)
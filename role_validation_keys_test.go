@@ -0,0 +1,196 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// newTestECKey returns a new P-256 private key and the public key, both
+// PEM-encoded.
+func newTestECKey(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+}
+
+func TestLogin_RoleValidationKeyRotation(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+	})
+
+	login := func(privKey string) *logical.Response {
+		t.Helper()
+		cl := jwt.Claims{
+			Audience:  jwt.Audience{"https://vault.plugin.auth.jwt.test"},
+			Issuer:    "https://team-vault.auth0.com/",
+			Subject:   "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients",
+			NotBefore: jwt.NewNumericDate(time.Now().Add(-5 * time.Second)),
+			Expiry:    jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}
+		privateCl := map[string]interface{}{
+			"https://vault/user":   "jeff",
+			"https://vault/groups": []string{"foo"},
+		}
+		token, _ := getTestJWT(t, privKey, cl, privateCl)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role": "plugin-test",
+				"jwt":  token,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		return resp
+	}
+
+	rotate := func(pubKey string) *logical.Response {
+		t.Helper()
+		return request("role/plugin-test/rotate-validation-key", map[string]interface{}{
+			"public_key": pubKey,
+		})
+	}
+
+	// Without role validation keys, the mount's keys are used.
+	if resp := login(ecdsaPrivKey); resp.IsError() {
+		t.Fatalf("unexpected error: %v", resp.Error())
+	}
+
+	priv1, pub1 := newTestECKey(t)
+	priv2, pub2 := newTestECKey(t)
+	priv3, pub3 := newTestECKey(t)
+	priv4, pub4 := newTestECKey(t)
+
+	rotate(pub1)
+	if resp := login(ecdsaPrivKey); !resp.IsError() {
+		t.Fatal("expected the role's validation key to replace the mount's keys")
+	}
+	if resp := login(priv1); resp.IsError() {
+		t.Fatalf("unexpected error: %v", resp.Error())
+	}
+
+	// A token signed with the previous key is accepted after rotation.
+	rotate(pub2)
+	for _, priv := range []string{priv2, priv1} {
+		if resp := login(priv); resp.IsError() {
+			t.Fatalf("unexpected error: %v", resp.Error())
+		}
+	}
+
+	// Only two previous versions are kept by default.
+	rotate(pub3)
+	resp := rotate(pub4)
+	if resp.Data["current_version"] != 4 {
+		t.Fatalf("expected current version 4, got %v", resp.Data["current_version"])
+	}
+	if versions := resp.Data["versions"].([]map[string]interface{}); len(versions) != 3 {
+		t.Fatalf("expected 3 versions, got %d", len(versions))
+	}
+	for _, priv := range []string{priv4, priv3, priv2} {
+		if resp := login(priv); resp.IsError() {
+			t.Fatalf("unexpected error: %v", resp.Error())
+		}
+	}
+	resp = login(priv1)
+	if !resp.IsError() || !strings.Contains(resp.Error().Error(), "no validation key of the role") {
+		t.Fatalf("expected token signed with a removed key to be rejected, got: %v", resp)
+	}
+
+	// Recently retired versions are kept by a trim.
+	resp = request("role/plugin-test/trim-validation-keys", map[string]interface{}{
+		"max_age": "1h",
+	})
+	if versions := resp.Data["versions"].([]map[string]interface{}); len(versions) != 3 {
+		t.Fatalf("expected 3 versions, got %d", len(versions))
+	}
+
+	resp = request("role/plugin-test/trim-validation-keys", map[string]interface{}{
+		"max_age": 0,
+	})
+	if versions := resp.Data["versions"].([]map[string]interface{}); len(versions) != 1 {
+		t.Fatalf("expected only the current version, got %d", len(versions))
+	}
+	if resp := login(priv4); resp.IsError() {
+		t.Fatalf("unexpected error: %v", resp.Error())
+	}
+	if resp := login(priv3); !resp.IsError() {
+		t.Fatal("expected token signed with a trimmed key to be rejected")
+	}
+}
+
+func TestPath_RotateValidationKey_Invalid(t *testing.T) {
+	b, storage := getBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/missing/rotate-validation-key",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"public_key": "not a key",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() || !strings.Contains(resp.Error().Error(), "error parsing public key") {
+		t.Fatalf("expected parse error, got: %v", resp)
+	}
+
+	_, pub := newTestECKey(t)
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/missing/rotate-validation-key",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"public_key": pub,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() || !strings.Contains(resp.Error().Error(), "could not be found") {
+		t.Fatalf("expected missing role error, got: %v", resp)
+	}
+}
@@ -0,0 +1,131 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// tokenIPsPrefix is the storage prefix of the first source address seen
+	// for each token fingerprint.
+	tokenIPsPrefix = "token_ips/"
+
+	// defaultTokenIPTTL is how long a fingerprint is kept for tokens without
+	// an exp claim.
+	defaultTokenIPTTL = 24 * time.Hour
+)
+
+// errTokenIPMismatch is returned for a token used from a different address
+// than the one it was first used from, if the role enforces the binding.
+var errTokenIPMismatch = errors.New("token was first used from a different address")
+
+// tokenIPEntry records the first use of a token.
+type tokenIPEntry struct {
+	IP        string    `json:"ip"`
+	FirstSeen time.Time `json:"first_seen"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+// tokenFingerprint returns a hash of the header and claims of a compact JWS.
+// The signature is excluded so that the fingerprint does not depend on its
+// encoding.
+func tokenFingerprint(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) > 2 {
+		parts = parts[:2]
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, ".")))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkTokenIP records the source address of the first use of token and
+// compares it on later uses. A mismatch is logged, or rejected if the role
+// sets oidc_strict_ip_binding.
+func (b *jwtAuthBackend) checkTokenIP(ctx context.Context, req *logical.Request, role *jwtRole, token string, allClaims map[string]interface{}) error {
+	if !role.TrackTokenIPs {
+		return nil
+	}
+
+	if req.Connection == nil || req.Connection.RemoteAddr == "" {
+		b.Logger().Warn("token IP tracking enabled but no connection information available")
+		return nil
+	}
+	remoteAddr := req.Connection.RemoteAddr
+
+	path := tokenIPsPrefix + tokenFingerprint(token)
+	entry, err := req.Storage.Get(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	if entry != nil {
+		var seen tokenIPEntry
+		if err := entry.DecodeJSON(&seen); err != nil {
+			return err
+		}
+		if seen.IP == remoteAddr {
+			return nil
+		}
+		if role.StrictIPBinding {
+			b.Logger().Error("rejected token reused from a different address", "first_ip", seen.IP, "ip", remoteAddr, "first_seen", seen.FirstSeen)
+			return errTokenIPMismatch
+		}
+		b.Logger().Error("token reused from a different address", "first_ip", seen.IP, "ip", remoteAddr, "first_seen", seen.FirstSeen)
+		return nil
+	}
+
+	now := time.Now()
+	seen := tokenIPEntry{
+		IP:        remoteAddr,
+		FirstSeen: now,
+		Expiry:    now.Add(defaultTokenIPTTL),
+	}
+	if exp, ok := allClaims["exp"].(float64); ok {
+		seen.Expiry = time.Unix(int64(exp), 0)
+	}
+
+	entry, err = logical.StorageEntryJSON(path, seen)
+	if err != nil {
+		return err
+	}
+
+	return req.Storage.Put(ctx, entry)
+}
+
+// tidyTokenIPs removes the records of expired tokens. It is run from the
+// backend's periodic function.
+func (b *jwtAuthBackend) tidyTokenIPs(ctx context.Context, s logical.Storage) error {
+	keys, err := s.List(ctx, tokenIPsPrefix)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		entry, err := s.Get(ctx, tokenIPsPrefix+key)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+
+		var seen tokenIPEntry
+		if err := entry.DecodeJSON(&seen); err != nil {
+			return err
+		}
+		if now.After(seen.Expiry) {
+			if err := s.Delete(ctx, tokenIPsPrefix+key); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package jwtauth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestLogin_TrackTokenIPs(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		b, storage := setupBackend(t, testConfig{
+			audience: true,
+			roleData: map[string]interface{}{
+				"oidc_track_token_ips":   true,
+				"oidc_strict_ip_binding": strict,
+			},
+		})
+		req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+
+		for _, addr := range []string{"127.0.0.1", "127.0.0.1", "10.0.0.1"} {
+			req.Connection.RemoteAddr = addr
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if strict && addr == "10.0.0.1" {
+				if resp == nil || !resp.IsError() {
+					t.Fatalf("expected token reuse from a different address to be rejected, got: %v", resp)
+				}
+				continue
+			}
+			if resp == nil || resp.IsError() {
+				t.Fatalf("expected successful login from %s, got: %v", addr, resp)
+			}
+		}
+
+		keys, err := storage.List(context.Background(), tokenIPsPrefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 1 {
+			t.Fatalf("expected 1 recorded token, got: %v", keys)
+		}
+	}
+}
+
+func TestTidyTokenIPs(t *testing.T) {
+	b, storage := getBackend(t)
+	ctx := context.Background()
+
+	for key, expiry := range map[string]time.Time{
+		"expired": time.Now().Add(-time.Minute),
+		"valid":   time.Now().Add(time.Hour),
+	} {
+		entry, err := logical.StorageEntryJSON(tokenIPsPrefix+key, tokenIPEntry{IP: "127.0.0.1", Expiry: expiry})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Put(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := b.(*jwtAuthBackend).tidyTokenIPs(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := storage.List(ctx, tokenIPsPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "valid" {
+		t.Fatalf("expected only the valid entry to remain, got: %v", keys)
+	}
+}
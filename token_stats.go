@@ -0,0 +1,158 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// tokenClassificationMetadata is the token metadata key holding the
+// classification of issued tokens.
+const tokenClassificationMetadata = "token_classification"
+
+// tokenStatsWindow is how long token issuance is counted for.
+const tokenStatsWindow = 7 * 24 * time.Hour
+
+// tokenClassification is added, JSON encoded, to the metadata of all issued
+// tokens for capacity planning.
+type tokenClassification struct {
+	AuthMethod   string `json:"auth_method"`
+	Role         string `json:"role"`
+	Provider     string `json:"provider"`
+	LoginTimeUTC string `json:"login_time_utc"`
+}
+
+// classifyToken returns the token_classification metadata value for a login
+// to role at now. The provider is the host of the token's issuer.
+func classifyToken(role *jwtRole, roleName string, allClaims map[string]interface{}, now time.Time) (string, error) {
+	var provider string
+	if iss, ok := allClaims["iss"].(string); ok {
+		if u, err := url.Parse(iss); err == nil && u.Host != "" {
+			provider = u.Host
+		} else {
+			provider = iss
+		}
+	}
+
+	classification, err := json.Marshal(tokenClassification{
+		AuthMethod:   role.RoleType,
+		Role:         roleName,
+		Provider:     provider,
+		LoginTimeUTC: now.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(classification), nil
+}
+
+// tokenStats counts issued tokens by role and minute over the last week.
+type tokenStats struct {
+	l      sync.Mutex
+	byRole map[string]map[int64]uint64
+}
+
+func newTokenStats() *tokenStats {
+	return &tokenStats{
+		byRole: make(map[string]map[int64]uint64),
+	}
+}
+
+// record counts a token issued for role at t.
+func (s *tokenStats) record(role string, t time.Time) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	minutes, ok := s.byRole[role]
+	if !ok {
+		minutes = make(map[int64]uint64)
+		s.byRole[role] = minutes
+	}
+	minutes[t.Unix()/60]++
+
+	oldest := t.Add(-tokenStatsWindow).Unix() / 60
+	for minute := range minutes {
+		if minute <= oldest {
+			delete(minutes, minute)
+		}
+	}
+}
+
+// deleteRole removes the counts of a deleted role.
+func (s *tokenStats) deleteRole(role string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	delete(s.byRole, role)
+}
+
+// counts returns the number of tokens issued by role in the last hour, day
+// and week before now.
+func (s *tokenStats) counts(now time.Time) map[string]interface{} {
+	windows := map[string]time.Duration{
+		"last_hour": time.Hour,
+		"last_day":  24 * time.Hour,
+		"last_week": tokenStatsWindow,
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	result := make(map[string]interface{}, len(s.byRole))
+	for role, minutes := range s.byRole {
+		roleCounts := make(map[string]uint64, len(windows))
+		for name, window := range windows {
+			oldest := now.Add(-window).Unix() / 60
+			var count uint64
+			for minute, n := range minutes {
+				if minute > oldest {
+					count += n
+				}
+			}
+			roleCounts[name] = count
+		}
+		result[role] = roleCounts
+	}
+
+	return result
+}
+
+func pathOIDCStats(b *jwtAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: `oidc/stats`,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathStatsRead,
+				Summary:  "Read token issuance counts by role.",
+			},
+		},
+
+		HelpSynopsis:    statsHelpSyn,
+		HelpDescription: statsHelpDesc,
+	}
+}
+
+func (b *jwtAuthBackend) pathStatsRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"roles": b.tokenStats.counts(time.Now()),
+		},
+	}, nil
+}
+
+const (
+	statsHelpSyn = `
+Returns the number of tokens issued by role.
+`
+	statsHelpDesc = `
+Returns, for each role, the number of tokens issued in the last hour, day and
+week. Counts are kept in memory on the node that issued the tokens and are
+reset when the plugin is reloaded.
+`
+)
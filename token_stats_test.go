@@ -0,0 +1,78 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestLogin_TokenClassification(t *testing.T) {
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+	})
+	req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+
+	for i := 0; i < 2; i++ {
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%v resp:%v", err, resp)
+		}
+
+		var classification tokenClassification
+		if err := json.Unmarshal([]byte(resp.Auth.Metadata[tokenClassificationMetadata]), &classification); err != nil {
+			t.Fatal(err)
+		}
+		if classification.AuthMethod != "jwt" || classification.Role != "plugin-test" || classification.Provider != "team-vault.auth0.com" {
+			t.Fatalf("unexpected token classification: %v", classification)
+		}
+		if _, err := time.Parse(time.RFC3339, classification.LoginTimeUTC); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "oidc/stats",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%v", err, resp)
+	}
+
+	counts := resp.Data["roles"].(map[string]interface{})["plugin-test"].(map[string]uint64)
+	if counts["last_hour"] != 2 || counts["last_week"] != 2 {
+		t.Fatalf("unexpected counts: %v", counts)
+	}
+}
+
+func TestTokenStats_Windows(t *testing.T) {
+	s := newTokenStats()
+	now := time.Now()
+
+	s.record("a", now.Add(-10*time.Minute))
+	s.record("a", now.Add(-2*time.Hour))
+	s.record("a", now.Add(-3*24*time.Hour))
+	s.record("b", now)
+
+	counts := s.counts(now)
+	expected := map[string]uint64{"last_hour": 1, "last_day": 2, "last_week": 3}
+	for window, n := range expected {
+		if got := counts["a"].(map[string]uint64)[window]; got != n {
+			t.Fatalf("expected %d tokens in %s, got %d", n, window, got)
+		}
+	}
+
+	// Counts older than a week are dropped.
+	s.record("a", now.Add(5*24*time.Hour))
+	if got := s.counts(now.Add(5 * 24 * time.Hour))["a"].(map[string]uint64)["last_week"]; got != 3 {
+		t.Fatalf("expected 3 tokens in the last week, got %d", got)
+	}
+
+	s.deleteRole("b")
+	if _, ok := s.counts(now)["b"]; ok {
+		t.Fatal("expected counts of deleted role to be removed")
+	}
+}
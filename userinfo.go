@@ -0,0 +1,93 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"github.com/hashicorp/errwrap"
+	"golang.org/x/oauth2"
+)
+
+// fetchUserinfoClaims fetches the claims of the user of tokenSource from the
+// role's userinfo_url or, if it is not set, the userinfo endpoint of the
+// provider's discovery document.
+func fetchUserinfoClaims(oidcCtx context.Context, provider *oidc.Provider, role *jwtRole, tokenSource oauth2.TokenSource) (map[string]interface{}, error) {
+	claims := make(map[string]interface{})
+
+	if role.UserinfoURL == "" {
+		userinfo, err := provider.UserInfo(oidcCtx, tokenSource)
+		if err != nil {
+			return nil, err
+		}
+		if err := userinfo.Claims(&claims); err != nil {
+			return nil, errwrap.Wrapf("error decoding userinfo: {{err}}", err)
+		}
+		return claims, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, role.UserinfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The client of oidcCtx, which trusts the discovery CA, is used with
+	// the access token.
+	resp, err := oauth2.NewClient(oidcCtx, tokenSource).Do(req.WithContext(oidcCtx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, body)
+	}
+
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, errwrap.Wrapf("error decoding userinfo: {{err}}", err)
+	}
+	return claims, nil
+}
+
+// mergeUserinfoClaims adds userinfo to allClaims. Claims already in allClaims
+// are only replaced if override is set. The userinfo must be about the
+// subject of the ID token.
+func mergeUserinfoClaims(allClaims, userinfo map[string]interface{}, override bool) error {
+	if sub, ok := userinfo["sub"]; ok && allClaims["sub"] != nil && sub != allClaims["sub"] {
+		return errors.New("userinfo sub does not match the ID token")
+	}
+
+	for k, v := range userinfo {
+		if _, ok := allClaims[k]; !ok || override {
+			allClaims[k] = v
+		}
+	}
+	return nil
+}
+
+// mergeRoleUserinfo merges the userinfo into allClaims for a role with
+// fetch_groups_from_userinfo. Unlike the best-effort merge of other roles,
+// failing to fetch the userinfo is an error.
+func (b *jwtAuthBackend) mergeRoleUserinfo(oidcCtx context.Context, provider *oidc.Provider, role *jwtRole, roleName string, tokenSource oauth2.TokenSource, allClaims map[string]interface{}) error {
+	if tokenSource == nil {
+		return errors.New("no access token was received to fetch the userinfo with")
+	}
+
+	start := time.Now()
+	userinfo, err := fetchUserinfoClaims(oidcCtx, provider, role, tokenSource)
+	b.providerMetrics.observe(roleName, start)
+	if err != nil {
+		return errwrap.Wrapf("error fetching userinfo: {{err}}", err)
+	}
+
+	return mergeUserinfoClaims(allClaims, userinfo, role.UserinfoClaimsOverride)
+}
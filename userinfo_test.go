@@ -0,0 +1,211 @@
+package jwtauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// userinfoLogin completes an OIDC login for the "test" role, with the ID
+// token carrying sampleClaims and extraClaims.
+func userinfoLogin(t *testing.T, b logical.Backend, storage logical.Storage, s *oidcProvider, extraClaims map[string]interface{}) *logical.Response {
+	t.Helper()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "oidc/auth_url",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role":         "test",
+			"redirect_uri": "https://example.com",
+		},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+
+	authURL := resp.Data["auth_url"].(string)
+	s.customClaims = sampleClaims(getQueryParam(t, authURL, "nonce"))
+	for k, v := range extraClaims {
+		s.customClaims[k] = v
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "oidc/callback",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"state": getQueryParam(t, authURL, "state"),
+			"code":  "abc",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func updateUserinfoRole(t *testing.T, b logical.Backend, storage logical.Storage, data map[string]interface{}) {
+	t.Helper()
+
+	data["role_type"] = "oidc"
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/test",
+		Storage:   storage,
+		Data:      data,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v\n", err, resp)
+	}
+}
+
+func TestOIDC_FetchGroupsFromUserinfo(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	s.code = "abc"
+
+	// The userinfo of the mock provider has the groups Everyone and
+	// okta-admins, and the color red.
+	updateUserinfoRole(t, b, storage, map[string]interface{}{
+		"fetch_groups_from_userinfo": true,
+		"groups_claim":               "groups",
+		"claim_mappings":             map[string]string{"color": "color"},
+		"claim_policies_map": map[string]interface{}{
+			"groups": map[string]interface{}{"okta-admins": "admin-policy"},
+		},
+	})
+	idTokenClaims := map[string]interface{}{"color": "blue"}
+
+	resp := userinfoLogin(t, b, storage, s, idTokenClaims)
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+
+	var groups []string
+	for _, alias := range resp.Auth.GroupAliases {
+		groups = append(groups, alias.Name)
+	}
+	if diff := deep.Equal(groups, []string{"Everyone", "okta-admins"}); diff != nil {
+		t.Fatal(diff)
+	}
+	if !strings.Contains(strings.Join(resp.Auth.Policies, ","), "admin-policy") {
+		t.Fatalf("expected policies derived from userinfo groups, got: %v", resp.Auth.Policies)
+	}
+	// ID token claims are kept by default.
+	if color := resp.Auth.Metadata["color"]; color != "blue" {
+		t.Fatalf("expected the ID token color, got %q", color)
+	}
+
+	updateUserinfoRole(t, b, storage, map[string]interface{}{
+		"userinfo_claims_override": true,
+	})
+	resp = userinfoLogin(t, b, storage, s, idTokenClaims)
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+	if color := resp.Auth.Metadata["color"]; color != "red" {
+		t.Fatalf("expected the userinfo color, got %q", color)
+	}
+}
+
+func TestOIDC_FetchGroupsFromUserinfo_URL(t *testing.T) {
+	b, storage, s := getBackendAndServer(t, false)
+	defer s.server.Close()
+
+	s.code = "abc"
+
+	status := http.StatusOK
+	var authorization string
+	userinfo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(`{"groups": ["custom-endpoint"], "temperature": "76"}`))
+		} else {
+			w.Write([]byte(`{"error": "server_error"}`))
+		}
+	}))
+	defer userinfo.Close()
+
+	updateUserinfoRole(t, b, storage, map[string]interface{}{
+		"fetch_groups_from_userinfo": true,
+		"userinfo_url":               userinfo.URL,
+		"groups_claim":               "groups",
+	})
+
+	resp := userinfoLogin(t, b, storage, s, nil)
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+	if len(resp.Auth.GroupAliases) != 1 || resp.Auth.GroupAliases[0].Name != "custom-endpoint" {
+		t.Fatalf("unexpected group aliases: %v", resp.Auth.GroupAliases)
+	}
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		t.Fatalf("expected the access token to be sent, got %q", authorization)
+	}
+
+	// A provider error fails the login.
+	status = http.StatusInternalServerError
+	resp = userinfoLogin(t, b, storage, s, nil)
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "error fetching userinfo: 500 Internal Server Error") {
+		t.Fatalf("expected userinfo error, got: %v", resp)
+	}
+}
+
+func TestMergeUserinfoClaims(t *testing.T) {
+	allClaims := map[string]interface{}{"sub": "alice", "color": "blue"}
+	userinfo := map[string]interface{}{"sub": "alice", "color": "red", "groups": []interface{}{"a"}}
+
+	if err := mergeUserinfoClaims(allClaims, userinfo, false); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"sub": "alice", "color": "blue", "groups": []interface{}{"a"}}
+	if diff := deep.Equal(allClaims, expected); diff != nil {
+		t.Fatal(diff)
+	}
+
+	if err := mergeUserinfoClaims(allClaims, userinfo, true); err != nil {
+		t.Fatal(err)
+	}
+	if allClaims["color"] != "red" {
+		t.Fatalf("expected the userinfo color, got %v", allClaims["color"])
+	}
+
+	err := mergeUserinfoClaims(allClaims, map[string]interface{}{"sub": "mallory"}, true)
+	if err == nil || !strings.Contains(err.Error(), "sub does not match") {
+		t.Fatalf("expected sub mismatch error, got: %v", err)
+	}
+}
+
+func TestRole_FetchGroupsFromUserinfo_Invalid(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for _, data := range []map[string]interface{}{
+		{"role_type": "jwt", "fetch_groups_from_userinfo": true},
+		{"role_type": "oidc", "userinfo_url": "https://example.com/userinfo"},
+		{"role_type": "oidc", "fetch_groups_from_userinfo": true, "userinfo_url": "not a url"},
+	} {
+		data["user_claim"] = "user"
+		data["bound_subject"] = "testsub"
+		data["allowed_redirect_uris"] = "https://example.com"
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/test",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || !resp.IsError() {
+			t.Fatalf("expected error for %v, got: %v", data, resp)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package jwtauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// webhookTimeout bounds a single login webhook request.
+	webhookTimeout = 10 * time.Second
+
+	// defaultMetadataWebhookTimeout is used when metadata_webhook_timeout
+	// is not set on the role.
+	defaultMetadataWebhookTimeout = 3 * time.Second
+
+	// requestIDHeader carries the Vault request ID of the login to the
+	// metadata webhook.
+	requestIDHeader = "X-Vault-Request-ID"
+
+	// webhookSignatureHeader carries the HMAC of the payload, in the format
+	// used by GitHub webhooks.
+	webhookSignatureHeader = "X-Hub-Signature-256"
+)
+
+// webhookPayload is the body POSTed to a role's oidc_webhook_url.
+type webhookPayload struct {
+	Sub      string            `json:"sub"`
+	Role     string            `json:"role"`
+	Metadata map[string]string `json:"metadata"`
+	IssuedAt int64             `json:"issued_at"`
+}
+
+// sendLoginWebhook notifies the role's webhook URL of a successful login in
+// the background. Failures are logged and don't affect the login.
+func (b *jwtAuthBackend) sendLoginWebhook(config *jwtConfig, role *jwtRole, roleName string, allClaims map[string]interface{}, auth *logical.Auth) {
+	if role.WebhookURL == "" {
+		return
+	}
+
+	sub, _ := allClaims["sub"].(string)
+	body, err := json.Marshal(webhookPayload{
+		Sub:      sub,
+		Role:     roleName,
+		Metadata: auth.Metadata,
+		IssuedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		b.Logger().Warn("error encoding login webhook payload", "error", err)
+		return
+	}
+
+	client, err := buildHTTPClient(config)
+	if err != nil {
+		b.Logger().Warn("error building login webhook client", "error", err)
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(b.providerCtx, webhookTimeout)
+		defer cancel()
+
+		if err := postWebhook(ctx, client, role.WebhookURL, role.WebhookSecret, body); err != nil {
+			b.Logger().Warn("login webhook failed", "url", role.WebhookURL, "role", roleName, "error", err)
+		}
+	}()
+}
+
+// postWebhook POSTs body to webhookURL, signed with secret if it is set.
+func postWebhook(ctx context.Context, client *http.Client, webhookURL, secret string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(webhookSignatureHeader, webhookSignature(secret, body))
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// webhookSignature returns the value of the signature header for body.
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// metadataWebhookTimeout returns the timeout of the metadata webhook request.
+func (r *jwtRole) metadataWebhookTimeout() time.Duration {
+	if r.MetadataWebhookTimeout <= 0 {
+		return defaultMetadataWebhookTimeout
+	}
+	return r.MetadataWebhookTimeout
+}
+
+// addWebhookMetadata POSTs the token metadata to the role's
+// metadata_webhook_url and adds the returned keys to it. Keys that are
+// already set are kept. Failures only fail the login with
+// metadata_webhook_required.
+func (b *jwtAuthBackend) addWebhookMetadata(ctx context.Context, config *jwtConfig, role *jwtRole, roleName, requestID string, metadata map[string]string) error {
+	if role.MetadataWebhookURL == "" {
+		return nil
+	}
+
+	extra, err := fetchWebhookMetadata(ctx, config, role, requestID, metadata)
+	if err != nil {
+		if role.MetadataWebhookRequired {
+			return fmt.Errorf("metadata webhook failed: %s", err)
+		}
+		b.Logger().Warn("metadata webhook failed", "url", role.MetadataWebhookURL, "role", roleName, "error", err)
+		return nil
+	}
+
+	for k, v := range extra {
+		if _, ok := metadata[k]; ok {
+			continue
+		}
+		metadata[k] = v
+	}
+
+	return nil
+}
+
+func fetchWebhookMetadata(ctx context.Context, config *jwtConfig, role *jwtRole, requestID string, metadata map[string]string) (map[string]string, error) {
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := buildHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, role.metadataWebhookTimeout())
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, role.MetadataWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(requestIDHeader, requestID)
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var extra map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&extra); err != nil {
+		return nil, fmt.Errorf("error decoding response: %s", err)
+	}
+
+	return extra, nil
+}
@@ -0,0 +1,238 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestLogin_Webhook(t *testing.T) {
+	type delivery struct {
+		body      []byte
+		signature string
+	}
+	deliveries := make(chan delivery, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		deliveries <- delivery{body, r.Header.Get(webhookSignatureHeader)}
+	}))
+	defer srv.Close()
+
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		roleData: map[string]interface{}{
+			"oidc_webhook_url":    srv.URL,
+			"oidc_webhook_secret": "s3cr3t",
+		},
+	})
+	req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected successful login, got: %v", resp)
+	}
+
+	var d delivery
+	select {
+	case d = <-deliveries:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+
+	if d.signature != webhookSignature("s3cr3t", d.body) {
+		t.Fatalf("unexpected signature: %q", d.signature)
+	}
+
+	var payload webhookPayload
+	if err := json.Unmarshal(d.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Sub != "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6@clients" || payload.Role != "plugin-test" || payload.Metadata["role"] != "plugin-test" || payload.IssuedAt == 0 {
+		t.Fatalf("unexpected payload: %s", d.body)
+	}
+}
+
+func TestLogin_AliasLookahead_Webhooks(t *testing.T) {
+	var loginHits, metadataHits, engineHits int32
+	loginSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&loginHits, 1)
+	}))
+	defer loginSrv.Close()
+	metadataSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&metadataHits, 1)
+		w.Write([]byte(`{"team": "payments"}`))
+	}))
+	defer metadataSrv.Close()
+	engineSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&engineHits, 1)
+		w.Write([]byte(`{"policies": ["engine"]}`))
+	}))
+	defer engineSrv.Close()
+
+	b, storage := setupBackend(t, testConfig{
+		audience: true,
+		roleData: map[string]interface{}{
+			"oidc_webhook_url":       loginSrv.URL,
+			"metadata_webhook_url":   metadataSrv.URL,
+			"oidc_policy_engine_url": engineSrv.URL,
+			"oidc_track_token_ips":   true,
+		},
+	})
+	req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+
+	// Vault sends an alias lookahead before the login, which only returns
+	// the entity alias.
+	lookahead := *req
+	lookahead.Operation = logical.AliasLookaheadOperation
+	resp, err := b.HandleRequest(context.Background(), &lookahead)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%v", err, resp)
+	}
+	if resp.Auth == nil || resp.Auth.Alias == nil || resp.Auth.Alias.Name != "foobar" {
+		t.Fatalf("expected the entity alias, got: %#v", resp.Auth)
+	}
+	keys, err := storage.List(context.Background(), tokenIPsPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("expected the lookahead not to track the token IP, got: %v", keys)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%v", err, resp)
+	}
+
+	// The login webhook is sent in the background.
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&loginHits) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	for name, hits := range map[string]*int32{"oidc_webhook_url": &loginHits, "metadata_webhook_url": &metadataHits, "oidc_policy_engine_url": &engineHits} {
+		if n := atomic.LoadInt32(hits); n != 1 {
+			t.Fatalf("expected %s to be called once per login, got %d", name, n)
+		}
+	}
+}
+
+func TestLogin_MetadataWebhook(t *testing.T) {
+	// Each login gets its own server, which is closed once the handler
+	// returned, so that handlers of timed out requests don't outlive it.
+	login := func(t *testing.T, required bool, delay time.Duration, status int) (*logical.Response, error) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if id := r.Header.Get(requestIDHeader); id != "req-1234" {
+				t.Errorf("unexpected request ID %q", id)
+			}
+
+			var metadata map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil || metadata["role"] != "plugin-test" {
+				t.Errorf("unexpected metadata: %v (%v)", metadata, err)
+			}
+
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+			if status != 0 {
+				w.WriteHeader(status)
+				return
+			}
+			w.Write([]byte(`{"team": "payments", "cost_center": "cc-42", "role": "admin"}`))
+		}))
+		defer srv.Close()
+
+		b, storage := setupBackend(t, testConfig{
+			audience: true,
+			roleData: map[string]interface{}{
+				"metadata_webhook_url":      srv.URL,
+				"metadata_webhook_timeout":  "1s",
+				"metadata_webhook_required": required,
+			},
+		})
+		req := setupLogin(t, time.Now(), time.Now().Add(time.Hour), time.Time{}, b, storage)
+		req.ID = "req-1234"
+		return b.HandleRequest(context.Background(), req)
+	}
+
+	t.Run("merged", func(t *testing.T) {
+		resp, err := login(t, true, 0, 0)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%v resp:%v", err, resp)
+		}
+
+		// Existing keys are kept.
+		metadata := resp.Auth.Metadata
+		if metadata["team"] != "payments" || metadata["cost_center"] != "cc-42" || metadata["role"] != "plugin-test" {
+			t.Fatalf("unexpected metadata: %v", metadata)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		resp, err := login(t, true, 2*time.Second, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || !resp.IsError() {
+			t.Fatalf("expected error, got: %v", resp)
+		}
+	})
+
+	t.Run("not required", func(t *testing.T) {
+		for _, d := range []time.Duration{0, 2 * time.Second} {
+			status := 0
+			if d == 0 {
+				status = http.StatusInternalServerError
+			}
+			resp, err := login(t, false, d, status)
+			if err != nil || resp == nil || resp.IsError() {
+				t.Fatalf("err:%v resp:%v", err, resp)
+			}
+			if _, ok := resp.Auth.Metadata["team"]; ok {
+				t.Fatalf("unexpected metadata: %v", resp.Auth.Metadata)
+			}
+		}
+	})
+
+	t.Run("invalid url", func(t *testing.T) {
+		b, storage := getBackend(t)
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/test",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role_type":            "jwt",
+				"user_claim":           "sub",
+				"bound_subject":        "test",
+				"metadata_webhook_url": "ftp://example.com",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || !resp.IsError() {
+			t.Fatal("expected error for invalid metadata_webhook_url")
+		}
+	})
+}
+
+func TestWebhookSignature(t *testing.T) {
+	// Example from GitHub's webhook validation documentation.
+	expected := "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+	if sig := webhookSignature("It's a Secret to Everybody", []byte("Hello, World!")); sig != expected {
+		t.Fatalf("expected %q, got %q", expected, sig)
+	}
+}